@@ -2,6 +2,7 @@ package cli
 
 import (
 	"fmt"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/zrepl/zrepl/config"
@@ -10,6 +11,63 @@ import (
 
 var rootArgs struct {
 	configPath string
+	quiet      bool
+}
+
+// Exit codes of zrepl subcommands.
+// They are part of the CLI's stable interface: scripts and monitoring may rely on them.
+const (
+	ExitOK = 0
+	// The command failed for a reason not covered by the other exit codes.
+	ExitFailed = 1
+	// Invalid flags or arguments.
+	ExitUsage = 2
+	// The daemon's control socket could not be reached.
+	ExitDaemonUnreachable = 3
+	// At least one job failed permanently (see `zrepl status --check`).
+	ExitJobFailed = 4
+	// No job failed, but at least one encountered a problem that may resolve itself.
+	ExitDegraded = 5
+)
+
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+
+func (e *exitError) Cause() error { return e.err }
+
+// WithExitCode makes the subcommand exit with code if it returns err (or an error wrapping it).
+// Returns nil if err is nil.
+func WithExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code, err}
+}
+
+func UsageError(format string, args ...interface{}) error {
+	return WithExitCode(ExitUsage, errors.Errorf(format, args...))
+}
+
+// ExitCode returns the exit code for an error returned by a subcommand.
+func ExitCode(err error) int {
+	for err != nil {
+		if e, ok := err.(*exitError); ok {
+			return e.code
+		}
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+	if err != nil {
+		return ExitFailed
+	}
+	return ExitOK
 }
 
 var rootCmd = &cobra.Command{
@@ -24,11 +82,11 @@ var bashcompCmd = &cobra.Command{
 		if len(args) != 1 {
 			fmt.Fprintf(os.Stderr, "specify exactly one positional agument\n")
 			cmd.Usage()
-			os.Exit(1)
+			os.Exit(ExitUsage)
 		}
 		if err := rootCmd.GenBashCompletionFile(args[0]); err != nil {
 			fmt.Fprintf(os.Stderr, "error generating bash completion: %s", err)
-			os.Exit(1)
+			os.Exit(ExitFailed)
 		}
 	},
 	Hidden: true,
@@ -36,6 +94,7 @@ var bashcompCmd = &cobra.Command{
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(&rootArgs.configPath, "config", "", "config file path")
+	rootCmd.PersistentFlags().BoolVarP(&rootArgs.quiet, "quiet", "q", false, "suppress all output, only set the exit code")
 	rootCmd.AddCommand(bashcompCmd)
 }
 
@@ -56,6 +115,18 @@ func (s *Subcommand) ConfigParsingError() error {
 	return s.configErr
 }
 
+// ConfigPath returns the config file path passed on the command line, or "" for the default locations.
+func (s *Subcommand) ConfigPath() string {
+	return rootArgs.configPath
+}
+
+// Quiet reports whether --quiet was passed.
+// Output to os.Stdout and os.Stderr is discarded in that case, but subcommands that draw
+// to the terminal directly must check it themselves.
+func (s *Subcommand) Quiet() bool {
+	return rootArgs.quiet
+}
+
 func (s *Subcommand) Config() *config.Config {
 	if !s.NoRequireConfig && s.config == nil {
 		panic("command that requires config is running and has no config set")
@@ -64,11 +135,16 @@ func (s *Subcommand) Config() *config.Config {
 }
 
 func (s *Subcommand) run(cmd *cobra.Command, args []string) {
+	if rootArgs.quiet {
+		if devnull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+			os.Stdout, os.Stderr = devnull, devnull
+		}
+	}
 	s.tryParseConfig()
 	err := s.Run(s, args)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
-		os.Exit(1)
+		os.Exit(ExitCode(err))
 	}
 }
 
@@ -81,7 +157,12 @@ func (s *Subcommand) tryParseConfig() {
 			return
 		} else {
 			fmt.Fprintf(os.Stderr, "could not parse config: %s\n", err)
-			os.Exit(1)
+			os.Exit(ExitFailed)
+		}
+	}
+	if !s.NoRequireConfig {
+		for _, w := range config.MigrationWarnings {
+			fmt.Fprintf(os.Stderr, "config uses deprecated format (run 'zrepl migrate config'): %s\n", w)
 		}
 	}
 	s.config = config
@@ -112,7 +193,8 @@ func addSubcommandToCobraCmd(c *cobra.Command, s *Subcommand) {
 
 
 func Run() {
+	// errors returned by Execute are about the command line, errors of subcommands exit in Subcommand.run
 	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+		os.Exit(ExitUsage)
 	}
 }
@@ -0,0 +1,20 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, ExitOK, ExitCode(nil))
+	assert.Equal(t, ExitFailed, ExitCode(fmt.Errorf("some error")))
+	assert.Equal(t, ExitUsage, ExitCode(UsageError("expected %d arguments", 2)))
+	assert.Nil(t, WithExitCode(ExitDegraded, nil))
+
+	unreachable := WithExitCode(ExitDaemonUnreachable, fmt.Errorf("connection refused"))
+	assert.Equal(t, "connection refused", unreachable.Error())
+	assert.Equal(t, ExitDaemonUnreachable, ExitCode(unreachable))
+	assert.Equal(t, ExitDaemonUnreachable, ExitCode(errors.Wrap(unreachable, "server: error")))
+}
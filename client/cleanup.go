@@ -0,0 +1,43 @@
+package client
+
+import (
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/zrepl/zrepl/cli"
+	"github.com/zrepl/zrepl/daemon/maintenance"
+)
+
+var cleanupArgs struct {
+	dryRun bool
+}
+
+var CleanupCmd = &cli.Subcommand{
+	Use:   "cleanup [--dry-run]",
+	Short: "remove zrepl bookmarks that no job of the config references anymore",
+	SetupFlags: func(f *pflag.FlagSet) {
+		f.BoolVar(&cleanupArgs.dryRun, "dry-run", false, "only list the stale bookmarks")
+	},
+	Run: runCleanupCmd,
+}
+
+func runCleanupCmd(subcommand *cli.Subcommand, args []string) error {
+	fsfs, err := maintenance.SenderFilters(subcommand.Config().Jobs)
+	if err != nil {
+		return err
+	}
+	stale, err := maintenance.FindStale(fsfs)
+	if err != nil {
+		return err
+	}
+	if cleanupArgs.dryRun {
+		for _, s := range stale {
+			fmt.Printf("would destroy %s\n", s)
+		}
+		return nil
+	}
+	removed, err := maintenance.Remove(stale)
+	for _, s := range removed {
+		fmt.Printf("destroyed %s\n", s)
+	}
+	return err
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/zrepl/yaml-config"
 	"github.com/zrepl/zrepl/cli"
 	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon"
 	"github.com/zrepl/zrepl/daemon/job"
 	"github.com/zrepl/zrepl/daemon/logging"
 	"github.com/zrepl/zrepl/logger"
@@ -41,7 +42,7 @@ var ConfigcheckCmd = &cli.Subcommand{
 
 		formatter, ok := formatMap[configcheckArgs.format]
 		if !ok {
-			return fmt.Errorf("unsupported --format %q", configcheckArgs.format)
+			return cli.UsageError("unsupported --format %q", configcheckArgs.format)
 		}
 
 		var hadErr bool
@@ -57,9 +58,29 @@ var ConfigcheckCmd = &cli.Subcommand{
 				hadErr = true
 			}
 		}
+		if err := daemon.CheckJobNames(confJobs); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			hadErr = true
+		}
+
+		// the jobs the daemon builds from the global section: monitoring and maintenance
+		if _, err := daemon.GlobalJobsFromConfig(subcommand.Config()); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			hadErr = true
+		}
+
+		if _, err := daemon.ZFSExecConfigFromConfig(subcommand.Config().Global.ZFS); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			hadErr = true
+		}
+
+		if _, err := daemon.PprofListenFromConfig(subcommand.Config().Global.Serve); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			hadErr = true
+		}
 
 		// further: try to build logging outlets
-		outlets, err := logging.OutletsFromConfig(*subcommand.Config().Global.Logging)
+		outlets, err := logging.OutletsFromConfig(*subcommand.Config().Global.Logging, subcommand.Config().Jobs)
 		if err != nil {
 			err := errors.Wrap(err, "cannot build logging from config")
 			if configcheckArgs.what == "logging" {
@@ -98,7 +119,7 @@ var ConfigcheckCmd = &cli.Subcommand{
 
 		wf, ok := whatMap[configcheckArgs.what]
 		if !ok {
-			return fmt.Errorf("unsupported --format %q", configcheckArgs.what)
+			return cli.UsageError("unsupported --what %q", configcheckArgs.what)
 		}
 		wf()
 
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/zrepl/zrepl/cli"
+	"github.com/zrepl/zrepl/daemon"
+	"github.com/zrepl/zrepl/daemon/job/journal"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var DebugCmd = &cli.Subcommand{
+	Use:   "debug",
+	Short: "inspect the debugging aids of the daemon",
+	SetupSubcommands: func() []*cli.Subcommand {
+		return []*cli.Subcommand{debugJournal}
+	},
+}
+
+var debugJournalArgs struct {
+	json bool
+}
+
+var debugJournal = &cli.Subcommand{
+	Use:   "journal [--json] JOB",
+	Short: "dump the debug journal of JOB, oldest entries first",
+	SetupFlags: func(f *pflag.FlagSet) {
+		f.BoolVar(&debugJournalArgs.json, "json", false, "print one JSON object per entry")
+	},
+	Run: runDebugJournal,
+}
+
+func runDebugJournal(subcommand *cli.Subcommand, args []string) error {
+	if len(args) != 1 {
+		return errors.Errorf("exactly one argument required: the name of the job")
+	}
+	journals, err := daemon.JobJournals(subcommand.Config().Jobs)
+	if err != nil {
+		return err
+	}
+	jc, ok := journals[args[0]]
+	if !ok {
+		return errors.Errorf("job %q does not exist or does not enable the debug journal", args[0])
+	}
+	enc := json.NewEncoder(os.Stdout)
+	return journal.ReadRecords(jc.Path, func(r journal.Record) error {
+		if debugJournalArgs.json {
+			return enc.Encode(r)
+		}
+		_, err := fmt.Println(formatJournalRecord(r))
+		return err
+	})
+}
+
+func formatJournalRecord(r journal.Record) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s [%s]: %s", r.Time.Format(time.RFC3339Nano), r.Level, r.Message)
+	keys := make([]string, 0, len(r.Fields))
+	for k := range r.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%v", k, r.Fields[k])
+	}
+	return b.String()
+}
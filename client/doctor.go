@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/zrepl/zrepl/cli"
+	"github.com/zrepl/zrepl/daemon/job"
+	"github.com/zrepl/zrepl/zfs"
+)
+
+var doctorArgs struct {
+	job string
+}
+
+var DoctorCmd = &cli.Subcommand{
+	Use:   "doctor [--job JOB]",
+	Short: "check that the daemon's user has the delegated zfs permissions (zfs allow) the jobs need",
+	SetupFlags: func(f *pflag.FlagSet) {
+		f.StringVar(&doctorArgs.job, "job", "", "only check job JOB")
+	},
+	Run: runDoctor,
+}
+
+func runDoctor(subcommand *cli.Subcommand, args []string) error {
+	if len(args) != 0 {
+		return cli.UsageError("doctor takes no positional arguments")
+	}
+	jobs, err := job.JobsFromConfig(subcommand.Config())
+	if err != nil {
+		return errors.Wrap(err, "cannot build jobs from config")
+	}
+
+	found, problems := false, 0
+
+	platform := zfs.DetectPlatform()
+	if features, err := zfs.ProbePlatform(context.Background(), platform); err != nil {
+		fmt.Printf("zfs: cannot probe features of platform %s: %s\n", platform.Name(), err)
+	} else {
+		fmt.Printf("zfs: %s\n", features)
+	}
+
+	for _, j := range jobs {
+		if doctorArgs.job == "" || j.Name() == doctorArgs.job {
+			found = true
+		}
+	}
+	for _, r := range job.Preflight(jobs, doctorArgs.job) {
+		if r.Err != nil {
+			problems++
+			fmt.Printf("%s: cannot check permissions: %s\n", r.Job, r.Err)
+			continue
+		}
+		if len(r.Missing) == 0 {
+			fmt.Printf("%s: OK\n", r.Job)
+			continue
+		}
+		problems++
+		fmt.Printf("%s: missing delegated permissions:\n", r.Job)
+		for _, m := range r.Missing {
+			fmt.Printf("    %s    # %s\n", m.GrantCommand(), m.Reason)
+		}
+	}
+	if doctorArgs.job != "" && !found {
+		return cli.UsageError("job %q not defined in config", doctorArgs.job)
+	}
+	if problems > 0 {
+		return errors.Errorf("%d job(s) lack permissions or could not be checked", problems)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/zrepl/zrepl/cli"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/job"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+var failbackArgs struct {
+	receiverRoot string
+	check        bool
+}
+
+var FailbackCmd = &cli.Subcommand{
+	Use:   "failback JOB [--receiver-root ROOT_FS/CLIENT_IDENTITY] [--check]",
+	Short: "check that the filesystems of push or pull job JOB can be replicated back from its receiver, and print the jobs that do it",
+	SetupFlags: func(f *pflag.FlagSet) {
+		f.StringVar(&failbackArgs.receiverRoot, "receiver-root", "", "for push jobs: the root_fs/CLIENT_IDENTITY below which the sink receives")
+		f.BoolVar(&failbackArgs.check, "check", false, "only check, do not print the jobs")
+	},
+	Run: func(subcommand *cli.Subcommand, args []string) error {
+		return runFailbackCmd(subcommand.Config(), args)
+	},
+}
+
+func runFailbackCmd(conf *config.Config, args []string) error {
+	if len(args) != 1 {
+		return cli.UsageError("Expected 1 argument: JOB")
+	}
+	jobConf, err := conf.Job(args[0])
+	if err != nil {
+		return err
+	}
+	var connect config.ConnectEnum
+	switch j := jobConf.Ret.(type) {
+	case *config.PushJob:
+		connect = j.Connect
+	case *config.PullJob:
+		connect = j.Connect
+	default:
+		return errors.Errorf("job type %T does not replicate", j)
+	}
+	if _, ok := connect.Ret.(*config.LocalConnect); ok {
+		return errors.New("local transport is only available within the daemon")
+	}
+	j, err := job.BuildJob(conf.Global, *jobConf)
+	if err != nil {
+		return err
+	}
+	active, ok := j.(*job.ActiveSide)
+	if !ok {
+		panic(fmt.Sprintf("implementation error: job %q is not an active side: %T", args[0], j))
+	}
+
+	plan, err := active.PlanFailback(context.Background(), failbackArgs.receiverRoot)
+	if err != nil {
+		return err
+	}
+	for _, f := range plan.Filesystems {
+		switch {
+		case !f.OnReceiver:
+			fmt.Printf("%s: not on receiver, skipped\n", f.Filesystem)
+		case f.Problem != "":
+			fmt.Printf("%s: refused: %s\n", f.Filesystem, f.Problem)
+		case len(f.Snapshots) == 0:
+			fmt.Printf("%s: %s is up to date\n", f.Filesystem, f.ReceiverName)
+		default:
+			fmt.Printf("%s: %s has %d snapshots after %s\n", f.Filesystem, f.ReceiverName, len(f.Snapshots), f.Common.RelName())
+		}
+	}
+	if len(plan.Refused) > 0 {
+		fmt.Println()
+		for _, r := range plan.Refused {
+			fmt.Printf("refused: %s\n", r)
+		}
+		return errors.Errorf("job %q cannot fail back", args[0])
+	}
+	var fss []string
+	for _, f := range plan.Filesystems {
+		if f.OnReceiver {
+			fss = append(fss, f.ReceiverName)
+		}
+	}
+	if len(fss) == 0 {
+		return errors.Errorf("the receiver of job %q has none of its filesystems", args[0])
+	}
+	if failbackArgs.check {
+		return nil
+	}
+	fmt.Println()
+	printFailbackJobs(os.Stdout, args[0], plan, fss)
+	return nil
+}
+
+// printFailbackJobs prints a source job for the receiver and a pull job for the sender of job jobName,
+// which replicate the filesystems of plan back under their original names.
+// The transport sections are left for the user to fill in.
+func printFailbackJobs(w io.Writer, jobName string, plan *job.FailbackPlan, fss []string) {
+	// receives nothing, all filesystems are matched by root_fs_mapping
+	rootFS := path.Join(strings.SplitN(plan.RootFSMapping[0].RootFS, "/", 2)[0], "zrepl_failback")
+
+	fmt.Fprintf(w, "# On the receiver of job %q: serve the received filesystems to the original sender.\n", jobName)
+	fmt.Fprintf(w, "jobs:\n")
+	fmt.Fprintf(w, "- name: %s_failback_source\n", jobName)
+	fmt.Fprintf(w, "  type: source\n")
+	fmt.Fprintf(w, "  serve:\n")
+	fmt.Fprintf(w, "    # the transport through which the pull job below connects\n")
+	fmt.Fprintf(w, "  filesystems:\n")
+	for _, fs := range fss {
+		fmt.Fprintf(w, "    %q: true\n", fs)
+	}
+	fmt.Fprintf(w, "  snapshotting:\n")
+	fmt.Fprintf(w, "    type: manual\n")
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "# On the original sender, after job %q was removed on both sides:\n", jobName)
+	fmt.Fprintf(w, "# pull the filesystems back under their original names.\n")
+	fmt.Fprintf(w, "jobs:\n")
+	fmt.Fprintf(w, "- name: %s_failback\n", jobName)
+	fmt.Fprintf(w, "  type: pull\n")
+	fmt.Fprintf(w, "  connect:\n")
+	fmt.Fprintf(w, "    # the transport to the serve section of job %s_failback_source\n", jobName)
+	fmt.Fprintf(w, "  root_fs: %q\n", rootFS)
+	fmt.Fprintf(w, "  root_fs_mapping:\n")
+	for _, r := range plan.RootFSMapping {
+		fmt.Fprintf(w, "  - sender: %q\n", r.Sender)
+		fmt.Fprintf(w, "    root_fs: %q\n", r.RootFS)
+	}
+	fmt.Fprintf(w, "  interval: 10m\n")
+	fmt.Fprintf(w, "  pruning:\n")
+	for _, side := range []string{"keep_sender", "keep_receiver"} {
+		fmt.Fprintf(w, "    %s:\n", side)
+		fmt.Fprintf(w, "    - type: regex\n")
+		fmt.Fprintf(w, "      regex: \".*\"\n")
+	}
+}
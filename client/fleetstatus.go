@@ -0,0 +1,184 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/zrepl/zrepl/cli"
+	"github.com/zrepl/zrepl/daemon"
+	"github.com/zrepl/zrepl/daemon/job"
+	"github.com/zrepl/zrepl/daemon/job/lastsuccess"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+var fleetStatusFlags struct {
+	Timeout time.Duration
+}
+
+var FleetStatusCmd = &cli.Subcommand{
+	Use:             "fleet-status HOSTS_FILE",
+	Short:           "query the control sockets of multiple daemons and print a combined status table",
+	NoRequireConfig: true,
+	SetupFlags: func(f *pflag.FlagSet) {
+		f.DurationVar(&fleetStatusFlags.Timeout, "timeout", 10*time.Second, "timeout for querying a single daemon")
+	},
+	Run: runFleetStatus,
+}
+
+// fleetHost is a line of the hosts file: a name and the address of the daemon's control socket.
+type fleetHost struct {
+	Name, Address string
+}
+
+// parseFleetHosts parses lines of the form `NAME ADDRESS`, ignoring empty lines and comments starting with #.
+func parseFleetHosts(path string) ([]fleetHost, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hosts []fleetHost
+	s := bufio.NewScanner(f)
+	for lineNo := 1; s.Scan(); lineNo++ {
+		line := strings.TrimSpace(s.Text())
+		if i := strings.Index(line, "#"); i != -1 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, errors.Errorf("%s:%d: expected `NAME ADDRESS`, got %q", path, lineNo, line)
+		}
+		hosts = append(hosts, fleetHost{Name: fields[0], Address: fields[1]})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return hosts, nil
+}
+
+// fleetHttpClient dials address as a unix socket if it is a path, and as TCP host:port otherwise,
+// e.g. for a control socket forwarded with `ssh -L`.
+func fleetHttpClient(address string, timeout time.Duration) http.Client {
+	network := "tcp"
+	if strings.HasPrefix(address, "/") {
+		network = "unix"
+	}
+	return http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, address)
+			},
+		},
+		Timeout: timeout,
+	}
+}
+
+type fleetHostStatus struct {
+	host fleetHost
+	jobs map[string]job.Status
+	err  error
+}
+
+func queryFleet(hosts []fleetHost, timeout time.Duration) []fleetHostStatus {
+	res := make([]fleetHostStatus, len(hosts))
+	var wg sync.WaitGroup
+	for i := range hosts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res[i].host = hosts[i]
+			res[i].jobs = make(map[string]job.Status)
+			httpc := fleetHttpClient(hosts[i].Address, timeout)
+			res[i].err = jsonRequestResponse(httpc, daemon.ControlJobEndpointStatus, struct{}{}, &res[i].jobs)
+		}(i)
+	}
+	wg.Wait()
+	return res
+}
+
+// fleetLag returns the time since the job last replicated (active jobs) or snapshotted (source jobs).
+func fleetLag(s *job.Status) string {
+	var r *lastsuccess.Report
+	phase := lastsuccess.Replication
+	switch st := s.JobSpecific.(type) {
+	case *job.ActiveSideStatus:
+		r = st.LastSuccess
+	case *job.PassiveStatus:
+		r = st.LastSuccess
+		phase = lastsuccess.Snapshot
+	}
+	if r == nil {
+		return "-"
+	}
+	at, ok := r.Job[phase]
+	if !ok {
+		return "never"
+	}
+	return time.Now().Sub(at).Round(time.Second).String()
+}
+
+func runFleetStatus(s *cli.Subcommand, args []string) error {
+	if len(args) != 1 {
+		return cli.UsageError("specify exactly one hosts file")
+	}
+	hosts, err := parseFleetHosts(args[0])
+	if err != nil {
+		return err
+	}
+	if len(hosts) == 0 {
+		return cli.UsageError("hosts file %q does not list any hosts", args[0])
+	}
+
+	statuses := queryFleet(hosts, fleetStatusFlags.Timeout)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tJOB\tTYPE\tHEALTH\tLAG\tPROBLEMS")
+	worst := jobHealthOK
+	unreachable := 0
+	for _, hs := range statuses {
+		if hs.err != nil {
+			unreachable++
+			fmt.Fprintf(w, "%s\t-\t-\tUNREACHABLE\t-\t%s\n", hs.host.Name, hs.err)
+			continue
+		}
+		names := make([]string, 0, len(hs.jobs))
+		for name := range hs.jobs {
+			if len(name) == 0 || daemon.IsInternalJobName(name) {
+				continue
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			st := hs.jobs[name]
+			c := checkJob(&st)
+			if c.health > worst {
+				worst = c.health
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", hs.host.Name, name, st.Type, c.health, fleetLag(&st), strings.Join(c.problems, "; "))
+		}
+	}
+	w.Flush()
+
+	if unreachable > 0 {
+		return cli.WithExitCode(cli.ExitDaemonUnreachable, errors.Errorf("%d of %d daemons unreachable", unreachable, len(hosts)))
+	}
+	if worst != jobHealthOK {
+		return cli.WithExitCode(worst.exitCode(), fmt.Errorf("fleet status: %s", worst))
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/daemon"
+	"github.com/zrepl/zrepl/daemon/job"
+	"github.com/zrepl/zrepl/daemon/job/lastsuccess"
+)
+
+func writeHostsFile(t *testing.T, content string) (path string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "zrepl-fleet")
+	require.NoError(t, err)
+	path = filepath.Join(dir, "hosts")
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestParseFleetHosts(t *testing.T) {
+	path, cleanup := writeHostsFile(t, `
+# production
+db1 /var/run/zrepl/control   # local socket
+db2 127.0.0.1:9000
+
+`)
+	defer cleanup()
+	hosts, err := parseFleetHosts(path)
+	require.NoError(t, err)
+	assert.Equal(t, []fleetHost{
+		{Name: "db1", Address: "/var/run/zrepl/control"},
+		{Name: "db2", Address: "127.0.0.1:9000"},
+	}, hosts)
+
+	path, cleanup = writeHostsFile(t, "db1 /var/run/zrepl/control\ndb2\n")
+	defer cleanup()
+	_, err = parseFleetHosts(path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), ":2:")
+}
+
+func TestQueryFleet(t *testing.T) {
+	snapshotted := time.Now().Add(-time.Hour)
+	statuses := map[string]*job.Status{
+		"src": {
+			Type: job.TypeSource,
+			JobSpecific: &job.PassiveStatus{
+				LastSuccess: &lastsuccess.Report{Job: map[lastsuccess.Phase]time.Time{lastsuccess.Snapshot: snapshotted}},
+			},
+		},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != daemon.ControlJobEndpointStatus {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(statuses)
+	}))
+	defer srv.Close()
+
+	// an address on which nobody listens
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unreachable := l.Addr().String()
+	l.Close()
+
+	res := queryFleet([]fleetHost{
+		{Name: "up", Address: srv.Listener.Addr().String()},
+		{Name: "down", Address: unreachable},
+	}, time.Second)
+	require.Len(t, res, 2)
+
+	assert.Equal(t, "up", res[0].host.Name)
+	require.NoError(t, res[0].err)
+	require.Contains(t, res[0].jobs, "src")
+	st := res[0].jobs["src"]
+	assert.Equal(t, job.TypeSource, st.Type)
+	lag, err := time.ParseDuration(fleetLag(&st))
+	require.NoError(t, err)
+	assert.True(t, lag >= time.Hour && lag < time.Hour+time.Minute, "lag %s", lag)
+
+	assert.Equal(t, "down", res[1].host.Name)
+	assert.Error(t, res[1].err)
+}
+
+func TestFleetLag(t *testing.T) {
+	assert.Equal(t, "-", fleetLag(&job.Status{Type: job.TypePush, JobSpecific: &job.ActiveSideStatus{}}))
+	never := &job.Status{Type: job.TypePush, JobSpecific: &job.ActiveSideStatus{
+		LastSuccess: &lastsuccess.Report{Job: map[lastsuccess.Phase]time.Time{lastsuccess.Snapshot: time.Now()}},
+	}}
+	assert.Equal(t, "never", fleetLag(never), "active jobs report the lag of replication, not snapshotting")
+}
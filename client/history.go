@@ -0,0 +1,85 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/zrepl/zrepl/cli"
+	"github.com/zrepl/zrepl/daemon"
+	"github.com/zrepl/zrepl/daemon/job/history"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var historyArgs struct {
+	json bool
+}
+
+var HistoryCmd = &cli.Subcommand{
+	Use:   "history [--json] [JOB]",
+	Short: "show the outcomes of the most recent invocations of JOB or of all jobs, as recorded by the running daemon",
+	SetupFlags: func(f *pflag.FlagSet) {
+		f.BoolVar(&historyArgs.json, "json", false, "print the invocations as JSON")
+	},
+	Run: runHistoryCmd,
+}
+
+func runHistoryCmd(subcommand *cli.Subcommand, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("at most one argument allowed: the name of the job")
+	}
+	req := daemon.HistoryRequest{}
+	if len(args) == 1 {
+		req.Job = args[0]
+	}
+	httpc, err := controlHttpClient(subcommand.Config().Global.Control.SockPath)
+	if err != nil {
+		return err
+	}
+	var res map[string][]history.Invocation
+	if err := jsonRequestResponse(httpc, daemon.ControlJobEndpointHistory, req, &res); err != nil {
+		return err
+	}
+	if historyArgs.json {
+		return json.NewEncoder(os.Stdout).Encode(res)
+	}
+	names := make([]string, 0, len(res))
+	for name := range res {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s\n", name)
+		if len(res[name]) == 0 {
+			fmt.Printf("    no invocations yet\n")
+		}
+		for _, inv := range res[name] {
+			printInvocation(inv)
+		}
+	}
+	return nil
+}
+
+func printInvocation(inv history.Invocation) {
+	var details []string
+	if inv.BytesReplicated > 0 {
+		details = append(details, ByteCountBinary(inv.BytesReplicated)+" replicated")
+	}
+	if inv.SnapshotsCreated > 0 {
+		details = append(details, fmt.Sprintf("%d snapshots created", inv.SnapshotsCreated))
+	}
+	if inv.SnapshotsDestroyed > 0 {
+		details = append(details, fmt.Sprintf("%d destroyed", inv.SnapshotsDestroyed))
+	}
+	fmt.Printf("    %s  %-9s  %8s  %s  [%s]\n",
+		inv.Start.Format(time.RFC3339), inv.Outcome, inv.End.Sub(inv.Start).Round(time.Second),
+		strings.Join(details, ", "), inv.ID)
+	for _, e := range inv.Errors {
+		fmt.Printf("        %s\n", e)
+	}
+}
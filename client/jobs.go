@@ -0,0 +1,78 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/zrepl/zrepl/cli"
+	"github.com/zrepl/zrepl/daemon"
+	"github.com/zrepl/zrepl/daemon/job/lastsuccess"
+	"os"
+	"strings"
+	"time"
+)
+
+var jobsArgs struct {
+	json bool
+}
+
+var JobsCmd = &cli.Subcommand{
+	Use:   "jobs [--json]",
+	Short: "list the jobs that the running daemon loaded, with their configuration and the outcome of their last run",
+	SetupFlags: func(f *pflag.FlagSet) {
+		f.BoolVar(&jobsArgs.json, "json", false, "print the job summaries as JSON")
+	},
+	Run: runJobsCmd,
+}
+
+func runJobsCmd(subcommand *cli.Subcommand, args []string) error {
+	httpc, err := controlHttpClient(subcommand.Config().Global.Control.SockPath)
+	if err != nil {
+		return err
+	}
+	var summaries []*daemon.JobSummary
+	if err := jsonRequestResponse(httpc, daemon.ControlJobEndpointJobs, struct{}{}, &summaries); err != nil {
+		return err
+	}
+	if jobsArgs.json {
+		return json.NewEncoder(os.Stdout).Encode(summaries)
+	}
+	for i, s := range summaries {
+		if i > 0 {
+			fmt.Println()
+		}
+		printJobSummary(s)
+	}
+	return nil
+}
+
+func printJobSummary(s *daemon.JobSummary) {
+	line := func(key, value string) {
+		if value != "" {
+			fmt.Printf("    %-20s %s\n", key+":", value)
+		}
+	}
+	fmt.Printf("%s (%s)\n", s.Name, s.Type)
+	line("transport", s.Transport)
+	line("schedule", s.Schedule)
+	line("triggered by", s.TriggeredBy)
+	line("filesystems", strings.Join(s.Filesystems, ", "))
+	line("root_fs", s.RootFS)
+	switch {
+	case s.LastRunError != "":
+		line("last run", fmt.Sprintf("%s: %s", s.LastRunState, s.LastRunError))
+	case s.LastRunState != "":
+		line("last run", s.LastRunState)
+	}
+	phases := []lastsuccess.Phase{
+		lastsuccess.Snapshot,
+		lastsuccess.Replication,
+		lastsuccess.PruneSender,
+		lastsuccess.PruneReceiver,
+	}
+	for _, p := range phases {
+		if at, ok := s.LastSuccess[p]; ok {
+			line("last "+string(p), fmt.Sprintf("%s (%s ago)", at.Format(time.RFC3339), time.Since(at).Round(time.Second)))
+		}
+	}
+}
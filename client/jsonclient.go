@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/pkg/errors"
+	"github.com/zrepl/zrepl/cli"
 	"io"
 	"net"
 	"net/http"
@@ -29,7 +30,7 @@ func jsonRequestResponse(c http.Client, endpoint string, req interface{}, res in
 
 	resp, err := c.Post("http://unix"+endpoint, "application/json", &buf)
 	if err != nil {
-		return err
+		return cli.WithExitCode(cli.ExitDaemonUnreachable, err)
 	}
 	defer resp.Body.Close()
 
@@ -0,0 +1,203 @@
+package client
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/zrepl/zrepl/cli"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/zfs"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var MigrateCmd = &cli.Subcommand{
+	Use:   "migrate",
+	Short: "perform migration of configuration or persistent state",
+	SetupSubcommands: func() []*cli.Subcommand {
+		return []*cli.Subcommand{migrateConfig, migrateState}
+	},
+}
+
+var migrateConfigArgs struct {
+	write bool
+}
+
+var migrateConfig = &cli.Subcommand{
+	Use:             "config [--write]",
+	Short:           "convert a config file of a release before 0.1 to the current format",
+	NoRequireConfig: true,
+	SetupFlags: func(f *pflag.FlagSet) {
+		f.BoolVar(&migrateConfigArgs.write, "write", false, "overwrite the config file instead of printing the result to stdout (comments are lost)")
+	},
+	Run: runMigrateConfig,
+}
+
+func runMigrateConfig(subcommand *cli.Subcommand, args []string) error {
+	path, err := config.ResolveConfigPath(subcommand.ConfigPath())
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		return errors.New("no config file found, use --config")
+	}
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	out, warnings, err := config.MigrateConfigBytes(in)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		fmt.Fprintf(os.Stderr, "%s does not need to be migrated\n", path)
+		return nil
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "WARNING: %s\n", w)
+	}
+	if _, err := config.ParseConfigBytes(out); err != nil {
+		return errors.Wrap(err, "migrated config is invalid")
+	}
+
+	if !migrateConfigArgs.write {
+		_, err := os.Stdout.Write(out)
+		return err
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".bak", in, stat.Mode()); err != nil {
+		return errors.Wrap(err, "cannot back up old config")
+	}
+	if err := ioutil.WriteFile(path, out, stat.Mode()); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "migrated %s, old config saved to %s.bak\n", path, path)
+	return nil
+}
+
+// A stateMigration upgrades state that zrepl persists in ZFS, e.g. in user properties,
+// from the format of another release to the one of this release.
+type stateMigration struct {
+	Name  string
+	Short string
+	// Plan inspects the state on this host and returns the changes the migration makes, none if it is up to date.
+	Plan func() ([]stateMigrationChange, error)
+}
+
+type stateMigrationChange struct {
+	Description string
+	Apply       func() error
+}
+
+// stateMigrations are applied in order. Migrations must be idempotent.
+var stateMigrations = []*stateMigration{
+	{
+		Name:  "placeholder-marks",
+		Short: "convert boolean placeholder marks and those of renamed placeholders to the hash of the filesystem name",
+		Plan:  planPlaceholderMarksMigration,
+	},
+}
+
+func planPlaceholderMarksMigration() ([]stateMigrationChange, error) {
+	marks, err := zfs.ZFSListLocalPlaceholderMarks()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot list placeholder marks")
+	}
+	fixes, err := zfs.OutdatedPlaceholderMarks(marks, func(fs *zfs.DatasetPath) (bool, error) {
+		versions, err := zfs.ZFSListFilesystemVersions(fs, nil)
+		if err != nil {
+			return false, err
+		}
+		for _, v := range versions {
+			if v.Type == zfs.Snapshot {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]stateMigrationChange, len(fixes))
+	for i := range fixes {
+		fix := fixes[i]
+		action := "mark"
+		if !fix.IsPlaceholder {
+			action = "unmark"
+		}
+		changes[i] = stateMigrationChange{
+			Description: fmt.Sprintf("%s %s as placeholder (%s=%q: %s)", action, fix.Filesystem.ToString(), zfs.ZREPL_PLACEHOLDER_PROPERTY_NAME, fix.Old, fix.Reason),
+			Apply: func() error {
+				return zfs.ZFSSetPlaceholder(fix.Filesystem, fix.IsPlaceholder)
+			},
+		}
+	}
+	return changes, nil
+}
+
+var migrateStateArgs struct {
+	dryRun bool
+}
+
+var migrateState = &cli.Subcommand{
+	Use:             "state [--dry-run] [MIGRATION...]",
+	Short:           "upgrade the state zrepl stores in ZFS on this host, runs all migrations if none is given",
+	NoRequireConfig: true,
+	SetupFlags: func(f *pflag.FlagSet) {
+		f.BoolVar(&migrateStateArgs.dryRun, "dry-run", false, "only print the changes")
+	},
+	Run: runMigrateState,
+}
+
+func runMigrateState(subcommand *cli.Subcommand, args []string) error {
+	migrations := stateMigrations
+	if len(args) > 0 {
+		byName := make(map[string]*stateMigration, len(stateMigrations))
+		names := make([]string, len(stateMigrations))
+		for i, m := range stateMigrations {
+			byName[m.Name] = m
+			names[i] = m.Name
+		}
+		migrations = nil
+		for _, a := range args {
+			m, ok := byName[a]
+			if !ok {
+				return cli.UsageError("unknown migration %q, must be one of %s", a, strings.Join(names, ", "))
+			}
+			migrations = append(migrations, m)
+		}
+	}
+
+	failed := false
+	for _, m := range migrations {
+		changes, err := m.Plan()
+		if err != nil {
+			return errors.Wrapf(err, "migration %s", m.Name)
+		}
+		if len(changes) == 0 {
+			fmt.Printf("%s: up to date\n", m.Name)
+			continue
+		}
+		for _, c := range changes {
+			if migrateStateArgs.dryRun {
+				fmt.Printf("%s: would %s\n", m.Name, c.Description)
+				continue
+			}
+			if err := c.Apply(); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: cannot %s: %s\n", m.Name, c.Description, err)
+				failed = true
+				continue
+			}
+			fmt.Printf("%s: %s\n", m.Name, c.Description)
+		}
+	}
+	if failed {
+		return errors.New("some changes could not be applied")
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/zrepl/zrepl/cli"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/transport/connecter"
+	"github.com/zrepl/zrepl/endpoint"
+	"github.com/zrepl/zrepl/replication/pdu"
+	"github.com/zrepl/zrepl/util"
+	"github.com/zrepl/zrepl/version"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var pingArgs struct {
+	count       int
+	size        int
+	filesystems bool
+}
+
+var PingCmd = &cli.Subcommand{
+	Use:   "ping JOB",
+	Short: "test connectivity, handshake, latency and throughput to the passive side of a push or pull job",
+	SetupFlags: func(f *pflag.FlagSet) {
+		f.IntVar(&pingArgs.count, "count", 5, "number of round trips for the latency test")
+		f.IntVar(&pingArgs.size, "size", 16, "MiB transferred in each direction for the throughput test, 0 to skip")
+		f.BoolVar(&pingArgs.filesystems, "filesystems", true, "list the filesystems that the passive side exposes to the job")
+	},
+	Run: func(subcommand *cli.Subcommand, args []string) error {
+		return runPingCmd(subcommand.Config(), args)
+	},
+}
+
+func runPingCmd(conf *config.Config, args []string) error {
+	if len(args) != 1 {
+		return cli.UsageError("Expected 1 argument: JOB")
+	}
+	if pingArgs.count < 1 {
+		return cli.UsageError("--count must be positive")
+	}
+	if pingArgs.size < 0 || int64(pingArgs.size)<<20 > endpoint.PingMaxReplyStreamLength {
+		return cli.UsageError("--size must be in [0, %d]", endpoint.PingMaxReplyStreamLength>>20)
+	}
+
+	job, err := conf.Job(args[0])
+	if err != nil {
+		return err
+	}
+	var connect config.ConnectEnum
+	switch j := job.Ret.(type) {
+	case *config.PushJob:
+		connect = j.Connect
+	case *config.PullJob:
+		connect = j.Connect
+	default:
+		return errors.Errorf("job type %T does not connect to a remote", j)
+	}
+	if _, ok := connect.Ret.(*config.LocalConnect); ok {
+		return errors.New("local transport is only available within the daemon")
+	}
+
+	factory, err := connecter.FromConfig(conf.Global, connect)
+	if err != nil {
+		return errors.Wrap(err, "cannot build client")
+	}
+
+	var (
+		connMtx     sync.Mutex
+		conn        net.Conn
+		connectTime time.Duration
+	)
+	client, err := factory.NewObservedClient(func(c net.Conn, d time.Duration) {
+		connMtx.Lock()
+		defer connMtx.Unlock()
+		conn, connectTime = c, d
+	})
+	if err != nil {
+		return errors.Wrap(err, "cannot build client")
+	}
+	ctx := context.Background()
+	defer client.Close(ctx)
+	remote := endpoint.NewRemote(client)
+
+	var rtts []time.Duration
+	for i := 0; i < pingArgs.count; i++ {
+		msg := fmt.Sprintf("ping %d", i)
+		begin := time.Now()
+		res, _, err := remote.Ping(ctx, &pdu.PingReq{Message: msg}, nil)
+		if err != nil {
+			return errors.Wrap(err, "ping failed")
+		}
+		rtt := time.Since(begin)
+		if res.GetMessage() != msg {
+			return errors.Errorf("ping reply mismatch: expected %q, got %q", msg, res.GetMessage())
+		}
+		if i == 0 {
+			// report the connection on first success, before the throughput test can fail
+			connMtx.Lock()
+			if conn != nil {
+				printPingConn(factory.ConnInfo(conn, connectTime))
+			}
+			connMtx.Unlock()
+		}
+		rtts = append(rtts, rtt)
+	}
+	printPingRTTs(rtts)
+
+	if pingArgs.filesystems {
+		fss, err := remote.ListFilesystems(ctx)
+		if err != nil {
+			return errors.Wrap(err, "cannot list remote filesystems")
+		}
+		printPingFilesystems(fss)
+	}
+
+	if pingArgs.size == 0 {
+		return nil
+	}
+	length := int64(pingArgs.size) << 20
+
+	begin := time.Now()
+	upload := ioutil.NopCloser(io.LimitReader(util.ZeroReader{}, length))
+	res, _, err := remote.Ping(ctx, &pdu.PingReq{Message: "upload"}, upload)
+	if err != nil {
+		return errors.Wrap(err, "upload test failed")
+	}
+	if res.GetReceivedStreamLength() != uint64(length) {
+		return errors.Errorf("upload test: remote received %d bytes, expected %d", res.GetReceivedStreamLength(), length)
+	}
+	fmt.Printf("upload:       %s\n", formatThroughput(length, time.Since(begin)))
+
+	begin = time.Now()
+	_, stream, err := remote.Ping(ctx, &pdu.PingReq{Message: "download", ReplyStreamLength: uint64(length)}, nil)
+	if err != nil {
+		return errors.Wrap(err, "download test failed")
+	}
+	n, err := io.Copy(ioutil.Discard, stream)
+	stream.Close()
+	if err != nil {
+		return errors.Wrap(err, "download test failed")
+	}
+	if n != length {
+		return errors.Errorf("download test: received %d bytes, expected %d", n, length)
+	}
+	fmt.Printf("download:     %s\n", formatThroughput(length, time.Since(begin)))
+
+	return nil
+}
+
+func printPingConn(info *connecter.ConnInfo) {
+	fmt.Printf("remote:       %s\n", info.RemoteAddr)
+	fmt.Printf("handshake:    %s (protocol version %d)\n", info.ConnectTime, info.ProtocolVersion)
+	if info.TLSVersion == "" {
+		fmt.Printf("tls:          no\n")
+	} else {
+		fmt.Printf("tls:          %s, cipher suite %s\n", info.TLSVersion, info.TLSCipherSuite)
+		fmt.Printf("server cert:  %s\n", info.Peer)
+	}
+	peerVersion := info.PeerVersion
+	if peerVersion == "" {
+		peerVersion = "unknown"
+	}
+	fmt.Printf("version:      %s (ours: %s)\n", peerVersion, version.NewZreplVersionInformation().Version)
+	fmt.Printf("features:     %s\n", strings.Join(info.PeerFeatures, ", "))
+	if info.FrameSize > 0 {
+		fmt.Printf("frames:       %d bytes\n", info.FrameSize)
+	}
+	if info.PeerClockSkew == nil {
+		fmt.Printf("clock skew:   unknown\n")
+	} else if skew := *info.PeerClockSkew; skew >= 0 {
+		fmt.Printf("clock skew:   remote is %s ahead\n", skew)
+	} else {
+		fmt.Printf("clock skew:   remote is %s behind\n", -skew)
+	}
+}
+
+func printPingFilesystems(fss []*pdu.Filesystem) {
+	paths := make([]string, len(fss))
+	for i, fs := range fss {
+		paths[i] = fs.GetPath()
+	}
+	sort.Strings(paths)
+	fmt.Printf("filesystems:  %d\n", len(paths))
+	for _, p := range paths {
+		fmt.Printf("  %s\n", p)
+	}
+}
+
+func printPingRTTs(rtts []time.Duration) {
+	min, max, sum := rtts[0], rtts[0], time.Duration(0)
+	for _, rtt := range rtts {
+		if rtt < min {
+			min = rtt
+		}
+		if rtt > max {
+			max = rtt
+		}
+		sum += rtt
+	}
+	fmt.Printf("rtt:          min %s avg %s max %s (%d round trips)\n",
+		min, sum/time.Duration(len(rtts)), max, len(rtts))
+}
+
+func formatThroughput(bytes int64, d time.Duration) string {
+	return fmt.Sprintf("%.1f MB/s (%d MiB in %s)", float64(bytes)/1e6/d.Seconds(), bytes>>20, d)
+}
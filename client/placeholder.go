@@ -0,0 +1,100 @@
+package client
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/zrepl/zrepl/cli"
+	"github.com/zrepl/zrepl/zfs"
+	"os"
+)
+
+var PlaceholderCmd = &cli.Subcommand{
+	Use:   "placeholder",
+	Short: "manage the placeholder filesystems that receiving jobs create as parents of received filesystems",
+	SetupSubcommands: func() []*cli.Subcommand {
+		return []*cli.Subcommand{placeholderCleanup, placeholderMark, placeholderUnmark}
+	},
+}
+
+var placeholderCleanupArgs struct {
+	dryRun bool
+}
+
+var placeholderCleanup = &cli.Subcommand{
+	Use:             "cleanup [--dry-run] ROOT_FS",
+	Short:           "destroy placeholder filesystems below ROOT_FS that do not contain a received filesystem anymore",
+	NoRequireConfig: true,
+	SetupFlags: func(f *pflag.FlagSet) {
+		f.BoolVar(&placeholderCleanupArgs.dryRun, "dry-run", false, "only print the placeholders that would be destroyed")
+	},
+	Run: runPlaceholderCleanup,
+}
+
+func runPlaceholderCleanup(subcommand *cli.Subcommand, args []string) error {
+	if len(args) != 1 {
+		return cli.UsageError("expected 1 argument: ROOT_FS")
+	}
+	root, err := zfs.NewDatasetPath(args[0])
+	if err != nil || root.Length() == 0 {
+		return errors.Errorf("%q is not a valid filesystem name", args[0])
+	}
+
+	state, err := zfs.ZFSListFilesystemState()
+	if err != nil {
+		return errors.Wrap(err, "cannot list filesystems")
+	}
+	removable, err := zfs.RemovablePlaceholders(root, state)
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	for _, p := range removable {
+		if placeholderCleanupArgs.dryRun {
+			fmt.Printf("would destroy %s\n", p.ToString())
+			continue
+		}
+		// not recursive: fails if a filesystem was received below p in the meantime,
+		// or if p has snapshots because it was used as a regular filesystem
+		if err := zfs.ZFSDestroy(p.ToString()); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot destroy %s: %s\n", p.ToString(), err)
+			failed = true
+			continue
+		}
+		fmt.Printf("destroyed %s\n", p.ToString())
+	}
+	if failed {
+		return errors.New("some placeholders could not be destroyed")
+	}
+	return nil
+}
+
+var placeholderMark = &cli.Subcommand{
+	Use:             "mark FS",
+	Short:           "mark FS as a placeholder, receiving jobs then overwrite it with a full send",
+	NoRequireConfig: true,
+	Run: func(subcommand *cli.Subcommand, args []string) error {
+		return runPlaceholderSet(args, true)
+	},
+}
+
+var placeholderUnmark = &cli.Subcommand{
+	Use:             "unmark FS",
+	Short:           "convert the placeholder FS into a regular filesystem",
+	NoRequireConfig: true,
+	Run: func(subcommand *cli.Subcommand, args []string) error {
+		return runPlaceholderSet(args, false)
+	},
+}
+
+func runPlaceholderSet(args []string, isPlaceholder bool) error {
+	if len(args) != 1 {
+		return cli.UsageError("expected 1 argument: FS")
+	}
+	p, err := zfs.NewDatasetPath(args[0])
+	if err != nil || p.Length() == 0 {
+		return errors.Errorf("%q is not a valid filesystem name", args[0])
+	}
+	return zfs.ZFSSetPlaceholder(p, isPlaceholder)
+}
@@ -1,7 +1,6 @@
 package client
 
 import (
-	"errors"
 	"github.com/zrepl/zrepl/cli"
 	"github.com/zrepl/zrepl/config"
 	"github.com/zrepl/zrepl/daemon"
@@ -24,7 +23,7 @@ var PprofCmd = &cli.Subcommand{
 		case "on":
 			pprofArgs.Run = true
 			if len(args) != 2 {
-				return errors.New("must specify TCP_LISTEN_ADDRESS as second positional argument")
+				return cli.UsageError("must specify TCP_LISTEN_ADDRESS as second positional argument")
 			}
 			pprofArgs.HttpListenAddress = args[1]
 		case "off":
@@ -37,7 +36,7 @@ var PprofCmd = &cli.Subcommand{
 		RunPProf(subcommand.Config())
 		return nil
 	enargs:
-		return errors.New("invalid number of positional arguments")
+		return cli.UsageError("invalid number of positional arguments")
 
 	},
 }
@@ -45,9 +44,9 @@ var PprofCmd = &cli.Subcommand{
 func RunPProf(conf *config.Config) {
 	log := log.New(os.Stderr, "", 0)
 
-	die := func() {
+	die := func(err error) {
 		log.Printf("exiting after error")
-		os.Exit(1)
+		os.Exit(cli.ExitCode(err))
 	}
 
 	log.Printf("connecting to zrepl daemon")
@@ -55,12 +54,12 @@ func RunPProf(conf *config.Config) {
 	httpc, err := controlHttpClient(conf.Global.Control.SockPath)
 	if err != nil {
 		log.Printf("error creating http client: %s", err)
-		die()
+		die(err)
 	}
 	err = jsonRequestResponse(httpc, daemon.ControlJobEndpointPProf, pprofArgs.PprofServerControlMsg, struct{}{})
 	if err != nil {
 		log.Printf("error sending control message: %s", err)
-		die()
+		die(err)
 	}
 	log.Printf("finished")
 }
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/zrepl/zrepl/cli"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon"
+	"github.com/zrepl/zrepl/daemon/job"
+	"time"
+)
+
+const replicateOncePollInterval = 1 * time.Second
+
+var replicateOnceArgs struct {
+	from, to string
+}
+
+var ReplicateOnceCmd = &cli.Subcommand{
+	Use:   "replicate-once JOB FS --to SNAP [--from SNAP|#BOOKMARK]",
+	Short: "let the daemon replicate a single step of FS chosen by the operator, using the sender, receiver and transport of push or pull job JOB",
+	SetupFlags: func(f *pflag.FlagSet) {
+		f.StringVar(&replicateOnceArgs.from, "from", "", "incremental source snapshot or #bookmark, full send if empty")
+		f.StringVar(&replicateOnceArgs.to, "to", "", "snapshot to replicate")
+	},
+	Run: func(subcommand *cli.Subcommand, args []string) error {
+		return runReplicateOnceCmd(subcommand.Config(), args)
+	},
+}
+
+func runReplicateOnceCmd(conf *config.Config, args []string) error {
+	if len(args) != 2 {
+		return cli.UsageError("Expected 2 arguments: JOB FS")
+	}
+	if replicateOnceArgs.to == "" {
+		return cli.UsageError("--to is required")
+	}
+
+	httpc, err := controlHttpClient(conf.Global.Control.SockPath)
+	if err != nil {
+		return err
+	}
+	req := daemon.ReplicateOnceRequest{
+		Job:        args[0],
+		Filesystem: args[1],
+		From:       replicateOnceArgs.from,
+		To:         replicateOnceArgs.to,
+	}
+	// the daemon replicates the step, we poll for its outcome
+	begin := time.Now()
+	var status job.ReplicateOnceStatus
+	for {
+		if err := jsonRequestResponse(httpc, daemon.ControlJobEndpointReplicateOnce, req, &status); err != nil {
+			return err
+		}
+		if status.Done {
+			break
+		}
+		req.Poll = true
+		time.Sleep(replicateOncePollInterval)
+	}
+
+	if rep := status.Report; rep != nil {
+		for _, s := range append(rep.Completed, rep.Pending...) {
+			from := s.From
+			if from == "" {
+				from = "(full)"
+			}
+			fmt.Printf("%s %s => %s: %s, %s / %s\n", rep.Filesystem, from, s.To, StringStepState(s.Status),
+				ByteCountBinary(s.TransferredBytes), ByteCountBinary(s.ExpectedBytes))
+		}
+	}
+	if status.Error != "" {
+		return errors.Errorf("replication failed: %s", status.Error)
+	}
+	fmt.Printf("done in %s\n", time.Since(begin).Round(time.Millisecond))
+	return nil
+}
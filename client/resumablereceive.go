@@ -0,0 +1,95 @@
+package client
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/zrepl/zrepl/cli"
+	"github.com/zrepl/zrepl/zfs"
+	"os"
+	"sort"
+)
+
+var ResumableReceiveCmd = &cli.Subcommand{
+	Use:   "resumable-receive",
+	Short: "manage the state of interrupted resumable receives (zfs recv -s) on the receiving side",
+	SetupSubcommands: func() []*cli.Subcommand {
+		return []*cli.Subcommand{resumableReceiveList, resumableReceiveAbort}
+	},
+}
+
+var resumableReceiveList = &cli.Subcommand{
+	Use:             "list ROOT_FS",
+	Short:           "list the filesystems at or below ROOT_FS that have the state of an interrupted receive",
+	NoRequireConfig: true,
+	Run: func(subcommand *cli.Subcommand, args []string) error {
+		fss, tokens, err := listResumableReceives(args)
+		if err != nil {
+			return err
+		}
+		for _, fs := range fss {
+			fmt.Printf("%s\t%s\n", fs, tokens[fs])
+		}
+		return nil
+	},
+}
+
+var resumableReceiveAbortArgs struct {
+	dryRun bool
+}
+
+var resumableReceiveAbort = &cli.Subcommand{
+	Use:             "abort [--dry-run] ROOT_FS",
+	Short:           "discard the state of interrupted receives at or below ROOT_FS (zfs recv -A)",
+	NoRequireConfig: true,
+	SetupFlags: func(f *pflag.FlagSet) {
+		f.BoolVar(&resumableReceiveAbortArgs.dryRun, "dry-run", false, "only print the filesystems whose state would be discarded")
+	},
+	Run: runResumableReceiveAbort,
+}
+
+func runResumableReceiveAbort(subcommand *cli.Subcommand, args []string) error {
+	fss, _, err := listResumableReceives(args)
+	if err != nil {
+		return err
+	}
+	failed := false
+	for _, fs := range fss {
+		if resumableReceiveAbortArgs.dryRun {
+			fmt.Printf("would abort receive of %s\n", fs)
+			continue
+		}
+		// an interrupted full receive is destroyed along with its state
+		if err := zfs.ZFSRecvClearResumeToken(fs); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot abort receive of %s: %s\n", fs, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("aborted receive of %s\n", fs)
+	}
+	if failed {
+		return errors.New("some receives could not be aborted")
+	}
+	return nil
+}
+
+// listResumableReceives returns the sorted names of the filesystems with resume tokens and the tokens by name.
+func listResumableReceives(args []string) ([]string, map[string]string, error) {
+	if len(args) != 1 {
+		return nil, nil, cli.UsageError("expected 1 argument: ROOT_FS")
+	}
+	root, err := zfs.NewDatasetPath(args[0])
+	if err != nil || root.Length() == 0 {
+		return nil, nil, errors.Errorf("%q is not a valid filesystem name", args[0])
+	}
+	tokens, err := zfs.ZFSListReceiveResumeTokens(root)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cannot list resume tokens")
+	}
+	fss := make([]string, 0, len(tokens))
+	for fs := range tokens {
+		fss = append(fss, fs)
+	}
+	sort.Strings(fss)
+	return fss, tokens, nil
+}
@@ -9,7 +9,11 @@ import (
 	"github.com/zrepl/zrepl/cli"
 	"github.com/zrepl/zrepl/daemon"
 	"github.com/zrepl/zrepl/daemon/job"
+	"github.com/zrepl/zrepl/daemon/job/crash"
+	"github.com/zrepl/zrepl/daemon/job/lastsuccess"
 	"github.com/zrepl/zrepl/daemon/pruner"
+	"github.com/zrepl/zrepl/daemon/snapper"
+	"github.com/zrepl/zrepl/daemon/transport/connecter"
 	"github.com/zrepl/zrepl/replication"
 	"github.com/zrepl/zrepl/replication/fsrep"
 	"io"
@@ -163,6 +167,7 @@ func (t *tui) addIndent(indent int) {
 
 var statusFlags struct {
 	Raw bool
+	Check bool
 }
 
 var StatusCmd = &cli.Subcommand{
@@ -170,6 +175,7 @@ var StatusCmd = &cli.Subcommand{
 	Short: "show job activity or dump as JSON for monitoring",
 	SetupFlags: func(f *pflag.FlagSet) {
 		f.BoolVar(&statusFlags.Raw, "raw", false, "dump raw status description from zrepl daemon")
+		f.BoolVar(&statusFlags.Check, "check", false, "print the health of each job and set the exit code accordingly")
 	},
 	Run: runStatus,
 }
@@ -180,10 +186,18 @@ func runStatus(s *cli.Subcommand, args []string) error {
 		return err
 	}
 
+	if statusFlags.Raw && statusFlags.Check {
+		return cli.UsageError("--raw and --check are mutually exclusive")
+	}
+
+	if statusFlags.Check {
+		return runStatusCheck(httpc)
+	}
+
 	if statusFlags.Raw {
 		resp, err := httpc.Get("http://unix"+daemon.ControlJobEndpointStatus)
 		if err != nil {
-			return err
+			return cli.WithExitCode(cli.ExitDaemonUnreachable, err)
 		}
 		defer resp.Body.Close()
 		if resp.StatusCode != http.StatusOK {
@@ -197,6 +211,10 @@ func runStatus(s *cli.Subcommand, args []string) error {
 		return nil
 	}
 
+	if s.Quiet() {
+		return cli.UsageError("--quiet requires --raw or --check")
+	}
+
 	t := newTui()
 	t.lock.Lock()
 	t.err = errors.New("Got no report yet")
@@ -298,6 +316,20 @@ func (t *tui) draw() {
 			t.setIndent(1)
 			t.newline()
 
+			if v.Usage != nil {
+				t.printf("Usage: %d goroutines, %d connections (buffer limit %s)",
+					v.Usage.Goroutines, v.Usage.OpenConnections, ByteCountBinary(v.Usage.ConnBufferLimitBytes))
+				t.newline()
+			}
+
+			if v.Crashes != nil {
+				t.printf("Crashes:")
+				t.newline()
+				t.addIndent(1)
+				t.renderCrashReport(v.Crashes)
+				t.addIndent(-1)
+			}
+
 			if v.Type != job.TypePush && v.Type != job.TypePull {
 				t.printf("No status representation for job type '%s', dumping as YAML", v.Type)
 				t.newline()
@@ -319,6 +351,14 @@ func (t *tui) draw() {
 				continue
 			}
 
+			if pushStatus.Snapshotting != nil {
+				t.printf("Snapshotting:")
+				t.newline()
+				t.addIndent(1)
+				t.renderSnapperReport(pushStatus.Snapshotting)
+				t.addIndent(-1)
+			}
+
 			t.printf("Replication:")
 			t.newline()
 			t.addIndent(1)
@@ -337,6 +377,26 @@ func (t *tui) draw() {
 			t.renderPrunerReport(pushStatus.PruningReceiver)
 			t.addIndent(-1)
 
+			t.printf("Last Success:")
+			t.newline()
+			t.addIndent(1)
+			t.renderLastSuccessReport(pushStatus.LastSuccess)
+			t.addIndent(-1)
+
+			t.printf("Connection:")
+			t.newline()
+			t.addIndent(1)
+			t.renderConnInfo(pushStatus.Connection)
+			t.addIndent(-1)
+
+			if pushStatus.ReceiveHooks != nil {
+				t.printf("Receive Hooks:")
+				t.newline()
+				t.addIndent(1)
+				t.renderReceiveHooksReport(pushStatus.ReceiveHooks)
+				t.addIndent(-1)
+			}
+
 		}
 	}
 	termbox.Flush()
@@ -348,11 +408,13 @@ func (t *tui) renderReplicationReport(rep *replication.Report, history *bytesPro
 		return
 	}
 
-	all := make([]*fsrep.Report, 0, len(rep.Completed)+len(rep.Pending) + 1)
+	all := make([]*fsrep.Report, 0, len(rep.Completed)+len(rep.Pending) + len(rep.Active))
 	all = append(all, rep.Completed...)
 	all = append(all, rep.Pending...)
-	if rep.Active != nil {
-		all = append(all, rep.Active)
+	all = append(all, rep.Active...)
+	active := make(map[*fsrep.Report]bool, len(rep.Active))
+	for _, fs := range rep.Active {
+		active[fs] = true
 	}
 	sort.Slice(all, func(i, j int) bool {
 		return all[i].Filesystem < all[j].Filesystem
@@ -379,11 +441,11 @@ func (t *tui) renderReplicationReport(rep *replication.Report, history *bytesPro
 		// Progress: [---------------]
 		sumUpFSRep := func(rep *fsrep.Report) (transferred, total int64) {
 			for _, s := range rep.Pending {
-				transferred += s.Bytes
+				transferred += s.TransferredBytes
 				total  += s.ExpectedBytes
 			}
 			for _, s := range rep.Completed {
-				transferred += s.Bytes
+				transferred += s.TransferredBytes
 				total += s.ExpectedBytes
 			}
 			return
@@ -398,6 +460,14 @@ func (t *tui) renderReplicationReport(rep *replication.Report, history *bytesPro
 		t.write("Progress: ")
 		t.drawBar(50, transferred, total, changeCount)
 		t.write(fmt.Sprintf(" %s / %s @ %s/s", ByteCountBinary(transferred), ByteCountBinary(total), ByteCountBinary(rate)))
+		if total > 0 {
+			t.write(fmt.Sprintf(" (%d%%", transferred*100/total))
+			if rate > 0 && total > transferred {
+				eta := time.Duration((total-transferred)/rate) * time.Second
+				t.write(fmt.Sprintf(", ETA %s", eta))
+			}
+			t.write(")")
+		}
 		t.newline()
 	}
 
@@ -408,7 +478,7 @@ func (t *tui) renderReplicationReport(rep *replication.Report, history *bytesPro
 		}
 	}
 	for _, fs := range all {
-		t.printFilesystemStatus(fs, fs == rep.Active, maxFSLen)
+		t.printFilesystemStatus(fs, active[fs], maxFSLen)
 	}
 }
 
@@ -433,6 +503,12 @@ func (t *tui) renderPrunerReport(r *pruner.Report) {
 	if r.SleepUntil.After(time.Now()) {
 		t.printf("Sleeping until %s (%s left)\n", r.SleepUntil, r.SleepUntil.Sub(time.Now()))
 	}
+	if !r.PostponedUntil.IsZero() {
+		t.printf("Destroys postponed until pruning window opens at %s\n", r.PostponedUntil)
+	}
+	if d := r.Destroying; d != nil {
+		t.printf("Destroying: %s (%d/%d snapshots)\n", d.Filesystem, d.Destroyed, d.Total)
+	}
 
 	type commonFS struct {
 		*pruner.FSReport
@@ -461,9 +537,9 @@ func (t *tui) renderPrunerReport(r *pruner.Report) {
 	var totalDestroyCount, completedDestroyCount int
 	var maxFSname int
 	for _, fs := range all {
-		totalDestroyCount += len(fs.DestroyList)
+		totalDestroyCount += len(fs.DestroyList) + len(fs.BookmarkDestroyList)
 		if fs.completed {
-			completedDestroyCount += len(fs.DestroyList)
+			completedDestroyCount += len(fs.DestroyList) + len(fs.BookmarkDestroyList)
 		}
 		if maxFSname < len(fs.Filesystem) {
 			maxFSname = len(fs.Filesystem)
@@ -496,14 +572,22 @@ func (t *tui) renderPrunerReport(r *pruner.Report) {
 
 		pruneRuleActionStr := fmt.Sprintf("(destroy %d of %d snapshots)",
 			len(fs.DestroyList), len(fs.SnapshotList))
+		if len(fs.BookmarkList) > 0 {
+			pruneRuleActionStr = fmt.Sprintf("(destroy %d of %d snapshots, %d of %d bookmarks)",
+				len(fs.DestroyList), len(fs.SnapshotList), len(fs.BookmarkDestroyList), len(fs.BookmarkList))
+		}
 
 		if fs.completed {
-			t.printf( "Completed  %s\n", pruneRuleActionStr)
+			t.printf( "Completed  %s", pruneRuleActionStr)
+			if skipped := skippedDestroys(fs.DestroyList); skipped != "" {
+				t.printf(", skipped %s", skipped)
+			}
+			t.newline()
 			continue
 		}
 
 		t.write("Pending    ") // whitespace is padding 10
-		if len(fs.DestroyList) == 1 {
+		if len(fs.DestroyList) == 1 && len(fs.BookmarkDestroyList) == 0 {
 			t.write(fs.DestroyList[0].Name)
 		} else {
 			t.write(pruneRuleActionStr)
@@ -513,6 +597,122 @@ func (t *tui) renderPrunerReport(r *pruner.Report) {
 
 }
 
+// skippedDestroys describes the snapshots of destroyList whose destroy was skipped, e.g. "@a (held), @b (clone)".
+func skippedDestroys(destroyList []pruner.SnapshotReport) string {
+	var skipped []string
+	for _, s := range destroyList {
+		if s.Skipped != "" {
+			skipped = append(skipped, fmt.Sprintf("@%s (%s)", s.Name, s.Skipped))
+		}
+	}
+	return strings.Join(skipped, ", ")
+}
+
+func (t *tui) renderSnapperReport(r *snapper.Report) {
+	t.printf("Status: %s", r.State)
+	t.newline()
+	if r.Error != "" {
+		t.printf("Error: %s\n", r.Error)
+	}
+	if !r.SleepUntil.IsZero() {
+		t.printf("Sleep until: %s\n", r.SleepUntil.Format(time.RFC3339))
+	}
+	if len(r.Misconfigured) > 0 {
+		t.printf("Misconfigured (no snapshots matching prefix, not snapshotted):\n")
+		t.addIndent(1)
+		for _, fs := range r.Misconfigured {
+			t.printf("%s\n", fs)
+		}
+		t.addIndent(-1)
+	}
+	if len(r.Progress) == 0 {
+		return
+	}
+	var failed []*snapper.ReportFilesystem
+	for _, fs := range r.Progress {
+		if fs.Error != "" {
+			failed = append(failed, fs)
+		}
+	}
+	t.printf("Filesystems: %d, failed: %d\n", len(r.Progress), len(failed))
+	t.addIndent(1)
+	for _, fs := range failed {
+		t.printf("%s@%s (%s, %d attempts): %s\n", fs.Path, fs.SnapName, fs.State, fs.Attempts, fs.Error)
+	}
+	t.addIndent(-1)
+}
+
+func (t *tui) renderCrashReport(r *crash.Report) {
+	if r.Stopped {
+		t.printf("job stopped after a panic, restart the daemon to run it again\n")
+	}
+	t.printf("%d recovered panics since daemon start\n", r.Count)
+	if len(r.Recent) == 0 {
+		return
+	}
+	last := r.Recent[len(r.Recent)-1]
+	state := last.State
+	if state == "" {
+		state = "unknown"
+	}
+	t.printf("last: %s in %s goroutine (state %s, %s ago)\n", last.Time.Format(time.RFC3339), last.Goroutine, state, time.Now().Sub(last.Time).Round(time.Second))
+	t.printfDrawIndentedAndWrappedIfMultiline("panic: %s", last.Panic)
+	t.newline()
+}
+
+func (t *tui) renderLastSuccessReport(r *lastsuccess.Report) {
+	if r == nil {
+		t.printf("...\n")
+		return
+	}
+	phases := []lastsuccess.Phase{
+		lastsuccess.Snapshot,
+		lastsuccess.Replication,
+		lastsuccess.PruneSender,
+		lastsuccess.PruneReceiver,
+	}
+	for _, p := range phases {
+		at, ok := r.Job[p]
+		if !ok {
+			t.printf("%s never\n", rightPad(string(p), 15, " "))
+			continue
+		}
+		t.printf("%s %s (%s ago)\n", rightPad(string(p), 15, " "), at.Format(time.RFC3339), time.Now().Sub(at).Round(time.Second))
+	}
+}
+
+func (t *tui) renderReceiveHooksReport(r *job.ReceiveHooksReport) {
+	t.printf("%d pending, %d failed\n", r.Pending, r.Failures)
+	if f := r.LastFailure; f != nil {
+		t.printf("last failure: %s@%s at %s\n", f.Filesystem, f.Snapshot, f.Time.Format(time.RFC3339))
+		t.printfDrawIndentedAndWrappedIfMultiline("%s", f.Error)
+		t.newline()
+	}
+}
+
+func (t *tui) renderConnInfo(i *connecter.ConnInfo) {
+	if i == nil {
+		t.printf("not connected yet\n")
+		return
+	}
+	t.printf("Transport: %s (protocol version %d)\n", i.Transport, i.ProtocolVersion)
+	t.printf("Peer:      %s (%s)\n", i.Peer, i.RemoteAddr)
+	if i.TLSVersion != "" {
+		t.printf("TLS:       %s, cipher suite %s\n", i.TLSVersion, i.TLSCipherSuite)
+	}
+	t.printf("Connected: %s (%s ago, handshake took %s)\n",
+		i.ConnectedAt.Format(time.RFC3339), time.Now().Sub(i.ConnectedAt).Round(time.Second), i.ConnectTime)
+	if len(i.PeerFeatures) > 0 {
+		t.printf("Features:  %s\n", strings.Join(i.PeerFeatures, ", "))
+	}
+	if i.FrameSize > 0 {
+		t.printf("Frames:    %s\n", ByteCountBinary(int64(i.FrameSize)))
+	}
+	if i.PeerPoolBusy != "" {
+		t.printf("Receiving pool busy: %s in progress\n", i.PeerPoolBusy)
+	}
+}
+
 const snapshotIndent = 1
 func calculateMaxFSLength(all []*fsrep.Report) (maxFS, maxStatus int) {
 	for _, e := range all {
@@ -590,20 +790,20 @@ func (t *tui) printFilesystemStatus(rep *fsrep.Report, active bool, maxFS int) {
 	bytes := int64(0)
 	totalBytes := int64(0)
 	for _, s := range rep.Pending {
-		bytes += s.Bytes
+		bytes += s.TransferredBytes
 		totalBytes += s.ExpectedBytes
 	}
 	for _, s := range rep.Completed {
-		bytes += s.Bytes
+		bytes += s.TransferredBytes
 		totalBytes += s.ExpectedBytes
 	}
 
 
-	status := fmt.Sprintf("%s (step %d/%d, %s/%s)",
+	status := fmt.Sprintf("%s (step %d/%d, %s/%s%s)",
 		rep.Status,
 		len(rep.Completed), len(rep.Pending) + len(rep.Completed),
 		ByteCountBinary(bytes), ByteCountBinary(totalBytes),
-
+		stepThroughput(rep, totalBytes-bytes),
 	)
 
 	activeIndicator := " "
@@ -625,11 +825,31 @@ func (t *tui) printFilesystemStatus(rep *fsrep.Report, active bool, maxFS int) {
 			next = fmt.Sprintf("next: %s (full)", rep.Pending[0].To)
 		}
 	}
+	if rep.Resolution != "" {
+		next = strings.TrimSpace(fmt.Sprintf("%s (%s)", next, rep.Resolution))
+	}
 	t.printfDrawIndentedAndWrappedIfMultiline("%s", next)
 
 	t.newline()
 }
 
+// stepThroughput describes the recent throughput of the step in progress as measured by the daemon,
+// with the ETA of the remaining bytes of the filesystem
+func stepThroughput(rep *fsrep.Report, remaining int64) string {
+	if len(rep.Pending) == 0 || rep.Pending[0].Throughput == nil {
+		return ""
+	}
+	tp := rep.Pending[0].Throughput
+	if tp.Ended || tp.RecentBytesPerSecond == 0 {
+		return ""
+	}
+	ret := fmt.Sprintf(" @ %s/s", ByteCountBinary(tp.RecentBytesPerSecond))
+	if remaining > 0 {
+		ret += fmt.Sprintf(", ETA %s", time.Duration(remaining/tp.RecentBytesPerSecond)*time.Second)
+	}
+	return ret
+}
+
 func ByteCountBinary(b int64) string {
 	const unit = 1024
 	if b < unit {
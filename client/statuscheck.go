@@ -0,0 +1,172 @@
+package client
+
+import (
+	"fmt"
+	"github.com/zrepl/zrepl/cli"
+	"github.com/zrepl/zrepl/daemon"
+	"github.com/zrepl/zrepl/daemon/job"
+	"github.com/zrepl/zrepl/daemon/job/crash"
+	"github.com/zrepl/zrepl/daemon/pruner"
+	"github.com/zrepl/zrepl/daemon/snapper"
+	"github.com/zrepl/zrepl/replication"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type jobHealth int
+
+// ordered by severity
+const (
+	jobHealthOK jobHealth = iota
+	jobHealthDegraded
+	jobHealthFailed
+)
+
+func (h jobHealth) String() string {
+	switch h {
+	case jobHealthOK:
+		return "OK"
+	case jobHealthDegraded:
+		return "DEGRADED"
+	case jobHealthFailed:
+		return "FAILED"
+	default:
+		return fmt.Sprintf("jobHealth(%d)", int(h))
+	}
+}
+
+func (h jobHealth) exitCode() int {
+	switch h {
+	case jobHealthDegraded:
+		return cli.ExitDegraded
+	case jobHealthFailed:
+		return cli.ExitJobFailed
+	default:
+		return cli.ExitOK
+	}
+}
+
+type healthCheck struct {
+	health   jobHealth
+	problems []string
+}
+
+func (c *healthCheck) add(h jobHealth, what, problem string) {
+	if h == jobHealthOK {
+		return
+	}
+	if h > c.health {
+		c.health = h
+	}
+	c.problems = append(c.problems, fmt.Sprintf("%s: %s", what, problem))
+}
+
+func (c *healthCheck) snapper(r *snapper.Report) {
+	if r == nil {
+		return
+	}
+	if r.State == snapper.ErrorWait.String() {
+		c.add(jobHealthDegraded, "snapshotting", r.Error)
+	}
+	if len(r.Misconfigured) > 0 {
+		c.add(jobHealthDegraded, "snapshotting", fmt.Sprintf("filesystems without matching snapshots: %s", strings.Join(r.Misconfigured, ", ")))
+	}
+}
+
+func (c *healthCheck) replication(r *replication.Report) {
+	if r == nil {
+		return
+	}
+	state, err := replication.StateString(r.Status)
+	if err != nil {
+		c.add(jobHealthFailed, "replication", fmt.Sprintf("unknown state %q", r.Status))
+		return
+	}
+	switch state {
+	case replication.PermanentError:
+		c.add(jobHealthFailed, "replication", r.Problem)
+	case replication.PlanningError, replication.WorkingWait:
+		c.add(jobHealthDegraded, "replication", r.Problem)
+	}
+}
+
+func (c *healthCheck) pruner(side string, r *pruner.Report) {
+	if r == nil {
+		return
+	}
+	state, err := pruner.StateString(r.State)
+	if err != nil {
+		c.add(jobHealthFailed, side, fmt.Sprintf("unknown state %q", r.State))
+		return
+	}
+	switch state {
+	case pruner.ErrPerm:
+		c.add(jobHealthFailed, side, r.Error)
+	case pruner.PlanWait, pruner.ExecWait:
+		c.add(jobHealthDegraded, side, r.Error)
+	}
+}
+
+func (c *healthCheck) crashes(r *crash.Report) {
+	if r == nil || len(r.Recent) == 0 {
+		return
+	}
+	last := r.Recent[len(r.Recent)-1]
+	if r.Stopped {
+		c.add(jobHealthFailed, "crashes", fmt.Sprintf("job stopped after panic in %s goroutine: %s", last.Goroutine, last.Panic))
+		return
+	}
+	c.add(jobHealthDegraded, "crashes", fmt.Sprintf("%d recovered panics, last in %s goroutine: %s", r.Count, last.Goroutine, last.Panic))
+}
+
+func checkJob(s *job.Status) *healthCheck {
+	var c healthCheck
+	c.crashes(s.Crashes)
+	switch st := s.JobSpecific.(type) {
+	case *job.ActiveSideStatus:
+		c.snapper(st.Snapshotting)
+		c.replication(st.Replication)
+		c.pruner("pruning sender", st.PruningSender)
+		c.pruner("pruning receiver", st.PruningReceiver)
+	case *job.PassiveStatus:
+		c.snapper(st.Snapshotting)
+	}
+	return &c
+}
+
+// runStatusCheck prints one line per job and returns an error with the exit code of the most severe job health.
+func runStatusCheck(httpc http.Client) error {
+	m := make(map[string]job.Status)
+	if err := jsonRequestResponse(httpc, daemon.ControlJobEndpointStatus, struct{}{}, &m); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		if len(name) == 0 || daemon.IsInternalJobName(name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	worst := jobHealthOK
+	for _, name := range names {
+		st := m[name]
+		c := checkJob(&st)
+		if c.health > worst {
+			worst = c.health
+		}
+		fmt.Printf("%s: %s", name, c.health)
+		if len(c.problems) > 0 {
+			fmt.Printf(": %s", strings.Join(c.problems, "; "))
+		}
+		fmt.Println()
+	}
+
+	if worst != jobHealthOK {
+		return cli.WithExitCode(worst.exitCode(), fmt.Errorf("job status: %s", worst))
+	}
+	return nil
+}
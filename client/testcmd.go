@@ -1,19 +1,26 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/pflag"
 	"github.com/zrepl/zrepl/cli"
 	"github.com/zrepl/zrepl/config"
 	"github.com/zrepl/zrepl/daemon/filters"
+	"github.com/zrepl/zrepl/daemon/pruner"
+	"github.com/zrepl/zrepl/daemon/pruner/prunertest"
 	"github.com/zrepl/zrepl/zfs"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var TestCmd = &cli.Subcommand {
 	Use: "test",
 	SetupSubcommands: func() []*cli.Subcommand {
-		return []*cli.Subcommand{testFilter, testPlaceholder}
+		return []*cli.Subcommand{testFilter, testPlaceholder, testPrunePolicy}
 	},
 }
 
@@ -37,10 +44,10 @@ var testFilter = &cli.Subcommand{
 func runTestFilterCmd(subcommand *cli.Subcommand, args []string) error {
 
 	if testFilterArgs.job == "" {
-		return fmt.Errorf("must specify --job flag")
+		return cli.UsageError("must specify --job flag")
 	}
 	if !(testFilterArgs.all != (testFilterArgs.input != "")) { // xor
-		return fmt.Errorf("must set one: --all or --input")
+		return cli.UsageError("must set one: --all or --input")
 	}
 
 	conf := subcommand.Config()
@@ -187,5 +194,155 @@ func runTestPlaceholder(subcommand *cli.Subcommand, args []string) error {
 		return nil
 	}
 
-	return fmt.Errorf("unknown --action %q", testPlaceholderArgs.action)
+	return cli.UsageError("unknown --action %q", testPlaceholderArgs.action)
+}
+
+var testPrunePolicyArgs struct {
+	job         string
+	side        string
+	prefix      string
+	interval    string
+	duration    string
+	reportEvery string
+	lag         int
+}
+
+var testPrunePolicy = &cli.Subcommand{
+	Use:   "prune-policy --job JOB [--side sender | receiver] [--interval INTERVAL] [--duration DURATION] [--report-every INTERVAL] [--lag N]",
+	Short: "simulate the pruning rules of push or pull job JOB on a synthetic snapshot timeline and print which snapshots survive",
+	Example: `
+	prune-policy --job prod_to_backups --interval 15m --duration 60d
+	prune-policy --job prod_to_backups --side receiver --interval 1h --duration 365d --report-every 30d`,
+	SetupFlags: func(f *pflag.FlagSet) {
+		f.StringVar(&testPrunePolicyArgs.job, "job", "", "the name of the push or pull job")
+		f.StringVar(&testPrunePolicyArgs.side, "side", "sender", "simulate the keep_sender or the keep_receiver rules: sender | receiver")
+		f.StringVar(&testPrunePolicyArgs.prefix, "prefix", "zrepl_", "the prefix of the snapshot names, followed by the creation date")
+		f.StringVar(&testPrunePolicyArgs.interval, "interval", "1h", "the snapshotting interval, e.g. 15m or 1d")
+		f.StringVar(&testPrunePolicyArgs.duration, "duration", "30d", "the simulated time span")
+		f.StringVar(&testPrunePolicyArgs.reportEvery, "report-every", "1d", "the interval of the progress lines")
+		f.IntVar(&testPrunePolicyArgs.lag, "lag", 0, "the number of most recent snapshots that are not replicated when the sender is pruned")
+	},
+	Run: runTestPrunePolicy,
+}
+
+// parseSimulationDuration accepts the durations of time.ParseDuration and whole days, e.g. 30d.
+func parseSimulationDuration(flag, s string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	if strings.HasSuffix(s, "d") {
+		var days int
+		if days, err = strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil {
+			d = time.Duration(days) * 24 * time.Hour
+		}
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d <= 0 {
+		return 0, cli.UsageError("--%s must be a positive duration, e.g. 15m, 2h or 30d, got %q", flag, s)
+	}
+	return d, nil
+}
+
+func formatAge(d time.Duration) string {
+	days, hours := d/(24*time.Hour), (d%(24*time.Hour))/time.Hour
+	if d%time.Hour != 0 {
+		return d.String()
+	}
+	return fmt.Sprintf("%dd%02dh", days, hours)
+}
+
+func runTestPrunePolicy(subcommand *cli.Subcommand, args []string) error {
+	a := testPrunePolicyArgs
+	if a.job == "" {
+		return cli.UsageError("must specify --job flag")
+	}
+	interval, err := parseSimulationDuration("interval", a.interval)
+	if err != nil {
+		return err
+	}
+	duration, err := parseSimulationDuration("duration", a.duration)
+	if err != nil {
+		return err
+	}
+	reportEvery, err := parseSimulationDuration("report-every", a.reportEvery)
+	if err != nil {
+		return err
+	}
+	if a.lag < 0 {
+		return cli.UsageError("--lag must not be negative")
+	}
+
+	job, err := subcommand.Config().Job(a.job)
+	if err != nil {
+		return err
+	}
+	var pruning config.PruningSenderReceiver
+	switch j := job.Ret.(type) {
+	case *config.PushJob:
+		pruning = j.Pruning
+	case *config.PullJob:
+		pruning = j.Pruning
+	default:
+		return fmt.Errorf("job type %T does not prune", j)
+	}
+	// the simulated destroys happen right away, regardless of the time of day
+	pruning.Window = nil
+	factory, err := pruner.NewPrunerFactory(pruning, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "zrepl",
+		Subsystem: "pruning",
+		Name:      "time",
+	}, []string{"prune_side"}))
+	if err != nil {
+		return err
+	}
+	sim := &prunertest.Simulation{
+		Filesystem:     "pool/simulated",
+		Prefix:         a.prefix,
+		Interval:       interval,
+		ReplicationLag: a.lag,
+		Build:          factory.BuildSenderPruner,
+	}
+	switch a.side {
+	case "sender":
+	case "receiver":
+		// the receiver only has replicated snapshots
+		sim.ReplicationLag = 0
+		sim.Build = factory.BuildReceiverPruner
+	default:
+		return cli.UsageError("--side must be sender or receiver, got %q", a.side)
+	}
+	sim.End = time.Now().UTC().Truncate(24 * time.Hour)
+	sim.Start = sim.End.Add(-duration)
+
+	fmt.Printf("TIME\tSNAPSHOTS\tDESTROYED\tOLDEST_AGE\n")
+	var last prunertest.Step
+	destroyed := 0
+	nextReport := sim.Start.Add(reportEvery)
+	err = sim.Run(context.Background(), func(s prunertest.Step) {
+		last = s
+		destroyed += len(s.Destroyed)
+		if s.Time.Before(nextReport) && !s.Time.Equal(sim.End) {
+			return
+		}
+		for !nextReport.After(s.Time) {
+			nextReport = nextReport.Add(reportEvery)
+		}
+		oldest := "-"
+		if len(s.Snapshots) > 0 {
+			oldest = formatAge(s.Time.Sub(s.Snapshots[0].SnapshotTime()))
+		}
+		fmt.Printf("%s\t%d\t%d\t%s\n", s.Time.Format("2006-01-02 15:04"), len(s.Snapshots), destroyed, oldest)
+		destroyed = 0
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nsnapshots at %s:\n", last.Time.Format("2006-01-02 15:04"))
+	fmt.Printf("AGE\tNAME\n")
+	for i := len(last.Snapshots) - 1; i >= 0; i-- {
+		v := last.Snapshots[i]
+		fmt.Printf("%s\t%s\n", formatAge(last.Time.Sub(v.SnapshotTime())), v.Name)
+	}
+	return nil
 }
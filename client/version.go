@@ -2,6 +2,7 @@ package client
 
 import (
 	"fmt"
+	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
 	"github.com/zrepl/zrepl/cli"
 	"github.com/zrepl/zrepl/config"
@@ -34,7 +35,7 @@ func runVersionCmd() error {
 	args := versionArgs
 
 	if args.Show != "daemon" && args.Show != "client" && args.Show != "" {
-		return fmt.Errorf("show flag must be 'client' or 'server' or be left empty")
+		return cli.UsageError("show flag must be 'client' or 'server' or be left empty")
 	}
 
 	var clientVersion, daemonVersion *version.ZreplVersionInformation
@@ -50,13 +51,13 @@ func runVersionCmd() error {
 
 		httpc, err := controlHttpClient(args.Config.Global.Control.SockPath)
 		if err != nil {
-			return fmt.Errorf("server: error: %s\n", err)
+			return errors.Wrap(err, "server: error")
 		}
 
 		var info version.ZreplVersionInformation
 		err = jsonRequestResponse(httpc, daemon.ControlJobEndpointVersion, "", &info)
 		if err != nil {
-			return fmt.Errorf("server: error: %s\n", err)
+			return errors.Wrap(err, "server: error")
 		}
 		daemonVersion = &info
 		fmt.Printf("server: %s\n", daemonVersion.String())
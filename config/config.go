@@ -9,12 +9,16 @@ import (
 	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
 	Jobs   []JobEnum `yaml:"jobs"`
 	Global *Global   `yaml:"global,optional,fromdefaults"`
+
+	// Set if the config was migrated from an old layout when parsing it, see MigrateConfigBytes.
+	MigrationWarnings []string `yaml:"-" json:"-"`
 }
 
 func (c *Config) Job(name string) (*JobEnum, error) {
@@ -43,11 +47,33 @@ func (j JobEnum) Name() string {
 	return name
 }
 
+// Logging returns the logging settings of the job.
+func (j JobEnum) Logging() JobLogging {
+	switch v := j.Ret.(type) {
+	case *PushJob: return v.Logging
+	case *SinkJob: return v.Logging
+	case *PullJob: return v.Logging
+	case *SourceJob: return v.Logging
+	default:
+		panic(fmt.Sprintf("unknownn job type %T", v))
+	}
+}
+
 type ActiveJob struct {
 	Type         string                `yaml:"type"`
 	Name         string                `yaml:"name"`
 	Connect     ConnectEnum     `yaml:"connect"`
 	Pruning      PruningSenderReceiver `yaml:"pruning"`
+	Bookmark     bool                  `yaml:"bookmark,optional,default=false"`
+	Send         SendOptions           `yaml:"send,optional"`
+	Priorities   map[string]int        `yaml:"priorities,optional"`
+	SyncProperties []string            `yaml:"sync_properties,optional"`
+	SnapshotGracePeriod time.Duration  `yaml:"snapshot_grace_period,optional"`
+	Replication  *ReplicationOptions   `yaml:"replication,optional,fromdefaults"`
+	// Name of a push or pull job after whose successful runs this job runs, in addition to its own schedule.
+	Trigger      string                `yaml:"trigger,optional"`
+	Notify       JobNotify             `yaml:"notify,optional"`
+	Logging      JobLogging            `yaml:"logging,optional"`
 	Debug        JobDebugSettings      `yaml:"debug,optional"`
 }
 
@@ -55,6 +81,8 @@ type PassiveJob struct {
 	Type        string           `yaml:"type"`
 	Name        string           `yaml:"name"`
 	Serve       ServeEnum `yaml:"serve"`
+	Notify      JobNotify        `yaml:"notify,optional"`
+	Logging     JobLogging       `yaml:"logging,optional"`
 	Debug       JobDebugSettings `yaml:"debug,optional"`
 }
 
@@ -62,44 +90,261 @@ type PushJob struct {
 	ActiveJob `yaml:",inline"`
 	Snapshotting SnapshottingEnum          `yaml:"snapshotting"`
 	Filesystems FilesystemsFilter `yaml:"filesystems"`
+	SnapshotFilter *SnapshotFilter `yaml:"snapshot_filter,optional"`
 }
 
 type PullJob struct {
 	ActiveJob `yaml:",inline"`
 	RootFS    string        `yaml:"root_fs"`
+	RootFSMapping []RootFSMappingRule `yaml:"root_fs_mapping,optional"`
 	Interval  time.Duration `yaml:"interval,positive"`
+	Recv      RecvOptions   `yaml:"recv,optional"`
 }
 
 type SinkJob struct {
 	PassiveJob `yaml:",inline"`
-	RootFS     string `yaml:"root_fs"`
+	RootFS     string      `yaml:"root_fs"`
+	RootFSMapping []RootFSMappingRule `yaml:"root_fs_mapping,optional"`
+	Recv       RecvOptions `yaml:"recv,optional"`
+}
+
+// RootFSMappingRule receives the sender's filesystems below Sender below RootFS instead of the job's root_fs.
+type RootFSMappingRule struct {
+	Sender string `yaml:"sender"`
+	RootFS string `yaml:"root_fs"`
+}
+
+type ReplicationOptions struct {
+	Concurrency *ReplicationConcurrency `yaml:"concurrency,optional,fromdefaults"`
+	// Shared by all concurrent steps, 0 means unlimited.
+	BandwidthLimit Bandwidth `yaml:"bandwidth_limit,optional"`
+	// Fail a step if zfs send produces no data for this long, 0 disables stall detection.
+	SendStallTimeout time.Duration `yaml:"send_stall_timeout,optional"`
+	// Abort and retry a step if it moves no data for this long, 0 disables the step watchdog.
+	StepProgressTimeout time.Duration `yaml:"step_progress_timeout,optional"`
+	BusyReceiver *BusyReceiverOptions `yaml:"busy_receiver,optional,fromdefaults"`
+	// Hold the snapshots of multi-step replications on the sender until they are sent.
+	StepHolds bool `yaml:"step_holds,optional,default=true"`
+	// What to do if the receiver has diverged from the sender: fail, rollback or rename.
+	ConflictPolicy string `yaml:"conflict_policy,optional,default=fail"`
+	// What to replicate of filesystems the receiver does not have yet: all, most_recent or fail.
+	InitialReplication string `yaml:"initial_replication,optional,default=most_recent"`
+}
+
+// BusyReceiverOptions apply while the receiving pool is busy with a scrub or resilver.
+type BusyReceiverOptions struct {
+	// Skip periodic runs, runs triggered by zrepl signal wakeup still happen.
+	Defer bool `yaml:"defer,optional,default=false"`
+	// Replaces bandwidth_limit, 0 means no change.
+	BandwidthLimit Bandwidth `yaml:"bandwidth_limit,optional"`
+}
+
+type ReplicationConcurrency struct {
+	// Number of replication steps (zfs send | zfs recv) that run in parallel.
+	Steps int `yaml:"steps,optional,default=1"`
+	// Number of filesystems whose replication is planned in parallel, 0 means the same as Steps.
+	Planning int `yaml:"planning,optional"`
+}
+
+// Bandwidth in bytes per second, e.g. `100MiB` or `1.5G` (binary prefixes).
+type Bandwidth int64
+
+func (b *Bandwidth) UnmarshalYAML(u func(interface{}, bool) error) (err error) {
+	var in string
+	if err := u(&in, true); err != nil {
+		return err
+	}
+	v, err := parseBandwidth(in)
+	if err != nil {
+		return err
+	}
+	*b = v
+	return nil
+}
+
+// DataSize is a number of bytes, specified like a Bandwidth without the /s suffix, e.g. 64MiB.
+type DataSize int64
+
+func (d *DataSize) UnmarshalYAML(u func(interface{}, bool) error) (err error) {
+	var in string
+	if err := u(&in, true); err != nil {
+		return err
+	}
+	if strings.HasSuffix(strings.TrimSpace(in), "/s") {
+		return fmt.Errorf("size must not be a rate: %q", in)
+	}
+	v, err := parseBandwidth(in)
+	if err != nil {
+		return err
+	}
+	*d = DataSize(v)
+	return nil
+}
+
+var bandwidthRegex = regexp.MustCompile(`^\s*(\d+(?:\.\d+)?)\s*([KMGT]?)(?:i?B)?(?:/s)?\s*$`)
+
+func parseBandwidth(s string) (Bandwidth, error) {
+	comps := bandwidthRegex.FindStringSubmatch(s)
+	if comps == nil {
+		return 0, fmt.Errorf("bandwidth must be a number followed by an optional unit, e.g. 100MiB: %q", s)
+	}
+	v, err := strconv.ParseFloat(comps[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	for _, prefix := range "KMGT" {
+		if comps[2] == "" {
+			break
+		}
+		v *= 1024
+		if string(prefix) == comps[2] {
+			break
+		}
+	}
+	return Bandwidth(v), nil
+}
+
+type SendOptions struct {
+	// Send encrypted filesystems as they are stored on disk (zfs send -w).
+	Raw bool `yaml:"raw,optional,default=false"`
+	// Like Raw, but refuse to replicate filesystems that are not encrypted.
+	Encrypted bool `yaml:"encrypted,optional,default=false"`
+	// zfs send -c
+	Compressed bool `yaml:"compressed,optional,default=false"`
+	// zfs send -L
+	LargeBlocks bool `yaml:"large_blocks,optional,default=false"`
+	// zfs send -e
+	EmbeddedData bool `yaml:"embedded_data,optional,default=false"`
+	// Copy each send stream to a file in this directory, or, if prefixed with "|", to the stdin of this command.
+	TeeTo string `yaml:"tee_to,optional"`
+	// "fail" the step or "continue" without copy if the copy fails
+	TeeOnError string `yaml:"tee_on_error,optional,default=fail"`
+}
+
+type RecvOptions struct {
+	// Additional flags passed through to zfs recv.
+	// Only flags in zfs.RecvPassThroughFlags are accepted.
+	Flags []string `yaml:"flags,optional"`
+	Properties RecvProperties `yaml:"properties,optional"`
+	// run after a filesystem received a new snapshot
+	Hooks []HookCommand `yaml:"hooks,optional"`
+	Quota RecvQuota     `yaml:"quota,optional"`
+	// Properties that the sync_properties of a sink's clients may set, none if empty.
+	// Property names or user property namespaces followed by *, e.g. com.example:*.
+	AcceptProperties []string `yaml:"accept_properties,optional"`
+}
+
+// RecvQuota limits what the receiver accepts, zero values mean no limit.
+type RecvQuota struct {
+	MaxFilesystems  int      `yaml:"max_filesystems,optional"`
+	MaxUsed         DataSize `yaml:"max_used,optional"`
+	MaxPoolCapacity int      `yaml:"max_pool_capacity,optional"`
+}
+
+type RecvProperties struct {
+	// zfs recv -o name=value
+	Override map[string]string `yaml:"override,optional"`
+	// zfs recv -x name
+	Inherit []string `yaml:"inherit,optional"`
 }
 
 type SourceJob struct {
 	PassiveJob `yaml:",inline"`
 	Snapshotting SnapshottingEnum      `yaml:"snapshotting"`
 	Filesystems FilesystemsFilter `yaml:"filesystems"`
+	// By client identity, further restricts the filesystems exposed to that client.
+	ClientFilesystems map[string]FilesystemsFilter `yaml:"client_filesystems,optional"`
+	SnapshotFilter *SnapshotFilter `yaml:"snapshot_filter,optional"`
 }
 
 type FilesystemsFilter map[string]bool
 
+// SnapshotFilter restricts the snapshots that a sender exposes for replication, e.g. to skip those of other tools.
+// A snapshot is exposed if its name has Prefix and matches Regex, empty values match all names.
+type SnapshotFilter struct {
+	Prefix string `yaml:"prefix,optional"`
+	Regex  string `yaml:"regex,optional"`
+}
+
 type SnapshottingEnum struct {
 	Ret interface{}
 }
 
 type SnapshottingPeriodic struct {
 	Type string		`yaml:"type"`
-	Prefix string	`yaml:"prefix"`
+	Prefix string	`yaml:"prefix,optional"`
+	NameFormat string `yaml:"name_format,optional"`
+	Timezone string `yaml:"timezone,optional,default=UTC"`
 	Interval time.Duration `yaml:"interval,positive"`
+	Align bool `yaml:"align,optional,default=false"`
+	NoMatchingSnapshots string `yaml:"no_matching_snapshots,optional,default=warn"`
+	Missed string `yaml:"missed,optional,default=run_once"`
+	Hooks []HookCommand `yaml:"hooks,optional"`
+}
+
+type SnapshottingCron struct {
+	Type string		`yaml:"type"`
+	Prefix string	`yaml:"prefix,optional"`
+	NameFormat string `yaml:"name_format,optional"`
+	Timezone string `yaml:"timezone,optional,default=UTC"`
+	Cron string `yaml:"cron"`
+	NoMatchingSnapshots string `yaml:"no_matching_snapshots,optional,default=warn"`
+	Missed string `yaml:"missed,optional,default=run_once"`
+	Hooks []HookCommand `yaml:"hooks,optional"`
+}
+
+type HookCommand struct {
+	Path        string            `yaml:"path"`
+	Timeout     time.Duration     `yaml:"timeout,optional,positive,default=30s"`
+	ErrIsFatal  bool              `yaml:"err_is_fatal,optional,default=false"`
+	Filesystems FilesystemsFilter `yaml:"filesystems,optional"`
+}
+
+// JobNotify lists the notifiers that receive the report of an invocation of a job.
+type JobNotify struct {
+	OnError   []NotifierEnum `yaml:"on_error,optional"`
+	OnSuccess []NotifierEnum `yaml:"on_success,optional"`
+}
+
+type NotifierEnum struct {
+	Ret interface{}
+}
+
+type NotifierCommand struct {
+	Type    string        `yaml:"type"`
+	Path    string        `yaml:"path"`
+	Timeout time.Duration `yaml:"timeout,optional,positive,default=30s"`
+}
+
+type NotifierWebhook struct {
+	Type    string            `yaml:"type"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers,optional"`
+	Timeout time.Duration     `yaml:"timeout,optional,positive,default=30s"`
 }
 
 type SnapshottingManual struct {
 	Type string `yaml:"type"`
+	// if positive, watch for snapshots created by other tools and trigger replication when new ones appear
+	PollInterval time.Duration `yaml:"poll_interval,optional"`
+	// only consider snapshots with this prefix when watching, empty matches all snapshots
+	Prefix string `yaml:"prefix,optional"`
 }
 
 type PruningSenderReceiver struct {
 	KeepSender   []PruningEnum `yaml:"keep_sender"`
 	KeepReceiver []PruningEnum `yaml:"keep_receiver"`
+	// Rules for bookmarks, which are not pruned if empty.
+	KeepSenderBookmarks   []PruningEnum `yaml:"keep_sender_bookmarks,optional"`
+	KeepReceiverBookmarks []PruningEnum `yaml:"keep_receiver_bookmarks,optional"`
+	Window       *PruningWindow `yaml:"window,optional"`
+}
+
+// Daily time window in local time (HH:MM) during which snapshots may be destroyed.
+// If To is before From, the window spans midnight.
+type PruningWindow struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
 }
 
 type PruningLocal struct {
@@ -131,6 +376,9 @@ type Global struct {
 	Control    *GlobalControl         `yaml:"control,optional,fromdefaults"`
 	Serve      *GlobalServe           `yaml:"serve,optional,fromdefaults"`
 	RPC        *RPCConfig             `yaml:"rpc,optional,fromdefaults"`
+	Maintenance *GlobalMaintenance    `yaml:"maintenance,optional,fromdefaults"`
+	ZFS        *GlobalZFS             `yaml:"zfs,optional,fromdefaults"`
+	State      *GlobalState           `yaml:"state,optional,fromdefaults"`
 }
 
 func Default(i interface{}) {
@@ -168,16 +416,19 @@ type TCPConnect struct {
 	ConnectCommon `yaml:",inline"`
 	Address       string        `yaml:"address"`
 	DialTimeout   time.Duration `yaml:"dial_timeout,positive,default=10s"`
+	KeepAlive     time.Duration `yaml:"keepalive,optional,default=30s"`
 }
 
 type TLSConnect struct {
-	ConnectCommon `yaml:",inline"`
-	Address       string        `yaml:"address"`
-	Ca            string        `yaml:"ca"`
-	Cert          string        `yaml:"cert"`
-	Key           string        `yaml:"key"`
-	ServerCN      string        `yaml:"server_cn"`
-	DialTimeout   time.Duration `yaml:"dial_timeout,positive,default=10s"`
+	ConnectCommon    `yaml:",inline"`
+	Address          string        `yaml:"address"`
+	Ca               string        `yaml:"ca"`
+	Cert             string        `yaml:"cert"`
+	Key              string        `yaml:"key"`
+	ServerCN         string        `yaml:"server_cn"`
+	DialTimeout      time.Duration `yaml:"dial_timeout,positive,default=10s"`
+	KeepAlive        time.Duration `yaml:"keepalive,optional,default=30s"`
+	HandshakeTimeout time.Duration `yaml:"handshake_timeout,positive,default=10s"`
 }
 
 type SSHStdinserverConnect struct {
@@ -192,6 +443,12 @@ type SSHStdinserverConnect struct {
 	DialTimeout          time.Duration `yaml:"dial_timeout,positive,default=10s"`
 }
 
+type UnixConnect struct {
+	ConnectCommon `yaml:",inline"`
+	Path          string        `yaml:"path"`
+	DialTimeout   time.Duration `yaml:"dial_timeout,positive,default=10s"`
+}
+
 type LocalConnect struct {
 	ConnectCommon `yaml:",inline"`
 	ListenerName string `yaml:"listener_name"`
@@ -203,24 +460,34 @@ type ServeEnum struct {
 }
 
 type ServeCommon struct {
-	Type string     `yaml:"type"`
-	RPC  *RPCConfig `yaml:"rpc,optional"`
+	Type   string            `yaml:"type"`
+	RPC    *RPCConfig        `yaml:"rpc,optional"`
+	Access []ServeAccessRule `yaml:"access,optional"`
+}
+
+type ServeAccessRule struct {
+	ClientIdentities []string `yaml:"client_identities,optional"`
+	Networks         []string `yaml:"networks,optional"`
 }
 
 type TCPServe struct {
-	ServeCommon `yaml:",inline"`
-	Listen      string            `yaml:"listen"`
-	Clients     map[string]string `yaml:"clients"`
+	ServeCommon  `yaml:",inline"`
+	Listen       string            `yaml:"listen,optional"`
+	ListenFDName string            `yaml:"listen_fd_name,optional"`
+	Clients      map[string]string `yaml:"clients"`
+	KeepAlive    time.Duration     `yaml:"keepalive,optional,default=30s"`
 }
 
 type TLSServe struct {
 	ServeCommon      `yaml:",inline"`
-	Listen           string        `yaml:"listen"`
+	Listen           string        `yaml:"listen,optional"`
+	ListenFDName     string        `yaml:"listen_fd_name,optional"`
 	Ca               string        `yaml:"ca"`
 	Cert             string        `yaml:"cert"`
 	Key              string        `yaml:"key"`
 	ClientCNs        []string      `yaml:"client_cns"`
 	HandshakeTimeout time.Duration `yaml:"handshake_timeout,positive,default=10s"`
+	KeepAlive        time.Duration `yaml:"keepalive,optional,default=30s"`
 }
 
 type StdinserverServer struct {
@@ -228,6 +495,13 @@ type StdinserverServer struct {
 	ClientIdentities []string `yaml:"client_identities"`
 }
 
+type UnixServe struct {
+	ServeCommon    `yaml:",inline"`
+	Path           string `yaml:"path,optional"`
+	ListenFDName   string `yaml:"listen_fd_name,optional"`
+	ClientIdentity string `yaml:"client_identity"`
+}
+
 type LocalServe struct {
 	ServeCommon `yaml:",inline"`
 	ListenerName string `yaml:"listener_name"`
@@ -258,9 +532,11 @@ type LoggingOutletEnum struct {
 }
 
 type LoggingOutletCommon struct {
-	Type   string `yaml:"type"`
-	Level  string `yaml:"level"`
-	Format string `yaml:"format"`
+	Type       string            `yaml:"type"`
+	Level      string            `yaml:"level"`
+	Format     string            `yaml:"format"`
+	Jobs       map[string]string `yaml:"jobs,optional"`
+	Subsystems map[string]string `yaml:"subsystems,optional"`
 }
 
 type StdoutLoggingOutlet struct {
@@ -274,11 +550,24 @@ type SyslogLoggingOutlet struct {
 	RetryInterval       time.Duration `yaml:"retry_interval,positive,default=10s"`
 }
 
+// JournaldLoggingOutlet submits entries to journald with their fields as journal fields,
+// hence it has no format.
+type JournaldLoggingOutlet struct {
+	Type          string            `yaml:"type"`
+	Level         string            `yaml:"level"`
+	Jobs          map[string]string `yaml:"jobs,optional"`
+	Subsystems    map[string]string `yaml:"subsystems,optional"`
+	Socket        string            `yaml:"socket,optional,default=/run/systemd/journal/socket"`
+	RetryInterval time.Duration     `yaml:"retry_interval,positive,default=10s"`
+}
+
 type TCPLoggingOutlet struct {
 	LoggingOutletCommon `yaml:",inline"`
 	Address             string               `yaml:"address"`
 	Net                 string               `yaml:"net,default=tcp"`
 	RetryInterval       time.Duration        `yaml:"retry_interval,positive,default=10s"`
+	BufferSize          int                  `yaml:"buffer_size,optional,default=1000"`
+	Overflow            string               `yaml:"overflow,optional,default=drop_oldest"`
 	TLS                 *TCPLoggingOutletTLS `yaml:"tls,optional"`
 }
 
@@ -297,24 +586,93 @@ type PrometheusMonitoring struct {
 	Listen string `yaml:"listen"`
 }
 
+// SnapshotMonitoring periodically checks per-filesystem invariants on this host.
+type SnapshotMonitoring struct {
+	Type     string           `yaml:"type"`
+	Interval time.Duration    `yaml:"interval,optional,positive,default=5m"`
+	Checks   []*SnapshotCheck `yaml:"checks"`
+}
+
+// SnapshotCheck applies to the filesystems matched by Filesystems, zero values disable an invariant.
+type SnapshotCheck struct {
+	Name        string            `yaml:"name"`
+	Filesystems FilesystemsFilter `yaml:"filesystems"`
+	// Only snapshots with this prefix are considered for NewestSnapshotMaxAge and MaxSnapshots.
+	SnapshotPrefix       string        `yaml:"snapshot_prefix,optional"`
+	NewestSnapshotMaxAge time.Duration `yaml:"newest_snapshot_max_age,optional"`
+	// Maximum age of the most recent snapshot replicated from this host, i.e. of the replication cursor.
+	ReplicationMaxLag time.Duration `yaml:"replication_max_lag,optional"`
+	MaxSnapshots      int           `yaml:"max_snapshots,optional"`
+}
+
 type GlobalControl struct {
 	SockPath string `yaml:"sockpath,default=/var/run/zrepl/control"`
+	// number of past invocations per job that can be queried over the control socket, 0 means history.DefaultSize
+	History int `yaml:"history,optional,default=20"`
+}
+
+type GlobalMaintenance struct {
+	// zero disables the daemon's periodic removal of stale zrepl bookmarks
+	Interval time.Duration `yaml:"interval,optional"`
+}
+
+type GlobalState struct {
+	// directory in which the daemon keeps state that survives restarts, e.g. the last successful run of each job phase
+	Dir string `yaml:"dir,optional,default=/var/lib/zrepl"`
+}
+
+type GlobalZFS struct {
+	// increment passed to nice(1) for every zfs and zpool command, zero disables nice
+	Nice int `yaml:"nice,optional"`
+	// idle, best-effort or realtime; empty disables ionice
+	IONiceClass string `yaml:"ionice_class,optional"`
+	IONiceLevel int    `yaml:"ionice_level,optional"`
+	// zero means unlimited, zfs send and recv are not counted
+	MaxConcurrentCommands int `yaml:"max_concurrent_commands,optional"`
+	// cli or lzc (libzfs_core, requires a binary built with the lzc build tag)
+	Backend string `yaml:"backend,optional,default=cli"`
+	// maximum number of snapshots destroyed by one zfs destroy, 0 or 1 destroys them one at a time
+	DestroyBatchSize int `yaml:"destroy_batch_size,optional,default=100"`
+	// the daemon runs unprivileged with zfs allow delegations, see zfs.ExecConfig.Delegated
+	Delegated bool `yaml:"delegated,optional"`
 }
 
 type GlobalServe struct {
 	StdinServer *GlobalStdinServer `yaml:"stdinserver,optional,fromdefaults"`
+	HTTPPprof   *GlobalHTTPPprof   `yaml:"http_pprof,optional"`
+}
+
+// GlobalHTTPPprof exposes net/http/pprof and expvar runtime metrics on Listen.
+type GlobalHTTPPprof struct {
+	Listen string `yaml:"listen"`
+	// required to listen on other than loopback addresses
+	AllowNonLocal bool `yaml:"allow_non_local,optional,default=false"`
 }
 
 type GlobalStdinServer struct {
 	SockDir string `yaml:"sockdir,default=/var/run/zrepl/stdinserver"`
 }
 
+// JobLogging applies to the entries of a job in every logging outlet.
+type JobLogging struct {
+	// Minimum level of the job's entries, unless the outlet's jobs map sets one; empty means the outlet's level.
+	Level string `yaml:"level,optional"`
+}
+
 type JobDebugSettings struct {
 	Conn *struct {
 		ReadDump  string `yaml:"read_dump"`
 		WriteDump string `yaml:"write_dump"`
 	} `yaml:"conn,optional"`
 	RPCLog bool `yaml:"rpc_log,optional,default=false"`
+	Journal *JobDebugJournal `yaml:"journal,optional"`
+}
+
+type JobDebugJournal struct {
+	// directory of the journal's files
+	Path string `yaml:"path"`
+	// 0 means the default, see journal.DefaultMaxSize
+	MaxSize DataSize `yaml:"max_size,optional"`
 }
 
 func enumUnmarshal(u func(interface{}, bool) error, types map[string]interface{}) (interface{}, error) {
@@ -353,6 +711,7 @@ func (t *ConnectEnum) UnmarshalYAML(u func(interface{}, bool) error) (err error)
 		"tcp":             &TCPConnect{},
 		"tls":             &TLSConnect{},
 		"ssh+stdinserver": &SSHStdinserverConnect{},
+		"unix":            &UnixConnect{},
 		"local": 		   &LocalConnect{},
 	})
 	return
@@ -363,6 +722,7 @@ func (t *ServeEnum) UnmarshalYAML(u func(interface{}, bool) error) (err error) {
 		"tcp":         &TCPServe{},
 		"tls":         &TLSServe{},
 		"stdinserver": &StdinserverServer{},
+		"unix":        &UnixServe{},
 		"local"      : &LocalServe{},
 	})
 	return
@@ -381,16 +741,26 @@ func (t *PruningEnum) UnmarshalYAML(u func(interface{}, bool) error) (err error)
 func (t *SnapshottingEnum) UnmarshalYAML(u func(interface{}, bool) error) (err error) {
 	t.Ret, err = enumUnmarshal(u, map[string]interface{}{
 		"periodic": &SnapshottingPeriodic{},
+		"cron": &SnapshottingCron{},
 		"manual": &SnapshottingManual{},
 	})
 	return
 }
 
+func (t *NotifierEnum) UnmarshalYAML(u func(interface{}, bool) error) (err error) {
+	t.Ret, err = enumUnmarshal(u, map[string]interface{}{
+		"command": &NotifierCommand{},
+		"webhook": &NotifierWebhook{},
+	})
+	return
+}
+
 func (t *LoggingOutletEnum) UnmarshalYAML(u func(interface{}, bool) error) (err error) {
 	t.Ret, err = enumUnmarshal(u, map[string]interface{}{
 		"stdout": &StdoutLoggingOutlet{},
 		"syslog": &SyslogLoggingOutlet{},
 		"tcp":    &TCPLoggingOutlet{},
+		"journald": &JournaldLoggingOutlet{},
 	})
 	return
 }
@@ -398,6 +768,7 @@ func (t *LoggingOutletEnum) UnmarshalYAML(u func(interface{}, bool) error) (err
 func (t *MonitoringEnum) UnmarshalYAML(u func(interface{}, bool) error) (err error) {
 	t.Ret, err = enumUnmarshal(u, map[string]interface{}{
 		"prometheus": &PrometheusMonitoring{},
+		"snapshots":  &SnapshotMonitoring{},
 	})
 	return
 }
@@ -407,22 +778,28 @@ var ConfigFileDefaultLocations = []string{
 	"/usr/local/etc/zrepl/zrepl.yml",
 }
 
+// ResolveConfigPath returns path, or the first of ConfigFileDefaultLocations that exists if path is empty.
+func ResolveConfigPath(path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	for _, l := range ConfigFileDefaultLocations {
+		stat, statErr := os.Stat(l)
+		if statErr != nil {
+			continue
+		}
+		if !stat.Mode().IsRegular() {
+			return "", errors.Errorf("file at default location is not a regular file: %s", l)
+		}
+		return l, nil
+	}
+	return "", nil
+}
+
 func ParseConfig(path string) (i *Config, err error) {
 
-	if path == "" {
-		// Try default locations
-		for _, l := range ConfigFileDefaultLocations {
-			stat, statErr := os.Stat(l)
-			if statErr != nil {
-				continue
-			}
-			if !stat.Mode().IsRegular() {
-				err = errors.Errorf("file at default location is not a regular file: %s", l)
-				return
-			}
-			path = l
-			break
-		}
+	if path, err = ResolveConfigPath(path); err != nil {
+		return
 	}
 
 	var bytes []byte
@@ -431,10 +808,17 @@ func ParseConfig(path string) (i *Config, err error) {
 		return
 	}
 
-	return ParseConfigBytes(bytes)
+	return parseConfigFile(path, bytes)
 }
 
 func ParseConfigBytes(bytes []byte) (*Config, error) {
+	migrated, warnings, err := MigrateConfigBytes(bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot migrate config from old format")
+	}
+	if migrated != nil {
+		bytes = migrated
+	}
 	var c *Config
 	if err := yaml.UnmarshalStrict(bytes, &c); err != nil {
 		return nil, err
@@ -442,6 +826,7 @@ func ParseConfigBytes(bytes []byte) (*Config, error) {
 	if c == nil {
 		return nil, fmt.Errorf("config is empty or only consists of comments")
 	}
+	c.MigrationWarnings = warnings
 	return c, nil
 }
 
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestJobDebugJournal(t *testing.T) {
+	tmpl := `
+jobs:
+- type: sink
+  name: "laptop_sink"
+  root_fs: "pool2/backup_laptops"
+  serve:
+    type: local
+    listener_name: localsink
+  debug:
+    journal:
+      path: /var/lib/zrepl/journal/laptop_sink
+%s
+`
+	conf := testValidConfig(t, fmt.Sprintf(tmpl, ""))
+	journal := conf.Jobs[0].Ret.(*SinkJob).Debug.Journal
+	require.NotNil(t, journal)
+	assert.Equal(t, "/var/lib/zrepl/journal/laptop_sink", journal.Path)
+	assert.Equal(t, DataSize(0), journal.MaxSize)
+
+	conf = testValidConfig(t, fmt.Sprintf(tmpl, "      max_size: 16MiB"))
+	assert.Equal(t, DataSize(16<<20), conf.Jobs[0].Ret.(*SinkJob).Debug.Journal.MaxSize)
+
+	_, err := testConfig(t, fmt.Sprintf(tmpl, "      max_size: 16MiB/s"))
+	assert.Error(t, err)
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/zrepl/yaml-config"
 	"testing"
+	"time"
 )
 
 func testValidGlobalSection(t *testing.T, s string) *Config {
@@ -30,8 +31,12 @@ func TestOutletTypes(t *testing.T) {
 global:
   logging:
   - type: stdout
-    level: debug
+    level: info
     format: human
+    jobs:
+      prod_to_backups: debug
+    subsystems:
+      rpc: warn
   - type: syslog
     level: info
     retry_interval: 20s
@@ -49,9 +54,17 @@ global:
       ca: /etc/zrepl/log/ca.crt
       cert: /etc/zrepl/log/key.pem
       key: /etc/zrepl/log/cert.pem
+  - type: journald
+    level: info
 `)
-	assert.Equal(t, 4, len(*conf.Global.Logging))
+	assert.Equal(t, 5, len(*conf.Global.Logging))
 	assert.NotNil(t, (*conf.Global.Logging)[3].Ret.(*TCPLoggingOutlet).TLS)
+	stdout := (*conf.Global.Logging)[0].Ret.(*StdoutLoggingOutlet)
+	assert.Equal(t, map[string]string{"prod_to_backups": "debug"}, stdout.Jobs)
+	assert.Equal(t, map[string]string{"rpc": "warn"}, stdout.Subsystems)
+	journald := (*conf.Global.Logging)[4].Ret.(*JournaldLoggingOutlet)
+	assert.Equal(t, "/run/systemd/journal/socket", journald.Socket)
+	assert.Equal(t, 10*time.Second, journald.RetryInterval)
 }
 
 func TestDefaultLoggingOutlet(t *testing.T) {
@@ -72,6 +85,58 @@ global:
 	assert.Equal(t, ":9091", conf.Global.Monitoring[0].Ret.(*PrometheusMonitoring).Listen)	
 }
 
+func TestHTTPPprof(t *testing.T) {
+	conf := testValidGlobalSection(t, `
+global:
+  serve:
+    http_pprof:
+      listen: '127.0.0.1:6060'
+`)
+	assert.Equal(t, "127.0.0.1:6060", conf.Global.Serve.HTTPPprof.Listen)
+	assert.False(t, conf.Global.Serve.HTTPPprof.AllowNonLocal)
+	assert.Equal(t, "/var/run/zrepl/stdinserver", conf.Global.Serve.StdinServer.SockDir)
+
+	conf = testValidGlobalSection(t, "")
+	assert.Nil(t, conf.Global.Serve.HTTPPprof)
+}
+
+func TestControlHistory(t *testing.T) {
+	conf := testValidGlobalSection(t, "")
+	assert.Equal(t, "/var/run/zrepl/control", conf.Global.Control.SockPath)
+	assert.Equal(t, 20, conf.Global.Control.History)
+
+	conf = testValidGlobalSection(t, `
+global:
+  control:
+    history: 100
+`)
+	assert.Equal(t, 100, conf.Global.Control.History)
+}
+
+func TestSnapshotMonitoring(t *testing.T) {
+	conf := testValidGlobalSection(t, `
+global:
+  monitoring:
+    - type: snapshots
+      checks:
+        - name: data
+          filesystems: {"pool/data<": true}
+          snapshot_prefix: zrepl_
+          newest_snapshot_max_age: 2h
+          replication_max_lag: 4h
+          max_snapshots: 500
+`)
+	m := conf.Global.Monitoring[0].Ret.(*SnapshotMonitoring)
+	assert.Equal(t, 5*time.Minute, m.Interval)
+	require.Len(t, m.Checks, 1)
+	c := m.Checks[0]
+	assert.Equal(t, "data", c.Name)
+	assert.Equal(t, "zrepl_", c.SnapshotPrefix)
+	assert.Equal(t, 2*time.Hour, c.NewestSnapshotMaxAge)
+	assert.Equal(t, 4*time.Hour, c.ReplicationMaxLag)
+	assert.Equal(t, 500, c.MaxSnapshots)
+}
+
 func TestLoggingOutletEnumList_SetDefaults(t *testing.T) {
 	e := &LoggingOutletEnumList{}
 	var i yaml.Defaulter = e
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "zrepl-config-include")
+	require.NoError(t, err)
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, ioutil.WriteFile(p, []byte(content), 0644))
+	}
+	return dir
+}
+
+const includeTestMain = `
+include: jobs.d/*.yml
+defaults:
+  connect:
+    type: tcp
+    address: "backup.example.com:8888"
+  serve:
+    type: tcp
+    listen: ":8888"
+    clients: {"10.0.0.1": "client1"}
+  pruning:
+    keep_sender:
+    - type: not_replicated
+    keep_receiver:
+    - type: last_n
+      count: 10
+  logging:
+    level: info
+jobs:
+- name: main
+  type: push
+  filesystems: {"pool/a<": true}
+  snapshotting:
+    type: manual
+`
+
+func TestIncludeAndDefaults(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"zrepl.yml": includeTestMain,
+		"jobs.d/10-push.yml": `
+jobs:
+- name: included
+  type: push
+  filesystems: {"pool/b<": true}
+  connect:
+    address: "other.example.com:8888"
+  snapshotting:
+    type: manual
+  logging:
+    level: debug
+  pruning:
+    keep_receiver:
+    - type: last_n
+      count: 3
+`,
+		"jobs.d/20-sink.yml": `
+jobs:
+- name: sink
+  type: sink
+  root_fs: "pool/sink"
+  serve:
+    type: local
+    listener_name: localsink
+`,
+		"jobs.d/ignored.yaml": `this is not a job file`,
+	})
+	defer os.RemoveAll(dir)
+
+	c, err := ParseConfig(filepath.Join(dir, "zrepl.yml"))
+	require.NoError(t, err)
+	require.Len(t, c.Jobs, 3)
+
+	first := c.Jobs[0].Ret.(*PushJob)
+	assert.Equal(t, "main", first.Name)
+	assert.Equal(t, "backup.example.com:8888", first.Connect.Ret.(*TCPConnect).Address)
+	assert.Len(t, first.Pruning.KeepSender, 1)
+	assert.Equal(t, 10, first.Pruning.KeepReceiver[0].Ret.(*PruneKeepLastN).Count)
+	assert.Equal(t, "info", c.Jobs[0].Logging().Level)
+
+	// mappings are merged, lists are replaced
+	included := c.Jobs[1].Ret.(*PushJob)
+	assert.Equal(t, "included", included.Name)
+	assert.Equal(t, "other.example.com:8888", included.Connect.Ret.(*TCPConnect).Address)
+	assert.Len(t, included.Pruning.KeepSender, 1)
+	assert.Equal(t, 3, included.Pruning.KeepReceiver[0].Ret.(*PruneKeepLastN).Count)
+	assert.Equal(t, "debug", c.Jobs[1].Logging().Level)
+
+	// a serve section of another type replaces the default, pruning does not apply to sinks
+	sink := c.Jobs[2].Ret.(*SinkJob)
+	assert.Equal(t, "localsink", sink.Serve.Ret.(*LocalServe).ListenerName)
+	assert.Equal(t, "info", sink.Logging.Level)
+}
+
+func TestIncludeErrorsNameTheFile(t *testing.T) {
+	tcs := []struct {
+		name     string
+		included string
+		contains []string
+	}{
+		{
+			name: "invalid job",
+			included: `
+jobs:
+- name: broken
+  type: push
+  filesystems: {"pool/b<": true}
+  snapshotting:
+    type: manual
+  unknown_key: true
+`,
+			contains: []string{"broken.yml", `job "broken"`, "zrepl.yml"},
+		},
+		{
+			name: "duplicate name",
+			included: `
+jobs:
+- name: main
+  type: push
+  filesystems: {"pool/b<": true}
+  snapshotting:
+    type: manual
+`,
+			contains: []string{"broken.yml", `job "main"`, "already used"},
+		},
+		{
+			name:     "not only jobs",
+			included: "global: {}\njobs: []\n",
+			contains: []string{"broken.yml", "only contain jobs"},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := writeConfigFiles(t, map[string]string{
+				"zrepl.yml":         includeTestMain,
+				"jobs.d/broken.yml": tc.included,
+			})
+			defer os.RemoveAll(dir)
+			_, err := ParseConfig(filepath.Join(dir, "zrepl.yml"))
+			require.Error(t, err)
+			for _, s := range tc.contains {
+				assert.Contains(t, err.Error(), s)
+			}
+		})
+	}
+}
+
+func TestDefaultsWithUnknownKey(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"zrepl.yml": `
+defaults:
+  conect:
+    type: tcp
+jobs: []
+`,
+	})
+	defer os.RemoveAll(dir)
+	_, err := ParseConfig(filepath.Join(dir, "zrepl.yml"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `no job type has key "conect"`)
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestMigratePullAndSource(t *testing.T) {
+	c := testValidConfig(t, `
+global:
+  logging:
+  - outlet: stdout
+    level: warn
+    format: human
+jobs:
+- name: pull_prod1
+  type: pull
+  connect:
+    type: ssh+stdinserver
+    host: prod1.example.com
+    user: root
+    port: 22
+    identity_file: /root/.ssh/id_ed25519
+  interval: 10m
+  mapping: {
+    "<": "storage/zrepl/pull/prod1",
+    "zroot/var/db<": "fast/zrepl/pull/prod1/db",
+  }
+  initial_repl_policy: most_recent
+  snapshot_prefix: zrepl_
+  prune:
+    policy: grid
+    grid: 1x1h(keep=all) | 24x1h | 35x1d | 6x30d
+- name: source_prod1
+  type: source
+  serve:
+    type: stdinserver
+    client_identity: backup-srv.example.com
+  filesystems: {
+    "zroot/var/db<": true,
+  }
+  snapshot_prefix: zrepl_
+  interval: 10m
+  prune:
+    policy: grid
+    grid: 1x1d(keep=all)
+    keep_bookmarks: 144
+`)
+	assert.NotEmpty(t, c.MigrationWarnings)
+
+	require.IsType(t, &StdoutLoggingOutlet{}, (*c.Global.Logging)[0].Ret)
+
+	pull := c.Jobs[0].Ret.(*PullJob)
+	assert.Equal(t, "storage/zrepl/pull/prod1", pull.RootFS)
+	assert.Equal(t, []RootFSMappingRule{{Sender: "zroot/var/db", RootFS: "fast/zrepl/pull/prod1/db"}}, pull.RootFSMapping)
+	require.Len(t, pull.Pruning.KeepReceiver, 2)
+	grid := pull.Pruning.KeepReceiver[0].Ret.(*PruneGrid)
+	assert.Equal(t, "^zrepl_", grid.Regex)
+	notPrefixed := pull.Pruning.KeepReceiver[1].Ret.(*PruneKeepRegex)
+	assert.True(t, notPrefixed.Negate)
+	require.Len(t, pull.Pruning.KeepSender, 1)
+	assert.Equal(t, ".*", pull.Pruning.KeepSender[0].Ret.(*PruneKeepRegex).Regex)
+
+	source := c.Jobs[1].Ret.(*SourceJob)
+	assert.Equal(t, []string{"backup-srv.example.com"}, source.Serve.Ret.(*StdinserverServer).ClientIdentities)
+	snap := source.Snapshotting.Ret.(*SnapshottingPeriodic)
+	assert.Equal(t, "zrepl_", snap.Prefix)
+	assert.Equal(t, 10*time.Minute, snap.Interval)
+}
+
+func TestMigrateCurrentConfigIsUntouched(t *testing.T) {
+	out, warnings, err := MigrateConfigBytes([]byte(`
+jobs:
+- name: sink
+  type: sink
+  serve:
+    type: stdinserver
+    client_identities: ["laptop"]
+  root_fs: "pool2/backup"
+`))
+	assert.NoError(t, err)
+	assert.Nil(t, out)
+	assert.Empty(t, warnings)
+}
+
+func TestMigrateUnsupported(t *testing.T) {
+	for _, job := range []string{`
+- name: local
+  type: local
+  mapping: {"zroot/var/db<": "storage/db"}
+  snapshot_prefix: zrepl_
+  interval: 10m
+`, `
+- name: pull
+  type: pull
+  connect: {type: tcp, address: "localhost:8888"}
+  interval: 10m
+  mapping: {"zroot/var/db": "storage/db"}
+`} {
+		_, _, err := MigrateConfigBytes([]byte("jobs:" + job))
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestJobNotify(t *testing.T) {
+	tmpl := `
+jobs:
+- name: foo
+  type: push
+  connect:
+    type: local
+    listener_name: foo
+    client_identity: bar
+  filesystems: {"<": true}
+  snapshotting:
+    type: manual
+  pruning:
+    keep_sender:
+    - type: last_n
+      count: 10
+    keep_receiver:
+    - type: last_n
+      count: 10
+%s
+`
+	fill := func(s string) string { return fmt.Sprintf(tmpl, s) }
+
+	c := testValidConfig(t, fill(""))
+	n := c.Jobs[0].Ret.(*PushJob).Notify
+	assert.Empty(t, n.OnError)
+	assert.Empty(t, n.OnSuccess)
+
+	c = testValidConfig(t, fill(`
+  notify:
+    on_error:
+    - type: command
+      path: /usr/local/bin/page-oncall
+    - type: webhook
+      url: https://hooks.example.com/zrepl
+      headers:
+        Authorization: "Bearer s3cret"
+      timeout: 5s
+    on_success:
+    - type: webhook
+      url: https://monitoring.example.com/ping/foo
+`))
+	n = c.Jobs[0].Ret.(*PushJob).Notify
+	require.Len(t, n.OnError, 2)
+	cmd := n.OnError[0].Ret.(*NotifierCommand)
+	assert.Equal(t, "/usr/local/bin/page-oncall", cmd.Path)
+	assert.Equal(t, 30*time.Second, cmd.Timeout)
+	hook := n.OnError[1].Ret.(*NotifierWebhook)
+	assert.Equal(t, "https://hooks.example.com/zrepl", hook.URL)
+	assert.Equal(t, map[string]string{"Authorization": "Bearer s3cret"}, hook.Headers)
+	assert.Equal(t, 5*time.Second, hook.Timeout)
+	require.Len(t, n.OnSuccess, 1)
+	assert.Equal(t, 30*time.Second, n.OnSuccess[0].Ret.(*NotifierWebhook).Timeout)
+
+	_, err := testConfig(t, fill(`
+  notify:
+    on_error:
+    - type: mail
+      to: root@localhost
+`))
+	assert.Error(t, err)
+}
+
+func TestSinkJobNotify(t *testing.T) {
+	c := testValidConfig(t, `
+jobs:
+- name: sink
+  type: sink
+  root_fs: pool/backup
+  serve:
+    type: local
+    listener_name: sink
+  notify:
+    on_error:
+    - type: command
+      path: /usr/local/bin/page-oncall
+`)
+	n := c.Jobs[0].Ret.(*SinkJob).Notify
+	require.Len(t, n.OnError, 1)
+	assert.Equal(t, "/usr/local/bin/page-oncall", n.OnError[0].Ret.(*NotifierCommand).Path)
+	assert.Empty(t, n.OnSuccess)
+}
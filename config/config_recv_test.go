@@ -0,0 +1,58 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestRecvHooks(t *testing.T) {
+	c := testValidConfig(t, `
+jobs:
+- name: sink
+  type: sink
+  root_fs: "pool/backups"
+  serve:
+    type: local
+    listener_name: sink
+  recv:
+    hooks:
+    - path: /usr/local/bin/refresh-clone
+      filesystems: {
+        "pool/backups/prod/db": true
+      }
+    - path: /usr/local/bin/notify
+      timeout: 5s
+      err_is_fatal: true
+`)
+	h := c.Jobs[0].Ret.(*SinkJob).Recv.Hooks
+	require.Len(t, h, 2)
+	assert.Equal(t, "/usr/local/bin/refresh-clone", h[0].Path)
+	assert.Equal(t, 30*time.Second, h[0].Timeout)
+	assert.False(t, h[0].ErrIsFatal)
+	assert.Equal(t, FilesystemsFilter{"pool/backups/prod/db": true}, h[0].Filesystems)
+	assert.Equal(t, 5*time.Second, h[1].Timeout)
+	assert.True(t, h[1].ErrIsFatal)
+}
+
+func TestRecvQuota(t *testing.T) {
+	c := testValidConfig(t, `
+jobs:
+- name: sink
+  type: sink
+  root_fs: "pool/backups"
+  serve:
+    type: local
+    listener_name: sink
+  recv:
+    quota:
+      max_filesystems: 200
+      max_used: 2TiB
+      max_pool_capacity: 90
+`)
+	q := c.Jobs[0].Ret.(*SinkJob).Recv.Quota
+	assert.Equal(t, 200, q.MaxFilesystems)
+	assert.Equal(t, DataSize(2<<40), q.MaxUsed)
+	assert.Equal(t, 90, q.MaxPoolCapacity)
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestReplicationOptions(t *testing.T) {
+	tmpl := `
+jobs:
+- name: foo
+  type: push
+  connect:
+    type: local
+    listener_name: foo
+    client_identity: bar
+  filesystems: {"<": true}
+  snapshotting:
+    type: manual
+  pruning:
+    keep_sender:
+    - type: last_n
+      count: 10
+    keep_receiver:
+    - type: last_n
+      count: 10
+  %s
+`
+	fill := func(s string) string { return fmt.Sprintf(tmpl, s) }
+
+	t.Run("defaults", func(t *testing.T) {
+		c := testValidConfig(t, fill(""))
+		r := c.Jobs[0].Ret.(*PushJob).Replication
+		assert.Equal(t, 1, r.Concurrency.Steps)
+		assert.Equal(t, Bandwidth(0), r.BandwidthLimit)
+		assert.False(t, r.BusyReceiver.Defer)
+		assert.Equal(t, Bandwidth(0), r.BusyReceiver.BandwidthLimit)
+		assert.True(t, r.StepHolds)
+		assert.Equal(t, "fail", r.ConflictPolicy)
+		assert.Equal(t, "most_recent", r.InitialReplication)
+		assert.Equal(t, time.Duration(0), r.StepProgressTimeout)
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		c := testValidConfig(t, fill(`
+  replication:
+    concurrency:
+      steps: 4
+    bandwidth_limit: 10MiB
+    step_progress_timeout: 15m
+`))
+		r := c.Jobs[0].Ret.(*PushJob).Replication
+		assert.Equal(t, 4, r.Concurrency.Steps)
+		assert.Equal(t, Bandwidth(10*1024*1024), r.BandwidthLimit)
+		assert.Equal(t, 15*time.Minute, r.StepProgressTimeout)
+	})
+
+	t.Run("busy_receiver", func(t *testing.T) {
+		c := testValidConfig(t, fill(`
+  replication:
+    busy_receiver:
+      defer: true
+      bandwidth_limit: 1MiB
+`))
+		r := c.Jobs[0].Ret.(*PushJob).Replication
+		assert.True(t, r.BusyReceiver.Defer)
+		assert.Equal(t, Bandwidth(1024*1024), r.BusyReceiver.BandwidthLimit)
+	})
+
+	t.Run("step_holds", func(t *testing.T) {
+		c := testValidConfig(t, fill(`
+  replication:
+    step_holds: false
+`))
+		r := c.Jobs[0].Ret.(*PushJob).Replication
+		assert.False(t, r.StepHolds)
+	})
+
+	t.Run("conflict_policy", func(t *testing.T) {
+		c := testValidConfig(t, fill(`
+  replication:
+    conflict_policy: rollback
+`))
+		r := c.Jobs[0].Ret.(*PushJob).Replication
+		assert.Equal(t, "rollback", r.ConflictPolicy)
+	})
+
+	t.Run("initial_replication", func(t *testing.T) {
+		c := testValidConfig(t, fill(`
+  replication:
+    initial_replication: all
+`))
+		r := c.Jobs[0].Ret.(*PushJob).Replication
+		assert.Equal(t, "all", r.InitialReplication)
+	})
+}
+
+func TestParseBandwidth(t *testing.T) {
+	tcs := map[string]Bandwidth{
+		"1000":    1000,
+		"512B":    512,
+		"100K":    100 * 1024,
+		"100KiB":  100 * 1024,
+		"1.5M":    1.5 * 1024 * 1024,
+		"2 GiB/s": 2 * 1024 * 1024 * 1024,
+		"1T":      1024 * 1024 * 1024 * 1024,
+	}
+	for in, expect := range tcs {
+		b, err := parseBandwidth(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, expect, b, in)
+	}
+	for _, in := range []string{"", "fast", "10X", "-1M", "1.M"} {
+		_, err := parseBandwidth(in)
+		assert.Error(t, err, in)
+	}
+}
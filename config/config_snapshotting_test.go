@@ -36,6 +36,25 @@ jobs:
     prefix: zrepl_
     interval: 10m
 `
+	manualWatch := `
+  snapshotting:
+    type: manual
+    poll_interval: 1m
+    prefix: autosnap_
+`
+	periodicNameFormat := `
+  snapshotting:
+    type: periodic
+    name_format: 'zrepl_{{ .Time.Format "2006-01-02_15:04:05" }}'
+    timezone: Local
+    interval: 10m
+`
+	cron := `
+  snapshotting:
+    type: cron
+    prefix: zrepl_
+    cron: "0 2 * * *"
+`
 
 	fillSnapshotting := func(s string) string {return fmt.Sprintf(tmpl, s)}
 	var c *Config
@@ -44,6 +63,14 @@ jobs:
 		c = testValidConfig(t, fillSnapshotting(manual))
 		snm := c.Jobs[0].Ret.(*PushJob).Snapshotting.Ret.(*SnapshottingManual)
 		assert.Equal(t, "manual", snm.Type)
+		assert.Equal(t, time.Duration(0), snm.PollInterval)
+	})
+
+	t.Run("manual_watch", func(t *testing.T) {
+		c = testValidConfig(t, fillSnapshotting(manualWatch))
+		snm := c.Jobs[0].Ret.(*PushJob).Snapshotting.Ret.(*SnapshottingManual)
+		assert.Equal(t, time.Minute, snm.PollInterval)
+		assert.Equal(t, "autosnap_", snm.Prefix)
 	})
 
 	t.Run("periodic", func(t *testing.T) {
@@ -52,6 +79,31 @@ jobs:
 		assert.Equal(t, "periodic", snp.Type)
 		assert.Equal(t, 10*time.Minute, snp.Interval)
 		assert.Equal(t, "zrepl_" , snp.Prefix)
+		assert.False(t, snp.Align)
+		assert.Equal(t, "run_once", snp.Missed)
+	})
+
+	t.Run("periodic_missed", func(t *testing.T) {
+		c = testValidConfig(t, fillSnapshotting(periodic + "    missed: run_all\n"))
+		snp := c.Jobs[0].Ret.(*PushJob).Snapshotting.Ret.(*SnapshottingPeriodic)
+		assert.Equal(t, "run_all", snp.Missed)
+	})
+
+	t.Run("periodic_name_format", func(t *testing.T) {
+		c = testValidConfig(t, fillSnapshotting(periodicNameFormat))
+		snp := c.Jobs[0].Ret.(*PushJob).Snapshotting.Ret.(*SnapshottingPeriodic)
+		assert.Equal(t, "", snp.Prefix)
+		assert.Equal(t, `zrepl_{{ .Time.Format "2006-01-02_15:04:05" }}`, snp.NameFormat)
+		assert.Equal(t, "Local", snp.Timezone)
+	})
+
+	t.Run("cron", func(t *testing.T) {
+		c = testValidConfig(t, fillSnapshotting(cron))
+		snc := c.Jobs[0].Ret.(*PushJob).Snapshotting.Ret.(*SnapshottingCron)
+		assert.Equal(t, "cron", snc.Type)
+		assert.Equal(t, "0 2 * * *", snc.Cron)
+		assert.Equal(t, "zrepl_" , snc.Prefix)
+		assert.Equal(t, "run_once", snc.Missed)
 	})
 
 }
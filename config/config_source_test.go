@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSourceClientFilesystems(t *testing.T) {
+	tmpl := `
+jobs:
+- name: src
+  type: source
+  serve:
+    type: tls
+    listen: ":8888"
+    ca: /etc/zrepl/ca.crt
+    cert: /etc/zrepl/prod.crt
+    key: /etc/zrepl/prod.key
+    client_cns:
+      - "backup1"
+      - "backup2"
+  filesystems: {
+    "pool<": true,
+  }
+  snapshotting:
+    type: manual
+%s
+`
+	fill := func(s string) string { return fmt.Sprintf(tmpl, s) }
+
+	t.Run("default", func(t *testing.T) {
+		c := testValidConfig(t, fill(""))
+		assert.Empty(t, c.Jobs[0].Ret.(*SourceJob).ClientFilesystems)
+	})
+
+	t.Run("per-client", func(t *testing.T) {
+		c := testValidConfig(t, fill(`
+  client_filesystems:
+    backup1: {
+      "pool/db<": true,
+    }
+`))
+		cf := c.Jobs[0].Ret.(*SourceJob).ClientFilesystems
+		assert.Equal(t, map[string]FilesystemsFilter{"backup1": {"pool/db<": true}}, cf)
+	})
+}
+
+func TestSourceSnapshotFilter(t *testing.T) {
+	c := testValidConfig(t, `
+jobs:
+- name: src
+  type: source
+  serve:
+    type: local
+    listener_name: src
+  filesystems: {
+    "pool<": true,
+  }
+  snapshot_filter:
+    prefix: zrepl_
+    regex: "^zrepl_[0-9]+"
+  snapshotting:
+    type: manual
+`)
+	sf := c.Jobs[0].Ret.(*SourceJob).SnapshotFilter
+	assert.Equal(t, &SnapshotFilter{Prefix: "zrepl_", Regex: "^zrepl_[0-9]+"}, sf)
+}
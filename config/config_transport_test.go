@@ -0,0 +1,155 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestTransportTimeouts(t *testing.T) {
+	conf := testValidConfig(t, `
+jobs:
+- name: pull_default
+  type: pull
+  connect:
+    type: tls
+    address: "server1.foo.bar:8888"
+    ca: /etc/zrepl/ca.crt
+    cert: /etc/zrepl/backupserver.crt
+    key:  /etc/zrepl/backupserver.key
+    server_cn: "server1"
+  root_fs: "pool2/backup_servers"
+  interval: 10m
+  pruning:
+    keep_sender:
+    - type: not_replicated
+    keep_receiver:
+    - type: last_n
+      count: 100
+
+- name: pull_wan
+  type: pull
+  connect:
+    type: tls
+    address: "server1.foo.bar:8888"
+    ca: /etc/zrepl/ca.crt
+    cert: /etc/zrepl/backupserver.crt
+    key:  /etc/zrepl/backupserver.key
+    server_cn: "server1"
+    dial_timeout: 5s
+    keepalive: 10s
+    handshake_timeout: 20s
+  root_fs: "pool2/backup_servers"
+  interval: 10m
+  pruning:
+    keep_sender:
+    - type: not_replicated
+    keep_receiver:
+    - type: last_n
+      count: 100
+
+- type: sink
+  name: "laptop_sink"
+  root_fs: "pool2/backup_laptops"
+  serve:
+    type: tcp
+    listen: "192.168.122.189:8888"
+    clients: {
+      "10.23.42.23":"client1"
+    }
+    keepalive: 0s
+`)
+
+	def := conf.Jobs[0].Ret.(*PullJob).Connect.Ret.(*TLSConnect)
+	assert.Equal(t, 10*time.Second, def.DialTimeout)
+	assert.Equal(t, 30*time.Second, def.KeepAlive)
+	assert.Equal(t, 10*time.Second, def.HandshakeTimeout)
+
+	wan := conf.Jobs[1].Ret.(*PullJob).Connect.Ret.(*TLSConnect)
+	assert.Equal(t, 5*time.Second, wan.DialTimeout)
+	assert.Equal(t, 10*time.Second, wan.KeepAlive)
+	assert.Equal(t, 20*time.Second, wan.HandshakeTimeout)
+
+	assert.Equal(t, time.Duration(0), conf.Jobs[2].Ret.(*SinkJob).Serve.Ret.(*TCPServe).KeepAlive)
+}
+
+func TestUnixTransportAndSocketActivation(t *testing.T) {
+	conf := testValidConfig(t, `
+jobs:
+- type: sink
+  name: "vm_sink"
+  root_fs: "pool2/backup_vms"
+  serve:
+    type: unix
+    path: /var/run/zrepl/vm.sock
+    client_identity: hypervisor1
+
+- type: sink
+  name: "activated_sink"
+  root_fs: "pool2/backup_laptops"
+  serve:
+    type: tls
+    listen_fd_name: zrepl_tls
+    ca: /etc/zrepl/ca.crt
+    cert: /etc/zrepl/prod.crt
+    key: /etc/zrepl/prod.key
+    client_cns:
+    - "laptop1"
+
+- name: push_vms
+  type: push
+  connect:
+    type: unix
+    path: /var/run/zrepl/vm.sock
+  filesystems: {
+    "pool1/vms<": true,
+  }
+  snapshotting:
+    type: manual
+  pruning:
+    keep_sender:
+    - type: not_replicated
+    keep_receiver:
+    - type: last_n
+      count: 100
+`)
+
+	unixServe := conf.Jobs[0].Ret.(*SinkJob).Serve.Ret.(*UnixServe)
+	assert.Equal(t, "/var/run/zrepl/vm.sock", unixServe.Path)
+	assert.Equal(t, "hypervisor1", unixServe.ClientIdentity)
+
+	tlsServe := conf.Jobs[1].Ret.(*SinkJob).Serve.Ret.(*TLSServe)
+	assert.Equal(t, "", tlsServe.Listen)
+	assert.Equal(t, "zrepl_tls", tlsServe.ListenFDName)
+
+	unixConnect := conf.Jobs[2].Ret.(*PushJob).Connect.Ret.(*UnixConnect)
+	assert.Equal(t, "/var/run/zrepl/vm.sock", unixConnect.Path)
+	assert.Equal(t, 10*time.Second, unixConnect.DialTimeout)
+}
+
+func TestServeAccessRules(t *testing.T) {
+	conf := testValidConfig(t, `
+jobs:
+- type: sink
+  name: "laptop_sink"
+  root_fs: "pool2/backup_laptops"
+  serve:
+    type: tls
+    listen: ":8888"
+    ca: /etc/zrepl/ca.crt
+    cert: /etc/zrepl/prod.crt
+    key: /etc/zrepl/prod.key
+    client_cns:
+    - "laptop1"
+    - "homeserver"
+    access:
+    - client_identities: [ "laptop1" ]
+      networks: [ "10.23.0.0/16" ]
+    - networks: [ "192.168.1.42" ]
+`)
+	access := conf.Jobs[0].Ret.(*SinkJob).Serve.Ret.(*TLSServe).Access
+	assert.Equal(t, []ServeAccessRule{
+		{ClientIdentities: []string{"laptop1"}, Networks: []string{"10.23.0.0/16"}},
+		{Networks: []string{"192.168.1.42"}},
+	}, access)
+}
@@ -0,0 +1,305 @@
+package config
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/zrepl/yaml-config"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// Top-level keys of a config file that are resolved by parseConfigFile before the config is parsed.
+const (
+	// a glob pattern or a list of them, relative to the directory of the config file
+	includeKey = "include"
+	// job keys that all jobs of the config inherit, see applyJobDefaults
+	defaultsKey = "defaults"
+)
+
+// jobTypes are the job types by type name, as in JobEnum.
+var jobTypes = map[string]reflect.Type{
+	"push":   reflect.TypeOf(PushJob{}),
+	"sink":   reflect.TypeOf(SinkJob{}),
+	"pull":   reflect.TypeOf(PullJob{}),
+	"source": reflect.TypeOf(SourceJob{}),
+}
+
+// yamlKeys returns the keys of struct type t, including those of its inlined fields.
+func yamlKeys(t reflect.Type) map[string]bool {
+	keys := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		opts := strings.Split(f.Tag.Get("yaml"), ",")
+		if opts[0] == "-" {
+			continue
+		}
+		if opts[0] == "" {
+			for _, o := range opts[1:] {
+				if o == "inline" {
+					for k := range yamlKeys(f.Type) {
+						keys[k] = true
+					}
+				}
+			}
+			continue
+		}
+		keys[opts[0]] = true
+	}
+	return keys
+}
+
+// jobSource is a job of the config and the file that defines it.
+type jobSource struct {
+	job  yaml.MapSlice
+	file string
+}
+
+// parseConfigFile parses in, the contents of the config file at path,
+// after appending the jobs of the included files and applying the defaults to all jobs.
+// Errors in a job name the file that defines it.
+func parseConfigFile(path string, in []byte) (*Config, error) {
+	var root yaml.MapSlice
+	if err := yaml.Unmarshal(in, &root); err != nil || (!mapHas(root, includeKey) && !mapHas(root, defaultsKey)) {
+		// leave reporting of syntax errors to the regular parser
+		return ParseConfigBytes(in)
+	}
+	migrated, warnings, err := MigrateConfigBytes(in)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s: cannot migrate config from old format", path)
+	}
+	if migrated != nil {
+		root = nil
+		if err := yaml.Unmarshal(migrated, &root); err != nil {
+			return nil, errors.Wrapf(err, "%s: cannot read migrated config", path)
+		}
+	}
+
+	sources, err := jobSources(path, root)
+	if err != nil {
+		return nil, err
+	}
+	patterns, err := includePatterns(mapGet(root, includeKey))
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s: invalid %s", path, includeKey)
+	}
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: invalid %s pattern %q", path, includeKey, pattern)
+		}
+		for _, m := range matches {
+			included, w, err := parseIncludedFile(m)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, included...)
+			warnings = append(warnings, w...)
+		}
+	}
+
+	var defaults yaml.MapSlice
+	if d := mapGet(root, defaultsKey); d != nil {
+		var ok bool
+		if defaults, ok = d.(yaml.MapSlice); !ok {
+			return nil, errors.Errorf("%s: %s must be a mapping of job keys", path, defaultsKey)
+		}
+		if err := validateJobDefaults(defaults); err != nil {
+			return nil, errors.Wrapf(err, "%s: invalid %s", path, defaultsKey)
+		}
+	}
+
+	jobs := make([]interface{}, len(sources))
+	definedIn := make(map[string]string, len(sources))
+	for i, s := range sources {
+		job, inherited := applyJobDefaults(defaults, s.job)
+		where := fmt.Sprintf("%s: job #%d", s.file, i)
+		if name, ok := mapGet(job, "name").(string); ok {
+			where = fmt.Sprintf("%s: job %q", s.file, name)
+		}
+		if inherited {
+			where += fmt.Sprintf(" (with %s of %s)", defaultsKey, path)
+		}
+		b, err := yaml.Marshal(job)
+		if err != nil {
+			return nil, errors.Wrap(err, where)
+		}
+		var j JobEnum
+		if err := yaml.UnmarshalStrict(b, &j); err != nil {
+			return nil, errors.Wrap(err, where)
+		}
+		if prev, ok := definedIn[j.Name()]; ok {
+			return nil, errors.Errorf("%s: name is already used by a job in %s", where, prev)
+		}
+		definedIn[j.Name()] = s.file
+		jobs[i] = job
+	}
+
+	root = mapDel(root, includeKey)
+	root = mapDel(root, defaultsKey)
+	root = mapSet(root, "jobs", jobs)
+	out, err := yaml.Marshal(root)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s: cannot marshal config", path)
+	}
+	c, err := ParseConfigBytes(out)
+	if err != nil {
+		return nil, errors.Wrap(err, path)
+	}
+	c.MigrationWarnings = append(warnings, c.MigrationWarnings...)
+	return c, nil
+}
+
+func jobSources(file string, root yaml.MapSlice) ([]jobSource, error) {
+	v := mapGet(root, "jobs")
+	if v == nil {
+		return nil, nil
+	}
+	jobs, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("%s: jobs must be a list", file)
+	}
+	sources := make([]jobSource, len(jobs))
+	for i := range jobs {
+		job, ok := jobs[i].(yaml.MapSlice)
+		if !ok {
+			return nil, errors.Errorf("%s: job #%d must be a mapping", file, i)
+		}
+		sources[i] = jobSource{job, file}
+	}
+	return sources, nil
+}
+
+func includePatterns(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		patterns := make([]string, len(v))
+		for i := range v {
+			p, ok := v[i].(string)
+			if !ok {
+				return nil, errors.Errorf("pattern #%d is not a string", i)
+			}
+			patterns[i] = p
+		}
+		return patterns, nil
+	default:
+		return nil, errors.New("must be a glob pattern or a list of them")
+	}
+}
+
+// parseIncludedFile returns the jobs of the included file at path, which must not contain anything else.
+func parseIncludedFile(path string) ([]jobSource, []string, error) {
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	migrated, warnings, err := MigrateConfigBytes(in)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "%s: cannot migrate config from old format", path)
+	}
+	if migrated != nil {
+		in = migrated
+	}
+	var root yaml.MapSlice
+	if err := yaml.Unmarshal(in, &root); err != nil {
+		return nil, nil, errors.Wrap(err, path)
+	}
+	for _, e := range root {
+		if e.Key != "jobs" {
+			return nil, nil, errors.Errorf("%s: included files may only contain jobs, found %v", path, e.Key)
+		}
+	}
+	for i := range warnings {
+		warnings[i] = path + ": " + warnings[i]
+	}
+	sources, err := jobSources(path, root)
+	return sources, warnings, err
+}
+
+func validateJobDefaults(defaults yaml.MapSlice) error {
+	for _, e := range defaults {
+		key, ok := e.Key.(string)
+		if !ok || key == "name" || key == "type" {
+			return errors.Errorf("%v cannot have a default", e.Key)
+		}
+		accepted := false
+		for _, t := range jobTypes {
+			accepted = accepted || yamlKeys(t)[key]
+		}
+		if !accepted {
+			return errors.Errorf("no job type has key %q", key)
+		}
+	}
+	return nil
+}
+
+// applyJobDefaults returns job with the defaults for the keys its type accepts.
+// Mappings are merged recursively, with the values of job taking precedence,
+// except for mappings with different types, e.g. connect sections of type tcp and tls, of which job's is used.
+// Lists and scalars of job replace those of the defaults.
+// inherited is true if job inherited any default.
+func applyJobDefaults(defaults yaml.MapSlice, job yaml.MapSlice) (out yaml.MapSlice, inherited bool) {
+	t, ok := jobTypes[fmt.Sprint(mapGet(job, "type"))]
+	if !ok || len(defaults) == 0 {
+		return job, false // the parser reports the invalid type
+	}
+	keys := yamlKeys(t)
+	out = append(yaml.MapSlice{}, job...)
+	for _, d := range defaults {
+		if !keys[d.Key.(string)] {
+			continue
+		}
+		inherited = true
+		if v, ok := mapLookup(out, d.Key); ok {
+			out = mapSet(out, d.Key.(string), mergeDefault(d.Value, v))
+		} else {
+			out = append(out, d)
+		}
+	}
+	return out, inherited
+}
+
+func mergeDefault(def, v interface{}) interface{} {
+	dm, ok := def.(yaml.MapSlice)
+	if !ok {
+		return v
+	}
+	vm, ok := v.(yaml.MapSlice)
+	if !ok {
+		return v
+	}
+	if dt, vt := mapGet(dm, "type"), mapGet(vm, "type"); dt != nil && vt != nil && dt != vt {
+		return v
+	}
+	out := make(yaml.MapSlice, 0, len(dm)+len(vm))
+	for _, d := range dm {
+		if value, ok := mapLookup(vm, d.Key); ok {
+			out = append(out, yaml.MapItem{Key: d.Key, Value: mergeDefault(d.Value, value)})
+		} else {
+			out = append(out, d)
+		}
+	}
+	for _, e := range vm {
+		if _, ok := mapLookup(dm, e.Key); !ok {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func mapLookup(m yaml.MapSlice, key interface{}) (interface{}, bool) {
+	for _, e := range m {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,304 @@
+package config
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/zrepl/yaml-config"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MigrateConfigBytes converts a config written for zrepl releases before 0.1 to the current format.
+// The returned warnings describe changes in behavior that the user should review.
+// If in does not use any of the old layouts, out is nil.
+func MigrateConfigBytes(in []byte) (out []byte, warnings []string, err error) {
+	var root yaml.MapSlice
+	if err := yaml.Unmarshal(in, &root); err != nil {
+		// leave reporting of syntax errors to the regular parser
+		return nil, nil, nil
+	}
+
+	m := &migration{}
+	if global, ok := mapGet(root, "global").(yaml.MapSlice); ok {
+		if logging, ok := mapGet(global, "logging").([]interface{}); ok {
+			for i := range logging {
+				m.migrateLoggingOutlet(i, logging[i])
+			}
+		}
+	}
+	if jobs, ok := mapGet(root, "jobs").([]interface{}); ok {
+		for i := range jobs {
+			job, ok := jobs[i].(yaml.MapSlice)
+			if !ok {
+				continue
+			}
+			if jobs[i], err = m.migrateJob(job); err != nil {
+				return nil, nil, errors.Wrapf(err, "job %q", mapGet(job, "name"))
+			}
+		}
+	}
+
+	if !m.changed {
+		return nil, nil, nil
+	}
+	if out, err = yaml.Marshal(root); err != nil {
+		return nil, nil, errors.Wrap(err, "cannot marshal migrated config")
+	}
+	return out, m.warnings, nil
+}
+
+type migration struct {
+	changed  bool
+	warnings []string
+}
+
+func (m *migration) warnf(format string, args ...interface{}) {
+	m.warnings = append(m.warnings, fmt.Sprintf(format, args...))
+}
+
+// keys that only exist in jobs of the old config layout
+var oldJobKeys = []string{"snapshot_prefix", "mapping", "initial_repl_policy", "prune", "prune_lhs", "prune_rhs"}
+
+func (m *migration) migrateLoggingOutlet(i int, outlet interface{}) {
+	o, ok := outlet.(yaml.MapSlice)
+	if !ok || !mapHas(o, "outlet") || mapHas(o, "type") {
+		return
+	}
+	for j := range o {
+		if o[j].Key == "outlet" {
+			o[j].Key = "type"
+		}
+	}
+	m.changed = true
+	m.warnf("logging outlet #%d: key 'outlet' was renamed to 'type'", i)
+}
+
+func (m *migration) migrateJob(job yaml.MapSlice) (yaml.MapSlice, error) {
+	old := false
+	for _, k := range oldJobKeys {
+		old = old || mapHas(job, k)
+	}
+	if serve, ok := mapGet(job, "serve").(yaml.MapSlice); ok && mapHas(serve, "client_identity") {
+		old = true
+	}
+	jobType, _ := mapGet(job, "type").(string)
+	if !old && jobType != "local" {
+		return job, nil
+	}
+
+	m.changed = true
+	switch jobType {
+	case "pull":
+		return m.migratePullJob(job)
+	case "source":
+		return m.migrateSourceJob(job)
+	case "local":
+		return nil, errors.New("local jobs cannot be migrated automatically, " +
+			"replace them by a push job and a sink job that use the local transport")
+	default:
+		return nil, errors.Errorf("cannot migrate job of type %q", jobType)
+	}
+}
+
+func (m *migration) migratePullJob(job yaml.MapSlice) (yaml.MapSlice, error) {
+	name := mapGet(job, "name")
+
+	mapping, ok := mapGet(job, "mapping").(yaml.MapSlice)
+	if !ok {
+		return nil, errors.New("mapping must be specified")
+	}
+	rootFS, rules, err := migrateMapping(mapping)
+	if err != nil {
+		return nil, err
+	}
+	job = mapDel(job, "mapping")
+	job = mapSet(job, "root_fs", rootFS)
+	if len(rules) > 0 {
+		job = mapSet(job, "root_fs_mapping", rules)
+	}
+
+	if mapHas(job, "initial_repl_policy") {
+		job = mapDel(job, "initial_repl_policy")
+		m.warnf("job %q: initial_repl_policy is no longer supported and was removed", name)
+	}
+
+	prefix, _ := mapGet(job, "snapshot_prefix").(string)
+	job = mapDel(job, "snapshot_prefix")
+
+	keepReceiver := []interface{}{keepAllRule()}
+	if prune, ok := mapGet(job, "prune").(yaml.MapSlice); ok {
+		if keepReceiver, err = m.migratePrune(name, prune, prefix); err != nil {
+			return nil, errors.Wrap(err, "prune")
+		}
+		job = mapDel(job, "prune")
+	}
+	job = mapSet(job, "pruning", yaml.MapSlice{
+		{Key: "keep_sender", Value: []interface{}{keepAllRule()}},
+		{Key: "keep_receiver", Value: keepReceiver},
+	})
+	m.warnf("job %q: the pull job now also prunes the source; "+
+		"pruning.keep_sender keeps all snapshots, move the prune rules of the source job there", name)
+
+	return job, nil
+}
+
+func (m *migration) migrateSourceJob(job yaml.MapSlice) (yaml.MapSlice, error) {
+	name := mapGet(job, "name")
+
+	if serve, ok := mapGet(job, "serve").(yaml.MapSlice); ok && mapHas(serve, "client_identity") {
+		ident := mapGet(serve, "client_identity")
+		serve = mapDel(serve, "client_identity")
+		serve = mapSet(serve, "client_identities", []interface{}{ident})
+		job = mapSet(job, "serve", serve)
+	}
+
+	prefix, _ := mapGet(job, "snapshot_prefix").(string)
+	if prefix == "" {
+		return nil, errors.New("snapshot_prefix must be specified")
+	}
+	interval := mapGet(job, "interval")
+	if interval == nil {
+		return nil, errors.New("interval must be specified")
+	}
+	job = mapDel(job, "snapshot_prefix")
+	job = mapDel(job, "interval")
+	job = mapSet(job, "snapshotting", yaml.MapSlice{
+		{Key: "type", Value: "periodic"},
+		{Key: "prefix", Value: prefix},
+		{Key: "interval", Value: interval},
+	})
+
+	if prune, ok := mapGet(job, "prune").(yaml.MapSlice); ok {
+		rules, err := m.migratePrune(name, prune, prefix)
+		if err != nil {
+			return nil, errors.Wrap(err, "prune")
+		}
+		job = mapDel(job, "prune")
+		rendered, err := yaml.Marshal(yaml.MapSlice{{Key: "keep_sender", Value: rules}})
+		if err != nil {
+			return nil, err
+		}
+		m.warnf("job %q: pruning of the source is now done by the pull job, add its rules to the pull job's pruning section:\n%s",
+			name, rendered)
+	}
+
+	return job, nil
+}
+
+// migratePrune converts an old prune policy into keep rules.
+// Old prune policies only applied to snapshots with the job's snapshot prefix,
+// whereas keep rules apply to all snapshots, hence the additional negated regex rule.
+func (m *migration) migratePrune(jobName interface{}, prune yaml.MapSlice, prefix string) ([]interface{}, error) {
+	if mapHas(prune, "keep_bookmarks") {
+		m.warnf("job %q: keep_bookmarks is no longer supported and was removed, "+
+			"bookmarks created by previous releases must be destroyed manually", jobName)
+	}
+	switch policy := mapGet(prune, "policy"); policy {
+	case "noprune":
+		return []interface{}{keepAllRule()}, nil
+	case "grid":
+		grid, ok := mapGet(prune, "grid").(string)
+		if !ok {
+			return nil, errors.New("grid must be specified")
+		}
+		if prefix == "" {
+			return nil, errors.New("snapshot_prefix must be specified")
+		}
+		prefixRegex := "^" + regexp.QuoteMeta(prefix)
+		return []interface{}{
+			yaml.MapSlice{
+				{Key: "type", Value: "grid"},
+				{Key: "grid", Value: grid},
+				{Key: "regex", Value: prefixRegex},
+			},
+			yaml.MapSlice{
+				{Key: "type", Value: "regex"},
+				{Key: "negate", Value: true},
+				{Key: "regex", Value: prefixRegex},
+			},
+		}, nil
+	default:
+		return nil, errors.Errorf("unknown policy %v", policy)
+	}
+}
+
+func keepAllRule() yaml.MapSlice {
+	return yaml.MapSlice{
+		{Key: "type", Value: "regex"},
+		{Key: "regex", Value: ".*"},
+	}
+}
+
+// migrateMapping converts an old pull job mapping, whose subtree entries ("pool/fs<") are
+// matched by longest prefix, into root_fs and root_fs_mapping rules, which are matched in order.
+func migrateMapping(mapping yaml.MapSlice) (rootFS string, rules []interface{}, err error) {
+	type rule struct{ sender, rootFS string }
+	var rs []rule
+	for _, e := range mapping {
+		from, fok := e.Key.(string)
+		to, tok := e.Value.(string)
+		if !fok || !tok {
+			return "", nil, errors.Errorf("mapping entry %v: %v is not a string mapping", e.Key, e.Value)
+		}
+		if !strings.HasSuffix(from, "<") {
+			return "", nil, errors.Errorf("mapping entry %q: only subtree mappings (ending with '<') can be migrated", from)
+		}
+		if from == "<" {
+			rootFS = to
+			continue
+		}
+		rs = append(rs, rule{strings.TrimSuffix(from, "<"), to})
+	}
+	if rootFS == "" {
+		return "", nil, errors.New(`mapping must contain a "<" entry, its target becomes root_fs`)
+	}
+	sort.SliceStable(rs, func(i, j int) bool {
+		return strings.Count(rs[i].sender, "/") > strings.Count(rs[j].sender, "/")
+	})
+	for _, r := range rs {
+		rules = append(rules, yaml.MapSlice{
+			{Key: "sender", Value: r.sender},
+			{Key: "root_fs", Value: r.rootFS},
+		})
+	}
+	return rootFS, rules, nil
+}
+
+func mapGet(m yaml.MapSlice, key string) interface{} {
+	for _, e := range m {
+		if e.Key == key {
+			return e.Value
+		}
+	}
+	return nil
+}
+
+func mapHas(m yaml.MapSlice, key string) bool {
+	for _, e := range m {
+		if e.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func mapSet(m yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i := range m {
+		if m[i].Key == key {
+			m[i].Value = value
+			return m
+		}
+	}
+	return append(m, yaml.MapItem{Key: key, Value: value})
+}
+
+func mapDel(m yaml.MapSlice, key string) yaml.MapSlice {
+	out := m[:0]
+	for _, e := range m {
+		if e.Key != key {
+			out = append(out, e)
+		}
+	}
+	return out
+}
@@ -13,7 +13,10 @@ type RetentionIntervalList []RetentionInterval
 type PruneGrid struct {
 	Type          string                `yaml:"type"`
 	Grid          RetentionIntervalList `yaml:"grid"`
-	Regex	      string                `yaml:"regex"`
+	Regex	      string                `yaml:"regex,optional"`
+	// alternative to Regex: match snapshots by name template and use the time encoded in their name
+	NameFormat    string                `yaml:"name_format,optional"`
+	Timezone      string                `yaml:"timezone,optional,default=UTC"`
 }
 
 type RetentionInterval struct {
@@ -14,16 +14,21 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"sort"
 	"time"
 )
 
 type controlJob struct {
 	sockaddr *net.UnixAddr
 	jobs     *jobs
+	// immutable, by job name
+	summaries map[string]*JobSummary
+	// address the pprof server listens on from the start, "" if it is only started by zrepl pprof
+	pprofListen string
 }
 
-func newControlJob(sockpath string, jobs *jobs) (j *controlJob, err error) {
-	j = &controlJob{jobs: jobs}
+func newControlJob(sockpath string, jobs *jobs, summaries map[string]*JobSummary, pprofListen string) (j *controlJob, err error) {
+	j = &controlJob{jobs: jobs, summaries: summaries, pprofListen: pprofListen}
 
 	j.sockaddr, err = net.ResolveUnixAddr("unix", sockpath)
 	if err != nil {
@@ -63,12 +68,33 @@ func (j *controlJob) RegisterMetrics(registerer prometheus.Registerer) {
 }
 
 const (
-	ControlJobEndpointPProf   string = "/debug/pprof"
-	ControlJobEndpointVersion string = "/version"
-	ControlJobEndpointStatus  string = "/status"
-	ControlJobEndpointSignal  string = "/signal"
+	ControlJobEndpointPProf         string = "/debug/pprof"
+	ControlJobEndpointVersion       string = "/version"
+	ControlJobEndpointStatus        string = "/status"
+	ControlJobEndpointSignal        string = "/signal"
+	ControlJobEndpointJobs          string = "/jobs"
+	ControlJobEndpointHistory       string = "/history"
+	ControlJobEndpointReplicateOnce string = "/replicate-once"
 )
 
+// ReplicateOnceRequest is the request of ControlJobEndpointReplicateOnce, whose response is the
+// *job.ReplicateOnceStatus of Job. Unless Poll is set, it starts the replication of a single step
+// of Filesystem from From to To with the endpoints and transport of push or pull job Job.
+type ReplicateOnceRequest struct {
+	Job        string
+	Filesystem string
+	From, To   string
+	// only report the status of the ad-hoc replication started before
+	Poll bool
+}
+
+// HistoryRequest is the request of ControlJobEndpointHistory, whose response is a map
+// from job name to the job's recorded invocations, most recent first.
+type HistoryRequest struct {
+	// empty for all jobs
+	Job string
+}
+
 func (j *controlJob) Run(ctx context.Context) {
 
 	log := job.GetLogger(ctx)
@@ -81,6 +107,9 @@ func (j *controlJob) Run(ctx context.Context) {
 	}
 
 	pprofServer := NewPProfServer(ctx)
+	if j.pprofListen != "" {
+		pprofServer.Control(PprofServerControlMsg{Run: true, HttpListenAddress: j.pprofListen})
+	}
 
 	mux := http.NewServeMux()
 	mux.Handle(ControlJobEndpointPProf,
@@ -106,6 +135,29 @@ func (j *controlJob) Run(ctx context.Context) {
 			return s, nil
 		}})
 
+	mux.Handle(ControlJobEndpointJobs,
+		requestLogger{log: log, handler: jsonResponder{func() (interface{}, error) {
+			return j.jobSummaries(), nil
+		}}})
+
+	mux.Handle(ControlJobEndpointHistory,
+		requestLogger{log: log, handler: jsonRequestResponder{func(decoder jsonDecoder) (interface{}, error) {
+			var req HistoryRequest
+			if decoder(&req) != nil {
+				return nil, errors.Errorf("decode failed")
+			}
+			return j.jobs.invocations(req.Job)
+		}}})
+
+	mux.Handle(ControlJobEndpointReplicateOnce,
+		requestLogger{log: log, handler: jsonRequestResponder{func(decoder jsonDecoder) (interface{}, error) {
+			var req ReplicateOnceRequest
+			if decoder(&req) != nil {
+				return nil, errors.Errorf("decode failed")
+			}
+			return j.jobs.replicateOnce(ctx, req)
+		}}})
+
 	mux.Handle(ControlJobEndpointSignal,
 		requestLogger{log: log, handler: jsonRequestResponder{func(decoder jsonDecoder) (interface{}, error) {
 			type reqT struct {
@@ -161,6 +213,17 @@ outer:
 
 }
 
+// jobSummaries returns the summaries of the configured jobs with the outcomes of their last runs, sorted by name.
+func (j *controlJob) jobSummaries() []*JobSummary {
+	status := j.jobs.status()
+	res := make([]*JobSummary, 0, len(j.summaries))
+	for name, s := range j.summaries {
+		res = append(res, s.withStatus(status[name]))
+	}
+	sort.Slice(res, func(i, k int) bool { return res[i].Name < res[k].Name })
+	return res
+}
+
 type jsonResponder struct {
 	producer func() (interface{}, error)
 }
@@ -7,11 +7,20 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/zrepl/zrepl/config"
 	"github.com/zrepl/zrepl/daemon/job"
+	"github.com/zrepl/zrepl/daemon/job/crash"
+	"github.com/zrepl/zrepl/daemon/job/history"
+	"github.com/zrepl/zrepl/daemon/job/journal"
 	"github.com/zrepl/zrepl/daemon/job/reset"
+	"github.com/zrepl/zrepl/daemon/job/trigger"
+	"github.com/zrepl/zrepl/daemon/job/usage"
 	"github.com/zrepl/zrepl/daemon/job/wakeup"
 	"github.com/zrepl/zrepl/daemon/logging"
 	"github.com/zrepl/zrepl/logger"
+	"github.com/zrepl/zrepl/tlsconf"
+	"github.com/zrepl/zrepl/util/envconst"
+	"github.com/zrepl/zrepl/util/faultinject"
 	"github.com/zrepl/zrepl/version"
+	"github.com/zrepl/zrepl/zfs"
 	"os"
 	"os/signal"
 	"strings"
@@ -32,54 +41,103 @@ func Run(conf *config.Config) error {
 		cancel()
 	}()
 
-	outlets, err := logging.OutletsFromConfig(*conf.Global.Logging)
+	outlets, err := logging.OutletsFromConfig(*conf.Global.Logging, conf.Jobs)
 	if err != nil {
 		return errors.Wrap(err, "cannot build logging from config")
 	}
+	// write out the last entries, e.g. errors that caused the daemon to exit
+	defer logging.FlushOutlets(1 * time.Second)
 
 	confJobs, err := job.JobsFromConfig(conf)
 	if err != nil {
 		return errors.Wrap(err, "cannot build jobs from config")
 	}
+	if err := CheckJobNames(confJobs); err != nil {
+		return err
+	}
+	triggers, err := JobTriggers(conf.Jobs)
+	if err != nil {
+		return err
+	}
+	journals, err := JobJournals(conf.Jobs)
+	if err != nil {
+		return err
+	}
+	globalJobs, err := GlobalJobsFromConfig(conf)
+	if err != nil {
+		return err
+	}
+	zfsExecConf, err := ZFSExecConfigFromConfig(conf.Global.ZFS)
+	if err != nil {
+		return err
+	}
+	pprofListen, err := PprofListenFromConfig(conf.Global.Serve)
+	if err != nil {
+		return err
+	}
 
 	log := logger.NewLogger(outlets, 1*time.Second)
 	log.Info(version.NewZreplVersionInformation().String())
+	if faultinject.FromEnv() != nil {
+		log.WithField("var", faultinject.EnvVar).Warn("fault injection is enabled, do not use this in production")
+	}
 
-	for _, job := range confJobs {
-		if IsInternalJobName(job.Name()) {
-			panic(fmt.Sprintf("internal job name used for config job '%s'", job.Name())) //FIXME
+	zfsExecConf.Logger = log.WithField(logging.SubsysField, logging.SubsysZFS)
+	if err := zfs.SetExecConfig(zfsExecConf); err != nil {
+		return err
+	}
+	platform := zfs.DetectPlatform()
+	if features, err := zfs.ProbePlatform(ctx, platform); err != nil {
+		log.WithError(err).WithField("platform", platform.Name()).
+			Warn("cannot probe zfs features, assuming all zfs send and recv flags are supported")
+	} else {
+		log.WithField("features", features.String()).Info("probed zfs features")
+	}
+	if zfsExecConf.Delegated {
+		if os.Geteuid() == 0 {
+			log.Warn("zfs delegation is enabled, but the daemon runs as root")
+		} else if err := job.CheckDelegation(confJobs); err != nil {
+			return err
 		}
 	}
 
+	tlsconf.SetReloadHook(func(certFile string, err error) {
+		l := log.WithField("cert", certFile)
+		if err != nil {
+			l.WithError(err).Error("cannot reload changed TLS certificate, keep using the previous one")
+			return
+		}
+		l.Info("reloaded changed TLS certificate")
+	})
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			if err := tlsconf.ReloadAll(); err != nil {
+				log.WithError(err).Error("SIGHUP: cannot reload TLS certificates")
+				continue
+			}
+			log.Info("SIGHUP: reloaded TLS certificates")
+		}
+	}()
+
 	ctx = job.WithLogger(ctx, log)
 
-	jobs := newJobs()
+	jobs := newJobs(triggers, journals, conf.Global.Control.History)
+	defer jobs.closeJournals()
+	jobs.goroutines = usage.SampleGoroutines(ctx, envconst.Duration("ZREPL_DAEMON_GOROUTINE_SAMPLE_INTERVAL", 10*time.Second))
 
 	// start control socket
-	controlJob, err := newControlJob(conf.Global.Control.SockPath, jobs)
+	controlJob, err := newControlJob(conf.Global.Control.SockPath, jobs, JobSummariesFromConfig(conf), pprofListen)
 	if err != nil {
 		panic(err) // FIXME
 	}
 	jobs.start(ctx, controlJob, true)
 
-	for i, jc := range conf.Global.Monitoring {
-		var (
-			job job.Job
-			err error
-		)
-		switch v := jc.Ret.(type) {
-		case *config.PrometheusMonitoring:
-			job, err = newPrometheusJobFromConfig(v)
-		default:
-			return errors.Errorf("unknown monitoring job #%d (type %T)", i, v)
-		}
-		if err != nil {
-			return errors.Wrapf(err,"cannot build monitorin gjob #%d", i)
-		}
-		jobs.start(ctx, job, true)
+	for _, j := range globalJobs {
+		jobs.start(ctx, j, true)
 	}
 
-
 	log.Info("starting daemon")
 
 	// start regular jobs
@@ -97,6 +155,77 @@ func Run(conf *config.Config) error {
 	return nil
 }
 
+// CheckJobNames returns an error if a job uses a name reserved for the daemon's internal jobs.
+func CheckJobNames(confJobs []job.Job) error {
+	for _, j := range confJobs {
+		if IsInternalJobName(j.Name()) {
+			return errors.Errorf("job name %q is reserved for an internal job, choose another name", j.Name())
+		}
+	}
+	return nil
+}
+
+// ZFSExecConfigFromConfig returns the validated zfs.ExecConfig for the global zfs section, without a logger.
+func ZFSExecConfigFromConfig(in *config.GlobalZFS) (zfs.ExecConfig, error) {
+	c := zfs.ExecConfig{
+		Nice:          in.Nice,
+		IONiceClass:   in.IONiceClass,
+		IONiceLevel:   in.IONiceLevel,
+		MaxConcurrent: in.MaxConcurrentCommands,
+		DestroyBatchSize: in.DestroyBatchSize,
+		Delegated:     in.Delegated,
+	}
+	switch in.Backend {
+	case "", "cli":
+	case "lzc":
+		c.LZC = true
+	default:
+		return c, fmt.Errorf("invalid global zfs config: unknown backend %q, must be cli or lzc", in.Backend)
+	}
+	if err := c.Validate(); err != nil {
+		return c, errors.Wrap(err, "invalid global zfs config")
+	}
+	return c, nil
+}
+
+// GlobalJobsFromConfig builds the monitoring and maintenance jobs configured in the global section,
+// without starting them.
+func GlobalJobsFromConfig(conf *config.Config) ([]job.Job, error) {
+	var jobs []job.Job
+	haveSnapshotMonitor := false
+	for i, jc := range conf.Global.Monitoring {
+		var (
+			j   job.Job
+			err error
+		)
+		switch v := jc.Ret.(type) {
+		case *config.PrometheusMonitoring:
+			j, err = newPrometheusJobFromConfig(v)
+		case *config.SnapshotMonitoring:
+			if haveSnapshotMonitor {
+				return nil, errors.Errorf("monitoring job #%d: only one snapshots monitoring job is allowed, use multiple checks instead", i)
+			}
+			haveSnapshotMonitor = true
+			j, err = newSnapshotMonitorJobFromConfig(v)
+		default:
+			return nil, errors.Errorf("unknown monitoring job #%d (type %T)", i, v)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot build monitoring job #%d", i)
+		}
+		jobs = append(jobs, j)
+	}
+
+	if conf.Global.Maintenance.Interval > 0 {
+		mj, err := newMaintenanceJob(conf.Global.Maintenance.Interval, conf.Jobs)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot build maintenance job")
+		}
+		jobs = append(jobs, mj)
+	}
+	return jobs, nil
+}
+
 type jobs struct {
 	wg sync.WaitGroup
 
@@ -105,13 +234,39 @@ type jobs struct {
 	wakeups map[string]wakeup.Func // by Job.Name
 	resets map[string]reset.Func // by Job.Name
 	jobs    map[string]job.Job
+	crashes map[string]*crash.Tracker // by Job.Name
+	usage   map[string]*usage.Tracker // by Job.Name
+	history map[string]*history.Tracker // by Job.Name
+	historySize int
+	// set by Run, if nil the goroutines of the jobs are reported as zero
+	goroutines *usage.GoroutineSampler
+
+	// immutable, see JobTriggers
+	triggers map[string][]string
+	// immutable, see JobJournals
+	journalConf map[string]*config.JobDebugJournal
+	// journalsMtx protects journals
+	journalsMtx sync.Mutex
+	journals    map[string]*journal.Journal // by Job.Name
+	// pendingTriggers protects pending
+	pendingTriggers sync.Mutex
+	pending map[string]bool // by name of the triggered job
 }
 
-func newJobs() *jobs {
+// triggers and journals may be nil, historySize is the number of invocations kept per job.
+func newJobs(triggers map[string][]string, journals map[string]*config.JobDebugJournal, historySize int) *jobs {
 	return &jobs{
 		wakeups: make(map[string]wakeup.Func),
 		resets:  make(map[string]reset.Func),
 		jobs:    make(map[string]job.Job),
+		crashes: make(map[string]*crash.Tracker),
+		usage:   make(map[string]*usage.Tracker),
+		history: make(map[string]*history.Tracker),
+		historySize: historySize,
+		triggers: triggers,
+		pending: make(map[string]bool),
+		journalConf: journals,
+		journals:    make(map[string]*journal.Journal),
 	}
 }
 
@@ -121,6 +276,16 @@ const (
 	logSubsysField string = "subsystem"
 )
 
+// closeJournals writes out the debug journals of all jobs.
+// Entries logged by a job afterwards are not recorded.
+func (s *jobs) closeJournals() {
+	s.journalsMtx.Lock()
+	defer s.journalsMtx.Unlock()
+	for _, j := range s.journals {
+		j.Close()
+	}
+}
+
 func (s *jobs) wait() <-chan struct{} {
 	ch := make(chan struct{})
 	go func() {
@@ -129,6 +294,27 @@ func (s *jobs) wait() <-chan struct{} {
 	return ch
 }
 
+// invocations returns the recorded invocations of job name, most recent first,
+// or those of all jobs except the internal ones if name is empty.
+func (s *jobs) invocations(name string) (map[string][]history.Invocation, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	if name != "" {
+		t, ok := s.history[name]
+		if !ok {
+			return nil, fmt.Errorf("job %q does not exist", name)
+		}
+		return map[string][]history.Invocation{name: t.Report()}, nil
+	}
+	ret := make(map[string][]history.Invocation, len(s.history))
+	for name, t := range s.history {
+		if !IsInternalJobName(name) {
+			ret[name] = t.Report()
+		}
+	}
+	return ret, nil
+}
+
 func (s *jobs) status() map[string]*job.Status {
 	s.m.RLock()
 	defer s.m.RUnlock()
@@ -137,14 +323,18 @@ func (s *jobs) status() map[string]*job.Status {
 		name   string
 		status *job.Status
 	}
+	goroutines := s.goroutines.Counts()
 	var wg sync.WaitGroup
 	c := make(chan res, len(s.jobs))
 	for name, j := range s.jobs {
 		wg.Add(1)
-		go func(name string, j job.Job) {
+		go func(name string, j job.Job, crashes *crash.Tracker, u *usage.Tracker) {
 			defer wg.Done()
-			c <- res{name: name, status: j.Status()}
-		}(name, j)
+			st := j.Status()
+			st.Crashes = crashes.Report()
+			st.Usage = u.Report(goroutines[name])
+			c <- res{name: name, status: st}
+		}(name, j, s.crashes[name], s.usage[name])
 	}
 	wg.Wait()
 	close(c)
@@ -166,6 +356,76 @@ func (s *jobs) wakeup(job string) error {
 	return wu()
 }
 
+// triggerRetryInterval is how often a trigger tries to wake up a job that is still running.
+const triggerRetryInterval = 1 * time.Second
+
+// trigger wakes up the jobs triggered by upstream after its successful run.
+// A triggered job that is still running is woken up once it waits for wakeups again,
+// multiple triggers while it is running result in a single run.
+func (s *jobs) trigger(ctx context.Context, upstream string) {
+	log := job.GetLogger(ctx)
+	for _, downstream := range s.triggers[upstream] {
+		s.pendingTriggers.Lock()
+		alreadyPending := s.pending[downstream]
+		s.pending[downstream] = true
+		s.pendingTriggers.Unlock()
+		if alreadyPending {
+			log.WithField("triggered_job", downstream).Info("triggered job is already pending")
+			continue
+		}
+		log.WithField("triggered_job", downstream).Info("triggering job")
+		go func(downstream string) {
+			defer func() {
+				s.pendingTriggers.Lock()
+				delete(s.pending, downstream)
+				s.pendingTriggers.Unlock()
+			}()
+			t := time.NewTicker(triggerRetryInterval)
+			defer t.Stop()
+			for {
+				err := s.wakeup(downstream)
+				if err != wakeup.AlreadyWokenUp {
+					if err != nil {
+						log.WithError(err).WithField("triggered_job", downstream).Error("cannot trigger job")
+					}
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+				}
+			}
+		}(downstream)
+	}
+}
+
+// replicateOnce starts an ad-hoc replication of the job of req or reports its status, see ReplicateOnceRequest.
+// The replication runs within the daemon, so that it does not race with the job's own invocations.
+func (s *jobs) replicateOnce(ctx context.Context, req ReplicateOnceRequest) (*job.ReplicateOnceStatus, error) {
+	s.m.RLock()
+	j, ok := s.jobs[req.Job]
+	s.m.RUnlock()
+	if !ok || IsInternalJobName(req.Job) {
+		return nil, errors.Errorf("Job %s does not exist", req.Job)
+	}
+	active, ok := j.(*job.ActiveSide)
+	if !ok {
+		return nil, errors.Errorf("job %s does not replicate", req.Job)
+	}
+	if !req.Poll {
+		ctx = job.WithLogger(ctx, job.GetLogger(ctx).WithField(logJobField, req.Job))
+		if err := active.StartReplicateOnce(ctx, req.Filesystem, req.From, req.To); err != nil {
+			return nil, err
+		}
+	}
+	status := active.ReplicateOnceStatus()
+	if status == nil {
+		return nil, errors.Errorf("job %s has no ad-hoc replication", req.Job)
+	}
+	return status, nil
+}
+
 func (s *jobs) reset(job string) error {
 	s.m.RLock()
 	defer s.m.RUnlock()
@@ -180,6 +440,8 @@ func (s *jobs) reset(job string) error {
 const (
 	jobNamePrometheus = "_prometheus"
 	jobNameControl    = "_control"
+	jobNameMaintenance = "_maintenance"
+	jobNameSnapshotMonitor = "_monitor_snapshots"
 )
 
 func IsInternalJobName(s string) bool {
@@ -204,12 +466,36 @@ func (s *jobs) start(ctx context.Context, j job.Job, internal bool) {
 		panic(fmt.Sprintf("duplicate job name %s", jobName))
 	}
 
+	if jc := s.journalConf[jobName]; jc != nil {
+		jrnl, err := journal.Open(jc.Path, int64(jc.MaxSize))
+		if err != nil {
+			jobLog.WithError(err).WithField("path", jc.Path).Error("cannot open debug journal, job runs without it")
+		} else {
+			s.journalsMtx.Lock()
+			s.journals[jobName] = jrnl
+			s.journalsMtx.Unlock()
+			jobLog = jobLog.WithOutlet(jrnl, logger.Debug)
+		}
+	}
+
 	j.RegisterMetrics(prometheus.DefaultRegisterer)
+	crashes := crash.NewTracker(jobName)
+	crashes.RegisterMetrics(prometheus.DefaultRegisterer)
+	usageTracker := usage.NewTracker(jobName)
+	usageTracker.RegisterMetrics(prometheus.DefaultRegisterer)
+	historyTracker := history.NewTracker(s.historySize)
 
 	s.jobs[jobName] = j
+	s.crashes[jobName] = crashes
+	s.usage[jobName] = usageTracker
+	s.history[jobName] = historyTracker
 	ctx = job.WithLogger(ctx, jobLog)
+	ctx = crash.WithTracker(ctx, crashes)
+	ctx = usage.WithTracker(ctx, usageTracker)
+	ctx = history.WithTracker(ctx, historyTracker)
 	ctx, wakeup := wakeup.Context(ctx)
 	ctx, resetFunc := reset.Context(ctx)
+	ctx = trigger.Context(ctx, func() { s.trigger(ctx, jobName) })
 	s.wakeups[jobName] = wakeup
 	s.resets[jobName] = resetFunc
 
@@ -218,6 +504,9 @@ func (s *jobs) start(ctx context.Context, j job.Job, internal bool) {
 		defer s.wg.Done()
 		jobLog.Info("starting job")
 		defer jobLog.Info("job exited")
-		j.Run(ctx)
+		// a crashed job stays stopped, the other jobs keep running
+		defer crashes.Recover(jobLog, crash.MainGoroutine, nil)
+		// label the job's goroutines for the goroutine count in the job's usage report
+		usage.Do(ctx, jobName, j.Run)
 	}()
 }
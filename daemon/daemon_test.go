@@ -0,0 +1,61 @@
+package daemon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/job"
+)
+
+type namedJob string
+
+func (j namedJob) Name() string                                     { return string(j) }
+func (j namedJob) Run(ctx context.Context)                          {}
+func (j namedJob) Status() *job.Status                              { return &job.Status{Type: job.TypeInternal} }
+func (j namedJob) RegisterMetrics(registerer prometheus.Registerer) {}
+
+func TestCheckJobNames(t *testing.T) {
+	assert.NoError(t, CheckJobNames([]job.Job{namedJob("prod"), namedJob("backup")}))
+	err := CheckJobNames([]job.Job{namedJob("prod"), namedJob(jobNameControl)})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), jobNameControl)
+}
+
+func jobNames(js []job.Job) []string {
+	names := make([]string, len(js))
+	for i, j := range js {
+		names[i] = j.Name()
+	}
+	return names
+}
+
+func TestGlobalJobsFromConfig(t *testing.T) {
+	conf := func(interval time.Duration, monitoring ...interface{}) *config.Config {
+		c := &config.Config{Global: &config.Global{Maintenance: &config.GlobalMaintenance{Interval: interval}}}
+		for _, m := range monitoring {
+			c.Global.Monitoring = append(c.Global.Monitoring, config.MonitoringEnum{Ret: m})
+		}
+		return c
+	}
+
+	js, err := GlobalJobsFromConfig(conf(0))
+	require.NoError(t, err)
+	assert.Empty(t, js)
+
+	js, err = GlobalJobsFromConfig(conf(24*time.Hour, &config.PrometheusMonitoring{Listen: ":9811"}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{jobNamePrometheus, jobNameMaintenance}, jobNames(js))
+
+	_, err = GlobalJobsFromConfig(conf(0, &config.PrometheusMonitoring{Listen: "no port"}))
+	assert.Error(t, err)
+
+	_, err = GlobalJobsFromConfig(conf(0, &config.SnapshotMonitoring{}, &config.SnapshotMonitoring{}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "only one snapshots monitoring job")
+}
@@ -44,6 +44,7 @@ func (m *DatasetMapFilter) Add(pathPattern, mapping string) (err error) {
 	patternCount := strings.Count(pathPattern, SUBTREE_PATTERN)
 	switch {
 	case patternCount > 1:
+		fallthrough
 	case patternCount == 1 && !strings.HasSuffix(pathPattern, SUBTREE_PATTERN):
 		err = fmt.Errorf("pattern invalid: only one '<' at end of string allowed")
 		return
@@ -0,0 +1,49 @@
+package filters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/zfs"
+)
+
+func TestDatasetMapFilterInvalidPatterns(t *testing.T) {
+	for _, pattern := range []string{"tank<<", "tank<home<", "tank<home", "<tank"} {
+		f := NewDatasetMapFilter(1, true)
+		assert.Error(t, f.Add(pattern, "ok"), "%q", pattern)
+	}
+	for _, pattern := range []string{"tank", "tank<", "tank/home<", "<"} {
+		f := NewDatasetMapFilter(1, true)
+		assert.NoError(t, f.Add(pattern, "ok"), "%q", pattern)
+	}
+}
+
+func TestDatasetMapFilterSemantics(t *testing.T) {
+	f, err := DatasetMapFilterFromConfig(map[string]bool{
+		"tank/home<":             true,
+		"tank/home/scratch<":     false,
+		"tank/home/scratch/keep": true,
+		"tank/vm":                true,
+	})
+	require.NoError(t, err)
+
+	tcs := map[string]bool{
+		"tank":                   false, // no matching entry
+		"tank/home":              true,
+		"tank/home/alice":        true,
+		"tank/home/scratch":      false, // longest prefix wins
+		"tank/home/scratch/tmp":  false,
+		"tank/home/scratch/keep": true, // a full path beats a wildcard
+		"tank/vm":                true,
+		"tank/vm/disk0":          false, // no wildcard
+	}
+	for fs, expected := range tcs {
+		p, err := zfs.NewDatasetPath(fs)
+		require.NoError(t, err)
+		pass, err := f.Filter(p)
+		require.NoError(t, err)
+		assert.Equal(t, expected, pass, "%s", fs)
+	}
+}
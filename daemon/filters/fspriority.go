@@ -0,0 +1,40 @@
+package filters
+
+import (
+	"fmt"
+	"github.com/zrepl/zrepl/zfs"
+	"strconv"
+)
+
+// DatasetPriorityMap assigns replication priorities to filesystems.
+// Patterns are matched like those of a DatasetMapFilter,
+// filesystems that match no pattern have priority 0.
+type DatasetPriorityMap struct {
+	m *DatasetMapFilter
+}
+
+func DatasetPriorityMapFromConfig(in map[string]int) (*DatasetPriorityMap, error) {
+	m := NewDatasetMapFilter(len(in), false)
+	for pathPattern, prio := range in {
+		if err := m.Add(pathPattern, strconv.Itoa(prio)); err != nil {
+			return nil, fmt.Errorf("invalid priority entry ['%s':%d]: %s", pathPattern, prio, err)
+		}
+	}
+	return &DatasetPriorityMap{m}, nil
+}
+
+func (p *DatasetPriorityMap) Priority(fs string) int {
+	path, err := zfs.NewDatasetPath(fs)
+	if err != nil {
+		return 0
+	}
+	idx, found := p.m.mostSpecificPrefixMapping(path)
+	if !found {
+		return 0
+	}
+	prio, err := strconv.Atoi(p.m.entries[idx].mapping)
+	if err != nil {
+		panic(fmt.Sprintf("implementation error: priority %q is not an integer", p.m.entries[idx].mapping))
+	}
+	return prio
+}
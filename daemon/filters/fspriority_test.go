@@ -0,0 +1,28 @@
+package filters
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDatasetPriorityMap(t *testing.T) {
+	p, err := DatasetPriorityMapFromConfig(map[string]int{
+		"pool/db<":       10,
+		"pool/db/logs<":  -1,
+		"pool/media":     -5,
+		"pool/important": 3,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 10, p.Priority("pool/db"))
+	assert.Equal(t, 10, p.Priority("pool/db/main"))
+	assert.Equal(t, -1, p.Priority("pool/db/logs/2018"), "most specific pattern wins")
+	assert.Equal(t, -5, p.Priority("pool/media"))
+	assert.Equal(t, 0, p.Priority("pool/media/movies"), "non-subtree pattern")
+	assert.Equal(t, 0, p.Priority("pool/other"))
+	assert.Equal(t, 0, p.Priority("invalid//path"))
+
+	_, err = DatasetPriorityMapFromConfig(map[string]int{"pool/db<<": 1})
+	assert.Error(t, err)
+}
@@ -1,7 +1,11 @@
 package filters
 
 import (
+	"fmt"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/util/snapname"
 	"github.com/zrepl/zrepl/zfs"
+	"regexp"
 	"strings"
 )
 
@@ -39,3 +43,49 @@ func (f *PrefixFilter) Filter(t zfs.VersionType, name string) (accept bool, err
 	prefixMatches := strings.HasPrefix(name, f.prefix)
 	return fstypeMatches && prefixMatches, nil
 }
+
+// SnapnameFilter accepts snapshots whose names were rendered by a snapname.Format.
+type SnapnameFilter struct {
+	format *snapname.Format
+}
+
+var _ zfs.FilesystemVersionFilter = &SnapnameFilter{}
+
+func NewSnapnameFilter(format *snapname.Format) *SnapnameFilter {
+	return &SnapnameFilter{format}
+}
+
+func (f *SnapnameFilter) Filter(t zfs.VersionType, name string) (accept bool, err error) {
+	return t == zfs.Snapshot && f.format.Matches(name), nil
+}
+
+// SnapshotFilter accepts all bookmarks and the snapshots whose names have a prefix and match a regular expression.
+type SnapshotFilter struct {
+	prefix string
+	regex  *regexp.Regexp // nil matches all names
+}
+
+var _ zfs.FilesystemVersionFilter = &SnapshotFilter{}
+
+// SnapshotFilterFromConfig returns nil if in is nil, i.e. if all snapshots are accepted.
+func SnapshotFilterFromConfig(in *config.SnapshotFilter) (zfs.FilesystemVersionFilter, error) {
+	if in == nil {
+		return nil, nil
+	}
+	f := &SnapshotFilter{prefix: in.Prefix}
+	if in.Regex != "" {
+		re, err := regexp.Compile(in.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot filter regex: %s", err)
+		}
+		f.regex = re
+	}
+	return f, nil
+}
+
+func (f *SnapshotFilter) Filter(t zfs.VersionType, name string) (accept bool, err error) {
+	if t != zfs.Snapshot {
+		return true, nil
+	}
+	return strings.HasPrefix(name, f.prefix) && (f.regex == nil || f.regex.MatchString(name)), nil
+}
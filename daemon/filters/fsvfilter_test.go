@@ -0,0 +1,71 @@
+package filters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/zfs"
+)
+
+func TestSnapshotFilterFromConfig(t *testing.T) {
+	f, err := SnapshotFilterFromConfig(nil)
+	require.NoError(t, err)
+	assert.Nil(t, f)
+
+	_, err = SnapshotFilterFromConfig(&config.SnapshotFilter{Regex: "zrepl_("})
+	assert.Error(t, err)
+
+	type tc struct {
+		t      zfs.VersionType
+		name   string
+		accept bool
+	}
+	for _, c := range []struct {
+		conf config.SnapshotFilter
+		tcs  []tc
+	}{
+		{
+			conf: config.SnapshotFilter{},
+			tcs:  []tc{{zfs.Snapshot, "syncoid_1", true}},
+		},
+		{
+			conf: config.SnapshotFilter{Prefix: "zrepl_"},
+			tcs: []tc{
+				{zfs.Snapshot, "zrepl_20181001_120000_000", true},
+				{zfs.Snapshot, "syncoid_1", false},
+				{zfs.Snapshot, "manual_zrepl_1", false},
+				// bookmarks are never filtered, they may be the incremental source of a filtered-in snapshot
+				{zfs.Bookmark, "syncoid_1", true},
+			},
+		},
+		{
+			conf: config.SnapshotFilter{Regex: "^(zrepl|auto)_"},
+			tcs: []tc{
+				{zfs.Snapshot, "zrepl_1", true},
+				{zfs.Snapshot, "auto_1", true},
+				{zfs.Snapshot, "manual_1", false},
+			},
+		},
+		{
+			// both must match
+			conf: config.SnapshotFilter{Prefix: "zrepl_", Regex: "_daily$"},
+			tcs: []tc{
+				{zfs.Snapshot, "zrepl_1_daily", true},
+				{zfs.Snapshot, "zrepl_1_hourly", false},
+				{zfs.Snapshot, "auto_1_daily", false},
+			},
+		},
+	} {
+		conf := c.conf
+		f, err := SnapshotFilterFromConfig(&conf)
+		require.NoError(t, err)
+		for _, tc := range c.tcs {
+			accept, err := f.Filter(tc.t, tc.name)
+			require.NoError(t, err)
+			assert.Equal(t, tc.accept, accept, "%#v %s", conf, tc.name)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package filters
+
+import (
+	"github.com/zrepl/zrepl/zfs"
+)
+
+// IntersectionFilter passes the filesystems that pass all of its filters.
+type IntersectionFilter []zfs.DatasetFilter
+
+var _ zfs.DatasetFilter = IntersectionFilter{}
+
+func (f IntersectionFilter) Filter(p *zfs.DatasetPath) (pass bool, err error) {
+	for _, filter := range f {
+		if pass, err = filter.Filter(p); err != nil || !pass {
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,207 @@
+// Package hooks implements user-configured commands that are run before and after zrepl activities.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/filters"
+	"github.com/zrepl/zrepl/logger"
+	"github.com/zrepl/zrepl/zfs"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+type Logger = logger.Logger
+
+type Phase string
+
+const (
+	PhasePreSnapshot  Phase = "pre_snapshot"
+	PhasePostSnapshot Phase = "post_snapshot"
+	PhasePostReceive  Phase = "post_receive"
+)
+
+// Env is passed to the hook command in addition to the daemon's environment.
+type Env map[string]string
+
+const (
+	EnvType     = "ZREPL_HOOKTYPE"
+	EnvFS       = "ZREPL_FS"
+	EnvSnapshot = "ZREPL_SNAPNAME"
+	EnvTimeout  = "ZREPL_TIMEOUT"
+	// the client identity of the sending side for sink jobs, the connect address for pull jobs
+	EnvSenderIdentity = "ZREPL_SENDER_IDENTITY"
+)
+
+type CommandHook struct {
+	path       string
+	timeout    time.Duration
+	errIsFatal bool
+	// nil means the hook applies to all filesystems
+	filter zfs.DatasetFilter
+}
+
+func CommandHookFromConfig(in *config.HookCommand) (*CommandHook, error) {
+	if in.Path == "" {
+		return nil, errors.New("hook path must not be empty")
+	}
+	if in.Timeout <= 0 {
+		return nil, errors.New("hook timeout must be positive")
+	}
+	h := &CommandHook{
+		path:       in.Path,
+		timeout:    in.Timeout,
+		errIsFatal: in.ErrIsFatal,
+	}
+	if len(in.Filesystems) > 0 {
+		f, err := filters.DatasetMapFilterFromConfig(in.Filesystems)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot build filesystem filter")
+		}
+		h.filter = f
+	}
+	return h, nil
+}
+
+func (h *CommandHook) String() string { return h.path }
+
+func (h *CommandHook) ErrIsFatal() bool { return h.errIsFatal }
+
+func (h *CommandHook) AppliesTo(fs *zfs.DatasetPath) (bool, error) {
+	if h.filter == nil {
+		return true, nil
+	}
+	return h.filter.Filter(fs)
+}
+
+type CommandHookError struct {
+	Hook   string
+	Phase  Phase
+	Output []byte
+	Err    error
+}
+
+func (e *CommandHookError) Error() string {
+	return fmt.Sprintf("hook %q (%s) failed: %s\noutput:\n%s", e.Hook, e.Phase, e.Err, e.Output)
+}
+
+// Run executes the hook command with env and the phase exported to its environment.
+// It returns a *CommandHookError if the command fails or does not exit within the hook's timeout.
+func (h *CommandHook) Run(ctx context.Context, phase Phase, env Env) error {
+	cmdEnv := Env{
+		EnvType:    string(phase),
+		EnvTimeout: fmt.Sprintf("%.f", h.timeout.Seconds()),
+	}
+	for k, v := range env {
+		cmdEnv[k] = v
+	}
+	output, err := RunCommand(ctx, h.path, h.timeout, cmdEnv, nil)
+	if err != nil {
+		return &CommandHookError{h.path, phase, output, err}
+	}
+	return nil
+}
+
+// RunCommand executes the command at path with env added to the daemon's environment and stdin, which may be nil, as its standard input.
+// It returns the combined standard output and standard error of the command,
+// and an error if the command fails or does not exit within timeout.
+// The command runs in its own process group which is killed as a whole on timeout,
+// so that children of the command (e.g. a shell pipeline) do not outlive it.
+func RunCommand(ctx context.Context, path string, timeout time.Duration, env Env, stdin io.Reader) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.Command(path)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Env = os.Environ()
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+	}
+	cmd.Stdin = stdin
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	waitErr := make(chan error, 1)
+	go func() {
+		waitErr <- cmd.Wait()
+	}()
+
+	var err error
+	select {
+	case err = <-waitErr:
+	case <-ctx.Done():
+		// Wait does not return before all holders of stdout exited, so kill the group, not only the child
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		err = <-waitErr
+	}
+	if err == nil {
+		return output.Bytes(), nil
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = errors.Errorf("timeout of %s exceeded", timeout)
+	}
+	return output.Bytes(), err
+}
+
+type List []*CommandHook
+
+func ListFromConfig(in []config.HookCommand) (List, error) {
+	l := make(List, len(in))
+	for i := range in {
+		h, err := CommandHookFromConfig(&in[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "hook %d", i)
+		}
+		l[i] = h
+	}
+	return l, nil
+}
+
+// Filter returns the hooks in l that apply to fs.
+func (l List) Filter(fs *zfs.DatasetPath) (List, error) {
+	applicable := make(List, 0, len(l))
+	for _, h := range l {
+		ok, err := h.AppliesTo(fs)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			applicable = append(applicable, h)
+		}
+	}
+	return applicable, nil
+}
+
+// Run runs all hooks in l in order.
+// Errors of hooks that are not fatal are logged as warnings.
+// The first error of a fatal hook is returned, but the remaining hooks are run nonetheless.
+func (l List) Run(ctx context.Context, log Logger, phase Phase, env Env) error {
+	var fatalErr error
+	for _, h := range l {
+		hlog := log.WithField("hook", h.String()).WithField("hook_phase", string(phase))
+		hlog.Debug("run hook")
+		err := h.Run(ctx, phase, env)
+		if err == nil {
+			continue
+		}
+		if !h.ErrIsFatal() {
+			hlog.WithError(err).Warn("hook failed, continuing because err_is_fatal is not set")
+			continue
+		}
+		hlog.WithError(err).Error("hook failed")
+		if fatalErr == nil {
+			fatalErr = err
+		}
+	}
+	return fatalErr
+}
@@ -0,0 +1,80 @@
+package hooks
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/logger"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeHookScript(t *testing.T, dir, name, body string) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(p, []byte("#!/bin/sh\n"+body+"\n"), 0700))
+	return p
+}
+
+func TestCommandHookRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrepl-hooks-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	envFile := filepath.Join(dir, "env")
+	envHook := writeHookScript(t, dir, "env.sh", `echo "$ZREPL_HOOKTYPE $ZREPL_FS $ZREPL_SNAPNAME" > `+envFile)
+	failHook := writeHookScript(t, dir, "fail.sh", "echo failure output; exit 1")
+	sleepHook := writeHookScript(t, dir, "sleep.sh", "sleep 5")
+	pipelineHook := writeHookScript(t, dir, "pipeline.sh", "sleep 5 | cat")
+
+	ctx := context.Background()
+	env := Env{EnvFS: "pool/fs", EnvSnapshot: "zrepl_1"}
+
+	t.Run("env", func(t *testing.T) {
+		h := &CommandHook{path: envHook, timeout: 5 * time.Second}
+		require.NoError(t, h.Run(ctx, PhasePreSnapshot, env))
+		out, err := ioutil.ReadFile(envFile)
+		require.NoError(t, err)
+		assert.Equal(t, "pre_snapshot pool/fs zrepl_1\n", string(out))
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		h := &CommandHook{path: failHook, timeout: 5 * time.Second}
+		err := h.Run(ctx, PhasePostSnapshot, env)
+		require.IsType(t, &CommandHookError{}, err)
+		assert.Equal(t, "failure output\n", string(err.(*CommandHookError).Output))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		h := &CommandHook{path: sleepHook, timeout: 100 * time.Millisecond}
+		err := h.Run(ctx, PhasePreSnapshot, env)
+		require.IsType(t, &CommandHookError{}, err)
+		assert.Contains(t, err.Error(), "timeout")
+	})
+
+	t.Run("timeout_kills_process_group", func(t *testing.T) {
+		h := &CommandHook{path: pipelineHook, timeout: 100 * time.Millisecond}
+		begin := time.Now()
+		err := h.Run(ctx, PhasePreSnapshot, env)
+		require.IsType(t, &CommandHookError{}, err)
+		assert.Contains(t, err.Error(), "timeout")
+		assert.True(t, time.Since(begin) < 2*time.Second, "children of the hook must be killed on timeout, too")
+	})
+
+	t.Run("list_error_policy", func(t *testing.T) {
+		log := logger.NewTestLogger(t)
+		warnOnly := List{&CommandHook{path: failHook, timeout: 5 * time.Second}}
+		assert.NoError(t, warnOnly.Run(ctx, log, PhasePreSnapshot, env))
+		fatal := List{
+			&CommandHook{path: failHook, timeout: 5 * time.Second, errIsFatal: true},
+			&CommandHook{path: envHook, timeout: 5 * time.Second},
+		}
+		assert.Error(t, fatal.Run(ctx, log, PhasePostSnapshot, env))
+		out, err := ioutil.ReadFile(envFile)
+		require.NoError(t, err)
+		assert.Equal(t, "post_snapshot pool/fs zrepl_1\n", string(out), "hooks after a fatal hook must still run")
+	})
+}
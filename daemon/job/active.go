@@ -2,21 +2,34 @@ package job
 
 import (
 	"context"
+	"fmt"
 	"github.com/pkg/errors"
 	"github.com/problame/go-streamrpc"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/zrepl/zrepl/config"
 	"github.com/zrepl/zrepl/daemon/filters"
+	"github.com/zrepl/zrepl/daemon/hooks"
+	"github.com/zrepl/zrepl/daemon/job/crash"
+	"github.com/zrepl/zrepl/daemon/job/history"
+	"github.com/zrepl/zrepl/daemon/job/lastsuccess"
 	"github.com/zrepl/zrepl/daemon/job/reset"
+	"github.com/zrepl/zrepl/daemon/job/trigger"
+	"github.com/zrepl/zrepl/daemon/job/usage"
 	"github.com/zrepl/zrepl/daemon/job/wakeup"
 	"github.com/zrepl/zrepl/daemon/logging"
+	"github.com/zrepl/zrepl/daemon/notify"
 	"github.com/zrepl/zrepl/daemon/pruner"
 	"github.com/zrepl/zrepl/daemon/snapper"
+	"github.com/zrepl/zrepl/daemon/streamtee"
+	"github.com/zrepl/zrepl/daemon/transport"
 	"github.com/zrepl/zrepl/daemon/transport/connecter"
 	"github.com/zrepl/zrepl/endpoint"
 	"github.com/zrepl/zrepl/replication"
+	"github.com/zrepl/zrepl/replication/fsrep"
+	"github.com/zrepl/zrepl/util"
 	"github.com/zrepl/zrepl/util/envconst"
 	"github.com/zrepl/zrepl/zfs"
+	"net"
 	"sync"
 	"time"
 )
@@ -25,6 +38,8 @@ type ActiveSide struct {
 	mode          activeMode
 	name          string
 	clientFactory *connecter.ClientFactory
+	// nil unless the job connects through the local transport
+	localConnect *config.LocalConnect
 
 	prunerFactory *pruner.PrunerFactory
 
@@ -32,6 +47,39 @@ type ActiveSide struct {
 	promRepStateSecs *prometheus.HistogramVec // labels: state
 	promPruneSecs *prometheus.HistogramVec // labels: prune_side
 	promBytesReplicated *prometheus.CounterVec // labels: filesystem
+	promBytesExpected *prometheus.GaugeVec // labels: filesystem
+	promStepThroughput prometheus.Histogram
+	promStreamReadLatency prometheus.Histogram
+
+	replicationOpts fsrep.Options
+	priorities      *filters.DatasetPriorityMap
+	gracePeriod     time.Duration
+	concurrency     int
+	// filesystems planned in parallel, the remote endpoint has max(concurrency, planConcurrency) connections
+	planConcurrency int
+	conflictPolicy  replication.ConflictPolicy
+	initialReplication replication.InitialReplicationPolicy
+	// while the receiving pool is busy with a scrub or resilver
+	busyDefer       bool
+	busyRateLimiter *util.RateLimiter
+
+	lastSuccess *lastsuccess.Tracker
+	// file in which lastSuccess is persisted, empty if it is kept in memory only
+	lastSuccessPath string
+	notify          *notify.Notifications
+
+	// plan of the last replication if it did not complete, only accessed by do
+	lastPlan *replication.Plan
+	// the snapper's SnapshotsCreated at the previous invocation, only accessed by do
+	lastSnapshotsCreated int
+
+	connMtx  sync.Mutex
+	lastConn *connecter.ConnInfo
+
+	// holds a token while an invocation or an ad-hoc replication (see StartReplicateOnce) runs
+	running  chan struct{}
+	adhocMtx sync.Mutex
+	adhoc    *adhocReplication
 
 	tasksMtx sync.Mutex
 	tasks    activeSideTasks
@@ -77,24 +125,34 @@ func (a *ActiveSide) updateTasks(u func(*activeSideTasks)) activeSideTasks {
 }
 
 type activeMode interface {
-	SenderReceiver(client *streamrpc.Client) (replication.Sender, replication.Receiver, error)
+	// remote has one client per concurrent replication step,
+	// the local endpoint gets the call timeout of remote
+	SenderReceiver(remote endpoint.Remote) (replication.Sender, replication.Receiver, error)
 	Type() Type
 	RunPeriodic(ctx context.Context, wakeUpCommon chan<- struct{})
+	// the permissions needed by the local endpoint
+	preflightRequirements() ([]permissionRequirement, error)
 }
 
 type modePush struct {
 	fsfilter         endpoint.FSFilter
+	snapshotFilter   zfs.FilesystemVersionFilter
 	snapper *snapper.PeriodicOrManual
 }
 
-func (m *modePush) SenderReceiver(client *streamrpc.Client) (replication.Sender, replication.Receiver, error) {
+func (m *modePush) SenderReceiver(remote endpoint.Remote) (replication.Sender, replication.Receiver, error) {
 	sender := endpoint.NewSender(m.fsfilter)
-	receiver := endpoint.NewRemote(client)
-	return sender, receiver, nil
+	sender.SnapshotFilter = m.snapshotFilter
+	sender.SetCallTimeout(remote.CallTimeout())
+	return sender, remote, nil
 }
 
 func (m *modePush) Type() Type { return TypePush }
 
+func (m *modePush) preflightRequirements() ([]permissionRequirement, error) {
+	return senderRequirements(m.fsfilter, m.snapper.Periodic())
+}
+
 func (m *modePush) RunPeriodic(ctx context.Context, wakeUpCommon chan <- struct{}) {
 	m.snapper.Run(ctx, wakeUpCommon)
 }
@@ -108,6 +166,10 @@ func modePushFromConfig(g *config.Global, in *config.PushJob) (*modePush, error)
 	}
 	m.fsfilter = fsf
 
+	if m.snapshotFilter, err = filters.SnapshotFilterFromConfig(in.SnapshotFilter); err != nil {
+		return nil, err
+	}
+
 	if m.snapper, err = snapper.FromConfig(g, fsf, in.Snapshotting); err != nil {
 		return nil, errors.Wrap(err, "cannot build snapper")
 	}
@@ -116,18 +178,38 @@ func modePushFromConfig(g *config.Global, in *config.PushJob) (*modePush, error)
 }
 
 type modePull struct {
-	rootFS   *zfs.DatasetPath
-	interval time.Duration
+	rootFS    *zfs.DatasetPath
+	rootRules []endpoint.RootRule
+	interval  time.Duration
+	recvFlags []string
+	recvProps zfs.RecvProperties
+	recvHooks *receiveHooks
+	recvQuota endpoint.Quota
+	// the job's own sync_properties
+	acceptProps endpoint.PropertyAllowlist
+	// the connect address, passed to recvHooks as the sender identity
+	peer string
 }
 
-func (m *modePull) SenderReceiver(client *streamrpc.Client) (replication.Sender, replication.Receiver, error) {
-	sender := endpoint.NewRemote(client)
-	receiver, err := endpoint.NewReceiver(m.rootFS)
-	return sender, receiver, err
+func (m *modePull) SenderReceiver(remote endpoint.Remote) (replication.Sender, replication.Receiver, error) {
+	sender := remote
+	receiver, err := endpoint.NewReceiver(m.rootFS, m.rootRules, m.recvFlags, zfs.RecvProperties{})
+	if err != nil {
+		return nil, nil, err
+	}
+	receiver.SetPostReceive(m.recvHooks.postReceive(m.peer))
+	receiver.SetQuota(m.recvQuota)
+	receiver.SetAcceptedProperties(m.acceptProps)
+	receiver.SetCallTimeout(remote.CallTimeout())
+	return sender, receiver, nil
 }
 
 func (*modePull) Type() Type { return TypePull }
 
+func (m *modePull) preflightRequirements() ([]permissionRequirement, error) {
+	return receiverRequirements(m.rootFS, m.rootRules, m.recvProps)
+}
+
 func (m *modePull) RunPeriodic(ctx context.Context, wakeUpCommon chan<- struct{}) {
 	t := time.NewTicker(m.interval)
 	defer t.Stop()
@@ -162,14 +244,168 @@ func modePullFromConfig(g *config.Global, in *config.PullJob) (m *modePull, err
 	if m.rootFS.Length() <= 0 {
 		return nil, errors.New("RootFS must not be empty") // duplicates error check of receiver
 	}
+	if m.rootRules, err = rootRulesFromConfig(in.RootFSMapping); err != nil {
+		return nil, errors.Wrap(err, "invalid root_fs_mapping")
+	}
+
+	if err := zfs.ValidateRecvPassThroughFlags(in.Recv.Flags); err != nil {
+		return nil, errors.Wrap(err, "invalid recv flags") // duplicates error check of receiver
+	}
+	m.recvFlags = in.Recv.Flags
+
+	m.recvProps = recvPropertiesFromConfig(in.Recv.Properties)
+	if err := m.recvProps.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid recv properties")
+	}
+	recvHooks, err := hooks.ListFromConfig(in.Recv.Hooks)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid recv hooks")
+	}
+	m.recvHooks = newReceiveHooks(recvHooks)
+	if m.recvQuota, err = recvQuotaFromConfig(in.Recv.Quota); err != nil {
+		return nil, errors.Wrap(err, "invalid recv quota")
+	}
+	if len(in.Recv.AcceptProperties) > 0 {
+		return nil, errors.New("recv.accept_properties is only for sink jobs, pull jobs accept their sync_properties")
+	}
+	// validated by activeSide
+	m.acceptProps = endpoint.PropertyAllowlist(in.SyncProperties)
 
 	return m, nil
 }
 
+// remote returns the stub for the remote endpoint, with the call timeout of the job's connect configuration
+// and the features negotiated with the peer.
+func (j *ActiveSide) remote(clients ...*streamrpc.Client) endpoint.Remote {
+	return endpoint.NewRemote(clients...).
+		WithCallTimeout(j.clientFactory.CallTimeout()).
+		WithPeerFeatures(j.clientFactory.PeerFeatures)
+}
+
+// requiredPeerFeatures returns the protocol features that the options of j need from the remote endpoint,
+// which is the receiver for push and the sender for pull jobs.
+func (j *ActiveSide) requiredPeerFeatures() []transport.RequiredFeature {
+	var required []transport.RequiredFeature
+	require := func(f transport.Feature, by string) {
+		required = append(required, transport.RequiredFeature{Feature: f, By: by})
+	}
+	opts := j.replicationOpts
+	if opts.Compressed || opts.LargeBlocks || opts.EmbeddedData {
+		require(transport.FeatureCompressedSend, "send.compressed, send.large_blocks or send.embedded_data")
+	}
+	if opts.Raw || opts.Encrypted {
+		require(transport.FeatureRawSend, "send.raw or send.encrypted")
+	}
+	if j.concurrency > 1 {
+		require(transport.FeatureMultiStream, "replication.concurrency.steps")
+	} else if j.planConcurrency > 1 {
+		require(transport.FeatureMultiStream, "replication.concurrency.planning")
+	}
+	if len(opts.Properties) > 0 {
+		require(transport.FeatureProperties, "sync_properties")
+	}
+	if pull, ok := j.mode.(*modePull); ok {
+		for _, f := range pull.recvFlags {
+			if f == "-s" {
+				require(transport.FeatureResume, "recv.flags -s")
+			}
+		}
+		if opts.StepHoldTag != "" {
+			require(transport.FeatureStepHolds, "replication.step_holds")
+		}
+	} else if j.conflictPolicy != replication.ConflictFail {
+		require(transport.FeatureResolveDivergence, "replication.conflict_policy")
+	}
+	return required
+}
+
+// stepHoldTag is the zfs hold tag with which a job holds the snapshots of multi-step replications on the sender.
+func stepHoldTag(job string) string { return endpoint.StepHoldTagPrefix + job }
+
 func activeSide(g *config.Global, in *config.ActiveJob, mode activeMode) (j *ActiveSide, err error) {
 
-	j = &ActiveSide{mode: mode}
+	j = &ActiveSide{mode: mode, running: make(chan struct{}, 1)}
 	j.name = in.Name
+	j.lastSuccess = lastsuccess.NewTracker(j.name)
+	if g.State != nil {
+		j.lastSuccessPath = lastsuccess.StatePath(g.State.Dir, j.name)
+	}
+	if j.notify, err = notify.FromConfig(in.Notify); err != nil {
+		return nil, errors.Wrap(err, "cannot build notifiers")
+	}
+	j.replicationOpts = fsrep.Options{
+		Bookmark:     in.Bookmark,
+		Raw:          in.Send.Raw,
+		Encrypted:    in.Send.Encrypted,
+		Compressed:   in.Send.Compressed,
+		LargeBlocks:  in.Send.LargeBlocks,
+		EmbeddedData: in.Send.EmbeddedData,
+		Properties:   in.SyncProperties,
+	}
+	if tee, err := streamtee.FromConfig(in.Send); err != nil {
+		return nil, errors.Wrap(err, "invalid send.tee_to")
+	} else if tee != nil {
+		j.replicationOpts.Tee = tee
+	}
+	switch in.Send.TeeOnError {
+	case "fail":
+		j.replicationOpts.TeeFailOnError = true
+	case "continue":
+	default:
+		return nil, errors.Errorf("send.tee_on_error must be fail or continue, got %q", in.Send.TeeOnError)
+	}
+	if err := (zfs.RecvProperties{Inherit: in.SyncProperties}).Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid sync_properties")
+	}
+	if in.SnapshotGracePeriod < 0 {
+		return nil, errors.Errorf("snapshot_grace_period must not be negative")
+	}
+	j.gracePeriod = in.SnapshotGracePeriod
+	if j.concurrency = in.Replication.Concurrency.Steps; j.concurrency < 1 {
+		return nil, errors.Errorf("replication.concurrency.steps must be positive")
+	}
+	switch j.planConcurrency = in.Replication.Concurrency.Planning; {
+	case j.planConcurrency < 0:
+		return nil, errors.Errorf("replication.concurrency.planning must not be negative")
+	case j.planConcurrency == 0:
+		j.planConcurrency = j.concurrency
+	}
+	j.replicationOpts.RateLimiter = util.NewRateLimiter(int64(in.Replication.BandwidthLimit))
+	if in.Replication.StepHolds {
+		j.replicationOpts.StepHoldTag = stepHoldTag(j.name)
+	}
+	if j.conflictPolicy, err = replication.ParseConflictPolicy(in.Replication.ConflictPolicy); err != nil {
+		return nil, errors.Wrap(err, "invalid replication.conflict_policy")
+	}
+	if j.initialReplication, err = replication.ParseInitialReplicationPolicy(in.Replication.InitialReplication); err != nil {
+		return nil, errors.Wrap(err, "invalid replication.initial_replication")
+	}
+	j.busyDefer = in.Replication.BusyReceiver.Defer
+	j.busyRateLimiter = util.NewRateLimiter(int64(in.Replication.BusyReceiver.BandwidthLimit))
+	if j.replicationOpts.SendStallTimeout = in.Replication.SendStallTimeout; j.replicationOpts.SendStallTimeout < 0 {
+		return nil, errors.Errorf("replication.send_stall_timeout must not be negative")
+	}
+	if j.replicationOpts.StepProgressTimeout = in.Replication.StepProgressTimeout; j.replicationOpts.StepProgressTimeout < 0 {
+		return nil, errors.Errorf("replication.step_progress_timeout must not be negative")
+	}
+	if j.priorities, err = filters.DatasetPriorityMapFromConfig(in.Priorities); err != nil {
+		return nil, errors.Wrap(err, "cannot build replication priorities")
+	}
+	if pull, ok := mode.(*modePull); ok {
+		// passed to the local receiver in the ReceiveReq, a remote receiver would refuse them
+		j.replicationOpts.RecvProperties = pull.recvProps
+		// for a sink, the receiver's recv.properties silently take precedence, but here we know both
+		for _, name := range in.SyncProperties {
+			_, overridden := pull.recvProps.Override[name]
+			inherited := false
+			for _, i := range pull.recvProps.Inherit {
+				inherited = inherited || i == name
+			}
+			if overridden || inherited {
+				return nil, errors.Errorf("property %q is specified in both sync_properties and recv.properties", name)
+			}
+		}
+	}
 	j.promRepStateSecs = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace:   "zrepl",
 		Subsystem:   "replication",
@@ -184,11 +420,43 @@ func activeSide(g *config.Global, in *config.ActiveJob, mode activeMode) (j *Act
 		Help:        "number of bytes replicated from sender to receiver per filesystem",
 		ConstLabels: prometheus.Labels{"zrepl_job":j.name},
 	}, []string{"filesystem"})
+	j.promBytesExpected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   "zrepl",
+		Subsystem:   "replication",
+		Name:        "bytes_expected",
+		Help:        "estimated size of the send streams planned by the current replication attempt per filesystem",
+		ConstLabels: prometheus.Labels{"zrepl_job":j.name},
+	}, []string{"filesystem"})
+	j.promStepThroughput = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   "zrepl",
+		Subsystem:   "replication",
+		Name:        "step_throughput_bytes_per_second",
+		Help:        "average throughput of the send streams of completed replication steps",
+		ConstLabels: prometheus.Labels{"zrepl_job":j.name},
+		Buckets:     prometheus.ExponentialBuckets(64*1024, 4, 10), // 64KiB/s to 16GiB/s
+	})
+	j.promStreamReadLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   "zrepl",
+		Subsystem:   "replication",
+		Name:        "stream_read_latency_seconds",
+		Help:        "time spent waiting for data from the sender per read from a send stream",
+		ConstLabels: prometheus.Labels{"zrepl_job":j.name},
+		Buckets:     prometheus.ExponentialBuckets(0.0001, 4, 10), // 100us to 26s
+	})
+	j.replicationOpts.StreamMetrics = &fsrep.StreamMetrics{
+		StepThroughput: j.promStepThroughput,
+		ReadLatency:    j.promStreamReadLatency,
+	}
 
 	j.clientFactory, err = connecter.FromConfig(g, in.Connect)
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot build client")
 	}
+	j.clientFactory.RequirePeerFeatures(j.requiredPeerFeatures())
+	j.localConnect, _ = in.Connect.Ret.(*config.LocalConnect)
+	if pull, ok := j.mode.(*modePull); ok {
+		pull.peer = j.clientFactory.Peer()
+	}
 
 	j.promPruneSecs = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace:   "zrepl",
@@ -209,6 +477,10 @@ func (j *ActiveSide) RegisterMetrics(registerer prometheus.Registerer) {
 	registerer.MustRegister(j.promRepStateSecs)
 	registerer.MustRegister(j.promPruneSecs)
 	registerer.MustRegister(j.promBytesReplicated)
+	registerer.MustRegister(j.promBytesExpected)
+	registerer.MustRegister(j.promStepThroughput)
+	registerer.MustRegister(j.promStreamReadLatency)
+	j.lastSuccess.RegisterMetrics(registerer)
 }
 
 func (j *ActiveSide) Name() string { return j.name }
@@ -216,13 +488,29 @@ func (j *ActiveSide) Name() string { return j.name }
 type ActiveSideStatus struct {
 	Replication *replication.Report
 	PruningSender, PruningReceiver *pruner.Report
+	LastSuccess *lastsuccess.Report
+	// nil for pull jobs and manual snapshotting
+	Snapshotting *snapper.Report
+	// most recently established connection to the passive side, nil if none yet
+	Connection *connecter.ConnInfo
+	// nil for push jobs and pull jobs without recv hooks
+	ReceiveHooks *ReceiveHooksReport
 }
 
 func (j *ActiveSide) Status() *Status {
 	tasks := j.updateTasks(nil)
 
-	s := &ActiveSideStatus{}
+	s := &ActiveSideStatus{LastSuccess: j.lastSuccess.Report()}
+	j.connMtx.Lock()
+	s.Connection = j.lastConn
+	j.connMtx.Unlock()
 	t := j.mode.Type()
+	if push, ok := j.mode.(*modePush); ok {
+		s.Snapshotting = push.snapper.Report()
+	}
+	if pull, ok := j.mode.(*modePull); ok {
+		s.ReceiveHooks = pull.recvHooks.Report()
+	}
 	if tasks.replication != nil {
 		s.Replication = tasks.replication.Report()
 	}
@@ -235,18 +523,37 @@ func (j *ActiveSide) Status() *Status {
 	return &Status{Type: t, JobSpecific: s}
 }
 
+func (j *ActiveSide) observeConn(conn net.Conn, connectTime time.Duration) {
+	info := j.clientFactory.ConnInfo(conn, connectTime)
+	j.connMtx.Lock()
+	defer j.connMtx.Unlock()
+	j.lastConn = info
+}
+
+func (j *ActiveSide) preflightRequirements() ([]permissionRequirement, error) {
+	return j.mode.preflightRequirements()
+}
+
 func (j *ActiveSide) Run(ctx context.Context) {
 	log := GetLogger(ctx)
 	ctx = logging.WithSubsystemLoggers(ctx, log)
+	ctx = lastsuccess.WithTracker(ctx, j.lastSuccess)
 
 	defer log.Info("job exiting")
 
+	persistLastSuccess(log, j.lastSuccess, j.lastSuccessPath)
+
+	logPreflight(ctx, j)
+
 	periodicDone := make(chan struct{})
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	go j.mode.RunPeriodic(ctx, periodicDone)
+	go func() {
+		defer crash.FromContext(ctx).Recover(log, "periodic", nil)
+		j.mode.RunPeriodic(ctx, periodicDone)
+	}()
 
-	invocationCount := 0
+	var urgent bool
 outer:
 	for {
 		log.Info("wait for wakeups")
@@ -256,15 +563,47 @@ outer:
 			break outer
 
 		case <-wakeup.Wait(ctx):
+			urgent = true
 		case <-periodicDone:
+			urgent = false
 		}
-		invocationCount++
-		invLog := log.WithField("invocation", invocationCount)
-		j.do(WithLogger(ctx, invLog))
+		invID := logging.NewInvocationID()
+		invLog := log.WithField(logging.InvocationField, invID)
+		j.doRecovering(WithLogger(ctx, invLog), urgent, invID)
 	}
 }
 
-func (j *ActiveSide) do(ctx context.Context) {
+// doRecovering is do, but a panic only fails the current invocation, the job waits for the next wakeup.
+// The outcome of the invocation is recorded in the job's history and reported to the job's notifiers.
+func (j *ActiveSide) doRecovering(ctx context.Context, urgent bool, invID string) {
+	inv := history.Invocation{ID: invID, Start: time.Now(), Outcome: history.Succeeded}
+	returned := false
+	defer func() {
+		inv.End = time.Now()
+		if !returned {
+			inv.Outcome = history.Failed
+			inv.Errors = append(inv.Errors, "invocation crashed, see the log")
+		}
+		history.FromContext(ctx).Record(inv)
+		j.notify.Notify(GetLogger(ctx), notify.Report{Job: j.name, Invocation: inv})
+	}()
+	defer crash.FromContext(ctx).Recover(GetLogger(ctx), "invocation", func() string {
+		return j.updateTasks(nil).state.String()
+	})
+	select {
+	case j.running <- struct{}{}:
+	default:
+		GetLogger(ctx).Info("waiting for ad-hoc replication to finish")
+		j.running <- struct{}{}
+	}
+	defer func() { <-j.running }()
+	j.do(ctx, urgent, &inv)
+	returned = true
+}
+
+// do runs replication and pruning, urgent is true if the run was triggered by zrepl signal wakeup.
+// do fills in the outcome of the invocation in inv.
+func (j *ActiveSide) do(ctx context.Context, urgent bool, inv *history.Invocation) {
 
 	log := GetLogger(ctx)
 	ctx = logging.WithSubsystemLoggers(ctx, log)
@@ -288,6 +627,7 @@ func (j *ActiveSide) do(ctx context.Context) {
 	// If the task is written to support context cancellation, it will return immediately (in permanent error state),
 	// and the sequential code above transitions to the next state.
 	go func() {
+		defer crash.FromContext(ctx).Recover(log, "watchdog", nil)
 
 		wdto := envconst.Duration("ZREPL_JOB_WATCHDOG_TIMEOUT", 10*time.Minute)
 		jitter := envconst.Duration("ZREPL_JOB_WATCHDOG_JITTER", 1*time.Second)
@@ -353,14 +693,46 @@ func (j *ActiveSide) do(ctx context.Context) {
 		}
 	}()
 
-	client, err := j.clientFactory.NewClient()
+	nclients := j.concurrency
+	if j.planConcurrency > nclients {
+		nclients = j.planConcurrency
+	}
+	clients := make([]*streamrpc.Client, nclients)
+	for i := range clients {
+		client, err := j.clientFactory.NewTrackedClient(j.observeConn, usage.FromContext(ctx))
+		if err != nil {
+			log.WithError(err).Error("factory cannot instantiate streamrpc client")
+		}
+		defer client.Close(ctx)
+		clients[i] = client
+	}
+
+	defer func() {
+		if ctx.Err() != nil {
+			inv.Outcome = history.Cancelled
+		}
+	}()
+	if push, ok := j.mode.(*modePush); ok {
+		created := push.snapper.SnapshotsCreated()
+		inv.SnapshotsCreated = created - j.lastSnapshotsCreated
+		j.lastSnapshotsCreated = created
+	}
+
+	sender, receiver, err := j.mode.SenderReceiver(j.remote(clients...))
 	if err != nil {
-		log.WithError(err).Error("factory cannot instantiate streamrpc client")
+		log.WithError(err).Error("cannot build sender and receiver")
+		inv.Outcome = history.Failed
+		inv.Errors = append(inv.Errors, fmt.Sprintf("cannot build sender and receiver: %s", err))
+		return
 	}
-	defer client.Close(ctx)
 
-	sender, receiver, err := j.mode.SenderReceiver(client)
+	replicationOpts, run := j.busyReceiverOptions(ctx, receiver, urgent)
+	if !run {
+		inv.Outcome = history.Skipped
+		return
+	}
 
+	var replicated bool
 	{
 		select {
 		case <-ctx.Done():
@@ -372,12 +744,17 @@ func (j *ActiveSide) do(ctx context.Context) {
 			// reset it
 			*tasks = activeSideTasks{}
 			tasks.replicationCancel = repCancel
-			tasks.replication = replication.NewReplication(j.promRepStateSecs, j.promBytesReplicated)
+			tasks.replication = replication.NewReplication(j.promRepStateSecs, j.promBytesReplicated, j.promBytesExpected, replicationOpts, j.priorities, j.gracePeriod, j.concurrency, j.planConcurrency, j.conflictPolicy, j.initialReplication)
+			tasks.replication.WarmStart(j.lastPlan)
 			tasks.state = ActiveSideReplicating
 		})
 		log.Info("start replication")
 		tasks.replication.Drive(ctx, sender, receiver)
 		repCancel() // always cancel to free up context resources
+		j.lastPlan = tasks.replication.RemainingPlan()
+		recordReplicationSuccess(j.lastSuccess, tasks.replication)
+		recordReplicationHistory(inv, tasks.replication)
+		replicated = tasks.replication.State() == replication.Completed
 	}
 
 	{
@@ -396,6 +773,8 @@ func (j *ActiveSide) do(ctx context.Context) {
 		tasks.prunerSender.Prune()
 		log.Info("finished pruning sender")
 		senderCancel()
+		recordPruneSuccess(j.lastSuccess, lastsuccess.PruneSender, tasks.prunerSender)
+		recordPruneHistory(inv, lastsuccess.PruneSender, tasks.prunerSender)
 	}
 	{
 		select {
@@ -413,10 +792,96 @@ func (j *ActiveSide) do(ctx context.Context) {
 		tasks.prunerReceiver.Prune()
 		log.Info("finished pruning receiver")
 		receiverCancel()
+		recordPruneSuccess(j.lastSuccess, lastsuccess.PruneReceiver, tasks.prunerReceiver)
+		recordPruneHistory(inv, lastsuccess.PruneReceiver, tasks.prunerReceiver)
 	}
 
 	j.updateTasks(func(tasks *activeSideTasks) {
 		tasks.state = ActiveSideDone
 	})
 
+	if replicated {
+		// jobs triggered by this one run after pruning, so that they see the final state of this run
+		trigger.Succeeded(ctx)
+	}
+}
+
+// persistLastSuccess makes t survive daemon restarts, see lastsuccess.Tracker.Persist.
+// If that fails, t is kept in memory only.
+func persistLastSuccess(log Logger, t *lastsuccess.Tracker, path string) {
+	if path == "" {
+		return
+	}
+	if err := t.Persist(path, log); err != nil {
+		log.WithError(err).WithField("path", path).Warn("cannot load persisted last successful runs, keeping them in memory only")
+	}
+}
+
+func recordReplicationSuccess(t *lastsuccess.Tracker, r *replication.Replication) {
+	now := time.Now()
+	rep := r.Report()
+	for _, fs := range rep.Completed {
+		if fs.Status == fsrep.Completed.String() && fs.Problem == "" {
+			t.RecordFS(lastsuccess.Replication, fs.Filesystem, now)
+		}
+	}
+	if r.State() == replication.Completed {
+		t.Record(lastsuccess.Replication, now)
+	}
+}
+
+func recordPruneSuccess(t *lastsuccess.Tracker, phase lastsuccess.Phase, p *pruner.Pruner) {
+	now := time.Now()
+	rep := p.Report()
+	for _, fs := range rep.Completed {
+		if fs.LastError == "" {
+			t.RecordFS(phase, fs.Filesystem, now)
+		}
+	}
+	// a run outside of the pruning window did not prune anything
+	if p.State() == pruner.Done && rep.PostponedUntil.IsZero() {
+		t.Record(phase, now)
+	}
+}
+
+func recordReplicationHistory(inv *history.Invocation, r *replication.Replication) {
+	rep := r.Report()
+	for _, fss := range [][]*fsrep.Report{rep.Completed, rep.Active, rep.Pending} {
+		for _, fs := range fss {
+			for _, steps := range [][]*fsrep.StepReport{fs.Completed, fs.Pending} {
+				for _, step := range steps {
+					inv.BytesReplicated += step.TransferredBytes
+				}
+			}
+			if fs.Problem != "" {
+				inv.Errors = append(inv.Errors, fmt.Sprintf("replication of %s: %s", fs.Filesystem, fs.Problem))
+			}
+		}
+	}
+	if r.State() != replication.Completed {
+		inv.Outcome = history.Failed
+		if rep.Problem != "" {
+			inv.Errors = append(inv.Errors, fmt.Sprintf("replication: %s", rep.Problem))
+		}
+	}
+}
+
+func recordPruneHistory(inv *history.Invocation, phase lastsuccess.Phase, p *pruner.Pruner) {
+	rep := p.Report()
+	for _, fs := range rep.Completed {
+		if fs.LastError != "" {
+			inv.Outcome = history.Failed
+			inv.Errors = append(inv.Errors, fmt.Sprintf("%s of %s: %s", phase, fs.Filesystem, fs.LastError))
+			continue
+		}
+		for _, snap := range fs.DestroyList {
+			if snap.Skipped == "" {
+				inv.SnapshotsDestroyed++
+			}
+		}
+	}
+	if rep.Error != "" {
+		inv.Outcome = history.Failed
+		inv.Errors = append(inv.Errors, fmt.Sprintf("%s: %s", phase, rep.Error))
+	}
 }
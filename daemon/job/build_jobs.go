@@ -21,6 +21,11 @@ func JobsFromConfig(c *config.Config) ([]Job, error) {
 	return js, nil
 }
 
+// BuildJob builds a single job, e.g. for use by a client subcommand outside of the daemon.
+func BuildJob(c *config.Global, in config.JobEnum) (Job, error) {
+	return buildJob(c, in)
+}
+
 func buildJob(c *config.Global, in config.JobEnum) (j Job, err error) {
 	cannotBuildJob := func(e error, name string) (Job, error) {
 		return nil, errors.Wrapf(e, "cannot build job %q", name)
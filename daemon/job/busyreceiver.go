@@ -0,0 +1,70 @@
+package job
+
+import (
+	"context"
+	"github.com/zrepl/zrepl/endpoint"
+	"github.com/zrepl/zrepl/replication"
+	"github.com/zrepl/zrepl/replication/fsrep"
+	"github.com/zrepl/zrepl/replication/pdu"
+	"github.com/zrepl/zrepl/zfs"
+	"strings"
+)
+
+// rootPool returns the name of the pool that contains fs.
+func rootPool(fs *zfs.DatasetPath) string {
+	return strings.SplitN(fs.ToString(), "/", 2)[0]
+}
+
+// receiverPoolBusy returns "scrub" or "resilver" if one is in progress on the receiving pool, and "" otherwise.
+func (j *ActiveSide) receiverPoolBusy(ctx context.Context, receiver replication.Receiver) (string, error) {
+	switch m := j.mode.(type) {
+	case *modePull:
+		// filesystems received below root_fs_mapping roots in other pools are not considered
+		return zfs.ZPoolScanActivity(ctx, rootPool(m.rootFS))
+	case *modePush:
+		remote, ok := receiver.(endpoint.Remote)
+		if !ok {
+			return "", nil
+		}
+		// the sink advertises its pool's activity in the handshake, which happens on the first request of this run
+		if _, _, err := remote.Ping(ctx, &pdu.PingReq{Message: "busy_receiver"}, nil); err != nil {
+			return "", err
+		}
+		j.connMtx.Lock()
+		defer j.connMtx.Unlock()
+		if j.lastConn == nil {
+			return "", nil
+		}
+		return j.lastConn.PeerPoolBusy, nil
+	default:
+		return "", nil
+	}
+}
+
+// busyReceiverOptions returns the replication options for this run, and false if the run is deferred
+// because the receiving pool is busy and the run is not urgent, i.e. not triggered by zrepl signal wakeup.
+func (j *ActiveSide) busyReceiverOptions(ctx context.Context, receiver replication.Receiver, urgent bool) (fsrep.Options, bool) {
+	opts := j.replicationOpts
+	if !j.busyDefer && j.busyRateLimiter == nil {
+		return opts, true
+	}
+	log := GetLogger(ctx)
+	activity, err := j.receiverPoolBusy(ctx, receiver)
+	if err != nil {
+		log.WithError(err).Warn("cannot determine whether the receiving pool is busy, assuming it is not")
+		return opts, true
+	}
+	if activity == "" {
+		return opts, true
+	}
+	log = log.WithField("receiver_pool_busy", activity)
+	if j.busyDefer && !urgent {
+		log.Info("receiving pool is busy, deferring run until the next wakeup")
+		return opts, false
+	}
+	if j.busyRateLimiter != nil {
+		log.Info("receiving pool is busy, replicating with busy_receiver bandwidth limit")
+		opts.RateLimiter = j.busyRateLimiter
+	}
+	return opts, true
+}
@@ -0,0 +1,155 @@
+// Package crash recovers panics in the goroutines of a job, so that a bug in one job
+// does not take down the daemon, and keeps reports of the recovered panics for zrepl status.
+package crash
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zrepl/zrepl/logger"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// maxRecent is the number of crashes of a job that Report keeps.
+const maxRecent = 5
+
+type Crash struct {
+	Time time.Time
+	// the goroutine of the job that panicked, e.g. "invocation" or "connection"
+	Goroutine string
+	// the state of the job's state machine at the time of the panic, empty if unknown
+	State string
+	Panic string
+	Stack string
+}
+
+type Report struct {
+	// number of crashes since the daemon started
+	Count int
+	// the most recent crashes, oldest first
+	Recent []*Crash
+	// true if the job's main goroutine crashed, i.e. the job does not run anymore
+	Stopped bool
+}
+
+// Tracker is safe for concurrent use.
+// All methods are no-ops on a nil *Tracker, except for Recover, which still recovers and logs the panic.
+type Tracker struct {
+	prom prometheus.Counter
+
+	mtx     sync.Mutex
+	count   int
+	recent  []*Crash
+	stopped bool
+}
+
+func NewTracker(jobName string) *Tracker {
+	return &Tracker{
+		prom: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   "zrepl",
+			Subsystem:   "job",
+			Name:        "crashes_total",
+			Help:        "number of panics recovered in the goroutines of a job",
+			ConstLabels: prometheus.Labels{"zrepl_job": jobName},
+		}),
+	}
+}
+
+func (t *Tracker) RegisterMetrics(registerer prometheus.Registerer) {
+	if t == nil {
+		return
+	}
+	registerer.MustRegister(t.prom)
+}
+
+// MainGoroutine is the goroutine name to pass to Recover for the goroutine that runs the job,
+// after whose crash the job is stopped.
+const MainGoroutine = "main"
+
+// Recover must be deferred directly in a goroutine of the job.
+// If the goroutine panics, Recover stops the panic, logs a crash report with the stack to log at error level and records it.
+// state may be nil, otherwise it is called to determine the state of the job at the time of the panic.
+func (t *Tracker) Recover(log logger.Logger, goroutine string, state func() string) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	c := &Crash{
+		Time:      time.Now(),
+		Goroutine: goroutine,
+		Panic:     fmt.Sprint(v),
+		Stack:     string(debug.Stack()),
+	}
+	if state != nil {
+		c.State = stateOrPanic(state)
+	}
+	log.
+		WithField("goroutine", c.Goroutine).
+		WithField("state", c.State).
+		WithField("panic", c.Panic).
+		WithField("stack", c.Stack).
+		Error("recovered from panic in job goroutine, this is a bug, please report it")
+	t.record(c)
+}
+
+// stateOrPanic returns the result of state, or a description of its panic.
+func stateOrPanic(state func() string) (s string) {
+	defer func() {
+		if v := recover(); v != nil {
+			s = fmt.Sprintf("unknown (panic while determining state: %v)", v)
+		}
+	}()
+	return state()
+}
+
+func (t *Tracker) record(c *Crash) {
+	if t == nil {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.count++
+	t.recent = append(t.recent, c)
+	if len(t.recent) > maxRecent {
+		t.recent = t.recent[len(t.recent)-maxRecent:]
+	}
+	if c.Goroutine == MainGoroutine {
+		t.stopped = true
+	}
+	t.prom.Inc()
+}
+
+// Report returns nil if the job has not crashed.
+func (t *Tracker) Report() *Report {
+	if t == nil {
+		return nil
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.count == 0 {
+		return nil
+	}
+	r := &Report{
+		Count:   t.count,
+		Recent:  make([]*Crash, len(t.recent)),
+		Stopped: t.stopped,
+	}
+	copy(r.Recent, t.recent)
+	return r
+}
+
+type contextKey int
+
+const contextKeyTracker contextKey = iota
+
+func WithTracker(ctx context.Context, t *Tracker) context.Context {
+	return context.WithValue(ctx, contextKeyTracker, t)
+}
+
+// FromContext returns the Tracker in ctx, or nil if there is none.
+func FromContext(ctx context.Context) *Tracker {
+	t, _ := ctx.Value(contextKeyTracker).(*Tracker)
+	return t
+}
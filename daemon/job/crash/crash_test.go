@@ -0,0 +1,56 @@
+package crash
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/logger"
+	"strings"
+	"testing"
+)
+
+func TestNilTracker(t *testing.T) {
+	var tr *Tracker
+	assert.Nil(t, tr.Report())
+	assert.Nil(t, FromContext(context.Background()))
+	assert.NotPanics(t, func() {
+		defer tr.Recover(logger.NewNullLogger(), "invocation", nil)
+		panic("bug")
+	})
+}
+
+func TestTrackerRecover(t *testing.T) {
+	tr := NewTracker("testjob")
+	log := logger.NewNullLogger()
+
+	assert.NotPanics(t, func() {
+		defer tr.Recover(log, "invocation", func() string { return "Replicating" })
+		panic("bug")
+	})
+	r := tr.Report()
+	require.NotNil(t, r)
+	assert.Equal(t, 1, r.Count)
+	assert.False(t, r.Stopped)
+	assert.Equal(t, "invocation", r.Recent[0].Goroutine)
+	assert.Equal(t, "Replicating", r.Recent[0].State)
+	assert.Equal(t, "bug", r.Recent[0].Panic)
+	assert.True(t, strings.Contains(r.Recent[0].Stack, "TestTrackerRecover"), r.Recent[0].Stack)
+
+	for i := 0; i < maxRecent; i++ {
+		func() {
+			defer tr.Recover(log, MainGoroutine, func() string { panic("state bug") })
+			panic("another bug")
+		}()
+	}
+	r = tr.Report()
+	assert.Equal(t, 1+maxRecent, r.Count)
+	assert.Len(t, r.Recent, maxRecent)
+	assert.True(t, r.Stopped)
+	assert.Equal(t, MainGoroutine, r.Recent[0].Goroutine)
+	assert.True(t, strings.HasPrefix(r.Recent[0].State, "unknown"))
+
+	func() {
+		defer tr.Recover(log, "invocation", nil)
+	}()
+	assert.Equal(t, 1+maxRecent, tr.Report().Count, "no panic, no crash")
+}
@@ -0,0 +1,132 @@
+package job
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/endpoint"
+	"github.com/zrepl/zrepl/replication"
+	"github.com/zrepl/zrepl/zfs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// FailbackFilesystem is a replication.FailbackFilesystem with the name of the filesystem on the receiver.
+type FailbackFilesystem struct {
+	*replication.FailbackFilesystem
+	// empty if the filesystem is not on the receiver
+	ReceiverName string
+}
+
+// FailbackPlan describes how to replicate the filesystems of a push or pull job back from its receiver to its sender.
+type FailbackPlan struct {
+	Filesystems []FailbackFilesystem
+	// The root_fs_mapping of a pull job on the sender that receives the filesystems under their original names.
+	// Empty if Refused is not.
+	RootFSMapping []config.RootFSMappingRule
+	// why the job cannot fail back as a whole, in addition to the problems of its filesystems
+	Refused []string
+}
+
+// PlanFailback checks that the filesystems of the job can be replicated back from the receiver to the sender,
+// see replication.CheckFailback, and determines their names on the receiver.
+// A push job does not know how the sink maps the names, receiverRoot must be the sink's root_fs/CLIENT_IDENTITY.
+// Pull jobs map the names with their own receiver and require an empty receiverRoot.
+func (j *ActiveSide) PlanFailback(ctx context.Context, receiverRoot string) (*FailbackPlan, error) {
+	var receiverName func(fs string) (string, error)
+	switch m := j.mode.(type) {
+	case *modePush:
+		if receiverRoot == "" {
+			return nil, errors.New("the root_fs/CLIENT_IDENTITY of the sink is required for push jobs")
+		}
+		root, err := zfs.NewDatasetPath(receiverRoot)
+		if err != nil || root.Length() == 0 {
+			return nil, errors.Errorf("invalid receiver root %q", receiverRoot)
+		}
+		receiverName = func(fs string) (string, error) { return path.Join(root.ToString(), fs), nil }
+	case *modePull:
+		if receiverRoot != "" {
+			return nil, errors.New("pull jobs determine the names on the receiver themselves, the receiver root must not be set")
+		}
+		receiver, err := endpoint.NewReceiver(m.rootFS, m.rootRules, nil, zfs.RecvProperties{})
+		if err != nil {
+			return nil, err
+		}
+		receiverName = receiver.LocalName
+	default:
+		return nil, errors.Errorf("job type %s does not replicate", j.mode.Type())
+	}
+
+	client, err := j.clientFactory.NewClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot build client")
+	}
+	defer client.Close(ctx)
+	sender, receiver, err := j.mode.SenderReceiver(j.remote(client))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot build sender and receiver")
+	}
+	checked, err := replication.CheckFailback(ctx, sender, receiver)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot compare sender and receiver")
+	}
+
+	plan := &FailbackPlan{Filesystems: make([]FailbackFilesystem, len(checked))}
+	for i, c := range checked {
+		plan.Filesystems[i].FailbackFilesystem = c
+		if !c.OnReceiver {
+			continue
+		}
+		if plan.Filesystems[i].ReceiverName, err = receiverName(c.Filesystem); err != nil {
+			return nil, err
+		}
+	}
+	plan.RootFSMapping, plan.Refused = failbackRootFSMapping(plan.Filesystems)
+	for _, f := range plan.Filesystems {
+		if f.Problem != "" {
+			plan.Refused = append(plan.Refused, f.Filesystem+": "+f.Problem)
+		}
+	}
+	if len(plan.Refused) > 0 {
+		plan.RootFSMapping = nil
+	}
+	return plan, nil
+}
+
+// failbackRootFSMapping returns the rules that map the parent of each filesystem's receiver name to the parent of its
+// sender name, which receives the filesystem under its sender name because the mapping preserves the last component.
+// Rules with more specific senders come first.
+func failbackRootFSMapping(fss []FailbackFilesystem) (rules []config.RootFSMappingRule, refused []string) {
+	parents := make(map[string]string)
+	for _, f := range fss {
+		if f.ReceiverName == "" {
+			continue
+		}
+		sparent, rparent := path.Dir(f.Filesystem), path.Dir(f.ReceiverName)
+		if sparent == "." {
+			refused = append(refused, f.Filesystem+": the root dataset of a pool cannot be received into")
+			continue
+		}
+		if path.Base(f.Filesystem) != path.Base(f.ReceiverName) || rparent == "." {
+			refused = append(refused, f.Filesystem+": receiver name "+f.ReceiverName+" cannot be mapped back")
+			continue
+		}
+		if prev, ok := parents[rparent]; ok && prev != sparent {
+			refused = append(refused, f.Filesystem+": receiver parent "+rparent+" is mapped to both "+prev+" and "+sparent)
+			continue
+		}
+		parents[rparent] = sparent
+	}
+	for r, s := range parents {
+		rules = append(rules, config.RootFSMappingRule{Sender: r, RootFS: s})
+	}
+	depth := func(fs string) int { return strings.Count(fs, "/") }
+	sort.Slice(rules, func(i, k int) bool {
+		if di, dk := depth(rules[i].Sender), depth(rules[k].Sender); di != dk {
+			return di > dk
+		}
+		return rules[i].Sender < rules[k].Sender
+	})
+	return rules, refused
+}
@@ -0,0 +1,84 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/replication"
+)
+
+func TestFailbackRootFSMapping(t *testing.T) {
+	fs := func(sender, receiver string) FailbackFilesystem {
+		return FailbackFilesystem{
+			FailbackFilesystem: &replication.FailbackFilesystem{Filesystem: sender, OnReceiver: receiver != ""},
+			ReceiverName:       receiver,
+		}
+	}
+	tcs := []struct {
+		name    string
+		fss     []FailbackFilesystem
+		rules   []config.RootFSMappingRule
+		refused []string
+	}{
+		{
+			name: "one parent",
+			fss: []FailbackFilesystem{
+				fs("pool/data/a", "backup/host/pool/data/a"),
+				fs("pool/data/b", "backup/host/pool/data/b"),
+			},
+			rules: []config.RootFSMappingRule{{Sender: "backup/host/pool/data", RootFS: "pool/data"}},
+		},
+		{
+			name: "more specific senders first",
+			fss: []FailbackFilesystem{
+				fs("pool/data", "backup/host/pool/data"),
+				fs("pool/data/vm/disk", "backup/host/pool/data/vm/disk"),
+				fs("other/x", "backup/host/other/x"),
+			},
+			rules: []config.RootFSMappingRule{
+				{Sender: "backup/host/pool/data/vm", RootFS: "pool/data/vm"},
+				{Sender: "backup/host/other", RootFS: "other"},
+				{Sender: "backup/host/pool", RootFS: "pool"},
+			},
+		},
+		{
+			name: "not on receiver",
+			fss: []FailbackFilesystem{
+				fs("pool/data/a", "backup/host/pool/data/a"),
+				fs("pool/data/b", ""),
+			},
+			rules: []config.RootFSMappingRule{{Sender: "backup/host/pool/data", RootFS: "pool/data"}},
+		},
+		{
+			name:    "pool root",
+			fss:     []FailbackFilesystem{fs("pool", "backup/host/pool")},
+			refused: []string{"pool: the root dataset of a pool cannot be received into"},
+		},
+		{
+			name:    "renamed",
+			fss:     []FailbackFilesystem{fs("pool/data/a", "backup/host/pool/data/b")},
+			refused: []string{"pool/data/a: receiver name backup/host/pool/data/b cannot be mapped back"},
+		},
+		{
+			name:    "receiver pool root",
+			fss:     []FailbackFilesystem{fs("pool/a", "a")},
+			refused: []string{"pool/a: receiver name a cannot be mapped back"},
+		},
+		{
+			name: "receiver parent with two sender parents",
+			fss: []FailbackFilesystem{
+				fs("pool/x/a", "backup/flat/a"),
+				fs("pool/y/b", "backup/flat/b"),
+			},
+			rules:   []config.RootFSMappingRule{{Sender: "backup/flat", RootFS: "pool/x"}},
+			refused: []string{"pool/y/b: receiver parent backup/flat is mapped to both pool/x and pool/y"},
+		},
+	}
+	for _, tc := range tcs {
+		rules, refused := failbackRootFSMapping(tc.fss)
+		assert.Equal(t, tc.rules, rules, tc.name)
+		assert.Equal(t, tc.refused, refused, tc.name)
+	}
+}
@@ -0,0 +1,98 @@
+// Package history keeps the outcomes of the most recent invocations of a job,
+// so that they can be queried after the job moved on to the next invocation.
+package history
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultSize is the default number of invocations kept per job.
+const DefaultSize = 20
+
+type Outcome string
+
+const (
+	Succeeded Outcome = "succeeded"
+	// at least one of the phases of the invocation failed, see Invocation.Errors
+	Failed Outcome = "failed"
+	// e.g. by zrepl signal reset or because the daemon is shutting down
+	Cancelled Outcome = "cancelled"
+	// e.g. because the receiving pool was busy
+	Skipped Outcome = "skipped"
+)
+
+type Invocation struct {
+	// the invocation's ID in the log, see logging.InvocationField
+	ID         string
+	Start, End time.Time
+	Outcome    Outcome
+	// replication phase
+	BytesReplicated int64 `json:",omitempty"`
+	// by the job's snapshotting since the previous invocation, always 0 for jobs that do not snapshot
+	SnapshotsCreated int `json:",omitempty"`
+	// by the pruners on both sides
+	SnapshotsDestroyed int `json:",omitempty"`
+	// one per failed phase or filesystem
+	Errors []string `json:",omitempty"`
+}
+
+// Tracker is safe for concurrent use.
+// All methods are no-ops on a nil *Tracker.
+type Tracker struct {
+	size int
+
+	mtx         sync.Mutex
+	invocations []Invocation // oldest first
+}
+
+// NewTracker returns a Tracker that keeps the size most recent invocations, DefaultSize if size <= 0.
+func NewTracker(size int) *Tracker {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	return &Tracker{size: size}
+}
+
+// Record adds the finished invocation inv, dropping the oldest one if the Tracker is full.
+func (t *Tracker) Record(inv Invocation) {
+	if t == nil {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if len(t.invocations) == t.size {
+		copy(t.invocations, t.invocations[1:])
+		t.invocations = t.invocations[:t.size-1]
+	}
+	t.invocations = append(t.invocations, inv)
+}
+
+// Report returns the recorded invocations, most recent first.
+func (t *Tracker) Report() []Invocation {
+	if t == nil {
+		return nil
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	r := make([]Invocation, len(t.invocations))
+	for i, inv := range t.invocations {
+		r[len(r)-1-i] = inv
+	}
+	return r
+}
+
+type contextKey int
+
+const contextKeyTracker contextKey = iota
+
+func WithTracker(ctx context.Context, t *Tracker) context.Context {
+	return context.WithValue(ctx, contextKeyTracker, t)
+}
+
+// FromContext returns the Tracker in ctx, or nil if there is none.
+func FromContext(ctx context.Context) *Tracker {
+	t, _ := ctx.Value(contextKeyTracker).(*Tracker)
+	return t
+}
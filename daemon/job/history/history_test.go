@@ -0,0 +1,36 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNilTrackerIsNoop(t *testing.T) {
+	var tr *Tracker
+	tr.Record(Invocation{ID: "a"})
+	assert.Nil(t, tr.Report())
+	assert.Nil(t, FromContext(context.Background()))
+}
+
+func TestTrackerKeepsMostRecent(t *testing.T) {
+	tr := NewTracker(3)
+	assert.Empty(t, tr.Report())
+	for i := 0; i < 5; i++ {
+		tr.Record(Invocation{ID: fmt.Sprint(i)})
+	}
+	r := tr.Report()
+	ids := make([]string, len(r))
+	for i, inv := range r {
+		ids[i] = inv.ID
+	}
+	assert.Equal(t, []string{"4", "3", "2"}, ids)
+
+	// the report must be a copy
+	r[0].ID = "x"
+	assert.Equal(t, "4", tr.Report()[0].ID)
+
+	assert.Equal(t, tr, FromContext(WithTracker(context.Background(), tr)))
+	assert.Equal(t, DefaultSize, NewTracker(0).size)
+}
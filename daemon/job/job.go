@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zrepl/zrepl/daemon/job/crash"
+	"github.com/zrepl/zrepl/daemon/job/usage"
 	"github.com/zrepl/zrepl/logger"
 )
 
@@ -48,6 +50,10 @@ const (
 type Status struct {
 	Type Type
 	JobSpecific interface{}
+	// set by the daemon, nil if the job has not crashed
+	Crashes *crash.Report
+	// set by the daemon
+	Usage *usage.Report
 }
 
 func (s *Status) MarshalJSON() ([]byte, error) {
@@ -63,6 +69,20 @@ func (s *Status) MarshalJSON() ([]byte, error) {
 		"type": typeJson,
 		string(s.Type): jobJSON,
 	}
+	if s.Crashes != nil {
+		crashesJSON, err := json.Marshal(s.Crashes)
+		if err != nil {
+			return nil, err
+		}
+		m["crashes"] = crashesJSON
+	}
+	if s.Usage != nil {
+		usageJSON, err := json.Marshal(s.Usage)
+		if err != nil {
+			return nil, err
+		}
+		m["usage"] = usageJSON
+	}
 	return json.Marshal(m)
 }
 
@@ -78,6 +98,16 @@ func (s *Status) UnmarshalJSON(in []byte) (err error) {
 	if err := json.Unmarshal(tJSON, &s.Type); err != nil {
 		return err
 	}
+	if crashesJSON, ok := m["crashes"]; ok {
+		if err := json.Unmarshal(crashesJSON, &s.Crashes); err != nil {
+			return err
+		}
+	}
+	if usageJSON, ok := m["usage"]; ok {
+		if err := json.Unmarshal(usageJSON, &s.Usage); err != nil {
+			return err
+		}
+	}
 	key := string(s.Type)
 	jobJSON, ok := m[key]
 	if !ok {
@@ -0,0 +1,302 @@
+// Package journal records the log entries of a job at debug level, independent of the configured log level,
+// to a gzip-compressed ring of files for the post-mortem analysis of intermittent replication failures.
+//
+// The journal contains what the job logs, e.g. state transitions, RPCs (without their payload) and errors.
+package journal
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/zrepl/zrepl/logger"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxSize is the default upper bound of the compressed size of a journal.
+const DefaultMaxSize = 64 << 20
+
+const (
+	// The ring consists of this many segment files, the oldest one is removed when a new one is started.
+	segments = 4
+	// Records are flushed to the current segment after this interval,
+	// i.e. a crash loses at most the records of the last interval.
+	flushInterval = 1 * time.Second
+
+	segmentPrefix = "journal-"
+	segmentSuffix = ".gz"
+)
+
+type Record struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"msg"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// Journal is a logger.Outlet.
+type Journal struct {
+	dir        string
+	segmentMax int64
+
+	mtx     sync.Mutex
+	seq     int // of the current segment
+	file    *os.File
+	written *countingWriter // compressed bytes of the current segment
+	enc     *gzip.Writer
+	dirty   bool
+	closed  bool
+	stop    chan struct{}
+}
+
+var _ logger.Outlet = (*Journal)(nil)
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Open starts a new segment of the journal in dir, which is created if it does not exist.
+// The segments of previous runs of the daemon are kept as part of the ring.
+// maxSize bounds the compressed size of all segments, 0 means DefaultMaxSize.
+func Open(dir string, maxSize int64) (*Journal, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	seqs, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{
+		dir:        dir,
+		segmentMax: maxSize / segments,
+		stop:       make(chan struct{}),
+	}
+	if len(seqs) > 0 {
+		j.seq = seqs[len(seqs)-1]
+	}
+	if err := j.startSegment(); err != nil {
+		return nil, err
+	}
+	go j.flushLoop()
+	return j, nil
+}
+
+func segmentName(seq int) string {
+	return fmt.Sprintf("%s%08d%s", segmentPrefix, seq, segmentSuffix)
+}
+
+// listSegments returns the sequence numbers of the segments in dir in ascending order.
+func listSegments(dir string) ([]int, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var seqs []int
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		seq, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix))
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+	return seqs, nil
+}
+
+// j.mtx must be held
+func (j *Journal) closeSegment() error {
+	if j.file == nil {
+		return nil
+	}
+	err := j.enc.Close()
+	if cerr := j.file.Close(); err == nil {
+		err = cerr
+	}
+	j.file, j.enc = nil, nil
+	return err
+}
+
+// j.mtx must be held
+func (j *Journal) startSegment() error {
+	if err := j.closeSegment(); err != nil {
+		return err
+	}
+	j.seq++
+	f, err := os.OpenFile(filepath.Join(j.dir, segmentName(j.seq)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	j.written = &countingWriter{w: f}
+	// the journal is written all the time, but rarely read
+	j.enc, err = gzip.NewWriterLevel(j.written, gzip.BestSpeed)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	j.file = f
+	j.dirty = false
+
+	seqs, err := listSegments(j.dir)
+	if err != nil {
+		return err
+	}
+	for len(seqs) > segments {
+		if err := os.Remove(filepath.Join(j.dir, segmentName(seqs[0]))); err != nil {
+			return err
+		}
+		seqs = seqs[1:]
+	}
+	return nil
+}
+
+func (j *Journal) flushLoop() {
+	t := time.NewTicker(flushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-t.C:
+		}
+		j.mtx.Lock()
+		if j.dirty && !j.closed {
+			j.enc.Flush() // an error surfaces in the next WriteEntry
+			j.dirty = false
+		}
+		j.mtx.Unlock()
+	}
+}
+
+func recordFromEntry(e logger.Entry) Record {
+	r := Record{
+		Time:    e.Time,
+		Level:   e.Level.String(),
+		Message: e.Message,
+	}
+	if len(e.Fields) > 0 {
+		r.Fields = make(map[string]interface{}, len(e.Fields))
+	}
+	for k, v := range e.Fields {
+		switch v := v.(type) {
+		case error:
+			r.Fields[k] = v.Error()
+		case fmt.Stringer:
+			r.Fields[k] = v.String()
+		default:
+			r.Fields[k] = v
+		}
+	}
+	return r
+}
+
+func (j *Journal) WriteEntry(e logger.Entry) error {
+	rec := recordFromEntry(e)
+	b, err := json.Marshal(rec)
+	if err != nil {
+		for k, v := range rec.Fields {
+			rec.Fields[k] = fmt.Sprintf("%v", v)
+		}
+		if b, err = json.Marshal(rec); err != nil {
+			return err
+		}
+	}
+	b = append(b, '\n')
+
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	if j.closed {
+		return errors.New("journal is closed")
+	}
+	if _, err := j.enc.Write(b); err != nil {
+		return err
+	}
+	j.dirty = true
+	if j.written.n >= j.segmentMax {
+		return j.startSegment()
+	}
+	return nil
+}
+
+// Close writes the remaining records and closes the current segment.
+func (j *Journal) Close() error {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	if j.closed {
+		return nil
+	}
+	j.closed = true
+	close(j.stop)
+	return j.closeSegment()
+}
+
+// ReadRecords calls f for every record of the journal in dir, oldest first, until f returns an error.
+// A segment that was not closed, e.g. because the daemon crashed or is still writing it,
+// is read up to the last flush.
+func ReadRecords(dir string, f func(r Record) error) error {
+	seqs, err := listSegments(dir)
+	if err != nil {
+		return err
+	}
+	for _, seq := range seqs {
+		if err := readSegment(filepath.Join(dir, segmentName(seq)), f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readSegment(path string, f func(r Record) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // removed by the daemon in the meantime
+		}
+		return err
+	}
+	defer file.Close()
+	dec, err := gzip.NewReader(file)
+	if err == io.EOF {
+		return nil // nothing flushed yet
+	} else if err != nil {
+		return errors.Wrapf(err, "segment %s", path)
+	}
+	defer dec.Close()
+	s := bufio.NewScanner(dec)
+	s.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for s.Scan() {
+		var r Record
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			continue // cut off by a crash
+		}
+		if err := f(r); err != nil {
+			return err
+		}
+	}
+	if err := s.Err(); err != nil && err != io.ErrUnexpectedEOF {
+		return errors.Wrapf(err, "segment %s", path)
+	}
+	return nil
+}
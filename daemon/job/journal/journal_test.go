@@ -0,0 +1,113 @@
+package journal
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/logger"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readAll(t *testing.T, dir string) []Record {
+	var recs []Record
+	require.NoError(t, ReadRecords(dir, func(r Record) error {
+		recs = append(recs, r)
+		return nil
+	}))
+	return recs
+}
+
+func entry(i int) logger.Entry {
+	return logger.Entry{
+		Level:   logger.Debug,
+		Message: fmt.Sprintf("entry %d", i),
+		Time:    time.Unix(int64(i), 0),
+		Fields: logger.Fields{
+			"i":   i,
+			"err": fmt.Errorf("error %d", i),
+		},
+	}
+}
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrepl-journal-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	j, err := Open(dir, 0)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, j.WriteEntry(entry(i)))
+	}
+	require.NoError(t, j.Close())
+
+	// a restarted daemon continues the journal
+	j, err = Open(dir, 0)
+	require.NoError(t, err)
+	require.NoError(t, j.WriteEntry(entry(3)))
+	require.NoError(t, j.Close())
+	assert.Error(t, j.WriteEntry(entry(4)))
+
+	recs := readAll(t, dir)
+	require.Len(t, recs, 4)
+	for i, r := range recs {
+		assert.Equal(t, fmt.Sprintf("entry %d", i), r.Message)
+		assert.Equal(t, "debug", r.Level)
+		assert.True(t, r.Time.Equal(time.Unix(int64(i), 0)))
+		assert.Equal(t, float64(i), r.Fields["i"])
+		assert.Equal(t, fmt.Sprintf("error %d", i), r.Fields["err"])
+	}
+}
+
+func TestJournalRing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrepl-journal-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	const maxSize = 4 * 1024
+	j, err := Open(dir, maxSize)
+	require.NoError(t, err)
+	const n = 5000
+	for i := 0; i < n; i++ {
+		e := entry(i)
+		e.Fields["padding"] = strings.Repeat(fmt.Sprint(i), 10) // defeat compression a little
+		require.NoError(t, j.WriteEntry(e))
+	}
+	require.NoError(t, j.Close())
+
+	seqs, err := listSegments(dir)
+	require.NoError(t, err)
+	assert.Len(t, seqs, segments)
+
+	// the oldest entries were dropped, the remaining ones are in order and end with the last one
+	recs := readAll(t, dir)
+	require.NotEmpty(t, recs)
+	assert.True(t, len(recs) < n)
+	assert.Equal(t, fmt.Sprintf("entry %d", n-1), recs[len(recs)-1].Message)
+	for i := 1; i < len(recs); i++ {
+		assert.True(t, recs[i-1].Time.Before(recs[i].Time))
+	}
+}
+
+func TestJournalReadWhileWriting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrepl-journal-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	j, err := Open(dir, 0)
+	require.NoError(t, err)
+	defer j.Close()
+	require.NoError(t, j.WriteEntry(entry(0)))
+
+	// the segment is neither closed nor flushed yet
+	assert.Len(t, readAll(t, dir), 0)
+
+	time.Sleep(flushInterval + 500*time.Millisecond)
+	recs := readAll(t, dir)
+	require.Len(t, recs, 1)
+	assert.Equal(t, "entry 0", recs[0].Message)
+}
@@ -0,0 +1,210 @@
+// Package lastsuccess tracks when the phases of a job last completed successfully,
+// for the job as a whole and per filesystem.
+package lastsuccess
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zrepl/zrepl/logger"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type Phase string
+
+const (
+	Snapshot      Phase = "snapshot"
+	Replication   Phase = "replication"
+	PruneSender   Phase = "prune_sender"
+	PruneReceiver Phase = "prune_receiver"
+)
+
+// Tracker is safe for concurrent use.
+// All methods are no-ops on a nil *Tracker.
+type Tracker struct {
+	prom *prometheus.GaugeVec // labels: phase, filesystem ("" for the job as a whole)
+
+	mtx sync.Mutex
+	job map[Phase]time.Time
+	fss map[string]map[Phase]time.Time
+
+	// set by Persist, empty if the times are kept in memory only
+	path string
+	log  logger.Logger
+}
+
+func NewTracker(jobName string) *Tracker {
+	return &Tracker{
+		prom: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   "zrepl",
+			Subsystem:   "job",
+			Name:        "last_success_timestamp",
+			Help:        "unix timestamp of the last successful completion of a job phase (filesystem label empty for the job as a whole)",
+			ConstLabels: prometheus.Labels{"zrepl_job": jobName},
+		}, []string{"phase", "filesystem"}),
+		job: make(map[Phase]time.Time),
+		fss: make(map[string]map[Phase]time.Time),
+	}
+}
+
+func (t *Tracker) RegisterMetrics(registerer prometheus.Registerer) {
+	if t == nil {
+		return
+	}
+	registerer.MustRegister(t.prom)
+}
+
+// Record records the successful completion of phase by the job as a whole.
+func (t *Tracker) Record(phase Phase, at time.Time) {
+	if t == nil {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.job[phase] = at
+	t.prom.WithLabelValues(string(phase), "").Set(float64(at.Unix()))
+	t.save()
+}
+
+// RecordFS records the successful completion of phase for filesystem fs.
+func (t *Tracker) RecordFS(phase Phase, fs string, at time.Time) {
+	if t == nil {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	m, ok := t.fss[fs]
+	if !ok {
+		m = make(map[Phase]time.Time)
+		t.fss[fs] = m
+	}
+	m[phase] = at
+	t.prom.WithLabelValues(string(phase), fs).Set(float64(at.Unix()))
+	t.save()
+}
+
+// Report contains the time of the last successful completion of each phase.
+// Phases that never completed successfully are not contained in the maps.
+type Report struct {
+	Job         map[Phase]time.Time
+	Filesystems map[string]map[Phase]time.Time
+}
+
+func (t *Tracker) Report() *Report {
+	if t == nil {
+		return nil
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.report()
+}
+
+func (t *Tracker) report() *Report {
+	r := &Report{
+		Job:         make(map[Phase]time.Time, len(t.job)),
+		Filesystems: make(map[string]map[Phase]time.Time, len(t.fss)),
+	}
+	for p, at := range t.job {
+		r.Job[p] = at
+	}
+	for fs, m := range t.fss {
+		c := make(map[Phase]time.Time, len(m))
+		for p, at := range m {
+			c[p] = at
+		}
+		r.Filesystems[fs] = c
+	}
+	return r
+}
+
+// StatePath returns the path of the file in stateDir in which the times of job are persisted.
+func StatePath(stateDir, job string) string {
+	return filepath.Join(stateDir, "lastsuccess", job+".json")
+}
+
+// Persist loads the times persisted in the file at path, if it exists,
+// and persists all times recorded from now on to that file.
+// Times recorded before the call take precedence over the persisted ones.
+// Errors writing the file are logged to log and do not affect recording in memory.
+func (t *Tracker) Persist(path string, log logger.Logger) error {
+	if t == nil {
+		return nil
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		var persisted Report
+		if err := json.Unmarshal(b, &persisted); err != nil {
+			return errors.Wrapf(err, "cannot parse %s", path)
+		}
+		for p, at := range persisted.Job {
+			if _, ok := t.job[p]; !ok {
+				t.job[p] = at
+				t.prom.WithLabelValues(string(p), "").Set(float64(at.Unix()))
+			}
+		}
+		for fs, m := range persisted.Filesystems {
+			if t.fss[fs] == nil {
+				t.fss[fs] = make(map[Phase]time.Time, len(m))
+			}
+			for p, at := range m {
+				if _, ok := t.fss[fs][p]; !ok {
+					t.fss[fs][p] = at
+					t.prom.WithLabelValues(string(p), fs).Set(float64(at.Unix()))
+				}
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	t.path, t.log = path, log
+	return nil
+}
+
+// save writes the times to t.path, atomically replacing the previous file.
+// t.mtx must be held.
+func (t *Tracker) save() {
+	if t.path == "" {
+		return
+	}
+	err := func() error {
+		b, err := json.Marshal(t.report())
+		if err != nil {
+			return err
+		}
+		tmp := t.path + ".tmp"
+		if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+			return err
+		}
+		return os.Rename(tmp, t.path)
+	}()
+	if err != nil {
+		t.log.WithError(err).WithField("path", t.path).Warn("cannot persist last successful runs")
+	}
+}
+
+type contextKey int
+
+const contextKeyTracker contextKey = iota
+
+func WithTracker(ctx context.Context, t *Tracker) context.Context {
+	return context.WithValue(ctx, contextKeyTracker, t)
+}
+
+// FromContext returns the Tracker in ctx, or nil if there is none.
+func FromContext(ctx context.Context) *Tracker {
+	t, _ := ctx.Value(contextKeyTracker).(*Tracker)
+	return t
+}
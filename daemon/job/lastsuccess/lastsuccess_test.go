@@ -0,0 +1,76 @@
+package lastsuccess
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/logger"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNilTrackerIsNoop(t *testing.T) {
+	var tr *Tracker
+	tr.Record(Replication, time.Now())
+	tr.RecordFS(Replication, "pool/fs", time.Now())
+	assert.Nil(t, tr.Report())
+	assert.Nil(t, FromContext(context.Background()))
+}
+
+func TestTrackerReport(t *testing.T) {
+	tr := NewTracker("testjob")
+	t1 := time.Unix(1000, 0)
+	t2 := time.Unix(2000, 0)
+	tr.Record(Snapshot, t1)
+	tr.RecordFS(Snapshot, "pool/a", t1)
+	tr.RecordFS(Snapshot, "pool/a", t2)
+	tr.RecordFS(PruneSender, "pool/b", t1)
+
+	r := tr.Report()
+	assert.Equal(t, map[Phase]time.Time{Snapshot: t1}, r.Job)
+	assert.Equal(t, map[Phase]time.Time{Snapshot: t2}, r.Filesystems["pool/a"])
+	assert.Equal(t, map[Phase]time.Time{PruneSender: t1}, r.Filesystems["pool/b"])
+
+	// the report must be a copy
+	r.Filesystems["pool/a"][Snapshot] = t1
+	assert.Equal(t, t2, tr.Report().Filesystems["pool/a"][Snapshot])
+
+	assert.Equal(t, tr, FromContext(WithTracker(context.Background(), tr)))
+}
+
+func TestTrackerPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrepl-lastsuccess-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := StatePath(dir, "testjob")
+	log := logger.NewTestLogger(t)
+
+	t1 := time.Unix(1000, 0)
+	t2 := time.Unix(2000, 0)
+
+	tr := NewTracker("testjob")
+	require.NoError(t, tr.Persist(path, log))
+	tr.Record(Replication, t1)
+	tr.RecordFS(Replication, "pool/a", t1)
+
+	// a restarted daemon starts with the persisted times
+	restarted := NewTracker("testjob")
+	restarted.Record(Snapshot, t2)
+	require.NoError(t, restarted.Persist(path, log))
+	r := restarted.Report()
+	assert.True(t, t1.Equal(r.Job[Replication]))
+	assert.True(t, t2.Equal(r.Job[Snapshot]))
+	assert.True(t, t1.Equal(r.Filesystems["pool/a"][Replication]))
+
+	// recording after Persist updates the file
+	restarted.RecordFS(Replication, "pool/a", t2)
+	again := NewTracker("testjob")
+	require.NoError(t, again.Persist(path, log))
+	assert.True(t, t2.Equal(again.Report().Filesystems["pool/a"][Replication]))
+	assert.True(t, t2.Equal(again.Report().Job[Snapshot]))
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("garbage"), 0600))
+	assert.Error(t, NewTracker("testjob").Persist(path, log))
+}
@@ -1,18 +1,34 @@
 package job
 
 import (
+	"bytes"
 	"context"
 	"github.com/pkg/errors"
 	"github.com/problame/go-streamrpc"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/zrepl/zrepl/config"
 	"github.com/zrepl/zrepl/daemon/filters"
+	"github.com/zrepl/zrepl/daemon/hooks"
+	"github.com/zrepl/zrepl/daemon/job/crash"
+	"github.com/zrepl/zrepl/daemon/job/history"
+	"github.com/zrepl/zrepl/daemon/job/lastsuccess"
+	"github.com/zrepl/zrepl/daemon/job/usage"
 	"github.com/zrepl/zrepl/daemon/logging"
+	"github.com/zrepl/zrepl/daemon/notify"
+	"github.com/zrepl/zrepl/daemon/streamrpcconfig"
+	"github.com/zrepl/zrepl/daemon/transport"
 	"github.com/zrepl/zrepl/daemon/transport/serve"
 	"github.com/zrepl/zrepl/daemon/snapper"
 	"github.com/zrepl/zrepl/endpoint"
+	"github.com/zrepl/zrepl/util/envconst"
+	"github.com/zrepl/zrepl/util/faultinject"
 	"github.com/zrepl/zrepl/zfs"
+	"io"
+	"net"
 	"path"
+	"strings"
+	"sync"
+	"time"
 )
 
 type PassiveSide struct {
@@ -20,43 +36,188 @@ type PassiveSide struct {
 	name     string
 	l        serve.ListenerFactory
 	rpcConf  *streamrpc.ConnConfig
+	// empty unless the job serves the local transport
+	localListener string
+
+	lastSuccess *lastsuccess.Tracker
+	// file in which lastSuccess is persisted, empty if it is kept in memory only
+	lastSuccessPath string
+	notify          *notify.Notifications
+
+	historyMtx sync.Mutex
+	// the snapper's SnapshotsCreated at the previous invocation, protected by historyMtx
+	lastSnapshotsCreated int
 }
 
 type passiveMode interface {
 	ConnHandleFunc(ctx context.Context, conn serve.AuthenticatedConn) streamrpc.HandlerFunc
 	RunPeriodic(ctx context.Context)
 	Type() Type
+	// the permissions needed by the local endpoint
+	preflightRequirements() ([]permissionRequirement, error)
+	// the permissions needed by the local endpoint to serve client
+	clientPreflightRequirements(client string) ([]permissionRequirement, error)
 }
 
 type modeSink struct {
 	rootDataset *zfs.DatasetPath
+	rootRules   []endpoint.RootRule
+	recvFlags   []string
+	recvProps   zfs.RecvProperties
+	recvHooks   *receiveHooks
+	recvQuota   endpoint.Quota
+	acceptProps endpoint.PropertyAllowlist
+
+	poolBusyMtx sync.Mutex
+	// handshake extensions for the scan activity of the receiving pool, see RunPeriodic
+	poolBusy []string
 }
 
 func (m *modeSink) Type() Type { return TypeSink }
 
+// handshakeExtensions advertise a scrub or resilver of the receiving pool to connecting senders.
+// They are called for every accepted connection and return the pool activity last seen by RunPeriodic.
+func (m *modeSink) handshakeExtensions() []string {
+	m.poolBusyMtx.Lock()
+	defer m.poolBusyMtx.Unlock()
+	return m.poolBusy
+}
+
+func (m *modeSink) updatePoolBusy(ctx context.Context, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	activity, err := zfs.ZPoolScanActivity(ctx, rootPool(m.rootDataset))
+	if err != nil {
+		// not being able to tell is no reason to refuse connections
+		GetLogger(ctx).WithError(err).Debug("cannot determine scrub or resilver of receiving pool")
+		activity = ""
+	}
+	m.poolBusyMtx.Lock()
+	m.poolBusy = transport.PoolBusyExtensions(activity)
+	m.poolBusyMtx.Unlock()
+}
+
+func (m *modeSink) preflightRequirements() ([]permissionRequirement, error) {
+	// clients receive below root_fs/CLIENT_IDENTITY, which inherits the permissions on root_fs
+	return receiverRequirements(m.rootDataset, m.rootRules, m.recvProps)
+}
+
+func (m *modeSink) clientPreflightRequirements(client string) ([]permissionRequirement, error) {
+	clientRoot, clientRules, err := m.clientRoots(client)
+	if err != nil {
+		return nil, err
+	}
+	return receiverRequirements(clientRoot, clientRules, m.recvProps)
+}
+
 func (m *modeSink) ConnHandleFunc(ctx context.Context, conn serve.AuthenticatedConn) streamrpc.HandlerFunc {
+	local := m.clientReceiver(ctx, conn.ClientIdentity())
+	if local == nil {
+		return nil
+	}
+	h := endpoint.NewHandler(local)
+	return h.Handle
+}
+
+// clientRoots returns root_fs/CLIENT and the root_fs_mapping rules with CLIENT appended to their roots,
+// below which client receives.
+func (m *modeSink) clientRoots(client string) (*zfs.DatasetPath, []endpoint.RootRule, error) {
+	// the transport validated the identity, but a client must never receive into root_fs or outside of it
+	if err := serve.ValidateClientIdentity(client); err != nil {
+		return nil, nil, errors.Wrap(err, "client identity must be a valid ZFS FS name")
+	}
+	clientRoot, err := zfs.NewDatasetPath(path.Join(m.rootDataset.ToString(), client))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "client identity must be a valid ZFS FS name")
+	}
+	// isolate clients from each other in the roots of the mapping rules, too
+	clientRules := make([]endpoint.RootRule, len(m.rootRules))
+	for i, r := range m.rootRules {
+		root, err := zfs.NewDatasetPath(path.Join(r.Root.ToString(), client))
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "cannot build client root for root_fs_mapping")
+		}
+		clientRules[i] = endpoint.RootRule{Sender: r.Sender, Root: root}
+	}
+	return clientRoot, clientRules, nil
+}
+
+// clientReceiver returns the receiver for client, which receives below root_fs/client,
+// or nil if the client identity is invalid.
+func (m *modeSink) clientReceiver(ctx context.Context, client string) *endpoint.Receiver {
 	log := GetLogger(ctx)
 
-	clientRootStr := path.Join(m.rootDataset.ToString(), conn.ClientIdentity())
-	clientRoot, err := zfs.NewDatasetPath(clientRootStr)
+	clientRoot, clientRules, err := m.clientRoots(client)
 	if err != nil {
 		log.WithError(err).
-			WithField("client_identity", conn.ClientIdentity()).
-			Error("cannot build client filesystem map (client identity must be a valid ZFS FS name")
+			WithField("client_identity", client).
+			Error("cannot build client filesystem map")
+		return nil
 	}
 	log.WithField("client_root", clientRoot).Debug("client root")
 
-	local, err := endpoint.NewReceiver(clientRoot)
+	local, err := endpoint.NewReceiver(clientRoot, clientRules, m.recvFlags, m.recvProps)
 	if err != nil {
 		log.WithError(err).Error("unexpected error: cannot convert mapping to filter")
 		return nil
 	}
+	local.SetPostReceive(m.recvHooks.postReceive(client))
+	local.SetQuota(m.recvQuota)
+	local.SetAcceptedProperties(m.acceptProps)
+	local.SetClientIdentity(client)
+	return local
+}
 
-	h := endpoint.NewHandler(local)
-	return h.Handle
+// RunPeriodic polls the scrub or resilver activity of the receiving pool for handshakeExtensions,
+// so that zpool status does not delay accepting connections.
+func (m *modeSink) RunPeriodic(ctx context.Context) {
+	interval := envconst.Duration("ZREPL_SINK_POOL_BUSY_POLL_INTERVAL", 30*time.Second)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		m.updatePoolBusy(ctx, interval)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func rootRulesFromConfig(in []config.RootFSMappingRule) ([]endpoint.RootRule, error) {
+	rules := make([]endpoint.RootRule, len(in))
+	for i, r := range in {
+		sender, err := zfs.NewDatasetPath(r.Sender)
+		if err != nil || sender.Length() <= 0 {
+			return nil, errors.Errorf("rule #%d: sender must be a non-empty zfs filesystem path", i)
+		}
+		root, err := zfs.NewDatasetPath(r.RootFS)
+		if err != nil || root.Length() <= 0 {
+			return nil, errors.Errorf("rule #%d: root_fs must be a non-empty zfs filesystem path", i)
+		}
+		rules[i] = endpoint.RootRule{Sender: sender, Root: root}
+	}
+	return rules, nil
 }
 
-func (m *modeSink) RunPeriodic(_ context.Context) {}
+func recvPropertiesFromConfig(in config.RecvProperties) zfs.RecvProperties {
+	return zfs.RecvProperties{Override: in.Override, Inherit: in.Inherit}
+}
+
+func recvQuotaFromConfig(in config.RecvQuota) (endpoint.Quota, error) {
+	q := endpoint.Quota{
+		MaxFilesystems:  in.MaxFilesystems,
+		MaxUsed:         int64(in.MaxUsed),
+		MaxPoolCapacity: in.MaxPoolCapacity,
+	}
+	if q.MaxFilesystems < 0 || q.MaxUsed < 0 {
+		return endpoint.Quota{}, errors.New("limits must not be negative")
+	}
+	if q.MaxPoolCapacity < 0 || q.MaxPoolCapacity > 100 {
+		return endpoint.Quota{}, errors.New("max_pool_capacity must be a percentage between 0 and 100")
+	}
+	return q, nil
+}
 
 func modeSinkFromConfig(g *config.Global, in *config.SinkJob) (m *modeSink, err error) {
 	m = &modeSink{}
@@ -67,11 +228,36 @@ func modeSinkFromConfig(g *config.Global, in *config.SinkJob) (m *modeSink, err
 	if m.rootDataset.Length() <= 0 {
 		return nil, errors.New("root dataset must not be empty") // duplicates error check of receiver
 	}
+	if m.rootRules, err = rootRulesFromConfig(in.RootFSMapping); err != nil {
+		return nil, errors.Wrap(err, "invalid root_fs_mapping")
+	}
+	if err := zfs.ValidateRecvPassThroughFlags(in.Recv.Flags); err != nil {
+		return nil, errors.Wrap(err, "invalid recv flags") // duplicates error check of receiver
+	}
+	m.recvFlags = in.Recv.Flags
+	m.recvProps = recvPropertiesFromConfig(in.Recv.Properties)
+	if err := m.recvProps.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid recv properties") // duplicates error check of receiver
+	}
+	recvHooks, err := hooks.ListFromConfig(in.Recv.Hooks)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid recv hooks")
+	}
+	m.recvHooks = newReceiveHooks(recvHooks)
+	if m.recvQuota, err = recvQuotaFromConfig(in.Recv.Quota); err != nil {
+		return nil, errors.Wrap(err, "invalid recv quota")
+	}
+	if m.acceptProps, err = endpoint.NewPropertyAllowlist(in.Recv.AcceptProperties); err != nil {
+		return nil, errors.Wrap(err, "invalid recv.accept_properties")
+	}
 	return m, nil
 }
 
 type modeSource struct {
 	fsfilter zfs.DatasetFilter
+	// by client identity, intersected with fsfilter
+	clientFilters map[string]zfs.DatasetFilter
+	snapshotFilter zfs.FilesystemVersionFilter
 	snapper *snapper.PeriodicOrManual
 }
 
@@ -84,6 +270,14 @@ func modeSourceFromConfig(g *config.Global, in *config.SourceJob) (m *modeSource
 	}
 	m.fsfilter = fsf
 
+	if m.clientFilters, err = clientFiltersFromConfig(fsf, in.Serve, in.ClientFilesystems); err != nil {
+		return nil, err
+	}
+
+	if m.snapshotFilter, err = filters.SnapshotFilterFromConfig(in.SnapshotFilter); err != nil {
+		return nil, err
+	}
+
 	if m.snapper, err = snapper.FromConfig(g, fsf, in.Snapshotting); err != nil {
 		return nil, errors.Wrap(err, "cannot build snapper")
 	}
@@ -93,8 +287,70 @@ func modeSourceFromConfig(g *config.Global, in *config.SourceJob) (m *modeSource
 
 func (m *modeSource) Type() Type { return TypeSource }
 
+func (m *modeSource) preflightRequirements() ([]permissionRequirement, error) {
+	return senderRequirements(m.fsfilter, m.snapper.Periodic())
+}
+
+func (m *modeSource) clientPreflightRequirements(client string) ([]permissionRequirement, error) {
+	fsfilter := m.clientFilter(client)
+	if fsfilter == nil {
+		return nil, errors.Errorf("client_filesystems has no entry for client %q", client)
+	}
+	// snapshotting does not depend on the client and is checked with the job itself
+	return senderRequirements(fsfilter, false)
+}
+
+// clientFiltersFromConfig builds the filters of client_filesystems, intersected with fsf.
+// Each client must be accepted by the serve transport serveConf.
+func clientFiltersFromConfig(fsf zfs.DatasetFilter, serveConf config.ServeEnum, in map[string]config.FilesystemsFilter) (map[string]zfs.DatasetFilter, error) {
+	configured, restricted := serve.ConfiguredClientIdentities(serveConf)
+	clientFilters := make(map[string]zfs.DatasetFilter, len(in))
+	for client, filter := range in {
+		if err := serve.ValidateClientIdentity(client); err != nil {
+			return nil, errors.Wrapf(err, "invalid client identity %q in client_filesystems", client)
+		}
+		if restricted && !containsString(configured, client) {
+			return nil, errors.Errorf("client_filesystems: client %q is not accepted by the serve transport (clients: %s)",
+				client, strings.Join(configured, ", "))
+		}
+		cf, err := filters.DatasetMapFilterFromConfig(filter)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot build filesystem filter for client %q", client)
+		}
+		clientFilters[client] = filters.IntersectionFilter{fsf, cf}
+	}
+	return clientFilters, nil
+}
+
+func containsString(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// clientFilter returns the filesystems exposed to client, nil if none:
+// if client_filesystems is set, clients without an entry are refused.
+func (m *modeSource) clientFilter(client string) zfs.DatasetFilter {
+	if len(m.clientFilters) == 0 {
+		return m.fsfilter
+	}
+	return m.clientFilters[client]
+}
+
 func (m *modeSource) ConnHandleFunc(ctx context.Context, conn serve.AuthenticatedConn) streamrpc.HandlerFunc {
-	sender := endpoint.NewSender(m.fsfilter)
+	fsfilter := m.clientFilter(conn.ClientIdentity())
+	if fsfilter == nil {
+		GetLogger(ctx).
+			WithField("client_identity", conn.ClientIdentity()).
+			Error("refusing client: client_filesystems has no entry for it")
+		return nil
+	}
+	sender := endpoint.NewSender(fsfilter)
+	sender.SnapshotFilter = m.snapshotFilter
+	sender.SetClientIdentity(conn.ClientIdentity())
 	h := endpoint.NewHandler(sender)
 	return h.Handle
 }
@@ -106,27 +362,61 @@ func (m *modeSource) RunPeriodic(ctx context.Context) {
 func passiveSideFromConfig(g *config.Global, in *config.PassiveJob, mode passiveMode) (s *PassiveSide, err error) {
 
 	s = &PassiveSide{mode: mode, name: in.Name}
+	s.lastSuccess = lastsuccess.NewTracker(s.name)
+	if g.State != nil {
+		s.lastSuccessPath = lastsuccess.StatePath(g.State.Dir, s.name)
+	}
+	if s.notify, err = notify.FromConfig(in.Notify); err != nil {
+		return nil, errors.Wrap(err, "cannot build notifiers")
+	}
 	if s.l, s.rpcConf, err = serve.FromConfig(g, in.Serve); err != nil {
 		return nil, errors.Wrap(err, "cannot build server")
 	}
+	if local, ok := in.Serve.Ret.(*config.LocalServe); ok {
+		s.localListener = local.ListenerName
+	}
+	if sink, ok := mode.(*modeSink); ok {
+		s.l = serve.WithHandshakeExtensions(s.l, sink.handshakeExtensions)
+	}
 
 	return s, nil
 }
 
 func (j *PassiveSide) Name() string { return j.name }
 
-type PassiveStatus struct {}
+type PassiveStatus struct {
+	LastSuccess *lastsuccess.Report
+	// nil for sink jobs and manual snapshotting
+	Snapshotting *snapper.Report
+	// nil for source jobs and sink jobs without recv hooks
+	ReceiveHooks *ReceiveHooksReport
+}
 
 func (s *PassiveSide) Status() *Status {
-	return &Status{Type: s.mode.Type()} // FIXME PassiveStatus
+	st := &PassiveStatus{LastSuccess: s.lastSuccess.Report()}
+	if source, ok := s.mode.(*modeSource); ok {
+		st.Snapshotting = source.snapper.Report()
+	}
+	if sink, ok := s.mode.(*modeSink); ok {
+		st.ReceiveHooks = sink.recvHooks.Report()
+	}
+	return &Status{Type: s.mode.Type(), JobSpecific: st}
 }
 
-func (*PassiveSide) RegisterMetrics(registerer prometheus.Registerer) {}
+func (j *PassiveSide) RegisterMetrics(registerer prometheus.Registerer) {
+	j.lastSuccess.RegisterMetrics(registerer)
+}
+
+func (j *PassiveSide) preflightRequirements() ([]permissionRequirement, error) {
+	return j.mode.preflightRequirements()
+}
 
 func (j *PassiveSide) Run(ctx context.Context) {
 
 	log := GetLogger(ctx)
 	defer log.Info("job exiting")
+	ctx = lastsuccess.WithTracker(ctx, j.lastSuccess)
+	persistLastSuccess(log, j.lastSuccess, j.lastSuccessPath)
 
 	l, err := j.l.Listen()
 	if err != nil {
@@ -135,43 +425,41 @@ func (j *PassiveSide) Run(ctx context.Context) {
 	}
 	defer l.Close()
 
+	logPreflight(ctx, j)
+
 	{
 		ctx, cancel := context.WithCancel(logging.WithSubsystemLoggers(ctx, log)) // shadowing
 		defer cancel()
-		go j.mode.RunPeriodic(ctx)
+		go func() {
+			defer crash.FromContext(ctx).Recover(log, "periodic", nil)
+			j.mode.RunPeriodic(ctx)
+		}()
 	}
 
 	log.WithField("addr", l.Addr()).Debug("accepting connections")
+	// listeners log rejected connections to the serve subsystem
+	acceptCtx := logging.WithSubsystemLoggers(ctx, log)
 	var connId int
 outer:
 	for {
 
 		select {
-		case res := <-accept(ctx, l):
+		case res := <-accept(acceptCtx, l):
 			if res.err != nil {
 				log.WithError(res.err).Info("accept error")
 				continue
 			}
 			conn := res.conn
 			connId++
+			invID := logging.NewInvocationID()
 			connLog := log.
-				WithField("connID", connId)
+				WithField("connID", connId).
+				WithField(logging.InvocationField, invID)
 			connLog.
 				WithField("addr", conn.RemoteAddr()).
 				WithField("client_identity", conn.ClientIdentity()).
 				Info("handling connection")
-			go func() {
-				defer connLog.Info("finished handling connection")
-				defer conn.Close()
-				ctx := logging.WithSubsystemLoggers(ctx, connLog)
-				handleFunc := j.mode.ConnHandleFunc(ctx, conn)
-				if handleFunc == nil {
-					return
-				}
-				if err := streamrpc.ServeConn(ctx, conn, j.rpcConf, handleFunc); err != nil {
-					log.WithError(err).Error("error serving client")
-				}
-			}()
+			go j.serveConn(ctx, connLog, invID, conn)
 
 		case <-ctx.Done():
 			break outer
@@ -181,6 +469,72 @@ outer:
 
 }
 
+// serveConn serves the client of conn until the connection ends, a panic only fails the connection.
+// Each connection is an invocation of the job, its outcome is recorded in the job's history and reported to the job's notifiers.
+func (j *PassiveSide) serveConn(ctx context.Context, connLog Logger, invID string, conn serve.AuthenticatedConn) {
+	inv := history.Invocation{ID: invID, Start: time.Now(), Outcome: history.Succeeded}
+	returned := false
+	defer func() {
+		inv.End = time.Now()
+		if !returned {
+			inv.Outcome = history.Failed
+			inv.Errors = append(inv.Errors, "connection crashed, see the log")
+		}
+		inv.SnapshotsCreated = j.snapshotsCreatedSinceLastInvocation()
+		history.FromContext(ctx).Record(inv)
+		j.notify.Notify(connLog, notify.Report{Job: j.name, Invocation: inv})
+	}()
+	defer connLog.Info("finished handling connection")
+	defer conn.Close()
+	rpcConf := streamrpcconfig.Negotiate(j.rpcConf, serve.PeerExtensions(conn))
+	defer usage.FromContext(ctx).AddConn(usage.ConnBufferLimitBytes(rpcConf))()
+	defer crash.FromContext(ctx).Recover(connLog, "connection", nil)
+
+	ctx = logging.WithSubsystemLoggers(ctx, connLog)
+	handleFunc := j.mode.ConnHandleFunc(ctx, conn)
+	if handleFunc == nil {
+		inv.Outcome = history.Failed
+		inv.Errors = append(inv.Errors, "client refused, see the log")
+		returned = true
+		return
+	}
+	handleFunc = endpoint.CallTimeoutHandler(handleFunc, rpcConf.Timeout)
+	if faults := faultinject.FromEnv(); faults != nil {
+		handleFunc = injectFaults(faults, conn, handleFunc)
+	}
+	err := streamrpc.ServeConn(ctx, conn, rpcConf, handleFunc)
+	returned = true
+	recordServeConnHistory(ctx, &inv, err)
+	if err != nil {
+		connLog.WithError(err).Error("error serving client")
+	}
+}
+
+func recordServeConnHistory(ctx context.Context, inv *history.Invocation, err error) {
+	switch {
+	case ctx.Err() != nil:
+		inv.Outcome = history.Cancelled
+	case err != nil:
+		inv.Outcome = history.Failed
+		inv.Errors = append(inv.Errors, err.Error())
+	}
+}
+
+// snapshotsCreatedSinceLastInvocation returns the number of snapshots the job's snapper created
+// since the previous call, always 0 for sink jobs.
+func (j *PassiveSide) snapshotsCreatedSinceLastInvocation() int {
+	source, ok := j.mode.(*modeSource)
+	if !ok {
+		return 0
+	}
+	j.historyMtx.Lock()
+	defer j.historyMtx.Unlock()
+	created := source.snapper.SnapshotsCreated()
+	n := created - j.lastSnapshotsCreated
+	j.lastSnapshotsCreated = created
+	return n
+}
+
 type acceptResult struct {
 	conn serve.AuthenticatedConn
 	err  error
@@ -194,3 +548,18 @@ func accept(ctx context.Context, listener serve.AuthenticatedListener) <-chan ac
 	}()
 	return c
 }
+
+// injectFaults wraps h so that faults configured for injection point server.RPCNAME are returned to the client,
+// or, for disconnect faults, conn is closed.
+func injectFaults(faults *faultinject.Injector, conn net.Conn, h streamrpc.HandlerFunc) streamrpc.HandlerFunc {
+	return func(ctx context.Context, endpoint string, reqStructured *bytes.Buffer, reqStream io.ReadCloser) (*bytes.Buffer, io.ReadCloser, error) {
+		if err := faults.Inject("server." + endpoint); err != nil {
+			GetLogger(ctx).WithError(err).Warn("injecting fault")
+			if faultinject.IsDisconnect(err) {
+				conn.Close()
+			}
+			return nil, nil, err
+		}
+		return h(ctx, endpoint, reqStructured, reqStream)
+	}
+}
@@ -0,0 +1,144 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/filters"
+	"github.com/zrepl/zrepl/daemon/job/history"
+	"github.com/zrepl/zrepl/daemon/snapper"
+	"github.com/zrepl/zrepl/daemon/transport"
+	"github.com/zrepl/zrepl/endpoint"
+	"github.com/zrepl/zrepl/logger"
+	"github.com/zrepl/zrepl/zfs"
+)
+
+func exposes(t *testing.T, f zfs.DatasetFilter, fs string) bool {
+	p, err := zfs.NewDatasetPath(fs)
+	require.NoError(t, err)
+	pass, err := f.Filter(p)
+	require.NoError(t, err)
+	return pass
+}
+
+func TestSourceClientFilters(t *testing.T) {
+	fsf, err := filters.DatasetMapFilterFromConfig(config.FilesystemsFilter{"pool<": true})
+	require.NoError(t, err)
+	tlsServe := config.ServeEnum{Ret: &config.TLSServe{ClientCNs: []string{"backup1", "backup2"}}}
+
+	cfs, err := clientFiltersFromConfig(fsf, tlsServe, map[string]config.FilesystemsFilter{
+		"backup1": {"pool/db<": true},
+	})
+	require.NoError(t, err)
+	m := &modeSource{fsfilter: fsf, clientFilters: cfs}
+
+	backup1 := m.clientFilter("backup1")
+	require.NotNil(t, backup1)
+	assert.True(t, exposes(t, backup1, "pool/db/a"))
+	assert.False(t, exposes(t, backup1, "pool/home"))
+	assert.Nil(t, m.clientFilter("backup2"), "clients without an entry must be refused")
+
+	// without client_filesystems, all clients are exposed the filesystems of the job
+	m = &modeSource{fsfilter: fsf}
+	all := m.clientFilter("backup2")
+	require.NotNil(t, all)
+	assert.True(t, exposes(t, all, "pool/home"))
+}
+
+func TestSourceClientFiltersUnknownClient(t *testing.T) {
+	fsf, err := filters.DatasetMapFilterFromConfig(config.FilesystemsFilter{"pool<": true})
+	require.NoError(t, err)
+	cf := map[string]config.FilesystemsFilter{"backup3": {"pool/db<": true}}
+
+	tlsServe := config.ServeEnum{Ret: &config.TLSServe{ClientCNs: []string{"backup1", "backup2"}}}
+	_, err = clientFiltersFromConfig(fsf, tlsServe, cf)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"backup3"`)
+
+	tcpServe := config.ServeEnum{Ret: &config.TCPServe{Clients: map[string]string{"10.0.0.3": "backup3"}}}
+	_, err = clientFiltersFromConfig(fsf, tcpServe, cf)
+	assert.NoError(t, err)
+
+	// the local transport accepts any client identity
+	localServe := config.ServeEnum{Ret: &config.LocalServe{ListenerName: "src"}}
+	_, err = clientFiltersFromConfig(fsf, localServe, cf)
+	assert.NoError(t, err)
+}
+
+func TestSinkClientReceiver(t *testing.T) {
+	path := func(s string) *zfs.DatasetPath {
+		p, err := zfs.NewDatasetPath(s)
+		require.NoError(t, err)
+		return p
+	}
+	m := &modeSink{
+		rootDataset: path("pool/backup"),
+		rootRules:   []endpoint.RootRule{{Sender: path("tank/vm"), Root: path("fast/vm")}},
+	}
+	ctx := WithLogger(context.Background(), logger.NewTestLogger(t))
+
+	r := m.clientReceiver(ctx, "laptop1")
+	require.NotNil(t, r)
+	local, err := r.LocalName("tank/home")
+	require.NoError(t, err)
+	assert.Equal(t, "pool/backup/laptop1/tank/home", local)
+	local, err = r.LocalName("tank/vm/a")
+	require.NoError(t, err)
+	assert.Equal(t, "fast/vm/laptop1/a", local, "clients are isolated in the roots of root_fs_mapping, too")
+
+	for _, invalid := range []string{"", "a/b", "bad@identity", "../x"} {
+		assert.Nil(t, m.clientReceiver(ctx, invalid), "%q", invalid)
+	}
+}
+
+func TestSinkHandshakeExtensionsCached(t *testing.T) {
+	root, err := zfs.NewDatasetPath("zrepl_test_nonexistent_pool/backup")
+	require.NoError(t, err)
+	m := &modeSink{rootDataset: root}
+	assert.Empty(t, m.handshakeExtensions())
+
+	// Accept must not wait for zpool status, it only returns what RunPeriodic saw last
+	m.poolBusy = transport.PoolBusyExtensions("scrub")
+	assert.Equal(t, transport.PoolBusyExtensions("scrub"), m.handshakeExtensions())
+
+	// a pool whose activity cannot be determined is not advertised as busy
+	m.updatePoolBusy(context.Background(), time.Second)
+	assert.Empty(t, m.handshakeExtensions())
+}
+
+func TestRecordServeConnHistory(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tcs := []struct {
+		name    string
+		ctx     context.Context
+		err     error
+		outcome history.Outcome
+		errors  []string
+	}{
+		{"served", context.Background(), nil, history.Succeeded, nil},
+		{"error", context.Background(), errors.New("connection reset"), history.Failed, []string{"connection reset"}},
+		{"job stopped", cancelled, errors.New("context canceled"), history.Cancelled, nil},
+	}
+	for _, tc := range tcs {
+		inv := history.Invocation{Outcome: history.Succeeded}
+		recordServeConnHistory(tc.ctx, &inv, tc.err)
+		assert.Equal(t, tc.outcome, inv.Outcome, tc.name)
+		assert.Equal(t, tc.errors, inv.Errors, tc.name)
+	}
+}
+
+func TestPassiveSnapshotsCreatedSinceLastInvocation(t *testing.T) {
+	sink := &PassiveSide{mode: &modeSink{}}
+	assert.Equal(t, 0, sink.snapshotsCreatedSinceLastInvocation())
+
+	// manual snapshotting creates no snapshots
+	source := &PassiveSide{mode: &modeSource{snapper: &snapper.PeriodicOrManual{}}}
+	assert.Equal(t, 0, source.snapshotsCreatedSinceLastInvocation())
+}
@@ -0,0 +1,303 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/zrepl/zrepl/endpoint"
+	"github.com/zrepl/zrepl/zfs"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// preflighter is implemented by jobs that can check whether the daemon's user
+// has the delegated ZFS permissions (zfs allow) needed for their operations.
+type preflighter interface {
+	// the permissions needed by the job's local endpoint
+	preflightRequirements() ([]permissionRequirement, error)
+}
+
+// PreflightResult is the outcome of the permission check of a job, see Preflight.
+type PreflightResult struct {
+	Job     string
+	Missing []MissingPermissions
+	// the permissions could not be checked
+	Err error
+}
+
+// MissingPermissions are the permissions that must be granted to User on Dataset for Reason.
+type MissingPermissions struct {
+	User        string
+	Dataset     string
+	Permissions []string
+	Reason      string
+}
+
+// GrantCommand returns the zfs allow command that grants the missing permissions.
+func (m MissingPermissions) GrantCommand() string {
+	return fmt.Sprintf("zfs allow -u %s %s %s", m.User, strings.Join(m.Permissions, ","), m.Dataset)
+}
+
+// permissionRequirement is a set of permissions needed on a dataset,
+// or on the datasets below it if descendants is true.
+type permissionRequirement struct {
+	dataset     *zfs.DatasetPath
+	descendants bool
+	perms       []string
+	reason      string
+}
+
+// senderRequirements are the permissions needed on every filesystem that passes fsfilter
+// for zfs send, the replication cursor, step holds and pruning, and for taking snapshots if snapshotting is true.
+func senderRequirements(fsfilter zfs.DatasetFilter, snapshotting bool) ([]permissionRequirement, error) {
+	fss, err := zfsListMapping(fsfilter)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot list filesystems")
+	}
+	perms := []string{"send", "bookmark", "hold", "release", "destroy", "mount"}
+	reason := "send, replication cursor, step holds and pruning"
+	if snapshotting {
+		perms = append(perms, "snapshot")
+		reason = "snapshotting, " + reason
+	}
+	reqs := make([]permissionRequirement, len(fss))
+	for i, fs := range fss {
+		reqs[i] = permissionRequirement{dataset: fs, perms: perms, reason: reason}
+	}
+	return reqs, nil
+}
+
+// receiverRequirements are the permissions needed below root and the roots of rules
+// for zfs recv, placeholders and pruning, and for setting or inheriting props on receive.
+// mountpoint is needed because placeholders are created with mountpoint=none.
+func receiverRequirements(root *zfs.DatasetPath, rules []endpoint.RootRule, props zfs.RecvProperties) ([]permissionRequirement, error) {
+	propPerms := append([]string{}, props.Inherit...)
+	for p := range props.Override {
+		propPerms = append(propPerms, p)
+	}
+	sort.Strings(propPerms)
+	perms := append([]string{"create", "mount", "mountpoint", "receive", "userprop", "destroy", "rollback", "rename"}, propPerms...)
+
+	roots := []*zfs.DatasetPath{root}
+	seen := map[string]bool{root.ToString(): true}
+	for _, r := range rules {
+		if !seen[r.Root.ToString()] {
+			seen[r.Root.ToString()] = true
+			roots = append(roots, r.Root)
+		}
+	}
+	reqs := make([]permissionRequirement, 0, len(roots))
+	for _, r := range roots {
+		// the receiver creates missing datasets below the closest one that exists
+		existing, err := zfsNearestExisting(r)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot check whether %q exists", r.ToString())
+		}
+		if existing == nil {
+			return nil, errors.Errorf("neither %q nor any of its parents exist", r.ToString())
+		}
+		reqs = append(reqs, permissionRequirement{
+			dataset:     existing,
+			descendants: true,
+			perms:       perms,
+			reason:      fmt.Sprintf("receive below %s, placeholders, conflict resolution and pruning", r.ToString()),
+		})
+	}
+	return reqs, nil
+}
+
+func currentDelegationIdentity() (id zfs.DelegationIdentity, name string, err error) {
+	u, err := user.Current()
+	if err != nil {
+		return id, "", errors.Wrap(err, "cannot determine daemon user")
+	}
+	id.Users = []string{u.Username, u.Uid}
+	gids, err := u.GroupIds()
+	if err != nil {
+		return id, "", errors.Wrap(err, "cannot determine groups of daemon user")
+	}
+	for _, gid := range gids {
+		id.Groups = append(id.Groups, gid)
+		if g, err := user.LookupGroupId(gid); err == nil {
+			id.Groups = append(id.Groups, g.Name)
+		}
+	}
+	return id, u.Username, nil
+}
+
+// mergeRequirements merges the requirements on the same dataset, so that each is checked and reported once.
+func mergeRequirements(reqs []permissionRequirement) []permissionRequirement {
+	type key struct {
+		dataset     string
+		descendants bool
+	}
+	merged := make([]permissionRequirement, 0, len(reqs))
+	idx := make(map[key]int, len(reqs))
+	for _, r := range reqs {
+		k := key{r.dataset.ToString(), r.descendants}
+		i, ok := idx[k]
+		if !ok {
+			idx[k] = len(merged)
+			merged = append(merged, permissionRequirement{
+				dataset:     r.dataset,
+				descendants: r.descendants,
+				perms:       append([]string{}, r.perms...),
+				reason:      r.reason,
+			})
+			continue
+		}
+		m := &merged[i]
+		for _, p := range r.perms {
+			if !containsString(m.perms, p) {
+				m.perms = append(m.perms, p)
+			}
+		}
+		if !containsString(strings.Split(m.reason, "; "), r.reason) {
+			m.reason += "; " + r.reason
+		}
+	}
+	return merged
+}
+
+// jobRequirements returns the permissions job j needs.
+// If j connects to a passive job of jobs through the local transport, both endpoints run in this daemon,
+// so j also needs the permissions of the passive job's endpoint for j's client identity.
+func jobRequirements(j preflighter, jobs []Job) ([]permissionRequirement, error) {
+	reqs, err := j.preflightRequirements()
+	if err != nil {
+		return nil, err
+	}
+	a, ok := j.(*ActiveSide)
+	if !ok || a.localConnect == nil {
+		return mergeRequirements(reqs), nil
+	}
+	for _, other := range jobs {
+		p, ok := other.(*PassiveSide)
+		if !ok || p.localListener != a.localConnect.ListenerName {
+			continue
+		}
+		peerReqs, err := p.mode.clientPreflightRequirements(a.localConnect.ClientIdentity)
+		if err != nil {
+			return nil, errors.Wrapf(err, "local peer job %q", p.name)
+		}
+		for _, r := range peerReqs {
+			r.reason = fmt.Sprintf("%s for local peer job %s", r.reason, p.name)
+			reqs = append(reqs, r)
+		}
+	}
+	return mergeRequirements(reqs), nil
+}
+
+// Preflight checks the delegated permissions of the job named only, or of all jobs if only is empty.
+// The permissions delegated on all datasets that the checked jobs need are listed once.
+// No permissions are missing if the daemon runs as root.
+func Preflight(jobs []Job, only string) []PreflightResult {
+	var results []PreflightResult
+	var checked []preflighter
+	for _, j := range jobs {
+		p, ok := j.(preflighter)
+		if !ok || (only != "" && j.Name() != only) {
+			continue
+		}
+		results = append(results, PreflightResult{Job: j.Name()})
+		checked = append(checked, p)
+	}
+	if geteuid() == 0 {
+		return results // root does not need delegated permissions
+	}
+	fail := func(err error) []PreflightResult {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i].Err = err
+			}
+		}
+		return results
+	}
+
+	id, name, err := currentDelegationIdentity()
+	if err != nil {
+		return fail(err)
+	}
+	reqs := make([][]permissionRequirement, len(checked))
+	var datasets []*zfs.DatasetPath
+	for i, p := range checked {
+		if reqs[i], results[i].Err = jobRequirements(p, jobs); results[i].Err != nil {
+			continue
+		}
+		for _, r := range reqs[i] {
+			datasets = append(datasets, r.dataset)
+		}
+	}
+	delegations, err := zfsAllowAll(datasets)
+	if err != nil {
+		return fail(errors.Wrap(err, "cannot list delegated permissions"))
+	}
+	for i := range checked {
+		for _, r := range reqs[i] {
+			if m := delegations[r.dataset.ToString()].Missing(id, r.descendants, r.perms); len(m) > 0 {
+				results[i].Missing = append(results[i].Missing, MissingPermissions{
+					User:        name,
+					Dataset:     r.dataset.ToString(),
+					Permissions: m,
+					Reason:      r.reason,
+				})
+			}
+		}
+	}
+	return results
+}
+
+// The functions used by Preflight, replaced by tests.
+var (
+	zfsListMapping     = zfs.ZFSListMapping
+	zfsNearestExisting = zfs.ZFSNearestExisting
+	zfsAllowAll        = zfs.ZFSAllowAll
+	geteuid            = os.Geteuid
+)
+
+// delegationChecked is set once CheckDelegation succeeded, the jobs then need not check their permissions again.
+var delegationChecked int32
+
+// logPreflight logs the missing permissions of a job before its first run,
+// so that they show up at startup instead of as failures in the middle of a run.
+func logPreflight(ctx context.Context, j Job) {
+	if atomic.LoadInt32(&delegationChecked) != 0 {
+		return
+	}
+	log := GetLogger(ctx)
+	for _, r := range Preflight([]Job{j}, "") {
+		if r.Err != nil {
+			log.WithError(r.Err).Warn("cannot check delegated zfs permissions")
+			continue
+		}
+		for _, m := range r.Missing {
+			log.WithField("dataset", m.Dataset).
+				WithField("missing", strings.Join(m.Permissions, ",")).
+				WithField("grant", m.GrantCommand()).
+				Error(fmt.Sprintf("missing delegated zfs permissions for %s", m.Reason))
+		}
+	}
+}
+
+// CheckDelegation returns an error that lists the zfs allow commands granting the permissions the jobs lack,
+// so that a daemon in delegated mode refuses to start instead of failing in the middle of a run.
+func CheckDelegation(jobs []Job) error {
+	var problems []string
+	for _, r := range Preflight(jobs, "") {
+		if r.Err != nil {
+			problems = append(problems, fmt.Sprintf("job %q: cannot check delegated zfs permissions: %s", r.Job, r.Err))
+			continue
+		}
+		for _, m := range r.Missing {
+			problems = append(problems, fmt.Sprintf("job %q: %s    # %s", r.Job, m.GrantCommand(), m.Reason))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.Errorf("missing delegated zfs permissions:\n%s", strings.Join(problems, "\n"))
+	}
+	atomic.StoreInt32(&delegationChecked, 1)
+	return nil
+}
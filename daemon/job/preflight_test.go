@@ -0,0 +1,105 @@
+package job
+
+import (
+	"fmt"
+	"os/user"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/zfs"
+)
+
+func TestMergeRequirements(t *testing.T) {
+	ds := func(s string) *zfs.DatasetPath {
+		p, err := zfs.NewDatasetPath(s)
+		require.NoError(t, err)
+		return p
+	}
+	merged := mergeRequirements([]permissionRequirement{
+		{dataset: ds("pool/backup"), descendants: true, perms: []string{"create", "receive"}, reason: "receive below pool/backup"},
+		{dataset: ds("pool/data"), perms: []string{"send"}, reason: "send"},
+		{dataset: ds("pool/backup"), descendants: true, perms: []string{"receive", "rollback"}, reason: "receive below pool/backup/host"},
+		{dataset: ds("pool/backup"), perms: []string{"userprop"}, reason: "local"},
+	})
+	require.Len(t, merged, 3)
+	assert.Equal(t, "pool/backup", merged[0].dataset.ToString())
+	assert.True(t, merged[0].descendants)
+	assert.Equal(t, []string{"create", "receive", "rollback"}, merged[0].perms)
+	assert.Equal(t, "receive below pool/backup; receive below pool/backup/host", merged[0].reason)
+	assert.Equal(t, "pool/data", merged[1].dataset.ToString())
+	assert.False(t, merged[2].descendants)
+}
+
+func userName(t *testing.T) string {
+	u, err := user.Current()
+	require.NoError(t, err)
+	return u.Username
+}
+
+// fakePreflight replaces the zfs functions used by Preflight so that the daemon runs as an unprivileged user,
+// all datasets exist and zfs allow prints allowOutput, formatted with the current user's name, for every dataset.
+func fakePreflight(t *testing.T, allowOutput string) (restore func()) {
+	name := userName(t)
+	prevAllow, prevNearest, prevEuid := zfsAllowAll, zfsNearestExisting, geteuid
+	geteuid = func() int { return 1000 }
+	zfsNearestExisting = func(p *zfs.DatasetPath) (*zfs.DatasetPath, error) { return p, nil }
+	zfsAllowAll = func(fss []*zfs.DatasetPath) (map[string]*zfs.Delegations, error) {
+		res := make(map[string]*zfs.Delegations, len(fss))
+		for _, fs := range fss {
+			d, err := zfs.ParseZFSAllowOutput(fs.ToString(), []byte(fmt.Sprintf(allowOutput, name)))
+			if err != nil {
+				return nil, err
+			}
+			res[fs.ToString()] = d
+		}
+		return res, nil
+	}
+	return func() {
+		zfsAllowAll, zfsNearestExisting, geteuid = prevAllow, prevNearest, prevEuid
+		atomic.StoreInt32(&delegationChecked, 0)
+	}
+}
+
+func TestCheckDelegation(t *testing.T) {
+	root, err := zfs.NewDatasetPath("pool/backup")
+	require.NoError(t, err)
+	jobs := []Job{&PassiveSide{name: "sink", mode: &modeSink{rootDataset: root}}}
+
+	t.Run("granted", func(t *testing.T) {
+		defer fakePreflight(t, `---- Permissions on pool/backup --------------------------------------
+Local+Descendent permissions:
+	user %s create,destroy,mount,mountpoint,receive,rename,rollback,userprop
+`)()
+		assert.NoError(t, CheckDelegation(jobs))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&delegationChecked))
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		defer fakePreflight(t, `---- Permissions on pool/backup --------------------------------------
+Local+Descendent permissions:
+	user %s create,destroy,mount,receive,rollback
+---- Permissions on pool ---------------------------------------------
+Local permissions:
+	user %[1]s rename
+`)()
+		err := CheckDelegation(jobs)
+		require.Error(t, err)
+		// rename is only granted on pool itself, not on its descendants
+		assert.Contains(t, err.Error(), "zfs allow -u "+userName(t)+" mountpoint,rename,userprop pool/backup")
+		assert.Contains(t, err.Error(), `job "sink"`)
+		assert.Equal(t, int32(0), atomic.LoadInt32(&delegationChecked))
+	})
+
+	t.Run("allow fails", func(t *testing.T) {
+		defer fakePreflight(t, "")()
+		zfsAllowAll = func(fss []*zfs.DatasetPath) (map[string]*zfs.Delegations, error) {
+			return nil, fmt.Errorf("zfs allow failed")
+		}
+		err := CheckDelegation(jobs)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "cannot check delegated zfs permissions")
+	})
+}
@@ -0,0 +1,140 @@
+package job
+
+import (
+	"context"
+	"github.com/zrepl/zrepl/daemon/hooks"
+	"github.com/zrepl/zrepl/endpoint"
+	"github.com/zrepl/zrepl/zfs"
+	"sync"
+	"time"
+)
+
+// ReceiveHooksReport is the outcome of the post-receive hooks of a job since the daemon started.
+type ReceiveHooksReport struct {
+	// number of received snapshots whose hooks are waiting to run or running
+	Pending  int
+	Failures int
+	// nil if no hook failed
+	LastFailure *ReceiveHookFailure
+}
+
+type ReceiveHookFailure struct {
+	Filesystem string
+	Snapshot   string
+	Error      string
+	Time       time.Time
+}
+
+type receiveHookRun struct {
+	log            Logger
+	fs             *zfs.DatasetPath
+	snapshot       string
+	senderIdentity string
+}
+
+// receiveHooks runs the post-receive hooks of a job in the background, one received snapshot after the other,
+// so that the receive neither waits for the hooks nor fails if they do.
+// Failures are logged and reported in the job's status, see Report.
+// A nil *receiveHooks has no hooks.
+type receiveHooks struct {
+	hooks hooks.List
+
+	mtx         sync.Mutex
+	queue       []receiveHookRun
+	running     bool
+	failures    int
+	lastFailure *ReceiveHookFailure
+}
+
+// newReceiveHooks returns nil if l is empty.
+func newReceiveHooks(l hooks.List) *receiveHooks {
+	if len(l) == 0 {
+		return nil
+	}
+	return &receiveHooks{hooks: l}
+}
+
+// postReceive returns the endpoint.PostReceiveFunc that queues the hooks applicable to the received filesystem,
+// or nil if r has no hooks.
+func (r *receiveHooks) postReceive(senderIdentity string) endpoint.PostReceiveFunc {
+	if r == nil {
+		return nil
+	}
+	return func(ctx context.Context, fs *zfs.DatasetPath, snapshot string) {
+		r.enqueue(receiveHookRun{
+			log:            GetLogger(ctx).WithField("fs", fs.ToString()).WithField("snapshot", snapshot),
+			fs:             fs,
+			snapshot:       snapshot,
+			senderIdentity: senderIdentity,
+		})
+	}
+}
+
+func (r *receiveHooks) enqueue(run receiveHookRun) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.queue = append(r.queue, run)
+	if !r.running {
+		r.running = true
+		go r.drain()
+	}
+}
+
+// drain runs the queued hooks until the queue is empty.
+func (r *receiveHooks) drain() {
+	for {
+		r.mtx.Lock()
+		if len(r.queue) == 0 {
+			r.running = false
+			r.mtx.Unlock()
+			return
+		}
+		run := r.queue[0]
+		r.mtx.Unlock()
+
+		// the hooks must not be cancelled by the end of the receive, each hook is limited by its timeout
+		err := r.run(context.Background(), run)
+
+		r.mtx.Lock()
+		r.queue = r.queue[1:]
+		if err != nil {
+			r.failures++
+			r.lastFailure = &ReceiveHookFailure{
+				Filesystem: run.fs.ToString(),
+				Snapshot:   run.snapshot,
+				Error:      err.Error(),
+				Time:       time.Now(),
+			}
+		}
+		r.mtx.Unlock()
+	}
+}
+
+func (r *receiveHooks) run(ctx context.Context, run receiveHookRun) error {
+	applicable, err := r.hooks.Filter(run.fs)
+	if err != nil {
+		run.log.WithError(err).Error("cannot filter post-receive hooks")
+		return err
+	}
+	env := hooks.Env{
+		hooks.EnvFS:             run.fs.ToString(),
+		hooks.EnvSnapshot:       run.snapshot,
+		hooks.EnvSenderIdentity: run.senderIdentity,
+	}
+	return applicable.Run(ctx, run.log, hooks.PhasePostReceive, env)
+}
+
+// Report returns nil if r has no hooks.
+func (r *receiveHooks) Report() *ReceiveHooksReport {
+	if r == nil {
+		return nil
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	rep := &ReceiveHooksReport{Pending: len(r.queue), Failures: r.failures}
+	if r.lastFailure != nil {
+		f := *r.lastFailure
+		rep.LastFailure = &f
+	}
+	return rep
+}
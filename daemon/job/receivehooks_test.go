@@ -0,0 +1,62 @@
+package job
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/hooks"
+	"github.com/zrepl/zrepl/zfs"
+)
+
+func TestNoReceiveHooks(t *testing.T) {
+	r := newReceiveHooks(nil)
+	assert.Nil(t, r)
+	assert.Nil(t, r.postReceive("client"))
+	assert.Nil(t, r.Report())
+}
+
+func TestReceiveHooksRunInBackground(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrepl-recvhooks")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	path := filepath.Join(dir, "hook.sh")
+	script := "#!/bin/sh\necho \"$ZREPL_HOOKTYPE $ZREPL_FS $ZREPL_SNAPNAME $ZREPL_SENDER_IDENTITY\" >> " + out + "\nexit 1\n"
+	require.NoError(t, ioutil.WriteFile(path, []byte(script), 0755))
+	l, err := hooks.ListFromConfig([]config.HookCommand{{Path: path, Timeout: 10 * time.Second, ErrIsFatal: true}})
+	require.NoError(t, err)
+
+	r := newReceiveHooks(l)
+	f := r.postReceive("client1")
+	require.NotNil(t, f)
+	fs, err := zfs.NewDatasetPath("pool/backups/a")
+	require.NoError(t, err)
+	f(context.Background(), fs, "zrepl_1")
+	f(context.Background(), fs, "zrepl_2")
+
+	deadline := time.Now().Add(10 * time.Second)
+	for r.Report().Pending > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	rep := r.Report()
+	require.Zero(t, rep.Pending)
+	// the failure of a fatal hook is reported, not returned to the receive
+	assert.Equal(t, 2, rep.Failures)
+	require.NotNil(t, rep.LastFailure)
+	assert.Equal(t, "pool/backups/a", rep.LastFailure.Filesystem)
+	assert.Equal(t, "zrepl_2", rep.LastFailure.Snapshot)
+	assert.Contains(t, rep.LastFailure.Error, "exit status 1")
+
+	env, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	// the hooks of the received snapshots run one after the other
+	assert.Equal(t, "post_receive pool/backups/a zrepl_1 client1\npost_receive pool/backups/a zrepl_2 client1\n", string(env))
+}
@@ -0,0 +1,150 @@
+package job
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/zrepl/zrepl/replication/fsrep"
+	"github.com/zrepl/zrepl/replication/pdu"
+	"github.com/zrepl/zrepl/util/watchdog"
+	"strings"
+	"sync"
+)
+
+// ReplicateOnceStatus is the state of the most recent ad-hoc replication of a job, see StartReplicateOnce.
+type ReplicateOnceStatus struct {
+	Filesystem string
+	// nil until the step was planned
+	Report *fsrep.Report
+	Done   bool
+	// empty if Done and the step was replicated
+	Error string
+}
+
+type adhocReplication struct {
+	mtx  sync.Mutex
+	fs   string
+	rep  *fsrep.Replication
+	done bool
+	err  error
+}
+
+func (a *adhocReplication) status() *ReplicateOnceStatus {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	s := &ReplicateOnceStatus{Filesystem: a.fs, Done: a.done}
+	if a.rep != nil {
+		s.Report = a.rep.Report()
+	}
+	if a.err != nil {
+		s.Error = a.err.Error()
+	}
+	return s
+}
+
+// StartReplicateOnce starts the replication of a single step chosen by the operator (see replicateOnce) in the background,
+// its progress and outcome are reported by ReplicateOnceStatus.
+// It fails if the job is replicating or pruning, or if another ad-hoc replication is running,
+// and invocations of the job wait until the ad-hoc replication is done.
+func (j *ActiveSide) StartReplicateOnce(ctx context.Context, fs, from, to string) error {
+	select {
+	case j.running <- struct{}{}:
+	default:
+		return errors.New("job is running, retry after the current invocation")
+	}
+	a := &adhocReplication{fs: fs}
+	j.adhocMtx.Lock()
+	j.adhoc = a
+	j.adhocMtx.Unlock()
+	go func() {
+		defer func() { <-j.running }()
+		err := j.replicateOnce(ctx, a, fs, from, to)
+		if err != nil {
+			GetLogger(ctx).WithError(err).WithField("fs", fs).Error("ad-hoc replication failed")
+		}
+		a.mtx.Lock()
+		a.done, a.err = true, err
+		a.mtx.Unlock()
+	}()
+	return nil
+}
+
+// ReplicateOnceStatus returns the state of the most recent ad-hoc replication, nil if there was none.
+func (j *ActiveSide) ReplicateOnceStatus() *ReplicateOnceStatus {
+	j.adhocMtx.Lock()
+	a := j.adhoc
+	j.adhocMtx.Unlock()
+	if a == nil {
+		return nil
+	}
+	return a.status()
+}
+
+// replicateOnce performs a single replication step of filesystem fs from version from to snapshot to,
+// using the sender, receiver and send options of the job, but outside of its periodic replication.
+// An empty from means a full send. Versions are given by name, bookmarks prefixed with '#'.
+// The job's sync_properties are not copied.
+func (j *ActiveSide) replicateOnce(ctx context.Context, a *adhocReplication, fs, from, to string) error {
+	client, err := j.clientFactory.NewClient()
+	if err != nil {
+		return errors.Wrap(err, "cannot build client")
+	}
+	defer client.Close(ctx)
+	sender, receiver, err := j.mode.SenderReceiver(j.remote(client))
+	if err != nil {
+		return errors.Wrap(err, "cannot build sender and receiver")
+	}
+
+	versions, err := sender.ListFilesystemVersions(ctx, fs)
+	if err != nil {
+		return errors.Wrap(err, "cannot list sender versions")
+	}
+	find := func(name string) (*pdu.FilesystemVersion, error) {
+		if !strings.HasPrefix(name, "@") && !strings.HasPrefix(name, "#") {
+			name = "@" + name
+		}
+		for _, v := range versions {
+			if v.RelName() == name {
+				return v, nil
+			}
+		}
+		return nil, errors.Errorf("sender has no version %s%s", fs, name)
+	}
+	toV, err := find(to)
+	if err != nil {
+		return err
+	}
+	if toV.Type != pdu.FilesystemVersion_Snapshot {
+		return errors.Errorf("%s%s is not a snapshot", fs, toV.RelName())
+	}
+
+	opts := j.replicationOpts
+	opts.Properties = nil
+	b := fsrep.BuildReplication(fs, opts, j.promBytesReplicated.WithLabelValues(fs))
+	if from == "" {
+		b.AddStep(nil, toV)
+	} else {
+		fromV, err := find(from)
+		if err != nil {
+			return err
+		}
+		if fromV.CreateTXG >= toV.CreateTXG {
+			return errors.Errorf("%s%s is not older than %s%s", fs, fromV.RelName(), fs, toV.RelName())
+		}
+		b.AddStep(fromV, toV)
+	}
+	rep := b.Done()
+	a.mtx.Lock()
+	a.rep = rep
+	a.mtx.Unlock()
+
+	if err := rep.UpdateSizeEsitmate(ctx, sender); err != nil {
+		return errors.Wrap(err, "cannot compute send size estimate")
+	}
+	var ka watchdog.KeepAlive
+	for rep.State() != fsrep.Completed {
+		if err := rep.Retry(ctx, &ka, sender, receiver); err != nil {
+			return err
+		}
+	}
+	return nil
+}
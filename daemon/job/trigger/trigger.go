@@ -0,0 +1,25 @@
+// Package trigger lets a job report a successful run to the daemon,
+// which then wakes up the jobs that are configured to run after it.
+package trigger
+
+import (
+	"context"
+)
+
+type contextKey int
+
+const contextKeyTrigger contextKey = iota
+
+type Func func()
+
+// Context returns a context for a job whose successful runs call f.
+func Context(ctx context.Context, f Func) context.Context {
+	return context.WithValue(ctx, contextKeyTrigger, f)
+}
+
+// Succeeded reports a successful run of the job of ctx.
+func Succeeded(ctx context.Context) {
+	if f, ok := ctx.Value(contextKeyTrigger).(Func); ok {
+		f()
+	}
+}
@@ -0,0 +1,260 @@
+// Package usage accounts the resources held by a job, i.e. its goroutines and connections,
+// to tell which job is responsible for the memory growth of the daemon.
+package usage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/problame/go-streamrpc"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"net"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// JobLabel is the pprof label that identifies the goroutines of a job, see Do.
+// It also shows up in the goroutine profiles of the profiling endpoint.
+const JobLabel = "zrepl_job"
+
+type Report struct {
+	// number of goroutines that run on behalf of the job, including those started by libraries
+	Goroutines int
+	// number of RPC connections of the job that are currently open
+	OpenConnections int
+	// sum of the configured buffer limits of the open connections,
+	// i.e. the memory their RPC buffers may grow to, not the memory they currently hold
+	ConnBufferLimitBytes int64
+}
+
+// Tracker is safe for concurrent use.
+// All methods are no-ops on a nil *Tracker.
+type Tracker struct {
+	conns           int64 // atomic
+	connBufferLimit int64 // atomic
+	promConns       prometheus.GaugeFunc
+	promBufferLimit prometheus.GaugeFunc
+}
+
+func NewTracker(jobName string) *Tracker {
+	t := &Tracker{}
+	t.promConns = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   "zrepl",
+		Subsystem:   "job",
+		Name:        "open_connections",
+		Help:        "number of RPC connections of a job that are currently open",
+		ConstLabels: prometheus.Labels{"zrepl_job": jobName},
+	}, func() float64 { return float64(atomic.LoadInt64(&t.conns)) })
+	t.promBufferLimit = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   "zrepl",
+		Subsystem:   "job",
+		Name:        "conn_buffer_limit_bytes",
+		Help:        "sum of the configured buffer limits of the open RPC connections of a job",
+		ConstLabels: prometheus.Labels{"zrepl_job": jobName},
+	}, func() float64 { return float64(atomic.LoadInt64(&t.connBufferLimit)) })
+	return t
+}
+
+func (t *Tracker) RegisterMetrics(registerer prometheus.Registerer) {
+	if t == nil {
+		return
+	}
+	registerer.MustRegister(t.promConns)
+	registerer.MustRegister(t.promBufferLimit)
+}
+
+// ConnBufferLimitBytes is the maximum size of the buffers a streamrpc connection with config c may allocate.
+func ConnBufferLimitBytes(c *streamrpc.ConnConfig) int64 {
+	return int64(c.RxHeaderMaxLen) + int64(c.RxStructuredMaxLen) + int64(c.RxStreamMaxChunkSize) + int64(c.TxChunkSize)
+}
+
+// AddConn accounts an open connection whose buffers may grow to bufferLimit bytes.
+// The returned function must be called once the connection is closed, subsequent calls are no-ops.
+func (t *Tracker) AddConn(bufferLimit int64) (release func()) {
+	if t == nil {
+		return func() {}
+	}
+	atomic.AddInt64(&t.conns, 1)
+	atomic.AddInt64(&t.connBufferLimit, bufferLimit)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			atomic.AddInt64(&t.conns, -1)
+			atomic.AddInt64(&t.connBufferLimit, -bufferLimit)
+		})
+	}
+}
+
+// TrackConn is AddConn for conn, the accounting ends when the returned connection is closed.
+func (t *Tracker) TrackConn(conn net.Conn, bufferLimit int64) net.Conn {
+	if t == nil {
+		return conn
+	}
+	return &trackedConn{Conn: conn, release: t.AddConn(bufferLimit)}
+}
+
+type trackedConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *trackedConn) Close() error {
+	defer c.release()
+	return c.Conn.Close()
+}
+
+// Report returns the usage of the job, goroutines is the job's entry in the result of CountGoroutines,
+// e.g. as sampled by a GoroutineSampler.
+func (t *Tracker) Report(goroutines int) *Report {
+	if t == nil {
+		return nil
+	}
+	return &Report{
+		Goroutines:           goroutines,
+		OpenConnections:      int(atomic.LoadInt64(&t.conns)),
+		ConnBufferLimitBytes: atomic.LoadInt64(&t.connBufferLimit),
+	}
+}
+
+// Do calls f with the goroutine labeled as belonging to job jobName.
+// Goroutines started by f inherit the label, which makes them count towards the job in CountGoroutines.
+func Do(ctx context.Context, jobName string, f func(ctx context.Context)) {
+	pprof.Do(ctx, pprof.Labels(JobLabel, jobName), f)
+}
+
+// CountGoroutines returns the number of goroutines by job name, for all goroutines started within Do.
+// It takes a goroutine profile and is hence too expensive to be called more often than every few seconds.
+func CountGoroutines() (map[string]int, error) {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return nil, err
+	}
+	return parseGoroutineProfile(&buf)
+}
+
+// GoroutineSampler counts the goroutines of all jobs with CountGoroutines in a fixed interval,
+// so that frequent readers of the counts, e.g. zrepl status, do not take a goroutine profile each.
+// All methods are safe for concurrent use, Counts returns nil on a nil *GoroutineSampler.
+type GoroutineSampler struct {
+	mtx    sync.Mutex
+	counts map[string]int
+}
+
+// SampleGoroutines takes a sample right away and then every interval until ctx is done.
+// If a sample fails, the counts of the previous sample are kept.
+func SampleGoroutines(ctx context.Context, interval time.Duration) *GoroutineSampler {
+	s := &GoroutineSampler{}
+	s.sample()
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				s.sample()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *GoroutineSampler) sample() {
+	counts, err := CountGoroutines()
+	if err != nil {
+		return
+	}
+	s.mtx.Lock()
+	s.counts = counts
+	s.mtx.Unlock()
+}
+
+// Counts returns the number of goroutines by job name of the most recent sample, which must not be modified.
+func (s *GoroutineSampler) Counts() map[string]int {
+	if s == nil {
+		return nil
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.counts
+}
+
+// parseGoroutineProfile parses the output of the goroutine profile with debug=1, i.e.
+//
+//	goroutine profile: total 7
+//	2 @ 0x42c8d5 0x43c7f1 ...
+//	# labels: {"zrepl_job":"prod_to_backups"}
+//	#	0x43c7f0	runtime.gopark+0x...
+func parseGoroutineProfile(r io.Reader) (map[string]int, error) {
+	const labelsPrefix = "# labels: "
+	ret := make(map[string]int)
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	count := 0 // of the current record, until its labels were seen
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, " @ "); i > 0 && !strings.HasPrefix(line, "#") {
+			n, err := strconv.Atoi(line[:i])
+			if err != nil {
+				return nil, err
+			}
+			count = n
+			continue
+		}
+		if !strings.HasPrefix(line, labelsPrefix) || count == 0 {
+			continue
+		}
+		if job, ok := labelValue(line[len(labelsPrefix):], JobLabel); ok {
+			ret[job] += count
+		}
+		count = 0
+	}
+	return ret, s.Err()
+}
+
+// labelValue returns the value of key in labels formatted as {"key":"value", ...}.
+func labelValue(labels, key string) (string, bool) {
+	prefix := strconv.Quote(key) + ":"
+	i := strings.Index(labels, prefix)
+	if i == -1 {
+		return "", false
+	}
+	v := labels[i+len(prefix):]
+	if !strings.HasPrefix(v, `"`) {
+		return "", false
+	}
+	// find the closing quote, skipping escaped characters
+	for end := 1; end < len(v); end++ {
+		switch v[end] {
+		case '\\':
+			end++
+		case '"':
+			value, err := strconv.Unquote(v[:end+1])
+			if err != nil {
+				return "", false
+			}
+			return value, true
+		}
+	}
+	return "", false
+}
+
+type contextKey int
+
+const contextKeyTracker contextKey = iota
+
+func WithTracker(ctx context.Context, t *Tracker) context.Context {
+	return context.WithValue(ctx, contextKeyTracker, t)
+}
+
+// FromContext returns the Tracker in ctx, or nil if there is none.
+func FromContext(ctx context.Context) *Tracker {
+	t, _ := ctx.Value(contextKeyTracker).(*Tracker)
+	return t
+}
@@ -0,0 +1,98 @@
+package usage
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGoroutineProfile(t *testing.T) {
+	profile := `goroutine profile: total 9
+3 @ 0x42c8d5 0x43c7f1 0x4a1b2c
+# labels: {"zrepl_job":"prod_to_backups"}
+#	0x43c7f0	runtime.gopark+0x1f0	/usr/lib/go/src/runtime/proc.go:303
+
+2 @ 0x42c8d5 0x43c7f1
+# labels: {"other":"x", "zrepl_job":"with \"quotes\""}
+#	0x43c7f0	runtime.gopark+0x1f0	/usr/lib/go/src/runtime/proc.go:303
+
+1 @ 0x42c8d5
+# labels: {"zrepl_job":"prod_to_backups"}
+#	0x43c7f0	runtime.gopark+0x1f0	/usr/lib/go/src/runtime/proc.go:303
+
+3 @ 0x42c8d5
+#	0x43c7f0	runtime.gopark+0x1f0	/usr/lib/go/src/runtime/proc.go:303
+`
+	m, err := parseGoroutineProfile(strings.NewReader(profile))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"prod_to_backups": 4, `with "quotes"`: 2}, m)
+}
+
+func TestCountGoroutines(t *testing.T) {
+	started := make(chan struct{})
+	stop := make(chan struct{})
+	defer close(stop)
+	go Do(context.Background(), "testjob", func(ctx context.Context) {
+		go func() { <-stop }()
+		close(started)
+		<-stop
+	})
+	<-started
+	m, err := CountGoroutines()
+	require.NoError(t, err)
+	assert.Equal(t, 2, m["testjob"])
+}
+
+func TestTrackConn(t *testing.T) {
+	tr := NewTracker("testjob")
+	a, b := net.Pipe()
+	defer b.Close()
+	conn := tr.TrackConn(a, 100)
+	release := tr.AddConn(10)
+	assert.Equal(t, &Report{Goroutines: 1, OpenConnections: 2, ConnBufferLimitBytes: 110}, tr.Report(1))
+
+	require.NoError(t, conn.Close())
+	conn.Close()
+	release()
+	release()
+	assert.Equal(t, &Report{Goroutines: 1}, tr.Report(1))
+
+	var nilTracker *Tracker
+	assert.Equal(t, a, nilTracker.TrackConn(a, 100))
+	nilTracker.AddConn(10)()
+	assert.Nil(t, nilTracker.Report(1))
+}
+
+func TestGoroutineSampler(t *testing.T) {
+	started := make(chan struct{})
+	stop := make(chan struct{})
+	defer close(stop)
+	go Do(context.Background(), "sampledjob", func(ctx context.Context) {
+		close(started)
+		<-stop
+	})
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := SampleGoroutines(ctx, time.Hour)
+	assert.Equal(t, 1, s.Counts()["sampledjob"], "the first sample is taken right away")
+
+	// a sample is a snapshot, it does not change until the next one is taken
+	started = make(chan struct{})
+	go Do(context.Background(), "sampledjob", func(ctx context.Context) {
+		close(started)
+		<-stop
+	})
+	<-started
+	assert.Equal(t, 1, s.Counts()["sampledjob"])
+	s.sample()
+	assert.Equal(t, 2, s.Counts()["sampledjob"])
+
+	var nilSampler *GoroutineSampler
+	assert.Nil(t, nilSampler.Counts())
+}
@@ -0,0 +1,164 @@
+package daemon
+
+import (
+	"fmt"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/job"
+	"github.com/zrepl/zrepl/daemon/job/lastsuccess"
+	"sort"
+	"strings"
+	"time"
+)
+
+// JobSummary describes a job as the daemon loaded it from its config, along with the outcome of its last run.
+// It is served by ControlJobEndpointJobs.
+type JobSummary struct {
+	Name string
+	Type string
+	// e.g. "tls backup.example.com:8888", "stdinserver clients [prod1 prod2]"
+	Transport string
+	// e.g. "periodic 10m", "cron 0 2 * * *", "interval 10m", empty for sink jobs
+	Schedule string
+	// the job that triggers this job after its successful runs, see config.ActiveJob.Trigger
+	TriggeredBy string `json:",omitempty"`
+	// filter rules of the filesystems field, excluded ones prefixed with "!"
+	Filesystems []string `json:",omitempty"`
+	RootFS      string   `json:",omitempty"`
+
+	// from the job's status, empty if the job has not run yet or does not report it
+	LastRunState string                          `json:",omitempty"`
+	LastRunError string                          `json:",omitempty"`
+	LastSuccess  map[lastsuccess.Phase]time.Time `json:",omitempty"`
+}
+
+// JobSummariesFromConfig returns the summaries of the jobs in c without the outcomes of their last runs, by name.
+func JobSummariesFromConfig(c *config.Config) map[string]*JobSummary {
+	summaries := make(map[string]*JobSummary, len(c.Jobs))
+	for _, j := range c.Jobs {
+		s := jobSummaryFromConfig(j)
+		summaries[s.Name] = s
+	}
+	return summaries
+}
+
+func jobSummaryFromConfig(in config.JobEnum) *JobSummary {
+	s := &JobSummary{Name: in.Name()}
+	switch v := in.Ret.(type) {
+	case *config.PushJob:
+		s.Type = v.Type
+		s.Transport = connectSummary(v.Connect)
+		s.Schedule = snapshottingSummary(v.Snapshotting)
+		s.TriggeredBy = v.Trigger
+		s.Filesystems = filterSummary(v.Filesystems)
+	case *config.PullJob:
+		s.Type = v.Type
+		s.Transport = connectSummary(v.Connect)
+		s.Schedule = fmt.Sprintf("interval %s", v.Interval)
+		s.TriggeredBy = v.Trigger
+		s.RootFS = v.RootFS
+	case *config.SourceJob:
+		s.Type = v.Type
+		s.Transport = serveSummary(v.Serve)
+		s.Schedule = snapshottingSummary(v.Snapshotting)
+		s.Filesystems = filterSummary(v.Filesystems)
+	case *config.SinkJob:
+		s.Type = v.Type
+		s.Transport = serveSummary(v.Serve)
+		s.RootFS = v.RootFS
+	}
+	return s
+}
+
+func connectSummary(in config.ConnectEnum) string {
+	switch v := in.Ret.(type) {
+	case *config.TCPConnect:
+		return fmt.Sprintf("tcp %s", v.Address)
+	case *config.TLSConnect:
+		return fmt.Sprintf("tls %s (server_cn %s)", v.Address, v.ServerCN)
+	case *config.SSHStdinserverConnect:
+		return fmt.Sprintf("ssh+stdinserver %s@%s:%d", v.User, v.Host, v.Port)
+	case *config.UnixConnect:
+		return fmt.Sprintf("unix %s", v.Path)
+	case *config.LocalConnect:
+		return fmt.Sprintf("local %s", v.ListenerName)
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func serveSummary(in config.ServeEnum) string {
+	switch v := in.Ret.(type) {
+	case *config.TCPServe:
+		return fmt.Sprintf("tcp %s", listenSummary(v.Listen, v.ListenFDName))
+	case *config.TLSServe:
+		return fmt.Sprintf("tls %s (client_cns %s)", listenSummary(v.Listen, v.ListenFDName), strings.Join(v.ClientCNs, " "))
+	case *config.UnixServe:
+		return fmt.Sprintf("unix %s (client_identity %s)", listenSummary(v.Path, v.ListenFDName), v.ClientIdentity)
+	case *config.StdinserverServer:
+		return fmt.Sprintf("stdinserver (client_identities %s)", strings.Join(v.ClientIdentities, " "))
+	case *config.LocalServe:
+		return fmt.Sprintf("local %s", v.ListenerName)
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func listenSummary(address, fdName string) string {
+	if fdName != "" {
+		return fmt.Sprintf("socket-activated %s", fdName)
+	}
+	return address
+}
+
+func snapshottingSummary(in config.SnapshottingEnum) string {
+	switch v := in.Ret.(type) {
+	case *config.SnapshottingPeriodic:
+		if v.Align {
+			return fmt.Sprintf("periodic %s (aligned)", v.Interval)
+		}
+		return fmt.Sprintf("periodic %s", v.Interval)
+	case *config.SnapshottingCron:
+		return fmt.Sprintf("cron %s", v.Cron)
+	case *config.SnapshottingManual:
+		if v.PollInterval > 0 {
+			return fmt.Sprintf("manual (poll %s)", v.PollInterval)
+		}
+		return "manual"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func filterSummary(in config.FilesystemsFilter) []string {
+	rules := make([]string, 0, len(in))
+	for pattern, include := range in {
+		if !include {
+			pattern = "!" + pattern
+		}
+		rules = append(rules, pattern)
+	}
+	sort.Strings(rules)
+	return rules
+}
+
+// withStatus returns a copy of s with the outcome of the last run from the job's status st.
+func (s JobSummary) withStatus(st *job.Status) *JobSummary {
+	if st == nil {
+		return &s
+	}
+	switch v := st.JobSpecific.(type) {
+	case *job.ActiveSideStatus:
+		if v.Replication != nil {
+			s.LastRunState = v.Replication.Status
+			s.LastRunError = v.Replication.Problem
+		}
+		if v.LastSuccess != nil {
+			s.LastSuccess = v.LastSuccess.Job
+		}
+	case *job.PassiveStatus:
+		if v.LastSuccess != nil {
+			s.LastSuccess = v.LastSuccess.Job
+		}
+	}
+	return &s
+}
@@ -0,0 +1,55 @@
+package daemon
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/job"
+	"github.com/zrepl/zrepl/replication"
+	"testing"
+	"time"
+)
+
+func TestJobSummariesFromConfig(t *testing.T) {
+	push := &config.PushJob{
+		ActiveJob: config.ActiveJob{
+			Type:    "push",
+			Name:    "prod_to_backups",
+			Connect: config.ConnectEnum{Ret: &config.TLSConnect{Address: "backups:8888", ServerCN: "backups"}},
+			Trigger: "local",
+		},
+		Snapshotting: config.SnapshottingEnum{Ret: &config.SnapshottingPeriodic{Interval: 10 * time.Minute}},
+		Filesystems:  config.FilesystemsFilter{"pool/data<": true, "pool/data/tmp<": false},
+	}
+	sink := &config.SinkJob{
+		PassiveJob: config.PassiveJob{
+			Type:  "sink",
+			Name:  "backup_sink",
+			Serve: config.ServeEnum{Ret: &config.TCPServe{Listen: ":8888"}},
+		},
+		RootFS: "storage/zrepl/sink",
+	}
+	summaries := JobSummariesFromConfig(&config.Config{Jobs: []config.JobEnum{{Ret: push}, {Ret: sink}}})
+
+	assert.Equal(t, &JobSummary{
+		Name:        "prod_to_backups",
+		Type:        "push",
+		Transport:   "tls backups:8888 (server_cn backups)",
+		Schedule:    "periodic 10m0s",
+		TriggeredBy: "local",
+		Filesystems: []string{"!pool/data/tmp<", "pool/data<"},
+	}, summaries["prod_to_backups"])
+	assert.Equal(t, &JobSummary{
+		Name:      "backup_sink",
+		Type:      "sink",
+		Transport: "tcp :8888",
+		RootFS:    "storage/zrepl/sink",
+	}, summaries["backup_sink"])
+
+	st := &job.Status{Type: job.TypePush, JobSpecific: &job.ActiveSideStatus{
+		Replication: &replication.Report{Status: "PermanentError", Problem: "connection refused"},
+	}}
+	withStatus := summaries["prod_to_backups"].withStatus(st)
+	assert.Equal(t, "PermanentError", withStatus.LastRunState)
+	assert.Equal(t, "connection refused", withStatus.LastRunError)
+	assert.Empty(t, summaries["prod_to_backups"].LastRunState, "withStatus must not modify the summary")
+}
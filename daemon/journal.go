@@ -0,0 +1,43 @@
+package daemon
+
+import (
+	"github.com/pkg/errors"
+	"github.com/zrepl/zrepl/config"
+)
+
+// JobJournals returns the journal settings of the jobs that enable the debug journal, by job name.
+// It returns an error if two jobs share a journal directory, their segments would overwrite each other.
+func JobJournals(jobs []config.JobEnum) (map[string]*config.JobDebugJournal, error) {
+	journals := make(map[string]*config.JobDebugJournal)
+	byPath := make(map[string]string)
+	for _, j := range jobs {
+		var (
+			name  string
+			debug config.JobDebugSettings
+		)
+		switch v := j.Ret.(type) {
+		case *config.PushJob:
+			name, debug = v.Name, v.Debug
+		case *config.PullJob:
+			name, debug = v.Name, v.Debug
+		case *config.SinkJob:
+			name, debug = v.Name, v.Debug
+		case *config.SourceJob:
+			name, debug = v.Name, v.Debug
+		default:
+			continue
+		}
+		if debug.Journal == nil {
+			continue
+		}
+		if debug.Journal.Path == "" {
+			return nil, errors.Errorf("job %q: debug journal path must not be empty", name)
+		}
+		if other, ok := byPath[debug.Journal.Path]; ok {
+			return nil, errors.Errorf("job %q: debug journal path %q is already used by job %q", name, debug.Journal.Path, other)
+		}
+		byPath[debug.Journal.Path] = name
+		journals[name] = debug.Journal
+	}
+	return journals, nil
+}
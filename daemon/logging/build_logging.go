@@ -2,8 +2,11 @@ package logging
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
+	"fmt"
 	"github.com/mattn/go-isatty"
 	"github.com/pkg/errors"
 	"github.com/problame/go-streamrpc"
@@ -18,18 +21,33 @@ import (
 	"github.com/zrepl/zrepl/daemon/transport/serve"
 )
 
-func OutletsFromConfig(in config.LoggingOutletEnumList) (*logger.Outlets, error) {
+// OutletsFromConfig builds the outlets of in.
+// The logging levels of jobs apply to every outlet that does not set a level for the job itself.
+func OutletsFromConfig(in config.LoggingOutletEnumList, jobs []config.JobEnum) (*logger.Outlets, error) {
 
 	outlets := logger.NewOutlets()
 
+	jobLevels, err := jobLevels(jobs)
+	if err != nil {
+		return nil, err
+	}
+
 	if len(in) == 0 {
 		// Default config
-		out := WriterOutlet{&HumanFormatter{}, os.Stdout}
-		outlets.Add(out, logger.Warn)
+		var out logger.Outlet = newConfiguredOutlet("stdout", WriterOutlet{&HumanFormatter{}, os.Stdout})
+		filter, addLevel, err := parseLevelOverrides(logger.Warn, jobLevels, nil)
+		if err != nil {
+			return nil, err
+		}
+		if filter != nil {
+			filter.outlet = out
+			out = filter
+		}
+		outlets.Add(out, addLevel)
 		return outlets, nil
 	}
 
-	var syslogOutlets, stdoutOutlets int
+	var syslogOutlets, stdoutOutlets, journaldOutlets int
 	for lei, le := range in {
 
 		outlet, minLevel, err := parseOutlet(le)
@@ -38,14 +56,35 @@ func OutletsFromConfig(in config.LoggingOutletEnumList) (*logger.Outlets, error)
 		}
 		var _ logger.Outlet = WriterOutlet{}
 		var _ logger.Outlet = &SyslogOutlet{}
+		var name string
 		switch outlet.(type) {
 		case *SyslogOutlet:
 			syslogOutlets++
+			name = "syslog"
 		case WriterOutlet:
 			stdoutOutlets++
+			name = "stdout"
+		case *JournaldOutlet:
+			journaldOutlets++
+			name = "journald"
+		case *TCPOutlet:
+			name = fmt.Sprintf("tcp#%d", lei)
+		}
+
+		jobs, subsystems := levelOverrides(le)
+		jobs = withJobLevels(jobLevels, jobs)
+		filter, addLevel, err := parseLevelOverrides(minLevel, jobs, subsystems)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot parse outlet #%d", lei)
 		}
 
-		outlets.Add(outlet, minLevel)
+		// a slow outlet must not block the goroutines that log
+		outlet = newConfiguredOutlet(name, outlet)
+		if filter != nil {
+			filter.outlet = outlet
+			outlet = filter
+		}
+		outlets.Add(outlet, addLevel)
 
 	}
 
@@ -55,6 +94,9 @@ func OutletsFromConfig(in config.LoggingOutletEnumList) (*logger.Outlets, error)
 	if stdoutOutlets > 1 {
 		return nil, errors.Errorf("can only define one 'stdout' outlet")
 	}
+	if journaldOutlets > 1 {
+		return nil, errors.Errorf("can only define one 'journald' outlet")
+	}
 
 	return outlets, nil
 
@@ -64,18 +106,44 @@ const (
 	SubsysReplication = "repl"
 	SubsysStreamrpc   = "rpc"
 	SubsyEndpoint     = "endpoint"
+	SubsysPruning     = "pruning"
+	SubsysSnapshot    = "snapshot"
+	SubsysServe       = "serve"
+	SubsysZFS         = "zfs"
 )
 
+// Subsystems are the values of SubsysField set by WithSubsystemLoggers.
+var Subsystems = []string{SubsysReplication, SubsysStreamrpc, SubsyEndpoint, SubsysPruning, SubsysSnapshot, SubsysServe, SubsysZFS}
+
+func isSubsystem(s string) bool {
+	for _, subsys := range Subsystems {
+		if s == subsys {
+			return true
+		}
+	}
+	return false
+}
+
 func WithSubsystemLoggers(ctx context.Context, log logger.Logger) context.Context {
-	ctx = replication.WithLogger(ctx, log.WithField(SubsysField, "repl"))
-	ctx = streamrpc.ContextWithLogger(ctx, streamrpcLogAdaptor{log.WithField(SubsysField, "rpc")})
-	ctx = endpoint.WithLogger(ctx, log.WithField(SubsysField, "endpoint"))
-	ctx = pruner.WithLogger(ctx, log.WithField(SubsysField, "pruning"))
-	ctx = snapper.WithLogger(ctx, log.WithField(SubsysField, "snapshot"))
-	ctx = serve.WithLogger(ctx, log.WithField(SubsysField, "serve"))
+	ctx = replication.WithLogger(ctx, log.WithField(SubsysField, SubsysReplication))
+	ctx = streamrpc.ContextWithLogger(ctx, streamrpcLogAdaptor{log.WithField(SubsysField, SubsysStreamrpc)})
+	ctx = endpoint.WithLogger(ctx, log.WithField(SubsysField, SubsyEndpoint))
+	ctx = pruner.WithLogger(ctx, log.WithField(SubsysField, SubsysPruning))
+	ctx = snapper.WithLogger(ctx, log.WithField(SubsysField, SubsysSnapshot))
+	ctx = serve.WithLogger(ctx, log.WithField(SubsysField, SubsysServe))
 	return ctx
 }
 
+// NewInvocationID returns a random id for the InvocationField that is unique across jobs and daemon restarts,
+// so that the entries of one job invocation can be found in aggregated logs.
+func NewInvocationID() string {
+	var id [6]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(err) // crypto/rand does not fail on supported platforms
+	}
+	return hex.EncodeToString(id[:])
+}
+
 func parseLogFormat(i interface{}) (f EntryFormatter, err error) {
 	var is string
 	switch j := i.(type) {
@@ -137,6 +205,17 @@ func parseOutlet(in config.LoggingOutletEnum) (o logger.Outlet, level logger.Lev
 			break
 		}
 		o, err = parseSyslogOutlet(v, f)
+	case *config.JournaldLoggingOutlet:
+		if v.Level == "" {
+			err = errors.Errorf("must specify 'level' field")
+			break
+		}
+		level, err = logger.ParseLevel(v.Level)
+		if err != nil {
+			err = errors.Wrap(err, "cannot parse 'level' field")
+			break
+		}
+		o = &JournaldOutlet{Socket: v.Socket, RetryInterval: v.RetryInterval}
 	default:
 		panic(v)
 	}
@@ -164,7 +243,7 @@ func parseTCPOutlet(in *config.TCPLoggingOutlet, formatter EntryFormatter) (out
 	var tlsConfig *tls.Config
 	if in.TLS != nil {
 		tlsConfig, err = func(m *config.TCPLoggingOutletTLS, host string) (*tls.Config, error) {
-			clientCert, err := tls.LoadX509KeyPair(m.Cert, m.Key)
+			clientCert, err := tlsconf.NewCertReloader(m.Cert, m.Key)
 			if err != nil {
 				return nil, errors.Wrap(err, "cannot load client cert")
 			}
@@ -191,8 +270,16 @@ func parseTCPOutlet(in *config.TCPLoggingOutlet, formatter EntryFormatter) (out
 		}
 	}
 
+	if in.BufferSize <= 0 {
+		return nil, errors.New("'buffer_size' must be positive")
+	}
+	overflow, err := ParseTCPOutletOverflow(in.Overflow)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse 'overflow' field")
+	}
+
 	formatter.SetMetadataFlags(MetadataAll)
-	return NewTCPOutlet(formatter, in.Net, in.Address, tlsConfig, in.RetryInterval), nil
+	return NewTCPOutlet(formatter, in.Net, in.Address, tlsConfig, in.RetryInterval, in.BufferSize, overflow), nil
 
 }
 
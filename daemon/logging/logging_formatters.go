@@ -8,6 +8,7 @@ import (
 	"github.com/go-logfmt/logfmt"
 	"github.com/pkg/errors"
 	"github.com/zrepl/zrepl/logger"
+	"sort"
 	"time"
 )
 
@@ -18,10 +19,39 @@ const (
 )
 
 const (
-	JobField    string = "job"
-	SubsysField string = "subsystem"
+	JobField        string = "job"
+	SubsysField     string = "subsystem"
+	InvocationField string = "invocation_id"
+	// FSField is the field for the filesystem an entry is about.
+	// Packages that cannot import this package use the literal "fs".
+	FSField string = "fs"
 )
 
+// contextFields are the fields that formatters render first, in this order,
+// so that entries can be correlated by job, invocation and filesystem.
+var contextFields = []string{JobField, SubsysField, InvocationField, FSField}
+
+// orderedFieldNames returns the names of fields that are not in skip,
+// the contextFields in their order first, then the remaining ones sorted.
+func orderedFieldNames(fields logger.Fields, skip map[string]bool) []string {
+	names := make([]string, 0, len(fields))
+	isContextField := make(map[string]bool, len(contextFields))
+	for _, field := range contextFields {
+		isContextField[field] = true
+		if _, ok := fields[field]; ok && !skip[field] {
+			names = append(names, field)
+		}
+	}
+	rest := make([]string, 0, len(fields))
+	for field := range fields {
+		if !isContextField[field] && !skip[field] {
+			rest = append(rest, field)
+		}
+	}
+	sort.Strings(rest)
+	return append(names, rest...)
+}
+
 type MetadataFlags int64
 
 const (
@@ -101,13 +131,11 @@ func (f *HumanFormatter) Format(e *logger.Entry) (out []byte, err error) {
 	}
 	fmt.Fprint(&line, e.Message)
 
-	if len(e.Fields)-len(prefixed) > 0 {
-		for field, value := range e.Fields {
-			if prefixed[field] || f.ignored(field) {
-				continue
-			}
-			fmt.Fprintf(&line, " %s=%q", col.Sprint(field), fmt.Sprint(value))
+	for _, field := range orderedFieldNames(e.Fields, prefixed) {
+		if f.ignored(field) {
+			continue
 		}
+		fmt.Fprintf(&line, " %s=%q", col.Sprint(field), fmt.Sprint(e.Fields[field]))
 	}
 
 	return line.Bytes(), nil
@@ -165,13 +193,12 @@ func (f *LogfmtFormatter) Format(e *logger.Entry) ([]byte, error) {
 		enc.EncodeKeyval(FieldLevel, e.Level)
 	}
 
-	// at least try and put job and task in front
-	prefixed := make(map[string]bool, 2)
-	prefix := []string{JobField, SubsysField}
-	for _, pf := range prefix {
+	// put the context fields in front of the message
+	prefixed := make(map[string]bool, len(contextFields))
+	for _, pf := range contextFields {
 		v, ok := e.Fields[pf]
 		if !ok {
-			break
+			continue
 		}
 		if err := logfmtTryEncodeKeyval(enc, pf, v); err != nil {
 			return nil, err // unlikely
@@ -181,11 +208,9 @@ func (f *LogfmtFormatter) Format(e *logger.Entry) ([]byte, error) {
 
 	enc.EncodeKeyval(FieldMessage, e.Message)
 
-	for k, v := range e.Fields {
-		if !prefixed[k] {
-			if err := logfmtTryEncodeKeyval(enc, k, v); err != nil {
-				return nil, err
-			}
+	for _, k := range orderedFieldNames(e.Fields, prefixed) {
+		if err := logfmtTryEncodeKeyval(enc, k, e.Fields[k]); err != nil {
+			return nil, err
 		}
 	}
 
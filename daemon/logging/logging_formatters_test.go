@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/logger"
+	"testing"
+)
+
+func testFormatterEntry() *logger.Entry {
+	return &logger.Entry{
+		Level:   logger.Info,
+		Message: "replicating",
+		Fields: logger.Fields{
+			"zeta":          1,
+			FSField:         "pool/data",
+			"alpha":         "a",
+			InvocationField: "0123456789ab",
+			SubsysField:     "repl",
+			JobField:        "prod",
+		},
+	}
+}
+
+func TestHumanFormatterFieldOrder(t *testing.T) {
+	f := &HumanFormatter{}
+	f.SetMetadataFlags(MetadataNone)
+	out, err := f.Format(testFormatterEntry())
+	require.NoError(t, err)
+	assert.Equal(t, `[prod][repl]: replicating invocation_id="0123456789ab" fs="pool/data" alpha="a" zeta="1"`, string(out))
+}
+
+func TestLogfmtFormatterFieldOrder(t *testing.T) {
+	f := &LogfmtFormatter{}
+	f.SetMetadataFlags(MetadataNone)
+	out, err := f.Format(testFormatterEntry())
+	require.NoError(t, err)
+	assert.Equal(t, `job=prod subsystem=repl invocation_id=0123456789ab fs=pool/data msg=replicating alpha=a zeta=1`, string(out))
+
+	// missing context fields do not prevent the others from being put in front
+	e := testFormatterEntry()
+	delete(e.Fields, JobField)
+	out, err = f.Format(e)
+	require.NoError(t, err)
+	assert.Equal(t, `subsystem=repl invocation_id=0123456789ab fs=pool/data msg=replicating alpha=a zeta=1`, string(out))
+}
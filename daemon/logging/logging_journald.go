@@ -0,0 +1,165 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/zrepl/zrepl/logger"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// JournaldOutlet submits log entries to systemd-journald using its native protocol.
+// The entry's message becomes MESSAGE, and each field becomes a journal field of its own,
+// e.g. JOB, SUBSYSTEM and FS, so that they can be matched with journalctl (journalctl JOB=prod).
+type JournaldOutlet struct {
+	Socket        string
+	RetryInterval time.Duration
+
+	conn               *net.UnixConn
+	addr               *net.UnixAddr
+	lastConnectAttempt time.Time
+}
+
+// journaldFieldName maps a logger field name to a journal field name,
+// which may only consist of uppercase letters, digits and underscores and must not start with an underscore.
+func journaldFieldName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		default:
+			return '_'
+		}
+	}, name)
+	name = strings.TrimLeft(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "F_" + name
+	}
+	return name
+}
+
+func journaldPriority(l logger.Level) int {
+	switch l {
+	case logger.Debug:
+		return 7
+	case logger.Info:
+		return 6
+	case logger.Warn:
+		return 4
+	default:
+		return 3 // reaching this for anything but logger.Error is in fact an error
+	}
+}
+
+// writeJournaldField appends a field in journald's native serialization.
+// Values containing newlines are length-prefixed, all others are written as NAME=value.
+func writeJournaldField(buf *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(buf, "%s=%s\n", name, value)
+		return
+	}
+	buf.WriteString(name)
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// formatJournaldEntry serializes e as a datagram for journald's native protocol.
+func formatJournaldEntry(e *logger.Entry) []byte {
+	var buf bytes.Buffer
+	writeJournaldField(&buf, "MESSAGE", e.Message)
+	writeJournaldField(&buf, "PRIORITY", fmt.Sprintf("%d", journaldPriority(e.Level)))
+	writeJournaldField(&buf, "SYSLOG_IDENTIFIER", "zrepl")
+
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var value string
+		switch v := e.Fields[name].(type) {
+		case error:
+			value = v.Error()
+		default:
+			value = fmt.Sprint(v)
+		}
+		fieldName := journaldFieldName(name)
+		switch fieldName {
+		case "MESSAGE", "PRIORITY", "SYSLOG_IDENTIFIER":
+			fieldName = "ZREPL_" + fieldName
+		}
+		writeJournaldField(&buf, fieldName, value)
+	}
+	return buf.Bytes()
+}
+
+func (o *JournaldOutlet) WriteEntry(entry logger.Entry) error {
+	if o.conn == nil {
+		if time.Since(o.lastConnectAttempt) < o.RetryInterval {
+			return nil // not an error toward logger
+		}
+		o.lastConnectAttempt = time.Now()
+		o.addr = &net.UnixAddr{Name: o.Socket, Net: "unixgram"}
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+		if err != nil {
+			return errors.Wrap(err, "cannot create journald socket")
+		}
+		o.conn = conn
+	}
+
+	msg := formatJournaldEntry(&entry)
+	_, err := o.conn.WriteToUnix(msg, o.addr)
+	if err == nil {
+		return nil
+	}
+	if isMessageTooLong(err) {
+		// journald accepts large entries as a file descriptor that refers to the serialized entry
+		return o.writeViaFile(msg)
+	}
+	// journald is not running or restarting, don't retry before RetryInterval has passed
+	o.conn.Close()
+	o.conn = nil
+	o.lastConnectAttempt = time.Now()
+	return err
+}
+
+func isMessageTooLong(err error) bool {
+	opErr, ok := err.(*net.OpError)
+	if !ok {
+		return false
+	}
+	sysErr, ok := opErr.Err.(*os.SyscallError)
+	if !ok {
+		return false
+	}
+	return sysErr.Err == syscall.EMSGSIZE || sysErr.Err == syscall.ENOBUFS
+}
+
+func (o *JournaldOutlet) writeViaFile(msg []byte) error {
+	f, err := ioutil.TempFile("/dev/shm", "zrepl-journald-")
+	if err != nil {
+		return errors.Wrap(err, "cannot create temporary file for large journald entry")
+	}
+	defer f.Close()
+	// journald only needs the file descriptor
+	if err := os.Remove(f.Name()); err != nil {
+		return err
+	}
+	if _, err := f.Write(msg); err != nil {
+		return err
+	}
+	rights := syscall.UnixRights(int(f.Fd()))
+	_, _, err = o.conn.WriteMsgUnix(nil, rights, o.addr)
+	return err
+}
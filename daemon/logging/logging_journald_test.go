@@ -0,0 +1,42 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/zrepl/zrepl/logger"
+	"testing"
+)
+
+func TestJournaldFieldName(t *testing.T) {
+	assert.Equal(t, "JOB", journaldFieldName("job"))
+	assert.Equal(t, "FSREP_STATE", journaldFieldName("fsrep_state"))
+	assert.Equal(t, "RECEIVER_POOL_BUSY", journaldFieldName("receiver-pool.busy"))
+	assert.Equal(t, "ERR", journaldFieldName("_err"))
+	assert.Equal(t, "F_1ST", journaldFieldName("1st"))
+	assert.Equal(t, "F_", journaldFieldName(""))
+}
+
+func TestFormatJournaldEntry(t *testing.T) {
+	e := logger.Entry{
+		Level:   logger.Warn,
+		Message: "replication failed",
+		Fields: logger.Fields{
+			JobField:    "prod",
+			SubsysField: "repl",
+			"fs":        "pool/data",
+			"err":       errors.New("first line\nsecond line"),
+			"message":   "shadowed",
+		},
+	}
+
+	var expect bytes.Buffer
+	expect.WriteString("MESSAGE=replication failed\nPRIORITY=4\nSYSLOG_IDENTIFIER=zrepl\n")
+	expect.WriteString("ERR\n")
+	binary.Write(&expect, binary.LittleEndian, uint64(len("first line\nsecond line")))
+	expect.WriteString("first line\nsecond line\n")
+	expect.WriteString("FS=pool/data\nJOB=prod\nZREPL_MESSAGE=shadowed\nSUBSYSTEM=repl\n")
+
+	assert.Equal(t, expect.String(), string(formatJournaldEntry(&e)))
+}
@@ -0,0 +1,130 @@
+package logging
+
+import (
+	"github.com/pkg/errors"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/logger"
+)
+
+// levelFilterOutlet passes an entry to outlet if its level is at least the outlet's level,
+// or the level configured for the entry's job or subsystem.
+// If levels are configured for both, the more verbose one applies.
+type levelFilterOutlet struct {
+	outlet     logger.Outlet
+	level      logger.Level
+	jobs       map[string]logger.Level
+	subsystems map[string]logger.Level
+}
+
+func (o *levelFilterOutlet) minLevel(e *logger.Entry) logger.Level {
+	jobLevel, hasJob := o.jobs[fieldString(e, JobField)]
+	subsysLevel, hasSubsys := o.subsystems[fieldString(e, SubsysField)]
+	switch {
+	case hasJob && hasSubsys && jobLevel < subsysLevel:
+		return jobLevel
+	case hasSubsys:
+		return subsysLevel
+	case hasJob:
+		return jobLevel
+	default:
+		return o.level
+	}
+}
+
+func fieldString(e *logger.Entry, field string) string {
+	s, _ := e.Fields[field].(string)
+	return s
+}
+
+func (o *levelFilterOutlet) WriteEntry(e logger.Entry) error {
+	if e.Level < o.minLevel(&e) {
+		return nil
+	}
+	return o.outlet.WriteEntry(e)
+}
+
+func levelOverrides(in config.LoggingOutletEnum) (jobs, subsystems map[string]string) {
+	switch v := in.Ret.(type) {
+	case *config.StdoutLoggingOutlet:
+		return v.Jobs, v.Subsystems
+	case *config.SyslogLoggingOutlet:
+		return v.Jobs, v.Subsystems
+	case *config.TCPLoggingOutlet:
+		return v.Jobs, v.Subsystems
+	case *config.JournaldLoggingOutlet:
+		return v.Jobs, v.Subsystems
+	default:
+		return nil, nil
+	}
+}
+
+// jobLevels returns the logging levels of the jobs that set one.
+func jobLevels(jobs []config.JobEnum) (map[string]string, error) {
+	levels := make(map[string]string)
+	for _, j := range jobs {
+		l := j.Logging().Level
+		if l == "" {
+			continue
+		}
+		if _, err := logger.ParseLevel(l); err != nil {
+			return nil, errors.Wrapf(err, "cannot parse logging level of job %q", j.Name())
+		}
+		levels[j.Name()] = l
+	}
+	return levels, nil
+}
+
+// withJobLevels returns the outlet's job levels, defaulting to the levels of the jobs.
+func withJobLevels(jobLevels, outletJobs map[string]string) map[string]string {
+	if len(jobLevels) == 0 {
+		return outletJobs
+	}
+	out := make(map[string]string, len(jobLevels)+len(outletJobs))
+	for job, l := range jobLevels {
+		out[job] = l
+	}
+	for job, l := range outletJobs {
+		out[job] = l
+	}
+	return out
+}
+
+// parseLevelOverrides returns a filter for an outlet whose entries of the given jobs and subsystems
+// are filtered by their own level instead of minLevel, or nil if there are no such levels.
+// It also returns the level with which the filter must be added to logger.Outlets.
+// The caller must set the filter's outlet.
+func parseLevelOverrides(minLevel logger.Level, jobs, subsystems map[string]string) (*levelFilterOutlet, logger.Level, error) {
+	if len(jobs) == 0 && len(subsystems) == 0 {
+		return nil, minLevel, nil
+	}
+	f := &levelFilterOutlet{
+		level:      minLevel,
+		jobs:       make(map[string]logger.Level, len(jobs)),
+		subsystems: make(map[string]logger.Level, len(subsystems)),
+	}
+	addLevel := minLevel
+	for job, l := range jobs {
+		level, err := logger.ParseLevel(l)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "cannot parse level of job %q", job)
+		}
+		f.jobs[job] = level
+		if level < addLevel {
+			addLevel = level
+		}
+	}
+	for subsys, l := range subsystems {
+		if !isSubsystem(subsys) {
+			return nil, 0, errors.Errorf("unknown subsystem %q, must be one of %v", subsys, Subsystems)
+		}
+		level, err := logger.ParseLevel(l)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "cannot parse level of subsystem %q", subsys)
+		}
+		f.subsystems[subsys] = level
+		if level < addLevel {
+			addLevel = level
+		}
+	}
+	return f, addLevel, nil
+}
@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/logger"
+	"testing"
+)
+
+type recordingOutlet struct {
+	entries []logger.Entry
+}
+
+func (o *recordingOutlet) WriteEntry(e logger.Entry) error {
+	o.entries = append(o.entries, e)
+	return nil
+}
+
+func TestLevelOverrides(t *testing.T) {
+	f, addLevel, err := parseLevelOverrides(logger.Warn,
+		map[string]string{"prod": "debug", "quiet": "error"},
+		map[string]string{SubsysStreamrpc: "error", SubsysPruning: "info"})
+	require.NoError(t, err)
+	assert.Equal(t, logger.Debug, addLevel)
+	rec := &recordingOutlet{}
+	f.outlet = rec
+
+	entry := func(level logger.Level, job, subsys string) logger.Entry {
+		fields := logger.Fields{}
+		if job != "" {
+			fields[JobField] = job
+		}
+		if subsys != "" {
+			fields[SubsysField] = subsys
+		}
+		return logger.Entry{Level: level, Fields: fields}
+	}
+	tcs := []struct {
+		entry  logger.Entry
+		passes bool
+	}{
+		{entry(logger.Info, "", ""), false},
+		{entry(logger.Warn, "other", SubsysReplication), true},
+		{entry(logger.Debug, "prod", ""), true},
+		{entry(logger.Warn, "quiet", ""), false},
+		{entry(logger.Warn, "other", SubsysStreamrpc), false},
+		{entry(logger.Info, "other", SubsysPruning), true},
+		// the more verbose of job and subsystem level applies
+		{entry(logger.Debug, "prod", SubsysStreamrpc), true},
+		{entry(logger.Info, "quiet", SubsysPruning), true},
+		{entry(logger.Warn, "quiet", SubsysStreamrpc), false},
+	}
+	for i, tc := range tcs {
+		rec.entries = nil
+		require.NoError(t, f.WriteEntry(tc.entry))
+		assert.Equal(t, tc.passes, len(rec.entries) == 1, "test case %d", i)
+	}
+}
+
+func TestLevelOverridesInvalid(t *testing.T) {
+	f, addLevel, err := parseLevelOverrides(logger.Info, nil, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, f)
+	assert.Equal(t, logger.Info, addLevel)
+
+	_, _, err = parseLevelOverrides(logger.Info, nil, map[string]string{"nonexistent": "debug"})
+	assert.Error(t, err)
+	_, _, err = parseLevelOverrides(logger.Info, map[string]string{"prod": "verbose"}, nil)
+	assert.Error(t, err)
+}
+
+func TestJobLevels(t *testing.T) {
+	job := func(name, level string) config.JobEnum {
+		j := &config.SinkJob{}
+		j.Name = name
+		j.Logging.Level = level
+		return config.JobEnum{Ret: j}
+	}
+	levels, err := jobLevels([]config.JobEnum{job("prod", "debug"), job("quiet", "error"), job("other", "")})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"prod": "debug", "quiet": "error"}, levels)
+
+	// the outlet's levels take precedence over those of the jobs
+	assert.Equal(t, map[string]string{"prod": "info", "quiet": "error"},
+		withJobLevels(levels, map[string]string{"prod": "info"}))
+	assert.Equal(t, map[string]string{"prod": "info"}, withJobLevels(nil, map[string]string{"prod": "info"}))
+
+	_, err = jobLevels([]config.JobEnum{job("prod", "verbose")})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `job "prod"`)
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"log/syslog"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -32,15 +33,42 @@ func (h WriterOutlet) WriteEntry(entry logger.Entry) error {
 	return err
 }
 
+// TCPOutletOverflow determines which entry a TCPOutlet drops if its buffer is full.
+type TCPOutletOverflow int
+
+const (
+	TCPOutletDropOldest TCPOutletOverflow = iota
+	TCPOutletDropNewest
+)
+
+func ParseTCPOutletOverflow(s string) (TCPOutletOverflow, error) {
+	switch s {
+	case "drop_oldest":
+		return TCPOutletDropOldest, nil
+	case "drop_newest":
+		return TCPOutletDropNewest, nil
+	default:
+		return 0, errors.Errorf("invalid overflow policy %q, must be 'drop_oldest' or 'drop_newest'", s)
+	}
+}
+
+// TCPOutlet buffers entries while the connection is broken or not fast enough
+// and sends them after reconnecting.
 type TCPOutlet struct {
 	formatter EntryFormatter
 	// Specifies how much time must pass between a connection error and a reconnection attempt
-	// Log entries written to the outlet during this time interval are silently dropped.
-	connect   func(ctx context.Context) (net.Conn, error)
-	entryChan chan *bytes.Buffer
+	// Log entries written to the outlet during this time interval are buffered.
+	connect  func(ctx context.Context) (net.Conn, error)
+	overflow TCPOutletOverflow
+	wakeup   chan struct{}
+
+	mtx        sync.Mutex
+	bufferSize int
+	buffer     []*bytes.Buffer // oldest first
+	closed     bool
 }
 
-func NewTCPOutlet(formatter EntryFormatter, network, address string, tlsConfig *tls.Config, retryInterval time.Duration) *TCPOutlet {
+func NewTCPOutlet(formatter EntryFormatter, network, address string, tlsConfig *tls.Config, retryInterval time.Duration, bufferSize int, overflow TCPOutletOverflow) *TCPOutlet {
 
 	connect := func(ctx context.Context) (conn net.Conn, err error) {
 		deadl, ok := ctx.Deadline()
@@ -58,12 +86,13 @@ func NewTCPOutlet(formatter EntryFormatter, network, address string, tlsConfig *
 		return
 	}
 
-	entryChan := make(chan *bytes.Buffer, 1) // allow one message in flight while previos is in io.Copy()
-
 	o := &TCPOutlet{
-		formatter: formatter,
-		connect:   connect,
-		entryChan: entryChan,
+		formatter:  formatter,
+		connect:    connect,
+		overflow:   overflow,
+		wakeup:     make(chan struct{}, 1),
+		bufferSize: bufferSize,
+		buffer:     make([]*bytes.Buffer, 0, bufferSize),
 	}
 
 	go o.outLoop(retryInterval)
@@ -73,32 +102,65 @@ func NewTCPOutlet(formatter EntryFormatter, network, address string, tlsConfig *
 
 // FIXME: use this method
 func (h *TCPOutlet) Close() {
-	close(h.entryChan)
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if !h.closed {
+		h.closed = true
+		close(h.wakeup)
+	}
+}
+
+// oldest returns the oldest buffered entry, or nil if there is none.
+func (h *TCPOutlet) oldest() *bytes.Buffer {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if len(h.buffer) == 0 {
+		return nil
+	}
+	return h.buffer[0]
+}
+
+// sent removes msg from the buffer unless it was already dropped in favor of newer entries.
+func (h *TCPOutlet) sent(msg *bytes.Buffer) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if len(h.buffer) > 0 && h.buffer[0] == msg {
+		h.buffer[0] = nil
+		h.buffer = h.buffer[1:]
+	}
 }
 
 func (h *TCPOutlet) outLoop(retryInterval time.Duration) {
 
 	var retry time.Time
 	var conn net.Conn
-	for msg := range h.entryChan {
-		var err error
-		for conn == nil {
-			time.Sleep(time.Until(retry))
-			ctx, cancel := context.WithDeadline(context.TODO(), time.Now().Add(retryInterval))
-			conn, err = h.connect(ctx)
-			cancel()
+	for range h.wakeup {
+		for msg := h.oldest(); msg != nil; msg = h.oldest() {
+			var err error
+			for conn == nil {
+				time.Sleep(time.Until(retry))
+				ctx, cancel := context.WithDeadline(context.TODO(), time.Now().Add(retryInterval))
+				conn, err = h.connect(ctx)
+				cancel()
+				if err != nil {
+					retry = time.Now().Add(retryInterval)
+					conn = nil
+				}
+			}
+			conn.SetWriteDeadline(time.Now().Add(retryInterval))
+			// unlike io.Copy, this does not consume msg, so that it is sent again in full after reconnecting
+			_, err = conn.Write(msg.Bytes())
 			if err != nil {
 				retry = time.Now().Add(retryInterval)
+				conn.Close()
 				conn = nil
+				continue
 			}
+			h.sent(msg)
 		}
-		conn.SetWriteDeadline(time.Now().Add(retryInterval))
-		_, err = io.Copy(conn, msg)
-		if err != nil {
-			retry = time.Now().Add(retryInterval)
-			conn.Close()
-			conn = nil
-		}
+	}
+	if conn != nil {
+		conn.Close()
 	}
 }
 
@@ -113,12 +175,28 @@ func (h *TCPOutlet) WriteEntry(e logger.Entry) error {
 	buf.Write(ebytes)
 	buf.WriteString("\n")
 
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if h.closed {
+		return errors.New("outlet closed")
+	}
+	if len(h.buffer) >= h.bufferSize {
+		switch h.overflow {
+		case TCPOutletDropNewest:
+			return errors.New("buffer full while connection broken or not fast enough, dropped newest entry")
+		default:
+			h.buffer[0] = nil
+			h.buffer = append(h.buffer[1:], buf)
+			err = errors.New("buffer full while connection broken or not fast enough, dropped oldest entry")
+		}
+	} else {
+		h.buffer = append(h.buffer, buf)
+	}
 	select {
-	case h.entryChan <- buf:
-		return nil
-	default:
-		return errors.New("connection broken or not fast enough")
+	case h.wakeup <- struct{}{}:
+	default: // outLoop is already woken up
 	}
+	return err
 }
 
 type SyslogOutlet struct {
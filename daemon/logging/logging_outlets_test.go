@@ -0,0 +1,94 @@
+package logging
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/logger"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestTCPOutlet returns a TCPOutlet that cannot connect until the collector is up.
+// The collector's end of a connection is sent on the returned channel.
+func newTestTCPOutlet(bufferSize int, overflow TCPOutletOverflow) (o *TCPOutlet, up func(), conns <-chan net.Conn) {
+	var mtx sync.Mutex
+	isUp := false
+	serverConns := make(chan net.Conn, 1)
+	connect := func(ctx context.Context) (net.Conn, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if !isUp {
+			return nil, errors.New("collector down")
+		}
+		client, server := net.Pipe()
+		serverConns <- server
+		return client, nil
+	}
+	o = &TCPOutlet{
+		formatter:  NoFormatter{},
+		connect:    connect,
+		overflow:   overflow,
+		wakeup:     make(chan struct{}, 1),
+		bufferSize: bufferSize,
+	}
+	go o.outLoop(10 * time.Millisecond)
+	up = func() {
+		mtx.Lock()
+		defer mtx.Unlock()
+		isUp = true
+	}
+	return o, up, serverConns
+}
+
+func readLines(t *testing.T, conn net.Conn, n int) []string {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	lines := make([]string, n)
+	for i := range lines {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		lines[i] = line[:len(line)-1]
+	}
+	return lines
+}
+
+func TestTCPOutletBackfill(t *testing.T) {
+	for _, tc := range []struct {
+		overflow TCPOutletOverflow
+		expect   []string
+	}{
+		{TCPOutletDropOldest, []string{"2", "3", "4"}},
+		{TCPOutletDropNewest, []string{"0", "1", "2"}},
+	} {
+		o, up, conns := newTestTCPOutlet(3, tc.overflow)
+		for i := 0; i < 5; i++ {
+			err := o.WriteEntry(logger.Entry{Message: fmt.Sprintf("%d", i)})
+			if i < 3 {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err, "entry %d overflows the buffer", i)
+			}
+		}
+		up()
+		conn := <-conns
+		assert.Equal(t, tc.expect, readLines(t, conn, 3))
+
+		require.NoError(t, o.WriteEntry(logger.Entry{Message: "after reconnect"}))
+		assert.Equal(t, []string{"after reconnect"}, readLines(t, conn, 1))
+		o.Close()
+	}
+}
+
+func TestParseTCPOutletOverflow(t *testing.T) {
+	o, err := ParseTCPOutletOverflow("drop_newest")
+	require.NoError(t, err)
+	assert.Equal(t, TCPOutletDropNewest, o)
+	_, err = ParseTCPOutletOverflow("block")
+	assert.Error(t, err)
+}
@@ -0,0 +1,194 @@
+package logging
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zrepl/zrepl/logger"
+	"sync"
+	"time"
+)
+
+const (
+	// OutletQueueSize is the number of entries an outlet may lag behind before entries are dropped.
+	OutletQueueSize = 1000
+	// OutletDropNoticeInterval is how often an outlet that dropped entries or failed to write them reports so.
+	OutletDropNoticeInterval = 30 * time.Second
+)
+
+var prom struct {
+	outletDroppedEntries *prometheus.CounterVec
+	outletWriteErrors    *prometheus.CounterVec
+}
+
+func init() {
+	prom.outletDroppedEntries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "zrepl",
+		Subsystem: "logging",
+		Name:      "outlet_dropped_entries",
+		Help:      "number of log entries dropped because the outlet's queue was full",
+	}, []string{"outlet"})
+	prom.outletWriteErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "zrepl",
+		Subsystem: "logging",
+		Name:      "outlet_write_errors",
+		Help:      "number of log entries the outlet failed to write",
+	}, []string{"outlet"})
+}
+
+func PrometheusRegister(registry prometheus.Registerer) error {
+	if err := registry.Register(prom.outletDroppedEntries); err != nil {
+		return err
+	}
+	if err := registry.Register(prom.outletWriteErrors); err != nil {
+		return err
+	}
+	return nil
+}
+
+type queuedEntry struct {
+	entry logger.Entry
+	// if non-nil, this is not an entry but a flush request that is closed once all previous entries are written
+	flushed chan struct{}
+}
+
+// QueuedOutlet decouples a possibly slow or stalled outlet, e.g. a TCP outlet whose log collector hangs,
+// from the goroutines that log: entries are queued and written by a goroutine of the outlet,
+// and dropped if the queue is full.
+//
+// Since a failing outlet cannot report its own errors, dropped entries and write errors are counted
+// and reported periodically through the outlet as a single notice, once it works again.
+type QueuedOutlet struct {
+	name   string
+	outlet logger.Outlet
+	queue  chan queuedEntry
+
+	mtx         sync.Mutex
+	dropped     uint64
+	writeErrors uint64
+	lastError   error
+}
+
+var _ logger.Outlet = &QueuedOutlet{}
+
+func NewQueuedOutlet(name string, outlet logger.Outlet, size int, noticeInterval time.Duration) *QueuedOutlet {
+	o := &QueuedOutlet{
+		name:   name,
+		outlet: outlet,
+		queue:  make(chan queuedEntry, size),
+	}
+	go o.writeLoop(noticeInterval)
+	return o
+}
+
+// WriteEntry never blocks and never fails, dropped entries are reported by the outlet's notice.
+func (o *QueuedOutlet) WriteEntry(entry logger.Entry) error {
+	select {
+	case o.queue <- queuedEntry{entry: entry}:
+	default:
+		o.mtx.Lock()
+		o.dropped++
+		o.mtx.Unlock()
+		prom.outletDroppedEntries.WithLabelValues(o.name).Inc()
+	}
+	return nil
+}
+
+// Flush waits until the entries queued so far are written, but no longer than timeout.
+// It returns false if the timeout is exceeded.
+func (o *QueuedOutlet) Flush(timeout time.Duration) bool {
+	flushed := make(chan struct{})
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case o.queue <- queuedEntry{flushed: flushed}:
+	case <-t.C:
+		return false
+	}
+	select {
+	case <-flushed:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+func (o *QueuedOutlet) writeLoop(noticeInterval time.Duration) {
+	ticker := time.NewTicker(noticeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case e := <-o.queue:
+			if e.flushed != nil {
+				close(e.flushed)
+				continue
+			}
+			if err := o.outlet.WriteEntry(e.entry); err != nil {
+				o.mtx.Lock()
+				o.writeErrors++
+				o.lastError = err
+				o.mtx.Unlock()
+				prom.outletWriteErrors.WithLabelValues(o.name).Inc()
+			}
+		case <-ticker.C:
+			o.notice()
+		}
+	}
+}
+
+// notice writes a warning about the entries dropped or not written since the last notice, if any.
+// If the notice cannot be written either, the counts carry over to the next one.
+func (o *QueuedOutlet) notice() {
+	o.mtx.Lock()
+	dropped, writeErrors, lastError := o.dropped, o.writeErrors, o.lastError
+	o.mtx.Unlock()
+	if dropped == 0 && writeErrors == 0 {
+		return
+	}
+	fields := logger.Fields{
+		"outlet":       o.name,
+		"dropped":      dropped,
+		"write_errors": writeErrors,
+	}
+	if lastError != nil {
+		fields[logger.FieldError] = lastError.Error()
+	}
+	entry := logger.Entry{
+		Level:   logger.Warn,
+		Message: "log outlet dropped entries or failed to write them",
+		Time:    time.Now(),
+		Fields:  fields,
+	}
+	if err := o.outlet.WriteEntry(entry); err != nil {
+		return
+	}
+	o.mtx.Lock()
+	o.dropped -= dropped
+	o.writeErrors -= writeErrors
+	if o.writeErrors == 0 {
+		o.lastError = nil
+	}
+	o.mtx.Unlock()
+}
+
+var queuedOutlets struct {
+	mtx     sync.Mutex
+	outlets []*QueuedOutlet
+}
+
+// FlushOutlets waits until the entries queued in the outlets built by OutletsFromConfig are written,
+// but no longer than timeout per outlet.
+func FlushOutlets(timeout time.Duration) {
+	queuedOutlets.mtx.Lock()
+	outlets := append([]*QueuedOutlet{}, queuedOutlets.outlets...)
+	queuedOutlets.mtx.Unlock()
+	for _, o := range outlets {
+		o.Flush(timeout)
+	}
+}
+
+func newConfiguredOutlet(name string, outlet logger.Outlet) *QueuedOutlet {
+	o := NewQueuedOutlet(name, outlet, OutletQueueSize, OutletDropNoticeInterval)
+	queuedOutlets.mtx.Lock()
+	queuedOutlets.outlets = append(queuedOutlets.outlets, o)
+	queuedOutlets.mtx.Unlock()
+	return o
+}
@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/logger"
+	"sync"
+	"testing"
+	"time"
+)
+
+type blockingOutlet struct {
+	unblock chan struct{}
+	mtx     sync.Mutex
+	entries []logger.Entry
+	fail    bool
+}
+
+func (o *blockingOutlet) WriteEntry(e logger.Entry) error {
+	<-o.unblock
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	if o.fail {
+		return errors.New("broken")
+	}
+	o.entries = append(o.entries, e)
+	return nil
+}
+
+func TestQueuedOutletDropsWhenFull(t *testing.T) {
+	inner := &blockingOutlet{unblock: make(chan struct{})}
+	o := NewQueuedOutlet("test", inner, 2, time.Hour)
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, o.WriteEntry(logger.Entry{Message: "msg"}))
+	}
+	assert.True(t, time.Since(start) < time.Second, "WriteEntry must not block")
+	assert.False(t, o.Flush(10*time.Millisecond))
+
+	close(inner.unblock)
+	require.True(t, o.Flush(time.Second))
+	o.notice()
+
+	inner.mtx.Lock()
+	defer inner.mtx.Unlock()
+	// at most one entry in flight plus two queued, the rest was dropped
+	require.True(t, len(inner.entries) >= 3 && len(inner.entries) <= 4)
+	notice := inner.entries[len(inner.entries)-1]
+	written := len(inner.entries) - 1
+	assert.Equal(t, logger.Warn, notice.Level)
+	assert.Equal(t, uint64(10-written), notice.Fields["dropped"])
+	assert.Equal(t, uint64(0), notice.Fields["write_errors"])
+}
+
+func TestQueuedOutletNoticeCarriesOver(t *testing.T) {
+	inner := &blockingOutlet{unblock: make(chan struct{}), fail: true}
+	close(inner.unblock)
+	o := NewQueuedOutlet("test", inner, 10, time.Hour)
+
+	require.NoError(t, o.WriteEntry(logger.Entry{Message: "msg"}))
+	require.True(t, o.Flush(time.Second))
+	o.notice() // fails, too
+
+	inner.mtx.Lock()
+	inner.fail = false
+	inner.mtx.Unlock()
+	require.NoError(t, o.WriteEntry(logger.Entry{Message: "msg"}))
+	require.True(t, o.Flush(time.Second))
+	o.notice()
+	o.notice() // nothing left to report
+
+	inner.mtx.Lock()
+	defer inner.mtx.Unlock()
+	require.Len(t, inner.entries, 2)
+	notice := inner.entries[1]
+	assert.Equal(t, uint64(1), notice.Fields["write_errors"])
+	assert.Equal(t, "broken", notice.Fields[logger.FieldError])
+}
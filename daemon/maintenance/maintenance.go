@@ -0,0 +1,118 @@
+// Package maintenance finds and removes ZFS objects owned by zrepl that no job needs anymore,
+// e.g. because a filesystem was removed from all jobs, or because a run crashed or its state was lost.
+//
+// Currently, these are replication cursor bookmarks on filesystems that no job on this host sends.
+// zrepl does not place holds on snapshots, hence there are no holds to release.
+package maintenance
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/filters"
+	"github.com/zrepl/zrepl/zfs"
+	"strings"
+)
+
+// Stale is a zrepl-owned dataset (e.g. a bookmark) that is not referenced by any job.
+type Stale struct {
+	Dataset string
+	Reason  string
+}
+
+func (s Stale) String() string { return fmt.Sprintf("%s (%s)", s.Dataset, s.Reason) }
+
+// SenderFilters returns the filesystem filters of the jobs that send from this host,
+// i.e. push and source jobs.
+func SenderFilters(jobs []config.JobEnum) ([]zfs.DatasetFilter, error) {
+	var fsfs []zfs.DatasetFilter
+	for _, j := range jobs {
+		var in config.FilesystemsFilter
+		switch v := j.Ret.(type) {
+		case *config.PushJob:
+			in = v.Filesystems
+		case *config.SourceJob:
+			in = v.Filesystems
+		default:
+			continue
+		}
+		fsf, err := filters.DatasetMapFilterFromConfig(in)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot build filesystem filter")
+		}
+		fsfs = append(fsfs, fsf)
+	}
+	return fsfs, nil
+}
+
+// FindStale returns the zrepl-owned datasets on this host that are not referenced by any of the senderFilters.
+func FindStale(senderFilters []zfs.DatasetFilter) ([]Stale, error) {
+	bookmarks, err := zfs.ZFSList([]string{"name"}, "-t", "bookmark")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot list bookmarks")
+	}
+	names := make([]string, len(bookmarks))
+	for i, b := range bookmarks {
+		names[i] = b[0]
+	}
+	return staleBookmarks(senderFilters, names)
+}
+
+func staleBookmarks(senderFilters []zfs.DatasetFilter, bookmarks []string) ([]Stale, error) {
+	var stale []Stale
+	for _, b := range bookmarks {
+		fs, _, name, err := zfs.DecomposeVersionString(b)
+		if err != nil {
+			return nil, err
+		}
+		if name != zfs.ReplicationCursorBookmarkName {
+			continue
+		}
+		p, err := zfs.NewDatasetPath(fs)
+		if err != nil {
+			return nil, err
+		}
+		sent, err := isSent(senderFilters, p)
+		if err != nil {
+			return nil, err
+		}
+		if !sent {
+			stale = append(stale, Stale{b, "replication cursor of a filesystem that no job sends"})
+		}
+	}
+	return stale, nil
+}
+
+func isSent(senderFilters []zfs.DatasetFilter, p *zfs.DatasetPath) (bool, error) {
+	for _, f := range senderFilters {
+		pass, err := f.Filter(p)
+		if err != nil {
+			return false, errors.Wrapf(err, "cannot filter %s", p.ToString())
+		}
+		if pass {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Remove destroys the stale datasets, continuing on errors.
+// It returns the datasets that were destroyed and an error that lists those that were not.
+func Remove(stale []Stale) (removed []Stale, err error) {
+	var failed []string
+	for _, s := range stale {
+		if !strings.Contains(s.Dataset, "#") {
+			// only bookmarks are collected, never destroy a filesystem or snapshot here
+			panic(fmt.Sprintf("implementation error: stale dataset %q is not a bookmark", s.Dataset))
+		}
+		if err := zfs.ZFSDestroy(s.Dataset); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", s.Dataset, err))
+			continue
+		}
+		removed = append(removed, s)
+	}
+	if len(failed) > 0 {
+		return removed, errors.Errorf("cannot destroy %d stale datasets: %s", len(failed), strings.Join(failed, "; "))
+	}
+	return removed, nil
+}
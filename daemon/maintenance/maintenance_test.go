@@ -0,0 +1,51 @@
+package maintenance
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/config"
+	"testing"
+)
+
+func TestStaleBookmarks(t *testing.T) {
+	jobs := []config.JobEnum{
+		{Ret: &config.PushJob{ActiveJob: config.ActiveJob{Name: "push"}, Filesystems: config.FilesystemsFilter{"pool/push<": true, "pool/push/tmp": false}}},
+		{Ret: &config.SourceJob{PassiveJob: config.PassiveJob{Name: "source"}, Filesystems: config.FilesystemsFilter{"pool/source": true}}},
+		// receiving jobs do not own replication cursors on this host
+		{Ret: &config.SinkJob{PassiveJob: config.PassiveJob{Name: "sink"}, RootFS: "pool/sink"}},
+	}
+	fsfs, err := SenderFilters(jobs)
+	require.NoError(t, err)
+	require.Len(t, fsfs, 2)
+
+	stale, err := staleBookmarks(fsfs, []string{
+		"pool/push/a#zrepl_replication_cursor",
+		"pool/push/tmp#zrepl_replication_cursor",
+		"pool/push/a#manual",
+		"pool/source#zrepl_replication_cursor",
+		"pool/sink/b#zrepl_replication_cursor",
+		"pool/removed#zrepl_replication_cursor",
+	})
+	require.NoError(t, err)
+	var datasets []string
+	for _, s := range stale {
+		datasets = append(datasets, s.Dataset)
+	}
+	assert.Equal(t, []string{
+		"pool/push/tmp#zrepl_replication_cursor",
+		"pool/sink/b#zrepl_replication_cursor",
+		"pool/removed#zrepl_replication_cursor",
+	}, datasets)
+
+	_, err = staleBookmarks(fsfs, []string{"pool/a"})
+	assert.Error(t, err)
+}
+
+func TestRemoveOnlyBookmarks(t *testing.T) {
+	assert.Panics(t, func() {
+		Remove([]Stale{{Dataset: "pool/a@snap"}})
+	})
+	assert.Panics(t, func() {
+		Remove([]Stale{{Dataset: "pool/a"}})
+	})
+}
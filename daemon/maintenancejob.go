@@ -0,0 +1,57 @@
+package daemon
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/job"
+	"github.com/zrepl/zrepl/daemon/maintenance"
+	"github.com/zrepl/zrepl/zfs"
+	"time"
+)
+
+// maintenanceJob periodically removes stale zrepl bookmarks, see package maintenance.
+type maintenanceJob struct {
+	interval      time.Duration
+	senderFilters []zfs.DatasetFilter
+}
+
+func newMaintenanceJob(interval time.Duration, jobs []config.JobEnum) (*maintenanceJob, error) {
+	fsfs, err := maintenance.SenderFilters(jobs)
+	if err != nil {
+		return nil, err
+	}
+	return &maintenanceJob{interval, fsfs}, nil
+}
+
+func (j *maintenanceJob) Name() string { return jobNameMaintenance }
+
+func (j *maintenanceJob) Status() *job.Status { return &job.Status{Type: job.TypeInternal} }
+
+func (j *maintenanceJob) RegisterMetrics(registerer prometheus.Registerer) {}
+
+func (j *maintenanceJob) Run(ctx context.Context) {
+	log := job.GetLogger(ctx)
+	t := time.NewTicker(j.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		stale, err := maintenance.FindStale(j.senderFilters)
+		if err != nil {
+			log.WithError(err).Error("cannot find stale bookmarks")
+			continue
+		}
+		removed, err := maintenance.Remove(stale)
+		for _, s := range removed {
+			log.WithField("dataset", s.Dataset).WithField("reason", s.Reason).Info("removed stale dataset")
+		}
+		if err != nil {
+			log.WithError(err).Error("cannot remove stale datasets")
+		}
+	}
+}
@@ -0,0 +1,255 @@
+// Package monitor periodically checks per-filesystem invariants, e.g. that snapshots are still being taken
+// and replicated, to detect pipelines that fail silently.
+//
+// Violations are logged as errors when they start and as info when they end,
+// and exported as Prometheus gauges for as long as they last.
+package monitor
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/filters"
+	"github.com/zrepl/zrepl/daemon/logging"
+	"github.com/zrepl/zrepl/logger"
+	"github.com/zrepl/zrepl/zfs"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Invariant string
+
+const (
+	NewestSnapshotAge Invariant = "newest_snapshot_age"
+	ReplicationLag    Invariant = "replication_lag"
+	SnapshotCount     Invariant = "snapshot_count"
+)
+
+type Check struct {
+	Name   string
+	Filter zfs.DatasetFilter
+	Prefix string
+	// zero disables the invariant
+	NewestSnapshotMaxAge time.Duration
+	ReplicationMaxLag    time.Duration
+	MaxSnapshots         int
+}
+
+func ChecksFromConfig(in []*config.SnapshotCheck) ([]*Check, error) {
+	checks := make([]*Check, len(in))
+	names := make(map[string]bool, len(in))
+	for i, c := range in {
+		if c.Name == "" {
+			return nil, errors.Errorf("check #%d: name must not be empty", i)
+		}
+		if names[c.Name] {
+			return nil, errors.Errorf("duplicate check name %q", c.Name)
+		}
+		names[c.Name] = true
+		if c.NewestSnapshotMaxAge < 0 || c.ReplicationMaxLag < 0 || c.MaxSnapshots < 0 {
+			return nil, errors.Errorf("check %q: limits must not be negative", c.Name)
+		}
+		if c.NewestSnapshotMaxAge == 0 && c.ReplicationMaxLag == 0 && c.MaxSnapshots == 0 {
+			return nil, errors.Errorf("check %q: no invariant configured", c.Name)
+		}
+		filter, err := filters.DatasetMapFilterFromConfig(c.Filesystems)
+		if err != nil {
+			return nil, errors.Wrapf(err, "check %q: invalid filesystems filter", c.Name)
+		}
+		checks[i] = &Check{
+			Name:                 c.Name,
+			Filter:               filter,
+			Prefix:               c.SnapshotPrefix,
+			NewestSnapshotMaxAge: c.NewestSnapshotMaxAge,
+			ReplicationMaxLag:    c.ReplicationMaxLag,
+			MaxSnapshots:         c.MaxSnapshots,
+		}
+	}
+	return checks, nil
+}
+
+// FSState is the part of a filesystem's state that the invariants are about.
+type FSState struct {
+	// Number of snapshots with the check's prefix.
+	Snapshots int
+	// Creation of the most recent snapshot with the check's prefix, zero if there is none.
+	Newest time.Time
+	// Creation of the snapshot the replication cursor points to, zero if there is no cursor.
+	Replicated time.Time
+}
+
+func fsState(versions []zfs.FilesystemVersion, prefix string) FSState {
+	var s FSState
+	for _, v := range versions {
+		switch {
+		case v.Type == zfs.Bookmark && v.Name == zfs.ReplicationCursorBookmarkName:
+			// bookmarks have the creation time of their snapshot
+			s.Replicated = v.Creation
+		case v.Type == zfs.Snapshot && strings.HasPrefix(v.Name, prefix):
+			s.Snapshots++
+			if v.Creation.After(s.Newest) {
+				s.Newest = v.Creation
+			}
+		}
+	}
+	return s
+}
+
+type Violation struct {
+	Check      string
+	Filesystem string
+	Invariant  Invariant
+	Problem    string
+}
+
+func (v Violation) key() string {
+	return fmt.Sprintf("%s\x00%s\x00%s", v.Check, v.Filesystem, v.Invariant)
+}
+
+// Evaluate returns the violations of c's invariants by filesystem fs in state s.
+func (c *Check) Evaluate(fs string, s FSState, now time.Time) []Violation {
+	var vs []Violation
+	add := func(inv Invariant, format string, args ...interface{}) {
+		vs = append(vs, Violation{c.Name, fs, inv, fmt.Sprintf(format, args...)})
+	}
+	if c.NewestSnapshotMaxAge > 0 {
+		if s.Newest.IsZero() {
+			add(NewestSnapshotAge, "no snapshots")
+		} else if age := now.Sub(s.Newest); age > c.NewestSnapshotMaxAge {
+			add(NewestSnapshotAge, "newest snapshot is %s old, limit is %s", age.Truncate(time.Second), c.NewestSnapshotMaxAge)
+		}
+	}
+	if c.ReplicationMaxLag > 0 {
+		if s.Replicated.IsZero() {
+			add(ReplicationLag, "never replicated (no replication cursor)")
+		} else if lag := now.Sub(s.Replicated); lag > c.ReplicationMaxLag {
+			add(ReplicationLag, "most recent replicated snapshot is %s old, limit is %s", lag.Truncate(time.Second), c.ReplicationMaxLag)
+		}
+	}
+	if c.MaxSnapshots > 0 && s.Snapshots > c.MaxSnapshots {
+		add(SnapshotCount, "%d snapshots, limit is %d", s.Snapshots, c.MaxSnapshots)
+	}
+	return vs
+}
+
+// Monitor is not safe for concurrent use.
+type Monitor struct {
+	checks []*Check
+
+	promViolation *prometheus.GaugeVec // labels: check, filesystem, invariant
+	promNewestAge *prometheus.GaugeVec // labels: check, filesystem
+	promLag       *prometheus.GaugeVec // labels: check, filesystem
+	promSnapshots *prometheus.GaugeVec // labels: check, filesystem
+
+	// by Violation.key
+	violations map[string]Violation
+}
+
+func NewMonitor(checks []*Check) *Monitor {
+	return &Monitor{
+		checks: checks,
+		promViolation: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "zrepl",
+			Subsystem: "monitor",
+			Name:      "violation",
+			Help:      "1 while a filesystem violates an invariant of a snapshot monitoring check",
+		}, []string{"check", "filesystem", "invariant"}),
+		promNewestAge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "zrepl",
+			Subsystem: "monitor",
+			Name:      "newest_snapshot_age_seconds",
+			Help:      "age of the newest snapshot matched by a snapshot monitoring check",
+		}, []string{"check", "filesystem"}),
+		promLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "zrepl",
+			Subsystem: "monitor",
+			Name:      "replication_lag_seconds",
+			Help:      "age of the snapshot the replication cursor points to",
+		}, []string{"check", "filesystem"}),
+		promSnapshots: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "zrepl",
+			Subsystem: "monitor",
+			Name:      "snapshots",
+			Help:      "number of snapshots matched by a snapshot monitoring check",
+		}, []string{"check", "filesystem"}),
+		violations: make(map[string]Violation),
+	}
+}
+
+func (m *Monitor) RegisterMetrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(m.promViolation)
+	registerer.MustRegister(m.promNewestAge)
+	registerer.MustRegister(m.promLag)
+	registerer.MustRegister(m.promSnapshots)
+}
+
+// CheckOnce evaluates all checks against the filesystems on this host.
+func (m *Monitor) CheckOnce(log logger.Logger, now time.Time) error {
+	states := make(map[*Check]map[string]FSState, len(m.checks))
+	for _, c := range m.checks {
+		fss, err := zfs.ZFSListMapping(c.Filter)
+		if err != nil {
+			return errors.Wrapf(err, "check %q: cannot list filesystems", c.Name)
+		}
+		versions, err := zfs.ZFSListFilesystemVersionsBatch(fss, nil)
+		if err != nil {
+			return errors.Wrapf(err, "check %q: cannot list snapshots", c.Name)
+		}
+		states[c] = make(map[string]FSState, len(versions))
+		for fs, vs := range versions {
+			states[c][fs] = fsState(vs, c.Prefix)
+		}
+	}
+	m.update(log, states, now)
+	return nil
+}
+
+// update exports states and logs the violations that started or ended since the last update.
+func (m *Monitor) update(log logger.Logger, states map[*Check]map[string]FSState, now time.Time) {
+	// filesystems that no longer exist must not be exported anymore
+	m.promViolation.Reset()
+	m.promNewestAge.Reset()
+	m.promLag.Reset()
+	m.promSnapshots.Reset()
+
+	current := make(map[string]Violation)
+	for _, c := range m.checks {
+		fss := make([]string, 0, len(states[c]))
+		for fs := range states[c] {
+			fss = append(fss, fs)
+		}
+		sort.Strings(fss)
+		for _, fs := range fss {
+			s := states[c][fs]
+			m.promSnapshots.WithLabelValues(c.Name, fs).Set(float64(s.Snapshots))
+			if !s.Newest.IsZero() {
+				m.promNewestAge.WithLabelValues(c.Name, fs).Set(now.Sub(s.Newest).Seconds())
+			}
+			if !s.Replicated.IsZero() {
+				m.promLag.WithLabelValues(c.Name, fs).Set(now.Sub(s.Replicated).Seconds())
+			}
+			for _, v := range c.Evaluate(fs, s, now) {
+				current[v.key()] = v
+				m.promViolation.WithLabelValues(v.Check, v.Filesystem, string(v.Invariant)).Set(1)
+				if _, ok := m.violations[v.key()]; !ok {
+					violationLogger(log, v).WithField("problem", v.Problem).Error("monitoring check violated")
+				}
+			}
+		}
+	}
+	for key, v := range m.violations {
+		if _, ok := current[key]; !ok {
+			violationLogger(log, v).Info("monitoring check no longer violated")
+		}
+	}
+	m.violations = current
+}
+
+func violationLogger(log logger.Logger, v Violation) logger.Logger {
+	return log.
+		WithField("check", v.Check).
+		WithField(logging.FSField, v.Filesystem).
+		WithField("invariant", string(v.Invariant))
+}
@@ -0,0 +1,67 @@
+package monitor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/logger"
+	"github.com/zrepl/zrepl/zfs"
+	"testing"
+	"time"
+)
+
+func TestFSState(t *testing.T) {
+	t0 := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	versions := []zfs.FilesystemVersion{
+		{Type: zfs.Snapshot, Name: "zrepl_1", Creation: t0},
+		{Type: zfs.Snapshot, Name: "zrepl_3", Creation: t0.Add(2 * time.Hour)},
+		{Type: zfs.Snapshot, Name: "zrepl_2", Creation: t0.Add(1 * time.Hour)},
+		{Type: zfs.Snapshot, Name: "manual", Creation: t0.Add(3 * time.Hour)},
+		{Type: zfs.Bookmark, Name: "zrepl_1", Creation: t0},
+		{Type: zfs.Bookmark, Name: zfs.ReplicationCursorBookmarkName, Creation: t0.Add(1 * time.Hour)},
+	}
+	s := fsState(versions, "zrepl_")
+	assert.Equal(t, FSState{Snapshots: 3, Newest: t0.Add(2 * time.Hour), Replicated: t0.Add(1 * time.Hour)}, s)
+
+	assert.Equal(t, FSState{}, fsState(nil, "zrepl_"))
+}
+
+func TestCheckEvaluate(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := &Check{Name: "c", NewestSnapshotMaxAge: time.Hour, ReplicationMaxLag: 2 * time.Hour, MaxSnapshots: 10}
+
+	invariants := func(vs []Violation) (invs []Invariant) {
+		for _, v := range vs {
+			invs = append(invs, v.Invariant)
+		}
+		return invs
+	}
+
+	ok := FSState{Snapshots: 10, Newest: now.Add(-time.Hour), Replicated: now.Add(-2 * time.Hour)}
+	assert.Empty(t, c.Evaluate("pool/fs", ok, now))
+
+	bad := FSState{Snapshots: 11, Newest: now.Add(-61 * time.Minute), Replicated: now.Add(-3 * time.Hour)}
+	assert.Equal(t, []Invariant{NewestSnapshotAge, ReplicationLag, SnapshotCount}, invariants(c.Evaluate("pool/fs", bad, now)))
+
+	assert.Equal(t, []Invariant{NewestSnapshotAge, ReplicationLag}, invariants(c.Evaluate("pool/fs", FSState{}, now)))
+
+	disabled := &Check{Name: "d", MaxSnapshots: 10}
+	assert.Empty(t, disabled.Evaluate("pool/fs", FSState{}, now))
+}
+
+func TestMonitorUpdate(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := &Check{Name: "c", MaxSnapshots: 1}
+	m := NewMonitor([]*Check{c})
+	log := logger.NewNullLogger()
+
+	m.update(log, map[*Check]map[string]FSState{c: {"pool/a": {Snapshots: 2}, "pool/b": {Snapshots: 1}}}, now)
+	require.Len(t, m.violations, 1)
+	for _, v := range m.violations {
+		assert.Equal(t, "pool/a", v.Filesystem)
+		assert.Equal(t, SnapshotCount, v.Invariant)
+	}
+
+	// pool/a recovered, pool/b was destroyed
+	m.update(log, map[*Check]map[string]FSState{c: {"pool/a": {Snapshots: 1}}}, now)
+	assert.Empty(t, m.violations)
+}
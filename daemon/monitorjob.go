@@ -0,0 +1,48 @@
+package daemon
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/job"
+	"github.com/zrepl/zrepl/daemon/monitor"
+	"time"
+)
+
+// snapshotMonitorJob periodically checks per-filesystem invariants, see package monitor.
+type snapshotMonitorJob struct {
+	interval time.Duration
+	monitor  *monitor.Monitor
+}
+
+func newSnapshotMonitorJobFromConfig(in *config.SnapshotMonitoring) (*snapshotMonitorJob, error) {
+	checks, err := monitor.ChecksFromConfig(in.Checks)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshotMonitorJob{in.Interval, monitor.NewMonitor(checks)}, nil
+}
+
+func (j *snapshotMonitorJob) Name() string { return jobNameSnapshotMonitor }
+
+func (j *snapshotMonitorJob) Status() *job.Status { return &job.Status{Type: job.TypeInternal} }
+
+func (j *snapshotMonitorJob) RegisterMetrics(registerer prometheus.Registerer) {
+	j.monitor.RegisterMetrics(registerer)
+}
+
+func (j *snapshotMonitorJob) Run(ctx context.Context) {
+	log := job.GetLogger(ctx)
+	t := time.NewTicker(j.interval)
+	defer t.Stop()
+	for {
+		if err := j.monitor.CheckOnce(log, time.Now()); err != nil {
+			log.WithError(err).Error("cannot check snapshot monitoring invariants")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// Package notify delivers the report of a job invocation to user-configured commands and webhooks,
+// e.g. to send mails or open incidents when replication fails.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/hooks"
+	"github.com/zrepl/zrepl/daemon/job/history"
+	"github.com/zrepl/zrepl/logger"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type Logger = logger.Logger
+
+// Report is passed to the notifiers as JSON.
+type Report struct {
+	Job string
+	history.Invocation
+}
+
+const (
+	EnvJob        = "ZREPL_JOB"
+	EnvOutcome    = "ZREPL_OUTCOME"
+	EnvInvocation = "ZREPL_INVOCATION"
+)
+
+type Notifier interface {
+	Notify(ctx context.Context, r *Report, body []byte) error
+	String() string
+}
+
+// Notifications holds the notifiers of a job.
+// All methods are no-ops on a nil *Notifications.
+type Notifications struct {
+	onError, onSuccess []Notifier
+
+	mtx     sync.Mutex
+	queue   []notification
+	running bool
+	// closed by drain once the queue is empty, nil before the first report, see wait
+	idle chan struct{}
+}
+
+type notification struct {
+	log       Logger
+	r         Report
+	notifiers []Notifier
+}
+
+// FromConfig returns nil if in configures no notifiers.
+func FromConfig(in config.JobNotify) (*Notifications, error) {
+	n := &Notifications{}
+	var err error
+	if n.onError, err = notifiersFromConfig(in.OnError); err != nil {
+		return nil, errors.Wrap(err, "on_error")
+	}
+	if n.onSuccess, err = notifiersFromConfig(in.OnSuccess); err != nil {
+		return nil, errors.Wrap(err, "on_success")
+	}
+	if len(n.onError) == 0 && len(n.onSuccess) == 0 {
+		return nil, nil
+	}
+	return n, nil
+}
+
+func notifiersFromConfig(in []config.NotifierEnum) ([]Notifier, error) {
+	l := make([]Notifier, len(in))
+	for i := range in {
+		var err error
+		switch v := in[i].Ret.(type) {
+		case *config.NotifierCommand:
+			l[i], err = CommandNotifierFromConfig(v)
+		case *config.NotifierWebhook:
+			l[i], err = WebhookNotifierFromConfig(v)
+		default:
+			err = errors.Errorf("unknown notifier type %T", v)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "notifier %d", i)
+		}
+	}
+	return l, nil
+}
+
+// Notify sends r to the on_success notifiers if the invocation succeeded,
+// and to the on_error notifiers if it failed.
+// Cancelled and skipped invocations are not reported.
+// Notify does not wait for the notifiers: they run in the background, one report after the other
+// and the notifiers of a report in order, their errors are logged.
+func (n *Notifications) Notify(log Logger, r Report) {
+	if n == nil {
+		return
+	}
+	var notifiers []Notifier
+	switch r.Outcome {
+	case history.Succeeded:
+		notifiers = n.onSuccess
+	case history.Failed:
+		notifiers = n.onError
+	}
+	if len(notifiers) == 0 {
+		return
+	}
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.queue = append(n.queue, notification{log, r, notifiers})
+	if !n.running {
+		n.running = true
+		n.idle = make(chan struct{})
+		go n.drain()
+	}
+}
+
+// drain sends the queued reports until the queue is empty.
+func (n *Notifications) drain() {
+	for {
+		n.mtx.Lock()
+		if len(n.queue) == 0 {
+			n.running = false
+			close(n.idle)
+			n.mtx.Unlock()
+			return
+		}
+		next := n.queue[0]
+		n.queue = n.queue[1:]
+		n.mtx.Unlock()
+
+		// the notifications must not be cancelled by the end of the invocation, each notifier is limited by its timeout
+		next.send(context.Background())
+	}
+}
+
+// wait returns once no reports are queued or being sent.
+func (n *Notifications) wait() {
+	n.mtx.Lock()
+	idle := n.idle
+	n.mtx.Unlock()
+	if idle != nil {
+		<-idle
+	}
+}
+
+func (next notification) send(ctx context.Context) {
+	body, err := json.Marshal(&next.r)
+	if err != nil {
+		next.log.WithError(err).Error("cannot encode notification report")
+		return
+	}
+	for _, notifier := range next.notifiers {
+		l := next.log.WithField("notifier", notifier.String())
+		if err := notifier.Notify(ctx, &next.r, body); err != nil {
+			l.WithError(err).Warn("notification failed")
+			continue
+		}
+		l.Debug("notification sent")
+	}
+}
+
+// CommandNotifier runs a command with the report on its standard input.
+type CommandNotifier struct {
+	path    string
+	timeout time.Duration
+}
+
+func CommandNotifierFromConfig(in *config.NotifierCommand) (*CommandNotifier, error) {
+	if in.Path == "" {
+		return nil, errors.New("command path must not be empty")
+	}
+	if in.Timeout <= 0 {
+		return nil, errors.New("timeout must be positive")
+	}
+	return &CommandNotifier{path: in.Path, timeout: in.Timeout}, nil
+}
+
+func (c *CommandNotifier) String() string { return c.path }
+
+func (c *CommandNotifier) Notify(ctx context.Context, r *Report, body []byte) error {
+	env := hooks.Env{
+		EnvJob:        r.Job,
+		EnvOutcome:    string(r.Outcome),
+		EnvInvocation: r.ID,
+	}
+	output, err := hooks.RunCommand(ctx, c.path, c.timeout, env, bytes.NewReader(body))
+	if err != nil {
+		return errors.Errorf("%s\noutput:\n%s", err, output)
+	}
+	return nil
+}
+
+// WebhookNotifier posts the report to a URL.
+type WebhookNotifier struct {
+	url     string
+	headers map[string]string
+	timeout time.Duration
+}
+
+func WebhookNotifierFromConfig(in *config.NotifierWebhook) (*WebhookNotifier, error) {
+	u, err := url.Parse(in.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse url")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("url must be http or https, got %q", in.URL)
+	}
+	if in.Timeout <= 0 {
+		return nil, errors.New("timeout must be positive")
+	}
+	return &WebhookNotifier{url: in.URL, headers: in.Headers, timeout: in.Timeout}, nil
+}
+
+// String does not include the URL's query or credentials, which often contain a token.
+func (w *WebhookNotifier) String() string {
+	u, err := url.Parse(w.url)
+	if err != nil {
+		return "webhook"
+	}
+	return u.Scheme + "://" + u.Host + u.Path
+}
+
+func (w *WebhookNotifier) Notify(ctx context.Context, r *Report, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return errors.Errorf("timeout of %s exceeded", w.timeout)
+		}
+		// the *url.Error includes the URL, whose query or credentials often contain a token
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return errors.Wrap(err, "cannot post report")
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return errors.Errorf("webhook returned %s: %s", res.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	return nil
+}
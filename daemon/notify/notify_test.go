@@ -0,0 +1,133 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/daemon/job/history"
+	"github.com/zrepl/zrepl/logger"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testReport(outcome history.Outcome) Report {
+	return Report{
+		Job: "backup",
+		Invocation: history.Invocation{
+			ID:              "inv1",
+			Outcome:         outcome,
+			BytesReplicated: 1024,
+		},
+	}
+}
+
+func TestCommandNotifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrepl-notify-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "notify.sh")
+	body := `#!/bin/sh
+echo "$ZREPL_JOB $ZREPL_OUTCOME $ZREPL_INVOCATION" > ` + out + `
+cat >> ` + out + `
+`
+	require.NoError(t, ioutil.WriteFile(script, []byte(body), 0700))
+
+	n := &Notifications{onError: []Notifier{&CommandNotifier{path: script, timeout: 5 * time.Second}}}
+	log := logger.NewTestLogger(t)
+
+	n.Notify(log, testReport(history.Succeeded))
+	n.wait()
+	_, err = os.Stat(out)
+	assert.True(t, os.IsNotExist(err), "on_error notifier must not run for a successful invocation")
+
+	n.Notify(log, testReport(history.Failed))
+	n.wait()
+	b, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	lines := string(b)
+	require.Contains(t, lines, "backup failed inv1\n")
+	var r Report
+	require.NoError(t, json.Unmarshal(b[len("backup failed inv1\n"):], &r))
+	assert.Equal(t, "backup", r.Job)
+	assert.Equal(t, int64(1024), r.BytesReplicated)
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var received []Report
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "POST", req.Method)
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		assert.Equal(t, "Bearer s3cret", req.Header.Get("Authorization"))
+		var r Report
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&r))
+		received = append(received, r)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	w := &WebhookNotifier{
+		url:     srv.URL + "/hook?token=x",
+		headers: map[string]string{"Authorization": "Bearer s3cret"},
+		timeout: 5 * time.Second,
+	}
+	assert.Equal(t, srv.URL+"/hook", w.String())
+
+	r := testReport(history.Succeeded)
+	body, err := json.Marshal(&r)
+	require.NoError(t, err)
+	require.NoError(t, w.Notify(context.Background(), &r, body))
+	require.Len(t, received, 1)
+	assert.Equal(t, history.Succeeded, received[0].Outcome)
+
+	status = http.StatusInternalServerError
+	assert.Error(t, w.Notify(context.Background(), &r, body))
+
+	// the error of an unreachable webhook must not leak the token in the URL
+	srv.Close()
+	err = w.Notify(context.Background(), &r, body)
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "token")
+}
+
+func TestNotifyDoesNotWait(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrepl-notify-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "notify.sh")
+	body := `#!/bin/sh
+sleep 1
+echo "$ZREPL_INVOCATION" >> ` + out + `
+`
+	require.NoError(t, ioutil.WriteFile(script, []byte(body), 0700))
+
+	n := &Notifications{onError: []Notifier{&CommandNotifier{path: script, timeout: 5 * time.Second}}}
+	log := logger.NewTestLogger(t)
+	begin := time.Now()
+	for _, id := range []string{"inv1", "inv2"} {
+		r := testReport(history.Failed)
+		r.ID = id
+		n.Notify(log, r)
+	}
+	assert.True(t, time.Since(begin) < time.Second, "Notify must not wait for the notifiers")
+
+	n.wait()
+	b, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "inv1\ninv2\n", string(b), "reports are sent in order")
+}
+
+func TestNilNotifications(t *testing.T) {
+	var n *Notifications
+	n.Notify(logger.NewTestLogger(t), testReport(history.Failed))
+}
@@ -5,6 +5,10 @@ import (
 	// FIXME: importing this package has the side-effect of poisoning the http.DefaultServeMux
 	// FIXME: with the /debug/pprof endpoints
 	"context"
+	"expvar"
+	"github.com/pkg/errors"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/job"
 	"net"
 	"net/http/pprof"
 )
@@ -52,9 +56,11 @@ outer:
 
 			s.listener, err = net.Listen("tcp", msg.HttpListenAddress)
 			if err != nil {
+				job.GetLogger(ctx).WithError(err).WithField("addr", msg.HttpListenAddress).Error("cannot listen for pprof requests")
 				s.listener = nil
 				continue
 			}
+			job.GetLogger(ctx).WithField("addr", msg.HttpListenAddress).Info("serving pprof and runtime metrics")
 
 			// FIXME: because net/http/pprof does not provide a mux,
 			mux := http.NewServeMux()
@@ -63,6 +69,7 @@ outer:
 			mux.Handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
 			mux.Handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
 			mux.Handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+			mux.Handle("/debug/vars", expvar.Handler())
 			go http.Serve(s.listener, mux)
 			continue
 		}
@@ -78,3 +85,29 @@ outer:
 func (s *pprofServer) Control(msg PprofServerControlMsg) {
 	s.cc <- msg
 }
+
+// PprofListenFromConfig returns the address of the pprof server configured in the global serve section,
+// or "" if it is not configured.
+// Addresses other than loopback addresses are rejected unless allow_non_local is set.
+func PprofListenFromConfig(in *config.GlobalServe) (string, error) {
+	if in.HTTPPprof == nil {
+		return "", nil
+	}
+	addr := in.HTTPPprof.Listen
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid global serve.http_pprof.listen address")
+	}
+	if !in.HTTPPprof.AllowNonLocal && !isLoopbackHost(host) {
+		return "", errors.Errorf("global serve.http_pprof.listen address %q is not a loopback address, set allow_non_local to expose pprof to the network", addr)
+	}
+	return addr, nil
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
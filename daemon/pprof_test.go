@@ -0,0 +1,33 @@
+package daemon
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/zrepl/zrepl/config"
+	"testing"
+)
+
+func TestPprofListenFromConfig(t *testing.T) {
+	listen := func(addr string, allowNonLocal bool) (string, error) {
+		return PprofListenFromConfig(&config.GlobalServe{
+			HTTPPprof: &config.GlobalHTTPPprof{Listen: addr, AllowNonLocal: allowNonLocal},
+		})
+	}
+
+	addr, err := PprofListenFromConfig(&config.GlobalServe{})
+	assert.NoError(t, err)
+	assert.Equal(t, "", addr)
+
+	for _, local := range []string{"127.0.0.1:6060", "[::1]:6060", "localhost:6060"} {
+		addr, err := listen(local, false)
+		assert.NoError(t, err, local)
+		assert.Equal(t, local, addr)
+	}
+	for _, nonLocal := range []string{":6060", "0.0.0.0:6060", "192.168.1.2:6060"} {
+		_, err := listen(nonLocal, false)
+		assert.Error(t, err, nonLocal)
+		_, err = listen(nonLocal, true)
+		assert.NoError(t, err, nonLocal)
+	}
+	_, err = listen("127.0.0.1", false)
+	assert.Error(t, err, "missing port")
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/zrepl/zrepl/config"
 	"github.com/zrepl/zrepl/daemon/job"
+	"github.com/zrepl/zrepl/daemon/logging"
 	"github.com/zrepl/zrepl/logger"
 	"github.com/zrepl/zrepl/zfs"
 	"net"
@@ -48,6 +49,9 @@ func (j *prometheusJob) Run(ctx context.Context) {
 	if err := zfs.PrometheusRegister(prometheus.DefaultRegisterer); err != nil {
 		panic(err)
 	}
+	if err := logging.PrometheusRegister(prometheus.DefaultRegisterer); err != nil {
+		panic(err)
+	}
 
 	log := job.GetLogger(ctx)
 
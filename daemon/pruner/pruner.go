@@ -8,9 +8,11 @@ import (
 	"github.com/zrepl/zrepl/config"
 	"github.com/zrepl/zrepl/logger"
 	"github.com/zrepl/zrepl/pruning"
+	"github.com/zrepl/zrepl/replication/errclass"
 	"github.com/zrepl/zrepl/replication/pdu"
 	"github.com/zrepl/zrepl/util/envconst"
 	"github.com/zrepl/zrepl/util/watchdog"
+	"github.com/zrepl/zrepl/zfs"
 	"github.com/problame/go-streamrpc"
 	"net"
 	"sort"
@@ -30,6 +32,13 @@ type Target interface {
 	DestroySnapshots(ctx context.Context, req *pdu.DestroySnapshotsReq) (*pdu.DestroySnapshotsRes, error)
 }
 
+// AsyncDestroyTarget is implemented by targets that can destroy snapshots in the background.
+// The pruner then polls for the result instead of blocking a single request for the duration of the destroys.
+type AsyncDestroyTarget interface {
+	DestroySnapshotsSubmit(ctx context.Context, req *pdu.DestroySnapshotsReq) (*pdu.DestroySnapshotsSubmitRes, error)
+	DestroySnapshotsPoll(ctx context.Context, req *pdu.DestroySnapshotsPollReq) (*pdu.DestroySnapshotsPollRes, error)
+}
+
 type Logger = logger.Logger
 
 type contextKey int
@@ -52,9 +61,24 @@ type args struct {
 	target                         Target
 	receiver                       History
 	rules                          []pruning.KeepRule
+	// nil if bookmarks are not pruned
+	bookmarkRules                  []pruning.KeepRule
 	retryWait                      time.Duration
 	considerSnapAtCursorReplicated bool
 	promPruneSecs prometheus.Observer
+	window                         *pruning.Window
+	destroyPollInterval            time.Duration
+	// the window is re-checked after each batch, <= 0 destroys all snapshots of a filesystem at once
+	destroyBatchSize               int
+	// nil means time.Now
+	now                            func() time.Time
+}
+
+func (a *args) timeNow() time.Time {
+	if a.now == nil {
+		return time.Now()
+	}
+	return a.now()
 }
 
 type Pruner struct {
@@ -70,16 +94,26 @@ type Pruner struct {
 	sleepUntil time.Time
 	err        error
 
+	// State Done, if destroys were postponed because the pruning window was closed
+	postponedUntil time.Time
+
 	// State Exec
 	execQueue *execQueue
+	// State Exec, while an asynchronous destroy is in progress
+	destroying *DestroyProgress
 }
 
 type PrunerFactory struct {
 	senderRules                    []pruning.KeepRule
 	receiverRules                  []pruning.KeepRule
+	senderBookmarkRules            []pruning.KeepRule
+	receiverBookmarkRules          []pruning.KeepRule
 	retryWait                      time.Duration
 	considerSnapAtCursorReplicated bool
 	promPruneSecs *prometheus.HistogramVec
+	window                         *pruning.Window
+	destroyPollInterval            time.Duration
+	destroyBatchSize               int
 }
 
 func checkContainsKeep1(rules []pruning.KeepRule) error {
@@ -106,6 +140,21 @@ func NewPrunerFactory(in config.PruningSenderReceiver, promPruneSecs *prometheus
 		return nil, errors.Wrap(err, "cannot build sender pruning rules")
 	}
 
+	keepRulesSenderBookmarks, err := pruning.RulesFromConfig(in.KeepSenderBookmarks)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot build sender bookmark pruning rules")
+	}
+
+	keepRulesReceiverBookmarks, err := pruning.RulesFromConfig(in.KeepReceiverBookmarks)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot build receiver bookmark pruning rules")
+	}
+
+	window, err := pruning.WindowFromConfig(in.Window)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot build pruning window")
+	}
+
 	considerSnapAtCursorReplicated := false
 	for _, r := range in.KeepSender {
 		knr, ok := r.Ret.(*config.PruneKeepNotReplicated)
@@ -117,9 +166,14 @@ func NewPrunerFactory(in config.PruningSenderReceiver, promPruneSecs *prometheus
 	f := &PrunerFactory{
 		senderRules: keepRulesSender,
 		receiverRules: keepRulesReceiver,
+		senderBookmarkRules: keepRulesSenderBookmarks,
+		receiverBookmarkRules: keepRulesReceiverBookmarks,
 		retryWait: envconst.Duration("ZREPL_PRUNER_RETRY_INTERVAL", 10 * time.Second),
 		considerSnapAtCursorReplicated: considerSnapAtCursorReplicated,
 		promPruneSecs: promPruneSecs,
+		window: window,
+		destroyPollInterval: envconst.Duration("ZREPL_PRUNER_DESTROY_POLL_INTERVAL", 2 * time.Second),
+		destroyBatchSize: envconst.Int("ZREPL_PRUNER_DESTROY_BATCH_SIZE", 100),
 	}
 	return f, nil
 }
@@ -131,9 +185,14 @@ func (f *PrunerFactory) BuildSenderPruner(ctx context.Context, target Target, re
 			target,
 			receiver,
 			f.senderRules,
+			f.senderBookmarkRules,
 			f.retryWait,
 			f.considerSnapAtCursorReplicated,
 			f.promPruneSecs.WithLabelValues("sender"),
+			f.window,
+			f.destroyPollInterval,
+			f.destroyBatchSize,
+			time.Now,
 		},
 		state: Plan,
 	}
@@ -147,9 +206,14 @@ func (f *PrunerFactory) BuildReceiverPruner(ctx context.Context, target Target,
 			target,
 			receiver,
 			f.receiverRules,
+			f.receiverBookmarkRules,
 			f.retryWait,
 			false, // senseless here anyways
 			f.promPruneSecs.WithLabelValues("receiver"),
+			f.window,
+			f.destroyPollInterval,
+			f.destroyBatchSize,
+			time.Now,
 		},
 		state: Plan,
 	}
@@ -217,13 +281,24 @@ func (p *Pruner) prune(args args) {
 type Report struct {
 	State string
 	SleepUntil time.Time
+	// destroys of Pending were postponed until the pruning window opens at PostponedUntil
+	PostponedUntil time.Time
 	Error string
 	Pending, Completed []FSReport
+	// set while the destroys of a filesystem are in progress on an asynchronous target
+	Destroying *DestroyProgress
+}
+
+type DestroyProgress struct {
+	Filesystem       string
+	Destroyed, Total int
 }
 
 type FSReport struct {
 	Filesystem string
 	SnapshotList, DestroyList []SnapshotReport
+	// empty if bookmarks are not pruned
+	BookmarkList, BookmarkDestroyList []SnapshotReport
 	ErrorCount int
 	LastError string
 }
@@ -232,6 +307,30 @@ type SnapshotReport struct {
 	Name string
 	Replicated bool
 	Date time.Time
+	// in a DestroyList: why the destroy was skipped, see skipReason, empty if it was not
+	Skipped string
+}
+
+const (
+	SkippedHeld       = "held"
+	SkippedClone      = "clone"
+	SkippedLastCommon = "last_common"
+)
+
+// skipReason returns SkippedHeld, SkippedClone or SkippedLastCommon if a destroy failed with code
+// because of a hold, a dependent clone or because the receiver protects the snapshot at the replication cursor,
+// which the pruner reports instead of failing the filesystem, and the empty string otherwise.
+func skipReason(code pdu.ErrorCode) string {
+	switch code {
+	case pdu.ErrorCode_Held:
+		return SkippedHeld
+	case pdu.ErrorCode_HasClones:
+		return SkippedClone
+	case pdu.ErrorCode_LastCommonSnapshot:
+		return SkippedLastCommon
+	default:
+		return ""
+	}
 }
 
 func (p *Pruner) Report() *Report {
@@ -248,10 +347,15 @@ func (p *Pruner) Report() *Report {
 			r.Error = p.err.Error()
 		}
 	}
+	r.PostponedUntil = p.postponedUntil
 
 	if p.execQueue != nil {
 		r.Pending, r.Completed = p.execQueue.Report()
 	}
+	if p.destroying != nil {
+		progress := *p.destroying
+		r.Destroying = &progress
+	}
 
 	return &r
 }
@@ -283,12 +387,19 @@ type fs struct {
 	// destroy list returned by pruning.PruneSnapshots(snaps)
 	// (type snapshot)
 	destroyList []pruning.Snapshot
+	// like snaps and destroyList, for bookmarks, empty if bookmarks are not pruned
+	// (type snapshot)
+	bookmarks, bookmarkDestroyList []pruning.Snapshot
 
 	mtx sync.RWMutex
 
 	// only during Exec state, also used by execQueue
 	execErrLast error
 	execErrCount int
+	// reasons the destroys of snapshots of destroyList were skipped, by relative name, see skipReason
+	skipped map[string]string
+	// guid of the version at the sender's replication cursor, protected by the receiver
+	cursorGuid uint64
 
 }
 
@@ -313,6 +424,17 @@ func (f *fs) Report() FSReport {
 	r.DestroyList = make([]SnapshotReport, len(f.destroyList))
 	for i, snap := range f.destroyList{
 		r.DestroyList[i] = snap.(snapshot).Report()
+		r.DestroyList[i].Skipped = f.skipped[snap.(snapshot).fsv.RelName()]
+	}
+
+	r.BookmarkList = make([]SnapshotReport, len(f.bookmarks))
+	for i, b := range f.bookmarks {
+		r.BookmarkList[i] = b.(snapshot).Report()
+	}
+
+	r.BookmarkDestroyList = make([]SnapshotReport, len(f.bookmarkDestroyList))
+	for i, b := range f.bookmarkDestroyList {
+		r.BookmarkDestroyList[i] = b.(snapshot).Report()
 	}
 
 	return r
@@ -348,17 +470,10 @@ type Error interface {
 var _ Error = net.Error(nil)
 var _ Error = streamrpc.Error(nil)
 
-func shouldRetry(e error) bool {
-	if neterr, ok := e.(net.Error); ok {
-		return neterr.Temporary()
-	}
-	return false
-}
-
 func onErr(u updater, e error) state {
 	return u(func(p *Pruner) {
 		p.err = e
-		if !shouldRetry(e) {
+		if !errclass.Temporary(e) {
 			p.state = ErrPerm
 			return
 		}
@@ -427,6 +542,12 @@ func statePlan(a *args, u updater) state {
 		}
 
 
+		pfs.cursorGuid = rc.GetGuid()
+
+		// The replication status is derived from the replication cursor, a bookmark on the sender that
+		// survives restarts of both daemons. There is no separate state to persist or to lose:
+		// if the cursor's snapshot cannot be found among the target's versions, none is considered replicated.
+		//
 		// scan from older to newer, all snapshots older than cursor are interpreted as replicated
 		sort.Slice(tfsvs, func(i, j int) bool {
 			return tfsvs[i].CreateTXG < tfsvs[j].CreateTXG
@@ -471,6 +592,15 @@ func statePlan(a *args, u updater) state {
 
 		// Apply prune rules
 		pfs.destroyList = pruning.PruneSnapshots(pfs.snaps, a.rules)
+
+		if len(a.bookmarkRules) > 0 {
+			pfs.bookmarks, err = prunableBookmarks(tfsvs, rc.GetGuid())
+			if err != nil {
+				l.WithError(err).Error("error with filesystem version")
+				return onErr(u, err)
+			}
+			pfs.bookmarkDestroyList = pruning.PruneSnapshots(pfs.bookmarks, a.bookmarkRules)
+		}
 		ka.MadeProgress()
 	}
 
@@ -484,8 +614,48 @@ func statePlan(a *args, u updater) state {
 	}).statefunc()
 }
 
+// prunableBookmarks returns the bookmarks among tfsvs, except for the replication cursor,
+// as snapshots for the pruning rules, with the date of the snapshot they were created from.
+// A bookmark counts as replicated if it is not younger than the version with cursorGUID.
+func prunableBookmarks(tfsvs []*pdu.FilesystemVersion, cursorGUID uint64) ([]pruning.Snapshot, error) {
+	var cursorTXG uint64
+	haveCursor := false
+	for _, v := range tfsvs {
+		// includes the cursor bookmark itself, which has the createtxg of its snapshot
+		if v.Guid == cursorGUID {
+			cursorTXG, haveCursor = v.CreateTXG, true
+		}
+	}
+	bookmarks := make([]pruning.Snapshot, 0)
+	for _, v := range tfsvs {
+		if v.Type != pdu.FilesystemVersion_Bookmark || v.Name == zfs.ReplicationCursorBookmarkName {
+			continue
+		}
+		creation, err := v.CreationAsTime()
+		if err != nil {
+			return nil, fmt.Errorf("%s has invalid creation date: %s", v.RelName(), err)
+		}
+		bookmarks = append(bookmarks, snapshot{
+			replicated: haveCursor && v.CreateTXG <= cursorTXG,
+			date:       creation,
+			fsv:        v,
+		})
+	}
+	return bookmarks, nil
+}
+
 func stateExec(a *args, u updater) state {
 
+	if now := a.timeNow(); !a.window.Contains(now) {
+		GetLogger(a.ctx).
+			WithField("window", a.window.String()).
+			Info("outside of pruning window, postponing destroys to the next run within the window")
+		return u(func(pruner *Pruner) {
+			pruner.postponedUntil = a.window.NextOpen(now)
+			pruner.state = Done
+		}).statefunc()
+	}
+
 	var pfs *fs
 	state := u(func(pruner *Pruner) {
 		pfs = pruner.execQueue.Pop()
@@ -502,60 +672,132 @@ func stateExec(a *args, u updater) state {
 		return state.statefunc()
 	}
 
-	destroyList := make([]*pdu.FilesystemVersion, len(pfs.destroyList))
-	for i := range destroyList {
-		destroyList[i] = pfs.destroyList[i].(snapshot).fsv
+	destroyList := make([]*pdu.FilesystemVersion, 0, len(pfs.destroyList)+len(pfs.bookmarkDestroyList))
+	for _, s := range pfs.destroyList {
+		destroyList = append(destroyList, s.(snapshot).fsv)
 		GetLogger(a.ctx).
 			WithField("fs", pfs.path).
-			WithField("destroy_snap", destroyList[i].Name).
+			WithField("destroy_snap", s.Name()).
 			Debug("policy destroys snapshot")
 	}
-	req := pdu.DestroySnapshotsReq{
-		Filesystem: pfs.path,
-		Snapshots:  destroyList,
+	for _, b := range pfs.bookmarkDestroyList {
+		destroyList = append(destroyList, b.(snapshot).fsv)
+		GetLogger(a.ctx).
+			WithField("fs", pfs.path).
+			WithField("destroy_bookmark", b.Name()).
+			Debug("policy destroys bookmark")
 	}
-	GetLogger(a.ctx).WithField("fs", pfs.path).Debug("destroying snapshots")
-	res, err := a.target.DestroySnapshots(a.ctx, &req)
-	if err != nil {
-		u(func(pruner *Pruner) {
-			pruner.execQueue.Put(pfs, err, false)
-		})
-		return onErr(u, err)
+	// destroy in batches so that a long destroy does not run past the end of the window
+	var (
+		results   []*pdu.DestroySnapshotRes
+		requested int
+		postponed time.Time
+	)
+	for {
+		if requested > 0 {
+			if now := a.timeNow(); !a.window.Contains(now) {
+				postponed = now
+				break
+			}
+		}
+		end := len(destroyList)
+		if a.destroyBatchSize > 0 && requested+a.destroyBatchSize < end {
+			end = requested + a.destroyBatchSize
+		}
+		req := pdu.DestroySnapshotsReq{
+			Filesystem: pfs.path,
+			Snapshots:  destroyList[requested:end],
+			CursorGuid: pfs.cursorGuid,
+		}
+		GetLogger(a.ctx).WithField("fs", pfs.path).Debug("destroying snapshots")
+		res, err := destroySnapshots(a, u, &req)
+		if err != nil {
+			u(func(pruner *Pruner) {
+				pruner.execQueue.Put(pfs, err, false)
+			})
+			return onErr(u, err)
+		}
+		results = append(results, res.Results...)
+		requested = end
+		if requested >= len(destroyList) {
+			break
+		}
 	}
 	// check if all snapshots were destroyed
 	destroyResults := make(map[string]*pdu.DestroySnapshotRes)
-	for _, fsres := range res.Results {
-		destroyResults[fsres.Snapshot.Name] = fsres
+	for _, fsres := range results {
+		destroyResults[fsres.Snapshot.RelName()] = fsres
 	}
-	err = nil
+	var err error
 	destroyFails := make([]*pdu.DestroySnapshotRes, 0)
-	for _, reqDestroy := range destroyList {
-		 res, ok := destroyResults[reqDestroy.Name]
+	skipped := make(map[string]string)
+	for _, reqDestroy := range destroyList[:requested] {
+		 res, ok := destroyResults[reqDestroy.RelName()]
 		 if !ok {
 		 	err = fmt.Errorf("missing destroy-result for %s", reqDestroy.RelName())
 		 	break
-		 } else if res.Error != "" {
+		 } else if res.Error == nil || res.Error.Code == pdu.ErrorCode_NotFound { // already destroyed is fine
+		 	continue
+		 } else if reason := skipReason(res.Error.Code); reason != "" {
+		 	// not an error of the filesystem, the snapshot is destroyed by the first pruning run after the hold or clone is gone,
+		 	// or after the replication cursor moved on
+		 	skipped[reqDestroy.RelName()] = reason
+		 	GetLogger(a.ctx).
+		 		WithField("fs", pfs.path).
+		 		WithField("snapshot", reqDestroy.RelName()).
+		 		WithField("reason", res.Error.Message).
+		 		Info("skipping destroy of snapshot")
+		 } else {
 		 	destroyFails = append(destroyFails, res)
 		 }
 	}
+	pfs.mtx.Lock()
+	pfs.skipped = skipped
+	pfs.mtx.Unlock()
 	if err == nil && len(destroyFails) > 0 {
 		names := make([]string, len(destroyFails))
 		pairs := make([]string, len(destroyFails))
-		allSame := true
-		lastMsg := destroyFails[0].Error
+		allSame, allBusy := true, true
+		lastMsg := destroyFails[0].Error.Message
 		for i := 0; i < len(destroyFails); i++{
-			allSame = allSame && destroyFails[i].Error == lastMsg
+			allSame = allSame && destroyFails[i].Error.Message == lastMsg
+			allBusy = allBusy && destroyFails[i].Error.Code == pdu.ErrorCode_Busy
 			relname := destroyFails[i].Snapshot.RelName()
 			names[i] = relname
-			pairs[i] = fmt.Sprintf("(%s: %s)", relname, destroyFails[i].Error)
+			pairs[i] = fmt.Sprintf("(%s: %s)", relname, destroyFails[i].Error.Message)
+		}
+		code := pdu.ErrorCode_Internal
+		if allBusy {
+			code = pdu.ErrorCode_Busy // retried after retryWait
 		}
 		if allSame {
-			err = fmt.Errorf("destroys failed %s: %s",
+			err = pdu.NewError(code, "destroys failed %s: %s",
 				strings.Join(names, ", "), lastMsg)
 		} else {
-			err = fmt.Errorf("destroys failed: %s", strings.Join(pairs, ", "))
+			err = pdu.NewError(code, "destroys failed: %s", strings.Join(pairs, ", "))
 		}
 	}
+	if err == nil && !postponed.IsZero() {
+		// only the destroys that were not requested yet remain for the next run within the window
+		pfs.mtx.Lock()
+		if requested < len(pfs.destroyList) {
+			pfs.destroyList = pfs.destroyList[requested:]
+		} else {
+			pfs.bookmarkDestroyList = pfs.bookmarkDestroyList[requested-len(pfs.destroyList):]
+			pfs.destroyList = nil
+		}
+		pfs.mtx.Unlock()
+		GetLogger(a.ctx).
+			WithField("fs", pfs.path).
+			WithField("window", a.window.String()).
+			Info("pruning window closed, postponing remaining destroys to the next run within the window")
+		return u(func(pruner *Pruner) {
+			pruner.execQueue.Put(pfs, nil, false)
+			pruner.Progress.MadeProgress()
+			pruner.postponedUntil = a.window.NextOpen(postponed)
+			pruner.state = Done
+		}).statefunc()
+	}
 	u(func(pruner *Pruner) {
 		pruner.execQueue.Put(pfs, err, err == nil)
 	})
@@ -569,6 +811,53 @@ func stateExec(a *args, u updater) state {
 	}).statefunc()
 }
 
+// destroySnapshots uses the asynchronous destroy of the target if available,
+// and reports its progress until it completes.
+func destroySnapshots(a *args, u updater, req *pdu.DestroySnapshotsReq) (*pdu.DestroySnapshotsRes, error) {
+	async, ok := a.target.(AsyncDestroyTarget)
+	if !ok {
+		return a.target.DestroySnapshots(a.ctx, req)
+	}
+	sres, err := async.DestroySnapshotsSubmit(a.ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer u(func(pruner *Pruner) {
+		pruner.destroying = nil
+	})
+	t := time.NewTicker(a.destroyPollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-a.ctx.Done():
+			return nil, a.ctx.Err()
+		case <-t.C:
+		}
+		pres, err := async.DestroySnapshotsPoll(a.ctx, &pdu.DestroySnapshotsPollReq{ID: sres.ID})
+		if err != nil {
+			return nil, err
+		}
+		u(func(pruner *Pruner) {
+			pruner.Progress.MadeProgress()
+			pruner.destroying = &DestroyProgress{
+				Filesystem: req.Filesystem,
+				Destroyed:  int(pres.Destroyed),
+				Total:      int(pres.Total),
+			}
+		})
+		if !pres.Done {
+			continue
+		}
+		if pres.Error != nil {
+			return nil, pres.Error
+		}
+		if pres.Result == nil {
+			return nil, errors.New("destroy operation completed without result")
+		}
+		return pres.Result, nil
+	}
+}
+
 func stateExecWait(a *args, u updater) state {
 	return doWait(Exec, a, u)
 }
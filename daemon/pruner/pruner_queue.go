@@ -1,6 +1,7 @@
 package pruner
 
 import (
+	"github.com/zrepl/zrepl/replication/errclass"
 	"sort"
 	"strings"
 	"sync"
@@ -61,7 +62,7 @@ func(q *execQueue) Put(fs *fs, err error, done bool) {
 	if err != nil {
 		fs.execErrCount++
 	}
-	if done || (err != nil && !shouldRetry(fs.execErrLast)) {
+	if done || (err != nil && !errclass.Temporary(fs.execErrLast)) {
 		fs.mtx.Unlock()
 		q.mtx.Lock()
 		q.completed = append(q.completed, fs)
@@ -4,9 +4,12 @@ import (
 	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/config"
 	"github.com/zrepl/zrepl/logger"
 	"github.com/zrepl/zrepl/pruning"
 	"github.com/zrepl/zrepl/replication/pdu"
+	"github.com/zrepl/zrepl/zfs"
 	"net"
 	"testing"
 	"time"
@@ -15,6 +18,8 @@ import (
 type mockFS struct {
 	path  string
 	snaps []string
+	// guids continue after those of snaps
+	bookmarks []string
 }
 
 func (m *mockFS) Filesystem() *pdu.Filesystem {
@@ -33,6 +38,15 @@ func (m *mockFS) FilesystemVersions() []*pdu.FilesystemVersion {
 			Guid: uint64(i),
 		}
 	}
+	for i, v := range m.bookmarks {
+		versions = append(versions, &pdu.FilesystemVersion{
+			Type:      pdu.FilesystemVersion_Bookmark,
+			Name:      v,
+			Creation:  pdu.FilesystemVersionCreation(time.Unix(0, 0)),
+			Guid:      uint64(len(m.snaps) + i),
+			CreateTXG: uint64(len(m.snaps) + i),
+		})
+	}
 	return versions
 }
 
@@ -42,6 +56,10 @@ type mockTarget struct {
 	listVersionsErrs   map[string][]error
 	listFilesystemsErr []error
 	destroyErrs        map[string][]error
+	// by fs@snap
+	destroyResultErrs map[string]*pdu.Error
+	// refuse to destroy the snapshot with the request's CursorGuid, like a receiver
+	protectCursor bool
 }
 
 func (t *mockTarget) ListFilesystems(ctx context.Context) ([]*pdu.Filesystem, error) {
@@ -83,8 +101,16 @@ func (t *mockTarget) DestroySnapshots(ctx context.Context, req *pdu.DestroySnaps
 	destroyed := t.destroyed[fs]
 	res := make([]*pdu.DestroySnapshotRes, len(snaps))
 	for i, s := range snaps {
+		res[i] = &pdu.DestroySnapshotRes{Snapshot: s}
+		if e, ok := t.destroyResultErrs[fs+"@"+s.Name]; ok {
+			res[i].Error = e
+			continue
+		}
+		if t.protectCursor && s.Guid == req.CursorGuid {
+			res[i].Error = pdu.NewError(pdu.ErrorCode_LastCommonSnapshot, "refusing to destroy %s@%s", fs, s.Name)
+			continue
+		}
 		destroyed = append(destroyed, s.Name)
-		res[i] = &pdu.DestroySnapshotRes{Error: "", Snapshot: s}
 	}
 	t.destroyed[fs] = destroyed
 	return &pdu.DestroySnapshotsRes{Results: res}, nil
@@ -106,6 +132,9 @@ func (r *mockHistory) ReplicationCursor(ctx context.Context, req *pdu.Replicatio
 		r.errs[fs] = r.errs[fs][1:]
 		return nil, e
 	}
+	if c, ok := r.cursors[fs]; ok {
+		return &pdu.ReplicationCursorRes{Result: &pdu.ReplicationCursorRes_Guid{Guid: c.guid}}, nil
+	}
 	return &pdu.ReplicationCursorRes{Result: &pdu.ReplicationCursorRes_Guid{Guid: 0}}, nil
 }
 
@@ -203,3 +232,374 @@ func TestPruner_Prune(t *testing.T) {
 	//assert.Equal(t, map[string][]error{}, target.listVersionsErrs, "retried")
 
 }
+
+func TestPruner_ReplicatedFromCursor(t *testing.T) {
+	target := &mockTarget{
+		destroyed: make(map[string][]string),
+		fss: []mockFS{
+			{path: "zroot/foo", snaps: []string{"a", "b", "c"}},
+			{path: "zroot/bar", snaps: []string{"d", "e"}},
+		},
+	}
+	history := &mockHistory{
+		cursors: map[string]*mockCursor{
+			"zroot/foo": {snapname: "b", guid: 1},
+			// the cursor's snapshot is gone from the target, e.g. after a restart with different snapshots,
+			// so no snapshot must be considered replicated
+			"zroot/bar": {snapname: "gone", guid: 42},
+		},
+	}
+
+	p := Pruner{
+		args: args{
+			ctx:       WithLogger(context.Background(), logger.NewTestLogger(t)),
+			target:    target,
+			receiver:  history,
+			rules:     []pruning.KeepRule{pruning.NewKeepNotReplicated()},
+			retryWait: 10 * time.Millisecond,
+		},
+		state: Plan,
+	}
+	p.Prune()
+
+	assert.Equal(t, []string{"a"}, target.destroyed["zroot/foo"])
+	assert.Empty(t, target.destroyed["zroot/bar"])
+}
+
+func TestPruner_PruneOutsideWindow(t *testing.T) {
+
+	target := &mockTarget{
+		destroyed: make(map[string][]string),
+		fss: []mockFS{
+			{
+				path:  "zroot/foo",
+				snaps: []string{"keep_a", "drop_b"},
+			},
+		},
+	}
+	history := &mockHistory{}
+
+	now := time.Now()
+	window, err := pruning.WindowFromConfig(&config.PruningWindow{
+		From: now.Add(1 * time.Hour).Format("15:04"),
+		To:   now.Add(2 * time.Hour).Format("15:04"),
+	})
+	require.NoError(t, err)
+
+	p := Pruner{
+		args: args{
+			ctx:       WithLogger(context.Background(), logger.NewTestLogger(t)),
+			target:    target,
+			receiver:  history,
+			rules:     []pruning.KeepRule{pruning.MustKeepRegex("^keep", false)},
+			retryWait: 10 * time.Millisecond,
+			window:    window,
+		},
+		state: Plan,
+	}
+	p.Prune()
+
+	assert.Equal(t, Done, p.State())
+	assert.Empty(t, target.destroyed)
+	rep := p.Report()
+	assert.True(t, rep.PostponedUntil.After(now))
+	require.Len(t, rep.Pending, 1)
+	assert.Equal(t, "zroot/foo", rep.Pending[0].Filesystem)
+}
+
+// clockTarget advances the clock of the pruner with each destroy request.
+type clockTarget struct {
+	*mockTarget
+	requests []int
+	now      time.Time
+	step     time.Duration
+}
+
+func (t *clockTarget) DestroySnapshots(ctx context.Context, req *pdu.DestroySnapshotsReq) (*pdu.DestroySnapshotsRes, error) {
+	t.requests = append(t.requests, len(req.Snapshots))
+	t.now = t.now.Add(t.step)
+	return t.mockTarget.DestroySnapshots(ctx, req)
+}
+
+func TestPruner_WindowClosesBetweenBatches(t *testing.T) {
+
+	now := time.Now()
+	window, err := pruning.WindowFromConfig(&config.PruningWindow{
+		From: now.Add(-1 * time.Hour).Format("15:04"),
+		To:   now.Add(1 * time.Hour).Format("15:04"),
+	})
+	require.NoError(t, err)
+
+	target := &clockTarget{
+		mockTarget: &mockTarget{
+			destroyed: make(map[string][]string),
+			fss: []mockFS{
+				{
+					path:  "zroot/foo",
+					snaps: []string{"keep_a", "drop_b", "drop_c", "drop_d"},
+				},
+			},
+		},
+		now:  now,
+		step: 2 * time.Hour,
+	}
+
+	p := Pruner{
+		args: args{
+			ctx:              WithLogger(context.Background(), logger.NewTestLogger(t)),
+			target:           target,
+			receiver:         &mockHistory{},
+			rules:            []pruning.KeepRule{pruning.MustKeepRegex("^keep", false)},
+			retryWait:        10 * time.Millisecond,
+			window:           window,
+			destroyBatchSize: 2,
+			now:              func() time.Time { return target.now },
+		},
+		state: Plan,
+	}
+	p.Prune()
+
+	assert.Equal(t, Done, p.State())
+	// the second batch is not requested after the window closed
+	assert.Equal(t, []int{2}, target.requests)
+	assert.Len(t, target.destroyed["zroot/foo"], 2)
+	rep := p.Report()
+	assert.True(t, rep.PostponedUntil.After(now))
+	require.Len(t, rep.Pending, 1)
+	assert.Empty(t, rep.Completed)
+	require.Len(t, rep.Pending[0].DestroyList, 1)
+	assert.NotContains(t, target.destroyed["zroot/foo"], rep.Pending[0].DestroyList[0].Name)
+}
+
+// mockAsyncTarget completes each destroy operation on the second poll.
+type mockAsyncTarget struct {
+	*mockTarget
+	polls   map[uint64]int
+	results map[uint64]*pdu.DestroySnapshotsRes
+}
+
+func (t *mockAsyncTarget) DestroySnapshotsSubmit(ctx context.Context, req *pdu.DestroySnapshotsReq) (*pdu.DestroySnapshotsSubmitRes, error) {
+	res, err := t.mockTarget.DestroySnapshots(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	id := uint64(len(t.results) + 1)
+	t.results[id] = res
+	return &pdu.DestroySnapshotsSubmitRes{ID: id}, nil
+}
+
+func (t *mockAsyncTarget) DestroySnapshotsPoll(ctx context.Context, req *pdu.DestroySnapshotsPollReq) (*pdu.DestroySnapshotsPollRes, error) {
+	res, ok := t.results[req.ID]
+	if !ok {
+		return nil, fmt.Errorf("unknown destroy operation %d", req.ID)
+	}
+	t.polls[req.ID]++
+	total := uint32(len(res.Results))
+	if t.polls[req.ID] < 2 {
+		return &pdu.DestroySnapshotsPollRes{Destroyed: total / 2, Total: total}, nil
+	}
+	return &pdu.DestroySnapshotsPollRes{Done: true, Destroyed: total, Total: total, Result: res}, nil
+}
+
+func TestPruner_AsyncDestroy(t *testing.T) {
+
+	target := &mockAsyncTarget{
+		mockTarget: &mockTarget{
+			destroyed: make(map[string][]string),
+			fss: []mockFS{
+				{
+					path:  "zroot/foo",
+					snaps: []string{"keep_a", "drop_b", "drop_c"},
+				},
+			},
+		},
+		polls:   make(map[uint64]int),
+		results: make(map[uint64]*pdu.DestroySnapshotsRes),
+	}
+
+	p := Pruner{
+		args: args{
+			ctx:                 WithLogger(context.Background(), logger.NewTestLogger(t)),
+			target:              target,
+			receiver:            &mockHistory{},
+			rules:               []pruning.KeepRule{pruning.MustKeepRegex("^keep", false)},
+			retryWait:           10 * time.Millisecond,
+			destroyPollInterval: time.Millisecond,
+		},
+		state: Plan,
+	}
+	p.Prune()
+
+	assert.Equal(t, Done, p.State())
+	require.Len(t, target.destroyed, 1)
+	assert.ElementsMatch(t, []string{"drop_b", "drop_c"}, target.destroyed["zroot/foo"])
+	assert.Equal(t, map[uint64]int{1: 2}, target.polls)
+	assert.Nil(t, p.Report().Destroying)
+}
+
+func TestPruner_SkipHeldAndCloned(t *testing.T) {
+
+	target := &mockTarget{
+		destroyed: make(map[string][]string),
+		fss: []mockFS{
+			{
+				path:  "zroot/foo",
+				snaps: []string{"keep_a", "drop_b", "drop_c", "drop_d"},
+			},
+		},
+		destroyResultErrs: map[string]*pdu.Error{
+			"zroot/foo@drop_b": pdu.NewError(pdu.ErrorCode_Held, "snapshot zroot/foo@drop_b has holds: backup"),
+			"zroot/foo@drop_c": pdu.NewError(pdu.ErrorCode_HasClones, "snapshot has dependent clones"),
+		},
+	}
+
+	p := Pruner{
+		args: args{
+			ctx:       WithLogger(context.Background(), logger.NewTestLogger(t)),
+			target:    target,
+			receiver:  &mockHistory{},
+			rules:     []pruning.KeepRule{pruning.MustKeepRegex("^keep", false)},
+			retryWait: 10 * time.Millisecond,
+		},
+		state: Plan,
+	}
+	p.Prune()
+
+	assert.Equal(t, Done, p.State())
+	assert.Equal(t, []string{"drop_d"}, target.destroyed["zroot/foo"])
+	report := p.Report()
+	require.Len(t, report.Completed, 1)
+	fs := report.Completed[0]
+	assert.Empty(t, fs.LastError)
+	skipped := make(map[string]string)
+	for _, s := range fs.DestroyList {
+		skipped[s.Name] = s.Skipped
+	}
+	assert.Equal(t, map[string]string{"drop_b": SkippedHeld, "drop_c": SkippedClone, "drop_d": ""}, skipped)
+}
+
+func TestPruner_SkipLastCommonSnapshot(t *testing.T) {
+
+	target := &mockTarget{
+		destroyed: make(map[string][]string),
+		fss: []mockFS{
+			{
+				path: "zroot/foo",
+				// the cursor is at drop_b, which is not the most recent snapshot
+				snaps: []string{"keep_a", "drop_b", "drop_c"},
+			},
+			{
+				path:  "zroot/bar",
+				snaps: []string{"keep_a", "drop_b"},
+			},
+		},
+		protectCursor: true,
+	}
+	history := &mockHistory{
+		cursors: map[string]*mockCursor{
+			"zroot/foo": {"drop_b", 1},
+			"zroot/bar": {"keep_a", 0},
+		},
+	}
+
+	p := Pruner{
+		args: args{
+			ctx:       WithLogger(context.Background(), logger.NewTestLogger(t)),
+			target:    target,
+			receiver:  history,
+			rules:     []pruning.KeepRule{pruning.MustKeepRegex("^keep", false)},
+			retryWait: 10 * time.Millisecond,
+		},
+		state: Plan,
+	}
+	p.Prune()
+
+	// a refused destroy is skipped for its filesystem only, instead of failing the pruner
+	assert.Equal(t, Done, p.State())
+	assert.Equal(t, []string{"drop_c"}, target.destroyed["zroot/foo"])
+	assert.Equal(t, []string{"drop_b"}, target.destroyed["zroot/bar"])
+	report := p.Report()
+	require.Len(t, report.Completed, 2)
+	for _, fs := range report.Completed {
+		assert.Empty(t, fs.LastError)
+		for _, s := range fs.DestroyList {
+			if fs.Filesystem == "zroot/foo" && s.Name == "drop_b" {
+				assert.Equal(t, SkippedLastCommon, s.Skipped)
+			} else {
+				assert.Empty(t, s.Skipped, "%s@%s", fs.Filesystem, s.Name)
+			}
+		}
+	}
+}
+
+func TestPrunableBookmarks(t *testing.T) {
+	v := func(typ pdu.FilesystemVersion_VersionType, name string, guid, txg uint64) *pdu.FilesystemVersion {
+		return &pdu.FilesystemVersion{
+			Type:      typ,
+			Name:      name,
+			Guid:      guid,
+			CreateTXG: txg,
+		}
+	}
+	tfsvs := []*pdu.FilesystemVersion{
+		v(pdu.FilesystemVersion_Bookmark, "a", 1, 10),
+		v(pdu.FilesystemVersion_Bookmark, "b", 2, 20),
+		v(pdu.FilesystemVersion_Snapshot, "c", 3, 30),
+		v(pdu.FilesystemVersion_Bookmark, zfs.ReplicationCursorBookmarkName, 2, 20),
+		v(pdu.FilesystemVersion_Bookmark, "c", 3, 30),
+	}
+	for _, fsv := range tfsvs {
+		fsv.Creation = pdu.FilesystemVersionCreation(time.Unix(int64(fsv.CreateTXG), 0))
+	}
+
+	bs, err := prunableBookmarks(tfsvs, 2)
+	require.NoError(t, err)
+	var names []string
+	var replicated []bool
+	for _, b := range bs {
+		names = append(names, b.Name())
+		replicated = append(replicated, b.Replicated())
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, names, "the replication cursor is never pruned")
+	assert.Equal(t, []bool{true, true, false}, replicated)
+
+	// the cursor's version is unknown
+	bs, err = prunableBookmarks(tfsvs, 42)
+	require.NoError(t, err)
+	for _, b := range bs {
+		assert.False(t, b.Replicated())
+	}
+}
+
+func TestPruner_Bookmarks(t *testing.T) {
+	target := &mockTarget{
+		destroyed: make(map[string][]string),
+		fss: []mockFS{
+			{path: "zroot/foo", snaps: []string{"a", "b"}, bookmarks: []string{"x", "y", "z"}},
+		},
+	}
+	history := &mockHistory{
+		cursors: map[string]*mockCursor{
+			"zroot/foo": {snapname: "b", guid: 1},
+		},
+	}
+
+	p := Pruner{
+		args: args{
+			ctx:           WithLogger(context.Background(), logger.NewTestLogger(t)),
+			target:        target,
+			receiver:      history,
+			rules:         []pruning.KeepRule{pruning.MustKeepRegex("", false)},
+			bookmarkRules: []pruning.KeepRule{pruning.MustKeepRegex("^z$", false)},
+			retryWait:     10 * time.Millisecond,
+		},
+		state: Plan,
+	}
+	p.Prune()
+
+	assert.Equal(t, []string{"x", "y"}, target.destroyed["zroot/foo"])
+	r := p.Report()
+	require.Len(t, r.Completed, 1)
+	assert.Len(t, r.Completed[0].BookmarkList, 3)
+	assert.Len(t, r.Completed[0].BookmarkDestroyList, 2)
+}
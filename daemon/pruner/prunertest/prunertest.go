@@ -0,0 +1,144 @@
+// Package prunertest provides in-memory implementations of the pruner.Target and pruner.History interfaces
+// and a simulator that runs the pruner on a synthetic snapshot timeline.
+package prunertest
+
+import (
+	"context"
+	"fmt"
+	"github.com/zrepl/zrepl/daemon/pruner"
+	"github.com/zrepl/zrepl/replication/pdu"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Target is an in-memory pruner.Target.
+// Its filesystems exist from their first version on, and DestroySnapshots removes versions from them.
+// The zero value is an empty target. It is safe for concurrent use.
+type Target struct {
+	mtx sync.Mutex
+	fss map[string][]*pdu.FilesystemVersion
+	// guid and createtxg of the last added version
+	txg uint64
+}
+
+var _ pruner.Target = (*Target)(nil)
+
+// AddSnapshot adds snapshot fs@name with the given creation date and returns it.
+// Its guid and createtxg are greater than those of all versions added before.
+func (t *Target) AddSnapshot(fs, name string, creation time.Time) *pdu.FilesystemVersion {
+	return t.add(fs, pdu.FilesystemVersion_Snapshot, name, creation)
+}
+
+// AddBookmark adds bookmark fs#name, see AddSnapshot.
+func (t *Target) AddBookmark(fs, name string, creation time.Time) *pdu.FilesystemVersion {
+	return t.add(fs, pdu.FilesystemVersion_Bookmark, name, creation)
+}
+
+func (t *Target) add(fs string, typ pdu.FilesystemVersion_VersionType, name string, creation time.Time) *pdu.FilesystemVersion {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.fss == nil {
+		t.fss = make(map[string][]*pdu.FilesystemVersion)
+	}
+	t.txg++
+	v := &pdu.FilesystemVersion{
+		Type:      typ,
+		Name:      name,
+		Guid:      t.txg,
+		CreateTXG: t.txg,
+		Creation:  pdu.FilesystemVersionCreation(creation),
+	}
+	t.fss[fs] = append(t.fss[fs], v)
+	return v
+}
+
+// Versions returns the versions of fs in the order they were added.
+func (t *Target) Versions(fs string) []*pdu.FilesystemVersion {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return append([]*pdu.FilesystemVersion(nil), t.fss[fs]...)
+}
+
+func (t *Target) ListFilesystems(ctx context.Context) ([]*pdu.Filesystem, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	fss := make([]*pdu.Filesystem, 0, len(t.fss))
+	for fs := range t.fss {
+		fss = append(fss, &pdu.Filesystem{Path: fs})
+	}
+	sort.Slice(fss, func(i, j int) bool { return fss[i].Path < fss[j].Path })
+	return fss, nil
+}
+
+func (t *Target) ListFilesystemVersions(ctx context.Context, fs string) ([]*pdu.FilesystemVersion, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	vs, ok := t.fss[fs]
+	if !ok {
+		return nil, pdu.NewError(pdu.ErrorCode_NotFound, "filesystem %s does not exist", fs)
+	}
+	return append([]*pdu.FilesystemVersion(nil), vs...), nil
+}
+
+// DestroySnapshots destroys the versions of req that exist with the same guid,
+// the results of the others have an error with code NotFound.
+func (t *Target) DestroySnapshots(ctx context.Context, req *pdu.DestroySnapshotsReq) (*pdu.DestroySnapshotsRes, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	vs, ok := t.fss[req.Filesystem]
+	if !ok {
+		return nil, pdu.NewError(pdu.ErrorCode_NotFound, "filesystem %s does not exist", req.Filesystem)
+	}
+	res := make([]*pdu.DestroySnapshotRes, len(req.Snapshots))
+	for i, s := range req.Snapshots {
+		res[i] = &pdu.DestroySnapshotRes{Snapshot: s}
+		found := false
+		for k := range vs {
+			if vs[k].Type == s.Type && vs[k].Guid == s.Guid {
+				vs = append(vs[:k], vs[k+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			res[i].Error = pdu.NewError(pdu.ErrorCode_NotFound, "%s%s%s does not exist",
+				req.Filesystem, s.Type.ZFSVersionType().DelimiterChar(), s.Name)
+		}
+	}
+	t.fss[req.Filesystem] = vs
+	return &pdu.DestroySnapshotsRes{Results: res}, nil
+}
+
+// History is an in-memory pruner.History with the replication cursors set by SetCursor.
+// Filesystems without a cursor have none, the pruner skips them.
+// The zero value has no cursors. It is safe for concurrent use.
+type History struct {
+	mtx     sync.Mutex
+	cursors map[string]uint64
+}
+
+var _ pruner.History = (*History)(nil)
+
+// SetCursor moves the replication cursor of fs to the version with guid.
+func (h *History) SetCursor(fs string, guid uint64) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if h.cursors == nil {
+		h.cursors = make(map[string]uint64)
+	}
+	h.cursors[fs] = guid
+}
+
+func (h *History) ReplicationCursor(ctx context.Context, req *pdu.ReplicationCursorReq) (*pdu.ReplicationCursorRes, error) {
+	if _, ok := req.Op.(*pdu.ReplicationCursorReq_Get); !ok {
+		return nil, fmt.Errorf("replication cursor operation %T is not supported", req.Op)
+	}
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	guid, ok := h.cursors[req.Filesystem]
+	if !ok {
+		return &pdu.ReplicationCursorRes{Result: &pdu.ReplicationCursorRes_Notexist{Notexist: true}}, nil
+	}
+	return &pdu.ReplicationCursorRes{Result: &pdu.ReplicationCursorRes_Guid{Guid: guid}}, nil
+}
@@ -0,0 +1,109 @@
+package prunertest
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/pruner"
+	"github.com/zrepl/zrepl/replication/pdu"
+	"testing"
+	"time"
+)
+
+func TestTargetDestroySnapshots(t *testing.T) {
+	ctx := context.Background()
+	target := &Target{}
+	a := target.AddSnapshot("pool/a", "a", time.Unix(0, 0))
+	b := target.AddSnapshot("pool/a", "b", time.Unix(1, 0))
+	assert.True(t, b.Guid > a.Guid)
+	assert.True(t, b.CreateTXG > a.CreateTXG)
+
+	unknown := &pdu.FilesystemVersion{Type: pdu.FilesystemVersion_Snapshot, Name: "c", Guid: 42}
+	res, err := target.DestroySnapshots(ctx, &pdu.DestroySnapshotsReq{
+		Filesystem: "pool/a",
+		Snapshots:  []*pdu.FilesystemVersion{a, unknown},
+	})
+	require.NoError(t, err)
+	require.Len(t, res.Results, 2)
+	assert.Nil(t, res.Results[0].Error)
+	assert.Equal(t, pdu.ErrorCode_NotFound, res.Results[1].Error.Code)
+
+	vs, err := target.ListFilesystemVersions(ctx, "pool/a")
+	require.NoError(t, err)
+	assert.Equal(t, []*pdu.FilesystemVersion{b}, vs)
+
+	_, err = target.ListFilesystemVersions(ctx, "pool/b")
+	assert.Equal(t, pdu.ErrorCode_NotFound, pdu.Code(err))
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	history := &History{}
+	get := &pdu.ReplicationCursorReq_Get{Get: &pdu.ReplicationCursorReq_GetOp{}}
+	res, err := history.ReplicationCursor(ctx, &pdu.ReplicationCursorReq{Filesystem: "pool/a", Op: get})
+	require.NoError(t, err)
+	assert.True(t, res.GetNotexist())
+
+	history.SetCursor("pool/a", 23)
+	res, err = history.ReplicationCursor(ctx, &pdu.ReplicationCursorReq{Filesystem: "pool/a", Op: get})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(23), res.GetGuid())
+}
+
+func simulate(t *testing.T, keep []config.PruningEnum, lag int) []Step {
+	t.Helper()
+	factory, err := pruner.NewPrunerFactory(config.PruningSenderReceiver{KeepSender: keep},
+		prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "test"}, []string{"prune_side"}))
+	require.NoError(t, err)
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	sim := &Simulation{
+		Filesystem:     "pool/a",
+		Prefix:         "zrepl_",
+		Start:          start,
+		End:            start.Add(9 * time.Hour),
+		Interval:       time.Hour,
+		ReplicationLag: lag,
+		Build:          factory.BuildSenderPruner,
+	}
+	var steps []Step
+	require.NoError(t, sim.Run(context.Background(), func(s Step) { steps = append(steps, s) }))
+	require.Len(t, steps, 10)
+	return steps
+}
+
+func names(vs []*pdu.FilesystemVersion) []string {
+	ns := make([]string, len(vs))
+	for i, v := range vs {
+		ns[i] = v.Name
+	}
+	return ns
+}
+
+func TestSimulationLastN(t *testing.T) {
+	steps := simulate(t, []config.PruningEnum{
+		{Ret: &config.PruneKeepLastN{Type: "last_n", Count: 3}},
+	}, 0)
+	for i, s := range steps {
+		if i < 3 {
+			assert.Empty(t, s.Destroyed, "step %d", i)
+		} else {
+			assert.Len(t, s.Destroyed, 1, "step %d", i)
+		}
+	}
+	assert.Equal(t, []string{"zrepl_20190101_070000_000", "zrepl_20190101_080000_000", "zrepl_20190101_090000_000"},
+		names(steps[9].Snapshots))
+}
+
+func TestSimulationReplicationLag(t *testing.T) {
+	steps := simulate(t, []config.PruningEnum{
+		{Ret: &config.PruneKeepNotReplicated{Type: "not_replicated", KeepSnapshotAtCursor: true}},
+		{Ret: &config.PruneKeepLastN{Type: "last_n", Count: 1}},
+	}, 2)
+	// nothing is replicated before the third snapshot
+	assert.Len(t, steps[1].Snapshots, 2)
+	// the snapshot at the cursor and the two unreplicated ones
+	assert.Equal(t, []string{"zrepl_20190101_070000_000", "zrepl_20190101_080000_000", "zrepl_20190101_090000_000"},
+		names(steps[9].Snapshots))
+}
@@ -0,0 +1,86 @@
+package prunertest
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/zrepl/zrepl/daemon/pruner"
+	"github.com/zrepl/zrepl/replication/pdu"
+	"github.com/zrepl/zrepl/util/snapname"
+	"time"
+)
+
+// Simulation takes a snapshot of Filesystem every Interval from Start until End and runs a pruner after each snapshot,
+// like a job that replicates and prunes right after snapshotting.
+// Creation dates are simulated, the pruning rules do not depend on the current time.
+type Simulation struct {
+	Filesystem string
+	// the snapshot names are Prefix followed by the creation date in UTC, like those of the snapper
+	Prefix     string
+	Start, End time.Time
+	Interval   time.Duration
+	// The number of most recent snapshots that are not replicated when the pruner runs,
+	// e.g. because replication runs behind. The pruner skips the filesystem while none is replicated.
+	ReplicationLag int
+	// Build builds the pruner of a run, e.g. with pruner.PrunerFactory.BuildSenderPruner.
+	Build func(ctx context.Context, target pruner.Target, history pruner.History) *pruner.Pruner
+}
+
+// Step is the outcome of a pruner run of a Simulation.
+type Step struct {
+	Time time.Time
+	// the snapshot names destroyed by the run
+	Destroyed []string
+	// the snapshots left after the run, from oldest to newest
+	Snapshots []*pdu.FilesystemVersion
+}
+
+// Run runs the simulation and calls step after each pruner run.
+// It fails if a pruner run fails, which does not happen with the in-memory Target and History.
+func (s *Simulation) Run(ctx context.Context, step func(Step)) error {
+	if s.Interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+	if s.End.Before(s.Start) {
+		return errors.New("end must not be before start")
+	}
+	target, history := &Target{}, &History{}
+	for now := s.Start; !now.After(s.End); now = now.Add(s.Interval) {
+		target.AddSnapshot(s.Filesystem, s.Prefix+now.UTC().Format(snapname.DefaultLayout), now)
+		before := target.Versions(s.Filesystem)
+		if i := len(before) - 1 - s.ReplicationLag; i >= 0 {
+			history.SetCursor(s.Filesystem, before[i].Guid)
+		} else {
+			step(Step{Time: now, Snapshots: before})
+			continue
+		}
+
+		p := s.Build(ctx, target, history)
+		p.Prune()
+		if p.State() != pruner.Done {
+			err := p.Error()
+			if err == nil {
+				err = errors.Errorf("ended in state %s", p.State())
+			}
+			return errors.Wrapf(err, "pruner run at %s failed", now)
+		}
+		for _, fs := range p.Report().Completed {
+			if fs.LastError != "" {
+				return errors.Errorf("pruner run at %s failed: %s: %s", now, fs.Filesystem, fs.LastError)
+			}
+		}
+
+		after := target.Versions(s.Filesystem)
+		kept := make(map[uint64]bool, len(after))
+		for _, v := range after {
+			kept[v.Guid] = true
+		}
+		var destroyed []string
+		for _, v := range before {
+			if !kept[v.Guid] {
+				destroyed = append(destroyed, v.Name)
+			}
+		}
+		step(Step{Time: now, Destroyed: destroyed, Snapshots: after})
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package snapper
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A schedule determines when the snapper takes snapshots.
+type schedule interface {
+	// Next returns the earliest time strictly after t at which snapshots should be taken.
+	Next(t time.Time) time.Time
+	String() string
+}
+
+type intervalSchedule struct {
+	interval time.Duration
+	// if true, snapshots are taken at multiples of interval since the zero time.Time (UTC)
+	// instead of interval after the previous snapshot
+	align bool
+}
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	if s.align {
+		return t.Truncate(s.interval).Add(s.interval)
+	}
+	return t.Add(s.interval)
+}
+
+func (s intervalSchedule) String() string {
+	if s.align {
+		return fmt.Sprintf("every %s (aligned)", s.interval)
+	}
+	return fmt.Sprintf("every %s", s.interval)
+}
+
+// cronSchedule implements the five-field cron syntax
+//
+//	minute hour day-of-month month day-of-week
+//
+// with support for *, lists (1,2), ranges (1-5) and steps (*/10, 1-30/5).
+// Day-of-week 0 and 7 are both Sunday.
+// As in traditional cron, if both day-of-month and day-of-week are restricted,
+// a day matches if it matches either field.
+// Times are evaluated in the location of the time passed to Next.
+type cronSchedule struct {
+	spec                         string
+	minute, hour, dom, month     uint64 // bitsets
+	dow                          uint64
+	domRestricted, dowRestricted bool
+}
+
+var _ schedule = &cronSchedule{}
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = [5]cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day-of-month", 1, 31},
+	{"month", 1, 12},
+	{"day-of-week", 0, 7},
+}
+
+func parseCronSchedule(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, errors.Errorf("cron expression %q must have %d fields, has %d", spec, len(cronFields), len(fields))
+	}
+	var sets [5]uint64
+	for i, f := range fields {
+		set, err := parseCronField(f, cronFields[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "cron expression %q: %s", spec, cronFields[i].name)
+		}
+		sets[i] = set
+	}
+	s := &cronSchedule{
+		spec:          spec,
+		minute:        sets[0],
+		hour:          sets[1],
+		dom:           sets[2],
+		month:         sets[3],
+		dow:           sets[4],
+		domRestricted: fields[2] != "*",
+		dowRestricted: fields[4] != "*",
+	}
+	// normalize Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 << 0
+	}
+	return s, nil
+}
+
+func parseCronField(f string, field cronField) (set uint64, err error) {
+	for _, item := range strings.Split(f, ",") {
+		rangeStr, step := item, 1
+		if idx := strings.Index(item, "/"); idx != -1 {
+			rangeStr = item[:idx]
+			step, err = strconv.Atoi(item[idx+1:])
+			if err != nil || step <= 0 {
+				return 0, errors.Errorf("invalid step in %q", item)
+			}
+		}
+		var lo, hi int
+		switch {
+		case rangeStr == "*":
+			lo, hi = field.min, field.max
+		case strings.Contains(rangeStr, "-"):
+			bounds := strings.SplitN(rangeStr, "-", 2)
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.Errorf("invalid range %q", item)
+			}
+			if hi, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, errors.Errorf("invalid range %q", item)
+			}
+		default:
+			if lo, err = strconv.Atoi(rangeStr); err != nil {
+				return 0, errors.Errorf("invalid value %q", item)
+			}
+			hi = lo
+			if strings.Contains(item, "/") {
+				hi = field.max
+			}
+		}
+		if lo < field.min || hi > field.max || lo > hi {
+			return 0, errors.Errorf("%q out of range [%d,%d]", item, field.min, field.max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func (s *cronSchedule) String() string { return fmt.Sprintf("cron %q", s.spec) }
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domRestricted && s.dowRestricted {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// an expression like "0 0 30 2 *" never matches, give up after five years
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// missedRuns returns the scheduled times of s from first up to and including now, but at most the max most recent ones,
+// and the first scheduled time after now.
+// first must be a scheduled time of s, e.g. the result of Next.
+func missedRuns(s schedule, first, now time.Time, max int) (missed []time.Time, next time.Time) {
+	t := first
+	if is, ok := s.(intervalSchedule); ok && !is.align {
+		// skip ahead instead of iterating over a long downtime
+		if n := int64(now.Sub(first) / is.interval); n >= int64(max) {
+			t = first.Add(time.Duration(n-int64(max)+1) * is.interval)
+		}
+	}
+	for !t.IsZero() && !t.After(now) {
+		missed = append(missed, t)
+		if len(missed) > max {
+			missed = missed[1:]
+		}
+		t = s.Next(t)
+	}
+	return missed, t
+}
@@ -0,0 +1,102 @@
+package snapper
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestIntervalSchedule(t *testing.T) {
+	at := time.Date(2018, 10, 1, 13, 37, 12, 0, time.UTC)
+
+	s := intervalSchedule{interval: 15 * time.Minute}
+	assert.Equal(t, at.Add(15*time.Minute), s.Next(at))
+
+	s.align = true
+	assert.Equal(t, time.Date(2018, 10, 1, 13, 45, 0, 0, time.UTC), s.Next(at))
+	assert.Equal(t, time.Date(2018, 10, 1, 14, 0, 0, 0, time.UTC), s.Next(time.Date(2018, 10, 1, 13, 45, 0, 0, time.UTC)))
+}
+
+func TestParseCronScheduleInvalid(t *testing.T) {
+	invalid := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"1-a * * * *",
+	}
+	for _, spec := range invalid {
+		_, err := parseCronSchedule(spec)
+		assert.Error(t, err, "spec %q", spec)
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	date := func(y int, m time.Month, d, h, min int) time.Time {
+		return time.Date(y, m, d, h, min, 0, 0, time.UTC)
+	}
+
+	tcs := []struct {
+		spec     string
+		from     time.Time
+		expected time.Time
+	}{
+		{"0 2 * * *", date(2018, 10, 1, 1, 59), date(2018, 10, 1, 2, 0)},
+		{"0 2 * * *", date(2018, 10, 1, 2, 0), date(2018, 10, 2, 2, 0)},
+		{"*/15 * * * *", date(2018, 10, 1, 13, 37), date(2018, 10, 1, 13, 45)},
+		{"10-20/5 * * * *", date(2018, 10, 1, 13, 16), date(2018, 10, 1, 13, 20)},
+		{"0 0,12 * * *", date(2018, 10, 1, 0, 0), date(2018, 10, 1, 12, 0)},
+		{"0 0 1 * *", date(2018, 12, 15, 0, 0), date(2019, 1, 1, 0, 0)},
+		{"0 0 29 2 *", date(2018, 3, 1, 0, 0), date(2020, 2, 29, 0, 0)},
+		// 2018-10-01 is a Monday
+		{"30 3 * * 0", date(2018, 10, 1, 0, 0), date(2018, 10, 7, 3, 30)},
+		{"30 3 * * 7", date(2018, 10, 1, 0, 0), date(2018, 10, 7, 3, 30)},
+		// day-of-month OR day-of-week
+		{"0 0 15 * 3", date(2018, 10, 1, 0, 0), date(2018, 10, 3, 0, 0)},
+		{"0 0 2 * 5", date(2018, 10, 1, 0, 0), date(2018, 10, 2, 0, 0)},
+	}
+
+	for _, tc := range tcs {
+		s, err := parseCronSchedule(tc.spec)
+		require.NoError(t, err, "spec %q", tc.spec)
+		assert.Equal(t, tc.expected, s.Next(tc.from), "spec %q from %s", tc.spec, tc.from)
+	}
+
+	never, err := parseCronSchedule("0 0 30 2 *")
+	require.NoError(t, err)
+	assert.True(t, never.Next(date(2018, 1, 1, 0, 0)).IsZero())
+}
+
+func TestMissedRuns(t *testing.T) {
+	at := func(h, min int) time.Time {
+		return time.Date(2018, 10, 1, h, min, 0, 0, time.UTC)
+	}
+	s := intervalSchedule{interval: 15 * time.Minute}
+
+	missed, next := missedRuns(s, at(14, 0), at(13, 50), 10)
+	assert.Empty(t, missed)
+	assert.Equal(t, at(14, 0), next)
+
+	// the schedule of the snapshot at 12:05 continues at 14:05, not 15 minutes after the catch-up
+	missed, next = missedRuns(s, at(12, 20), at(14, 1), 10)
+	assert.Equal(t, []time.Time{at(12, 20), at(12, 35), at(12, 50), at(13, 5), at(13, 20), at(13, 35), at(13, 50)}, missed)
+	assert.Equal(t, at(14, 5), next)
+
+	missed, next = missedRuns(s, at(12, 20), at(14, 1), 2)
+	assert.Equal(t, []time.Time{at(13, 35), at(13, 50)}, missed)
+	assert.Equal(t, at(14, 5), next)
+
+	cron, err := parseCronSchedule("0 * * * *")
+	require.NoError(t, err)
+	missed, next = missedRuns(cron, at(11, 0), at(13, 30), 2)
+	assert.Equal(t, []time.Time{at(12, 0), at(13, 0)}, missed)
+	assert.Equal(t, at(14, 0), next)
+}
@@ -1,11 +1,16 @@
 package snapper
 
 import (
+	"bytes"
 	"github.com/zrepl/zrepl/config"
 	"github.com/pkg/errors"
 	"time"
 	"context"
 	"github.com/zrepl/zrepl/daemon/filters"
+	"github.com/zrepl/zrepl/daemon/hooks"
+	"github.com/zrepl/zrepl/daemon/job/lastsuccess"
+	"github.com/zrepl/zrepl/util/envconst"
+	"github.com/zrepl/zrepl/util/snapname"
 	"fmt"
 	"github.com/zrepl/zrepl/zfs"
 	"sort"
@@ -36,15 +41,125 @@ type snapProgress struct {
 
 	// SnapErr
 	err error
+
+	// number of times the snapshot was attempted, including retries of transient errors
+	attempts int
+}
+
+// noMatchingPolicy determines how the snapper treats filesystems
+// that have no snapshots matching the snapshot name format when it starts.
+type noMatchingPolicy string
+
+const (
+	// log a warning and snapshot the filesystem at the next scheduled time
+	noMatchingWarn noMatchingPolicy = "warn"
+	// take a snapshot of all filesystems immediately
+	noMatchingSnapshot noMatchingPolicy = "snapshot"
+	// exclude the filesystem from snapshotting and report it in the status
+	// until a snapshot matching the name format is created externally
+	noMatchingMisconfigured noMatchingPolicy = "misconfigured"
+)
+
+func noMatchingPolicyFromConfig(in string) (noMatchingPolicy, error) {
+	switch p := noMatchingPolicy(in); p {
+	case "":
+		return noMatchingWarn, nil
+	case noMatchingWarn, noMatchingSnapshot, noMatchingMisconfigured:
+		return p, nil
+	default:
+		return "", errors.Errorf("invalid no_matching_snapshots policy %q", in)
+	}
+}
+
+// missedPolicy determines how the snapper catches up on the scheduled snapshots
+// that were missed while the daemon was not running.
+type missedPolicy string
+
+const (
+	// take no catch-up snapshot, continue with the next scheduled time
+	missedSkip missedPolicy = "skip"
+	// take one catch-up snapshot immediately, then continue with the next scheduled time
+	missedRunOnce missedPolicy = "run_once"
+	// take a snapshot for each missed scheduled time (at most maxMissedRuns), named after that time,
+	// then continue with the next scheduled time
+	missedRunAll missedPolicy = "run_all"
+)
+
+// maxMissedRuns bounds the number of catch-up snapshots of missedRunAll.
+const maxMissedRuns = 100
+
+// catchUpRound is a round of snapshots that catches up on a missed scheduled time.
+type catchUpRound struct {
+	// the time after which the snapshots are named, zero means now
+	at time.Time
+	// the names of the filesystems that missed the scheduled time, only these are snapshotted
+	fss map[string]bool
+}
+
+// catchUpRounds returns the rounds of snapshots that catch up, according to policy, on the scheduled times of sched
+// that were missed by the filesystems whose next snapshot was due at the times in due, keyed by filesystem name.
+// It also returns the first scheduled time after now, at which the schedule is resumed after catching up.
+// rounds is empty if no filesystem missed a scheduled time.
+func catchUpRounds(policy missedPolicy, sched schedule, due map[string]time.Time, now time.Time) (rounds []catchUpRound, next time.Time) {
+	missedBy := make(map[time.Time]map[string]bool)
+	var times []time.Time
+	for fs, first := range due {
+		if first.After(now) {
+			continue
+		}
+		missed, fsNext := missedRuns(sched, first, now, maxMissedRuns)
+		if next.IsZero() || fsNext.Before(next) {
+			next = fsNext
+		}
+		if policy == missedRunOnce {
+			missed = []time.Time{{}}
+		}
+		for _, t := range missed {
+			if missedBy[t] == nil {
+				missedBy[t] = make(map[string]bool)
+				times = append(times, t)
+			}
+			missedBy[t][fs] = true
+		}
+	}
+	if policy == missedSkip || len(times) == 0 {
+		return nil, next
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	if len(times) > maxMissedRuns {
+		times = times[len(times)-maxMissedRuns:]
+	}
+	rounds = make([]catchUpRound, len(times))
+	for i, t := range times {
+		rounds[i] = catchUpRound{at: t, fss: missedBy[t]}
+	}
+	return rounds, next
+}
+
+func missedPolicyFromConfig(in string) (missedPolicy, error) {
+	switch p := missedPolicy(in); p {
+	case "":
+		return missedRunOnce, nil
+	case missedSkip, missedRunOnce, missedRunAll:
+		return p, nil
+	default:
+		return "", errors.Errorf("invalid missed policy %q", in)
+	}
 }
 
 type args struct {
 	ctx            context.Context
 	log            Logger
-	prefix         string
-	interval       time.Duration
+	names          *snapname.Format
+	schedule       schedule
+	noMatching     noMatchingPolicy
+	missed         missedPolicy
 	fsf            *filters.DatasetMapFilter
+	hooks          hooks.List
 	snapshotsTaken chan<-struct{}
+	lastSuccess    *lastsuccess.Tracker
+	// initial wait before retrying snapshots that failed with a transient error, doubled for every retry
+	retryInterval  time.Duration
 }
 
 type Snapper struct {
@@ -56,6 +171,15 @@ type Snapper struct {
 	// set in state Plan, used in Waiting
 	lastInvocation time.Time
 
+	// set in state SyncUp if scheduled snapshots were missed, see missedPolicy:
+	// the catch-up rounds that are still to be snapshotted, consumed by Planning,
+	// and the time at which Waiting resumes the schedule after catching up
+	catchUp  []catchUpRound
+	resumeAt time.Time
+
+	// valid for state Snapshotting, the time after which the snapshots are named, zero means now
+	nameAt time.Time
+
 	// valid for state Snapshotting
 	plan map[*zfs.DatasetPath]snapProgress
 
@@ -64,6 +188,12 @@ type Snapper struct {
 
 	// valid for state Err
 	err error
+
+	// filesystems excluded from snapshotting by noMatchingMisconfigured, keyed by name
+	misconfigured map[string]*zfs.DatasetPath
+
+	// number of snapshots created since the daemon started, see SnapshotsCreated
+	created int
 }
 
 //go:generate stringer -type=State
@@ -113,17 +243,73 @@ func getLogger(ctx context.Context) Logger {
 }
 
 func PeriodicFromConfig(g *config.Global, fsf *filters.DatasetMapFilter, in *config.SnapshottingPeriodic) (*Snapper, error) {
-	if in.Prefix == "" {
-		return nil, errors.New("prefix must not be empty")
-	}
 	if in.Interval <= 0 {
 		return nil, errors.New("interval must be positive")
 	}
+	sched := intervalSchedule{interval: in.Interval, align: in.Align}
+	names, err := namesFromConfig(in.Prefix, in.NameFormat, in.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	return newSnapper(fsf, names, sched, in.NoMatchingSnapshots, in.Missed, in.Hooks)
+}
+
+func CronFromConfig(g *config.Global, fsf *filters.DatasetMapFilter, in *config.SnapshottingCron) (*Snapper, error) {
+	sched, err := parseCronSchedule(in.Cron)
+	if err != nil {
+		return nil, err
+	}
+	if sched.Next(time.Now()).IsZero() {
+		return nil, errors.Errorf("cron expression %q never matches", in.Cron)
+	}
+	names, err := namesFromConfig(in.Prefix, in.NameFormat, in.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	return newSnapper(fsf, names, sched, in.NoMatchingSnapshots, in.Missed, in.Hooks)
+}
+
+// namesFromConfig requires exactly one of prefix and nameFormat.
+// Names with a prefix always use UTC, timezone only applies to nameFormat.
+func namesFromConfig(prefix, nameFormat, timezone string) (*snapname.Format, error) {
+	switch {
+	case prefix != "" && nameFormat != "":
+		return nil, errors.New("prefix and name_format are mutually exclusive")
+	case nameFormat != "":
+		return snapname.New(nameFormat, timezone)
+	case prefix != "":
+		if timezone != "" && timezone != "UTC" {
+			return nil, errors.New("timezone requires name_format, names with a prefix always use UTC")
+		}
+		return snapname.FromPrefix(prefix)
+	default:
+		return nil, errors.New("either prefix or name_format must be specified")
+	}
+}
+
+func newSnapper(fsf *filters.DatasetMapFilter, names *snapname.Format, sched schedule, noMatching, missed string, hookConfig []config.HookCommand) (*Snapper, error) {
+	noMatchingPolicy, err := noMatchingPolicyFromConfig(noMatching)
+	if err != nil {
+		return nil, err
+	}
+	missedPolicy, err := missedPolicyFromConfig(missed)
+	if err != nil {
+		return nil, err
+	}
+
+	hookList, err := hooks.ListFromConfig(hookConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot build hooks")
+	}
 
 	args := args{
-		prefix: in.Prefix,
-		interval: in.Interval,
+		names: names,
+		schedule: sched,
+		noMatching: noMatchingPolicy,
+		missed: missedPolicy,
 		fsf: fsf,
+		hooks: hookList,
+		retryInterval: envconst.Duration("ZREPL_SNAPPER_RETRY_INTERVAL", 10*time.Second),
 		// ctx and log is set in Run()
 	}
 
@@ -132,12 +318,13 @@ func PeriodicFromConfig(g *config.Global, fsf *filters.DatasetMapFilter, in *con
 
 func (s *Snapper) Run(ctx context.Context, snapshotsTaken chan<- struct{}) {
 
-	getLogger(ctx).Debug("start")
+	getLogger(ctx).WithField("schedule", s.args.schedule.String()).Debug("start")
 	defer getLogger(ctx).Debug("stop")
 
 	s.args.snapshotsTaken = snapshotsTaken
 	s.args.ctx = ctx
 	s.args.log = getLogger(ctx)
+	s.args.lastSuccess = lastsuccess.FromContext(ctx)
 
 	u := func(u func(*Snapper)) State {
 		s.mtx.Lock()
@@ -181,12 +368,60 @@ func syncUp(a args, u updater) state {
 	if err != nil {
 		return onErr(err, u)
 	}
-	syncPoint, err := findSyncPoint(a.log, fss, a.prefix, a.interval)
+	syncPoint, due, noMatching, err := findSyncPoint(a.log, fss, a.names, a.schedule)
 	if err != nil {
 		return onErr(err, u)
 	}
+	now := time.Now()
+	var catchUp []catchUpRound
+	var resumeAt time.Time
+	if syncPoint.IsZero() {
+		// no snapshots to sync up with
+		syncPoint = now
+	} else if !syncPoint.After(now) {
+		// each filesystem missed the scheduled times since its own latest snapshot
+		var next time.Time
+		catchUp, next = catchUpRounds(a.missed, a.schedule, due, now)
+		l := a.log.
+			WithField("policy", a.missed).
+			WithField("rounds", len(catchUp)).
+			WithField("first_missed", syncPoint).
+			WithField("next", next)
+		switch a.missed {
+		case missedSkip:
+			l.Info("skipping scheduled snapshots missed while the daemon was not running")
+			syncPoint = next
+		case missedRunOnce:
+			l.Info("taking a catch-up snapshot of the filesystems that missed scheduled snapshots while the daemon was not running")
+			syncPoint, resumeAt = now, next
+		case missedRunAll:
+			l.Info("taking catch-up snapshots of the filesystems that missed scheduled snapshots while the daemon was not running")
+			syncPoint, resumeAt = now, next
+		}
+	}
+	misconfigured := make(map[string]*zfs.DatasetPath)
+	for _, fs := range noMatching {
+		l := a.log.WithField("fs", fs.ToString()).WithField("name_format", a.names.String())
+		switch a.noMatching {
+		case noMatchingWarn:
+			l.Warn("filesystem has no snapshots matching the name format")
+		case noMatchingSnapshot:
+			l.Info("filesystem has no snapshots matching the name format, snapshotting immediately")
+			syncPoint = time.Now()
+			if len(catchUp) > 0 {
+				// the catch-up rounds only snapshot the filesystems that missed them, join the most recent one
+				catchUp[len(catchUp)-1].fss[fs.ToString()] = true
+			}
+		case noMatchingMisconfigured:
+			l.Error("filesystem has no snapshots matching the name format, excluding it from snapshotting")
+			misconfigured[fs.ToString()] = fs
+		}
+	}
 	u(func(s *Snapper){
 		s.sleepUntil = syncPoint
+		s.misconfigured = misconfigured
+		s.catchUp = catchUp
+		s.resumeAt = resumeAt
 	})
 	t := time.NewTimer(syncPoint.Sub(time.Now()))
 	defer t.Stop()
@@ -201,65 +436,198 @@ func syncUp(a args, u updater) state {
 }
 
 func plan(a args, u updater) state {
+	// nil unless this is a catch-up round
+	var only map[string]bool
 	u(func(snapper *Snapper) {
 		snapper.lastInvocation = time.Now()
+		snapper.nameAt = time.Time{}
+		if len(snapper.catchUp) > 0 {
+			snapper.nameAt = snapper.catchUp[0].at
+			only = snapper.catchUp[0].fss
+			snapper.catchUp = snapper.catchUp[1:]
+		}
 	})
 	fss, err := listFSes(a.fsf)
 	if err != nil {
 		return onErr(err, u)
 	}
 
+	var misconfigured map[string]*zfs.DatasetPath
+	u(func(snapper *Snapper) {
+		misconfigured = snapper.misconfigured
+	})
+	misconfigured = recheckMisconfigured(a, misconfigured)
+
 	plan := make(map[*zfs.DatasetPath]snapProgress, len(fss))
 	for _, fs := range fss {
+		if _, ok := misconfigured[fs.ToString()]; ok {
+			continue
+		}
+		if only != nil && !only[fs.ToString()] {
+			continue
+		}
 		plan[fs] = snapProgress{state: SnapPending}
 	}
 	return u(func(s *Snapper) {
 		s.state = Snapshotting
 		s.plan = plan
+		s.misconfigured = misconfigured
 	}).sf()
 }
 
 func snapshot(a args, u updater) state {
 
 	var plan map[*zfs.DatasetPath]snapProgress
+	nameAt := time.Now()
 	u(func(snapper *Snapper) {
 		plan = snapper.plan
+		if !snapper.nameAt.IsZero() {
+			nameAt = snapper.nameAt
+		}
 	})
 
-	hadErr := false
-	// TODO channel programs -> allow a little jitter?
-	for fs, progress := range plan {
-		suffix := time.Now().In(time.UTC).Format("20060102_150405_000")
-		snapname := fmt.Sprintf("%s%s", a.prefix, suffix)
+	snapname, err := a.names.Name(nameAt)
+	if err != nil {
+		a.log.WithError(err).Error("cannot render snapshot name")
+		return onErr(err, u)
+	}
 
-		l := a.log.
-			WithField("fs", fs.ToString()).
-			WithField("snap", snapname)
+	fss := make([]*zfs.DatasetPath, 0, len(plan))
+	for fs := range plan {
+		fss = append(fss, fs)
+	}
+	sort.Slice(fss, func(i, j int) bool { return fss[i].ToString() < fss[j].ToString() })
 
-		u(func(snapper *Snapper) {
+	u(func(snapper *Snapper) {
+		for _, fs := range fss {
+			progress := snapper.plan[fs]
 			progress.name = snapname
 			progress.startAt = time.Now()
 			progress.state = SnapStarted
+			progress.attempts = 1
+			snapper.plan[fs] = progress
+		}
+	})
+
+	errs := snapshotWithHooks(a, fss, snapname)
+
+	// Filesystems that failed with a transient error are retried below, report the others right away,
+	// so that e.g. replication does not wait for the retries.
+	var finished, retry []*zfs.DatasetPath
+	for _, fs := range fss {
+		if err := errs[fs]; err != nil && transientSnapshotError(err) {
+			retry = append(retry, fs)
+		} else {
+			finished = append(finished, fs)
+		}
+	}
+	hadErr := finishSnapshots(a, u, finished, errs)
+
+	// Retry transient errors until the next snapshot is due.
+	// The hooks of the retried filesystems have already run, so only the snapshots are repeated.
+	var deadline time.Time
+	u(func(snapper *Snapper) {
+		deadline = snapper.nextRound(a.schedule)
+	})
+	for wait := a.retryInterval; ; wait *= 2 {
+		var failed []*zfs.DatasetPath
+		for _, fs := range retry {
+			if err := errs[fs]; err != nil && transientSnapshotError(err) {
+				failed = append(failed, fs)
+			}
+		}
+		if len(failed) == 0 || time.Now().Add(wait).After(deadline) {
+			break
+		}
+		a.log.WithField("count", len(failed)).WithField("wait", wait).
+			Warn("snapshots failed with a transient error, retrying")
+		u(func(snapper *Snapper) {
+			for _, fs := range failed {
+				progress := snapper.plan[fs]
+				progress.err = errs[fs]
+				snapper.plan[fs] = progress
+			}
 		})
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-a.ctx.Done():
+			t.Stop()
+			return onMainCtxDone(a.ctx, u)
+		}
+		u(func(snapper *Snapper) {
+			for _, fs := range failed {
+				progress := snapper.plan[fs]
+				progress.attempts++
+				snapper.plan[fs] = progress
+			}
+		})
+		for _, pool := range zfs.GroupByPool(failed) {
+			retryErrs := createSnapshots(a, pool, snapname)
+			for _, fs := range pool {
+				errs[fs] = retryErrs[fs]
+				if err := retryErrs[fs]; err != nil {
+					a.log.WithField("fs", fs.ToString()).WithField("snap", snapname).
+						WithError(err).Error("cannot create snapshot")
+				}
+			}
+		}
+	}
+	if finishSnapshots(a, u, retry, errs) {
+		hadErr = true
+	}
 
-		l.Debug("create snapshot")
-		err := zfs.ZFSSnapshot(fs, snapname, false)
-		if err != nil {
+	if !hadErr {
+		a.lastSuccess.Record(lastsuccess.Snapshot, time.Now())
+	}
+
+	return u(func(snapper *Snapper) {
+		if hadErr {
+			snapper.state = ErrorWait
+			snapper.err = errors.New("one or more snapshots could not be created, check logs for details")
+		} else {
+			snapper.state = Waiting
+		}
+	}).sf()
+}
+
+// finishSnapshots records the outcome of the snapshots of fss, whose errors are in errs,
+// and signals snapshotsTaken if one of them was created.
+// It returns true if one of the snapshots failed.
+func finishSnapshots(a args, u updater, fss []*zfs.DatasetPath, errs map[*zfs.DatasetPath]error) (hadErr bool) {
+	if len(fss) == 0 {
+		return false
+	}
+	doneAt := time.Now()
+	taken := false
+	for _, fs := range fss {
+		if errs[fs] != nil {
 			hadErr = true
-			l.WithError(err).Error("cannot create snapshot")
+		} else {
+			taken = true
+			a.lastSuccess.RecordFS(lastsuccess.Snapshot, fs.ToString(), doneAt)
 		}
-		doneAt := time.Now()
+	}
 
-		u(func(snapper *Snapper) {
+	u(func(snapper *Snapper) {
+		for _, fs := range fss {
+			progress := snapper.plan[fs]
 			progress.doneAt = doneAt
 			progress.state = SnapDone
-			if err != nil {
+			progress.err = nil
+			if err := errs[fs]; err != nil {
 				progress.state = SnapError
 				progress.err = err
+			} else {
+				snapper.created++
 			}
-		})
-	}
+			snapper.plan[fs] = progress
+		}
+	})
 
+	if !taken {
+		return hadErr
+	}
 	select {
 	case a.snapshotsTaken <- struct{}{}:
 	default:
@@ -267,22 +635,143 @@ func snapshot(a args, u updater) state {
 			a.log.Warn("callback channel is full, discarding snapshot update event")
 		}
 	}
+	return hadErr
+}
 
-	return u(func(snapper *Snapper) {
-		if hadErr {
-			snapper.state = ErrorWait
-			snapper.err = errors.New("one or more snapshots could not be created, check logs for details")
-		} else {
-			snapper.state = Waiting
+// snapshotWithHooks takes the snapshot fs@snapname of all fss, surrounded by the hooks applicable to each fs.
+// The filesystems are snapshotted pool by pool (see snapshotPoolWithHooks),
+// so that the post-snapshot hooks of a pool, e.g. releasing locks taken by its pre-snapshot hooks,
+// run before the hooks of the next pool.
+// The returned map has the error of each filesystem that failed.
+func snapshotWithHooks(a args, fss []*zfs.DatasetPath, snapname string) map[*zfs.DatasetPath]error {
+	errs := make(map[*zfs.DatasetPath]error)
+	for _, pool := range zfs.GroupByPool(fss) {
+		snapshotPoolWithHooks(a, pool, snapname, errs)
+	}
+	return errs
+}
+
+// snapshotPoolWithHooks takes the snapshots of pool, which are the filesystems of a single pool,
+// after the pre-snapshot hooks of all of them have run, and runs their post-snapshot hooks afterwards.
+// If a fatal pre-snapshot hook fails, the snapshot of its filesystem is not taken.
+// Post-snapshot hooks are always run, e.g. to release locks acquired by pre-snapshot hooks.
+// The error of each filesystem that failed is stored in errs.
+func snapshotPoolWithHooks(a args, pool []*zfs.DatasetPath, snapname string, errs map[*zfs.DatasetPath]error) {
+	logs := make(map[*zfs.DatasetPath]Logger, len(pool))
+	envs := make(map[*zfs.DatasetPath]hooks.Env, len(pool))
+	fsHooks := make(map[*zfs.DatasetPath]hooks.List, len(pool))
+	var snap []*zfs.DatasetPath
+	for _, fs := range pool {
+		l := a.log.
+			WithField("fs", fs.ToString()).
+			WithField("snap", snapname)
+		logs[fs] = l
+		h, err := a.hooks.Filter(fs)
+		if err != nil {
+			l.WithError(err).Error("cannot determine hooks for filesystem")
+			errs[fs] = err
+			continue
 		}
-	}).sf()
+		fsHooks[fs] = h
+		envs[fs] = hooks.Env{
+			hooks.EnvFS:       fs.ToString(),
+			hooks.EnvSnapshot: snapname,
+		}
+		if err := h.Run(a.ctx, l, hooks.PhasePreSnapshot, envs[fs]); err != nil {
+			l.WithError(err).Error("fatal pre-snapshot hook failed, skipping snapshot")
+			errs[fs] = err
+			continue
+		}
+		snap = append(snap, fs)
+	}
+
+	for fs, err := range createSnapshots(a, snap, snapname) {
+		logs[fs].WithError(err).Error("cannot create snapshot")
+		errs[fs] = err
+	}
+
+	for _, fs := range pool {
+		h, ok := fsHooks[fs]
+		if !ok {
+			continue
+		}
+		if err := h.Run(a.ctx, logs[fs], hooks.PhasePostSnapshot, envs[fs]); err != nil {
+			logs[fs].WithError(err).Error("fatal post-snapshot hook failed")
+			if errs[fs] == nil {
+				errs[fs] = err
+			}
+		}
+	}
+}
+
+// createSnapshots atomically creates the snapshot fs@snapname of all fss, which must be in the same pool.
+// The snapshot of a single filesystem, e.g. a busy one, fails the atomic snapshot of all of them,
+// so if it fails, the filesystems are snapshotted one by one and only those that still fail are reported.
+// The returned map has the error of each filesystem that failed.
+func createSnapshots(a args, fss []*zfs.DatasetPath, snapname string) map[*zfs.DatasetPath]error {
+	errs := make(map[*zfs.DatasetPath]error)
+	if len(fss) == 0 {
+		return errs
+	}
+	a.log.WithField("snap", snapname).WithField("count", len(fss)).Debug("create snapshots")
+	snapErrs := zfsSnapshotAtomic(fss, snapname)
+	if len(snapErrs) > 0 && len(fss) > 1 {
+		a.log.WithField("snap", snapname).WithField("count", len(fss)).
+			Warn("atomic snapshot failed, snapshotting filesystems individually")
+		for _, fs := range fss {
+			if snapErrs[fs.ToString()] != nil {
+				snapErrs[fs.ToString()] = zfsSnapshot(fs, snapname, false)
+			}
+		}
+	}
+	for _, fs := range fss {
+		if err := snapErrs[fs.ToString()]; err != nil {
+			errs[fs] = err
+		}
+	}
+	return errs
+}
+
+// transientSnapshotErrors are the messages of zfs snapshot errors that may disappear by themselves.
+var transientSnapshotErrors = [][]byte{
+	[]byte("dataset is busy"),
+	[]byte("pool I/O is currently suspended"),
+}
+
+// transientSnapshotError returns true if the snapshot that failed with err should be retried.
+// Errors of hooks are never transient.
+func transientSnapshotError(err error) bool {
+	zfsErr, ok := err.(zfs.ZFSError)
+	if !ok {
+		return false
+	}
+	for _, msg := range transientSnapshotErrors {
+		if bytes.Contains(zfsErr.Stderr, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextRound returns the time at which the round of snapshots after the current one is due.
+// s.mtx must be held
+func (s *Snapper) nextRound(sched schedule) time.Time {
+	if len(s.catchUp) > 0 {
+		return time.Now()
+	}
+	if !s.resumeAt.IsZero() {
+		return s.resumeAt
+	}
+	return sched.Next(s.lastInvocation)
 }
 
 func wait(a args, u updater) state {
 	var sleepUntil time.Time
 	u(func(snapper *Snapper) {
-		lastTick := snapper.lastInvocation
-		snapper.sleepUntil = lastTick.Add(a.interval)
+		snapper.sleepUntil = snapper.nextRound(a.schedule)
+		if len(snapper.catchUp) == 0 {
+			snapper.resumeAt = time.Time{}
+		}
 		sleepUntil = snapper.sleepUntil
 	})
 
@@ -299,18 +788,73 @@ func wait(a args, u updater) state {
 	}
 }
 
+// recheckMisconfigured returns the subset of misconfigured filesystems
+// that still have no snapshots matching the name format.
+func recheckMisconfigured(a args, misconfigured map[string]*zfs.DatasetPath) map[string]*zfs.DatasetPath {
+	still := make(map[string]*zfs.DatasetPath, len(misconfigured))
+	fss := make([]*zfs.DatasetPath, 0, len(misconfigured))
+	for _, fs := range misconfigured {
+		fss = append(fss, fs)
+	}
+	allFsvs := listVersions(a.log, fss, filters.NewSnapnameFilter(a.names))
+	for name, fs := range misconfigured {
+		l := a.log.WithField("fs", name)
+		if fsvs, ok := allFsvs[name]; !ok || len(fsvs) == 0 {
+			still[name] = fs
+			continue
+		}
+		l.Info("filesystem now has snapshots matching the name format, including it in snapshotting again")
+	}
+	return still
+}
+
+// The zfs functions used by the snapper and the Watcher, replaced by tests.
+var (
+	zfsListMapping                 = zfs.ZFSListMapping
+	zfsListFilesystemVersionsBatch = zfs.ZFSListFilesystemVersionsBatch
+	zfsListFilesystemVersions      = zfs.ZFSListFilesystemVersions
+	zfsSnapshotAtomic              = zfs.ZFSSnapshotAtomic
+	zfsSnapshot                    = zfs.ZFSSnapshot
+)
+
 func listFSes(mf *filters.DatasetMapFilter) (fss []*zfs.DatasetPath, err error) {
-	return zfs.ZFSListMapping(mf)
+	return zfsListMapping(mf)
+}
+
+// listVersions returns the versions of fss by filesystem, listed with one zfs list per pool.
+// If that fails, each filesystem is listed separately, and those that cannot be listed
+// are logged and omitted from the result, so that one broken filesystem does not affect the others.
+func listVersions(log Logger, fss []*zfs.DatasetPath, filter zfs.FilesystemVersionFilter) map[string][]zfs.FilesystemVersion {
+	allFsvs, err := zfsListFilesystemVersionsBatch(fss, filter)
+	if err == nil {
+		return allFsvs
+	}
+	log.WithError(err).Warn("cannot list filesystem versions of all filesystems at once, listing them one by one")
+	allFsvs = make(map[string][]zfs.FilesystemVersion, len(fss))
+	for _, fs := range fss {
+		fsvs, err := zfsListFilesystemVersions(fs, filter)
+		if err != nil {
+			log.WithField("fs", fs.ToString()).WithError(err).Error("cannot list filesystem versions")
+			continue
+		}
+		allFsvs[fs.ToString()] = fsvs
+	}
+	return allFsvs
 }
 
-func findSyncPoint(log Logger, fss []*zfs.DatasetPath, prefix string, interval time.Duration) (syncPoint time.Time, err error) {
+// findSyncPoint returns the earliest time at which a filesystem is due to be snapshotted according to sched,
+// which is in the past if scheduled snapshots were missed,
+// or the zero time if no filesystem has a snapshot matching names.
+// It also returns the time at which each filesystem with matching snapshots is due, keyed by name,
+// and the filesystems that have no snapshots matching names.
+func findSyncPoint(log Logger, fss []*zfs.DatasetPath, names *snapname.Format, sched schedule) (syncPoint time.Time, due map[string]time.Time, noMatching []*zfs.DatasetPath, err error) {
 	type snapTime struct {
 		ds   *zfs.DatasetPath
 		time time.Time
 	}
 
 	if len(fss) == 0 {
-		return time.Now(), nil
+		return time.Time{}, nil, nil, nil
 	}
 
 	snaptimes := make([]snapTime, 0, len(fss))
@@ -318,17 +862,18 @@ func findSyncPoint(log Logger, fss []*zfs.DatasetPath, prefix string, interval t
 	now := time.Now()
 
 	log.Debug("examine filesystem state")
+	allFsvs := listVersions(log, fss, filters.NewSnapnameFilter(names))
 	for _, d := range fss {
 
 		l := log.WithField("fs", d.ToString())
 
-		fsvs, err := zfs.ZFSListFilesystemVersions(d, filters.NewTypedPrefixFilter(prefix, zfs.Snapshot))
-		if err != nil {
-			l.WithError(err).Error("cannot list filesystem versions")
-			continue
+		fsvs, ok := allFsvs[d.ToString()]
+		if !ok {
+			continue // logged by listVersions
 		}
 		if len(fsvs) <= 0 {
-			l.WithField("prefix", prefix).Debug("no filesystem versions with prefix")
+			l.WithField("name_format", names.String()).Debug("no filesystem versions matching name format")
+			noMatching = append(noMatching, d)
 			continue
 		}
 
@@ -348,22 +893,82 @@ func findSyncPoint(log Logger, fss []*zfs.DatasetPath, prefix string, interval t
 				Error("snapshot is from the future")
 			continue
 		}
-		next := now
-		if since < interval {
-			next = latest.Creation.Add(interval)
-		}
-		snaptimes = append(snaptimes, snapTime{d, next})
+		snaptimes = append(snaptimes, snapTime{d, sched.Next(latest.Creation)})
 	}
 
 	if len(snaptimes) == 0 {
-		snaptimes = append(snaptimes, snapTime{nil, now})
+		return time.Time{}, nil, noMatching, nil
 	}
 
+	due = make(map[string]time.Time, len(snaptimes))
+	for _, st := range snaptimes {
+		due[st.ds.ToString()] = st.time
+	}
 	sort.Slice(snaptimes, func(i, j int) bool {
 		return snaptimes[i].time.Before(snaptimes[j].time)
 	})
 
-	return snaptimes[0].time, nil
+	return snaptimes[0].time, due, noMatching, nil
 
 }
 
+type Report struct {
+	State string
+	SleepUntil time.Time
+	Error string
+	// filesystems without snapshots matching the name format
+	// that are excluded from snapshotting (no_matching_snapshots: misconfigured)
+	Misconfigured []string
+	// the filesystems of the current or most recent round of snapshots, sorted by path
+	Progress []*ReportFilesystem
+}
+
+type ReportFilesystem struct {
+	Path     string
+	State    string
+	SnapName string
+	StartAt  time.Time
+	DoneAt   time.Time
+	Error    string
+	Attempts int
+}
+
+// SnapshotsCreated returns the number of snapshots created by s since the daemon started.
+func (s *Snapper) SnapshotsCreated() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.created
+}
+
+func (s *Snapper) Report() *Report {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	r := &Report{
+		State: s.state.String(),
+		SleepUntil: s.sleepUntil,
+	}
+	if s.err != nil {
+		r.Error = s.err.Error()
+	}
+	for name := range s.misconfigured {
+		r.Misconfigured = append(r.Misconfigured, name)
+	}
+	sort.Strings(r.Misconfigured)
+	for fs, progress := range s.plan {
+		rfs := &ReportFilesystem{
+			Path:     fs.ToString(),
+			State:    progress.state.String(),
+			SnapName: progress.name,
+			StartAt:  progress.startAt,
+			DoneAt:   progress.doneAt,
+			Attempts: progress.attempts,
+		}
+		if progress.err != nil {
+			rfs.Error = progress.err.Error()
+		}
+		r.Progress = append(r.Progress, rfs)
+	}
+	sort.Slice(r.Progress, func(i, j int) bool { return r.Progress[i].Path < r.Progress[j].Path })
+	return r
+}
@@ -15,12 +15,41 @@ import (
 //   - support a `zrepl snapshot JOBNAME` subcommand for config.SnapshottingManual
 type PeriodicOrManual struct {
 	s *Snapper
+	// only set for manual snapshotting with poll_interval
+	w *Watcher
 }
 
 func (s *PeriodicOrManual) Run(ctx context.Context, wakeUpCommon chan <- struct{}) {
 	if s.s != nil {
 		s.s.Run(ctx, wakeUpCommon)
 	}
+	if s.w != nil {
+		s.w.Run(ctx, wakeUpCommon)
+	}
+}
+
+// Periodic returns true if zrepl takes the snapshots, i.e. snapshotting is not manual.
+func (s *PeriodicOrManual) Periodic() bool {
+	return s.s != nil
+}
+
+// SnapshotsCreated returns the number of snapshots zrepl created since the daemon started, always 0 for manual snapshotting.
+func (s *PeriodicOrManual) SnapshotsCreated() int {
+	if s.s != nil {
+		return s.s.SnapshotsCreated()
+	}
+	return 0
+}
+
+// Report returns nil for manual snapshotting without poll_interval.
+func (s *PeriodicOrManual) Report() *Report {
+	if s.s != nil {
+		return s.s.Report()
+	}
+	if s.w != nil {
+		return s.w.Report()
+	}
+	return nil
 }
 
 func FromConfig(g *config.Global, fsf *filters.DatasetMapFilter, in config.SnapshottingEnum) (*PeriodicOrManual, error) {
@@ -30,9 +59,22 @@ func FromConfig(g *config.Global, fsf *filters.DatasetMapFilter, in config.Snaps
 		if err != nil {
 			return nil, err
 		}
-		return &PeriodicOrManual{snapper}, nil
+		return &PeriodicOrManual{s: snapper}, nil
+	case *config.SnapshottingCron:
+		snapper, err := CronFromConfig(g, fsf, v)
+		if err != nil {
+			return nil, err
+		}
+		return &PeriodicOrManual{s: snapper}, nil
 	case *config.SnapshottingManual:
-		return &PeriodicOrManual{}, nil
+		if v.PollInterval <= 0 {
+			return &PeriodicOrManual{}, nil
+		}
+		watcher, err := WatcherFromConfig(g, fsf, v)
+		if err != nil {
+			return nil, err
+		}
+		return &PeriodicOrManual{w: watcher}, nil
 	default:
 		return nil, fmt.Errorf("unknown snapshotting type %T", v)
 	}
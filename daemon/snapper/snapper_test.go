@@ -0,0 +1,472 @@
+package snapper
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/hooks"
+	"github.com/zrepl/zrepl/logger"
+	"github.com/zrepl/zrepl/util/snapname"
+	"github.com/zrepl/zrepl/zfs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransientSnapshotError(t *testing.T) {
+	busy := zfs.ZFSError{
+		Stderr:  []byte("cannot create snapshot 'pool/fs@zrepl_1': dataset is busy\n"),
+		WaitErr: errors.New("exit status 1"),
+	}
+	exists := zfs.ZFSError{
+		Stderr:  []byte("cannot create snapshot 'pool/fs@zrepl_1': dataset already exists\n"),
+		WaitErr: errors.New("exit status 1"),
+	}
+	assert.True(t, transientSnapshotError(busy))
+	assert.False(t, transientSnapshotError(exists))
+	assert.False(t, transientSnapshotError(errors.New("dataset is busy")), "only zfs errors are transient")
+}
+
+// fakeZFS replaces the zfs functions of the snapper with in-memory filesystems and snapshots.
+type fakeZFS struct {
+	mtx      sync.Mutex
+	fss      []*zfs.DatasetPath
+	versions map[string][]zfs.FilesystemVersion
+	listErr  error
+	// filesystems that cannot be listed, which also fails listing them in batch
+	fsListErrs map[string]error
+	// errors of the next snapshots of a filesystem, consumed in order
+	snapErrs map[string][]error
+	// fs@snapname of all snapshot attempts, in order
+	attempts []string
+}
+
+func newFakeZFS(t *testing.T, fss ...string) *fakeZFS {
+	f := &fakeZFS{
+		versions:   make(map[string][]zfs.FilesystemVersion),
+		fsListErrs: make(map[string]error),
+		snapErrs:   make(map[string][]error),
+	}
+	for _, fs := range fss {
+		p, err := zfs.NewDatasetPath(fs)
+		require.NoError(t, err)
+		f.fss = append(f.fss, p)
+	}
+	return f
+}
+
+// install replaces the zfs functions of the snapper until the returned function is called.
+func (f *fakeZFS) install() (restore func()) {
+	prevListMapping, prevListVersionsBatch, prevListVersions := zfsListMapping, zfsListFilesystemVersionsBatch, zfsListFilesystemVersions
+	prevSnapshotAtomic, prevSnapshot := zfsSnapshotAtomic, zfsSnapshot
+	zfsListMapping = func(filter zfs.DatasetFilter) ([]*zfs.DatasetPath, error) {
+		f.mtx.Lock()
+		defer f.mtx.Unlock()
+		return f.fss, f.listErr
+	}
+	zfsListFilesystemVersionsBatch = f.listVersions
+	zfsListFilesystemVersions = func(fs *zfs.DatasetPath, filter zfs.FilesystemVersionFilter) ([]zfs.FilesystemVersion, error) {
+		f.mtx.Lock()
+		defer f.mtx.Unlock()
+		if err := f.fsListErrs[fs.ToString()]; err != nil {
+			return nil, err
+		}
+		return f.filterVersions(fs, filter)
+	}
+	zfsSnapshotAtomic = f.snapshotAtomic
+	zfsSnapshot = func(fs *zfs.DatasetPath, name string, recursive bool) error {
+		return f.snapshot(fs, name)
+	}
+	return func() {
+		zfsListMapping, zfsListFilesystemVersionsBatch, zfsListFilesystemVersions = prevListMapping, prevListVersionsBatch, prevListVersions
+		zfsSnapshotAtomic, zfsSnapshot = prevSnapshotAtomic, prevSnapshot
+	}
+}
+
+func (f *fakeZFS) listVersions(fss []*zfs.DatasetPath, filter zfs.FilesystemVersionFilter) (map[string][]zfs.FilesystemVersion, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.listErr != nil {
+		return nil, f.listErr
+	}
+	res := make(map[string][]zfs.FilesystemVersion, len(fss))
+	for _, fs := range fss {
+		if err := f.fsListErrs[fs.ToString()]; err != nil {
+			return nil, err
+		}
+		fsvs, err := f.filterVersions(fs, filter)
+		if err != nil {
+			return nil, err
+		}
+		res[fs.ToString()] = fsvs
+	}
+	return res, nil
+}
+
+// f.mtx must be held
+func (f *fakeZFS) filterVersions(fs *zfs.DatasetPath, filter zfs.FilesystemVersionFilter) ([]zfs.FilesystemVersion, error) {
+	fsvs := make([]zfs.FilesystemVersion, 0)
+	for _, v := range f.versions[fs.ToString()] {
+		ok, err := filter.Filter(v.Type, v.Name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			fsvs = append(fsvs, v)
+		}
+	}
+	return fsvs, nil
+}
+
+// addSnapshot adds the snapshot fs@name, created at creation, as if it was created outside of the snapper.
+func (f *fakeZFS) addSnapshot(fs, name string, creation time.Time) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	n := uint64(len(f.attempts)) + 1
+	for _, vs := range f.versions {
+		n += uint64(len(vs))
+	}
+	f.versions[fs] = append(f.versions[fs], zfs.FilesystemVersion{
+		Type: zfs.Snapshot, Name: name, Guid: n, CreateTXG: n, Creation: creation,
+	})
+}
+
+func (f *fakeZFS) snapshot(fs *zfs.DatasetPath, name string) error {
+	f.mtx.Lock()
+	f.attempts = append(f.attempts, fs.ToString()+"@"+name)
+	var err error
+	if errs := f.snapErrs[fs.ToString()]; len(errs) > 0 {
+		err, f.snapErrs[fs.ToString()] = errs[0], errs[1:]
+	}
+	f.mtx.Unlock()
+	if err == nil {
+		f.addSnapshot(fs.ToString(), name, time.Now())
+	}
+	return err
+}
+
+// snapshotAtomic creates the snapshots of all fss or, like zfs snapshot, none of them if the snapshot of one fails.
+// Only the error of the failing filesystem is consumed, but it is reported for all of them.
+func (f *fakeZFS) snapshotAtomic(fss []*zfs.DatasetPath, name string) map[string]error {
+	f.mtx.Lock()
+	var err error
+	for _, fs := range fss {
+		f.attempts = append(f.attempts, fs.ToString()+"@"+name)
+		if errs := f.snapErrs[fs.ToString()]; err == nil && len(errs) > 0 {
+			err, f.snapErrs[fs.ToString()] = errs[0], errs[1:]
+		}
+	}
+	f.mtx.Unlock()
+	errs := make(map[string]error)
+	for _, fs := range fss {
+		if err != nil {
+			errs[fs.ToString()] = err
+		} else {
+			f.addSnapshot(fs.ToString(), name, time.Now())
+		}
+	}
+	return errs
+}
+
+func (f *fakeZFS) Attempts() []string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return append([]string(nil), f.attempts...)
+}
+
+func TestNamesFromConfig(t *testing.T) {
+	names, err := namesFromConfig("zrepl_", "", "UTC")
+	require.NoError(t, err)
+	name, err := names.Name(time.Date(2018, 10, 1, 13, 37, 12, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, "zrepl_20181001_133712_000", name)
+
+	_, err = namesFromConfig("zrepl_", "", "Local")
+	assert.Error(t, err, "names with a prefix are always UTC")
+	_, err = namesFromConfig("zrepl_", `zrepl_{{ .Time.Format "2006" }}`, "UTC")
+	assert.Error(t, err)
+	_, err = namesFromConfig("", "", "UTC")
+	assert.Error(t, err)
+}
+
+// newTestSnapper returns a Snapper of the filesystems of f that is ready to run a single state with runState.
+func newTestSnapper(t *testing.T, sched schedule, noMatching noMatchingPolicy, missed missedPolicy) *Snapper {
+	names, err := snapname.FromPrefix("zrepl_")
+	require.NoError(t, err)
+	return &Snapper{state: SyncUp, args: args{
+		log:           logger.NewTestLogger(t),
+		names:         names,
+		schedule:      sched,
+		noMatching:    noMatching,
+		missed:        missed,
+		retryInterval: 10 * time.Millisecond,
+	}}
+}
+
+// runState runs st with ctx and returns the state of s afterwards.
+func runState(ctx context.Context, s *Snapper, st state) State {
+	a := s.args
+	a.ctx = ctx
+	u := func(u func(*Snapper)) State {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		if u != nil {
+			u(s)
+		}
+		return s.state
+	}
+	st(a, u)
+	return u(nil)
+}
+
+// cancelled returns a context that is already done,
+// so that a state that waits for a time in the future returns immediately.
+func cancelled() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+func TestSyncUpNoMatchingSnapshots(t *testing.T) {
+	sched := intervalSchedule{interval: time.Hour}
+	newFS := func() *fakeZFS {
+		f := newFakeZFS(t, "pool/a", "pool/b")
+		f.addSnapshot("pool/a", "zrepl_20181001_133712_000", time.Now())
+		f.addSnapshot("pool/b", "manual_1", time.Now())
+		return f
+	}
+
+	t.Run("warn", func(t *testing.T) {
+		defer newFS().install()()
+		s := newTestSnapper(t, sched, noMatchingWarn, missedRunOnce)
+		assert.Equal(t, Stopped, runState(cancelled(), s, syncUp))
+		assert.True(t, s.sleepUntil.After(time.Now().Add(59*time.Minute)), "must wait for the schedule of pool/a")
+		assert.Empty(t, s.misconfigured)
+	})
+
+	t.Run("snapshot", func(t *testing.T) {
+		defer newFS().install()()
+		s := newTestSnapper(t, sched, noMatchingSnapshot, missedRunOnce)
+		assert.Equal(t, Planning, runState(context.Background(), s, syncUp))
+		assert.False(t, s.sleepUntil.After(time.Now()), "must snapshot immediately")
+	})
+
+	t.Run("misconfigured", func(t *testing.T) {
+		f := newFS()
+		defer f.install()()
+		s := newTestSnapper(t, sched, noMatchingMisconfigured, missedRunOnce)
+		assert.Equal(t, Stopped, runState(cancelled(), s, syncUp))
+		assert.True(t, s.sleepUntil.After(time.Now().Add(59*time.Minute)))
+		require.Len(t, s.misconfigured, 1)
+		assert.Contains(t, s.misconfigured, "pool/b")
+		assert.Equal(t, []string{"pool/b"}, s.Report().Misconfigured)
+
+		assert.Equal(t, Snapshotting, runState(context.Background(), s, plan))
+		assert.Len(t, s.plan, 1, "misconfigured filesystems must not be snapshotted")
+
+		f.addSnapshot("pool/b", "zrepl_20181001_133712_000", time.Now())
+		assert.Equal(t, Snapshotting, runState(context.Background(), s, plan))
+		assert.Len(t, s.plan, 2, "a filesystem with a matching snapshot is no longer misconfigured")
+		assert.Empty(t, s.misconfigured)
+	})
+}
+
+func TestCatchUpRounds(t *testing.T) {
+	at := func(h, m int) time.Time { return time.Date(2018, 10, 1, h, m, 0, 0, time.UTC) }
+	s := intervalSchedule{interval: 15 * time.Minute, align: true}
+	due := map[string]time.Time{
+		"pool/a": at(13, 15),
+		"pool/b": at(13, 45),
+		// not missed
+		"pool/c": at(14, 15),
+	}
+	now := at(14, 1)
+
+	rounds, next := catchUpRounds(missedSkip, s, due, now)
+	assert.Empty(t, rounds)
+	assert.Equal(t, at(14, 15), next)
+
+	rounds, next = catchUpRounds(missedRunOnce, s, due, now)
+	require.Len(t, rounds, 1)
+	assert.True(t, rounds[0].at.IsZero(), "named after the time of the round")
+	assert.Equal(t, map[string]bool{"pool/a": true, "pool/b": true}, rounds[0].fss)
+	assert.Equal(t, at(14, 15), next)
+
+	rounds, next = catchUpRounds(missedRunAll, s, due, now)
+	assert.Equal(t, []catchUpRound{
+		{at(13, 15), map[string]bool{"pool/a": true}},
+		{at(13, 30), map[string]bool{"pool/a": true}},
+		{at(13, 45), map[string]bool{"pool/a": true, "pool/b": true}},
+		{at(14, 0), map[string]bool{"pool/a": true, "pool/b": true}},
+	}, rounds)
+	assert.Equal(t, at(14, 15), next)
+
+	rounds, _ = catchUpRounds(missedRunAll, s, map[string]time.Time{"pool/c": at(14, 15)}, now)
+	assert.Empty(t, rounds)
+}
+
+func TestSyncUpMissedPolicy(t *testing.T) {
+	sched := intervalSchedule{interval: time.Hour}
+	newFS := func() *fakeZFS {
+		f := newFakeZFS(t, "pool/a", "pool/b")
+		// pool/a missed the runs 2h10m, 1h10m and 10m ago, pool/b is due in 30m
+		f.addSnapshot("pool/a", "zrepl_20181001_103712_000", time.Now().Add(-3*time.Hour-10*time.Minute))
+		f.addSnapshot("pool/b", "zrepl_20181001_133712_000", time.Now().Add(-30*time.Minute))
+		return f
+	}
+	resumesInFiftyMinutes := func(t *testing.T, at time.Time) {
+		until := at.Sub(time.Now())
+		assert.True(t, until > 49*time.Minute && until <= 50*time.Minute, "resumes in %s", until)
+	}
+
+	t.Run("skip", func(t *testing.T) {
+		defer newFS().install()()
+		s := newTestSnapper(t, sched, noMatchingWarn, missedSkip)
+		assert.Equal(t, Stopped, runState(cancelled(), s, syncUp))
+		assert.Empty(t, s.catchUp)
+		resumesInFiftyMinutes(t, s.sleepUntil)
+	})
+
+	t.Run("run_once", func(t *testing.T) {
+		defer newFS().install()()
+		s := newTestSnapper(t, sched, noMatchingWarn, missedRunOnce)
+		assert.Equal(t, Planning, runState(context.Background(), s, syncUp))
+		resumesInFiftyMinutes(t, s.resumeAt)
+
+		assert.Equal(t, Snapshotting, runState(context.Background(), s, plan))
+		assert.True(t, s.nameAt.IsZero())
+		require.Len(t, s.plan, 1, "only the filesystem that missed runs catches up")
+		for fs := range s.plan {
+			assert.Equal(t, "pool/a", fs.ToString())
+		}
+		assert.Empty(t, s.catchUp)
+	})
+
+	t.Run("run_all", func(t *testing.T) {
+		defer newFS().install()()
+		s := newTestSnapper(t, sched, noMatchingWarn, missedRunAll)
+		assert.Equal(t, Planning, runState(context.Background(), s, syncUp))
+		resumesInFiftyMinutes(t, s.resumeAt)
+		require.Len(t, s.catchUp, 3)
+
+		first := s.catchUp[0].at
+		assert.Equal(t, Snapshotting, runState(context.Background(), s, plan))
+		assert.Equal(t, first, s.nameAt)
+		require.Len(t, s.plan, 1)
+		for fs := range s.plan {
+			assert.Equal(t, "pool/a", fs.ToString())
+		}
+		assert.Len(t, s.catchUp, 2)
+	})
+}
+
+func TestFindSyncPointSkipsUnlistableFilesystem(t *testing.T) {
+	f := newFakeZFS(t, "pool/a", "pool/b", "pool/c")
+	defer f.install()()
+	created := time.Now().Add(-10 * time.Minute)
+	f.addSnapshot("pool/a", "zrepl_20181001_133712_000", created.Add(-time.Hour))
+	f.addSnapshot("pool/c", "zrepl_20181001_143712_000", created)
+	f.fsListErrs["pool/a"] = errors.New("dataset is busy")
+
+	s := newTestSnapper(t, intervalSchedule{interval: time.Hour}, noMatchingWarn, missedRunOnce)
+	syncPoint, due, noMatching, err := findSyncPoint(s.args.log, f.fss, s.args.names, s.args.schedule)
+	require.NoError(t, err)
+	// pool/a would be due an hour earlier, but it cannot be listed
+	assert.True(t, syncPoint.Equal(created.Add(time.Hour)), "sync point %s", syncPoint)
+	assert.Len(t, due, 1)
+	assert.True(t, due["pool/c"].Equal(syncPoint))
+	require.Len(t, noMatching, 1)
+	assert.Equal(t, "pool/b", noMatching[0].ToString(), "a filesystem that cannot be listed has no known snapshots, not none")
+}
+
+// hookLogScript writes a hook that appends its phase and filesystem to log.
+func hookLogScript(t *testing.T, dir, log string) hooks.List {
+	path := filepath.Join(dir, "hook.sh")
+	script := "#!/bin/sh\necho \"$ZREPL_HOOKTYPE $ZREPL_FS\" >> " + log + "\n"
+	require.NoError(t, ioutil.WriteFile(path, []byte(script), 0755))
+	h, err := hooks.ListFromConfig([]config.HookCommand{{Path: path, Timeout: 10 * time.Second}})
+	require.NoError(t, err)
+	return h
+}
+
+func TestSnapshotWithHooksIsolatesPools(t *testing.T) {
+	f := newFakeZFS(t, "a/x", "a/y", "b/z")
+	defer f.install()()
+	noSpace := zfs.ZFSError{
+		Stderr:  []byte("cannot create snapshot 'a/x@zrepl_1': out of space\n"),
+		WaitErr: errors.New("exit status 1"),
+	}
+	f.snapErrs["a/x"] = []error{noSpace, noSpace}
+	dir, err := ioutil.TempDir("", "zrepl-snapper")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	log := filepath.Join(dir, "log")
+
+	s := newTestSnapper(t, intervalSchedule{interval: time.Hour}, noMatchingWarn, missedRunOnce)
+	a := s.args
+	a.ctx = context.Background()
+	a.hooks = hookLogScript(t, dir, log)
+	errs := snapshotWithHooks(a, f.fss, "zrepl_1")
+
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[f.fss[0]].Error(), "out of space")
+	// the failed atomic snapshot of pool a is repeated one by one, pool b is not affected
+	assert.Equal(t, []string{"a/x@zrepl_1", "a/y@zrepl_1", "a/x@zrepl_1", "a/y@zrepl_1", "b/z@zrepl_1"}, f.Attempts())
+	assert.Len(t, f.versions["a/y"], 1)
+	assert.Len(t, f.versions["b/z"], 1)
+
+	out, err := ioutil.ReadFile(log)
+	require.NoError(t, err)
+	// the hooks of pool a are done before those of pool b start
+	assert.Equal(t, []string{
+		"pre_snapshot a/x", "pre_snapshot a/y", "post_snapshot a/x", "post_snapshot a/y",
+		"pre_snapshot b/z", "post_snapshot b/z",
+	}, strings.Split(strings.TrimSpace(string(out)), "\n"))
+}
+
+func TestSnapshotRetriesOnlyFailedSnapshots(t *testing.T) {
+	f := newFakeZFS(t, "a/x", "b/y")
+	defer f.install()()
+	f.snapErrs["b/y"] = []error{zfs.ZFSError{
+		Stderr:  []byte("cannot create snapshot 'b/y@zrepl_1': dataset is busy\n"),
+		WaitErr: errors.New("exit status 1"),
+	}}
+	dir, err := ioutil.TempDir("", "zrepl-snapper")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	log := filepath.Join(dir, "log")
+
+	s := newTestSnapper(t, intervalSchedule{interval: time.Hour}, noMatchingWarn, missedRunOnce)
+	taken := make(chan struct{}, 2)
+	s.args.snapshotsTaken = taken
+	s.args.hooks = hookLogScript(t, dir, log)
+	require.Equal(t, Snapshotting, runState(context.Background(), s, plan))
+	assert.Equal(t, Waiting, runState(context.Background(), s, snapshot))
+
+	// a/x is reported before b/y is retried, and b/y once its retry succeeded
+	assert.Len(t, taken, 2)
+	attempts := f.Attempts()
+	require.Len(t, attempts, 3)
+	assert.Equal(t, []string{"b/y", "b/y"}, []string{
+		strings.SplitN(attempts[1], "@", 2)[0], strings.SplitN(attempts[2], "@", 2)[0],
+	})
+	for fs, progress := range s.plan {
+		assert.Equal(t, SnapDone, progress.state, fs.ToString())
+	}
+	assert.Equal(t, 2, s.plan[f.fss[1]].attempts)
+	// the failed attempt does not count
+	assert.Equal(t, 2, s.SnapshotsCreated())
+
+	out, err := ioutil.ReadFile(log)
+	require.NoError(t, err)
+	// the retry does not repeat the hooks
+	assert.Equal(t, []string{
+		"pre_snapshot a/x", "post_snapshot a/x", "pre_snapshot b/y", "post_snapshot b/y",
+	}, strings.Split(strings.TrimSpace(string(out)), "\n"))
+}
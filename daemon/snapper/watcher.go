@@ -0,0 +1,129 @@
+package snapper
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/filters"
+	"github.com/zrepl/zrepl/zfs"
+	"sync"
+	"time"
+)
+
+// Watcher does not take snapshots itself but polls the filesystems
+// for snapshots created by other tools (e.g. sanoid or cron scripts)
+// and notifies the job whenever a new snapshot appears.
+type Watcher struct {
+	fsf      *filters.DatasetMapFilter
+	prefix   string
+	interval time.Duration
+
+	mtx sync.Mutex
+	// guid of the most recent snapshot per filesystem, nil before the first poll
+	latest     map[string]uint64
+	sleepUntil time.Time
+	err        error
+}
+
+func WatcherFromConfig(g *config.Global, fsf *filters.DatasetMapFilter, in *config.SnapshottingManual) (*Watcher, error) {
+	if in.PollInterval <= 0 {
+		return nil, errors.New("poll_interval must be positive")
+	}
+	return &Watcher{fsf: fsf, prefix: in.Prefix, interval: in.PollInterval}, nil
+}
+
+func (w *Watcher) Run(ctx context.Context, snapshotsTaken chan<- struct{}) {
+	log := getLogger(ctx).WithField("poll_interval", w.interval)
+	log.Debug("start")
+	defer log.Debug("stop")
+
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	for {
+		changed, err := w.poll(log)
+		w.mtx.Lock()
+		w.err = err
+		w.sleepUntil = time.Now().Add(w.interval)
+		w.mtx.Unlock()
+		if err != nil {
+			log.WithError(err).Error("cannot poll filesystem versions")
+		}
+
+		if changed {
+			log.Info("new snapshots detected")
+			select {
+			case snapshotsTaken <- struct{}{}:
+			default:
+				if snapshotsTaken != nil {
+					log.Warn("callback channel is full, discarding snapshot update event")
+				}
+			}
+		}
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// poll returns true if any filesystem has a new most recent snapshot since the previous call.
+// The first call establishes the baseline and never returns true.
+func (w *Watcher) poll(log Logger) (changed bool, err error) {
+	fss, err := listFSes(w.fsf)
+	if err != nil {
+		return false, errors.Wrap(err, "cannot list filesystems")
+	}
+
+	w.mtx.Lock()
+	prev := w.latest
+	w.mtx.Unlock()
+
+	allFsvs := listVersions(log, fss, filters.NewTypedPrefixFilter(w.prefix, zfs.Snapshot))
+
+	latest := make(map[string]uint64, len(fss))
+	for _, fs := range fss {
+		l := log.WithField("fs", fs.ToString())
+		fsvs, ok := allFsvs[fs.ToString()]
+		if !ok {
+			// keep the previous state to avoid spurious wakeups once listing succeeds again
+			if guid, ok := prev[fs.ToString()]; ok {
+				latest[fs.ToString()] = guid
+			}
+			continue
+		}
+		if len(fsvs) == 0 {
+			continue
+		}
+		newest := fsvs[0]
+		for _, v := range fsvs[1:] {
+			if v.CreateTXG > newest.CreateTXG {
+				newest = v
+			}
+		}
+		latest[fs.ToString()] = newest.Guid
+		if prev != nil && prev[fs.ToString()] != newest.Guid {
+			l.WithField("snap", newest.Name).Debug("new snapshot")
+			changed = true
+		}
+	}
+
+	w.mtx.Lock()
+	w.latest = latest
+	w.mtx.Unlock()
+	return changed, nil
+}
+
+func (w *Watcher) Report() *Report {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	r := &Report{
+		State:      "Watching",
+		SleepUntil: w.sleepUntil,
+	}
+	if w.err != nil {
+		r.Error = w.err.Error()
+	}
+	return r
+}
@@ -0,0 +1,95 @@
+package snapper
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/logger"
+	"testing"
+	"time"
+)
+
+func TestWatcherPoll(t *testing.T) {
+	f := newFakeZFS(t, "pool/a", "pool/b")
+	defer f.install()()
+	log := logger.NewTestLogger(t)
+	f.addSnapshot("pool/a", "autosnap_1", time.Unix(1, 0))
+
+	w := &Watcher{prefix: "autosnap_", interval: time.Minute}
+
+	changed, err := w.poll(log)
+	require.NoError(t, err)
+	assert.False(t, changed, "snapshots that exist at the first poll must not trigger replication")
+
+	changed, err = w.poll(log)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	f.addSnapshot("pool/b", "other_1", time.Unix(2, 0))
+	changed, err = w.poll(log)
+	require.NoError(t, err)
+	assert.False(t, changed, "snapshots without the prefix must be ignored")
+
+	f.addSnapshot("pool/b", "autosnap_2", time.Unix(3, 0))
+	changed, err = w.poll(log)
+	require.NoError(t, err)
+	assert.True(t, changed, "the first snapshot of a filesystem is new")
+
+	f.listErr = errors.New("zfs list failed")
+	_, err = w.poll(log)
+	assert.Error(t, err)
+	f.listErr = nil
+
+	changed, err = w.poll(log)
+	require.NoError(t, err)
+	assert.False(t, changed, "a failed poll must not cause a spurious wakeup")
+
+	f.fsListErrs["pool/a"] = errors.New("dataset is busy")
+	f.addSnapshot("pool/b", "autosnap_3", time.Unix(4, 0))
+	changed, err = w.poll(log)
+	require.NoError(t, err)
+	assert.True(t, changed, "a filesystem that cannot be listed must not hide the others")
+	delete(f.fsListErrs, "pool/a")
+
+	changed, err = w.poll(log)
+	require.NoError(t, err)
+	assert.False(t, changed, "a filesystem that could not be listed must not cause a spurious wakeup")
+
+	f.addSnapshot("pool/a", "autosnap_4", time.Unix(5, 0))
+	changed, err = w.poll(log)
+	require.NoError(t, err)
+	assert.True(t, changed)
+}
+
+func TestWatcherRunSignalsNewSnapshots(t *testing.T) {
+	f := newFakeZFS(t, "pool/a")
+	defer f.install()()
+
+	w := &Watcher{interval: 10 * time.Millisecond}
+	ctx, cancel := context.WithCancel(WithLogger(context.Background(), logger.NewTestLogger(t)))
+	defer cancel()
+	snapshotsTaken := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Run(ctx, snapshotsTaken)
+	}()
+
+	select {
+	case <-snapshotsTaken:
+		t.Fatal("no snapshot was created")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, "Watching", w.Report().State)
+
+	f.addSnapshot("pool/a", "external_1", time.Now())
+	select {
+	case <-snapshotsTaken:
+	case <-time.After(5 * time.Second):
+		t.Fatal("new snapshot was not signalled")
+	}
+
+	cancel()
+	<-done
+}
@@ -3,6 +3,7 @@ package streamrpcconfig
 import (
 	"github.com/problame/go-streamrpc"
 	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/transport"
 )
 
 func FromDaemonConfig(g *config.Global, in *config.RPCConfig) (*streamrpc.ConnConfig, error) {
@@ -23,3 +24,11 @@ func FromDaemonConfig(g *config.Global, in *config.RPCConfig) (*streamrpc.ConnCo
 	}
 	return srpcConf, nil
 }
+
+// Negotiate returns the config for a connection whose peer sent peerExtensions in the handshake:
+// a copy of conf that sends stream frames no larger than the peer accepts, see transport.NegotiateFrameSize.
+func Negotiate(conf *streamrpc.ConnConfig, peerExtensions []string) *streamrpc.ConnConfig {
+	negotiated := *conf
+	negotiated.TxChunkSize = transport.NegotiateFrameSize(conf.TxChunkSize, peerExtensions)
+	return &negotiated
+}
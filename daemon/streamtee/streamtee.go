@@ -0,0 +1,169 @@
+// Package streamtee copies the send streams of replication steps to local files or commands,
+// e.g. to keep zfs send archives for backup verification while replicating.
+package streamtee
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/replication/fsrep"
+	"github.com/zrepl/zrepl/replication/pdu"
+	"github.com/zrepl/zrepl/util"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	EnvFS   = "ZREPL_FS"
+	EnvFrom = "ZREPL_FROM"
+	EnvTo   = "ZREPL_TO"
+)
+
+// commandPrefix marks a tee_to that is a command instead of a directory.
+const commandPrefix = "|"
+
+// Tee is a fsrep.StreamTee.
+type Tee struct {
+	// exactly one is set
+	dir     string
+	command string
+}
+
+var _ fsrep.StreamTee = (*Tee)(nil)
+
+// FromConfig returns nil if in does not configure a tee.
+func FromConfig(in config.SendOptions) (*Tee, error) {
+	if in.TeeTo == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(in.TeeTo, commandPrefix) {
+		command := strings.TrimSpace(strings.TrimPrefix(in.TeeTo, commandPrefix))
+		if !filepath.IsAbs(command) {
+			return nil, errors.Errorf("tee command must be an absolute path, got %q", command)
+		}
+		return &Tee{command: command}, nil
+	}
+	if !filepath.IsAbs(in.TeeTo) {
+		return nil, errors.Errorf("tee directory must be an absolute path, got %q", in.TeeTo)
+	}
+	return &Tee{dir: in.TeeTo}, nil
+}
+
+func (t *Tee) String() string {
+	if t.command != "" {
+		return commandPrefix + " " + t.command
+	}
+	return t.dir
+}
+
+// FileName returns the name of the file to which the stream of r is copied,
+// e.g. pool%2Fdata@a..@b.zfs for an incremental stream of pool/data from @a to @b.
+func FileName(r *pdu.SendReq) string {
+	name := url.PathEscape(r.Filesystem)
+	if r.From != "" {
+		name += r.From + ".."
+	}
+	return name + r.To + ".zfs"
+}
+
+func (t *Tee) Open(ctx context.Context, r *pdu.SendReq) (util.TeeTarget, error) {
+	if t.command != "" {
+		return openCommand(ctx, t.command, r)
+	}
+	return openFile(t.dir, FileName(r))
+}
+
+// fileTarget writes to a temporary file that is renamed to its final name on commit,
+// so that a complete-looking file always contains a complete stream.
+type fileTarget struct {
+	*os.File
+	path string
+}
+
+func openFile(dir, name string) (*fileTarget, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, name)
+	f, err := os.OpenFile(path+".part", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileTarget{f, path}, nil
+}
+
+func (f *fileTarget) Commit() error {
+	err := f.File.Sync()
+	if cerr := f.File.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.File.Name(), f.path)
+	}
+	if err != nil {
+		os.Remove(f.File.Name())
+	}
+	return err
+}
+
+func (f *fileTarget) Abort() {
+	f.File.Close()
+	os.Remove(f.File.Name())
+}
+
+// commandTarget writes to the standard input of a command, which must exit successfully on commit.
+type commandTarget struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	output bytes.Buffer
+}
+
+func openCommand(ctx context.Context, path string, r *pdu.SendReq) (*commandTarget, error) {
+	t := &commandTarget{cmd: exec.CommandContext(ctx, path)}
+	t.cmd.Env = os.Environ()
+	t.cmd.Env = append(t.cmd.Env, fmt.Sprintf("%s=%s", EnvFS, r.Filesystem))
+	t.cmd.Env = append(t.cmd.Env, fmt.Sprintf("%s=%s", EnvFrom, r.From))
+	t.cmd.Env = append(t.cmd.Env, fmt.Sprintf("%s=%s", EnvTo, r.To))
+	t.cmd.Stdout = &t.output
+	t.cmd.Stderr = &t.output
+	var err error
+	if t.stdin, err = t.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if err := t.cmd.Start(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *commandTarget) Write(p []byte) (int, error) {
+	n, err := t.stdin.Write(p)
+	if err != nil {
+		// most likely, the command exited early, its output tells why
+		t.Abort()
+		return n, errors.Errorf("%s: %s\noutput:\n%s", t.cmd.Path, err, t.output.Bytes())
+	}
+	return n, nil
+}
+
+func (t *commandTarget) Commit() error {
+	t.stdin.Close()
+	if err := t.cmd.Wait(); err != nil {
+		return errors.Errorf("%s: %s\noutput:\n%s", t.cmd.Path, err, t.output.Bytes())
+	}
+	return nil
+}
+
+func (t *commandTarget) Abort() {
+	t.stdin.Close()
+	if t.cmd.ProcessState == nil {
+		t.cmd.Process.Kill()
+		t.cmd.Wait()
+	}
+}
@@ -0,0 +1,96 @@
+package streamtee
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/replication/pdu"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFromConfig(t *testing.T) {
+	tee, err := FromConfig(config.SendOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, tee)
+
+	tee, err = FromConfig(config.SendOptions{TeeTo: "/var/backups/zrepl"})
+	require.NoError(t, err)
+	assert.Equal(t, &Tee{dir: "/var/backups/zrepl"}, tee)
+
+	tee, err = FromConfig(config.SendOptions{TeeTo: "| /usr/local/bin/archive"})
+	require.NoError(t, err)
+	assert.Equal(t, &Tee{command: "/usr/local/bin/archive"}, tee)
+
+	_, err = FromConfig(config.SendOptions{TeeTo: "backups"})
+	assert.Error(t, err)
+	_, err = FromConfig(config.SendOptions{TeeTo: "|archive"})
+	assert.Error(t, err)
+}
+
+func TestFileName(t *testing.T) {
+	assert.Equal(t, "pool%2Fdata@b.zfs", FileName(&pdu.SendReq{Filesystem: "pool/data", To: "@b"}))
+	assert.Equal(t, "pool%2Fdata@a..@b.zfs", FileName(&pdu.SendReq{Filesystem: "pool/data", From: "@a", To: "@b"}))
+	assert.Equal(t, "pool%2Fdata#a..@b.zfs", FileName(&pdu.SendReq{Filesystem: "pool/data", From: "#a", To: "@b"}))
+}
+
+func TestFileTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrepl-streamtee-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	tee := &Tee{dir: filepath.Join(dir, "archive")}
+	r := &pdu.SendReq{Filesystem: "pool/data", To: "@b"}
+
+	target, err := tee.Open(context.Background(), r)
+	require.NoError(t, err)
+	_, err = target.Write([]byte("stream"))
+	require.NoError(t, err)
+	target.Abort()
+	entries, err := ioutil.ReadDir(tee.dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "an aborted copy must not leave a file")
+
+	target, err = tee.Open(context.Background(), r)
+	require.NoError(t, err)
+	_, err = target.Write([]byte("stream"))
+	require.NoError(t, err)
+	require.NoError(t, target.Commit())
+	b, err := ioutil.ReadFile(filepath.Join(tee.dir, FileName(r)))
+	require.NoError(t, err)
+	assert.Equal(t, "stream", string(b))
+}
+
+func TestCommandTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrepl-streamtee-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "archive.sh")
+	body := `#!/bin/sh
+echo "$ZREPL_FS $ZREPL_FROM $ZREPL_TO" > ` + out + `
+cat >> ` + out + `
+`
+	require.NoError(t, ioutil.WriteFile(script, []byte(body), 0700))
+
+	tee := &Tee{command: script}
+	target, err := tee.Open(context.Background(), &pdu.SendReq{Filesystem: "pool/data", From: "@a", To: "@b"})
+	require.NoError(t, err)
+	_, err = target.Write([]byte("stream"))
+	require.NoError(t, err)
+	require.NoError(t, target.Commit())
+	b, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "pool/data @a @b\nstream", string(b))
+
+	failing := filepath.Join(dir, "fail.sh")
+	require.NoError(t, ioutil.WriteFile(failing, []byte("#!/bin/sh\ncat > /dev/null\nexit 1\n"), 0700))
+	target, err = (&Tee{command: failing}).Open(context.Background(), &pdu.SendReq{Filesystem: "pool/data", To: "@b"})
+	require.NoError(t, err)
+	_, err = target.Write([]byte("stream"))
+	require.NoError(t, err)
+	assert.Error(t, target.Commit())
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/zrepl/zrepl/config"
 	"net"
+	"time"
 )
 
 type TCPConnecter struct {
@@ -12,13 +13,22 @@ type TCPConnecter struct {
 }
 
 func TCPConnecterFromConfig(in *config.TCPConnect) (*TCPConnecter, error) {
-	dialer := net.Dialer{
-		Timeout: in.DialTimeout,
-	}
-
+	dialer := newDialer(in.DialTimeout, in.KeepAlive)
 	return &TCPConnecter{in.Address, dialer}, nil
 }
 
+// newDialer returns a dialer whose connections send TCP keepalive probes every keepAlive,
+// a keepAlive of 0 disables them instead of falling back to the defaults of Go or the OS.
+func newDialer(dialTimeout, keepAlive time.Duration) net.Dialer {
+	if keepAlive == 0 {
+		keepAlive = -1
+	}
+	return net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: keepAlive,
+	}
+}
+
 func (c *TCPConnecter) Connect(dialCtx context.Context) (conn net.Conn, err error) {
 	return c.dialer.DialContext(dialCtx, "tcp", c.Address)
 }
@@ -7,25 +7,25 @@ import (
 	"github.com/zrepl/zrepl/config"
 	"github.com/zrepl/zrepl/tlsconf"
 	"net"
+	"time"
 )
 
 type TLSConnecter struct {
-	Address   string
-	dialer    net.Dialer
-	tlsConfig *tls.Config
+	Address          string
+	dialer           net.Dialer
+	tlsConfig        *tls.Config
+	handshakeTimeout time.Duration
 }
 
 func TLSConnecterFromConfig(in *config.TLSConnect) (*TLSConnecter, error) {
-	dialer := net.Dialer{
-		Timeout: in.DialTimeout,
-	}
+	dialer := newDialer(in.DialTimeout, in.KeepAlive)
 
 	ca, err := tlsconf.ParseCAFile(in.Ca)
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot parse ca file")
 	}
 
-	cert, err := tls.LoadX509KeyPair(in.Cert, in.Key)
+	cert, err := tlsconf.NewCertReloader(in.Cert, in.Key)
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot parse cert/key pair")
 	}
@@ -35,7 +35,7 @@ func TLSConnecterFromConfig(in *config.TLSConnect) (*TLSConnecter, error) {
 		return nil, errors.Wrap(err, "cannot build tls config")
 	}
 
-	return &TLSConnecter{in.Address, dialer, tlsConfig}, nil
+	return &TLSConnecter{in.Address, dialer, tlsConfig, in.HandshakeTimeout}, nil
 }
 
 func (c *TLSConnecter) Connect(dialCtx context.Context) (conn net.Conn, err error) {
@@ -43,5 +43,24 @@ func (c *TLSConnecter) Connect(dialCtx context.Context) (conn net.Conn, err erro
 	if err != nil {
 		return nil, err
 	}
-	return tls.Client(conn, c.tlsConfig), nil
+	tlsConn := tls.Client(conn, c.tlsConfig)
+	// handshake eagerly to detect an unresponsive server within handshakeTimeout,
+	// instead of blocking in the first Read or Write of the RPC layer
+	deadline := time.Now().Add(c.handshakeTimeout)
+	if dl, ok := dialCtx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	if err := tlsConn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "tls handshake")
+	}
+	if err := tlsConn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
 }
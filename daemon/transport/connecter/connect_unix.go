@@ -0,0 +1,27 @@
+package connecter
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/zrepl/zrepl/config"
+	"net"
+)
+
+type UnixConnecter struct {
+	Path   string
+	dialer net.Dialer
+}
+
+func UnixConnecterFromConfig(in *config.UnixConnect) (*UnixConnecter, error) {
+	if in.Path == "" {
+		return nil, errors.New("field 'path' must not be empty")
+	}
+	dialer := net.Dialer{
+		Timeout: in.DialTimeout,
+	}
+	return &UnixConnecter{in.Path, dialer}, nil
+}
+
+func (c *UnixConnecter) Connect(dialCtx context.Context) (conn net.Conn, err error) {
+	return c.dialer.DialContext(dialCtx, "unix", c.Path)
+}
@@ -5,15 +5,28 @@ import (
 	"fmt"
 	"github.com/problame/go-streamrpc"
 	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/job/usage"
 	"github.com/zrepl/zrepl/daemon/streamrpcconfig"
 	"github.com/zrepl/zrepl/daemon/transport"
+	"github.com/zrepl/zrepl/version"
 	"net"
+	"sync"
 	"time"
 )
 
 
 type HandshakeConnecter struct {
 	connecter streamrpc.Connecter
+	// checked against the features advertised by the peer
+	required []transport.RequiredFeature
+	// as configured, frameMax is advertised to the peer, see transport.ExtensionFrameMax
+	frameSize, frameMax uint32
+	// the config of the client that uses this connecter, may be nil.
+	// On every connection, its ConnConfig is replaced by a copy with the frame size negotiated with the peer as TxChunkSize,
+	// so that the ConnConfig of a previous connection of the client is never modified.
+	client *streamrpc.ClientConfig
+	// records the features advertised by the peer on every connection, may be nil
+	features *peerFeatures
 }
 
 func (c HandshakeConnecter) Connect(ctx context.Context) (net.Conn, error) {
@@ -25,11 +38,59 @@ func (c HandshakeConnecter) Connect(ctx context.Context) (net.Conn, error) {
 	if !ok {
 		dl = time.Now().Add(10 * time.Second) // FIXME constant
 	}
-	if err := transport.DoHandshakeCurrentVersion(conn, dl); err != nil {
+	ours := transport.FeatureExtensions(transport.Features)
+	if c.frameMax > 0 {
+		ours = append(ours, transport.FrameMaxExtensions(c.frameMax)...)
+	}
+	ours = append(ours, transport.VersionExtensions(version.NewZreplVersionInformation().Version)...)
+	theirs, begin, err := transport.DoHandshakeWithTime(conn, dl, transport.ProtocolVersion, ours)
+	if err != nil {
 		conn.Close()
 		return nil, err
 	}
-	return conn, nil
+	hc := &HandshakeConn{
+		Conn:           conn,
+		PeerExtensions: theirs,
+		PeerVersion:    transport.VersionFromExtensions(theirs),
+	}
+	if skew, ok := transport.ClockSkew(theirs, begin, time.Now()); ok {
+		hc.PeerClockSkew = &skew
+	}
+	if err := transport.CheckFeatures(theirs, c.required); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if c.features != nil {
+		c.features.set(transport.FeaturesFromExtensions(theirs))
+	}
+	hc.FrameSize = transport.NegotiateFrameSize(c.frameSize, theirs)
+	if c.client != nil {
+		// the client sets up the connection with its config after Connect returned
+		connConf := *c.client.ConnConfig
+		connConf.TxChunkSize = hc.FrameSize
+		c.client.ConnConfig = &connConf
+	}
+	return hc, nil
+}
+
+// HandshakeConn is a connection on which the protocol handshake succeeded.
+type HandshakeConn struct {
+	net.Conn
+	PeerExtensions []string
+	// the size of the stream frames sent on the connection, negotiated in the handshake
+	FrameSize uint32
+	// as advertised in the handshake, empty if the peer did not
+	PeerVersion string
+	// estimated from the time advertised in the handshake, nil if the peer did not
+	PeerClockSkew *time.Duration
+}
+
+// UnwrapConn returns the transport connection below a *HandshakeConn, e.g. a *tls.Conn.
+func UnwrapConn(conn net.Conn) net.Conn {
+	if hc, ok := conn.(*HandshakeConn); ok {
+		return hc.Conn
+	}
+	return conn
 }
 
 
@@ -39,20 +100,29 @@ func FromConfig(g *config.Global, in config.ConnectEnum) (*ClientFactory, error)
 		connecter            streamrpc.Connecter
 		errConnecter, errRPC error
 		connConf             *streamrpc.ConnConfig
+		transportName, peer  string
 	)
 	switch v := in.Ret.(type) {
 	case *config.SSHStdinserverConnect:
 		connecter, errConnecter = SSHStdinserverConnecterFromConfig(v)
 		connConf, errRPC = streamrpcconfig.FromDaemonConfig(g, v.RPC)
+		transportName, peer = "ssh+stdinserver", fmt.Sprintf("%s@%s", v.User, v.Host)
 	case *config.TCPConnect:
 		connecter, errConnecter = TCPConnecterFromConfig(v)
 		connConf, errRPC = streamrpcconfig.FromDaemonConfig(g, v.RPC)
+		transportName, peer = "tcp", v.Address
 	case *config.TLSConnect:
 		connecter, errConnecter = TLSConnecterFromConfig(v)
 		connConf, errRPC = streamrpcconfig.FromDaemonConfig(g, v.RPC)
+		transportName, peer = "tls", v.Address
+	case *config.UnixConnect:
+		connecter, errConnecter = UnixConnecterFromConfig(v)
+		connConf, errRPC = streamrpcconfig.FromDaemonConfig(g, v.RPC)
+		transportName, peer = "unix", v.Path
 	case *config.LocalConnect:
 		connecter, errConnecter = LocalConnecterFromConfig(v)
 		connConf, errRPC = streamrpcconfig.FromDaemonConfig(g, v.RPC)
+		transportName, peer = "local", v.ListenerName
 	default:
 		panic(fmt.Sprintf("implementation error: unknown connecter type %T", v))
 	}
@@ -69,16 +139,144 @@ func FromConfig(g *config.Global, in config.ConnectEnum) (*ClientFactory, error)
 		return nil, err
 	}
 
-	connecter = HandshakeConnecter{connecter}
+	features := &peerFeatures{}
+	connecter = HandshakeConnecter{
+		connecter: connecter,
+		frameSize: connConf.TxChunkSize,
+		frameMax:  connConf.RxStreamMaxChunkSize,
+		features:  features,
+	}
+
+	return &ClientFactory{connecter: connecter, config: &config, transport: transportName, peer: peer, features: features}, nil
+}
+
+// peerFeatures are the features advertised by the peer in the most recent handshake.
+type peerFeatures struct {
+	mtx      sync.Mutex
+	features map[transport.Feature]bool
+}
+
+func (p *peerFeatures) set(features map[transport.Feature]bool) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.features = features
+}
 
-	return &ClientFactory{connecter: connecter, config: &config}, nil
+func (p *peerFeatures) get() map[transport.Feature]bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.features
 }
 
 type ClientFactory struct {
 	connecter streamrpc.Connecter
 	config    *streamrpc.ClientConfig
+	transport string
+	peer      string
+	features  *peerFeatures
+}
+
+// PeerFeatures returns the features advertised by the peer in the most recent handshake of a client of f,
+// nil if no client connected yet. The result must not be modified.
+func (f ClientFactory) PeerFeatures() map[transport.Feature]bool {
+	if f.features == nil {
+		return nil
+	}
+	return f.features.get()
+}
+
+// RequirePeerFeatures makes connections of clients created afterwards fail
+// if the peer does not advertise all required features in the handshake.
+func (f *ClientFactory) RequirePeerFeatures(required []transport.RequiredFeature) {
+	if hc, ok := f.connecter.(HandshakeConnecter); ok {
+		hc.required = required
+		f.connecter = hc
+	}
+}
+
+// Peer returns the address of the remote endpoint as configured, e.g. user@host for ssh+stdinserver.
+func (f ClientFactory) Peer() string {
+	return f.peer
+}
+
+// CallTimeout is the timeout for control RPCs of clients created by f, see endpoint.Remote.WithCallTimeout.
+func (f ClientFactory) CallTimeout() time.Duration {
+	return f.config.ConnConfig.Timeout
 }
 
 func (f ClientFactory) NewClient() (*streamrpc.Client, error) {
-	return streamrpc.NewClient(f.connecter, f.config)
+	return f.newClient(nil)
+}
+
+// newClient creates a client with its own copy of the config, so that the frame size
+// can be negotiated per connection, see HandshakeConnecter. wrap may be nil.
+func (f ClientFactory) newClient(wrap func(c streamrpc.Connecter) streamrpc.Connecter) (*streamrpc.Client, error) {
+	config := *f.config
+	connecter := f.connecter
+	if hc, ok := connecter.(HandshakeConnecter); ok {
+		hc.client = &config
+		connecter = hc
+	}
+	if wrap != nil {
+		connecter = wrap(connecter)
+	}
+	return streamrpc.NewClient(connecter, &config)
+}
+
+// ConnObserver is called for every connection established by a client,
+// after the transport-level connection and the protocol handshake succeeded.
+// connectTime is the time spent on both.
+type ConnObserver func(conn net.Conn, connectTime time.Duration)
+
+type observingConnecter struct {
+	connecter streamrpc.Connecter
+	observe   ConnObserver
+}
+
+func (c observingConnecter) Connect(ctx context.Context) (net.Conn, error) {
+	begin := time.Now()
+	conn, err := c.connecter.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.observe(conn, time.Since(begin))
+	return conn, nil
+}
+
+// ConnInfo describes conn, which must have been established by a client of this factory.
+func (f ClientFactory) ConnInfo(conn net.Conn, connectTime time.Duration) *ConnInfo {
+	return newConnInfo(f.transport, f.peer, conn, connectTime)
+}
+
+// NewObservedClient is like NewClient, but calls observe for every connection of the returned client.
+func (f ClientFactory) NewObservedClient(observe ConnObserver) (*streamrpc.Client, error) {
+	return f.newClient(func(c streamrpc.Connecter) streamrpc.Connecter {
+		return observingConnecter{c, observe}
+	})
+}
+
+type trackingConnecter struct {
+	connecter streamrpc.Connecter
+	usage     *usage.Tracker
+	bufferLimit int64
+}
+
+func (c trackingConnecter) Connect(ctx context.Context) (net.Conn, error) {
+	conn, err := c.connecter.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.usage.TrackConn(conn, c.bufferLimit), nil
+}
+
+// NewTrackedClient is like NewObservedClient, but also accounts the connections of the returned client in u.
+// The accounted buffer size is that of the configured frame size, the negotiated one is at most as large.
+func (f ClientFactory) NewTrackedClient(observe ConnObserver, u *usage.Tracker) (*streamrpc.Client, error) {
+	return f.newClient(func(c streamrpc.Connecter) streamrpc.Connecter {
+		return trackingConnecter{
+			connecter: observingConnecter{c, observe},
+			usage:     u,
+			bufferLimit: usage.ConnBufferLimitBytes(f.config.ConnConfig),
+		}
+	})
 }
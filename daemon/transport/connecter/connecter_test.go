@@ -0,0 +1,88 @@
+package connecter
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/problame/go-streamrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/daemon/transport"
+	"github.com/zrepl/zrepl/util/socketpair"
+)
+
+// pipeConnecter returns conn on the first Connect.
+type pipeConnecter struct {
+	conn net.Conn
+}
+
+func (c pipeConnecter) Connect(ctx context.Context) (net.Conn, error) {
+	return c.conn, nil
+}
+
+// serveHandshake performs the handshake of a peer that advertises extensions on a new connection,
+// and returns the client side of the connection.
+func serveHandshake(t *testing.T, extensions []string) (client net.Conn, srvErr <-chan error) {
+	srv, client, err := socketpair.SocketPair()
+	require.NoError(t, err)
+	errCh := make(chan error, 1)
+	go func() {
+		defer srv.Close()
+		_, err := transport.DoHandshake(srv, time.Now().Add(2*time.Second), transport.ProtocolVersion, extensions)
+		errCh <- err
+	}()
+	return client, errCh
+}
+
+func TestHandshakeConnecterRecordsPeerFeatures(t *testing.T) {
+	features := &peerFeatures{}
+	f := ClientFactory{features: features}
+	assert.Nil(t, f.PeerFeatures())
+
+	client, srvErr := serveHandshake(t, transport.FeatureExtensions([]transport.Feature{transport.FeatureResume}))
+	c := HandshakeConnecter{connecter: pipeConnecter{client}, features: features}
+	conn, err := c.Connect(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, <-srvErr)
+	assert.Equal(t, map[transport.Feature]bool{transport.FeatureResume: true}, f.PeerFeatures())
+
+	// the peer was downgraded
+	client, srvErr = serveHandshake(t, nil)
+	c.connecter = pipeConnecter{client}
+	conn, err = c.Connect(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, <-srvErr)
+	assert.Empty(t, f.PeerFeatures())
+}
+
+func TestHandshakeConnecterNegotiatesFramePerConnection(t *testing.T) {
+	base := &streamrpc.ConnConfig{TxChunkSize: 1 << 20}
+	// the client's copy of the factory's config, as set up by newClient
+	config := streamrpc.ClientConfig{ConnConfig: base}
+	hc := HandshakeConnecter{frameSize: base.TxChunkSize, client: &config}
+
+	conn, srvErr := serveHandshake(t, transport.FrameMaxExtensions(1<<16))
+	hc.connecter = pipeConnecter{conn}
+	c, err := hc.Connect(context.Background())
+	require.NoError(t, err)
+	defer c.Close()
+	require.NoError(t, <-srvErr)
+	first := config.ConnConfig
+	assert.Equal(t, uint32(1<<16), first.TxChunkSize)
+
+	// a reconnect to a peer with another limit
+	conn, srvErr = serveHandshake(t, transport.FrameMaxExtensions(1<<17))
+	hc.connecter = pipeConnecter{conn}
+	c, err = hc.Connect(context.Background())
+	require.NoError(t, err)
+	defer c.Close()
+	require.NoError(t, <-srvErr)
+	assert.Equal(t, uint32(1<<17), config.ConnConfig.TxChunkSize)
+	assert.Equal(t, uint32(1<<16), first.TxChunkSize, "the config of the previous connection must not change")
+	assert.Equal(t, uint32(1<<20), base.TxChunkSize, "the factory's config must not change")
+}
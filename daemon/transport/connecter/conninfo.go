@@ -0,0 +1,81 @@
+package connecter
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/zrepl/zrepl/daemon/transport"
+	"net"
+	"sort"
+	"time"
+)
+
+// ConnInfo describes an established client connection, for display in status and ping.
+type ConnInfo struct {
+	// ssh+stdinserver, tcp, tls or local
+	Transport  string
+	RemoteAddr string
+	// The peer as configured (ssh user@host, tcp or tls address, local listener name),
+	// for TLS the common name of the verified server certificate.
+	Peer            string
+	ProtocolVersion int
+	// empty if the transport is not TLS
+	TLSVersion, TLSCipherSuite string
+	ConnectedAt                time.Time
+	// transport connection setup and protocol handshake
+	ConnectTime time.Duration
+	// scrub or resilver on the peer's pool as advertised in the handshake, empty if none
+	PeerPoolBusy string
+	// protocol features advertised by the peer in the handshake, sorted
+	PeerFeatures []string
+	// size of the stream frames sent to the peer, negotiated in the handshake, 0 if unknown
+	FrameSize uint32
+	// zrepl version of the peer as advertised in the handshake, empty if unknown
+	PeerVersion string
+	// by how much the peer's clock is ahead of ours, nil if unknown
+	PeerClockSkew *time.Duration
+}
+
+func newConnInfo(transportName, peer string, conn net.Conn, connectTime time.Duration) *ConnInfo {
+	i := &ConnInfo{
+		Transport:       transportName,
+		Peer:            peer,
+		ProtocolVersion: transport.ProtocolVersion,
+		ConnectedAt:     time.Now(),
+		ConnectTime:     connectTime,
+	}
+	if addr := conn.RemoteAddr(); addr != nil {
+		i.RemoteAddr = addr.String()
+	}
+	if hc, ok := conn.(*HandshakeConn); ok {
+		i.PeerPoolBusy = transport.PoolBusyFromExtensions(hc.PeerExtensions)
+		for f := range transport.FeaturesFromExtensions(hc.PeerExtensions) {
+			i.PeerFeatures = append(i.PeerFeatures, string(f))
+		}
+		sort.Strings(i.PeerFeatures)
+		i.FrameSize = hc.FrameSize
+		i.PeerVersion = hc.PeerVersion
+		i.PeerClockSkew = hc.PeerClockSkew
+	}
+	if tlsConn, ok := UnwrapConn(conn).(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		i.TLSVersion = TLSVersionString(state.Version)
+		i.TLSCipherSuite = fmt.Sprintf("0x%04x", state.CipherSuite)
+		if len(state.PeerCertificates) > 0 {
+			i.Peer = state.PeerCertificates[0].Subject.CommonName
+		}
+	}
+	return i
+}
+
+func TLSVersionString(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	default:
+		return fmt.Sprintf("TLS version 0x%04x", v)
+	}
+}
@@ -0,0 +1,109 @@
+package connecter
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/daemon/transport"
+)
+
+func TestConnInfoHandshakeConn(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	skew := 3 * time.Second
+	hc := &HandshakeConn{
+		Conn: a,
+		PeerExtensions: []string{
+			transport.ExtensionPoolBusy + "scrub",
+			transport.ExtensionFeature + string(transport.FeatureResume),
+			transport.ExtensionFeature + string(transport.FeatureCompressedSend),
+		},
+		FrameSize:     1 << 16,
+		PeerVersion:   "v0.1.0",
+		PeerClockSkew: &skew,
+	}
+	i := newConnInfo("tcp", "backup:8888", hc, time.Second)
+
+	assert.Equal(t, "tcp", i.Transport)
+	assert.Equal(t, "backup:8888", i.Peer)
+	assert.Equal(t, transport.ProtocolVersion, i.ProtocolVersion)
+	assert.Equal(t, time.Second, i.ConnectTime)
+	assert.Equal(t, "scrub", i.PeerPoolBusy)
+	assert.Equal(t, []string{string(transport.FeatureCompressedSend), string(transport.FeatureResume)}, i.PeerFeatures)
+	assert.Equal(t, uint32(1<<16), i.FrameSize)
+	assert.Equal(t, "v0.1.0", i.PeerVersion)
+	require.NotNil(t, i.PeerClockSkew)
+	assert.Equal(t, skew, *i.PeerClockSkew)
+	assert.Empty(t, i.TLSVersion)
+	assert.Empty(t, i.TLSCipherSuite)
+}
+
+func TestConnInfoPlainConn(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	i := newConnInfo("local", "sink", a, 0)
+	assert.Equal(t, "local", i.Transport)
+	assert.Equal(t, "sink", i.Peer)
+	assert.Empty(t, i.PeerFeatures)
+	assert.Nil(t, i.PeerClockSkew)
+	assert.Empty(t, i.TLSVersion)
+}
+
+func testCertificate(t *testing.T, cn string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestConnInfoTLS(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	server := tls.Server(b, &tls.Config{
+		Certificates: []tls.Certificate{testCertificate(t, "backup.example.com")},
+		MaxVersion:   tls.VersionTLS12,
+	})
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Handshake()
+	}()
+	client := tls.Client(a, &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, client.Handshake())
+	require.NoError(t, <-serverErr)
+
+	i := newConnInfo("tls", "backup:8888", &HandshakeConn{Conn: client}, 0)
+	assert.Equal(t, "TLS 1.2", i.TLSVersion)
+	assert.Equal(t, "backup.example.com", i.Peer)
+	assert.NotEmpty(t, i.TLSCipherSuite)
+}
+
+func TestTLSVersionString(t *testing.T) {
+	assert.Equal(t, "TLS 1.0", TLSVersionString(tls.VersionTLS10))
+	assert.Equal(t, "TLS 1.1", TLSVersionString(tls.VersionTLS11))
+	assert.Equal(t, "TLS 1.2", TLSVersionString(tls.VersionTLS12))
+	assert.Equal(t, "TLS version 0x0305", TLSVersionString(0x0305))
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"strings"
 	"time"
 	"unicode/utf8"
@@ -100,37 +101,262 @@ func (m *HandshakeMessage) DecodeReader(r io.Reader, maxLen int) error {
 	return nil
 }
 
+// ProtocolVersion is the current protocol version, both sides must use the same.
+const ProtocolVersion = 2
+
+// ExtensionPoolBusy is sent by a receiver whose pool is busy with a scrub or resilver,
+// e.g. POOL_BUSY=resilver. Peers that do not know it ignore it.
+const ExtensionPoolBusy = "POOL_BUSY="
+
+// PoolBusyExtensions returns the extensions that advertise activity ("scrub" or "resilver", none if empty).
+func PoolBusyExtensions(activity string) []string {
+	if activity == "" {
+		return nil
+	}
+	return []string{ExtensionPoolBusy + activity}
+}
+
+// PoolBusyFromExtensions returns the activity advertised by the peer's extensions, or "" if none.
+func PoolBusyFromExtensions(extensions []string) string {
+	for _, ext := range extensions {
+		if strings.HasPrefix(ext, ExtensionPoolBusy) {
+			return strings.TrimPrefix(ext, ExtensionPoolBusy)
+		}
+	}
+	return ""
+}
+
+// ExtensionFrameMax advertises the size of the largest stream frame a peer accepts, i.e. its rpc.rx_stream_chunk_max,
+// e.g. FRAME_MAX=262144. Peers that do not know it ignore it.
+const ExtensionFrameMax = "FRAME_MAX="
+
+// FrameMaxExtensions returns the extension that advertises max.
+func FrameMaxExtensions(max uint32) []string {
+	return []string{fmt.Sprintf("%s%d", ExtensionFrameMax, max)}
+}
+
+// FrameMaxFromExtensions returns the frame size limit advertised by the peer's extensions, ok is false if none.
+func FrameMaxFromExtensions(extensions []string) (max uint32, ok bool) {
+	for _, ext := range extensions {
+		if !strings.HasPrefix(ext, ExtensionFrameMax) {
+			continue
+		}
+		v, err := strconv.ParseUint(strings.TrimPrefix(ext, ExtensionFrameMax), 10, 32)
+		if err != nil || v == 0 {
+			return 0, false
+		}
+		return uint32(v), true
+	}
+	return 0, false
+}
+
+// NegotiateFrameSize returns the size of the stream frames to send on a connection:
+// ours (rpc.tx_chunk_size), unless the peer advertised that it only accepts smaller frames.
+func NegotiateFrameSize(ours uint32, peerExtensions []string) uint32 {
+	if max, ok := FrameMaxFromExtensions(peerExtensions); ok && max < ours {
+		return max
+	}
+	return ours
+}
+
+// ExtensionVersion advertises the zrepl version of a peer, e.g. VERSION=v0.1.0,
+// and ExtensionTime its clock when it sent the handshake, in nanoseconds since the Unix epoch.
+// Both are informational, e.g. for zrepl ping, peers that do not know them ignore them.
+const (
+	ExtensionVersion = "VERSION="
+	ExtensionTime    = "TIME="
+)
+
+// VersionExtensions returns the extensions that advertise version, none if version is empty.
+// The time is advertised by DoHandshakeWithTime.
+func VersionExtensions(version string) []string {
+	if version == "" {
+		return nil
+	}
+	return []string{ExtensionVersion + version}
+}
+
+// TimeExtension returns the extension that advertises now.
+func TimeExtension(now time.Time) string {
+	return fmt.Sprintf("%s%d", ExtensionTime, now.UnixNano())
+}
+
+// VersionFromExtensions returns the version advertised by the peer's extensions, or "" if none.
+func VersionFromExtensions(extensions []string) string {
+	for _, ext := range extensions {
+		if strings.HasPrefix(ext, ExtensionVersion) {
+			return strings.TrimPrefix(ext, ExtensionVersion)
+		}
+	}
+	return ""
+}
+
+// ClockSkew estimates by how much the peer's clock is ahead of ours from the time advertised in its extensions,
+// for a handshake that started at begin and completed at end, assuming that the peer sent its message halfway.
+// ok is false if the peer did not advertise its time.
+func ClockSkew(peerExtensions []string, begin, end time.Time) (skew time.Duration, ok bool) {
+	for _, ext := range peerExtensions {
+		if !strings.HasPrefix(ext, ExtensionTime) {
+			continue
+		}
+		nanos, err := strconv.ParseInt(strings.TrimPrefix(ext, ExtensionTime), 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		halfway := begin.Add(end.Sub(begin) / 2)
+		return time.Unix(0, nanos).Sub(halfway), true
+	}
+	return 0, false
+}
+
+// A Feature is an optional part of the protocol that a peer implements.
+// Peers advertise the features they implement as handshake extensions FEATURE=<name>,
+// so that a job whose options need a feature fails with a clear error if the peer lacks it,
+// instead of an unknown-endpoint error or silently ignored request fields.
+type Feature string
+
+const ExtensionFeature = "FEATURE="
+
+const (
+	// SendReq.ResumeToken and Filesystem.ResumeToken, see zfs recv -s
+	FeatureResume Feature = "resume"
+	// SendReq.Compress, LargeBlocks and EmbeddedData and their ReceiveReq counterparts
+	FeatureCompressedSend Feature = "compressed-send"
+	// SendReq.Raw and Encrypted and ReceiveReq.Raw
+	FeatureRawSend Feature = "raw-send"
+	// concurrent connections of one client, see replication.concurrency
+	FeatureMultiStream Feature = "multi-stream"
+	// the SetStepHolds endpoint
+	FeatureStepHolds Feature = "step-holds"
+	// the ResolveDivergence endpoint
+	FeatureResolveDivergence Feature = "resolve-divergence"
+	// the GetProperties and SetProperties endpoints
+	FeatureProperties Feature = "properties"
+	// the SendEstimates endpoint, older senders are asked for the size estimate of each step separately
+	FeatureSendEstimates Feature = "send-estimates"
+)
+
+// Features are the features implemented by this build, both sides of a connection advertise them.
+var Features = []Feature{
+	FeatureResume,
+	FeatureCompressedSend,
+	FeatureRawSend,
+	FeatureMultiStream,
+	FeatureStepHolds,
+	FeatureResolveDivergence,
+	FeatureProperties,
+	FeatureSendEstimates,
+}
+
+// FeatureExtensions returns the extensions that advertise features.
+func FeatureExtensions(features []Feature) []string {
+	exts := make([]string, len(features))
+	for i, f := range features {
+		exts[i] = ExtensionFeature + string(f)
+	}
+	return exts
+}
+
+// FeaturesFromExtensions returns the features advertised by the peer's extensions.
+func FeaturesFromExtensions(extensions []string) map[Feature]bool {
+	features := make(map[Feature]bool)
+	for _, ext := range extensions {
+		if strings.HasPrefix(ext, ExtensionFeature) {
+			features[Feature(strings.TrimPrefix(ext, ExtensionFeature))] = true
+		}
+	}
+	return features
+}
+
+// A RequiredFeature is needed from the peer because of the configuration option By.
+type RequiredFeature struct {
+	Feature Feature
+	By      string
+}
+
+type MissingFeaturesError struct {
+	Missing []RequiredFeature
+}
+
+func (e *MissingFeaturesError) Error() string {
+	missing := make([]string, len(e.Missing))
+	for i, m := range e.Missing {
+		missing[i] = fmt.Sprintf("%s (required by %s)", m.Feature, m.By)
+	}
+	return fmt.Sprintf("peer does not implement protocol features %s, upgrade zrepl on the peer or change the options",
+		strings.Join(missing, ", "))
+}
+
+// CheckFeatures returns a *MissingFeaturesError if the peer's extensions do not advertise all required features.
+func CheckFeatures(peerExtensions []string, required []RequiredFeature) error {
+	theirs := FeaturesFromExtensions(peerExtensions)
+	var missing []RequiredFeature
+	for _, r := range required {
+		if !theirs[r.Feature] {
+			missing = append(missing, r)
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingFeaturesError{missing}
+	}
+	return nil
+}
+
 func DoHandshakeCurrentVersion(conn net.Conn, deadline time.Time) error {
-	// current protocol version is hardcoded here
-	return DoHandshakeVersion(conn, deadline, 1)
+	return DoHandshakeVersion(conn, deadline, ProtocolVersion)
 }
 
 func DoHandshakeVersion(conn net.Conn, deadline time.Time, version int) error {
+	_, err := DoHandshake(conn, deadline, version, nil)
+	return err
+}
+
+// DoHandshake sends our extensions and returns the peer's.
+func DoHandshake(conn net.Conn, deadline time.Time, version int, extensions []string) (theirExtensions []string, err error) {
+	theirExtensions, _, err = doHandshake(conn, deadline, version, extensions, false)
+	return theirExtensions, err
+}
+
+// DoHandshakeWithTime is DoHandshake, but also advertises our clock (see ExtensionTime),
+// read immediately before our message is sent so that the peer's ClockSkew is not skewed by what precedes the handshake.
+// sent is the advertised time.
+func DoHandshakeWithTime(conn net.Conn, deadline time.Time, version int, extensions []string) (theirExtensions []string, sent time.Time, err error) {
+	return doHandshake(conn, deadline, version, extensions, true)
+}
+
+func doHandshake(conn net.Conn, deadline time.Time, version int, extensions []string, withTime bool) (theirExtensions []string, sent time.Time, err error) {
+	conn.SetDeadline(deadline)
 	ours := HandshakeMessage{
 		ProtocolVersion: version,
-		Extensions: nil,
+		Extensions: extensions,
+	}
+	if withTime {
+		sent = time.Now()
+		ours.Extensions = append(append([]string(nil), extensions...), TimeExtension(sent))
 	}
 	hsb, err := ours.Encode()
 	if err != nil {
-		return fmt.Errorf("could not encode protocol banner: %s", err)
+		return nil, sent, fmt.Errorf("could not encode protocol banner: %s", err)
 	}
 
-	conn.SetDeadline(deadline)
 	_, err = io.Copy(conn, bytes.NewBuffer(hsb))
 	if err != nil {
-		return fmt.Errorf("could not send protocol banner: %s", err)
+		return nil, sent, fmt.Errorf("could not send protocol banner: %s", err)
 	}
 
 	theirs := HandshakeMessage{}
 	if err := theirs.DecodeReader(conn, 16 * 4096); err != nil { // FIXME constant
-		return fmt.Errorf("could not decode protocol banner: %s", err)
+		return nil, sent, fmt.Errorf("could not decode protocol banner: %s", err)
 	}
 
 	if theirs.ProtocolVersion != ours.ProtocolVersion {
-		return fmt.Errorf("protocol versions do not match: ours is %d, theirs is %d",
-			ours.ProtocolVersion, theirs.ProtocolVersion)
+		older := "the peer"
+		if ours.ProtocolVersion < theirs.ProtocolVersion {
+			older = "this host"
+		}
+		return nil, sent, fmt.Errorf("protocol versions do not match: ours is %d, theirs is %d, upgrade zrepl on %s",
+			ours.ProtocolVersion, theirs.ProtocolVersion, older)
 	}
-	// ignore extensions, we don't use them
 
-	return nil
+	return theirs.Extensions, sent, nil
 }
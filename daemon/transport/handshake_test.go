@@ -117,3 +117,117 @@ func TestDoHandshakeCurrentVersion(t *testing.T) {
 	assert.Nil(t, <-srvErrCh)
 
 }
+
+func TestDoHandshake_Extensions(t *testing.T) {
+	srv, client, err := socketpair.SocketPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	defer client.Close()
+
+	srvErrCh := make(chan error)
+	go func() {
+		_, err := DoHandshake(srv, time.Now().Add(2*time.Second), ProtocolVersion, PoolBusyExtensions("resilver"))
+		srvErrCh <- err
+	}()
+	theirs, err := DoHandshake(client, time.Now().Add(2*time.Second), ProtocolVersion, nil)
+	require.NoError(t, err)
+	require.NoError(t, <-srvErrCh)
+	assert.Equal(t, "resilver", PoolBusyFromExtensions(theirs))
+	assert.Equal(t, "", PoolBusyFromExtensions(nil))
+}
+
+func TestDoHandshakeWithTime(t *testing.T) {
+	srv, client, err := socketpair.SocketPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	defer client.Close()
+
+	type result struct {
+		sent time.Time
+		err  error
+	}
+	srvRes := make(chan result, 1)
+	ours := VersionExtensions("v0.1.0")
+	before := time.Now()
+	go func() {
+		_, sent, err := DoHandshakeWithTime(srv, time.Now().Add(2*time.Second), ProtocolVersion, ours)
+		srvRes <- result{sent, err}
+	}()
+	theirs, err := DoHandshake(client, time.Now().Add(2*time.Second), ProtocolVersion, nil)
+	require.NoError(t, err)
+	res := <-srvRes
+	require.NoError(t, res.err)
+
+	assert.False(t, res.sent.Before(before))
+	assert.Contains(t, theirs, TimeExtension(res.sent), "the advertised time is the returned one")
+	assert.Equal(t, "v0.1.0", VersionFromExtensions(theirs))
+	assert.Len(t, ours, 1, "the caller's extensions must not be modified")
+}
+
+func TestDoHandshake_Features(t *testing.T) {
+	srv, client, err := socketpair.SocketPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	defer client.Close()
+
+	srvErrCh := make(chan error)
+	go func() {
+		_, err := DoHandshake(srv, time.Now().Add(2*time.Second), ProtocolVersion, FeatureExtensions([]Feature{FeatureResume}))
+		srvErrCh <- err
+	}()
+	theirs, err := DoHandshake(client, time.Now().Add(2*time.Second), ProtocolVersion, FeatureExtensions(Features))
+	require.NoError(t, err)
+	require.NoError(t, <-srvErrCh)
+	assert.Equal(t, map[Feature]bool{FeatureResume: true}, FeaturesFromExtensions(theirs))
+
+	assert.NoError(t, CheckFeatures(theirs, nil))
+	assert.NoError(t, CheckFeatures(theirs, []RequiredFeature{{FeatureResume, "recv.flags -s"}}))
+	err = CheckFeatures(theirs, []RequiredFeature{{FeatureResume, "recv.flags -s"}, {FeatureStepHolds, "replication.step_holds"}})
+	require.IsType(t, &MissingFeaturesError{}, err)
+	assert.Equal(t, []RequiredFeature{{FeatureStepHolds, "replication.step_holds"}}, err.(*MissingFeaturesError).Missing)
+	assert.Contains(t, err.Error(), "step-holds (required by replication.step_holds)")
+}
+
+func TestNegotiateFrameSize(t *testing.T) {
+	max, ok := FrameMaxFromExtensions(append(FeatureExtensions(Features), FrameMaxExtensions(1<<18)...))
+	assert.True(t, ok)
+	assert.Equal(t, uint32(1<<18), max)
+
+	_, ok = FrameMaxFromExtensions(nil)
+	assert.False(t, ok)
+	_, ok = FrameMaxFromExtensions([]string{ExtensionFrameMax + "0"})
+	assert.False(t, ok)
+	_, ok = FrameMaxFromExtensions([]string{ExtensionFrameMax + "huge"})
+	assert.False(t, ok)
+
+	// a peer with less memory gets smaller frames
+	assert.Equal(t, uint32(1<<18), NegotiateFrameSize(1<<22, FrameMaxExtensions(1<<18)))
+	// a peer that accepts larger frames does not change ours
+	assert.Equal(t, uint32(1<<15), NegotiateFrameSize(1<<15, FrameMaxExtensions(1<<24)))
+	// peers that do not advertise a limit
+	assert.Equal(t, uint32(1<<22), NegotiateFrameSize(1<<22, nil))
+}
+
+func TestVersionExtensions(t *testing.T) {
+	begin := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	end := begin.Add(2 * time.Second)
+	// the peer's clock is 5s ahead of ours
+	exts := append(VersionExtensions("v0.1.0"), TimeExtension(begin.Add(6*time.Second)))
+
+	assert.Equal(t, "v0.1.0", VersionFromExtensions(exts))
+	skew, ok := ClockSkew(exts, begin, end)
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, skew)
+
+	// builds without version information only advertise their time
+	assert.Empty(t, VersionExtensions(""))
+	assert.Equal(t, "", VersionFromExtensions(nil))
+	_, ok = ClockSkew(nil, begin, end)
+	assert.False(t, ok)
+}
@@ -0,0 +1,154 @@
+package serve
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/zrepl/zrepl/config"
+	"net"
+	"strings"
+)
+
+// AccessRule allows connections of the listed client identities from the listed networks.
+// An empty list matches any identity or source address, respectively.
+type AccessRule struct {
+	identities map[string]bool
+	networks   []*net.IPNet
+}
+
+func accessRulesFromConfig(in []config.ServeAccessRule) ([]AccessRule, error) {
+	rules := make([]AccessRule, len(in))
+	for i, r := range in {
+		if len(r.ClientIdentities) == 0 && len(r.Networks) == 0 {
+			return nil, errors.Errorf("access rule #%d: must restrict 'client_identities' or 'networks'", i)
+		}
+		rules[i].identities = make(map[string]bool, len(r.ClientIdentities))
+		for _, ci := range r.ClientIdentities {
+			if err := ValidateClientIdentity(ci); err != nil {
+				return nil, errors.Wrapf(err, "access rule #%d: invalid client identity %q", i, ci)
+			}
+			rules[i].identities[ci] = true
+		}
+		for _, n := range r.Networks {
+			ipnet, err := parseNetwork(n)
+			if err != nil {
+				return nil, errors.Wrapf(err, "access rule #%d", i)
+			}
+			rules[i].networks = append(rules[i].networks, ipnet)
+		}
+	}
+	return rules, nil
+}
+
+// parseNetwork parses CIDR notation, or a single IP address
+func parseNetwork(in string) (*net.IPNet, error) {
+	if !strings.Contains(in, "/") {
+		ip := net.ParseIP(in)
+		if ip == nil {
+			return nil, errors.Errorf("cannot parse network %q", in)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipnet, err := net.ParseCIDR(in)
+	if err != nil {
+		return nil, errors.Errorf("cannot parse network %q", in)
+	}
+	return ipnet, nil
+}
+
+// remoteIP returns nil if addr is not an IP address, e.g. for UNIX sockets
+func remoteIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	default:
+		return nil
+	}
+}
+
+func (r AccessRule) allows(clientIdentity string, ip net.IP) bool {
+	if len(r.identities) > 0 && !r.identities[clientIdentity] {
+		return false
+	}
+	if len(r.networks) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, n := range r.networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize returns an error if none of rules allows the connection of clientIdentity from addr.
+func authorize(rules []AccessRule, clientIdentity string, addr net.Addr) error {
+	ip := remoteIP(addr)
+	for _, r := range rules {
+		if r.allows(clientIdentity, ip) {
+			return nil
+		}
+	}
+	return &UnauthorizedError{ClientIdentity: clientIdentity, Addr: addr}
+}
+
+type UnauthorizedError struct {
+	ClientIdentity string
+	Addr           net.Addr
+}
+
+func (e *UnauthorizedError) Error() string {
+	return fmt.Sprintf("client %q from %s is not allowed by any access rule", e.ClientIdentity, e.Addr)
+}
+
+type authorizingListenerFactory struct {
+	lf    ListenerFactory
+	rules []AccessRule
+}
+
+func (f authorizingListenerFactory) Listen() (AuthenticatedListener, error) {
+	l, err := f.lf.Listen()
+	if err != nil {
+		return nil, err
+	}
+	return authorizingListener{l, f.rules}, nil
+}
+
+// authorizingListener rejects the authenticated connections that are not allowed by its access rules
+// before they reach the protocol handshake.
+type authorizingListener struct {
+	l     AuthenticatedListener
+	rules []AccessRule
+}
+
+func (l authorizingListener) Addr() net.Addr { return l.l.Addr() }
+
+func (l authorizingListener) Close() error { return l.l.Close() }
+
+func (l authorizingListener) Accept(ctx context.Context) (AuthenticatedConn, error) {
+	conn, err := l.l.Accept(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(l.rules, conn.ClientIdentity(), conn.RemoteAddr()); err != nil {
+		getLogger(ctx).
+			WithField("audit", "rejected").
+			WithField("client_identity", conn.ClientIdentity()).
+			WithField("addr", conn.RemoteAddr().String()).
+			Warn("rejecting connection not allowed by access rules")
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
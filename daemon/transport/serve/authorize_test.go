@@ -0,0 +1,59 @@
+package serve
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/config"
+	"net"
+	"testing"
+)
+
+func TestAuthorize(t *testing.T) {
+	rules, err := accessRulesFromConfig([]config.ServeAccessRule{
+		{ClientIdentities: []string{"laptop1"}, Networks: []string{"10.0.0.0/8", "fd00::/8"}},
+		{ClientIdentities: []string{"hypervisor1"}},
+		{Networks: []string{"192.168.1.23"}},
+	})
+	require.NoError(t, err)
+
+	tcp := func(ip string) net.Addr { return &net.TCPAddr{IP: net.ParseIP(ip), Port: 4242} }
+	unix := &net.UnixAddr{Name: "/var/run/zrepl/vm.sock", Net: "unix"}
+
+	type tc struct {
+		identity string
+		addr     net.Addr
+		allowed  bool
+	}
+	tcs := []tc{
+		{"laptop1", tcp("10.1.2.3"), true},
+		{"laptop1", tcp("fd00::1"), true},
+		{"laptop1", tcp("172.16.0.1"), false},
+		{"laptop1", unix, false},
+		{"laptop2", tcp("10.1.2.3"), false},
+		{"hypervisor1", unix, true},
+		{"hypervisor1", tcp("172.16.0.1"), true},
+		{"anyone", tcp("192.168.1.23"), true},
+		{"anyone", tcp("192.168.1.24"), false},
+	}
+	for _, c := range tcs {
+		err := authorize(rules, c.identity, c.addr)
+		if c.allowed {
+			assert.NoError(t, err, "%s from %s", c.identity, c.addr)
+		} else {
+			assert.IsType(t, &UnauthorizedError{}, err, "%s from %s", c.identity, c.addr)
+		}
+	}
+}
+
+func TestAccessRulesFromConfig_Invalid(t *testing.T) {
+	invalid := [][]config.ServeAccessRule{
+		{{}},
+		{{Networks: []string{"10.0.0.0/33"}}},
+		{{Networks: []string{"not an ip"}}},
+		{{ClientIdentities: []string{"with/slash"}}},
+	}
+	for _, rules := range invalid {
+		_, err := accessRulesFromConfig(rules)
+		assert.Error(t, err, "%#v", rules)
+	}
+}
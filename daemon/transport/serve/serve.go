@@ -9,7 +9,9 @@ import (
 	"github.com/problame/go-streamrpc"
 	"context"
 	"github.com/zrepl/zrepl/logger"
+	"github.com/zrepl/zrepl/version"
 	"github.com/zrepl/zrepl/zfs"
+	"sort"
 	"time"
 )
 
@@ -75,6 +77,21 @@ type ListenerFactory interface {
 
 type HandshakeListenerFactory struct {
 	lf ListenerFactory
+	// called for every accepted connection, may be nil
+	extensions func() []string
+	// advertised to clients, see transport.ExtensionFrameMax
+	frameMax uint32
+}
+
+// WithHandshakeExtensions makes the listeners of lf, which must have been returned by FromConfig,
+// send the extensions returned by f in the handshake of every accepted connection.
+func WithHandshakeExtensions(lf ListenerFactory, f func() []string) ListenerFactory {
+	hlf, ok := lf.(HandshakeListenerFactory)
+	if !ok {
+		return lf
+	}
+	hlf.extensions = f
+	return hlf
 }
 
 func (lf HandshakeListenerFactory) Listen() (AuthenticatedListener, error) {
@@ -82,11 +99,28 @@ func (lf HandshakeListenerFactory) Listen() (AuthenticatedListener, error) {
 	if err != nil {
 		return nil, err
 	}
-	return HandshakeListener{l}, nil
+	return HandshakeListener{l, lf.extensions, lf.frameMax}, nil
 }
 
 type HandshakeListener struct {
 	l AuthenticatedListener
+	extensions func() []string
+	frameMax   uint32
+}
+
+// handshakeConn is a connection on which the protocol handshake succeeded.
+type handshakeConn struct {
+	AuthenticatedConn
+	peerExtensions []string
+}
+
+// PeerExtensions returns the handshake extensions sent by the client of conn,
+// nil if conn was not accepted by a listener returned by FromConfig.
+func PeerExtensions(conn AuthenticatedConn) []string {
+	if hc, ok := conn.(handshakeConn); ok {
+		return hc.peerExtensions
+	}
+	return nil
 }
 
 func (l HandshakeListener) Addr() (net.Addr) { return l.l.Addr() }
@@ -102,31 +136,70 @@ func (l HandshakeListener) Accept(ctx context.Context) (AuthenticatedConn, error
 	if !ok {
 		dl = time.Now().Add(10*time.Second) // FIXME constant
 	}
-	if err := transport.DoHandshakeCurrentVersion(conn, dl); err != nil {
+	extensions := transport.FeatureExtensions(transport.Features)
+	if l.frameMax > 0 {
+		extensions = append(extensions, transport.FrameMaxExtensions(l.frameMax)...)
+	}
+	extensions = append(extensions, transport.VersionExtensions(version.NewZreplVersionInformation().Version)...)
+	if l.extensions != nil {
+		extensions = append(extensions, l.extensions()...)
+	}
+	theirs, _, err := transport.DoHandshakeWithTime(conn, dl, transport.ProtocolVersion, extensions)
+	if err != nil {
 		conn.Close()
 		return nil, err
 	}
-	return conn, nil
+	return handshakeConn{conn, theirs}, nil
+}
+
+// ConfiguredClientIdentities returns the client identities that serve transport in accepts.
+// restricted is false if in accepts any client identity, i.e. for the local transport.
+func ConfiguredClientIdentities(in config.ServeEnum) (identities []string, restricted bool) {
+	switch v := in.Ret.(type) {
+	case *config.TCPServe:
+		for _, ci := range v.Clients {
+			identities = append(identities, ci)
+		}
+		sort.Strings(identities)
+		return identities, true
+	case *config.TLSServe:
+		return v.ClientCNs, true
+	case *config.StdinserverServer:
+		return v.ClientIdentities, true
+	case *config.UnixServe:
+		return []string{v.ClientIdentity}, true
+	default:
+		return nil, false
+	}
 }
 
 func FromConfig(g *config.Global, in config.ServeEnum) (lf ListenerFactory, conf *streamrpc.ConnConfig, _ error) {
 
 	var (
 		lfError, rpcErr error
+		common          config.ServeCommon
 	)
 	switch v := in.Ret.(type) {
 	case *config.TCPServe:
 		lf, lfError = TCPListenerFactoryFromConfig(g, v)
 		conf, rpcErr = streamrpcconfig.FromDaemonConfig(g, v.RPC)
+		common = v.ServeCommon
 	case *config.TLSServe:
 		lf, lfError = TLSListenerFactoryFromConfig(g, v)
 		conf, rpcErr = streamrpcconfig.FromDaemonConfig(g, v.RPC)
+		common = v.ServeCommon
 	case *config.StdinserverServer:
 		lf, lfError = MultiStdinserverListenerFactoryFromConfig(g, v)
 		conf, rpcErr = streamrpcconfig.FromDaemonConfig(g, v.RPC)
+		common = v.ServeCommon
+	case *config.UnixServe:
+		lf, lfError = UnixListenerFactoryFromConfig(g, v)
+		conf, rpcErr = streamrpcconfig.FromDaemonConfig(g, v.RPC)
+		common = v.ServeCommon
 	case *config.LocalServe:
 		lf, lfError = LocalListenerFactoryFromConfig(g, v)
 		conf, rpcErr = streamrpcconfig.FromDaemonConfig(g, v.RPC)
+		common = v.ServeCommon
 	default:
 		return nil, nil, errors.Errorf("internal error: unknown serve type %T", v)
 	}
@@ -138,10 +211,17 @@ func FromConfig(g *config.Global, in config.ServeEnum) (lf ListenerFactory, conf
 		return nil, nil, rpcErr
 	}
 
-	lf = HandshakeListenerFactory{lf}
+	if len(common.Access) > 0 {
+		rules, err := accessRulesFromConfig(common.Access)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "cannot parse field 'access'")
+		}
+		lf = authorizingListenerFactory{lf, rules}
+	}
+
+	lf = HandshakeListenerFactory{lf: lf, frameMax: conf.RxStreamMaxChunkSize}
 
 	return lf, conf, nil
 
 }
 
-
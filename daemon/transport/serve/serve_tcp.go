@@ -5,11 +5,14 @@ import (
 	"net"
 	"github.com/pkg/errors"
 	"context"
+	"time"
 )
 
 type TCPListenerFactory struct {
 	address *net.TCPAddr
+	fdName string
 	clientMap *ipMap
+	keepAlive time.Duration
 }
 
 type ipMapEntry struct {
@@ -46,9 +49,16 @@ func (m *ipMap) Get(ip net.IP) (string, error) {
 }
 
 func TCPListenerFactoryFromConfig(c *config.Global, in *config.TCPServe) (*TCPListenerFactory, error) {
-	addr, err := net.ResolveTCPAddr("tcp", in.Listen)
-	if err != nil {
-		return nil, errors.Wrap(err, "cannot parse listen address")
+	if err := validateListenConfig("listen", in.Listen, in.ListenFDName); err != nil {
+		return nil, err
+	}
+	var addr *net.TCPAddr
+	if in.Listen != "" {
+		var err error
+		addr, err = net.ResolveTCPAddr("tcp", in.Listen)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot parse listen address")
+		}
 	}
 	clientMap, err := ipMapFromConfig(in.Clients)
 	if err != nil {
@@ -56,36 +66,89 @@ func TCPListenerFactoryFromConfig(c *config.Global, in *config.TCPServe) (*TCPLi
 	}
 	lf := &TCPListenerFactory{
 		address: addr,
+		fdName: in.ListenFDName,
 		clientMap: clientMap,
+		keepAlive: in.KeepAlive,
 	}
 	return lf, nil
 }
 
 func (f *TCPListenerFactory) Listen() (AuthenticatedListener, error) {
-	l, err := net.ListenTCP("tcp", f.address)
+	l, err := listenTCP(f.address, f.fdName)
+	if err != nil {
+		return nil, err
+	}
+	return &TCPAuthListener{l, f.clientMap, f.keepAlive}, nil
+}
+
+// listenTCP listens on address, or uses the socket passed by systemd socket activation if fdName is set.
+func listenTCP(address *net.TCPAddr, fdName string) (*net.TCPListener, error) {
+	if fdName == "" {
+		return net.ListenTCP("tcp", address)
+	}
+	l, err := activatedListener(fdName)
 	if err != nil {
 		return nil, err
 	}
-	return &TCPAuthListener{l, f.clientMap}, nil
+	tl, ok := l.(*net.TCPListener)
+	if !ok {
+		l.Close()
+		return nil, errors.Errorf("socket %q passed by systemd socket activation is not a TCP socket", fdName)
+	}
+	return tl, nil
 }
 
 type TCPAuthListener struct {
 	*net.TCPListener
 	clientMap *ipMap
+	keepAlive time.Duration
 }
 
 func (f *TCPAuthListener) Accept(ctx context.Context) (AuthenticatedConn, error) {
-	nc, err := f.TCPListener.Accept()
+	nc, err := f.TCPListener.AcceptTCP()
 	if err != nil {
 		return nil, err
 	}
+	if err := setKeepAlive(nc, f.keepAlive); err != nil {
+		nc.Close()
+		return nil, err
+	}
 	clientIP := nc.RemoteAddr().(*net.TCPAddr).IP
 	clientIdent, err := f.clientMap.Get(clientIP)
 	if err != nil {
-		getLogger(ctx).WithField("ip", clientIP).Error("client IP not in client map")
+		getLogger(ctx).WithField("audit", "rejected").WithField("ip", clientIP).Error("client IP not in client map")
 		nc.Close()
 		return nil, err
 	}
 	return authConn{nc, clientIdent}, nil
 }
 
+// setKeepAlive makes c send TCP keepalive probes every period, a period of 0 disables them.
+// This detects dead peers long before the keepalive defaults of the OS would.
+func setKeepAlive(c *net.TCPConn, period time.Duration) error {
+	if period == 0 {
+		return c.SetKeepAlive(false)
+	}
+	if err := c.SetKeepAlive(true); err != nil {
+		return err
+	}
+	return c.SetKeepAlivePeriod(period)
+}
+
+// keepAliveListener applies setKeepAlive to the connections it accepts.
+type keepAliveListener struct {
+	*net.TCPListener
+	period time.Duration
+}
+
+func (l keepAliveListener) Accept() (net.Conn, error) {
+	c, err := l.TCPListener.AcceptTCP()
+	if err != nil {
+		return nil, err
+	}
+	if err := setKeepAlive(c, l.period); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
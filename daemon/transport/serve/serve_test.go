@@ -0,0 +1,28 @@
+package serve
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zrepl/zrepl/config"
+)
+
+func TestConfiguredClientIdentities(t *testing.T) {
+	tcs := []struct {
+		in         interface{}
+		identities []string
+		restricted bool
+	}{
+		{&config.TCPServe{Clients: map[string]string{"10.0.0.2": "b", "10.0.0.1": "a"}}, []string{"a", "b"}, true},
+		{&config.TLSServe{ClientCNs: []string{"laptop1"}}, []string{"laptop1"}, true},
+		{&config.StdinserverServer{ClientIdentities: []string{"c1", "c2"}}, []string{"c1", "c2"}, true},
+		{&config.UnixServe{ClientIdentity: "local-backup"}, []string{"local-backup"}, true},
+		{&config.LocalServe{ListenerName: "l"}, nil, false},
+	}
+	for _, tc := range tcs {
+		identities, restricted := ConfiguredClientIdentities(config.ServeEnum{Ret: tc.in})
+		assert.Equal(t, tc.identities, identities, "%T", tc.in)
+		assert.Equal(t, tc.restricted, restricted, "%T", tc.in)
+	}
+}
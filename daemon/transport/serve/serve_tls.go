@@ -1,7 +1,6 @@
 package serve
 
 import (
-	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"github.com/pkg/errors"
@@ -13,17 +12,31 @@ import (
 )
 
 type TLSListenerFactory struct {
-	address          string
+	address          *net.TCPAddr
+	fdName           string
 	clientCA         *x509.CertPool
-	serverCert       tls.Certificate
+	serverCert       *tlsconf.CertReloader
 	handshakeTimeout time.Duration
+	keepAlive        time.Duration
 	clientCNs map[string]struct{}
 }
 
 func TLSListenerFactoryFromConfig(c *config.Global, in *config.TLSServe) (lf *TLSListenerFactory, err error) {
+	if err := validateListenConfig("listen", in.Listen, in.ListenFDName); err != nil {
+		return nil, err
+	}
+
 	lf = &TLSListenerFactory{
-		address: in.Listen,
+		fdName: in.ListenFDName,
 		handshakeTimeout: in.HandshakeTimeout,
+		keepAlive: in.KeepAlive,
+	}
+
+	if in.Listen != "" {
+		lf.address, err = net.ResolveTCPAddr("tcp", in.Listen)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot parse listen address")
+		}
 	}
 
 	if in.Ca == "" || in.Cert == "" || in.Key == "" {
@@ -35,7 +48,7 @@ func TLSListenerFactoryFromConfig(c *config.Global, in *config.TLSServe) (lf *TL
 		return nil, errors.Wrap(err, "cannot parse ca file")
 	}
 
-	lf.serverCert, err = tls.LoadX509KeyPair(in.Cert, in.Key)
+	lf.serverCert, err = tlsconf.NewCertReloader(in.Cert, in.Key)
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot parse cer/key pair")
 	}
@@ -53,11 +66,12 @@ func TLSListenerFactoryFromConfig(c *config.Global, in *config.TLSServe) (lf *TL
 }
 
 func (f *TLSListenerFactory) Listen() (AuthenticatedListener, error) {
-	l, err := net.Listen("tcp", f.address)
+	l, err := listenTCP(f.address, f.fdName)
 	if err != nil {
 		return nil, err
 	}
-	tl := tlsconf.NewClientAuthListener(l, f.clientCA, f.serverCert, f.handshakeTimeout)
+	kl := keepAliveListener{l, f.keepAlive}
+	tl := tlsconf.NewClientAuthListener(kl, f.clientCA, f.serverCert, f.handshakeTimeout)
 	return tlsAuthListener{tl, f.clientCNs}, nil
 }
 
@@ -72,6 +86,11 @@ func (l tlsAuthListener) Accept(ctx context.Context) (AuthenticatedConn, error)
 		return nil, err
 	}
 	if _, ok := l.clientCNs[cn]; !ok {
+		getLogger(ctx).
+			WithField("audit", "rejected").
+			WithField("client_identity", cn).
+			WithField("addr", c.RemoteAddr().String()).
+			Warn("rejecting connection with client common name not in client_cns")
 		if err := c.Close(); err != nil {
 			getLogger(ctx).WithError(err).Error("error closing connection with unauthorized common name")
 		}
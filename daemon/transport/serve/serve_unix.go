@@ -0,0 +1,70 @@
+package serve
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/nethelpers"
+	"net"
+)
+
+type UnixListenerFactory struct {
+	sockaddr       *net.UnixAddr
+	fdName         string
+	clientIdentity string
+}
+
+func UnixListenerFactoryFromConfig(g *config.Global, in *config.UnixServe) (*UnixListenerFactory, error) {
+	if err := validateListenConfig("path", in.Path, in.ListenFDName); err != nil {
+		return nil, err
+	}
+	if err := ValidateClientIdentity(in.ClientIdentity); err != nil {
+		return nil, errors.Wrapf(err, "invalid client identity %q", in.ClientIdentity)
+	}
+	f := &UnixListenerFactory{
+		fdName:         in.ListenFDName,
+		clientIdentity: in.ClientIdentity,
+	}
+	if in.Path != "" {
+		var err error
+		f.sockaddr, err = net.ResolveUnixAddr("unix", in.Path)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot parse path")
+		}
+	}
+	return f, nil
+}
+
+func (f *UnixListenerFactory) Listen() (AuthenticatedListener, error) {
+	if f.fdName == "" {
+		// anyone who can connect to the socket is considered to be the client, hence it must be private
+		l, err := nethelpers.ListenUnixPrivate(f.sockaddr)
+		if err != nil {
+			return nil, err
+		}
+		return &UnixAuthListener{l, f.clientIdentity}, nil
+	}
+	l, err := activatedListener(f.fdName)
+	if err != nil {
+		return nil, err
+	}
+	ul, ok := l.(*net.UnixListener)
+	if !ok {
+		l.Close()
+		return nil, errors.Errorf("socket %q passed by systemd socket activation is not a UNIX socket", f.fdName)
+	}
+	return &UnixAuthListener{ul, f.clientIdentity}, nil
+}
+
+type UnixAuthListener struct {
+	*net.UnixListener
+	clientIdentity string
+}
+
+func (l *UnixAuthListener) Accept(ctx context.Context) (AuthenticatedConn, error) {
+	nc, err := l.UnixListener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return authConn{nc, l.clientIdentity}, nil
+}
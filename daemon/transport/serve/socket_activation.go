@@ -0,0 +1,136 @@
+package serve
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Sockets passed by systemd socket activation, see sd_listen_fds(3).
+// They are taken from the environment on first use, which also unsets the variables
+// so that they are not inherited by the processes started by the daemon.
+var activatedSockets struct {
+	once   sync.Once
+	mtx    sync.Mutex
+	byName map[string][]*os.File
+	err    error
+}
+
+// file descriptor number of the first socket passed by systemd
+const sdListenFDsStart = 3
+
+// parseListenFDsEnv returns the names of the sockets passed to process pid by systemd,
+// given the values of the LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES environment variables.
+// The i-th name belongs to file descriptor sdListenFDsStart+i.
+// It returns no names if the sockets were not passed to pid.
+func parseListenFDsEnv(pid int, listenPID, listenFDs, listenFDNames string) ([]string, error) {
+	if listenPID == "" {
+		return nil, nil
+	}
+	p, err := strconv.Atoi(listenPID)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse LISTEN_PID")
+	}
+	if p != pid {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(listenFDs)
+	if err != nil || n < 0 {
+		return nil, errors.Errorf("cannot parse LISTEN_FDS %q", listenFDs)
+	}
+	names := make([]string, n)
+	if listenFDNames == "" {
+		// systemd's default if FileDescriptorName= is not set
+		for i := range names {
+			names[i] = "unknown"
+		}
+		return names, nil
+	}
+	names = strings.Split(listenFDNames, ":")
+	if len(names) != n {
+		return nil, errors.Errorf("LISTEN_FDNAMES has %d names, but LISTEN_FDS is %d", len(names), n)
+	}
+	return names, nil
+}
+
+func loadActivatedSockets() {
+	a := &activatedSockets
+	names, err := parseListenFDsEnv(os.Getpid(), os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"))
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if err != nil {
+		a.err = errors.Wrap(err, "invalid systemd socket activation environment")
+		return
+	}
+	a.byName = make(map[string][]*os.File, len(names))
+	for i, name := range names {
+		fd := sdListenFDsStart + i
+		syscall.CloseOnExec(fd)
+		a.byName[name] = append(a.byName[name], os.NewFile(uintptr(fd), name))
+	}
+}
+
+// activatedListener returns a listener for the socket passed by systemd socket activation
+// whose FileDescriptorName= is name.
+// Each socket can only be taken once, sockets that share a name are returned in the order they were passed.
+// UNIX sockets are refused unless they are private, see checkUnixSocketPrivate.
+func activatedListener(name string) (net.Listener, error) {
+	a := &activatedSockets
+	a.once.Do(loadActivatedSockets)
+	if a.err != nil {
+		return nil, a.err
+	}
+	a.mtx.Lock()
+	files := a.byName[name]
+	if len(files) == 0 {
+		a.mtx.Unlock()
+		return nil, errors.Errorf("no socket named %q passed by systemd socket activation, or already in use", name)
+	}
+	f := files[0]
+	a.byName[name] = files[1:]
+	a.mtx.Unlock()
+
+	defer f.Close() // net.FileListener dups the file descriptor
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "socket %q passed by systemd socket activation", name)
+	}
+	if ul, ok := l.(*net.UnixListener); ok {
+		if err := checkUnixSocketPrivate(ul); err != nil {
+			l.Close()
+			return nil, errors.Wrapf(err, "socket %q passed by systemd socket activation", name)
+		}
+	}
+	return l, nil
+}
+
+// checkUnixSocketPrivate returns an error if users other than the owner of l's socket file can connect to it.
+// Anyone who can connect to a unix transport's socket is considered to be its client,
+// so an activated socket must be as private as the one created by nethelpers.ListenUnixPrivate.
+// Abstract sockets have no permissions and are always refused.
+func checkUnixSocketPrivate(l *net.UnixListener) error {
+	path := l.Addr().String()
+	if path == "" || path[0] == '@' {
+		return errors.Errorf("abstract socket %q can be connected to by any user, use a socket file", path)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrap(err, "cannot check socket permissions")
+	}
+	if perm := fi.Mode().Perm(); perm&0077 != 0 {
+		return errors.Errorf("socket %q is accessible by group or other users (mode %#o), set SocketMode=0600", path, perm)
+	}
+	return nil
+}
+
+func validateListenConfig(listenField, listen, listenFDName string) error {
+	if (listen == "") == (listenFDName == "") {
+		return errors.Errorf("exactly one of fields '%s' and 'listen_fd_name' must be specified", listenField)
+	}
+	return nil
+}
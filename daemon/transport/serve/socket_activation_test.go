@@ -0,0 +1,109 @@
+package serve
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseListenFDsEnv(t *testing.T) {
+	type tc struct {
+		pid, fds, names string
+		expect          []string
+		expectErr       bool
+	}
+	tcs := []tc{
+		{"", "", "", nil, false},
+		{"23", "2", "a:b", nil, false}, // other process
+		{"42", "2", "zrepl_tls:zrepl_unix", []string{"zrepl_tls", "zrepl_unix"}, false},
+		{"42", "2", "", []string{"unknown", "unknown"}, false},
+		{"42", "0", "", []string{}, false},
+		{"42", "2", "a", nil, true},
+		{"42", "x", "", nil, true},
+		{"42", "-1", "", nil, true},
+		{"x", "1", "a", nil, true},
+	}
+	for i, c := range tcs {
+		names, err := parseListenFDsEnv(42, c.pid, c.fds, c.names)
+		if c.expectErr {
+			assert.Error(t, err, "case %d", i)
+			continue
+		}
+		assert.NoError(t, err, "case %d", i)
+		assert.Equal(t, c.expect, names, "case %d", i)
+	}
+}
+
+// activateSockets replaces the sockets passed by systemd with files, by name.
+func activateSockets(t *testing.T, files map[string][]*os.File) {
+	a := &activatedSockets
+	a.once.Do(func() {})
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.byName, a.err = files, nil
+}
+
+func listenUnixFile(t *testing.T, path string, mode os.FileMode) *os.File {
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	require.NoError(t, err)
+	// like systemd, which keeps the socket file after passing the socket
+	l.SetUnlinkOnClose(false)
+	defer l.Close()
+	require.NoError(t, os.Chmod(path, mode))
+	f, err := l.File()
+	require.NoError(t, err)
+	return f
+}
+
+func TestActivatedListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrepl-activation")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer tcp.Close()
+	tcpFile, err := tcp.(*net.TCPListener).File()
+	require.NoError(t, err)
+
+	activateSockets(t, map[string][]*os.File{
+		"zrepl_tcp":    {tcpFile},
+		"zrepl_unix":   {listenUnixFile(t, filepath.Join(dir, "private"), 0600)},
+		"zrepl_group":  {listenUnixFile(t, filepath.Join(dir, "group"), 0660)},
+		"zrepl_public": {listenUnixFile(t, filepath.Join(dir, "public"), 0666)},
+	})
+
+	l, err := activatedListener("zrepl_tcp")
+	require.NoError(t, err)
+	assert.Equal(t, tcp.Addr().String(), l.Addr().String())
+	l.Close()
+	_, err = activatedListener("zrepl_tcp")
+	assert.Error(t, err, "each socket can only be taken once")
+
+	l, err = activatedListener("zrepl_unix")
+	require.NoError(t, err)
+	assert.IsType(t, &net.UnixListener{}, l)
+	l.Close()
+
+	for _, name := range []string{"zrepl_group", "zrepl_public"} {
+		_, err = activatedListener(name)
+		if assert.Error(t, err, name) {
+			assert.Contains(t, err.Error(), "SocketMode=0600")
+		}
+	}
+	_, err = activatedListener("zrepl_unknown")
+	assert.Error(t, err)
+}
+
+func TestCheckUnixSocketPrivateRefusesAbstractSockets(t *testing.T) {
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: "@zrepl-test-abstract", Net: "unix"})
+	if err != nil {
+		t.Skipf("abstract sockets not supported: %s", err)
+	}
+	defer l.Close()
+	assert.Error(t, checkUnixSocketPrivate(l))
+}
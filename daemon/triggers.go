@@ -0,0 +1,55 @@
+package daemon
+
+import (
+	"github.com/pkg/errors"
+	"github.com/zrepl/zrepl/config"
+	"sort"
+	"strings"
+)
+
+// JobTriggers returns the names of the jobs triggered by each job, i.e. the jobs that run after its successful runs.
+// It returns an error if a trigger refers to a job that does not exist or is not a push or pull job,
+// or if the triggers form a cycle, which would run the jobs forever.
+func JobTriggers(jobs []config.JobEnum) (map[string][]string, error) {
+	active := make(map[string]bool, len(jobs))
+	triggeredBy := make(map[string]string, len(jobs))
+	for _, j := range jobs {
+		switch v := j.Ret.(type) {
+		case *config.PushJob:
+			active[v.Name] = true
+			if v.Trigger != "" {
+				triggeredBy[v.Name] = v.Trigger
+			}
+		case *config.PullJob:
+			active[v.Name] = true
+			if v.Trigger != "" {
+				triggeredBy[v.Name] = v.Trigger
+			}
+		}
+	}
+
+	triggers := make(map[string][]string)
+	for job, upstream := range triggeredBy {
+		if !active[upstream] {
+			return nil, errors.Errorf("job %q: trigger %q is not the name of a push or pull job", job, upstream)
+		}
+		triggers[upstream] = append(triggers[upstream], job)
+	}
+	for _, downstream := range triggers {
+		sort.Strings(downstream) // deterministic order
+	}
+
+	// every job has at most one trigger, so following the triggers either ends or cycles
+	for job := range triggeredBy {
+		seen := map[string]bool{job: true}
+		chain := []string{job}
+		for upstream, ok := triggeredBy[job]; ok; upstream, ok = triggeredBy[upstream] {
+			chain = append(chain, upstream)
+			if seen[upstream] {
+				return nil, errors.Errorf("job triggers form a cycle (a <- b: a is triggered by b): %s", strings.Join(chain, " <- "))
+			}
+			seen[upstream] = true
+		}
+	}
+	return triggers, nil
+}
@@ -0,0 +1,54 @@
+package daemon
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/config"
+	"testing"
+)
+
+func TestJobTriggers(t *testing.T) {
+	push := func(name, trigger string) config.JobEnum {
+		return config.JobEnum{Ret: &config.PushJob{ActiveJob: config.ActiveJob{Name: name, Trigger: trigger}}}
+	}
+	pull := func(name, trigger string) config.JobEnum {
+		return config.JobEnum{Ret: &config.PullJob{ActiveJob: config.ActiveJob{Name: name, Trigger: trigger}}}
+	}
+	sink := config.JobEnum{Ret: &config.SinkJob{PassiveJob: config.PassiveJob{Name: "sink"}}}
+
+	t.Run("chain", func(t *testing.T) {
+		triggers, err := JobTriggers([]config.JobEnum{
+			push("local", ""),
+			push("offsite", "local"),
+			pull("archive", "local"),
+			push("tape", "offsite"),
+			sink,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, map[string][]string{
+			"local":   {"archive", "offsite"},
+			"offsite": {"tape"},
+		}, triggers)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := JobTriggers([]config.JobEnum{push("a", "nonexistent")})
+		assert.Error(t, err)
+	})
+
+	t.Run("passive", func(t *testing.T) {
+		_, err := JobTriggers([]config.JobEnum{push("a", "sink"), sink})
+		assert.Error(t, err)
+	})
+
+	t.Run("self", func(t *testing.T) {
+		_, err := JobTriggers([]config.JobEnum{push("a", "a")})
+		assert.Error(t, err)
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		_, err := JobTriggers([]config.JobEnum{push("a", "c"), push("b", "a"), pull("c", "b"), push("d", "a")})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "cycle")
+	})
+}
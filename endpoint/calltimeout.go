@@ -0,0 +1,152 @@
+package endpoint
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/problame/go-streamrpc"
+	"github.com/zrepl/zrepl/replication/pdu"
+	"io"
+	"time"
+)
+
+// Control RPCs whose duration grows with the amount of data they destroy or roll back.
+// They are exempt from the call timeout because retrying them after a timeout would not help.
+var longRunningRPCs = map[string]bool{
+	RPCSDestroySnapshots: true,
+	RPCResolveDivergence: true,
+}
+
+func hasCallTimeout(rpc string) bool {
+	_, control := controlRPCs[rpc]
+	return control && !longRunningRPCs[rpc]
+}
+
+// CallTimeoutError is returned by Remote if a control RPC did not complete within the call timeout.
+// It is temporary, i.e. replication retries after a timeout instead of giving up.
+type CallTimeoutError struct {
+	RPC   string
+	After time.Duration
+}
+
+func (e *CallTimeoutError) Error() string {
+	return fmt.Sprintf("%s did not complete within %s", e.RPC, e.After)
+}
+
+func (e *CallTimeoutError) Timeout() bool { return true }
+
+func (e *CallTimeoutError) Temporary() bool { return true }
+
+// WithCallTimeout returns a copy of s whose control RPCs and dry-run sends fail with a *CallTimeoutError after d.
+// Send, Receive and Ping, which carry streams, are bounded by the connection timeout only.
+// Zero disables the call timeout.
+func (s Remote) WithCallTimeout(d time.Duration) Remote {
+	s.callTimeout = d
+	return s
+}
+
+// CallTimeout returns the call timeout of s, see WithCallTimeout.
+func (s Remote) CallTimeout() time.Duration {
+	return s.callTimeout
+}
+
+type callResult struct {
+	rb  *bytes.Buffer
+	rs  io.ReadCloser
+	err error
+}
+
+// requestReplyTimeout is requestReply without a request stream, with s.callTimeout applied,
+// for control RPCs (see hasCallTimeout) and dry-run sends.
+// It does not rely on the transport to honor the deadline of ctx:
+// a reply arriving after the timeout is discarded, and the client of the call is closed,
+// which aborts the call and makes the client reconnect for its next request.
+func (s Remote) requestReplyTimeout(ctx context.Context, rpc string, reqStructured *bytes.Buffer) (*bytes.Buffer, io.ReadCloser, error) {
+	if s.callTimeout <= 0 {
+		return s.requestReply(ctx, rpc, reqStructured, nil)
+	}
+	c, err := s.acquire(ctx, rpc)
+	if err != nil {
+		return nil, nil, err
+	}
+	callCtx, cancel := context.WithTimeout(ctx, s.callTimeout)
+	defer cancel()
+	done := make(chan callResult, 1)
+	go func() {
+		rb, rs, err := s.requestReplyClient(callCtx, c, rpc, reqStructured, nil)
+		done <- callResult{rb, rs, err}
+	}()
+	select {
+	case res := <-done:
+		if res.err != nil && callCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			return nil, nil, &CallTimeoutError{rpc, s.callTimeout}
+		}
+		return res.rb, res.rs, res.err
+	case <-callCtx.Done():
+		// Until the call returns, c stays out of the pool, so that no other request
+		// is sent on a connection on which the late reply may still arrive.
+		go func() {
+			c.Close(context.Background())
+			if res := <-done; res.rs != nil {
+				res.rs.Close()
+			}
+		}()
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		return nil, nil, &CallTimeoutError{rpc, s.callTimeout}
+	}
+}
+
+// SetCallTimeout makes the control calls of the local Sender fail with a *CallTimeoutError after d,
+// like those of a Remote with WithCallTimeout. Zero disables the call timeout.
+// It must be called before the Sender is used.
+func (p *Sender) SetCallTimeout(d time.Duration) {
+	p.callTimeout = d
+}
+
+// SetCallTimeout makes the control calls of the local Receiver fail with a *CallTimeoutError after d,
+// like those of a Remote with WithCallTimeout. Zero disables the call timeout.
+// It must be called before the Receiver is used.
+func (e *Receiver) SetCallTimeout(d time.Duration) {
+	e.callTimeout = d
+}
+
+// localCall returns the context for a call of a local endpoint that corresponds to rpc, with the call timeout d applied.
+// done must be called with the error of the call, it returns a *CallTimeoutError if the call ran out of time.
+func localCall(ctx context.Context, rpc string, d time.Duration) (_ context.Context, done func(err error) error) {
+	if d <= 0 || !hasCallTimeout(rpc) {
+		return ctx, func(err error) error { return err }
+	}
+	callCtx, cancel := context.WithTimeout(ctx, d)
+	return callCtx, func(err error) error {
+		defer cancel()
+		if err != nil && callCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			return &CallTimeoutError{rpc, d}
+		}
+		return err
+	}
+}
+
+// CallTimeoutHandler wraps h so that control RPCs are handled with a context that expires after timeout.
+// Endpoint implementations pass that context on to the zfs commands they run,
+// and a call that runs out of time fails with pdu.ErrorCode_Busy, which callers retry.
+// Zero disables the call timeout.
+func CallTimeoutHandler(h streamrpc.HandlerFunc, timeout time.Duration) streamrpc.HandlerFunc {
+	if timeout <= 0 {
+		return h
+	}
+	return func(ctx context.Context, endpoint string, reqStructured *bytes.Buffer, reqStream io.ReadCloser) (*bytes.Buffer, io.ReadCloser, error) {
+		if !hasCallTimeout(endpoint) {
+			return h(ctx, endpoint, reqStructured, reqStream)
+		}
+		callCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		rb, rs, err := h(callCtx, endpoint, reqStructured, reqStream)
+		if err != nil && callCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			getLogger(ctx).WithField("rpc", endpoint).WithField("timeout", timeout).Warn("control RPC timed out")
+			err = pdu.WireError(pdu.NewError(pdu.ErrorCode_Busy, "%s did not complete within %s on the remote side", endpoint, timeout))
+		}
+		return rb, rs, err
+	}
+}
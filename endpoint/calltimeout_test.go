@@ -0,0 +1,110 @@
+package endpoint
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/problame/go-streamrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/replication/pdu"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCallTimeoutHandler(t *testing.T) {
+	blocking := func(ctx context.Context, endpoint string, reqStructured *bytes.Buffer, reqStream io.ReadCloser) (*bytes.Buffer, io.ReadCloser, error) {
+		<-ctx.Done()
+		return nil, nil, pdu.WireError(ctx.Err())
+	}
+	h := CallTimeoutHandler(blocking, 10*time.Millisecond)
+
+	_, _, err := h(context.Background(), RPCListFilesystemVersions, bytes.NewBuffer(nil), nil)
+	require.Error(t, err)
+	assert.Equal(t, pdu.ErrorCode_Busy, pdu.Code(pdu.ErrorFromWire(err)))
+
+	// stream RPCs and long-running control RPCs are not subject to the call timeout
+	for _, rpc := range []string{RPCSend, RPCReceive, RPCPing, RPCSDestroySnapshots, RPCResolveDivergence} {
+		assert.False(t, hasCallTimeout(rpc), rpc)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err = h(ctx, RPCSend, bytes.NewBuffer(nil), nil)
+	require.Error(t, err)
+	assert.Equal(t, pdu.ErrorCode_Internal, pdu.Code(pdu.ErrorFromWire(err)))
+}
+
+// blockingConnecter blocks Connect until unblock is closed.
+type blockingConnecter struct {
+	unblock chan struct{}
+}
+
+func (c blockingConnecter) Connect(ctx context.Context) (net.Conn, error) {
+	<-c.unblock
+	return nil, errors.New("unblocked")
+}
+
+func TestRemoteCallTimeoutReleasesClientAfterCall(t *testing.T) {
+	unblock := make(chan struct{})
+	c, err := streamrpc.NewClient(blockingConnecter{unblock}, &streamrpc.ClientConfig{})
+	require.NoError(t, err)
+	r := NewRemote(c).WithCallTimeout(10 * time.Millisecond)
+
+	_, err = r.ListFilesystems(context.Background())
+	require.IsType(t, &CallTimeoutError{}, err)
+	assert.Equal(t, RPCListFilesystems, err.(*CallTimeoutError).RPC)
+
+	// the abandoned call still occupies the client
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = r.acquire(ctx, RPCListFilesystems)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	close(unblock)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	released, err := r.acquire(ctx, RPCListFilesystems)
+	require.NoError(t, err)
+	assert.Equal(t, c, released)
+}
+
+func TestLocalCallTimeout(t *testing.T) {
+	ctx, done := localCall(context.Background(), RPCListFilesystemVersions, 10*time.Millisecond)
+	<-ctx.Done()
+	err := done(ctx.Err())
+	require.IsType(t, &CallTimeoutError{}, err)
+	assert.True(t, err.(*CallTimeoutError).Temporary())
+
+	// errors other than a missed deadline are passed through
+	_, done = localCall(context.Background(), RPCListFilesystemVersions, time.Second)
+	assert.Equal(t, io.EOF, done(io.EOF))
+
+	// the caller's cancellation is not a call timeout
+	parent, cancel := context.WithCancel(context.Background())
+	ctx, done = localCall(parent, RPCBookmark, time.Second)
+	cancel()
+	<-ctx.Done()
+	assert.Equal(t, context.Canceled, done(ctx.Err()))
+
+	// stream and long-running calls have no deadline
+	for _, rpc := range []string{RPCSend, RPCSDestroySnapshots} {
+		ctx, _ := localCall(context.Background(), rpc, time.Millisecond)
+		_, ok := ctx.Deadline()
+		assert.False(t, ok, rpc)
+	}
+}
+
+func TestRemoteCallTimeoutDryRunSend(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+	c, err := streamrpc.NewClient(blockingConnecter{unblock}, &streamrpc.ClientConfig{})
+	require.NoError(t, err)
+	r := NewRemote(c).WithCallTimeout(10 * time.Millisecond)
+
+	_, stream, err := r.Send(context.Background(), &pdu.SendReq{Filesystem: "pool/a", From: "@a", To: "@b", DryRun: true})
+	require.IsType(t, &CallTimeoutError{}, err)
+	assert.Equal(t, RPCSend, err.(*CallTimeoutError).RPC)
+	assert.Nil(t, stream)
+}
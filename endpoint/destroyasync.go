@@ -0,0 +1,104 @@
+package endpoint
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/zrepl/zrepl/replication/pdu"
+	"github.com/zrepl/zrepl/util/envconst"
+	"sync"
+	"time"
+)
+
+// Results of asynchronous destroys that are never polled are dropped after this duration.
+var destroyOpRetention = envconst.Duration("ZREPL_ENDPOINT_DESTROY_RESULT_RETENTION", 1*time.Hour)
+
+type destroyOp struct {
+	// the endpoint that submitted the operation, see destroyOwner, only it may poll
+	owner     string
+	total     int
+	destroyed int
+	done      bool
+	doneAt    time.Time
+	res       *pdu.DestroySnapshotsRes
+	err       error
+}
+
+// destroyOps tracks the asynchronous destroy operations of all endpoints in this process.
+// Operations are identified by random IDs and can only be polled by their owner.
+type destroyOps struct {
+	mtx sync.Mutex
+	ops map[uint64]*destroyOp
+}
+
+var asyncDestroys = &destroyOps{ops: make(map[uint64]*destroyOp)}
+
+// destroyOwner identifies the endpoint of the given role (sender or receiver) serving clientIdentity.
+func destroyOwner(role, clientIdentity string) string {
+	return role + "/" + clientIdentity
+}
+
+func randomDestroyOpID() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err) // crypto/rand does not fail on supported platforms
+	}
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// submit runs do in the background and returns the ID for polling it, which only owner may do.
+// do reports the number of processed snapshots (out of total) through its argument.
+func (o *destroyOps) submit(owner string, total int, do func(progress func(destroyed int)) (*pdu.DestroySnapshotsRes, error)) uint64 {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	for id, op := range o.ops {
+		if op.done && time.Since(op.doneAt) > destroyOpRetention {
+			delete(o.ops, id)
+		}
+	}
+
+	var id uint64
+	for id == 0 || o.ops[id] != nil {
+		id = randomDestroyOpID()
+	}
+	op := &destroyOp{owner: owner, total: total}
+	o.ops[id] = op
+
+	go func() {
+		res, err := do(func(destroyed int) {
+			o.mtx.Lock()
+			defer o.mtx.Unlock()
+			op.destroyed = destroyed
+		})
+		o.mtx.Lock()
+		defer o.mtx.Unlock()
+		op.done, op.doneAt = true, time.Now()
+		op.res, op.err = res, err
+	}()
+
+	return id
+}
+
+// poll reports the progress of operation id, and its result once it is done.
+// The operation is forgotten after its result has been returned.
+// Operations of other owners are reported like unknown operations.
+func (o *destroyOps) poll(owner string, id uint64) (*pdu.DestroySnapshotsPollRes, error) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	op, ok := o.ops[id]
+	if !ok || op.owner != owner {
+		return nil, pdu.NewError(pdu.ErrorCode_NotFound, "unknown destroy operation %d", id)
+	}
+	res := &pdu.DestroySnapshotsPollRes{
+		Done:      op.done,
+		Destroyed: uint32(op.destroyed),
+		Total:     uint32(op.total),
+	}
+	if !op.done {
+		return res, nil
+	}
+	delete(o.ops, id)
+	res.Result = op.res
+	res.Error = pdu.ErrorFrom(op.err)
+	return res, nil
+}
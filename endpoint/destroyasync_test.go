@@ -0,0 +1,71 @@
+package endpoint
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/replication/pdu"
+	"testing"
+)
+
+func TestDestroyOps(t *testing.T) {
+	ops := &destroyOps{ops: make(map[uint64]*destroyOp)}
+
+	proceed := make(chan struct{})
+	progressed := make(chan struct{})
+	result := &pdu.DestroySnapshotsRes{}
+	id := ops.submit("receiver/a", 2, func(progress func(int)) (*pdu.DestroySnapshotsRes, error) {
+		progress(1)
+		close(progressed)
+		<-proceed
+		progress(2)
+		return result, nil
+	})
+
+	<-progressed
+	res, err := ops.poll("receiver/a", id)
+	require.NoError(t, err)
+	assert.False(t, res.Done)
+	assert.Equal(t, uint32(1), res.Destroyed)
+	assert.Equal(t, uint32(2), res.Total)
+
+	close(proceed)
+	for !res.Done {
+		res, err = ops.poll("receiver/a", id)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, uint32(2), res.Destroyed)
+	assert.Equal(t, result, res.Result)
+	assert.Empty(t, res.Error)
+
+	_, err = ops.poll("receiver/a", id)
+	assert.Error(t, err, "result must only be returned once")
+}
+
+func TestDestroyOpsOwner(t *testing.T) {
+	ops := &destroyOps{ops: make(map[uint64]*destroyOp)}
+
+	done := make(chan struct{})
+	id := ops.submit(destroyOwner("receiver", "a"), 1, func(progress func(int)) (*pdu.DestroySnapshotsRes, error) {
+		defer close(done)
+		return &pdu.DestroySnapshotsRes{}, nil
+	})
+	<-done
+	other := ops.submit(destroyOwner("receiver", "a"), 1, func(progress func(int)) (*pdu.DestroySnapshotsRes, error) {
+		return &pdu.DestroySnapshotsRes{}, nil
+	})
+	assert.NotEqual(t, id, other)
+	assert.NotEqual(t, id+1, other, "IDs must not be guessable")
+
+	for _, owner := range []string{destroyOwner("receiver", "b"), destroyOwner("sender", "a")} {
+		_, err := ops.poll(owner, id)
+		require.Error(t, err)
+		assert.Equal(t, pdu.ErrorCode_NotFound, pdu.Code(err))
+	}
+	// polls of other owners do not consume the result
+	var res *pdu.DestroySnapshotsPollRes
+	for res == nil || !res.Done {
+		var err error
+		res, err = ops.poll(destroyOwner("receiver", "a"), id)
+		require.NoError(t, err)
+	}
+}
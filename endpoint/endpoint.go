@@ -8,15 +8,36 @@ import (
 	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
 	"github.com/problame/go-streamrpc"
+	"github.com/zrepl/zrepl/daemon/transport"
 	"github.com/zrepl/zrepl/replication"
+	"github.com/zrepl/zrepl/replication/fsrep"
 	"github.com/zrepl/zrepl/replication/pdu"
+	"github.com/zrepl/zrepl/util"
+	"github.com/zrepl/zrepl/util/faultinject"
 	"github.com/zrepl/zrepl/zfs"
 	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Sender implements replication.ReplicationEndpoint for a sending side
 type Sender struct {
 	FSFilter                zfs.DatasetFilter
+	// If not nil, the snapshots that are exposed for replication, see ListFilesystemVersions and Send.
+	SnapshotFilter zfs.FilesystemVersionFilter
+	// the client served by this Sender, see SetClientIdentity
+	clientIdentity string
+	// zero if unset, see SetCallTimeout
+	callTimeout time.Duration
+}
+
+// SetClientIdentity sets the identity of the client served by the Sender,
+// only it can poll the asynchronous destroys it submitted.
+// It must be called before the Sender is used.
+func (s *Sender) SetClientIdentity(identity string) {
+	s.clientIdentity = identity
 }
 
 func NewSender(fsf zfs.DatasetFilter) *Sender {
@@ -26,10 +47,10 @@ func NewSender(fsf zfs.DatasetFilter) *Sender {
 func (s *Sender) filterCheckFS(fs string) (*zfs.DatasetPath, error) {
 	dp, err := zfs.NewDatasetPath(fs)
 	if err != nil {
-		return nil, err
+		return nil, pdu.NewError(pdu.ErrorCode_InvalidArgument, "%s", err)
 	}
 	if dp.Length() == 0 {
-		return nil, errors.New("empty filesystem not allowed")
+		return nil, pdu.NewError(pdu.ErrorCode_InvalidArgument, "empty filesystem not allowed")
 	}
 	pass, err := s.FSFilter.Filter(dp)
 	if err != nil {
@@ -41,8 +62,10 @@ func (s *Sender) filterCheckFS(fs string) (*zfs.DatasetPath, error) {
 	return dp, nil
 }
 
-func (p *Sender) ListFilesystems(ctx context.Context) ([]*pdu.Filesystem, error) {
-	fss, err := zfs.ZFSListMapping(p.FSFilter)
+func (p *Sender) ListFilesystems(ctx context.Context) (_ []*pdu.Filesystem, err error) {
+	ctx, done := localCall(ctx, RPCListFilesystems, p.callTimeout)
+	defer func() { err = done(err) }()
+	fss, err := zfs.ZFSListMappingContext(ctx, p.FSFilter)
 	if err != nil {
 		return nil, err
 	}
@@ -56,12 +79,16 @@ func (p *Sender) ListFilesystems(ctx context.Context) ([]*pdu.Filesystem, error)
 	return rfss, nil
 }
 
-func (p *Sender) ListFilesystemVersions(ctx context.Context, fs string) ([]*pdu.FilesystemVersion, error) {
+// ListFilesystemVersions omits the snapshots rejected by p.SnapshotFilter,
+// which makes them invisible to replication planning and to pruning of the sender.
+func (p *Sender) ListFilesystemVersions(ctx context.Context, fs string) (_ []*pdu.FilesystemVersion, err error) {
+	ctx, done := localCall(ctx, RPCListFilesystemVersions, p.callTimeout)
+	defer func() { err = done(err) }()
 	lp, err := p.filterCheckFS(fs)
 	if err != nil {
 		return nil, err
 	}
-	fsvs, err := zfs.ZFSListFilesystemVersions(lp, nil)
+	fsvs, err := zfs.ZFSListFilesystemVersionsContext(ctx, lp, p.SnapshotFilter)
 	if err != nil {
 		return nil, err
 	}
@@ -72,14 +99,68 @@ func (p *Sender) ListFilesystemVersions(ctx context.Context, fs string) ([]*pdu.
 	return rfsvs, nil
 }
 
+// ListFilesystemVersionsBatch implements replication.BatchLister with one zfs list per pool.
+// Filesystems that are not exposed by the sender are omitted.
+func (p *Sender) ListFilesystemVersionsBatch(ctx context.Context, fss []string) (_ map[string][]*pdu.FilesystemVersion, err error) {
+	ctx, done := localCall(ctx, RPCListFilesystemVersions, p.callTimeout)
+	defer func() { err = done(err) }()
+	lps := make(map[string]*zfs.DatasetPath, len(fss))
+	for _, fs := range fss {
+		lp, err := p.filterCheckFS(fs)
+		if err != nil {
+			continue // reported by ListFilesystemVersions
+		}
+		lps[fs] = lp
+	}
+	return listFilesystemVersionsBatch(ctx, lps, p.SnapshotFilter)
+}
+
+// listFilesystemVersionsBatch lists the versions of the local filesystems in lps
+// and returns them by the key of their filesystem in lps.
+func listFilesystemVersionsBatch(ctx context.Context, lps map[string]*zfs.DatasetPath, filter zfs.FilesystemVersionFilter) (map[string][]*pdu.FilesystemVersion, error) {
+	list := make([]*zfs.DatasetPath, 0, len(lps))
+	for _, lp := range lps {
+		list = append(list, lp)
+	}
+	versions, err := zfs.ZFSListFilesystemVersionsBatchContext(ctx, list, filter)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string][]*pdu.FilesystemVersion, len(lps))
+	for fs, lp := range lps {
+		fsvs := versions[lp.ToString()]
+		rfsvs := make([]*pdu.FilesystemVersion, len(fsvs))
+		for i := range fsvs {
+			rfsvs[i] = pdu.FilesystemVersionFromZFS(&fsvs[i])
+		}
+		res[fs] = rfsvs
+	}
+	return res, nil
+}
+
 func (p *Sender) Send(ctx context.Context, r *pdu.SendReq) (*pdu.SendRes, io.ReadCloser, error) {
-	_, err := p.filterCheckFS(r.Filesystem)
+	dp, err := p.filterCheckFS(r.Filesystem)
 	if err != nil {
 		return nil, nil, err
 	}
+	for _, v := range []string{r.From, r.To} {
+		if err := p.snapshotFilterCheck(v); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if r.Encrypted {
+		encrypted, err := zfs.ZFSEncryptionEnabled(dp)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !encrypted {
+			return nil, nil, fmt.Errorf("filesystem %q is not encrypted, refusing to send it unencrypted", r.Filesystem)
+		}
+	}
 
 	if r.DryRun {
-		si, err := zfs.ZFSSendDry(r.Filesystem, r.From, r.To, "")
+		si, err := zfs.ZFSSendDry(r.Filesystem, r.From, r.To, "", r.StreamFeatures())
 		if err != nil {
 			return nil, nil, err
 		}
@@ -89,12 +170,171 @@ func (p *Sender) Send(ctx context.Context, r *pdu.SendReq) (*pdu.SendRes, io.Rea
 		}
 		return &pdu.SendRes{ExpectedSize: expSize}, nil, nil
 	} else {
-		stream, err := zfs.ZFSSend(ctx, r.Filesystem, r.From, r.To, "")
+		token, err := p.usableResumeToken(ctx, dp, r)
+		if err != nil {
+			return nil, nil, err
+		}
+		stream, err := zfs.ZFSSend(ctx, r.Filesystem, r.From, r.To, token, r.StreamFeatures())
 		if err != nil {
 			return nil, nil, err
 		}
-		return &pdu.SendRes{}, stream, nil
+		return &pdu.SendRes{UsedResumeToken: token != ""}, stream, nil
+	}
+}
+
+// SendEstimates answers the dry-run send requests req.Steps like Send, and fails if one of them fails.
+// Consecutive incremental steps of a filesystem are estimated with a single zfs send -n, see incrementalRunEnd.
+func (p *Sender) SendEstimates(ctx context.Context, req *pdu.SendEstimatesReq) (_ *pdu.SendEstimatesRes, err error) {
+	ctx, done := localCall(ctx, RPCSendEstimates, p.callTimeout)
+	defer func() { err = done(err) }()
+	for i, step := range req.Steps {
+		if !step.DryRun {
+			return nil, pdu.NewError(pdu.ErrorCode_InvalidArgument, "step %d is not a dry run", i)
+		}
+	}
+	res := &pdu.SendEstimatesRes{ExpectedSizes: make([]int64, len(req.Steps))}
+	for i := 0; i < len(req.Steps); {
+		if end := incrementalRunEnd(req.Steps, i); end-i > 1 {
+			sizes, err := p.sendEstimatesIncrementals(ctx, req.Steps[i:end])
+			if err == nil {
+				copy(res.ExpectedSizes[i:end], sizes)
+				i = end
+				continue
+			}
+			getLogger(ctx).WithError(err).WithField("fs", req.Steps[i].Filesystem).
+				Debug("cannot estimate consecutive steps at once, estimating each step separately")
+		}
+		sres, _, err := p.Send(ctx, req.Steps[i])
+		if err != nil {
+			return nil, err
+		}
+		res.ExpectedSizes[i] = sres.ExpectedSize
+		i++
+	}
+	return res, nil
+}
+
+// incrementalRunEnd returns the end of the run of steps starting at steps[start]
+// that can be estimated with a single zfs send -n -I: incremental steps between snapshots of the same filesystem
+// with the same stream features, each continuing from the previous one, and without resume tokens.
+func incrementalRunEnd(steps []*pdu.SendReq, start int) int {
+	batchable := func(s *pdu.SendReq) bool {
+		return s.ResumeToken == "" && strings.HasPrefix(s.From, "@") && strings.HasPrefix(s.To, "@")
+	}
+	first := steps[start]
+	if !batchable(first) {
+		return start + 1
+	}
+	end := start + 1
+	for ; end < len(steps); end++ {
+		s := steps[end]
+		if !batchable(s) || s.Filesystem != first.Filesystem || s.From != steps[end-1].To ||
+			s.StreamFeatures() != first.StreamFeatures() || s.Encrypted != first.Encrypted {
+			break
+		}
+	}
+	return end
+}
+
+// sendEstimatesIncrementals estimates the sizes of steps, a run of steps as returned by incrementalRunEnd,
+// with the checks of Send.
+func (p *Sender) sendEstimatesIncrementals(ctx context.Context, steps []*pdu.SendReq) ([]int64, error) {
+	first := steps[0]
+	dp, err := p.filterCheckFS(first.Filesystem)
+	if err != nil {
+		return nil, err
+	}
+	tos := make([]string, len(steps))
+	for i, s := range steps {
+		for _, v := range []string{s.From, s.To} {
+			if err := p.snapshotFilterCheck(v); err != nil {
+				return nil, err
+			}
+		}
+		tos[i] = s.To
+	}
+	if first.Encrypted {
+		encrypted, err := zfs.ZFSEncryptionEnabled(dp)
+		if err != nil {
+			return nil, err
+		}
+		if !encrypted {
+			return nil, fmt.Errorf("filesystem %q is not encrypted, refusing to send it unencrypted", first.Filesystem)
+		}
+	}
+	return zfs.ZFSSendDryIncrementals(ctx, first.Filesystem, first.From, tos, first.StreamFeatures())
+}
+
+// snapshotFilterCheck refuses to send relName, which is relative to the filesystem, e.g. @snap,
+// if it is a snapshot rejected by p.SnapshotFilter.
+func (p *Sender) snapshotFilterCheck(relName string) error {
+	if p.SnapshotFilter == nil || !strings.HasPrefix(relName, "@") {
+		return nil
+	}
+	name := strings.TrimPrefix(relName, "@")
+	pass, err := p.SnapshotFilter.Filter(zfs.Snapshot, name)
+	if err != nil {
+		return err
+	}
+	if !pass {
+		return pdu.NewError(pdu.ErrorCode_PermissionDenied, "snapshot %s is excluded by the sender's snapshot filter", relName)
+	}
+	return nil
+}
+
+// usableResumeToken returns r.ResumeToken if it continues the stream from r.From to r.To,
+// and the empty string if there is no token or it cannot be decoded on this host.
+func (p *Sender) usableResumeToken(ctx context.Context, dp *zfs.DatasetPath, r *pdu.SendReq) (string, error) {
+	if r.ResumeToken == "" {
+		return "", nil
+	}
+	t, err := zfs.ParseResumeToken(ctx, r.ResumeToken)
+	if err != nil {
+		getLogger(ctx).WithError(err).Warn("cannot decode resume token, sending from the start")
+		return "", nil
+	}
+	fsvs, err := zfs.ZFSListFilesystemVersionsContext(ctx, dp, nil)
+	if err != nil {
+		return "", err
+	}
+	if err := checkResumeToken(fsvs, r.From, r.To, t); err != nil {
+		return "", err
+	}
+	return r.ResumeToken, nil
+}
+
+// checkResumeToken returns an error unless t encodes the stream from version from (empty for a full stream)
+// to version to, both relative names of versions in fsvs.
+func checkResumeToken(fsvs []zfs.FilesystemVersion, from, to string, t *zfs.ResumeToken) error {
+	guid := func(relName string) (uint64, bool) {
+		for _, v := range fsvs {
+			if v.String() == relName {
+				return v.Guid, true
+			}
+		}
+		return 0, false
+	}
+	toGUID, ok := guid(to)
+	if !ok {
+		return pdu.NewError(pdu.ErrorCode_NotFound, "version %q does not exist", to)
+	}
+	if t.ToGUID != toGUID {
+		return pdu.NewError(pdu.ErrorCode_InvalidArgument, "resume token does not continue a stream to %s", to)
+	}
+	if from == "" {
+		if t.HasFromGUID && t.FromGUID != 0 {
+			return pdu.NewError(pdu.ErrorCode_InvalidArgument, "resume token continues an incremental stream, not a full one")
+		}
+		return nil
+	}
+	fromGUID, ok := guid(from)
+	if !ok {
+		return pdu.NewError(pdu.ErrorCode_NotFound, "version %q does not exist", from)
 	}
+	if !t.HasFromGUID || t.FromGUID != fromGUID {
+		return pdu.NewError(pdu.ErrorCode_InvalidArgument, "resume token does not continue a stream from %s", from)
+	}
+	return nil
 }
 
 func (p *Sender) DestroySnapshots(ctx context.Context, req *pdu.DestroySnapshotsReq) (*pdu.DestroySnapshotsRes, error) {
@@ -102,10 +342,24 @@ func (p *Sender) DestroySnapshots(ctx context.Context, req *pdu.DestroySnapshots
 	if err != nil {
 		return nil, err
 	}
-	return doDestroySnapshots(ctx, dp, req.Snapshots)
+	return doDestroySnapshots(ctx, dp, req.Snapshots, false, 0, nil)
+}
+
+func (p *Sender) DestroySnapshotsSubmit(ctx context.Context, req *pdu.DestroySnapshotsReq) (*pdu.DestroySnapshotsSubmitRes, error) {
+	dp, err := p.filterCheckFS(req.Filesystem)
+	if err != nil {
+		return nil, err
+	}
+	return submitDestroySnapshots(ctx, destroyOwner("sender", p.clientIdentity), dp, req.Snapshots, false, 0), nil
+}
+
+func (p *Sender) DestroySnapshotsPoll(ctx context.Context, req *pdu.DestroySnapshotsPollReq) (*pdu.DestroySnapshotsPollRes, error) {
+	return asyncDestroys.poll(destroyOwner("sender", p.clientIdentity), req.ID)
 }
 
-func (p *Sender) ReplicationCursor(ctx context.Context, req *pdu.ReplicationCursorReq) (*pdu.ReplicationCursorRes, error) {
+func (p *Sender) ReplicationCursor(ctx context.Context, req *pdu.ReplicationCursorReq) (_ *pdu.ReplicationCursorRes, err error) {
+	ctx, done := localCall(ctx, RPCReplicationCursor, p.callTimeout)
+	defer func() { err = done(err) }()
 	dp, err := p.filterCheckFS(req.Filesystem)
 	if err != nil {
 		return nil, err
@@ -132,6 +386,125 @@ func (p *Sender) ReplicationCursor(ctx context.Context, req *pdu.ReplicationCurs
 	}
 }
 
+func (p *Sender) Bookmark(ctx context.Context, req *pdu.BookmarkReq) (_ *pdu.BookmarkRes, err error) {
+	ctx, done := localCall(ctx, RPCBookmark, p.callTimeout)
+	defer func() { err = done(err) }()
+	dp, err := p.filterCheckFS(req.Filesystem)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.snapshotFilterCheck("@" + req.Snapshot); err != nil {
+		return nil, err
+	}
+	guid, err := zfs.ZFSBookmarkSnapshot(dp, req.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return &pdu.BookmarkRes{Guid: guid}, nil
+}
+
+// StepHoldTagPrefix is the prefix of the hold tags of multi-step replications, see SetStepHolds.
+const StepHoldTagPrefix = "zrepl_step_"
+
+func (p *Sender) SetStepHolds(ctx context.Context, req *pdu.SetStepHoldsReq) (_ *pdu.SetStepHoldsRes, err error) {
+	ctx, done := localCall(ctx, RPCSetStepHolds, p.callTimeout)
+	defer func() { err = done(err) }()
+	// holds of other tools must be neither released nor placed by clients
+	if !strings.HasPrefix(req.Tag, StepHoldTagPrefix) {
+		return nil, pdu.NewError(pdu.ErrorCode_InvalidArgument, "only step holds (tag prefix %q) can be set", StepHoldTagPrefix)
+	}
+	if req.Filesystem == "" && len(req.Snapshots) == 0 {
+		return p.releaseUnexposedStepHolds(ctx, req.Tag)
+	}
+	dp, err := p.filterCheckFS(req.Filesystem)
+	if err != nil {
+		return nil, err
+	}
+	for _, snap := range req.Snapshots {
+		if err := p.snapshotFilterCheck("@" + snap); err != nil {
+			return nil, err
+		}
+	}
+	held, err := zfs.ZFSListHolds(dp, req.Tag)
+	if err != nil {
+		return nil, err
+	}
+	want := make(map[string]bool, len(req.Snapshots))
+	for _, snap := range req.Snapshots {
+		want[snap] = true
+	}
+	for _, snap := range held {
+		if !want[snap] {
+			if err := zfs.ZFSRelease(dp, snap, req.Tag); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, snap := range req.Snapshots {
+		if err := zfs.ZFSHold(dp, snap, req.Tag); err != nil {
+			return nil, err
+		}
+	}
+	return &pdu.SetStepHoldsRes{}, nil
+}
+
+// releaseUnexposedStepHolds releases the holds with tag on the filesystems that do not pass the filter (anymore),
+// e.g. after a filter change, which the active side cannot release through SetStepHolds of a filesystem.
+// Only step hold tags are released (see SetStepHolds), holds of other tools remain untouched.
+func (p *Sender) releaseUnexposedStepHolds(ctx context.Context, tag string) (*pdu.SetStepHoldsRes, error) {
+	held, err := zfs.ZFSListAllHolds(tag)
+	if err != nil {
+		return nil, err
+	}
+	for fs, snaps := range held {
+		dp, err := zfs.NewDatasetPath(fs)
+		if err != nil {
+			return nil, err
+		}
+		if pass, err := p.FSFilter.Filter(dp); err != nil {
+			return nil, err
+		} else if pass {
+			continue // released by the SetStepHolds of the filesystem
+		}
+		for _, snap := range snaps {
+			getLogger(ctx).WithField("fs", fs).WithField("snapshot", snap).WithField("tag", tag).
+				Info("releasing step hold on filesystem that is no longer replicated")
+			if err := zfs.ZFSRelease(dp, snap, tag); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &pdu.SetStepHoldsRes{}, nil
+}
+
+func (p *Sender) GetProperties(ctx context.Context, req *pdu.GetPropertiesReq) (_ *pdu.GetPropertiesRes, err error) {
+	ctx, done := localCall(ctx, RPCGetProperties, p.callTimeout)
+	defer func() { err = done(err) }()
+	dp, err := p.filterCheckFS(req.Filesystem)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Names) == 0 {
+		return &pdu.GetPropertiesRes{}, nil
+	}
+	if err := (zfs.RecvProperties{Inherit: req.Names}).Validate(); err != nil {
+		return nil, err
+	}
+	props, err := zfs.ZFSGetSetProperties(dp, req.Names)
+	if err != nil {
+		return nil, err
+	}
+	res := &pdu.GetPropertiesRes{}
+	for _, name := range req.Names {
+		if value, ok := props.Lookup(name); ok {
+			res.Set = append(res.Set, &pdu.Property{Name: name, Value: value})
+		} else {
+			res.Inherited = append(res.Inherited, name)
+		}
+	}
+	return res, nil
+}
+
 type FSFilter interface { // FIXME unused
 	Filter(path *zfs.DatasetPath) (pass bool, err error)
 }
@@ -146,14 +519,106 @@ type FSMap interface { // FIXME unused
 
 // Receiver implements replication.ReplicationEndpoint for a receiving side
 type Receiver struct {
-	root *zfs.DatasetPath
+	root      *zfs.DatasetPath
+	rules     []RootRule
+	recvFlags []string
+	recvProps zfs.RecvProperties
+	// zfs recv -s: report and clear the state of interrupted receives
+	resumable bool
+	// nil if unset
+	postReceive PostReceiveFunc
+	quota       Quota
+	// the properties SetProperties accepts, see SetAcceptedProperties
+	acceptedProps PropertyAllowlist
+	// the client served by this Receiver, see SetClientIdentity
+	clientIdentity string
+	// zero if unset, see SetCallTimeout
+	callTimeout time.Duration
+}
+
+// SetClientIdentity sets the identity of the client served by the Receiver,
+// only it can poll the asynchronous destroys it submitted.
+// It must be called before the Receiver is used.
+func (e *Receiver) SetClientIdentity(identity string) {
+	e.clientIdentity = identity
+}
+
+// PostReceiveFunc is called after Receive received a new snapshot into the local filesystem fs.
+// snapshot is the name of the received snapshot (see pdu.ReceiveReq.ToSnapshot), without the fs@ prefix.
+// It is called within the Receive RPC and must return quickly, e.g. by running the actual work in the background.
+type PostReceiveFunc func(ctx context.Context, fs *zfs.DatasetPath, snapshot string)
+
+// SetPostReceive sets the function called after each successful Receive, f may be nil.
+// It must be called before the Receiver is used.
+func (e *Receiver) SetPostReceive(f PostReceiveFunc) {
+	e.postReceive = f
+}
+
+// RootRule routes the sender's filesystems below Sender to below Root instead of the receiver's root dataset.
+// For example, with Sender tank/vm and Root fastpool/vm, tank/vm/a is received as fastpool/vm/a.
+type RootRule struct {
+	Sender *zfs.DatasetPath
+	Root   *zfs.DatasetPath
 }
 
-func NewReceiver(rootDataset *zfs.DatasetPath) (*Receiver, error) {
+// rules are evaluated in order, the first rule whose Sender is a strict prefix of a sender filesystem applies.
+// Sender filesystems matched by no rule are received below rootDataset.
+//
+// recvFlags are passed to zfs recv in addition to the flags determined by the receiver,
+// and must pass zfs.ValidateRecvPassThroughFlags.
+// recvProps are applied to every received filesystem and take precedence over those in a ReceiveReq.
+func NewReceiver(rootDataset *zfs.DatasetPath, rules []RootRule, recvFlags []string, recvProps zfs.RecvProperties) (*Receiver, error) {
 	if rootDataset.Length() <= 0 {
 		return nil, errors.New("root dataset must not be an empty path")
 	}
-	return &Receiver{root: rootDataset.Copy()}, nil
+	rulesCopy := make([]RootRule, len(rules))
+	for i, r := range rules {
+		if r.Sender.Length() <= 0 || r.Root.Length() <= 0 {
+			return nil, errors.Errorf("root rule #%d: sender and root must not be empty paths", i)
+		}
+		rulesCopy[i] = RootRule{r.Sender.Copy(), r.Root.Copy()}
+	}
+	if err := zfs.ValidateRecvPassThroughFlags(recvFlags); err != nil {
+		return nil, err
+	}
+	flags := make([]string, len(recvFlags))
+	copy(flags, recvFlags)
+	if err := recvProps.Validate(); err != nil {
+		return nil, err
+	}
+	props := zfs.RecvProperties{}.Merge(recvProps) // copy
+	resumable := false
+	for _, f := range flags {
+		resumable = resumable || f == "-s"
+	}
+	return &Receiver{root: rootDataset.Copy(), rules: rulesCopy, recvFlags: flags, recvProps: props, resumable: resumable}, nil
+}
+
+// mapToLocal maps the sender's filesystem fs to the local filesystem it is received into.
+func (e *Receiver) mapToLocal(fs string) (*zfs.DatasetPath, error) {
+	p, err := zfs.NewDatasetPath(fs)
+	if err != nil {
+		return nil, pdu.NewError(pdu.ErrorCode_InvalidArgument, "%s", err)
+	}
+	if p.Length() == 0 {
+		return nil, pdu.NewError(pdu.ErrorCode_InvalidArgument, "cannot map empty filesystem")
+	}
+	for _, r := range e.rules {
+		if p.HasPrefix(r.Sender) && !p.Equal(r.Sender) {
+			p.TrimPrefix(r.Sender)
+			return subroot{r.Root}.MapToLocal(p.ToString())
+		}
+	}
+	return subroot{e.root}.MapToLocal(fs)
+}
+
+// LocalName returns the name of the local filesystem into which the sender's filesystem fs is received.
+func (e *Receiver) LocalName(fs string) (string, error) {
+	lp, err := e.mapToLocal(fs)
+	if err != nil {
+		return "", err
+	}
+	return lp.ToString(), nil
 }
 
 type subroot struct {
@@ -180,38 +645,93 @@ func (f subroot) MapToLocal(fs string) (*zfs.DatasetPath, error) {
 	return c, nil
 }
 
-func (e *Receiver) ListFilesystems(ctx context.Context) ([]*pdu.Filesystem, error) {
-	filtered, err := zfs.ZFSListMapping(subroot{e.root})
-	if err != nil {
-		return nil, err
+func (e *Receiver) ListFilesystems(ctx context.Context) (_ []*pdu.Filesystem, err error) {
+	ctx, done := localCall(ctx, RPCListFilesystems, e.callTimeout)
+	defer func() { err = done(err) }()
+	// the sender's path of a local filesystem below root is senderPrefix + the path relative to root
+	type root struct {
+		local, senderPrefix *zfs.DatasetPath
 	}
+	roots := make([]root, 0, 1+len(e.rules))
+	roots = append(roots, root{e.root, nil})
+	for _, r := range e.rules {
+		roots = append(roots, root{r.Root, r.Sender})
+	}
+
 	// present without prefix, and only those that are not placeholders
-	fss := make([]*pdu.Filesystem, 0, len(filtered))
-	for _, a := range filtered {
-		ph, err := zfs.ZFSIsPlaceholderFilesystem(a)
+	fss := make([]*pdu.Filesystem, 0)
+	seen := make(map[string]bool)
+	for _, r := range roots {
+		filtered, err := zfs.ZFSListMappingContext(ctx, subroot{r.local})
 		if err != nil {
-			getLogger(ctx).
-				WithError(err).
-				WithField("fs", a).
-				Error("inconsistent placeholder property")
-			return nil, errors.New("server error, see logs") // don't leak path
+			return nil, err
 		}
-		if ph {
-			continue
+		for _, a := range filtered {
+			local := a.Copy()
+			a.TrimPrefix(r.local)
+			if r.senderPrefix != nil {
+				sender := r.senderPrefix.Copy()
+				sender.Extend(a)
+				a = sender
+			}
+			// skip filesystems that are not received there, e.g. because the roots are nested
+			if lp, err := e.mapToLocal(a.ToString()); err != nil || !lp.Equal(local) || seen[a.ToString()] {
+				continue
+			}
+			ph, err := zfs.ZFSIsPlaceholderFilesystem(local)
+			if err != nil {
+				getLogger(ctx).
+					WithError(err).
+					WithField("fs", local).
+					Error("inconsistent placeholder property")
+				return nil, errors.New("server error, see logs") // don't leak path
+			}
+			if ph {
+				continue
+			}
+			seen[a.ToString()] = true
+			rfs := &pdu.Filesystem{Path: a.ToString()}
+			if e.resumable {
+				if err := e.fillResumeToken(ctx, local, rfs); err != nil {
+					return nil, err
+				}
+			}
+			fss = append(fss, rfs)
 		}
-		a.TrimPrefix(e.root)
-		fss = append(fss, &pdu.Filesystem{Path: a.ToString()})
 	}
 	return fss, nil
 }
 
-func (e *Receiver) ListFilesystemVersions(ctx context.Context, fs string) ([]*pdu.FilesystemVersion, error) {
-	lp, err := subroot{e.root}.MapToLocal(fs)
+// fillResumeToken reports the state of an interrupted receive of local in rfs.
+func (e *Receiver) fillResumeToken(ctx context.Context, local *zfs.DatasetPath, rfs *pdu.Filesystem) error {
+	token, err := zfs.ZFSGetReceiveResumeToken(local)
+	if err != nil || token == "" {
+		return err
+	}
+	rfs.ResumeToken = token
+	t, err := zfs.ParseResumeToken(ctx, token)
+	if err != nil {
+		// the planner then discards the interrupted receive
+		getLogger(ctx).
+			WithError(err).
+			WithField("fs", local.ToString()).
+			Warn("cannot decode receive resume token")
+		return nil
+	}
+	rfs.ResumeTokenFromGUID = t.FromGUID
+	rfs.ResumeTokenToGUID = t.ToGUID
+	return nil
+}
+
+func (e *Receiver) ListFilesystemVersions(ctx context.Context, fs string) (_ []*pdu.FilesystemVersion, err error) {
+	ctx, done := localCall(ctx, RPCListFilesystemVersions, e.callTimeout)
+	defer func() { err = done(err) }()
+	lp, err := e.mapToLocal(fs)
 	if err != nil {
 		return nil, err
 	}
 
-	fsvs, err := zfs.ZFSListFilesystemVersions(lp, nil)
+	fsvs, err := zfs.ZFSListFilesystemVersionsContext(ctx, lp, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -224,16 +744,38 @@ func (e *Receiver) ListFilesystemVersions(ctx context.Context, fs string) ([]*pd
 	return rfsvs, nil
 }
 
+// ListFilesystemVersionsBatch implements replication.BatchLister with one zfs list per pool.
+// Filesystems that are not received by the receiver are omitted.
+func (e *Receiver) ListFilesystemVersionsBatch(ctx context.Context, fss []string) (_ map[string][]*pdu.FilesystemVersion, err error) {
+	ctx, done := localCall(ctx, RPCListFilesystemVersions, e.callTimeout)
+	defer func() { err = done(err) }()
+	lps := make(map[string]*zfs.DatasetPath, len(fss))
+	for _, fs := range fss {
+		lp, err := e.mapToLocal(fs)
+		if err != nil {
+			continue // reported by ListFilesystemVersions
+		}
+		lps[fs] = lp
+	}
+	return listFilesystemVersionsBatch(ctx, lps, nil)
+}
+
 func (e *Receiver) Receive(ctx context.Context, req *pdu.ReceiveReq, sendStream io.ReadCloser) error {
 	defer sendStream.Close()
 
-	lp, err := subroot{e.root}.MapToLocal(req.Filesystem)
+	lp, err := e.mapToLocal(req.Filesystem)
 	if err != nil {
 		return err
 	}
+	ctx = WithLogger(ctx, getLogger(ctx).WithField("fs", lp.ToString()))
 
 	getLogger(ctx).Debug("incoming Receive")
 
+	if err := e.checkQuota(lp, req.GetExpectedSize()); err != nil {
+		getLogger(ctx).WithError(err).Error("refusing receive")
+		return err
+	}
+
 	// create placeholder parent filesystems as appropriate
 	var visitErr error
 	f := zfs.NewDatasetPathForest()
@@ -264,6 +806,15 @@ func (e *Receiver) Receive(ctx context.Context, req *pdu.ReceiveReq, sendStream
 		return visitErr
 	}
 
+	if e.resumable && req.ClearResumeToken {
+		// the stream does not continue an interrupted receive, zfs recv refuses it if one is pending
+		if _, err := zfs.ZFSGet(lp, []string{"name"}); err == nil {
+			if err := zfs.ZFSRecvClearResumeToken(lp.ToString()); err != nil {
+				return err
+			}
+		}
+	}
+
 	needForceRecv := false
 	props, err := zfs.ZFSGet(lp, []string{zfs.ZREPL_PLACEHOLDER_PROPERTY_NAME})
 	if err == nil {
@@ -272,10 +823,35 @@ func (e *Receiver) Receive(ctx context.Context, req *pdu.ReceiveReq, sendStream
 		}
 	}
 
-	args := make([]string, 0, 1)
+	if err == nil && !needForceRecv && !req.Raw {
+		// a plain stream would be re-encrypted with the receiver's key,
+		// and zfs cannot continue a raw-received filesystem with a plain incremental stream
+		encrypted, err := zfs.ZFSEncryptionEnabled(lp)
+		if err != nil {
+			return err
+		}
+		if encrypted {
+			return fmt.Errorf("target filesystem %q is encrypted, refusing to receive a non-raw stream (enable raw sends on the sender)", lp.ToString())
+		}
+	}
+
+	// fail before zfs recv consumes the stream, its error messages for unsupported streams are not helpful
+	pool := strings.SplitN(lp.ToString(), "/", 2)[0]
+	if err := req.StreamFeatures().CheckPoolSupport(pool); err != nil {
+		return errors.Wrap(err, "cannot receive stream, disable the corresponding send option")
+	}
+
+	recvProps := req.RecvProperties().Merge(e.recvProps)
+	if err := recvProps.Validate(); err != nil {
+		return errors.Wrap(err, "invalid receive properties")
+	}
+
+	args := make([]string, 0, 1+len(e.recvFlags))
 	if needForceRecv {
 		args = append(args, "-F")
 	}
+	args = append(args, e.recvFlags...)
+	args = append(args, recvProps.Args()...)
 
 	getLogger(ctx).Debug("start receive command")
 
@@ -287,44 +863,336 @@ func (e *Receiver) Receive(ctx context.Context, req *pdu.ReceiveReq, sendStream
 		sendStream.Close()
 		return err
 	}
+
+	if needForceRecv {
+		// otherwise, the received filesystem would remain hidden from the planner,
+		// which would then try to overwrite it with a full send again
+		if err := zfs.ZFSSetPlaceholder(lp, false); err != nil {
+			getLogger(ctx).
+				WithError(err).
+				Error("cannot convert placeholder into received filesystem")
+			return err
+		}
+	}
+
+	if e.postReceive != nil {
+		if req.GetToSnapshot() == "" {
+			// older senders do not tell, and the newest snapshot of lp need not be the received one
+			getLogger(ctx).Warn("sender did not name the received snapshot, skipping post-receive hooks")
+		} else {
+			e.postReceive(ctx, lp, req.GetToSnapshot())
+		}
+	}
 	return nil
 }
 
-func (e *Receiver) DestroySnapshots(ctx context.Context, req *pdu.DestroySnapshotsReq) (*pdu.DestroySnapshotsRes, error) {
-	lp, err := subroot{e.root}.MapToLocal(req.Filesystem)
+// SetProperties applies the sender's properties to the received filesystem.
+// Properties specified by the receiver's recvProps take precedence and are left untouched.
+// The request is refused with ErrorCode_PermissionDenied if it contains other properties that are not accepted,
+// see SetAcceptedProperties.
+func (e *Receiver) SetProperties(ctx context.Context, req *pdu.SetPropertiesReq) (_ *pdu.SetPropertiesRes, err error) {
+	ctx, done := localCall(ctx, RPCSetProperties, e.callTimeout)
+	defer func() { err = done(err) }()
+	lp, err := e.mapToLocal(req.Filesystem)
 	if err != nil {
 		return nil, err
 	}
-	return doDestroySnapshots(ctx, lp, req.Snapshots)
-}
 
-func doDestroySnapshots(ctx context.Context, lp *zfs.DatasetPath, snaps []*pdu.FilesystemVersion) (*pdu.DestroySnapshotsRes, error) {
-	fsvs := make([]*zfs.FilesystemVersion, len(snaps))
-	for i, fsv := range snaps {
-		if fsv.Type != pdu.FilesystemVersion_Snapshot {
-			return nil, fmt.Errorf("version %q is not a snapshot", fsv.Name)
-		}
-		var err error
-		fsvs[i], err = fsv.ZFSFilesystemVersion()
-		if err != nil {
-			return nil, err
-		}
+	receiverOwned := make(map[string]bool, len(e.recvProps.Override)+len(e.recvProps.Inherit))
+	for name := range e.recvProps.Override {
+		receiverOwned[name] = true
 	}
-	res := &pdu.DestroySnapshotsRes{
-		Results: make([]*pdu.DestroySnapshotRes, len(fsvs)),
+	for _, name := range e.recvProps.Inherit {
+		receiverOwned[name] = true
 	}
-	for i, fsv := range fsvs {
-		err := zfs.ZFSDestroyFilesystemVersion(lp, fsv)
-		errMsg := ""
-		if err != nil {
-			errMsg = err.Error()
+	props := zfs.RecvProperties{Override: make(map[string]string, len(req.Set))}
+	for _, p := range req.Set {
+		if !receiverOwned[p.GetName()] {
+			props.Override[p.GetName()] = p.GetValue()
 		}
-		res.Results[i] = &pdu.DestroySnapshotRes{
-			Snapshot: pdu.FilesystemVersionFromZFS(fsv),
-			Error:    errMsg,
+	}
+	for _, name := range req.Inherit {
+		if !receiverOwned[name] {
+			props.Inherit = append(props.Inherit, name)
 		}
 	}
-	return res, nil
+	for name := range props.Override {
+		if !e.acceptedProps.Allows(name) {
+			return nil, pdu.NewError(pdu.ErrorCode_PermissionDenied, "the receiver does not accept property %q", name)
+		}
+	}
+	for _, name := range props.Inherit {
+		if !e.acceptedProps.Allows(name) {
+			return nil, pdu.NewError(pdu.ErrorCode_PermissionDenied, "the receiver does not accept property %q", name)
+		}
+	}
+	if err := props.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid properties")
+	}
+
+	if len(props.Override) > 0 {
+		set := zfs.NewZFSProperties()
+		for name, value := range props.Override {
+			set.Set(name, value)
+		}
+		if err := zfs.ZFSSet(lp, set); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range props.Inherit {
+		if err := zfs.ZFSInherit(lp, name); err != nil {
+			return nil, err
+		}
+	}
+	return &pdu.SetPropertiesRes{}, nil
+}
+
+// ResolveDivergence resolves a receiving filesystem that has versions the sender does not have
+// after the most recent common snapshot, as requested by the planner of the active side.
+func (e *Receiver) ResolveDivergence(ctx context.Context, req *pdu.ResolveDivergenceReq) (*pdu.ResolveDivergenceRes, error) {
+	lp, err := e.mapToLocal(req.Filesystem)
+	if err != nil {
+		return nil, err
+	}
+	log := getLogger(ctx).WithField("fs", lp.ToString())
+
+	switch req.Action {
+	case pdu.ResolveDivergenceReq_Rollback:
+		if req.CommonAncestor == nil || req.CommonAncestor.Type != pdu.FilesystemVersion_Snapshot {
+			return nil, pdu.NewError(pdu.ErrorCode_InvalidArgument, "rollback requires a common ancestor snapshot")
+		}
+		fsvs, err := zfs.ZFSListFilesystemVersions(lp, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkRollbackDestroysOnly(fsvs, req.CommonAncestor, req.Diverged); err != nil {
+			return nil, err
+		}
+		log.WithField("snapshot", req.CommonAncestor.Name).
+			WithField("diverged", len(req.Diverged)).
+			Warn("rolling back diverged filesystem to the most recent common snapshot")
+		if err := zfs.ZFSRollback(lp, req.CommonAncestor.Name); err != nil {
+			return nil, err
+		}
+		return &pdu.ResolveDivergenceRes{}, nil
+
+	case pdu.ResolveDivergenceReq_Rename:
+		children, err := zfs.ZFSList([]string{"name"}, "-r", "-d", "1", "-t", "filesystem,volume", lp.ToString())
+		if err != nil {
+			return nil, err
+		}
+		if len(children) > 1 {
+			return nil, pdu.NewError(pdu.ErrorCode_InvalidArgument,
+				"cannot rename %s aside: its child filesystems would be renamed along", lp.ToString())
+		}
+		to, err := zfs.NewDatasetPath(fmt.Sprintf("%s_zrepl_diverged_%s", lp.ToString(), time.Now().UTC().Format("20060102_150405")))
+		if err != nil {
+			return nil, err
+		}
+		log.WithField("renamed_to", to.ToString()).Warn("renaming diverged filesystem aside")
+		if err := zfs.ZFSRename(lp, to); err != nil {
+			return nil, err
+		}
+		return &pdu.ResolveDivergenceRes{RenamedTo: to.ToString()}, nil
+
+	default:
+		return nil, pdu.NewError(pdu.ErrorCode_InvalidArgument, "unknown action %s", req.Action)
+	}
+}
+
+// checkRollbackDestroysOnly returns an error if rolling back to ancestor would destroy versions other than diverged,
+// e.g. snapshots that were created on the receiver after the planner listed its versions.
+func checkRollbackDestroysOnly(fsvs []zfs.FilesystemVersion, ancestor *pdu.FilesystemVersion, diverged []*pdu.FilesystemVersion) error {
+	expected := make(map[uint64]bool, len(diverged))
+	for _, v := range diverged {
+		expected[v.Guid] = true
+	}
+	var ancestorTXG uint64
+	found := false
+	for _, v := range fsvs {
+		if v.Type == zfs.Snapshot && v.Guid == ancestor.Guid {
+			ancestorTXG, found = v.CreateTXG, true
+		}
+	}
+	if !found {
+		return pdu.NewError(pdu.ErrorCode_NotFound, "common ancestor @%s does not exist on the receiver", ancestor.Name)
+	}
+	for _, v := range fsvs {
+		if v.CreateTXG > ancestorTXG && !expected[v.Guid] {
+			return pdu.NewError(pdu.ErrorCode_InvalidArgument,
+				"refusing to roll back to @%s: it would also destroy %s, which was not considered diverged", ancestor.Name, v.String())
+		}
+	}
+	return nil
+}
+
+func (e *Receiver) DestroySnapshots(ctx context.Context, req *pdu.DestroySnapshotsReq) (*pdu.DestroySnapshotsRes, error) {
+	lp, err := e.mapToLocal(req.Filesystem)
+	if err != nil {
+		return nil, err
+	}
+	return doDestroySnapshots(ctx, lp, req.Snapshots, true, req.GetCursorGuid(), nil)
+}
+
+func (e *Receiver) DestroySnapshotsSubmit(ctx context.Context, req *pdu.DestroySnapshotsReq) (*pdu.DestroySnapshotsSubmitRes, error) {
+	lp, err := e.mapToLocal(req.Filesystem)
+	if err != nil {
+		return nil, err
+	}
+	return submitDestroySnapshots(ctx, destroyOwner("receiver", e.clientIdentity), lp, req.Snapshots, true, req.GetCursorGuid()), nil
+}
+
+func (e *Receiver) DestroySnapshotsPoll(ctx context.Context, req *pdu.DestroySnapshotsPollReq) (*pdu.DestroySnapshotsPollRes, error) {
+	return asyncDestroys.poll(destroyOwner("receiver", e.clientIdentity), req.ID)
+}
+
+// submitDestroySnapshots destroys snaps in the background, independent of ctx, which usually
+// belongs to the RPC that submitted the destroy operation. Only owner can poll the operation.
+func submitDestroySnapshots(ctx context.Context, owner string, lp *zfs.DatasetPath, snaps []*pdu.FilesystemVersion, protectLastCommon bool, cursorGuid uint64) *pdu.DestroySnapshotsSubmitRes {
+	bgCtx := WithLogger(context.Background(), getLogger(ctx))
+	id := asyncDestroys.submit(owner, len(snaps), func(progress func(int)) (*pdu.DestroySnapshotsRes, error) {
+		return doDestroySnapshots(bgCtx, lp, snaps, protectLastCommon, cursorGuid, progress)
+	})
+	return &pdu.DestroySnapshotsSubmitRes{ID: id}
+}
+
+// If protectLastCommon is set, the snapshots of lp that the next incremental receive may build upon
+// are not destroyed but refused with ErrorCode_LastCommonSnapshot, see lastCommonSnapshots.
+// They are looked up in the versions of lp, the guids in snaps are not trusted.
+// (The sending side needs no such protection because the replication cursor bookmark can be the incremental source.)
+//
+// snaps may contain bookmarks, except for the replication cursor.
+//
+// progress, if not nil, is called with the number of snapshots processed so far.
+func doDestroySnapshots(ctx context.Context, lp *zfs.DatasetPath, snaps []*pdu.FilesystemVersion, protectLastCommon bool, cursorGuid uint64, progress func(destroyed int)) (*pdu.DestroySnapshotsRes, error) {
+	fsvs := make([]*zfs.FilesystemVersion, len(snaps))
+	for i, fsv := range snaps {
+		if fsv.Type == pdu.FilesystemVersion_Bookmark && fsv.Name == zfs.ReplicationCursorBookmarkName {
+			return nil, pdu.NewError(pdu.ErrorCode_InvalidArgument, "refusing to destroy the replication cursor")
+		}
+		var err error
+		fsvs[i], err = fsv.ZFSFilesystemVersion()
+		if err != nil {
+			return nil, err
+		}
+	}
+	var protected map[string]string
+	if protectLastCommon {
+		local, err := zfsListFilesystemVersions(ctx, lp, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot list versions to protect the last common snapshot")
+		}
+		protected = lastCommonSnapshots(local, cursorGuid)
+	}
+	res := &pdu.DestroySnapshotsRes{
+		Results: make([]*pdu.DestroySnapshotRes, len(fsvs)),
+	}
+	processed := 0
+	reportProgress := func(n int) {
+		processed += n
+		if progress != nil {
+			progress(processed)
+		}
+	}
+	// snapshots are destroyed in batches after the bookmarks, indices into fsvs and res.Results
+	var snapshots []int
+	for i, fsv := range fsvs {
+		res.Results[i] = &pdu.DestroySnapshotRes{
+			Snapshot: pdu.FilesystemVersionFromZFS(fsv),
+		}
+		reason, isProtected := protected[fsv.Name]
+		switch {
+		case isProtected && fsv.Type == zfs.Snapshot:
+			getLogger(ctx).
+				WithField("fs", lp.ToString()).
+				WithField("snapshot", fsv.String()).
+				Warn("refusing to destroy the last common snapshot, check the pruning rules of the receiving side")
+			res.Results[i].Error = pdu.NewError(pdu.ErrorCode_LastCommonSnapshot, "refusing to destroy %s: %s", fsv.String(), reason)
+			reportProgress(1)
+		case fsv.Type == zfs.Snapshot:
+			snapshots = append(snapshots, i)
+		default:
+			res.Results[i].Error = destroyError(zfs.ZFSDestroyFilesystemVersion(lp, fsv))
+			reportProgress(1)
+		}
+	}
+	names := make([]string, len(snapshots))
+	for j, i := range snapshots {
+		names[j] = fsvs[i].Name
+	}
+	batchProcessed := 0
+	errs := zfs.ZFSDestroySnapshots(ctx, lp, names, func(destroyed int) {
+		reportProgress(destroyed - batchProcessed)
+		batchProcessed = destroyed
+	})
+	for j, i := range snapshots {
+		res.Results[i].Error = destroyError(errs[j])
+		if res.Results[i].Error != nil && res.Results[i].Error.Code == pdu.ErrorCode_Busy {
+			res.Results[i].Error = heldError(lp, fsvs[i].Name, res.Results[i].Error)
+		}
+	}
+	return res, nil
+}
+
+// zfsListFilesystemVersions lists the versions of a receiving filesystem for doDestroySnapshots, replaced by tests.
+var zfsListFilesystemVersions = zfs.ZFSListFilesystemVersionsContext
+
+// lastCommonSnapshots returns the snapshots in fsvs, the versions of a receiving filesystem,
+// that must not be destroyed, by name, with the reason:
+// the newest snapshot is the incremental source of the next receive,
+// and the snapshot with guid cursorGuid, unless 0, is at the sender's replication cursor.
+func lastCommonSnapshots(fsvs []zfs.FilesystemVersion, cursorGuid uint64) map[string]string {
+	protected := make(map[string]string)
+	var newest *zfs.FilesystemVersion
+	for i := range fsvs {
+		v := &fsvs[i]
+		if v.Type != zfs.Snapshot {
+			continue
+		}
+		if cursorGuid != 0 && v.Guid == cursorGuid {
+			protected[v.Name] = "it is the snapshot at the replication cursor, which the next incremental replication builds upon"
+		}
+		if newest == nil || v.CreateTXG > newest.CreateTXG {
+			newest = v
+		}
+	}
+	if newest == nil {
+		return protected
+	}
+	if _, ok := protected[newest.Name]; !ok {
+		protected[newest.Name] = "it is the newest snapshot, which the next incremental replication builds upon"
+	}
+	return protected
+}
+
+// heldError returns an ErrorCode_Held error if the snapshot whose destroy failed with busyErr has holds, busyErr otherwise:
+// zfs destroy fails with the same error for held snapshots as for snapshots that are in use.
+func heldError(lp *zfs.DatasetPath, name string, busyErr *pdu.Error) *pdu.Error {
+	tags, err := zfs.ZFSHoldTags(lp, name)
+	if err != nil || len(tags) == 0 {
+		return busyErr
+	}
+	return pdu.NewError(pdu.ErrorCode_Held, "snapshot %s@%s has holds: %s", lp.ToString(), name, strings.Join(tags, ", "))
+}
+
+// destroyError classifies the error of a zfs destroy, nil if there is none.
+func destroyError(err error) *pdu.Error {
+	if err == nil {
+		return nil
+	}
+	code := pdu.ErrorCode_Internal
+	if zfsErr, ok := err.(zfs.ZFSError); ok {
+		switch {
+		case bytes.Contains(zfsErr.Stderr, []byte("could not find any snapshots to destroy")),
+			bytes.Contains(zfsErr.Stderr, []byte("dataset does not exist")):
+			code = pdu.ErrorCode_NotFound
+		case bytes.Contains(zfsErr.Stderr, []byte("dataset is busy")):
+			code = pdu.ErrorCode_Busy
+		case bytes.Contains(zfsErr.Stderr, []byte("snapshot has dependent clones")):
+			code = pdu.ErrorCode_HasClones
+		}
+	}
+	return &pdu.Error{Code: code, Message: err.Error()}
 }
 
 // =-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=-=
@@ -336,58 +1204,139 @@ const (
 	RPCListFilesystemVersions = "ListFilesystemVersions"
 	RPCReceive                = "Receive"
 	RPCSend                   = "Send"
+	RPCSendEstimates          = "SendEstimates"
 	RPCSDestroySnapshots      = "DestroySnapshots"
+	RPCDestroySnapshotsSubmit = "DestroySnapshotsSubmit"
+	RPCDestroySnapshotsPoll   = "DestroySnapshotsPoll"
 	RPCReplicationCursor      = "ReplicationCursor"
+	RPCBookmark               = "Bookmark"
+	RPCSetStepHolds           = "SetStepHolds"
+	RPCGetProperties          = "GetProperties"
+	RPCSetProperties          = "SetProperties"
+	RPCResolveDivergence      = "ResolveDivergence"
+	RPCPing                   = "Ping"
 )
 
 // Remote implements an endpoint stub that uses streamrpc as a transport.
+// Remote is safe for concurrent use: each request occupies one of the clients
+// until the reply, or if the reply contains a stream, until that stream is closed.
 type Remote struct {
-	c *streamrpc.Client
+	clients     chan *streamrpc.Client
+	callTimeout time.Duration
+	// nil if the features of the peer are unknown, see WithPeerFeatures
+	peerFeatures func() map[transport.Feature]bool
 }
 
-func NewRemote(c *streamrpc.Client) Remote {
-	return Remote{c}
+// WithPeerFeatures returns a copy of s that only issues optional requests if the peer advertised the
+// corresponding feature, features returns those of the most recent handshake with the peer.
+// Without peer features, s assumes that the peer implements all requests.
+func (s Remote) WithPeerFeatures(features func() map[transport.Feature]bool) Remote {
+	s.peerFeatures = features
+	return s
 }
 
-func (s Remote) ListFilesystems(ctx context.Context) ([]*pdu.Filesystem, error) {
-	req := pdu.ListFilesystemReq{}
-	b, err := proto.Marshal(&req)
-	if err != nil {
-		return nil, err
+func NewRemote(clients ...*streamrpc.Client) Remote {
+	if len(clients) == 0 {
+		panic("at least one client is required")
+	}
+	r := Remote{clients: make(chan *streamrpc.Client, len(clients))}
+	for _, c := range clients {
+		r.clients <- c
 	}
-	rb, rs, err := s.c.RequestReply(ctx, RPCListFilesystems, bytes.NewBuffer(b), nil)
+	return r
+}
+
+// releasingStream returns the client that produced it to the Remote's pool on Close.
+type releasingStream struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (s *releasingStream) Close() error {
+	err := s.ReadCloser.Close()
+	s.once.Do(s.release)
+	return err
+}
+
+func (s Remote) requestReply(ctx context.Context, rpc string, reqStructured *bytes.Buffer, reqStream io.ReadCloser) (*bytes.Buffer, io.ReadCloser, error) {
+	c, err := s.acquire(ctx, rpc)
 	if err != nil {
+		return nil, nil, err
+	}
+	return s.requestReplyClient(ctx, c, rpc, reqStructured, reqStream)
+}
+
+// acquire takes a client for rpc from the pool, it must be returned by requestReplyClient.
+func (s Remote) acquire(ctx context.Context, rpc string) (*streamrpc.Client, error) {
+	if err := faultinject.FromEnv().Inject("client." + rpc); err != nil {
 		return nil, err
 	}
-	if rs != nil {
+	select {
+	case c := <-s.clients:
+		return c, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// requestReplyClient performs rpc with c, which is returned to the pool after the reply,
+// or if the reply contains a stream, after that stream is closed.
+func (s Remote) requestReplyClient(ctx context.Context, c *streamrpc.Client, rpc string, reqStructured *bytes.Buffer, reqStream io.ReadCloser) (*bytes.Buffer, io.ReadCloser, error) {
+	release := func() { s.clients <- c }
+	reqLen, begin := reqStructured.Len(), time.Now()
+	rb, rs, err := c.RequestReply(ctx, rpc, reqStructured, reqStream)
+	err = pdu.ErrorFromWire(err)
+	logRPC(ctx, "client", rpc, begin, reqLen, rb, rs != nil, err)
+	if err != nil && rs != nil {
 		rs.Close()
-		return nil, errors.New("response contains unexpected stream")
+		rs = nil
 	}
-	var res pdu.ListFilesystemRes
-	if err := proto.Unmarshal(rb.Bytes(), &res); err != nil {
-		return nil, err
+	if rs == nil {
+		release()
+		return rb, nil, err
 	}
-	return res.Filesystems, nil
+	return rb, &releasingStream{ReadCloser: rs, release: release}, nil
 }
 
-func (s Remote) ListFilesystemVersions(ctx context.Context, fs string) ([]*pdu.FilesystemVersion, error) {
-	req := pdu.ListFilesystemVersionsReq{
-		Filesystem: fs,
-	}
-	b, err := proto.Marshal(&req)
+// call performs the control-plane RPC rpc, i.e. one whose request and reply are structured only.
+// Streams are reserved for the bulk RPCs Send, Receive and Ping.
+func (s Remote) call(ctx context.Context, rpc string, req, res proto.Message) error {
+	b, err := proto.Marshal(req)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	var rb *bytes.Buffer
+	var rs io.ReadCloser
+	if hasCallTimeout(rpc) {
+		rb, rs, err = s.requestReplyTimeout(ctx, rpc, bytes.NewBuffer(b))
+	} else {
+		rb, rs, err = s.requestReply(ctx, rpc, bytes.NewBuffer(b), nil)
 	}
-	rb, rs, err := s.c.RequestReply(ctx, RPCListFilesystemVersions, bytes.NewBuffer(b), nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if rs != nil {
 		rs.Close()
-		return nil, errors.New("response contains unexpected stream")
+		return errors.Errorf("response to %s contains unexpected stream", rpc)
+	}
+	if err := proto.Unmarshal(rb.Bytes(), res); err != nil {
+		return errors.Wrapf(err, "cannot unmarshal response to %s", rpc)
+	}
+	return nil
+}
+
+func (s Remote) ListFilesystems(ctx context.Context) ([]*pdu.Filesystem, error) {
+	var res pdu.ListFilesystemRes
+	if err := s.call(ctx, RPCListFilesystems, &pdu.ListFilesystemReq{}, &res); err != nil {
+		return nil, err
 	}
+	return res.Filesystems, nil
+}
+
+func (s Remote) ListFilesystemVersions(ctx context.Context, fs string) ([]*pdu.FilesystemVersion, error) {
 	var res pdu.ListFilesystemVersionsRes
-	if err := proto.Unmarshal(rb.Bytes(), &res); err != nil {
+	if err := s.call(ctx, RPCListFilesystemVersions, &pdu.ListFilesystemVersionsReq{Filesystem: fs}, &res); err != nil {
 		return nil, err
 	}
 	return res.Versions, nil
@@ -398,7 +1347,14 @@ func (s Remote) Send(ctx context.Context, r *pdu.SendReq) (*pdu.SendRes, io.Read
 	if err != nil {
 		return nil, nil, err
 	}
-	rb, rs, err := s.c.RequestReply(ctx, RPCSend, bytes.NewBuffer(b), nil)
+	var rb *bytes.Buffer
+	var rs io.ReadCloser
+	if r.DryRun {
+		// size estimates during planning must not block on a busy pool
+		rb, rs, err = s.requestReplyTimeout(ctx, RPCSend, bytes.NewBuffer(b))
+	} else {
+		rb, rs, err = s.requestReply(ctx, RPCSend, bytes.NewBuffer(b), nil)
+	}
 	if err != nil {
 		return nil, nil, err
 	}
@@ -411,7 +1367,9 @@ func (s Remote) Send(ctx context.Context, r *pdu.SendReq) (*pdu.SendRes, io.Read
 	}
 	var res pdu.SendRes
 	if err := proto.Unmarshal(rb.Bytes(), &res); err != nil {
-		rs.Close()
+		if rs != nil {
+			rs.Close()
+		}
 		return nil, nil, err
 	}
 	return &res, rs, nil
@@ -423,7 +1381,7 @@ func (s Remote) Receive(ctx context.Context, r *pdu.ReceiveReq, sendStream io.Re
 	if err != nil {
 		return err
 	}
-	rb, rs, err := s.c.RequestReply(ctx, RPCReceive, bytes.NewBuffer(b), sendStream)
+	rb, rs, err := s.requestReply(ctx, RPCReceive, bytes.NewBuffer(b), sendStream)
 	getLogger(ctx).WithField("err", err).Debug("Remote.Receive RequestReplyReturned")
 	if err != nil {
 		return err
@@ -440,46 +1398,102 @@ func (s Remote) Receive(ctx context.Context, r *pdu.ReceiveReq, sendStream io.Re
 }
 
 func (s Remote) DestroySnapshots(ctx context.Context, r *pdu.DestroySnapshotsReq) (*pdu.DestroySnapshotsRes, error) {
-	b, err := proto.Marshal(r)
-	if err != nil {
-		return nil, err
-	}
-	rb, rs, err := s.c.RequestReply(ctx, RPCSDestroySnapshots, bytes.NewBuffer(b), nil)
-	if err != nil {
-		return nil, err
-	}
-	if rs != nil {
-		rs.Close()
-		return nil, errors.New("response contains unexpected stream")
-	}
 	var res pdu.DestroySnapshotsRes
-	if err := proto.Unmarshal(rb.Bytes(), &res); err != nil {
-		return nil, err
-	}
-	return &res, nil
+	return &res, s.call(ctx, RPCSDestroySnapshots, r, &res)
+}
+
+func (s Remote) DestroySnapshotsSubmit(ctx context.Context, r *pdu.DestroySnapshotsReq) (*pdu.DestroySnapshotsSubmitRes, error) {
+	var res pdu.DestroySnapshotsSubmitRes
+	return &res, s.call(ctx, RPCDestroySnapshotsSubmit, r, &res)
+}
+
+func (s Remote) DestroySnapshotsPoll(ctx context.Context, r *pdu.DestroySnapshotsPollReq) (*pdu.DestroySnapshotsPollRes, error) {
+	var res pdu.DestroySnapshotsPollRes
+	return &res, s.call(ctx, RPCDestroySnapshotsPoll, r, &res)
 }
 
 func (s Remote) ReplicationCursor(ctx context.Context, req *pdu.ReplicationCursorReq) (*pdu.ReplicationCursorRes, error) {
+	var res pdu.ReplicationCursorRes
+	return &res, s.call(ctx, RPCReplicationCursor, req, &res)
+}
+
+func (s Remote) Bookmark(ctx context.Context, req *pdu.BookmarkReq) (*pdu.BookmarkRes, error) {
+	var res pdu.BookmarkRes
+	return &res, s.call(ctx, RPCBookmark, req, &res)
+}
+
+// SendEstimates returns fsrep.ErrSendEstimatesUnsupported if the peer did not advertise
+// transport.FeatureSendEstimates in the handshake, see WithPeerFeatures.
+func (s Remote) SendEstimates(ctx context.Context, req *pdu.SendEstimatesReq) (*pdu.SendEstimatesRes, error) {
+	if s.peerFeatures != nil && !s.peerFeatures()[transport.FeatureSendEstimates] {
+		return nil, fsrep.ErrSendEstimatesUnsupported
+	}
+	var res pdu.SendEstimatesRes
+	return &res, s.call(ctx, RPCSendEstimates, req, &res)
+}
+
+func (s Remote) SetStepHolds(ctx context.Context, req *pdu.SetStepHoldsReq) (*pdu.SetStepHoldsRes, error) {
+	var res pdu.SetStepHoldsRes
+	return &res, s.call(ctx, RPCSetStepHolds, req, &res)
+}
+
+func (s Remote) GetProperties(ctx context.Context, req *pdu.GetPropertiesReq) (*pdu.GetPropertiesRes, error) {
+	var res pdu.GetPropertiesRes
+	return &res, s.call(ctx, RPCGetProperties, req, &res)
+}
+
+func (s Remote) SetProperties(ctx context.Context, req *pdu.SetPropertiesReq) (*pdu.SetPropertiesRes, error) {
+	var res pdu.SetPropertiesRes
+	return &res, s.call(ctx, RPCSetProperties, req, &res)
+}
+
+func (s Remote) ResolveDivergence(ctx context.Context, req *pdu.ResolveDivergenceReq) (*pdu.ResolveDivergenceRes, error) {
+	var res pdu.ResolveDivergenceRes
+	return &res, s.call(ctx, RPCResolveDivergence, req, &res)
+}
+
+// Ping sends req and, if reqStream is not nil, the stream to the remote endpoint.
+// The returned stream is non-nil iff req.ReplyStreamLength is non-zero.
+func (s Remote) Ping(ctx context.Context, req *pdu.PingReq, reqStream io.ReadCloser) (*pdu.PingRes, io.ReadCloser, error) {
 	b, err := proto.Marshal(req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	rb, rs, err := s.c.RequestReply(ctx, RPCReplicationCursor, bytes.NewBuffer(b), nil)
+	rb, rs, err := s.requestReply(ctx, RPCPing, bytes.NewBuffer(b), reqStream)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	if rs != nil {
+	if req.ReplyStreamLength > 0 && rs == nil {
+		return nil, nil, errors.New("response does not contain a stream")
+	}
+	if req.ReplyStreamLength == 0 && rs != nil {
 		rs.Close()
-		return nil, errors.New("response contains unexpected stream")
+		return nil, nil, errors.New("response contains unexpected stream")
 	}
-	var res pdu.ReplicationCursorRes
+	var res pdu.PingRes
 	if err := proto.Unmarshal(rb.Bytes(), &res); err != nil {
-		return nil, err
+		if rs != nil {
+			rs.Close()
+		}
+		return nil, nil, err
 	}
-	return &res, nil
+	return &res, rs, nil
 }
 
 // Handler implements the server-side streamrpc.HandlerFunc for a Remote endpoint stub.
+// asyncDestroyer is implemented by Sender and Receiver.
+type asyncDestroyer interface {
+	DestroySnapshotsSubmit(ctx context.Context, req *pdu.DestroySnapshotsReq) (*pdu.DestroySnapshotsSubmitRes, error)
+	DestroySnapshotsPoll(ctx context.Context, req *pdu.DestroySnapshotsPollReq) (*pdu.DestroySnapshotsPollRes, error)
+}
+
+var _ replication.BatchLister = &Sender{}
+var _ replication.BatchLister = &Receiver{}
+
+var _ asyncDestroyer = &Sender{}
+var _ asyncDestroyer = &Receiver{}
+var _ asyncDestroyer = Remote{}
+
 type Handler struct {
 	ep replication.Endpoint
 }
@@ -488,56 +1502,210 @@ func NewHandler(ep replication.Endpoint) Handler {
 	return Handler{ep}
 }
 
+// Handle returns errors with their pdu.ErrorCode encoded, see pdu.WireError.
 func (a *Handler) Handle(ctx context.Context, endpoint string, reqStructured *bytes.Buffer, reqStream io.ReadCloser) (resStructured *bytes.Buffer, resStream io.ReadCloser, err error) {
-
-	switch endpoint {
-	case RPCListFilesystems:
-		var req pdu.ListFilesystemReq
-		if err := proto.Unmarshal(reqStructured.Bytes(), &req); err != nil {
-			return nil, nil, err
-		}
-		fsses, err := a.ep.ListFilesystems(ctx)
-		if err != nil {
-			return nil, nil, err
-		}
-		res := &pdu.ListFilesystemRes{
-			Filesystems: fsses,
-		}
-		b, err := proto.Marshal(res)
-		if err != nil {
-			return nil, nil, err
+	reqLen, begin := reqStructured.Len(), time.Now()
+	resStructured, resStream, err = a.handle(ctx, endpoint, reqStructured, reqStream)
+	logRPC(ctx, "server", endpoint, begin, reqLen, resStructured, resStream != nil, err)
+	if err != nil {
+		if _, ok := err.(*replication.FilteredError); ok {
+			err = pdu.NewError(pdu.ErrorCode_PermissionDenied, "%s", err)
 		}
-		return bytes.NewBuffer(b), nil, nil
+		err = pdu.WireError(err)
+	}
+	return resStructured, resStream, err
+}
 
-	case RPCListFilesystemVersions:
+// logRPC logs an RPC at debug level, e.g. for the job's debug journal.
+// The payload is not logged, only its size: streams are only reported as present.
+func logRPC(ctx context.Context, side, rpc string, begin time.Time, reqLen int, res *bytes.Buffer, stream bool, err error) {
+	l := getLogger(ctx).
+		WithField("rpc", rpc).
+		WithField("side", side).
+		WithField("duration", time.Since(begin).String()).
+		WithField("req_bytes", reqLen).
+		WithField("stream", stream)
+	if res != nil {
+		l = l.WithField("res_bytes", res.Len())
+	}
+	if err != nil {
+		l.WithError(err).Debug("rpc failed")
+		return
+	}
+	l.Debug("rpc")
+}
 
-		var req pdu.ListFilesystemVersionsReq
-		if err := proto.Unmarshal(reqStructured.Bytes(), &req); err != nil {
-			return nil, nil, err
+// errNoHandler is returned by a controlRPC if the endpoint does not implement the call, e.g. Send on a Receiver.
+var errNoHandler = errors.New("no handler")
+
+// A controlRPC is a call whose request and reply are structured only, see Remote.call.
+// Adding one requires an RPC name, a Remote stub and an entry in controlRPCs.
+type controlRPC struct {
+	newReq func() proto.Message
+	handle func(ctx context.Context, ep replication.Endpoint, req proto.Message) (proto.Message, error)
+}
+
+var controlRPCs = map[string]controlRPC{
+	RPCListFilesystems: {
+		func() proto.Message { return &pdu.ListFilesystemReq{} },
+		func(ctx context.Context, ep replication.Endpoint, req proto.Message) (proto.Message, error) {
+			fss, err := ep.ListFilesystems(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return &pdu.ListFilesystemRes{Filesystems: fss}, nil
+		},
+	},
+	RPCListFilesystemVersions: {
+		func() proto.Message { return &pdu.ListFilesystemVersionsReq{} },
+		func(ctx context.Context, ep replication.Endpoint, req proto.Message) (proto.Message, error) {
+			fsvs, err := ep.ListFilesystemVersions(ctx, req.(*pdu.ListFilesystemVersionsReq).Filesystem)
+			if err != nil {
+				return nil, err
+			}
+			return &pdu.ListFilesystemVersionsRes{Versions: fsvs}, nil
+		},
+	},
+	RPCSDestroySnapshots: {
+		func() proto.Message { return &pdu.DestroySnapshotsReq{} },
+		func(ctx context.Context, ep replication.Endpoint, req proto.Message) (proto.Message, error) {
+			return ep.DestroySnapshots(ctx, req.(*pdu.DestroySnapshotsReq))
+		},
+	},
+	RPCDestroySnapshotsSubmit: {
+		func() proto.Message { return &pdu.DestroySnapshotsReq{} },
+		func(ctx context.Context, ep replication.Endpoint, req proto.Message) (proto.Message, error) {
+			ad, ok := ep.(asyncDestroyer)
+			if !ok {
+				return nil, fmt.Errorf("endpoint does not support asynchronous destroys")
+			}
+			return ad.DestroySnapshotsSubmit(ctx, req.(*pdu.DestroySnapshotsReq))
+		},
+	},
+	RPCDestroySnapshotsPoll: {
+		func() proto.Message { return &pdu.DestroySnapshotsPollReq{} },
+		func(ctx context.Context, ep replication.Endpoint, req proto.Message) (proto.Message, error) {
+			ad, ok := ep.(asyncDestroyer)
+			if !ok {
+				return nil, fmt.Errorf("endpoint does not support asynchronous destroys")
+			}
+			return ad.DestroySnapshotsPoll(ctx, req.(*pdu.DestroySnapshotsPollReq))
+		},
+	},
+	RPCReplicationCursor: {
+		func() proto.Message { return &pdu.ReplicationCursorReq{} },
+		func(ctx context.Context, ep replication.Endpoint, req proto.Message) (proto.Message, error) {
+			sender, ok := ep.(replication.Sender)
+			if !ok {
+				return nil, errNoHandler
+			}
+			return sender.ReplicationCursor(ctx, req.(*pdu.ReplicationCursorReq))
+		},
+	},
+	RPCBookmark: {
+		func() proto.Message { return &pdu.BookmarkReq{} },
+		func(ctx context.Context, ep replication.Endpoint, req proto.Message) (proto.Message, error) {
+			sender, ok := ep.(replication.Sender)
+			if !ok {
+				return nil, errNoHandler
+			}
+			return sender.Bookmark(ctx, req.(*pdu.BookmarkReq))
+		},
+	},
+	RPCSendEstimates: {
+		func() proto.Message { return &pdu.SendEstimatesReq{} },
+		func(ctx context.Context, ep replication.Endpoint, req proto.Message) (proto.Message, error) {
+			sender, ok := ep.(fsrep.EstimateSender)
+			if !ok {
+				return nil, errNoHandler
+			}
+			return sender.SendEstimates(ctx, req.(*pdu.SendEstimatesReq))
+		},
+	},
+	RPCSetStepHolds: {
+		func() proto.Message { return &pdu.SetStepHoldsReq{} },
+		func(ctx context.Context, ep replication.Endpoint, req proto.Message) (proto.Message, error) {
+			sender, ok := ep.(replication.Sender)
+			if !ok {
+				return nil, errNoHandler
+			}
+			return sender.SetStepHolds(ctx, req.(*pdu.SetStepHoldsReq))
+		},
+	},
+	RPCGetProperties: {
+		func() proto.Message { return &pdu.GetPropertiesReq{} },
+		func(ctx context.Context, ep replication.Endpoint, req proto.Message) (proto.Message, error) {
+			sender, ok := ep.(replication.Sender)
+			if !ok {
+				return nil, errNoHandler
+			}
+			return sender.GetProperties(ctx, req.(*pdu.GetPropertiesReq))
+		},
+	},
+	RPCSetProperties: {
+		func() proto.Message { return &pdu.SetPropertiesReq{} },
+		func(ctx context.Context, ep replication.Endpoint, req proto.Message) (proto.Message, error) {
+			receiver, ok := ep.(replication.Receiver)
+			if !ok {
+				return nil, errNoHandler
+			}
+			return receiver.SetProperties(ctx, req.(*pdu.SetPropertiesReq))
+		},
+	},
+	RPCResolveDivergence: {
+		func() proto.Message { return &pdu.ResolveDivergenceReq{} },
+		func(ctx context.Context, ep replication.Endpoint, req proto.Message) (proto.Message, error) {
+			receiver, ok := ep.(replication.Receiver)
+			if !ok {
+				return nil, errNoHandler
+			}
+			return receiver.ResolveDivergence(ctx, req.(*pdu.ResolveDivergenceReq))
+		},
+	},
+}
+
+func (a *Handler) handle(ctx context.Context, endpoint string, reqStructured *bytes.Buffer, reqStream io.ReadCloser) (resStructured *bytes.Buffer, resStream io.ReadCloser, err error) {
+
+	noHandler := pdu.NewError(pdu.ErrorCode_InvalidArgument, "no handler for endpoint %q", endpoint)
+
+	if rpc, ok := controlRPCs[endpoint]; ok {
+		if reqStream != nil {
+			reqStream.Close()
+			return nil, nil, pdu.NewError(pdu.ErrorCode_InvalidArgument, "request to %s contains unexpected stream", endpoint)
+		}
+		req := rpc.newReq()
+		if err := proto.Unmarshal(reqStructured.Bytes(), req); err != nil {
+			return nil, nil, pdu.NewError(pdu.ErrorCode_InvalidArgument, "cannot unmarshal request: %s", err)
+		}
+		res, err := rpc.handle(ctx, a.ep, req)
+		if err == errNoHandler {
+			return nil, nil, noHandler
 		}
-		fsvs, err := a.ep.ListFilesystemVersions(ctx, req.Filesystem)
 		if err != nil {
 			return nil, nil, err
 		}
-		res := &pdu.ListFilesystemVersionsRes{
-			Versions: fsvs,
-		}
 		b, err := proto.Marshal(res)
 		if err != nil {
 			return nil, nil, err
 		}
 		return bytes.NewBuffer(b), nil, nil
+	}
+
+	// bulk RPCs, i.e. those with a stream in the request or reply
+	switch endpoint {
+	case RPCPing:
+		return handlePing(reqStructured, reqStream)
 
 	case RPCSend:
 
 		sender, ok := a.ep.(replication.Sender)
 		if !ok {
-			goto Err
+			return nil, nil, noHandler
 		}
 
 		var req pdu.SendReq
 		if err := proto.Unmarshal(reqStructured.Bytes(), &req); err != nil {
-			return nil, nil, err
+			return nil, nil, pdu.NewError(pdu.ErrorCode_InvalidArgument, "cannot unmarshal request: %s", err)
 		}
 		res, sendStream, err := sender.Send(ctx, &req)
 		if err != nil {
@@ -553,12 +1721,19 @@ func (a *Handler) Handle(ctx context.Context, endpoint string, reqStructured *by
 
 		receiver, ok := a.ep.(replication.Receiver)
 		if !ok {
-			goto Err
+			return nil, nil, noHandler
 		}
 
 		var req pdu.ReceiveReq
 		if err := proto.Unmarshal(reqStructured.Bytes(), &req); err != nil {
-			return nil, nil, err
+			return nil, nil, pdu.NewError(pdu.ErrorCode_InvalidArgument, "cannot unmarshal request: %s", err)
+		}
+		if len(req.OverrideProperties) > 0 || len(req.InheritProperties) > 0 {
+			// the requester is the sender, it must not control the properties of received filesystems
+			if reqStream != nil {
+				reqStream.Close()
+			}
+			return nil, nil, errors.New("receive properties must be configured on the receiving side")
 		}
 		err := receiver.Receive(ctx, &req, reqStream)
 		if err != nil {
@@ -570,45 +1745,38 @@ func (a *Handler) Handle(ctx context.Context, endpoint string, reqStructured *by
 		}
 		return bytes.NewBuffer(b), nil, err
 
-	case RPCSDestroySnapshots:
-
-		var req pdu.DestroySnapshotsReq
-		if err := proto.Unmarshal(reqStructured.Bytes(), &req); err != nil {
-			return nil, nil, err
-		}
-
-		res, err := a.ep.DestroySnapshots(ctx, &req)
-		if err != nil {
-			return nil, nil, err
-		}
-		b, err := proto.Marshal(res)
-		if err != nil {
-			return nil, nil, err
-		}
-		return bytes.NewBuffer(b), nil, nil
+	}
+	return nil, nil, noHandler
+}
 
-	case RPCReplicationCursor:
+// PingMaxReplyStreamLength limits the amount of data a client can request from a Handler via RPCPing.
+const PingMaxReplyStreamLength = 1 << 30
 
-		sender, ok := a.ep.(replication.Sender)
-		if !ok {
-			goto Err
-		}
-
-		var req pdu.ReplicationCursorReq
-		if err := proto.Unmarshal(reqStructured.Bytes(), &req); err != nil {
-			return nil, nil, err
-		}
-		res, err := sender.ReplicationCursor(ctx, &req)
-		if err != nil {
-			return nil, nil, err
-		}
-		b, err := proto.Marshal(res)
+// handlePing does not touch the endpoint so that it can be used to test the transport independently of zfs.
+func handlePing(reqStructured *bytes.Buffer, reqStream io.ReadCloser) (*bytes.Buffer, io.ReadCloser, error) {
+	var req pdu.PingReq
+	if err := proto.Unmarshal(reqStructured.Bytes(), &req); err != nil {
+		return nil, nil, pdu.NewError(pdu.ErrorCode_InvalidArgument, "cannot unmarshal request: %s", err)
+	}
+	if req.ReplyStreamLength > PingMaxReplyStreamLength {
+		return nil, nil, fmt.Errorf("requested reply stream length exceeds maximum of %d bytes", PingMaxReplyStreamLength)
+	}
+	res := &pdu.PingRes{Message: req.Message}
+	if reqStream != nil {
+		n, err := io.Copy(ioutil.Discard, reqStream)
+		reqStream.Close()
 		if err != nil {
 			return nil, nil, err
 		}
-		return bytes.NewBuffer(b), nil, nil
-
+		res.ReceivedStreamLength = uint64(n)
+	}
+	b, err := proto.Marshal(res)
+	if err != nil {
+		return nil, nil, err
+	}
+	var resStream io.ReadCloser
+	if req.ReplyStreamLength > 0 {
+		resStream = ioutil.NopCloser(io.LimitReader(util.ZeroReader{}, int64(req.ReplyStreamLength)))
 	}
-Err:
-	return nil, nil, errors.New("no handler for given endpoint")
+	return bytes.NewBuffer(b), resStream, nil
 }
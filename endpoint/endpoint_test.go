@@ -0,0 +1,364 @@
+package endpoint
+
+import (
+	"bytes"
+	"context"
+	"github.com/golang/protobuf/proto"
+	"github.com/problame/go-streamrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/daemon/transport"
+	"github.com/zrepl/zrepl/replication/fsrep"
+	"github.com/zrepl/zrepl/replication/pdu"
+	"github.com/zrepl/zrepl/zfs"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestReceiverMapToLocal(t *testing.T) {
+	p := func(s string) *zfs.DatasetPath {
+		d, err := zfs.NewDatasetPath(s)
+		require.NoError(t, err)
+		return d
+	}
+
+	rules := []RootRule{
+		{Sender: p("tank/vm"), Root: p("fastpool/vm")},
+		{Sender: p("tank"), Root: p("slowpool/tank")},
+	}
+	r, err := NewReceiver(p("slowpool/misc"), rules, nil, zfs.RecvProperties{})
+	require.NoError(t, err)
+
+	tcs := map[string]string{
+		"tank/vm/a":     "fastpool/vm/a",
+		"tank/vm/a/b":   "fastpool/vm/a/b",
+		"tank/vm":       "slowpool/tank/vm", // rules only apply to strict descendants
+		"tank/home":     "slowpool/tank/home",
+		"tank":          "slowpool/misc/tank",
+		"other/fs":      "slowpool/misc/other/fs",
+		"tank/vmfoobar": "slowpool/tank/vmfoobar",
+	}
+	for sender, expected := range tcs {
+		lp, err := r.mapToLocal(sender)
+		require.NoError(t, err)
+		assert.Equal(t, expected, lp.ToString(), "sender %q", sender)
+	}
+
+	_, err = r.mapToLocal("")
+	assert.Error(t, err)
+	name, err := r.LocalName("tank/vm/a")
+	require.NoError(t, err)
+	assert.Equal(t, "fastpool/vm/a", name)
+
+	_, err = NewReceiver(p("slowpool/misc"), []RootRule{{Sender: p(""), Root: p("fastpool")}}, nil, zfs.RecvProperties{})
+	assert.Error(t, err)
+}
+
+func TestHandlePing(t *testing.T) {
+	ping := func(req *pdu.PingReq, reqStream io.ReadCloser) (*pdu.PingRes, io.ReadCloser, error) {
+		b, err := proto.Marshal(req)
+		require.NoError(t, err)
+		resStructured, resStream, err := handlePing(bytes.NewBuffer(b), reqStream)
+		if err != nil {
+			return nil, nil, err
+		}
+		var res pdu.PingRes
+		require.NoError(t, proto.Unmarshal(resStructured.Bytes(), &res))
+		return &res, resStream, nil
+	}
+
+	res, stream, err := ping(&pdu.PingReq{Message: "hello"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", res.Message)
+	assert.Nil(t, stream)
+
+	upload := ioutil.NopCloser(bytes.NewReader(make([]byte, 4711)))
+	res, stream, err = ping(&pdu.PingReq{}, upload)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4711), res.ReceivedStreamLength)
+	assert.Nil(t, stream)
+
+	_, stream, err = ping(&pdu.PingReq{ReplyStreamLength: 4711}, nil)
+	require.NoError(t, err)
+	require.NotNil(t, stream)
+	n, err := io.Copy(ioutil.Discard, stream)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4711), n)
+
+	_, _, err = ping(&pdu.PingReq{ReplyStreamLength: PingMaxReplyStreamLength + 1}, nil)
+	assert.Error(t, err)
+}
+
+func TestCheckRollbackDestroysOnly(t *testing.T) {
+	fsvs := []zfs.FilesystemVersion{
+		{Type: zfs.Snapshot, Name: "a", Guid: 1, CreateTXG: 10},
+		{Type: zfs.Snapshot, Name: "b", Guid: 2, CreateTXG: 20},
+		{Type: zfs.Snapshot, Name: "x", Guid: 3, CreateTXG: 30},
+		{Type: zfs.Bookmark, Name: "x", Guid: 3, CreateTXG: 30},
+	}
+	ancestor := &pdu.FilesystemVersion{Type: pdu.FilesystemVersion_Snapshot, Name: "b", Guid: 2, CreateTXG: 20}
+	diverged := []*pdu.FilesystemVersion{
+		{Type: pdu.FilesystemVersion_Bookmark, Name: "x", Guid: 3, CreateTXG: 30},
+		{Type: pdu.FilesystemVersion_Snapshot, Name: "x", Guid: 3, CreateTXG: 30},
+	}
+	assert.NoError(t, checkRollbackDestroysOnly(fsvs, ancestor, diverged))
+
+	// created on the receiver after planning
+	fsvs = append(fsvs, zfs.FilesystemVersion{Type: zfs.Snapshot, Name: "y", Guid: 4, CreateTXG: 40})
+	assert.Error(t, checkRollbackDestroysOnly(fsvs, ancestor, diverged))
+
+	gone := &pdu.FilesystemVersion{Type: pdu.FilesystemVersion_Snapshot, Name: "c", Guid: 5, CreateTXG: 25}
+	err := checkRollbackDestroysOnly(fsvs, gone, diverged)
+	assert.Equal(t, pdu.ErrorCode_NotFound, pdu.Code(err))
+}
+
+func TestCheckResumeToken(t *testing.T) {
+	fsvs := []zfs.FilesystemVersion{
+		{Type: zfs.Snapshot, Name: "a", Guid: 1, CreateTXG: 10},
+		{Type: zfs.Bookmark, Name: "a", Guid: 1, CreateTXG: 10},
+		{Type: zfs.Snapshot, Name: "b", Guid: 2, CreateTXG: 20},
+	}
+	incremental := &zfs.ResumeToken{HasFromGUID: true, FromGUID: 1, HasToGUID: true, ToGUID: 2}
+	full := &zfs.ResumeToken{HasToGUID: true, ToGUID: 2}
+
+	assert.NoError(t, checkResumeToken(fsvs, "@a", "@b", incremental))
+	assert.NoError(t, checkResumeToken(fsvs, "#a", "@b", incremental))
+	assert.NoError(t, checkResumeToken(fsvs, "", "@b", full))
+
+	assert.Error(t, checkResumeToken(fsvs, "", "@b", incremental))
+	assert.Error(t, checkResumeToken(fsvs, "@a", "@b", full))
+	assert.Error(t, checkResumeToken(fsvs, "@b", "@a", incremental))
+	err := checkResumeToken(fsvs, "@a", "@c", incremental)
+	assert.Equal(t, pdu.ErrorCode_NotFound, pdu.Code(err))
+}
+
+func TestHandleControlRPCs(t *testing.T) {
+	root, err := zfs.NewDatasetPath("pool/sink")
+	require.NoError(t, err)
+	r, err := NewReceiver(root, nil, nil, zfs.RecvProperties{})
+	require.NoError(t, err)
+	h := NewHandler(r)
+
+	code := func(endpoint string, req []byte) pdu.ErrorCode {
+		_, _, err := h.Handle(context.Background(), endpoint, bytes.NewBuffer(req), nil)
+		require.Error(t, err)
+		return pdu.Code(pdu.ErrorFromWire(err))
+	}
+
+	// sender-only RPC on a receiver
+	b, err := proto.Marshal(&pdu.BookmarkReq{Filesystem: "pool/sink/a"})
+	require.NoError(t, err)
+	assert.Equal(t, pdu.ErrorCode_InvalidArgument, code(RPCBookmark, b))
+
+	assert.Equal(t, pdu.ErrorCode_InvalidArgument, code(RPCSetProperties, []byte{0xff, 0xff}))
+	assert.Equal(t, pdu.ErrorCode_InvalidArgument, code("NoSuchRPC", nil))
+
+	for _, rpc := range []string{
+		RPCListFilesystems, RPCListFilesystemVersions, RPCSDestroySnapshots, RPCDestroySnapshotsSubmit,
+		RPCDestroySnapshotsPoll, RPCReplicationCursor, RPCBookmark, RPCSetStepHolds,
+		RPCGetProperties, RPCSetProperties, RPCResolveDivergence, RPCSendEstimates,
+	} {
+		assert.Contains(t, controlRPCs, rpc)
+	}
+}
+
+type prefixSnapshotFilter string
+
+func (f prefixSnapshotFilter) Filter(t zfs.VersionType, name string) (bool, error) {
+	return t != zfs.Snapshot || strings.HasPrefix(name, string(f)), nil
+}
+
+func TestSenderSnapshotFilterCheck(t *testing.T) {
+	s := NewSender(nil)
+	s.SnapshotFilter = prefixSnapshotFilter("zrepl_")
+
+	assert.NoError(t, s.snapshotFilterCheck(""))
+	assert.NoError(t, s.snapshotFilterCheck("@zrepl_1"))
+	assert.NoError(t, s.snapshotFilterCheck("#syncoid_1"))
+	err := s.snapshotFilterCheck("@syncoid_1")
+	require.Error(t, err)
+	assert.Equal(t, pdu.ErrorCode_PermissionDenied, pdu.Code(err))
+
+	s.SnapshotFilter = nil
+	assert.NoError(t, s.snapshotFilterCheck("@syncoid_1"))
+}
+
+type anyFSFilter struct{}
+
+func (anyFSFilter) Filter(p *zfs.DatasetPath) (bool, error) { return true, nil }
+
+func TestSenderSendRefusesFilteredSnapshots(t *testing.T) {
+	s := NewSender(anyFSFilter{})
+	s.SnapshotFilter = prefixSnapshotFilter("zrepl_")
+
+	for _, req := range []*pdu.SendReq{
+		{Filesystem: "pool/a", To: "@syncoid_2"},
+		{Filesystem: "pool/a", From: "@syncoid_1", To: "@zrepl_2"},
+		{Filesystem: "pool/a", From: "@zrepl_1", To: "@syncoid_2", DryRun: true},
+	} {
+		_, stream, err := s.Send(context.Background(), req)
+		require.Error(t, err, "%s", req)
+		assert.Equal(t, pdu.ErrorCode_PermissionDenied, pdu.Code(err), "%s", req)
+		assert.Nil(t, stream)
+	}
+}
+
+func TestSenderBookmarkRefusesFilteredSnapshots(t *testing.T) {
+	s := NewSender(anyFSFilter{})
+	s.SnapshotFilter = prefixSnapshotFilter("zrepl_")
+
+	_, err := s.Bookmark(context.Background(), &pdu.BookmarkReq{Filesystem: "pool/a", Snapshot: "syncoid_1"})
+	require.Error(t, err)
+	assert.Equal(t, pdu.ErrorCode_PermissionDenied, pdu.Code(err))
+}
+
+func TestSenderSendEstimatesRequiresDryRun(t *testing.T) {
+	s := NewSender(nil)
+	_, err := s.SendEstimates(context.Background(), &pdu.SendEstimatesReq{
+		Steps: []*pdu.SendReq{{Filesystem: "pool/a", To: "@b"}},
+	})
+	require.Error(t, err)
+	assert.Equal(t, pdu.ErrorCode_InvalidArgument, pdu.Code(err))
+}
+
+func TestIncrementalRunEnd(t *testing.T) {
+	steps := []*pdu.SendReq{
+		{Filesystem: "pool/a", To: "@1", DryRun: true},
+		{Filesystem: "pool/a", From: "@1", To: "@2", DryRun: true},
+		{Filesystem: "pool/a", From: "@2", To: "@3", DryRun: true},
+		{Filesystem: "pool/a", From: "@3", To: "@4", DryRun: true, Compress: true},
+		{Filesystem: "pool/b", From: "@1", To: "@2", DryRun: true},
+		{Filesystem: "pool/b", From: "@2", To: "@3", DryRun: true, ResumeToken: "1-abc"},
+		{Filesystem: "pool/c", From: "#1", To: "@2", DryRun: true},
+		{Filesystem: "pool/c", From: "@2", To: "@3", DryRun: true},
+		{Filesystem: "pool/c", From: "@4", To: "@5", DryRun: true},
+	}
+	var runs [][2]int
+	for i := 0; i < len(steps); {
+		end := incrementalRunEnd(steps, i)
+		runs = append(runs, [2]int{i, end})
+		i = end
+	}
+	assert.Equal(t, [][2]int{
+		{0, 1}, // full send
+		{1, 3},
+		{3, 4}, // other stream features
+		{4, 5}, // other filesystem, followed by a resumed step
+		{5, 6},
+		{6, 7}, // from a bookmark
+		{7, 8}, // not continued by the next step
+		{8, 9},
+	}, runs)
+}
+
+func TestRemoteSendEstimatesRequiresPeerFeature(t *testing.T) {
+	var features map[transport.Feature]bool
+	// the client is never used because the request is not issued
+	r := NewRemote(&streamrpc.Client{}).WithPeerFeatures(func() map[transport.Feature]bool { return features })
+	req := &pdu.SendEstimatesReq{Steps: []*pdu.SendReq{{Filesystem: "pool/a", To: "@b", DryRun: true}}}
+
+	// no handshake yet
+	_, err := r.SendEstimates(context.Background(), req)
+	assert.Equal(t, fsrep.ErrSendEstimatesUnsupported, err)
+
+	features = map[transport.Feature]bool{transport.FeatureResume: true}
+	_, err = r.SendEstimates(context.Background(), req)
+	assert.Equal(t, fsrep.ErrSendEstimatesUnsupported, err)
+}
+
+func TestLastCommonSnapshots(t *testing.T) {
+	fsvs := []zfs.FilesystemVersion{
+		{Type: zfs.Snapshot, Name: "a", Guid: 1, CreateTXG: 10},
+		{Type: zfs.Snapshot, Name: "b", Guid: 2, CreateTXG: 20},
+		{Type: zfs.Snapshot, Name: "c", Guid: 3, CreateTXG: 30},
+		{Type: zfs.Bookmark, Name: "d", Guid: 4, CreateTXG: 40},
+	}
+	names := func(protected map[string]string) []string {
+		var n []string
+		for name := range protected {
+			n = append(n, name)
+		}
+		sort.Strings(n)
+		return n
+	}
+	assert.Equal(t, []string{"c"}, names(lastCommonSnapshots(fsvs, 0)))
+	assert.Equal(t, []string{"b", "c"}, names(lastCommonSnapshots(fsvs, 2)))
+	assert.Equal(t, []string{"c"}, names(lastCommonSnapshots(fsvs, 3)))
+	assert.Equal(t, []string{"c"}, names(lastCommonSnapshots(fsvs, 4711)))
+	assert.Empty(t, lastCommonSnapshots(nil, 2))
+}
+
+func TestDestroySnapshotsProtectsLastCommonSnapshot(t *testing.T) {
+	lp, err := zfs.NewDatasetPath("pool/backup/a")
+	require.NoError(t, err)
+	defer func(f func(context.Context, *zfs.DatasetPath, zfs.FilesystemVersionFilter) ([]zfs.FilesystemVersion, error)) {
+		zfsListFilesystemVersions = f
+	}(zfsListFilesystemVersions)
+	zfsListFilesystemVersions = func(ctx context.Context, fs *zfs.DatasetPath, filter zfs.FilesystemVersionFilter) ([]zfs.FilesystemVersion, error) {
+		assert.Equal(t, lp.ToString(), fs.ToString())
+		return []zfs.FilesystemVersion{
+			{Type: zfs.Snapshot, Name: "a", Guid: 1, CreateTXG: 10},
+			{Type: zfs.Snapshot, Name: "b", Guid: 2, CreateTXG: 20},
+		}, nil
+	}
+	snap := func(name string, guid uint64) *pdu.FilesystemVersion {
+		return &pdu.FilesystemVersion{Type: pdu.FilesystemVersion_Snapshot, Name: name, Guid: guid, Creation: "2018-10-01T12:00:00Z"}
+	}
+
+	tcs := []struct {
+		name       string
+		snap       *pdu.FilesystemVersion
+		cursorGuid uint64
+	}{
+		{"cursor", snap("a", 1), 1},
+		// the guid of the request is not trusted, the destroy is by name
+		{"cursor with wrong guid", snap("a", 4711), 1},
+		{"newest without cursor", snap("b", 2), 0},
+		{"newest with wrong guid", snap("b", 4711), 1},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := doDestroySnapshots(context.Background(), lp, []*pdu.FilesystemVersion{tc.snap}, true, tc.cursorGuid, nil)
+			require.NoError(t, err)
+			require.Len(t, res.Results, 1)
+			require.NotNil(t, res.Results[0].Error)
+			assert.Equal(t, pdu.ErrorCode_LastCommonSnapshot, res.Results[0].Error.Code)
+		})
+	}
+
+	_, err = doDestroySnapshots(context.Background(), lp, []*pdu.FilesystemVersion{
+		{Type: pdu.FilesystemVersion_Bookmark, Name: zfs.ReplicationCursorBookmarkName},
+	}, true, 0, nil)
+	assert.Equal(t, pdu.ErrorCode_InvalidArgument, pdu.Code(err))
+}
+
+func TestSenderSetStepHoldsRequiresStepTag(t *testing.T) {
+	s := NewSender(anyFSFilter{})
+	for _, req := range []*pdu.SetStepHoldsReq{
+		{},
+		{Tag: "backup_export"},
+		// per filesystem
+		{Filesystem: "pool/a", Tag: "backup_export"},
+		{Filesystem: "pool/a", Tag: "backup_export", Snapshots: []string{"zrepl_1"}},
+		{Filesystem: "pool/a", Tag: "-r", Snapshots: []string{"zrepl_1"}},
+	} {
+		_, err := s.SetStepHolds(context.Background(), req)
+		require.Error(t, err, "%s", req)
+		assert.Equal(t, pdu.ErrorCode_InvalidArgument, pdu.Code(err), "%s", req)
+	}
+}
+
+func TestSenderSetStepHoldsRefusesFilteredSnapshots(t *testing.T) {
+	s := NewSender(anyFSFilter{})
+	s.SnapshotFilter = prefixSnapshotFilter("zrepl_")
+	_, err := s.SetStepHolds(context.Background(), &pdu.SetStepHoldsReq{
+		Filesystem: "pool/a",
+		Tag:        StepHoldTagPrefix + "job",
+		Snapshots:  []string{"zrepl_1", "syncoid_1"},
+	})
+	require.Error(t, err)
+	assert.Equal(t, pdu.ErrorCode_PermissionDenied, pdu.Code(err))
+}
@@ -0,0 +1,49 @@
+package endpoint
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// PropertyAllowlist are the properties that the sender may set or inherit on a Receiver's filesystems with SetProperties.
+// An entry is a property name, e.g. compression, or a user property namespace followed by *, e.g. com.example:*.
+// The empty list accepts no property.
+type PropertyAllowlist []string
+
+// NewPropertyAllowlist validates patterns and returns them as a PropertyAllowlist.
+func NewPropertyAllowlist(patterns []string) (PropertyAllowlist, error) {
+	l := make(PropertyAllowlist, len(patterns))
+	for i, p := range patterns {
+		if p == "" {
+			return nil, errors.Errorf("entry #%d is empty", i)
+		}
+		if star := strings.Index(p, "*"); star != -1 {
+			// native properties, e.g. mountpoint or setuid, must be listed one by one
+			if star != len(p)-1 || !strings.Contains(p, ":") {
+				return nil, errors.Errorf("entry %q: * is only allowed at the end of a user property namespace, e.g. com.example:*", p)
+			}
+		}
+		l[i] = p
+	}
+	return l, nil
+}
+
+// Allows returns whether property name matches an entry of l.
+func (l PropertyAllowlist) Allows(name string) bool {
+	for _, p := range l {
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(name, strings.TrimSuffix(p, "*")) {
+				return true
+			}
+		} else if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAcceptedProperties sets the properties that SetProperties accepts, none by default.
+// It must be called before the Receiver is used.
+func (e *Receiver) SetAcceptedProperties(l PropertyAllowlist) {
+	e.acceptedProps = l
+}
@@ -0,0 +1,60 @@
+package endpoint
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/replication/pdu"
+	"github.com/zrepl/zrepl/zfs"
+	"testing"
+)
+
+func TestPropertyAllowlist(t *testing.T) {
+	l, err := NewPropertyAllowlist([]string{"compression", "com.example:*"})
+	require.NoError(t, err)
+	assert.True(t, l.Allows("compression"))
+	assert.True(t, l.Allows("com.example:owner"))
+	assert.False(t, l.Allows("compress"))
+	assert.False(t, l.Allows("com.other:owner"))
+	assert.False(t, l.Allows("mountpoint"))
+
+	assert.False(t, PropertyAllowlist(nil).Allows("compression"))
+
+	for _, invalid := range [][]string{{""}, {"*"}, {"moun*"}, {"com.example:*:x"}, {"com.*ample:x"}} {
+		_, err := NewPropertyAllowlist(invalid)
+		assert.Error(t, err, "%q", invalid)
+	}
+}
+
+func TestReceiverSetPropertiesRefusesUnaccepted(t *testing.T) {
+	root, err := zfs.NewDatasetPath("pool/sink/client")
+	require.NoError(t, err)
+	r, err := NewReceiver(root, nil, nil, zfs.RecvProperties{})
+	require.NoError(t, err)
+
+	set := func(name string) error {
+		_, err := r.SetProperties(context.Background(), &pdu.SetPropertiesReq{
+			Filesystem: "pool/a",
+			Set:        []*pdu.Property{{Name: name, Value: "x"}},
+		})
+		return err
+	}
+	inherit := func(name string) error {
+		_, err := r.SetProperties(context.Background(), &pdu.SetPropertiesReq{
+			Filesystem: "pool/a",
+			Inherit:    []string{name},
+		})
+		return err
+	}
+
+	// the default accepts nothing
+	assert.Equal(t, pdu.ErrorCode_PermissionDenied, pdu.Code(set("compression")))
+
+	l, err := NewPropertyAllowlist([]string{"compression", "com.example:*"})
+	require.NoError(t, err)
+	r.SetAcceptedProperties(l)
+	for _, name := range []string{"mountpoint", "setuid", "exec", "canmount", "com.other:owner"} {
+		assert.Equal(t, pdu.ErrorCode_PermissionDenied, pdu.Code(set(name)), "set %s", name)
+		assert.Equal(t, pdu.ErrorCode_PermissionDenied, pdu.Code(inherit(name)), "inherit %s", name)
+	}
+}
@@ -0,0 +1,150 @@
+package endpoint
+
+import (
+	"github.com/zrepl/zrepl/replication/pdu"
+	"github.com/zrepl/zrepl/zfs"
+	"strconv"
+	"strings"
+)
+
+// Quota limits what a Receiver accepts, checked before each Receive.
+// A zero field means no limit.
+type Quota struct {
+	// number of filesystems below the receiving root, including placeholders, a limit for new filesystems only
+	MaxFilesystems int
+	// used space of the receiving root in bytes, including its snapshots and descendants
+	MaxUsed int64
+	// percentage of the receiving pool's space that is allocated, 1-100
+	MaxPoolCapacity int
+}
+
+// SetQuota sets the limits checked before each Receive.
+func (e *Receiver) SetQuota(q Quota) {
+	e.quota = q
+}
+
+// receivingRoot returns the root below which the local filesystem lp is received.
+func (e *Receiver) receivingRoot(lp *zfs.DatasetPath) *zfs.DatasetPath {
+	for _, r := range e.rules {
+		if lp.HasPrefix(r.Root) && !lp.Equal(r.Root) {
+			return r.Root
+		}
+	}
+	return e.root
+}
+
+// quotaUsage is the state of the receiving side that a Quota limits, see Quota.check.
+type quotaUsage struct {
+	root, pool string
+	// only set if the quota limits the pool capacity
+	poolSize, poolAllocated int64
+	// only set if the quota limits the used space, and the root exists
+	used int64
+	// only set if the quota limits the filesystems, and the root exists:
+	// the filesystems below the root, and those that the receive would create, including placeholders
+	filesystems, newFilesystems int
+}
+
+// check returns an ErrorCode_QuotaExceeded error if receiving a stream of expectedSize bytes (0 if unknown) would exceed q.
+// Without a size estimate, only the current usage is compared against the limits.
+func (q Quota) check(u quotaUsage, expectedSize int64) error {
+	if q.MaxPoolCapacity > 0 {
+		limit := int64(q.MaxPoolCapacity)
+		capacity := u.poolAllocated * 100 / u.poolSize // as reported by zpool
+		if capacity >= limit || (u.poolAllocated+expectedSize)*100 > limit*u.poolSize {
+			return pdu.NewError(pdu.ErrorCode_QuotaExceeded,
+				"receiving pool %q is %d%% full and an estimated %d bytes would be received, the receiver accepts at most %d%%",
+				u.pool, capacity, expectedSize, q.MaxPoolCapacity)
+		}
+	}
+	if q.MaxUsed > 0 && (u.used >= q.MaxUsed || u.used+expectedSize > q.MaxUsed) {
+		return pdu.NewError(pdu.ErrorCode_QuotaExceeded,
+			"%s uses %d bytes and would use %d bytes after receiving an estimated %d bytes, the receiver accepts at most %d bytes",
+			u.root, u.used, u.used+expectedSize, expectedSize, q.MaxUsed)
+	}
+	if q.MaxFilesystems > 0 && u.newFilesystems > 0 && u.filesystems+u.newFilesystems > q.MaxFilesystems {
+		return pdu.NewError(pdu.ErrorCode_QuotaExceeded,
+			"%s has %d filesystems and the receive would create %d more, including placeholders, the receiver accepts at most %d",
+			u.root, u.filesystems, u.newFilesystems, q.MaxFilesystems)
+	}
+	return nil
+}
+
+// newFilesystems returns the number of filesystems that receiving lp below root creates,
+// i.e. lp and its placeholder parents below root that do not exist.
+func newFilesystems(root, lp *zfs.DatasetPath, exists func(fs string) (bool, error)) (int, error) {
+	n := 0
+	for fs := lp.ToString(); len(fs) > len(root.ToString()); fs = fs[:strings.LastIndex(fs, "/")] {
+		ok, err := exists(fs)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			break // so do its parents
+		}
+		n++
+	}
+	return n, nil
+}
+
+// checkQuota returns an ErrorCode_QuotaExceeded error if receiving a stream of expectedSize bytes into lp would exceed e's quota.
+// The number of filesystems below roots that do not exist yet is not limited.
+func (e *Receiver) checkQuota(lp *zfs.DatasetPath, expectedSize int64) error {
+	q := e.quota
+	if q == (Quota{}) {
+		return nil
+	}
+	root := e.receivingRoot(lp)
+	u := quotaUsage{root: root.ToString(), pool: strings.SplitN(lp.ToString(), "/", 2)[0]}
+
+	if q.MaxPoolCapacity > 0 {
+		var err error
+		u.poolSize, u.poolAllocated, err = zfs.ZPoolSpace(u.pool)
+		if err != nil {
+			return err
+		}
+	}
+
+	if q.MaxUsed > 0 || q.MaxFilesystems > 0 {
+		props, err := zfs.ZFSGet(root, []string{"used"})
+		if _, ok := err.(*zfs.DatasetDoesNotExist); ok {
+			return q.check(u, expectedSize)
+		} else if err != nil {
+			return err
+		}
+		if q.MaxUsed > 0 {
+			u.used, err = strconv.ParseInt(props.Get("used"), 10, 64)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if q.MaxFilesystems > 0 {
+		var err error
+		u.newFilesystems, err = newFilesystems(root, lp, datasetExists)
+		if err != nil {
+			return err
+		}
+		if u.newFilesystems > 0 {
+			res, err := zfs.ZFSList([]string{"name"}, "-r", "-t", "filesystem,volume", root.ToString())
+			if err != nil {
+				return err
+			}
+			u.filesystems = len(res) - 1 // without the root itself
+		}
+	}
+	return q.check(u, expectedSize)
+}
+
+func datasetExists(fs string) (bool, error) {
+	p, err := zfs.NewDatasetPath(fs)
+	if err != nil {
+		return false, err
+	}
+	_, err = zfs.ZFSGet(p, []string{"name"})
+	if _, ok := err.(*zfs.DatasetDoesNotExist); ok {
+		return false, nil
+	}
+	return err == nil, err
+}
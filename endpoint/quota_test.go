@@ -0,0 +1,71 @@
+package endpoint
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/replication/pdu"
+	"github.com/zrepl/zrepl/zfs"
+	"testing"
+)
+
+func TestQuotaCheck(t *testing.T) {
+	u := quotaUsage{
+		root: "pool/backup", pool: "pool",
+		poolSize: 1000, poolAllocated: 800,
+		used: 400, filesystems: 9,
+	}
+	tcs := []struct {
+		name         string
+		quota        Quota
+		newFSs       int
+		expectedSize int64
+		exceeded     bool
+	}{
+		{"no limits", Quota{}, 5, 1 << 40, false},
+		{"pool below", Quota{MaxPoolCapacity: 90}, 0, 100, false},
+		{"pool after stream", Quota{MaxPoolCapacity: 90}, 0, 101, true},
+		{"pool full", Quota{MaxPoolCapacity: 80}, 0, 0, true},
+		{"used below", Quota{MaxUsed: 500}, 0, 100, false},
+		{"used after stream", Quota{MaxUsed: 500}, 0, 101, true},
+		{"used without estimate", Quota{MaxUsed: 400}, 0, 0, true},
+		{"existing filesystem", Quota{MaxFilesystems: 9}, 0, 0, false},
+		{"new filesystem", Quota{MaxFilesystems: 10}, 1, 0, false},
+		{"new filesystem beyond limit", Quota{MaxFilesystems: 9}, 1, 0, true},
+		{"placeholders beyond limit", Quota{MaxFilesystems: 10}, 2, 0, true},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			u := u
+			u.newFilesystems = tc.newFSs
+			err := tc.quota.check(u, tc.expectedSize)
+			if !tc.exceeded {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Equal(t, pdu.ErrorCode_QuotaExceeded, pdu.Code(err))
+		})
+	}
+}
+
+func TestNewFilesystems(t *testing.T) {
+	root, err := zfs.NewDatasetPath("pool/backup")
+	require.NoError(t, err)
+	existing := map[string]bool{"pool/backup": true, "pool/backup/host": true}
+	exists := func(fs string) (bool, error) {
+		return existing[fs], nil
+	}
+	tcs := map[string]int{
+		"pool/backup/host":           0,
+		"pool/backup/host/tank":      1,
+		"pool/backup/host/tank/a/b":  3,
+		"pool/backup/other/tank/a/b": 4,
+	}
+	for fs, expected := range tcs {
+		lp, err := zfs.NewDatasetPath(fs)
+		require.NoError(t, err)
+		n, err := newFilesystems(root, lp, exists)
+		require.NoError(t, err)
+		assert.Equal(t, expected, n, fs)
+	}
+}
@@ -10,12 +10,24 @@ import (
 func init() {
 	cli.AddSubcommand(daemon.DaemonCmd)
 	cli.AddSubcommand(client.StatusCmd)
+	cli.AddSubcommand(client.FleetStatusCmd)
 	cli.AddSubcommand(client.SignalCmd)
+	cli.AddSubcommand(client.JobsCmd)
+	cli.AddSubcommand(client.HistoryCmd)
 	cli.AddSubcommand(client.StdinserverCmd)
 	cli.AddSubcommand(client.ConfigcheckCmd)
+	cli.AddSubcommand(client.DoctorCmd)
 	cli.AddSubcommand(client.VersionCmd)
 	cli.AddSubcommand(client.PprofCmd)
 	cli.AddSubcommand(client.TestCmd)
+	cli.AddSubcommand(client.PingCmd)
+	cli.AddSubcommand(client.MigrateCmd)
+	cli.AddSubcommand(client.PlaceholderCmd)
+	cli.AddSubcommand(client.CleanupCmd)
+	cli.AddSubcommand(client.ResumableReceiveCmd)
+	cli.AddSubcommand(client.ReplicateOnceCmd)
+	cli.AddSubcommand(client.FailbackCmd)
+	cli.AddSubcommand(client.DebugCmd)
 }
 
 func main() {
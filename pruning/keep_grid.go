@@ -5,6 +5,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/zrepl/zrepl/config"
 	"github.com/zrepl/zrepl/pruning/retentiongrid"
+	"github.com/zrepl/zrepl/util/snapname"
 	"regexp"
 	"sort"
 	"time"
@@ -13,20 +14,35 @@ import (
 // KeepGrid fits snapshots that match a given regex into a retentiongrid.Grid,
 // uses the most recent snapshot among those that match the regex as 'now',
 // and deletes all snapshots that do not fit the grid specification.
+//
+// If a snapname.Format is used instead of the regex, snapshots are matched by the format
+// and placed into the grid by the time encoded in their name instead of their creation date.
 type KeepGrid struct {
 	retentionGrid *retentiongrid.Grid
 	re *regexp.Regexp
+	names *snapname.Format
 }
 
 func NewKeepGrid(in *config.PruneGrid) (p *KeepGrid, err error) {
 
-	if in.Regex == "" {
+	var re *regexp.Regexp
+	var names *snapname.Format
+	switch {
+	case in.Regex != "" && in.NameFormat != "":
+		return nil, fmt.Errorf("Regex and NameFormat are mutually exclusive")
+	case in.NameFormat != "":
+		names, err = snapname.New(in.NameFormat, in.Timezone)
+		if err != nil {
+			return nil, errors.Wrap(err, "NameFormat is invalid")
+		}
+	case in.Regex != "":
+		re, err = regexp.Compile(in.Regex)
+		if err != nil {
+			return nil, errors.Wrap(err, "Regex is invalid")
+		}
+	default:
 		return nil, fmt.Errorf("Regex must not be empty")
 	}
-	re, err := regexp.Compile(in.Regex)
-	if err != nil {
-		return nil, errors.Wrap(err, "Regex is invalid")
-	}
 
 	// Assert intervals are of increasing length (not necessarily required, but indicates config mistake)
 	lastDuration := time.Duration(0)
@@ -57,13 +73,17 @@ func NewKeepGrid(in *config.PruneGrid) (p *KeepGrid, err error) {
 	return &KeepGrid{
 		retentiongrid.NewGrid(retentionIntervals),
 		re,
+		names,
 	}, nil
 }
 
 type retentionGridAdaptor struct {
 	Snapshot
+	date time.Time
 }
 
+func (a retentionGridAdaptor) Date() time.Time { return a.date }
+
 func (a retentionGridAdaptor) LessThan(b retentiongrid.Entry) bool {
 	return a.Date().Before(b.Date())
 }
@@ -72,6 +92,9 @@ func (a retentionGridAdaptor) LessThan(b retentiongrid.Entry) bool {
 func (p *KeepGrid) KeepRule(snaps []Snapshot) (destroyList []Snapshot) {
 
 	snaps = filterSnapList(snaps, func(snapshot Snapshot) bool {
+		if p.names != nil {
+			return p.names.Matches(snapshot.Name())
+		}
 		return p.re.MatchString(snapshot.Name())
 	})
 	if len(snaps) == 0 {
@@ -81,7 +104,11 @@ func (p *KeepGrid) KeepRule(snaps []Snapshot) (destroyList []Snapshot) {
 	// Build adaptors for retention grid
 	adaptors := make([]retentiongrid.Entry, 0)
 	for i := range snaps {
-		adaptors = append(adaptors, retentionGridAdaptor{snaps[i]})
+		date := snaps[i].Date()
+		if p.names != nil {
+			date, _ = p.names.Parse(snaps[i].Name()) // matched above
+		}
+		adaptors = append(adaptors, retentionGridAdaptor{snaps[i], date})
 	}
 
 	// determine 'now' edge
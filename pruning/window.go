@@ -0,0 +1,79 @@
+package pruning
+
+import (
+	"fmt"
+	"github.com/zrepl/zrepl/config"
+	"time"
+)
+
+// Window is a daily time window (in local time) outside of which no snapshots are destroyed.
+// A nil *Window contains all times.
+type Window struct {
+	// offsets from midnight
+	from, to time.Duration
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, must be HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// WindowFromConfig returns nil if in is nil.
+func WindowFromConfig(in *config.PruningWindow) (*Window, error) {
+	if in == nil {
+		return nil, nil
+	}
+	from, err := parseTimeOfDay(in.From)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseTimeOfDay(in.To)
+	if err != nil {
+		return nil, err
+	}
+	if from == to {
+		return nil, fmt.Errorf("window must not be empty: from and to are both %s", in.From)
+	}
+	return &Window{from, to}, nil
+}
+
+func sinceMidnight(t time.Time) (midnight time.Time, since time.Duration) {
+	midnight = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return midnight, t.Sub(midnight)
+}
+
+func (w *Window) Contains(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	_, since := sinceMidnight(t)
+	if w.from < w.to {
+		return w.from <= since && since < w.to
+	}
+	return since >= w.from || since < w.to
+}
+
+// NextOpen returns t if t is within the window, otherwise the time at which the window opens next.
+func (w *Window) NextOpen(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+	midnight, since := sinceMidnight(t)
+	if since < w.from {
+		return midnight.Add(w.from)
+	}
+	return midnight.AddDate(0, 0, 1).Add(w.from)
+}
+
+func (w *Window) String() string {
+	if w == nil {
+		return "always"
+	}
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return fmt.Sprintf("%s-%s", format(w.from), format(w.to))
+}
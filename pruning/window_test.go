@@ -0,0 +1,50 @@
+package pruning
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zrepl/zrepl/config"
+	"testing"
+	"time"
+)
+
+func TestWindow(t *testing.T) {
+
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2018, 9, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	night, err := WindowFromConfig(&config.PruningWindow{From: "22:00", To: "05:30"})
+	require.NoError(t, err)
+	assert.True(t, night.Contains(at(1, 23, 0)))
+	assert.True(t, night.Contains(at(1, 2, 0)))
+	assert.True(t, night.Contains(at(1, 22, 0)))
+	assert.False(t, night.Contains(at(1, 5, 30)))
+	assert.False(t, night.Contains(at(1, 12, 0)))
+	assert.Equal(t, at(1, 22, 0), night.NextOpen(at(1, 12, 0)))
+	assert.Equal(t, at(1, 2, 0), night.NextOpen(at(1, 2, 0)))
+	assert.Equal(t, "22:00-05:30", night.String())
+
+	early, err := WindowFromConfig(&config.PruningWindow{From: "02:00", To: "05:00"})
+	require.NoError(t, err)
+	assert.True(t, early.Contains(at(1, 2, 0)))
+	assert.False(t, early.Contains(at(1, 5, 0)))
+	assert.False(t, early.Contains(at(1, 1, 59)))
+	assert.Equal(t, at(1, 2, 0), early.NextOpen(at(1, 1, 0)))
+	assert.Equal(t, at(2, 2, 0), early.NextOpen(at(1, 6, 0)))
+
+	var always *Window
+	assert.True(t, always.Contains(at(1, 12, 0)))
+	always, err = WindowFromConfig(nil)
+	require.NoError(t, err)
+	assert.Nil(t, always)
+
+	for _, in := range []config.PruningWindow{
+		{From: "02:00", To: "02:00"},
+		{From: "2am", To: "05:00"},
+		{From: "02:00", To: "25:00"},
+	} {
+		_, err := WindowFromConfig(&in)
+		assert.Error(t, err, "%v", in)
+	}
+}
@@ -0,0 +1,131 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	. "github.com/zrepl/zrepl/replication/internal/diff"
+	"github.com/zrepl/zrepl/replication/pdu"
+)
+
+// ConflictPolicy determines how the planner handles a receiver that has diverged from the sender,
+// i.e. has versions after the most recent common snapshot that the sender does not have.
+type ConflictPolicy int
+
+const (
+	// ConflictFail reports the filesystem as failed, the divergence must be resolved manually.
+	ConflictFail ConflictPolicy = iota
+	// ConflictRollback rolls the receiver back to the most recent common snapshot,
+	// which destroys the diverged snapshots, and replicates incrementally from there.
+	ConflictRollback
+	// ConflictRename renames the receiving filesystem aside, which preserves the diverged snapshots,
+	// and replicates the filesystem as if it had never been replicated before.
+	ConflictRename
+)
+
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch s {
+	case "fail":
+		return ConflictFail, nil
+	case "rollback":
+		return ConflictRollback, nil
+	case "rename":
+		return ConflictRename, nil
+	default:
+		return 0, fmt.Errorf("invalid conflict policy %q, must be one of fail, rollback, rename", s)
+	}
+}
+
+func (p ConflictPolicy) String() string {
+	switch p {
+	case ConflictFail:
+		return "fail"
+	case ConflictRollback:
+		return "rollback"
+	case ConflictRename:
+		return "rename"
+	default:
+		return fmt.Sprintf("ConflictPolicy(%d)", int(p))
+	}
+}
+
+// InitialReplicationPolicy determines what the planner replicates of a filesystem that the receiver does not have yet.
+type InitialReplicationPolicy int
+
+const (
+	// InitialReplicationMostRecent sends the sender's most recent snapshot in full.
+	InitialReplicationMostRecent InitialReplicationPolicy = iota
+	// InitialReplicationAll sends the sender's oldest snapshot in full and all others incrementally.
+	InitialReplicationAll
+	// InitialReplicationFail reports the filesystem as failed.
+	InitialReplicationFail
+)
+
+func ParseInitialReplicationPolicy(s string) (InitialReplicationPolicy, error) {
+	switch s {
+	case "most_recent":
+		return InitialReplicationMostRecent, nil
+	case "all":
+		return InitialReplicationAll, nil
+	case "fail":
+		return InitialReplicationFail, nil
+	default:
+		return 0, fmt.Errorf("invalid initial replication policy %q, must be one of all, most_recent, fail", s)
+	}
+}
+
+func (p InitialReplicationPolicy) String() string {
+	switch p {
+	case InitialReplicationMostRecent:
+		return "most_recent"
+	case InitialReplicationAll:
+		return "all"
+	case InitialReplicationFail:
+		return "fail"
+	default:
+		return fmt.Sprintf("InitialReplicationPolicy(%d)", int(p))
+	}
+}
+
+// resolveDivergence applies policy (which must not be ConflictFail) to the diverged receiving filesystem fs.
+// It returns the receiver's versions after the resolution and a description of the action taken.
+func resolveDivergence(ctx context.Context, receiver Receiver, fs string, diverged *ConflictDiverged, policy ConflictPolicy) ([]*pdu.FilesystemVersion, string, error) {
+	req := &pdu.ResolveDivergenceReq{
+		Filesystem: fs,
+		Diverged:   diverged.ReceiverOnly,
+	}
+	switch policy {
+	case ConflictRollback:
+		// CommonAncestor is the sender's version, which may be a bookmark, but the receiver needs a snapshot
+		var ancestor *pdu.FilesystemVersion
+		for _, v := range diverged.SortedReceiverVersions {
+			if v.Guid == diverged.CommonAncestor.Guid && v.Type == pdu.FilesystemVersion_Snapshot {
+				ancestor = v
+			}
+		}
+		if ancestor == nil {
+			return nil, "", fmt.Errorf("cannot roll back: the receiver only has a bookmark of the common ancestor %s", diverged.CommonAncestor.RelName())
+		}
+		req.Action = pdu.ResolveDivergenceReq_Rollback
+		req.CommonAncestor = ancestor
+		if _, err := receiver.ResolveDivergence(ctx, req); err != nil {
+			return nil, "", err
+		}
+		var rfsvs []*pdu.FilesystemVersion
+		for _, v := range diverged.SortedReceiverVersions {
+			if v.CreateTXG <= ancestor.CreateTXG {
+				rfsvs = append(rfsvs, v)
+			}
+		}
+		msg := fmt.Sprintf("rolled back receiver to %s, destroying %d diverged versions", ancestor.RelName(), len(diverged.ReceiverOnly))
+		return rfsvs, msg, nil
+	case ConflictRename:
+		req.Action = pdu.ResolveDivergenceReq_Rename
+		res, err := receiver.ResolveDivergence(ctx, req)
+		if err != nil {
+			return nil, "", err
+		}
+		return []*pdu.FilesystemVersion{}, fmt.Sprintf("renamed diverged receiver filesystem to %s", res.RenamedTo), nil
+	default:
+		panic(fmt.Sprintf("unexpected conflict policy %s", policy))
+	}
+}
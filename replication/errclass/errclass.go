@@ -0,0 +1,89 @@
+// Package errclass classifies the errors of replication and pruning by whether retrying the failed operation
+// later may succeed, e.g. after a network outage, or will fail the same way, e.g. because of missing permissions.
+package errclass
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/zrepl/zrepl/replication/pdu"
+	"github.com/zrepl/zrepl/zfs"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Errnos of a connection that broke or could not be established, which may work again later.
+var temporaryErrnos = map[syscall.Errno]bool{
+	syscall.ECONNRESET:   true,
+	syscall.ECONNABORTED: true,
+	syscall.ECONNREFUSED: true,
+	syscall.EPIPE:        true,
+	syscall.ETIMEDOUT:    true,
+	syscall.EHOSTUNREACH: true,
+	syscall.ENETUNREACH:  true,
+	syscall.ENETDOWN:     true,
+}
+
+type temporary interface {
+	Temporary() bool
+}
+
+type timeout interface {
+	Timeout() bool
+}
+
+// Temporary reports whether the operation that failed with err may succeed if it is retried later.
+//
+// Temporary are
+//   - pdu.Errors with code Busy, see (*pdu.Error).Temporary,
+//   - temporary DNS failures and timeouts,
+//   - connections that were reset, refused or closed unexpectedly, e.g. of the RPC transport,
+//   - timeouts, e.g. context.DeadlineExceeded or TLS handshakes running into a deadline,
+//   - other errors whose Temporary method returns true.
+//
+// All other errors are permanent, in particular pdu.Errors with other codes, e.g. PermissionDenied,
+// and errors of zfs commands, e.g. usage errors.
+// Errors wrapped with github.com/pkg/errors are classified by their cause.
+func Temporary(err error) bool {
+	for err != nil {
+		switch e := err.(type) {
+		case *pdu.Error:
+			return e.Temporary()
+		case *zfs.ZFSError:
+			return false
+		case zfs.ZFSError:
+			return false
+		case *net.DNSError:
+			return e.Temporary() || e.Timeout()
+		case syscall.Errno:
+			return temporaryErrnos[e] || e.Temporary()
+		case *net.OpError:
+			// its Temporary method does not consider resets
+			err = e.Err
+			continue
+		case *os.SyscallError:
+			err = e.Err
+			continue
+		}
+		switch err {
+		case context.DeadlineExceeded:
+			return true
+		case io.EOF, io.ErrUnexpectedEOF:
+			// the peer or a middlebox closed the connection
+			return true
+		}
+		if t, ok := err.(temporary); ok && t.Temporary() {
+			return true
+		}
+		if t, ok := err.(timeout); ok && t.Timeout() {
+			return true
+		}
+		cause := errors.Cause(err)
+		if cause == err {
+			return false
+		}
+		err = cause
+	}
+	return false
+}
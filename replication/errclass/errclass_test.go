@@ -0,0 +1,73 @@
+package errclass
+
+import (
+	"context"
+	"errors"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/zrepl/zrepl/replication/pdu"
+	"github.com/zrepl/zrepl/zfs"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// like the errors of the RPC library
+type rpcError struct {
+	msg  string
+	temp bool
+}
+
+func (e rpcError) Error() string   { return e.msg }
+func (e rpcError) Temporary() bool { return e.temp }
+
+func TestTemporary(t *testing.T) {
+	connReset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	tcs := []struct {
+		name      string
+		err       error
+		temporary bool
+	}{
+		{"nil", nil, false},
+		{"plain", errors.New("something failed"), false},
+
+		{"dns temporary", &net.DNSError{Err: "server misbehaving", Name: "backup.example.com", IsTemporary: true}, true},
+		{"dns timeout", &net.DNSError{Err: "i/o timeout", Name: "backup.example.com", IsTimeout: true}, true},
+		{"dns no such host", &net.DNSError{Err: "no such host", Name: "backup.example.com"}, false},
+
+		{"deadline exceeded", context.DeadlineExceeded, true},
+		{"deadline exceeded wrapped", pkgerrors.Wrap(context.DeadlineExceeded, "list filesystems"), true},
+		{"canceled", context.Canceled, false},
+
+		{"tls handshake timeout", &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}, true},
+
+		{"connection reset", connReset, true},
+		{"connection reset wrapped", pkgerrors.Wrap(connReset, "cannot read response"), true},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, true},
+		{"broken pipe", os.NewSyscallError("write", syscall.EPIPE), true},
+		{"unexpected eof", io.ErrUnexpectedEOF, true},
+		{"rpc temporary", rpcError{"connection closed", true}, true},
+		{"rpc permanent", rpcError{"protocol error", false}, false},
+
+		{"busy", pdu.NewError(pdu.ErrorCode_Busy, "busy"), true},
+		{"permission denied", pdu.NewError(pdu.ErrorCode_PermissionDenied, "not allowed"), false},
+		{"permission denied wrapped", pkgerrors.Wrap(pdu.NewError(pdu.ErrorCode_PermissionDenied, "not allowed"), "send"), false},
+		{"invalid argument", pdu.NewError(pdu.ErrorCode_InvalidArgument, "bad request"), false},
+		{"bad certificate", &net.OpError{Op: "remote error", Err: errors.New("tls: bad certificate")}, false},
+
+		{"zfs usage", &zfs.ZFSError{Stderr: []byte("invalid option 'q'\nusage:\n"), WaitErr: &exec.ExitError{}}, false},
+		{"zfs usage value", zfs.ZFSError{Stderr: []byte("missing dataset argument\n"), WaitErr: &exec.ExitError{}}, false},
+	}
+	for _, tc := range tcs {
+		assert.Equal(t, tc.temporary, Temporary(tc.err), tc.name)
+	}
+}
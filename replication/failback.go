@@ -0,0 +1,93 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	. "github.com/zrepl/zrepl/replication/internal/diff"
+	"github.com/zrepl/zrepl/replication/pdu"
+	"strings"
+)
+
+// FailbackFilesystem is the result of CheckFailback for one filesystem of the sender.
+type FailbackFilesystem struct {
+	Filesystem string
+	// false if the receiver does not have the filesystem, which is then not replicated back
+	OnReceiver bool
+	// most recent version that reverse replication starts from, nil if there is nothing to replicate back
+	Common *pdu.FilesystemVersion
+	// snapshots that the receiver has after Common, i.e. that reverse replication would send
+	Snapshots []*pdu.FilesystemVersion
+	// why the filesystem cannot be replicated back, empty if it can
+	Problem string
+}
+
+// CheckFailback checks, for each filesystem of sender that receiver has, that the receiver's snapshots
+// can be replicated back to the sender incrementally, i.e. with the roles of sender and receiver swapped.
+// This is refused for filesystems without a common snapshot, for those in which the sender has
+// versions after the most recent common snapshot, because receiving back would roll them back,
+// and for those in which the sender only has a bookmark of it, because zfs recv needs the snapshot.
+func CheckFailback(ctx context.Context, sender, receiver Endpoint) ([]*FailbackFilesystem, error) {
+	sfss, err := sender.ListFilesystems(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rfss, err := receiver.ListFilesystems(ctx)
+	if err != nil {
+		return nil, err
+	}
+	onReceiver := make(map[string]bool, len(rfss))
+	for _, rfs := range rfss {
+		onReceiver[rfs.Path] = true
+	}
+
+	res := make([]*FailbackFilesystem, 0, len(sfss))
+	for _, sfs := range sfss {
+		f := &FailbackFilesystem{Filesystem: sfs.Path, OnReceiver: onReceiver[sfs.Path]}
+		res = append(res, f)
+		if !f.OnReceiver {
+			continue
+		}
+		sfsvs, err := sender.ListFilesystemVersions(ctx, sfs.Path)
+		if err != nil {
+			return nil, err
+		}
+		rfsvs, err := receiver.ListFilesystemVersions(ctx, sfs.Path)
+		if err != nil {
+			return nil, err
+		}
+		// reversed: the receiver sends, the sender receives
+		path, conflict := IncrementalPath(sfsvs, rfsvs)
+		switch c := conflict.(type) {
+		case nil:
+			if len(path) == 0 {
+				break
+			}
+			f.Common, f.Snapshots = path[0], path[1:]
+			if !hasSnapshot(sfsvs, f.Common.Guid) {
+				f.Problem = fmt.Sprintf("sender has only a bookmark of the most recent common version %s, "+
+					"but receiving back requires the snapshot", f.Common.RelName())
+			}
+		case *ConflictNoCommonAncestor:
+			f.Problem = "no common snapshot or bookmark between sender and receiver"
+		case *ConflictDiverged:
+			names := make([]string, len(c.ReceiverOnly))
+			for i, v := range c.ReceiverOnly {
+				names[i] = v.RelName()
+			}
+			f.Problem = fmt.Sprintf("sender has versions after the most recent common snapshot %s, "+
+				"destroy them or roll back to it before failing back: %s", c.CommonAncestor.RelName(), strings.Join(names, ", "))
+		default:
+			return nil, conflict
+		}
+	}
+	return res, nil
+}
+
+func hasSnapshot(fsvs []*pdu.FilesystemVersion, guid uint64) bool {
+	for _, v := range fsvs {
+		if v.Type == pdu.FilesystemVersion_Snapshot && v.Guid == guid {
+			return true
+		}
+	}
+	return false
+}
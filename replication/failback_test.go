@@ -0,0 +1,104 @@
+package replication
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/replication/pdu"
+)
+
+func testBookmark(name string, txg uint64, creation time.Time) *pdu.FilesystemVersion {
+	v := testSnap(name, txg, creation)
+	v.Type = pdu.FilesystemVersion_Bookmark
+	return v
+}
+
+func TestCheckFailback(t *testing.T) {
+	now := time.Now()
+	s1 := testSnap("s1", 1, now.Add(-3*time.Hour))
+	s2 := testSnap("s2", 2, now.Add(-2*time.Hour))
+	s3 := testSnap("s3", 3, now.Add(-time.Hour))
+	b2 := testBookmark("s2", 2, now.Add(-2*time.Hour))
+	// created on the sender after s2, not replicated
+	x3 := testSnap("x3", 4, now.Add(-30*time.Minute))
+
+	tcs := []struct {
+		name       string
+		sender     []*pdu.FilesystemVersion
+		receiver   []*pdu.FilesystemVersion
+		onReceiver bool
+		common     string
+		snapshots  []string
+		problem    string
+	}{
+		{name: "not on receiver", sender: []*pdu.FilesystemVersion{s1}},
+		{
+			name: "newer snapshots on receiver", onReceiver: true,
+			sender: []*pdu.FilesystemVersion{s1, s2}, receiver: []*pdu.FilesystemVersion{s1, s2, s3},
+			common: "@s2", snapshots: []string{"@s3"},
+		},
+		{
+			name: "in sync", onReceiver: true,
+			sender: []*pdu.FilesystemVersion{s1, s2}, receiver: []*pdu.FilesystemVersion{s1, s2},
+		},
+		{
+			name: "sender keeps a bookmark next to the snapshot", onReceiver: true,
+			sender: []*pdu.FilesystemVersion{b2, s2}, receiver: []*pdu.FilesystemVersion{s2, s3},
+			common: "@s2", snapshots: []string{"@s3"},
+		},
+		{
+			name: "receiver only has a bookmark of the common snapshot", onReceiver: true,
+			sender: []*pdu.FilesystemVersion{s1, s2}, receiver: []*pdu.FilesystemVersion{s1, b2, s3},
+			common: "#s2", snapshots: []string{"@s3"},
+		},
+		{
+			name: "sender only has a bookmark of the common snapshot", onReceiver: true,
+			sender: []*pdu.FilesystemVersion{s1, b2}, receiver: []*pdu.FilesystemVersion{s1, s2, s3},
+			common: "@s2", snapshots: []string{"@s3"}, problem: "sender has only a bookmark",
+		},
+		{
+			name: "no common version", onReceiver: true,
+			sender: []*pdu.FilesystemVersion{s1}, receiver: []*pdu.FilesystemVersion{s3},
+			problem: "no common snapshot or bookmark",
+		},
+		{
+			name: "sender diverged", onReceiver: true,
+			sender: []*pdu.FilesystemVersion{s1, s2, x3}, receiver: []*pdu.FilesystemVersion{s1, s2, s3},
+			problem: "sender has versions after the most recent common snapshot @s2, destroy them or roll back to it before failing back: @x3",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			sender, receiver := newFakeEndpoint(), newFakeEndpoint()
+			sender.add("pool/a", tc.sender...)
+			if tc.receiver != nil {
+				receiver.add("pool/a", tc.receiver...)
+			}
+			res, err := CheckFailback(context.Background(), sender, receiver)
+			require.NoError(t, err)
+			require.Len(t, res, 1)
+			f := res[0]
+			assert.Equal(t, "pool/a", f.Filesystem)
+			assert.Equal(t, tc.onReceiver, f.OnReceiver)
+			if tc.common == "" {
+				assert.Nil(t, f.Common)
+			} else if assert.NotNil(t, f.Common) {
+				assert.Equal(t, tc.common, f.Common.RelName())
+			}
+			var snapshots []string
+			for _, v := range f.Snapshots {
+				snapshots = append(snapshots, v.RelName())
+			}
+			assert.Equal(t, tc.snapshots, snapshots)
+			if tc.problem == "" {
+				assert.Empty(t, f.Problem)
+			} else {
+				assert.Contains(t, f.Problem, tc.problem)
+			}
+		})
+	}
+}
@@ -10,12 +10,16 @@ import (
 	"github.com/zrepl/zrepl/util/watchdog"
 	"io"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/zrepl/zrepl/logger"
+	"github.com/zrepl/zrepl/replication/errclass"
 	"github.com/zrepl/zrepl/replication/pdu"
 	"github.com/zrepl/zrepl/util"
+	"github.com/zrepl/zrepl/zfs"
 )
 
 type contextKey int
@@ -45,8 +49,21 @@ type Sender interface {
 	// If the send request is for dry run the io.ReadCloser will be nil
 	Send(ctx context.Context, r *pdu.SendReq) (*pdu.SendRes, io.ReadCloser, error)
 	ReplicationCursor(ctx context.Context, req *pdu.ReplicationCursorReq) (*pdu.ReplicationCursorRes, error)
+	Bookmark(ctx context.Context, req *pdu.BookmarkReq) (*pdu.BookmarkRes, error)
+	SetStepHolds(ctx context.Context, req *pdu.SetStepHoldsReq) (*pdu.SetStepHoldsRes, error)
+	GetProperties(ctx context.Context, req *pdu.GetPropertiesReq) (*pdu.GetPropertiesRes, error)
 }
 
+// An EstimateSender computes the size estimates of several steps with one request,
+// which saves a round trip per step over a slow link, see UpdateSizeEsitmate.
+type EstimateSender interface {
+	// Returns ErrSendEstimatesUnsupported if the sender does not implement the request,
+	// e.g. because it runs an older version of zrepl.
+	SendEstimates(ctx context.Context, req *pdu.SendEstimatesReq) (*pdu.SendEstimatesRes, error)
+}
+
+var ErrSendEstimatesUnsupported = errors.New("sender does not support bulk size estimates")
+
 // A Sender is usually part of a github.com/zrepl/zrepl/replication.Endpoint.
 type Receiver interface {
 	// Receive sends r and sendStream (the latter containing a ZFS send stream)
@@ -54,20 +71,25 @@ type Receiver interface {
 	// Implementors must guarantee that Close was called on sendStream before
 	// the call to Receive returns.
 	Receive(ctx context.Context, r *pdu.ReceiveReq, sendStream io.ReadCloser) error
+	SetProperties(ctx context.Context, req *pdu.SetPropertiesReq) (*pdu.SetPropertiesRes, error)
 }
 
 type StepReport struct {
 	From, To string
 	Status   StepState
 	Problem  string
-	Bytes    int64
-	ExpectedBytes int64 // 0 means no size estimate possible
+	TransferredBytes int64
+	ExpectedBytes    int64 // 0 means no size estimate possible
+	// of the send stream of the last attempt, nil if the step did not start transferring data yet
+	Throughput *util.ThroughputStats
 }
 
 type Report struct {
 	Filesystem         string
 	Status             string
 	Problem            string
+	// the action the planner took to resolve a conflict with the receiver, if any
+	Resolution         string
 	Completed, Pending []*StepReport
 }
 
@@ -79,6 +101,53 @@ const (
 	Completed
 )
 
+// Options apply to all steps of a Replication.
+type Options struct {
+	// Bookmark each replicated snapshot on the sender,
+	// so that it can serve as the incremental source after the snapshot was destroyed.
+	Bookmark bool
+	// Send encrypted filesystems without decrypting them.
+	Raw bool
+	// Refuse to replicate filesystems that are not encrypted. Implies Raw.
+	Encrypted bool
+	// zfs send -c, -L and -e
+	Compressed, LargeBlocks, EmbeddedData bool
+	// zfs recv -o and -x, only honored by a local receiver
+	RecvProperties zfs.RecvProperties
+	// Copied from sender to receiver after all steps completed.
+	Properties []string
+	// Shared by all concurrently replicated filesystems, nil means unlimited.
+	RateLimiter *util.RateLimiter
+	// Fail a step if the send stream produces no data for this long, 0 means never.
+	SendStallTimeout time.Duration
+	// Abort and retry a step if it moves no data from sender to receiver for this long, 0 means never.
+	StepProgressTimeout time.Duration
+	// If not empty, the snapshots of a multi-step replication are held on the sender with this tag
+	// until the last step completed, so that they cannot be destroyed, e.g. by a pruner, before they are sent.
+	StepHoldTag string
+	// nil means no metrics are collected
+	StreamMetrics *StreamMetrics
+	// If not nil, the send stream of each step is copied to the target it opens.
+	Tee StreamTee
+	// Fail a step if its copy fails, instead of only logging the failure.
+	TeeFailOnError bool
+}
+
+// StreamTee opens the target to which the send stream of a step is copied while it is transferred.
+type StreamTee interface {
+	Open(ctx context.Context, r *pdu.SendReq) (util.TeeTarget, error)
+	String() string
+}
+
+// StreamMetrics are observed while the send streams of the steps are transferred.
+type StreamMetrics struct {
+	// average throughput in bytes per second of every completed step
+	StepThroughput prometheus.Observer
+	// duration in seconds of every read from a send stream that returned data,
+	// i.e. how long the receiving side waited for the sender and the network
+	ReadLatency prometheus.Observer
+}
+
 type Error interface {
 	error
 	Temporary() bool
@@ -90,12 +159,20 @@ type Replication struct {
 	promBytesReplicated prometheus.Counter
 
 	fs                 string
+	opts               Options
+	priority           int
+	resolution         string
 
 	// lock protects all fields below it in this struct, but not the data behind pointers
 	lock               sync.Mutex
 	state              State
 	err                Error
 	completed, pending []*ReplicationStep
+	// properties are synced after the last step
+	propertiesPending bool
+	// step holds are set before the first step, stepHolds are the snapshots held
+	stepHoldsSet bool
+	stepHolds    []string
 }
 
 func (f *Replication) State() State {
@@ -106,6 +183,9 @@ func (f *Replication) State() State {
 
 func (f *Replication) FS() string { return f.fs }
 
+// Priority is immutable, higher priority filesystems are replicated first.
+func (f *Replication) Priority() int { return f.priority }
+
 // returns zero value time.Time{} if no more pending steps
 func (f *Replication) NextStepDate() time.Time {
 	if len(f.pending) == 0 {
@@ -138,6 +218,13 @@ func (f *Replication) CanRetry() bool {
 func (f *Replication) UpdateSizeEsitmate(ctx context.Context, sender Sender) error {
 	f.lock.Lock()
 	defer f.lock.Unlock()
+	if es, ok := sender.(EstimateSender); ok && len(f.pending) > 1 {
+		err := f.updateSizeEstimates(ctx, es)
+		if err != ErrSendEstimatesUnsupported {
+			return err
+		}
+		getLogger(ctx).Debug("sender does not support bulk size estimates, estimate each step separately")
+	}
 	for _, e := range f.pending {
 		if err := e.updateSizeEstimate(ctx, sender); err != nil {
 			return err
@@ -146,12 +233,43 @@ func (f *Replication) UpdateSizeEsitmate(ctx context.Context, sender Sender) err
 	return nil
 }
 
+// f.lock must be held
+func (f *Replication) updateSizeEstimates(ctx context.Context, sender EstimateSender) error {
+	req := &pdu.SendEstimatesReq{Steps: make([]*pdu.SendReq, len(f.pending))}
+	for i, e := range f.pending {
+		req.Steps[i] = e.buildSendRequest(true)
+	}
+	res, err := sender.SendEstimates(ctx, req)
+	if err != nil {
+		return err
+	}
+	if len(res.ExpectedSizes) != len(f.pending) {
+		return fmt.Errorf("sender returned %d size estimates for %d steps", len(res.ExpectedSizes), len(f.pending))
+	}
+	for i, e := range f.pending {
+		e.expectedSize = res.ExpectedSizes[i]
+	}
+	return nil
+}
+
+// SetSizeEstimates sets the size estimates of the pending steps to previously computed ones,
+// instead of computing them with UpdateSizeEsitmate. Superfluous sizes are ignored.
+func (f *Replication) SetSizeEstimates(sizes []int64) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for i, e := range f.pending {
+		if i < len(sizes) {
+			e.expectedSize = sizes[i]
+		}
+	}
+}
+
 type ReplicationBuilder struct {
 	r *Replication
 }
 
-func BuildReplication(fs string, promBytesReplicated prometheus.Counter) *ReplicationBuilder {
-	return &ReplicationBuilder{&Replication{fs: fs, promBytesReplicated: promBytesReplicated}}
+func BuildReplication(fs string, opts Options, promBytesReplicated prometheus.Counter) *ReplicationBuilder {
+	return &ReplicationBuilder{&Replication{fs: fs, opts: opts, promBytesReplicated: promBytesReplicated}}
 }
 
 func (b *ReplicationBuilder) AddStep(from, to FilesystemVersion) *ReplicationBuilder {
@@ -165,8 +283,29 @@ func (b *ReplicationBuilder) AddStep(from, to FilesystemVersion) *ReplicationBui
 	return b
 }
 
-func (b *ReplicationBuilder) Done() (r *Replication) {
+func (b *ReplicationBuilder) Priority(priority int) *ReplicationBuilder {
+	b.r.priority = priority
+	return b
+}
+
+// Resolution records the action taken by the planner to resolve a conflict with the receiver, for the report.
+func (b *ReplicationBuilder) Resolution(resolution string) *ReplicationBuilder {
+	b.r.resolution = resolution
+	return b
+}
+
+// ResumeToken makes the first step continue the interrupted receive with the given token.
+// The receiver must have reported the token for the stream of the first step.
+func (b *ReplicationBuilder) ResumeToken(token string) *ReplicationBuilder {
 	if len(b.r.pending) > 0 {
+		b.r.pending[0].resumeToken = token
+	}
+	return b
+}
+
+func (b *ReplicationBuilder) Done() (r *Replication) {
+	b.r.propertiesPending = len(b.r.opts.Properties) > 0
+	if len(b.r.pending) > 0 || b.r.propertiesPending {
 		b.r.state = Ready
 	} else {
 		b.r.state = Completed
@@ -228,7 +367,11 @@ type ReplicationStep struct {
 	err error
 
 	byteCounter  *util.ByteCounterReader
+	throughput   *util.ThroughputReader
 	expectedSize int64 // 0 means no size estimate present / possible
+
+	// token of the interrupted receive that the step continues, only used for the first attempt
+	resumeToken string
 }
 
 func (f *Replication) Retry(ctx context.Context, ka *watchdog.KeepAlive, sender Sender, receiver Receiver) Error {
@@ -251,8 +394,13 @@ func (f *Replication) Retry(ctx context.Context, ka *watchdog.KeepAlive, sender
 		getLogger(ctx).WithField("fsrep_transition", post).Debug("end fsrep.Retry")
 	}()
 
+	var syncProperties bool
 	st := u(func(f *Replication) {
 		if len(f.pending) == 0 {
+			if f.propertiesPending {
+				syncProperties = true
+				return
+			}
 			f.state = Completed
 			return
 		}
@@ -265,6 +413,29 @@ func (f *Replication) Retry(ctx context.Context, ka *watchdog.KeepAlive, sender
 		panic(fmt.Sprintf("implementation error: %v", st))
 	}
 
+	if syncProperties {
+		err := f.doSyncProperties(ctx, ka, sender, receiver)
+		u(func(f *Replication) {
+			if err != nil {
+				f.err = &StepError{stepStr: "property sync", err: err}
+				return
+			}
+			f.err = nil
+			f.propertiesPending = false
+			f.state = Completed
+		})
+		if u(nil) == Completed {
+			f.releaseStepHolds(ctx, sender)
+		}
+		var retErr Error = nil
+		u(func(fsr *Replication) {
+			retErr = fsr.err
+		})
+		return retErr
+	}
+
+	f.setStepHolds(ctx, sender)
+
 	stepCtx := WithLogger(ctx, getLogger(ctx).WithField("step", current))
 	getLogger(stepCtx).Debug("take step")
 	err := current.Retry(stepCtx, ka, sender, receiver)
@@ -283,12 +454,21 @@ func (f *Replication) Retry(ctx context.Context, ka *watchdog.KeepAlive, sender
 		f.err = nil
 		f.completed = append(f.completed, current)
 		f.pending = f.pending[1:]
-		if len(f.pending) > 0 {
+		if len(f.pending) > 0 || f.propertiesPending {
 			f.state = Ready
 		} else {
 			f.state = Completed
 		}
 	})
+	if u(nil) == Completed {
+		f.releaseStepHolds(ctx, sender)
+	} else if err != nil && !errclass.Temporary(err) {
+		// the filesystem is not retried, the remaining steps do not need the holds anymore
+		f.releaseStepHolds(ctx, sender)
+		u(func(f *Replication) {
+			f.stepHoldsSet = false
+		})
+	}
 	var retErr Error = nil
 	u(func(fsr *Replication) {
 		retErr = fsr.err
@@ -296,10 +476,117 @@ func (f *Replication) Retry(ctx context.Context, ka *watchdog.KeepAlive, sender
 	return retErr
 }
 
+// setStepHolds holds the snapshots of a multi-step replication on the sender before the first step.
+// Holds with opts.StepHoldTag that are left over from previous replications, e.g. after a crash
+// or a permanent error, are released. Failures are not fatal for the replication.
+func (f *Replication) setStepHolds(ctx context.Context, sender Sender) {
+	f.lock.Lock()
+	if f.opts.StepHoldTag == "" || f.stepHoldsSet {
+		f.lock.Unlock()
+		return
+	}
+	f.stepHoldsSet = true
+	var snaps []string
+	if len(f.pending) > 1 {
+		isSnapshot := func(v FilesystemVersion) bool { return strings.HasPrefix(v.RelName(), "@") }
+		if from := f.pending[0].from; from != nil && isSnapshot(from) {
+			snaps = append(snaps, from.GetName())
+		}
+		for _, step := range f.pending {
+			snaps = append(snaps, step.to.GetName())
+		}
+	}
+	f.lock.Unlock()
+
+	log := getLogger(ctx).WithField("hold_tag", f.opts.StepHoldTag)
+	log.WithField("snapshots", snaps).Debug("set step holds")
+	_, err := sender.SetStepHolds(ctx, &pdu.SetStepHoldsReq{
+		Filesystem: f.fs,
+		Tag:        f.opts.StepHoldTag,
+		Snapshots:  snaps,
+	})
+	if err != nil {
+		log.WithError(err).Warn("cannot hold snapshots of multi-step replication, they are not protected from concurrent destroys")
+		return
+	}
+	f.lock.Lock()
+	f.stepHolds = snaps
+	f.lock.Unlock()
+}
+
+// releaseStepHolds releases the holds set by setStepHolds after the last step completed.
+func (f *Replication) releaseStepHolds(ctx context.Context, sender Sender) {
+	f.lock.Lock()
+	held := len(f.stepHolds) > 0
+	f.stepHolds = nil
+	f.lock.Unlock()
+	if !held {
+		return
+	}
+	log := getLogger(ctx).WithField("hold_tag", f.opts.StepHoldTag)
+	log.Debug("release step holds")
+	_, err := sender.SetStepHolds(ctx, &pdu.SetStepHoldsReq{
+		Filesystem: f.fs,
+		Tag:        f.opts.StepHoldTag,
+	})
+	if err != nil {
+		log.WithError(err).Warn("cannot release step holds, they are released by the next replication of the filesystem")
+	}
+}
+
+// doSyncProperties copies opts.Properties from sender to receiver.
+// Properties that are not set on the sender are inherited on the receiver.
+func (f *Replication) doSyncProperties(ctx context.Context, ka *watchdog.KeepAlive, sender Sender, receiver Receiver) error {
+	log := getLogger(ctx)
+
+	log.Debug("get properties from sender")
+	gres, err := sender.GetProperties(ctx, &pdu.GetPropertiesReq{
+		Filesystem: f.fs,
+		Names:      f.opts.Properties,
+	})
+	if err != nil {
+		log.WithError(err).Error("error getting properties from sender")
+		return err
+	}
+	ka.MadeProgress()
+
+	log.Debug("set properties on receiver")
+	_, err = receiver.SetProperties(ctx, &pdu.SetPropertiesReq{
+		Filesystem: f.fs,
+		Set:        gres.GetSet(),
+		Inherit:    gres.GetInherited(),
+	})
+	if err != nil {
+		log.WithError(err).Error("error setting properties on receiver")
+		return err
+	}
+	ka.MadeProgress()
+	return nil
+}
+
 type updater func(func(fsr *Replication)) State
 
 type state func(ctx context.Context, ka *watchdog.KeepAlive, sender Sender, receiver Receiver, u updater) state
 
+// StepProgressTimeoutError is returned by a step that was aborted because it moved no data for ProgressTimeout,
+// e.g. because zfs recv hung.
+// It is a temporary net.Error so that the step is retried like after a network error.
+type StepProgressTimeoutError struct {
+	ProgressTimeout time.Duration
+	// Number of bytes moved before the step got stuck.
+	Bytes int64
+}
+
+var _ net.Error = &StepProgressTimeoutError{}
+
+func (e *StepProgressTimeoutError) Error() string {
+	return fmt.Sprintf("step made no progress for %s after %d bytes", e.ProgressTimeout, e.Bytes)
+}
+
+func (e *StepProgressTimeoutError) Timeout() bool { return true }
+
+func (e *StepProgressTimeoutError) Temporary() bool { return true }
+
 type StepError struct {
 	stepStr string
 	err     error
@@ -322,16 +609,16 @@ func (e StepError) Timeout() bool {
 }
 
 func (e StepError) Temporary() bool {
-	if neterr, ok := e.err.(net.Error); ok {
-		return neterr.Temporary()
-	}
-	return false
+	return errclass.Temporary(e.err)
 }
 
 func (e StepError) LocalToFS() bool {
 	if _, ok := e.err.(net.Error); ok {
 		return false
 	}
+	if pdu.Code(e.err) == pdu.ErrorCode_QuotaExceeded {
+		return false // a limit of the receiver, not of this filesystem
+	}
 	return true // conservative approximation: we'd like to check for specific errors returned over RPC here...
 }
 
@@ -352,6 +639,7 @@ func (fsr *Replication) Report() *Report {
 	rep := Report{
 		Filesystem: fsr.fs,
 		Status:     fsr.state.String(),
+		Resolution: fsr.resolution,
 	}
 
 	if fsr.err != nil && fsr.err.LocalToFS() {
@@ -400,6 +688,16 @@ func (s *ReplicationStep) doReplication(ctx context.Context, ka *watchdog.KeepAl
 	log := getLogger(ctx)
 	sr := s.buildSendRequest(false)
 
+	// cancelled if the step makes no progress for opts.StepProgressTimeout
+	ctx, cancelStep := context.WithCancel(ctx)
+	defer cancelStep()
+
+	if sr.ResumeToken != "" {
+		log.Info("resuming interrupted receive")
+	}
+	// an interrupted attempt of this step leaves a different resume token, or none if the receiver cleared it
+	s.resumeToken = ""
+
 	log.Debug("initiate send request")
 	sres, sstream, err := sender.Send(ctx, sr)
 	if err != nil {
@@ -411,21 +709,70 @@ func (s *ReplicationStep) doReplication(ctx context.Context, ka *watchdog.KeepAl
 		return err
 	}
 
-	s.byteCounter = util.NewByteCounterReader(sstream)
+	stall := util.NewStallDetectingReader(sstream, s.parent.opts.SendStallTimeout)
+	tee, err := s.openTee(ctx, sr, stall)
+	if err != nil {
+		stall.Close()
+		return err
+	}
+	metrics := s.parent.opts.StreamMetrics
+	var observeLatency func(time.Duration)
+	if metrics != nil && metrics.ReadLatency != nil {
+		observeLatency = func(d time.Duration) { metrics.ReadLatency.Observe(d.Seconds()) }
+	}
+	s.throughput = util.NewThroughputReader(tee, observeLatency)
+	s.byteCounter = util.NewByteCounterReader(s.throughput)
+	// export progress while the step is running, not only after it completed
+	var promReported int64
+	promReport := func(full int64) {
+		s.parent.promBytesReplicated.Add(float64(full - atomic.SwapInt64(&promReported, full)))
+	}
+	var stepProgress watchdog.KeepAlive
 	s.byteCounter.SetCallback(1*time.Second, func(i int64) {
 		ka.MadeProgress()
+		stepProgress.MadeProgress()
+		promReport(i)
 	})
+	var stuck int32
+	if timeout := s.parent.opts.StepProgressTimeout; timeout > 0 {
+		stop := stepProgress.Watch(timeout, func() {
+			atomic.StoreInt32(&stuck, 1)
+			log.WithField("step_progress_timeout", timeout).Error("step made no progress, aborting it")
+			// a hung zfs recv is killed through ctx, a hung zfs send by closing its stream
+			cancelStep()
+			stall.Close()
+		})
+		defer stop()
+	}
 	defer func() {
-		s.parent.promBytesReplicated.Add(float64(s.byteCounter.Bytes()))
+		promReport(s.byteCounter.Bytes())
 	}()
-	sstream = s.byteCounter
+	sstream = util.NewRateLimitedReader(ctx, s.byteCounter, s.parent.opts.RateLimiter)
 
 	rr := &pdu.ReceiveReq{
 		Filesystem:       fs,
 		ClearResumeToken: !sres.UsedResumeToken,
+		Raw:              s.parent.opts.Raw || s.parent.opts.Encrypted,
+		Compressed:       s.parent.opts.Compressed,
+		LargeBlocks:      s.parent.opts.LargeBlocks,
+		EmbeddedData:     s.parent.opts.EmbeddedData,
+		ToSnapshot:       s.to.GetName(),
+		ExpectedSize:     s.expectedSize,
 	}
+	for name, value := range s.parent.opts.RecvProperties.Override {
+		rr.OverrideProperties = append(rr.OverrideProperties, &pdu.Property{Name: name, Value: value})
+	}
+	rr.InheritProperties = s.parent.opts.RecvProperties.Inherit
 	log.Debug("initiate receive request")
 	err = receiver.Receive(ctx, rr, sstream)
+	if stallErr := stall.Stalled(); stallErr != nil {
+		// the receiver only saw the closed stream, report why it was closed
+		err = stallErr
+	} else if atomic.LoadInt32(&stuck) != 0 {
+		err = &StepProgressTimeoutError{ProgressTimeout: s.parent.opts.StepProgressTimeout, Bytes: s.byteCounter.Bytes()}
+	} else if teeErr := tee.Failed(); err != nil && teeErr != nil && s.parent.opts.TeeFailOnError {
+		err = teeErr
+	}
 	if err != nil {
 		log.
 			WithError(err).
@@ -438,7 +785,18 @@ func (s *ReplicationStep) doReplication(ctx context.Context, ka *watchdog.KeepAl
 		//  - a connectivity issue
 		return err
 	}
-	log.Debug("receive finished")
+	if teeErr := tee.Failed(); teeErr != nil {
+		log.WithError(teeErr).Warn("stream was replicated, but its copy is incomplete")
+	}
+	throughput := s.throughput.Stats()
+	log.
+		WithField("bytes", throughput.Bytes).
+		WithField("duration", throughput.Elapsed.String()).
+		WithField("bytes_per_second", throughput.AverageBytesPerSecond).
+		Debug("receive finished")
+	if metrics != nil && metrics.StepThroughput != nil && throughput.Elapsed > 0 {
+		metrics.StepThroughput.Observe(float64(throughput.AverageBytesPerSecond))
+	}
 	ka.MadeProgress()
 
 	s.state = StepMarkReplicatedReady
@@ -446,6 +804,33 @@ func (s *ReplicationStep) doReplication(ctx context.Context, ka *watchdog.KeepAl
 
 }
 
+// openTee wraps stream in a TeeReader that copies it to the target of opts.Tee, if any.
+// If the target cannot be opened, the stream is replicated without copy unless opts.TeeFailOnError is set.
+func (s *ReplicationStep) openTee(ctx context.Context, sr *pdu.SendReq, stream io.ReadCloser) (*util.TeeReader, error) {
+	opts := s.parent.opts
+	if opts.Tee == nil {
+		return util.NewTeeReader(stream, nil, false), nil
+	}
+	log := getLogger(ctx).WithField("tee", opts.Tee.String())
+	if sr.ResumeToken != "" {
+		// the stream would only contain the remainder of the interrupted one
+		log.Warn("not copying resumed stream")
+		return util.NewTeeReader(stream, nil, false), nil
+	}
+	target, err := opts.Tee.Open(ctx, sr)
+	if err != nil {
+		err = &util.TeeError{Err: err}
+		if opts.TeeFailOnError {
+			log.WithError(err).Error("cannot open stream tee")
+			return nil, err
+		}
+		log.WithError(err).Warn("cannot open stream tee, replicating without copy")
+		return util.NewTeeReader(stream, nil, false), nil
+	}
+	log.Debug("copying stream")
+	return util.NewTeeReader(stream, target, opts.TeeFailOnError), nil
+}
+
 func (s *ReplicationStep) doMarkReplicated(ctx context.Context, ka *watchdog.KeepAlive, sender Sender) error {
 
 	if s.state != StepMarkReplicatedReady {
@@ -470,6 +855,19 @@ func (s *ReplicationStep) doMarkReplicated(ctx context.Context, ka *watchdog.Kee
 	}
 	ka.MadeProgress()
 
+	if s.parent.opts.Bookmark {
+		log.Debug("bookmark replicated snapshot")
+		_, err = sender.Bookmark(ctx, &pdu.BookmarkReq{
+			Filesystem: s.parent.fs,
+			Snapshot:   s.to.GetName(),
+		})
+		if err != nil {
+			log.WithError(err).Error("error bookmarking replicated snapshot")
+			return err
+		}
+		ka.MadeProgress()
+	}
+
 	s.state = StepCompleted
 	return err
 }
@@ -492,19 +890,23 @@ func (s *ReplicationStep) updateSizeEstimate(ctx context.Context, sender Sender)
 
 func (s *ReplicationStep) buildSendRequest(dryRun bool) (sr *pdu.SendReq) {
 	fs := s.parent.fs
-	if s.from == nil {
-		sr = &pdu.SendReq{
-			Filesystem: fs,
-			To:         s.to.RelName(),
-			DryRun:     dryRun,
-		}
-	} else {
-		sr = &pdu.SendReq{
-			Filesystem: fs,
-			From:       s.from.RelName(),
-			To:         s.to.RelName(),
-			DryRun:     dryRun,
-		}
+	opts := s.parent.opts
+	sr = &pdu.SendReq{
+		Filesystem:   fs,
+		To:           s.to.RelName(),
+		DryRun:       dryRun,
+		Raw:          opts.Raw,
+		Encrypted:    opts.Encrypted,
+		Compress:     opts.Compressed,
+		LargeBlocks:  opts.LargeBlocks,
+		EmbeddedData: opts.EmbeddedData,
+	}
+	if s.from != nil {
+		sr.From = s.from.RelName()
+	}
+	if !dryRun {
+		// the size estimate is that of the whole step
+		sr.ResumeToken = s.resumeToken
 	}
 	return sr
 }
@@ -526,6 +928,11 @@ func (s *ReplicationStep) Report() *StepReport {
 	if s.byteCounter != nil {
 		bytes = s.byteCounter.Bytes()
 	}
+	var throughput *util.ThroughputStats
+	if s.throughput != nil {
+		stats := s.throughput.Stats()
+		throughput = &stats
+	}
 	problem := ""
 	if s.err != nil {
 		problem = s.err.Error()
@@ -535,8 +942,9 @@ func (s *ReplicationStep) Report() *StepReport {
 		To:     s.to.RelName(),
 		Status: s.state,
 		Problem: problem,
-		Bytes:  bytes,
+		TransferredBytes: bytes,
 		ExpectedBytes: s.expectedSize,
+		Throughput: throughput,
 	}
 	return &rep
 }
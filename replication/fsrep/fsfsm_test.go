@@ -0,0 +1,300 @@
+package fsrep
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/replication/pdu"
+	"github.com/zrepl/zrepl/util/watchdog"
+	"github.com/zrepl/zrepl/zfs"
+)
+
+// fakeSender records the requests it receives, the returned errors can be set per request type.
+type fakeSender struct {
+	sends     []*pdu.SendReq
+	cursors   []*pdu.ReplicationCursorReq
+	bookmarks []*pdu.BookmarkReq
+	holds     []*pdu.SetStepHoldsReq
+
+	sendErr, bookmarkErr, holdErr error
+}
+
+var _ Sender = &fakeSender{}
+
+func (s *fakeSender) Send(ctx context.Context, r *pdu.SendReq) (*pdu.SendRes, io.ReadCloser, error) {
+	s.sends = append(s.sends, r)
+	if s.sendErr != nil {
+		return nil, nil, s.sendErr
+	}
+	if r.DryRun {
+		return &pdu.SendRes{ExpectedSize: 23}, nil, nil
+	}
+	return &pdu.SendRes{}, ioutil.NopCloser(bytes.NewReader([]byte("stream"))), nil
+}
+
+func (s *fakeSender) ReplicationCursor(ctx context.Context, req *pdu.ReplicationCursorReq) (*pdu.ReplicationCursorRes, error) {
+	s.cursors = append(s.cursors, req)
+	return &pdu.ReplicationCursorRes{}, nil
+}
+
+func (s *fakeSender) Bookmark(ctx context.Context, req *pdu.BookmarkReq) (*pdu.BookmarkRes, error) {
+	s.bookmarks = append(s.bookmarks, req)
+	if s.bookmarkErr != nil {
+		return nil, s.bookmarkErr
+	}
+	return &pdu.BookmarkRes{}, nil
+}
+
+func (s *fakeSender) SetStepHolds(ctx context.Context, req *pdu.SetStepHoldsReq) (*pdu.SetStepHoldsRes, error) {
+	s.holds = append(s.holds, req)
+	if s.holdErr != nil {
+		return nil, s.holdErr
+	}
+	return &pdu.SetStepHoldsRes{}, nil
+}
+
+func (s *fakeSender) GetProperties(ctx context.Context, req *pdu.GetPropertiesReq) (*pdu.GetPropertiesRes, error) {
+	return &pdu.GetPropertiesRes{}, nil
+}
+
+type fakeReceiver struct {
+	receives   []*pdu.ReceiveReq
+	receiveErr error
+}
+
+var _ Receiver = &fakeReceiver{}
+
+func (r *fakeReceiver) Receive(ctx context.Context, req *pdu.ReceiveReq, sendStream io.ReadCloser) error {
+	r.receives = append(r.receives, req)
+	defer sendStream.Close()
+	if _, err := io.Copy(ioutil.Discard, sendStream); err != nil {
+		return err
+	}
+	return r.receiveErr
+}
+
+func (r *fakeReceiver) SetProperties(ctx context.Context, req *pdu.SetPropertiesReq) (*pdu.SetPropertiesRes, error) {
+	return &pdu.SetPropertiesRes{}, nil
+}
+
+func snap(name string, txg uint64) *pdu.FilesystemVersion {
+	return &pdu.FilesystemVersion{
+		Type:      pdu.FilesystemVersion_Snapshot,
+		Name:      name,
+		Guid:      txg,
+		CreateTXG: txg,
+		Creation:  "2018-10-01T12:00:00Z",
+	}
+}
+
+// countingCounter records the sum of the values added to the counter.
+type countingCounter struct {
+	prometheus.Counter
+	mtx   sync.Mutex
+	total float64
+}
+
+func (c *countingCounter) Add(f float64) {
+	c.mtx.Lock()
+	c.total += f
+	c.mtx.Unlock()
+	c.Counter.Add(f)
+}
+
+func buildTestReplication(opts Options, steps ...*pdu.FilesystemVersion) *Replication {
+	return buildTestReplicationCounter(opts, prometheus.NewCounter(prometheus.CounterOpts{Name: "test"}), steps...)
+}
+
+func buildTestReplicationCounter(opts Options, bytesReplicated prometheus.Counter, steps ...*pdu.FilesystemVersion) *Replication {
+	b := BuildReplication("pool/fs", opts, bytesReplicated)
+	var from FilesystemVersion
+	for _, to := range steps {
+		b.AddStep(from, to)
+		from = to
+	}
+	return b.Done()
+}
+
+// replicateAll retries r until it completed or failed permanently.
+func replicateAll(t *testing.T, r *Replication, sender Sender, receiver Receiver) Error {
+	var ka watchdog.KeepAlive
+	for i := 0; i < 10; i++ {
+		if err := r.Retry(context.Background(), &ka, sender, receiver); err != nil {
+			return err
+		}
+		if r.State() == Completed {
+			return nil
+		}
+	}
+	t.Fatal("replication did not complete")
+	return nil
+}
+
+func TestBookmarkReplicatedSnapshots(t *testing.T) {
+	sender, receiver := &fakeSender{}, &fakeReceiver{}
+	r := buildTestReplication(Options{Bookmark: true}, snap("a", 1), snap("b", 2))
+	require.NoError(t, replicateAll(t, r, sender, receiver))
+
+	require.Len(t, sender.bookmarks, 2)
+	assert.Equal(t, "pool/fs", sender.bookmarks[0].Filesystem)
+	assert.Equal(t, "a", sender.bookmarks[0].Snapshot)
+	assert.Equal(t, "b", sender.bookmarks[1].Snapshot)
+	// the cursor is advanced before the bookmark is created
+	assert.Len(t, sender.cursors, 2)
+}
+
+func TestBookmarkDisabled(t *testing.T) {
+	sender, receiver := &fakeSender{}, &fakeReceiver{}
+	r := buildTestReplication(Options{}, snap("a", 1), snap("b", 2))
+	require.NoError(t, replicateAll(t, r, sender, receiver))
+	assert.Empty(t, sender.bookmarks)
+	assert.Len(t, sender.cursors, 2)
+}
+
+func TestBookmarkErrorFailsStep(t *testing.T) {
+	sender, receiver := &fakeSender{bookmarkErr: errors.New("permission denied")}, &fakeReceiver{}
+	r := buildTestReplication(Options{Bookmark: true}, snap("a", 1))
+
+	var ka watchdog.KeepAlive
+	err := r.Retry(context.Background(), &ka, sender, receiver)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "permission denied")
+	assert.Equal(t, Ready, r.State())
+	require.Len(t, r.pending, 1)
+	// the stream was received, a retry only repeats the bookmark
+	assert.Equal(t, StepMarkReplicatedReady, r.pending[0].state)
+
+	sender.bookmarkErr = nil
+	require.NoError(t, r.Retry(context.Background(), &ka, sender, receiver))
+	assert.Equal(t, Completed, r.State())
+	assert.Len(t, receiver.receives, 1)
+	assert.Len(t, sender.bookmarks, 2)
+}
+
+func TestRawSendOptions(t *testing.T) {
+	for _, opts := range []Options{{Raw: true}, {Encrypted: true}} {
+		sender, receiver := &fakeSender{}, &fakeReceiver{}
+		r := buildTestReplication(opts, snap("a", 1))
+		require.NoError(t, replicateAll(t, r, sender, receiver))
+
+		require.Len(t, sender.sends, 1)
+		assert.Equal(t, opts.Raw, sender.sends[0].Raw)
+		assert.Equal(t, opts.Encrypted, sender.sends[0].Encrypted)
+		// the receiver must know that it gets a raw stream, also if the sender only checked for encryption
+		require.Len(t, receiver.receives, 1)
+		assert.True(t, receiver.receives[0].Raw)
+	}
+
+	sender, receiver := &fakeSender{}, &fakeReceiver{}
+	r := buildTestReplication(Options{}, snap("a", 1))
+	require.NoError(t, replicateAll(t, r, sender, receiver))
+	assert.False(t, sender.sends[0].Raw)
+	assert.False(t, receiver.receives[0].Raw)
+}
+
+func TestStreamFeatureOptions(t *testing.T) {
+	sender, receiver := &fakeSender{}, &fakeReceiver{}
+	opts := Options{Compressed: true, LargeBlocks: true, EmbeddedData: true}
+	r := buildTestReplication(opts, snap("a", 1), snap("b", 2))
+	require.NoError(t, r.UpdateSizeEsitmate(context.Background(), sender))
+	require.NoError(t, replicateAll(t, r, sender, receiver))
+
+	// dry runs must estimate the size of the stream that is actually sent
+	require.Len(t, sender.sends, 4)
+	for _, sr := range sender.sends {
+		assert.Equal(t, zfs.StreamFeatures{Compressed: true, LargeBlocks: true, EmbeddedData: true}, sr.StreamFeatures())
+	}
+	// the receiver checks its pool for the features of the stream
+	require.Len(t, receiver.receives, 2)
+	for _, rr := range receiver.receives {
+		assert.Equal(t, zfs.StreamFeatures{Compressed: true, LargeBlocks: true, EmbeddedData: true}, rr.StreamFeatures())
+	}
+}
+
+func TestStepByteProgress(t *testing.T) {
+	sender, receiver := &fakeSender{}, &fakeReceiver{}
+	counter := &countingCounter{Counter: prometheus.NewCounter(prometheus.CounterOpts{Name: "test"})}
+	r := buildTestReplicationCounter(Options{}, counter, snap("a", 1), snap("b", 2))
+	require.NoError(t, r.UpdateSizeEsitmate(context.Background(), sender))
+
+	rep := r.Report()
+	require.Len(t, rep.Pending, 2)
+	for _, s := range rep.Pending {
+		assert.Equal(t, int64(23), s.ExpectedBytes)
+		assert.Zero(t, s.TransferredBytes)
+	}
+
+	require.NoError(t, replicateAll(t, r, sender, receiver))
+	rep = r.Report()
+	require.Len(t, rep.Completed, 2)
+	for _, s := range rep.Completed {
+		assert.Equal(t, int64(23), s.ExpectedBytes)
+		assert.Equal(t, int64(len("stream")), s.TransferredBytes)
+	}
+	// every byte is exported exactly once
+	assert.Equal(t, float64(2*len("stream")), counter.total)
+}
+
+func TestStepHoldsReleasedOnPermanentError(t *testing.T) {
+	sender, receiver := &fakeSender{}, &fakeReceiver{receiveErr: errors.New("cannot receive: permission denied")}
+	r := buildTestReplication(Options{StepHoldTag: "zrepl_step_prod"}, snap("a", 1), snap("b", 2))
+
+	var ka watchdog.KeepAlive
+	err := r.Retry(context.Background(), &ka, sender, receiver)
+	require.Error(t, err)
+	assert.False(t, err.Temporary())
+	require.Len(t, sender.holds, 2)
+	assert.Equal(t, []string{"a", "b"}, sender.holds[0].Snapshots)
+	// the filesystem is not retried, so its remaining steps must not keep the snapshots held
+	assert.Equal(t, "pool/fs", sender.holds[1].Filesystem)
+	assert.Empty(t, sender.holds[1].Snapshots)
+}
+
+func TestResumeTokenOnlyForFirstAttempt(t *testing.T) {
+	sender, receiver := &fakeSender{}, &fakeReceiver{receiveErr: &net.OpError{Op: "read", Err: syscall.ECONNRESET}}
+	b := BuildReplication("pool/fs", Options{}, prometheus.NewCounter(prometheus.CounterOpts{Name: "test"}))
+	b.AddStep(snap("a", 1), snap("b", 2))
+	b.AddStep(snap("b", 2), snap("c", 3))
+	r := b.ResumeToken("1-abc").Done()
+	require.NoError(t, r.UpdateSizeEsitmate(context.Background(), sender))
+
+	var ka watchdog.KeepAlive
+	err := r.Retry(context.Background(), &ka, sender, receiver)
+	require.Error(t, err)
+	require.True(t, err.Temporary())
+
+	receiver.receiveErr = nil
+	require.NoError(t, replicateAll(t, r, sender, receiver))
+
+	require.Len(t, sender.sends, 5)
+	for _, sr := range sender.sends[:2] {
+		assert.True(t, sr.DryRun)
+		assert.Empty(t, sr.ResumeToken, "the size estimate is that of the whole step")
+	}
+	assert.Equal(t, "1-abc", sender.sends[2].ResumeToken)
+	// the interrupted attempt left a different token, or none
+	assert.Empty(t, sender.sends[3].ResumeToken)
+	assert.Equal(t, "@b", sender.sends[3].To)
+	assert.Empty(t, sender.sends[4].ResumeToken)
+}
+
+func TestReceiveReqNamesSnapshot(t *testing.T) {
+	sender, receiver := &fakeSender{}, &fakeReceiver{}
+	r := buildTestReplication(Options{}, snap("a", 1), snap("b", 2))
+	require.NoError(t, replicateAll(t, r, sender, receiver))
+	require.Len(t, receiver.receives, 2)
+	// the receiver passes it to its post-receive hooks
+	assert.Equal(t, "a", receiver.receives[0].ToSnapshot)
+	assert.Equal(t, "b", receiver.receives[1].ToSnapshot)
+}
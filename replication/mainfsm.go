@@ -14,9 +14,11 @@ import (
 	"math/bits"
 	"net"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/zrepl/zrepl/replication/errclass"
 	"github.com/zrepl/zrepl/replication/fsrep"
 	. "github.com/zrepl/zrepl/replication/internal/diff"
 	"github.com/zrepl/zrepl/replication/pdu"
@@ -62,6 +64,15 @@ type Replication struct {
 	// not protected by lock
 	promSecsPerState *prometheus.HistogramVec // labels: state
 	promBytesReplicated *prometheus.CounterVec // labels: filesystem
+	promBytesExpected *prometheus.GaugeVec // labels: filesystem
+
+	opts fsrep.Options
+	priorities Priorities
+	gracePeriod time.Duration
+	concurrency int
+	planConcurrency int
+	conflictPolicy ConflictPolicy
+	initialReplication InitialReplicationPolicy
 
 	Progress watchdog.KeepAlive
 
@@ -73,13 +84,16 @@ type Replication struct {
 	// Working, WorkingWait, Completed, ContextDone
 	queue     []*fsrep.Replication
 	completed []*fsrep.Replication
-	active    *fsrep.Replication // == queue[0] or nil, unlike in Report
+	active    []*fsrep.Replication // prefix of queue, unlike in Report
 
 	// for PlanningError, WorkingWait and ContextError and Completed
 	err error
 
 	// PlanningError, WorkingWait
 	sleepUntil time.Time
+
+	// by filesystem, replaced after each successful planning, see Plan
+	plan map[string]*fsPlan
 }
 
 type Report struct {
@@ -88,13 +102,39 @@ type Report struct {
 	SleepUntil time.Time
 	Completed []*fsrep.Report
 	Pending   []*fsrep.Report
-	Active    *fsrep.Report // not contained in Pending, unlike in struct Replication
+	Active    []*fsrep.Report // not contained in Pending, unlike in struct Replication
+}
+
+// Priorities determines the order in which filesystems are replicated,
+// filesystems with a higher priority are replicated first.
+type Priorities interface {
+	Priority(fs string) int
 }
 
-func NewReplication(secsPerState *prometheus.HistogramVec, bytesReplicated *prometheus.CounterVec) *Replication {
+// priorities may be nil, all filesystems then have the same priority.
+// Snapshots younger than gracePeriod are not replicated yet, 0 disables the grace period.
+// Up to concurrency filesystems are replicated and up to planConcurrency filesystems are planned in parallel,
+// which requires sender and receiver passed to Drive to be safe for concurrent use.
+// conflictPolicy determines what happens to receiving filesystems that have diverged from the sender,
+// initialReplication what is replicated of filesystems that the receiver does not have yet.
+func NewReplication(secsPerState *prometheus.HistogramVec, bytesReplicated *prometheus.CounterVec, bytesExpected *prometheus.GaugeVec, opts fsrep.Options, priorities Priorities, gracePeriod time.Duration, concurrency, planConcurrency int, conflictPolicy ConflictPolicy, initialReplication InitialReplicationPolicy) *Replication {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if planConcurrency < 1 {
+		planConcurrency = 1
+	}
 	r := Replication{
 		promSecsPerState: secsPerState,
 		promBytesReplicated: bytesReplicated,
+		promBytesExpected: bytesExpected,
+		opts:             opts,
+		priorities:       priorities,
+		gracePeriod:      gracePeriod,
+		concurrency:      concurrency,
+		planConcurrency:  planConcurrency,
+		conflictPolicy:   conflictPolicy,
+		initialReplication: initialReplication,
 		state:            Planning,
 	}
 	return &r
@@ -112,6 +152,16 @@ type Endpoint interface {
 	DestroySnapshots(ctx context.Context, req *pdu.DestroySnapshotsReq) (*pdu.DestroySnapshotsRes, error)
 }
 
+// A BatchLister lists the versions of many filesystems faster than one ListFilesystemVersions call per filesystem,
+// e.g. a local endpoint that runs one `zfs list` per pool, see zfs.ZFSListFilesystemVersionsBatch.
+// Sender and Receiver may implement it.
+type BatchLister interface {
+	// ListFilesystemVersionsBatch returns the versions of fss by filesystem.
+	// Filesystems that are missing in the result are listed with ListFilesystemVersions instead,
+	// i.e. filesystems that the endpoint does not allow access to can be omitted.
+	ListFilesystemVersionsBatch(ctx context.Context, fss []string) (map[string][]*pdu.FilesystemVersion, error)
+}
+
 type Sender interface {
 	Endpoint
 	fsrep.Sender
@@ -120,6 +170,8 @@ type Sender interface {
 type Receiver interface {
 	Endpoint
 	fsrep.Receiver
+	// Only used if the ConflictPolicy is not ConflictFail.
+	ResolveDivergence(ctx context.Context, req *pdu.ResolveDivergenceReq) (*pdu.ResolveDivergenceRes, error)
 }
 
 type FilteredError struct{ fs string }
@@ -174,21 +226,69 @@ func (r *Replication) Drive(ctx context.Context, sender Sender, receiver Receive
 		Debug("main final state")
 }
 
-func resolveConflict(conflict error) (path []*pdu.FilesystemVersion, msg string) {
+// trimFreshSnapshots removes the snapshots created after notAfter from the end of path,
+// and returns the trimmed path as well as the number of removed snapshots.
+//
+// For an incremental path, the incremental source path[0] is never removed, and an empty path
+// is returned if no step is left (i.e. the filesystem is up to date for now).
+// For a full send, the most recent of senderVersions' snapshots that is old enough is sent instead.
+// A path that starts with nil (see resolveConflict) is trimmed like an incremental path,
+// so the full send of path[1] only happens if path[1] is old enough.
+func trimFreshSnapshots(path, senderVersions []*pdu.FilesystemVersion, notAfter time.Time) ([]*pdu.FilesystemVersion, int) {
+	if len(path) == 1 {
+		if !path[0].SnapshotTime().After(notAfter) {
+			return path, 0
+		}
+		senderVersions = SortVersionListByCreateTXGThenBookmarkLTSnapshot(senderVersions)
+		deferred := 0
+		for n := len(senderVersions) - 1; n >= 0; n-- {
+			v := senderVersions[n]
+			if v.Type != pdu.FilesystemVersion_Snapshot {
+				continue
+			}
+			if !v.SnapshotTime().After(notAfter) {
+				return []*pdu.FilesystemVersion{v}, deferred
+			}
+			deferred++
+		}
+		return path[:0], deferred
+	}
+	n := len(path)
+	for n > 1 && path[n-1].SnapshotTime().After(notAfter) {
+		n--
+	}
+	deferred := len(path) - n
+	if n == 1 {
+		n = 0 // must not turn into a full send of path[0]
+	}
+	return path[:n], deferred
+}
+
+// resolveConflict returns the path to replicate despite conflict, or nil if the conflict cannot be resolved automatically,
+// as well as a message for the log.
+// A path that starts with nil is a full send of path[1] followed by incremental steps, see InitialReplicationAll.
+func resolveConflict(conflict error, initial InitialReplicationPolicy) (path []*pdu.FilesystemVersion, msg string) {
 	if noCommonAncestor, ok := conflict.(*ConflictNoCommonAncestor); ok {
 		if len(noCommonAncestor.SortedReceiverVersions) == 0 {
-			// TODO this is hard-coded replication policy: most recent snapshot as source
-			var mostRecentSnap *pdu.FilesystemVersion
-			for n := len(noCommonAncestor.SortedSenderVersions) - 1; n >= 0; n-- {
-				if noCommonAncestor.SortedSenderVersions[n].Type == pdu.FilesystemVersion_Snapshot {
-					mostRecentSnap = noCommonAncestor.SortedSenderVersions[n]
-					break
+			var snaps []*pdu.FilesystemVersion
+			for _, v := range noCommonAncestor.SortedSenderVersions {
+				if v.Type == pdu.FilesystemVersion_Snapshot {
+					snaps = append(snaps, v)
 				}
 			}
-			if mostRecentSnap == nil {
+			if len(snaps) == 0 {
 				return nil, "no snapshots available on sender side"
 			}
-			return []*pdu.FilesystemVersion{mostRecentSnap}, fmt.Sprintf("start replication at most recent snapshot %s", mostRecentSnap.RelName())
+			switch initial {
+			case InitialReplicationAll:
+				path = append([]*pdu.FilesystemVersion{nil}, snaps...)
+				return path, fmt.Sprintf("start replication at oldest snapshot %s and replicate all %d snapshots", snaps[0].RelName(), len(snaps))
+			case InitialReplicationFail:
+				return nil, "initial replication is disabled by initial_replication: fail"
+			default:
+				mostRecentSnap := snaps[len(snaps)-1]
+				return []*pdu.FilesystemVersion{mostRecentSnap}, fmt.Sprintf("start replication at most recent snapshot %s", mostRecentSnap.RelName())
+			}
 		}
 	}
 	return nil, "no automated way to handle conflict type"
@@ -206,10 +306,7 @@ var _ Error = net.Error(nil)
 var _ Error = streamrpc.Error(nil)
 
 func isPermanent(err error) bool {
-	if e, ok := err.(Error); ok {
-		return !e.Temporary()
-	}
-	return true
+	return !errclass.Temporary(err)
 }
 
 func statePlanning(ctx context.Context, ka *watchdog.KeepAlive, sender Sender, receiver Receiver, u updater) state {
@@ -239,6 +336,19 @@ func statePlanning(ctx context.Context, ka *watchdog.KeepAlive, sender Sender, r
 	}
 	// no progress here since we could run in a live-lock on connectivity issues
 
+	var stepHoldTag string
+	u(func(r *Replication) {
+		stepHoldTag = r.opts.StepHoldTag
+	})
+	if stepHoldTag != "" {
+		// filesystems that are not replicated anymore, e.g. after a filter change, would keep their step holds forever
+		_, err := sender.SetStepHolds(ctx, &pdu.SetStepHoldsReq{Tag: stepHoldTag})
+		if err != nil {
+			log.WithError(err).WithField("hold_tag", stepHoldTag).
+				Warn("cannot release step holds of filesystems that are no longer replicated")
+		}
+	}
+
 	rfss, err := receiver.ListFilesystems(ctx)
 	if err != nil {
 		log.WithError(err).Error("error listing receiver filesystems")
@@ -247,55 +357,206 @@ func statePlanning(ctx context.Context, ka *watchdog.KeepAlive, sender Sender, r
 
 	ka.MadeProgress() // for both sender and receiver
 
-	q := make([]*fsrep.Replication, 0, len(sfss))
-	mainlog := log
+	var batch batchVersions
+	sfsPaths := make([]string, 0, len(sfss))
+	rfsPaths := make([]string, 0, len(sfss))
 	for _, fs := range sfss {
+		sfsPaths = append(sfsPaths, fs.Path)
+		for _, rfs := range rfss {
+			if rfs.Path == fs.Path {
+				rfsPaths = append(rfsPaths, fs.Path)
+				break
+			}
+		}
+	}
+	batch.sender = listVersionsBatch(ctx, sender, sfsPaths)
+	batch.receiver = listVersionsBatch(ctx, receiver, rfsPaths)
+	ka.MadeProgress()
 
-		log := mainlog.WithField("filesystem", fs.Path)
+	var planConcurrency int
+	u(func(r *Replication) {
+		planConcurrency = r.planConcurrency
+	})
 
-		log.Debug("assessing filesystem")
+	// filesystems are planned in parallel, but queued in the order of the sender's list
+	qitems := make([]*fsrep.Replication, len(sfss))
+	fsplans := make([]*fsPlan, len(sfss))
+	planCtx, cancelPlan := context.WithCancel(ctx)
+	defer cancelPlan()
+	var (
+		wg       sync.WaitGroup
+		errMtx   sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, planConcurrency)
+	for i, fs := range sfss {
+		sem <- struct{}{}
+		if planCtx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int, fs *pdu.Filesystem) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			qitem, fsplan, err := planFilesystem(planCtx, ka, sender, receiver, u, fs, rfss, batch)
+			if err != nil {
+				errMtx.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMtx.Unlock()
+				cancelPlan() // errors of the other filesystems are caused by the cancellation
+				return
+			}
+			qitems[i], fsplans[i] = qitem, fsplan
+		}(i, fs)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return handlePlanningError(firstErr)
+	}
 
-		sfsvs, err := sender.ListFilesystemVersions(ctx, fs.Path)
+	q := make([]*fsrep.Replication, 0, len(sfss))
+	plan := make(map[string]*fsPlan, len(sfss))
+	for i, qitem := range qitems {
+		if qitem == nil {
+			continue // ignored by the receiver
+		}
+		q = append(q, qitem)
+		if fsplans[i] != nil {
+			plan[sfss[i].Path] = fsplans[i]
+		}
+	}
+
+	ka.MadeProgress()
+
+	return u(func(r *Replication) {
+		r.completed = nil
+		r.queue = q
+		r.plan = plan
+		r.err = nil
+		r.state = Working
+	}).rsf()
+}
+
+// batchVersions are the versions of sender and receiver filesystems that were listed before planning,
+// see BatchLister. A nil map means that the endpoint's versions are listed per filesystem.
+type batchVersions struct {
+	sender, receiver map[string][]*pdu.FilesystemVersion
+}
+
+// listVersionsBatch returns the versions of fss if ep is a BatchLister.
+// It returns nil if it is not or if the batch listing fails,
+// in which case planFilesystem lists the versions of each filesystem separately
+// so that an error only affects the filesystem that causes it.
+func listVersionsBatch(ctx context.Context, ep Endpoint, fss []string) map[string][]*pdu.FilesystemVersion {
+	bl, ok := ep.(BatchLister)
+	if !ok || len(fss) == 0 {
+		return nil
+	}
+	versions, err := bl.ListFilesystemVersionsBatch(ctx, fss)
+	if err != nil {
+		getLogger(ctx).WithError(err).Warn("cannot list filesystem versions in batch, listing them per filesystem")
+		return nil
+	}
+	return versions
+}
+
+// planFilesystem returns the replication of fs and its plan, or a replication that reports
+// a conflict and no plan, or neither if the receiver ignores fs. Errors are global planning errors.
+// It is called concurrently for different filesystems.
+func planFilesystem(ctx context.Context, ka *watchdog.KeepAlive, sender Sender, receiver Receiver, u updater, fs *pdu.Filesystem, rfss []*pdu.Filesystem, batch batchVersions) (*fsrep.Replication, *fsPlan, error) {
+
+	log := getLogger(ctx).WithField("fs", fs.Path)
+
+	log.Debug("assessing filesystem")
+
+	var err error
+	sfsvs, listed := batch.sender[fs.Path]
+	if !listed {
+		sfsvs, err = sender.ListFilesystemVersions(ctx, fs.Path)
 		if err != nil {
 			log.WithError(err).Error("cannot get remote filesystem versions")
-			return handlePlanningError(err)
+			return nil, nil, err
 		}
-		ka.MadeProgress()
+	}
+	ka.MadeProgress()
 
-		if len(sfsvs) < 1 {
-			err := errors.New("sender does not have any versions")
-			log.Error(err.Error())
-			q = append(q, fsrep.NewReplicationConflictError(fs.Path, err))
-			continue
+	if len(sfsvs) < 1 {
+		err := errors.New("sender does not have any versions")
+		log.Error(err.Error())
+		return fsrep.NewReplicationConflictError(fs.Path, err), nil, nil
+	}
+
+	var rfs *pdu.Filesystem
+	for _, f := range rfss {
+		if f.Path == fs.Path {
+			rfs = f
 		}
+	}
+	receiverFSExists := rfs != nil
 
-		receiverFSExists := false
-		for _, rfs := range rfss {
-			if rfs.Path == fs.Path {
-				receiverFSExists = true
+	rfsvs := []*pdu.FilesystemVersion{}
+	if receiverFSExists {
+		rfsvs, listed = batch.receiver[fs.Path]
+	}
+	if receiverFSExists && !listed {
+		rfsvs, err = receiver.ListFilesystemVersions(ctx, fs.Path)
+		if err != nil {
+			if _, ok := err.(*FilteredError); ok || pdu.Code(err) == pdu.ErrorCode_PermissionDenied {
+				log.Info("receiver ignores filesystem")
+				return nil, nil, nil
 			}
+			log.WithError(err).Error("receiver error")
+			return nil, nil, err
 		}
+	}
+	ka.MadeProgress()
+
+	var promBytesReplicated *prometheus.CounterVec
+	var promBytesExpected *prometheus.GaugeVec
+	var opts fsrep.Options
+	var priorities Priorities
+	var gracePeriod time.Duration
+	var conflictPolicy ConflictPolicy
+	var initialReplication InitialReplicationPolicy
+	var prev *fsPlan
+	u(func(replication *Replication) { // FIXME args struct like in pruner (also use for sender and receiver)
+		promBytesReplicated = replication.promBytesReplicated
+		promBytesExpected = replication.promBytesExpected
+		opts = replication.opts
+		priorities = replication.priorities
+		gracePeriod = replication.gracePeriod
+		conflictPolicy = replication.conflictPolicy
+		initialReplication = replication.initialReplication
+		prev = replication.plan[fs.Path]
+	})
 
-		var rfsvs []*pdu.FilesystemVersion
-		if receiverFSExists {
-			rfsvs, err = receiver.ListFilesystemVersions(ctx, fs.Path)
+	digest := versionsDigest(sfsvs, rfsvs)
+	var path []*pdu.FilesystemVersion
+	var sizes []int64
+	var resolution string
+	reuse := prev != nil && prev.digest == digest
+	if reuse {
+		log.Debug("versions unchanged, reusing previous plan")
+		path = prev.path
+	} else {
+		var conflict error
+		path, conflict = IncrementalPath(rfsvs, sfsvs)
+		if diverged, ok := conflict.(*ConflictDiverged); ok && conflictPolicy != ConflictFail {
+			log.WithField("conflict", conflict).WithField("conflict_policy", conflictPolicy).Warn("receiver has diverged")
+			rfsvs, resolution, err = resolveDivergence(ctx, receiver, fs.Path, diverged, conflictPolicy)
 			if err != nil {
-				if _, ok := err.(*FilteredError); ok {
-					log.Info("receiver ignores filesystem")
-					continue
-				}
-				log.WithError(err).Error("receiver error")
-				return handlePlanningError(err)
+				log.WithError(err).Error("cannot resolve diverged receiver")
+				return fsrep.NewReplicationConflictError(fs.Path, err), nil, nil
 			}
-		} else {
-			rfsvs = []*pdu.FilesystemVersion{}
+			log.WithField("resolution", resolution).Warn("resolved diverged receiver")
+			digest = versionsDigest(sfsvs, rfsvs)
+			path, conflict = IncrementalPath(rfsvs, sfsvs)
 		}
-		ka.MadeProgress()
-
-		path, conflict := IncrementalPath(rfsvs, sfsvs)
 		if conflict != nil {
 			var msg string
-			path, msg = resolveConflict(conflict) // no shadowing allowed!
+			path, msg = resolveConflict(conflict, initialReplication) // no shadowing allowed!
 			if path != nil {
 				log.WithField("conflict", conflict).Info("conflict")
 				log.WithField("resolution", msg).Info("automatically resolved")
@@ -306,43 +567,71 @@ func statePlanning(ctx context.Context, ka *watchdog.KeepAlive, sender Sender, r
 		}
 		ka.MadeProgress()
 		if path == nil {
-			q = append(q, fsrep.NewReplicationConflictError(fs.Path, conflict))
-			continue
+			return fsrep.NewReplicationConflictError(fs.Path, conflict), nil, nil
 		}
+	}
 
-		var promBytesReplicated *prometheus.CounterVec
-		u(func(replication *Replication) { // FIXME args struct like in pruner (also use for sender and receiver)
-			promBytesReplicated = replication.promBytesReplicated
-		})
-		fsrfsm := fsrep.BuildReplication(fs.Path, promBytesReplicated.WithLabelValues(fs.Path))
-		if len(path) == 1 {
-			fsrfsm.AddStep(nil, path[0])
-		} else {
-			for i := 0; i < len(path)-1; i++ {
-				fsrfsm.AddStep(path[i], path[i+1])
+	// also for a reused plan, its snapshots may have left the grace period in the meantime
+	planned := path
+	if gracePeriod > 0 {
+		var deferred int
+		path, deferred = trimFreshSnapshots(path, sfsvs, time.Now().Add(-gracePeriod))
+		if deferred > 0 {
+			log.WithField("deferred", deferred).WithField("grace_period", gracePeriod).
+				Info("not replicating snapshots within grace period")
+		}
+	}
+	if reuse && sameVersions(path, prev.trimmed) {
+		sizes = prev.sizes
+	}
+	fsrfsm := fsrep.BuildReplication(fs.Path, opts, promBytesReplicated.WithLabelValues(fs.Path))
+	if priorities != nil {
+		fsrfsm.Priority(priorities.Priority(fs.Path))
+	}
+	if resolution != "" {
+		fsrfsm.Resolution(resolution)
+	}
+	if len(path) == 1 {
+		fsrfsm.AddStep(nil, path[0])
+	} else {
+		for i := 0; i < len(path)-1; i++ {
+			if path[i] == nil {
+				fsrfsm.AddStep(nil, path[i+1]) // AddStep(path[i], ...) would pass a non-nil interface
+				continue
 			}
+			fsrfsm.AddStep(path[i], path[i+1])
 		}
-		qitem := fsrfsm.Done()
-		ka.MadeProgress()
+	}
+	if rfs.GetResumeToken() != "" {
+		if resumesFirstStep(rfs, path) {
+			fsrfsm.ResumeToken(rfs.ResumeToken)
+		} else {
+			log.Info("receiver has the state of an interrupted receive that does not continue the plan, it is discarded")
+		}
+	}
+	qitem := fsrfsm.Done()
+	ka.MadeProgress()
 
+	if sizes != nil {
+		qitem.SetSizeEstimates(sizes)
+	} else {
 		log.Debug("compute send size estimate")
 		if err = qitem.UpdateSizeEsitmate(ctx, sender); err != nil {
 			log.WithError(err).Error("error computing size estimate")
-			return handlePlanningError(err)
+			return nil, nil, err
+		}
+		for _, s := range qitem.Report().Pending {
+			sizes = append(sizes, s.ExpectedBytes)
 		}
-		ka.MadeProgress()
-
-		q = append(q, qitem)
 	}
-
+	var expected int64
+	for _, size := range sizes {
+		expected += size
+	}
+	promBytesExpected.WithLabelValues(fs.Path).Set(float64(expected))
 	ka.MadeProgress()
 
-	return u(func(r *Replication) {
-		r.completed = nil
-		r.queue = q
-		r.err = nil
-		r.state = Working
-	}).rsf()
+	return qitem, &fsPlan{digest, planned, path, sizes}, nil
 }
 
 func statePlanningError(ctx context.Context, ka *watchdog.KeepAlive, sender Sender, receiver Receiver, u updater) state {
@@ -403,92 +692,195 @@ func (e FilesystemsReplicationFailedError) Error() string {
 	return fmt.Sprintf("%s could not be replicated: %s", fsstr, errorStr)
 }
 
-func stateWorking(ctx context.Context, ka *watchdog.KeepAlive, sender Sender, receiver Receiver, u updater) state {
+// replicateBefore defines the order in which filesystems with pending steps are replicated:
+// higher priority first, and among equal priorities, the oldest next step first.
+func replicateBefore(a, b *fsrep.Replication) bool {
+	if a.Priority() != b.Priority() {
+		return a.Priority() > b.Priority()
+	}
+	return a.NextStepDate().Before(b.NextStepDate())
+}
 
-	var active *fsrep.Replication
-	rsfNext := u(func(r *Replication) {
+// pickActive returns the filesystems of the sorted queue to start replicating so that up to n are active,
+// skipping those whose ancestor or descendant is already active or picked (a receive may create placeholders for parents),
+// and the remainder of the queue in its original order.
+func pickActive(queue, active []*fsrep.Replication, n int) (picked, rest []*fsrep.Replication) {
+	related := func(a, b string) bool {
+		return a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+	}
+	picked = make([]*fsrep.Replication, 0, n)
+	rest = make([]*fsrep.Replication, 0, len(queue))
+	for _, fsr := range queue {
+		pick := len(active)+len(picked) < n
+		for i := 0; pick && i < len(active); i++ {
+			pick = !related(fsr.FS(), active[i].FS())
+		}
+		for i := 0; pick && i < len(picked); i++ {
+			pick = !related(fsr.FS(), picked[i].FS())
+		}
+		if pick {
+			picked = append(picked, fsr)
+		} else {
+			rest = append(rest, fsr)
+		}
+	}
+	return picked, rest
+}
 
-		r.err = nil
+// startActive moves the filesystems that are to be replicated next from the queue to r.active and returns them.
+// Filesystems in deferred are only started if no filesystem is active.
+// If no filesystem is left to replicate, it completes the replication.
+// r.lock must be held.
+func (r *Replication) startActive(deferred map[*fsrep.Replication]bool) []*fsrep.Replication {
 
-		newq := make([]*fsrep.Replication, 0, len(r.queue))
-		for i := range r.queue {
-			if r.queue[i].CanRetry() {
-				newq = append(newq, r.queue[i])
-			} else {
-				r.completed = append(r.completed, r.queue[i])
-			}
+	pending := make([]*fsrep.Replication, 0, len(r.queue))
+	for _, fsr := range r.queue[len(r.active):] {
+		if fsr.CanRetry() {
+			pending = append(pending, fsr)
+		} else {
+			r.completed = append(r.completed, fsr)
 		}
-		sort.SliceStable(newq, func(i, j int) bool {
-			return newq[i].NextStepDate().Before(newq[j].NextStepDate())
-		})
-		r.queue = newq
+	}
+	sort.SliceStable(pending, func(i, j int) bool {
+		return replicateBefore(pending[i], pending[j])
+	})
 
-		if len(r.queue) == 0 {
-			r.state = Completed
-			fsWithErr := FilesystemsReplicationFailedError{ // prepare it
-				FilesystemsWithError: make([]*fsrep.Replication, 0, len(r.completed)),
-			}
-			for _, fs := range r.completed {
-				if fs.CanRetry() {
-					panic(fmt.Sprintf("implementation error: completed contains retryable FS %s %#v",
-						fs.FS(), fs.Err()))
-				}
-				if fs.Err() != nil {
-					fsWithErr.FilesystemsWithError = append(fsWithErr.FilesystemsWithError, fs)
-				}
+	if len(pending) == 0 && len(r.active) == 0 {
+		r.queue = pending
+		r.state = Completed
+		fsWithErr := FilesystemsReplicationFailedError{ // prepare it
+			FilesystemsWithError: make([]*fsrep.Replication, 0, len(r.completed)),
+		}
+		for _, fs := range r.completed {
+			if fs.CanRetry() {
+				panic(fmt.Sprintf("implementation error: completed contains retryable FS %s %#v",
+					fs.FS(), fs.Err()))
 			}
-			if len(fsWithErr.FilesystemsWithError) > 0 {
-				r.err = fsWithErr
-				r.state = PermanentError
+			if fs.Err() != nil {
+				fsWithErr.FilesystemsWithError = append(fsWithErr.FilesystemsWithError, fs)
 			}
-			return
 		}
+		if len(fsWithErr.FilesystemsWithError) > 0 {
+			r.err = fsWithErr
+			r.state = PermanentError
+		}
+		return nil
+	}
 
-		active =  r.queue[0] // do not dequeue: if it's done, it will be sorted the next time we check for more work
-		r.active = active
-	}).rsf()
+	candidates := make([]*fsrep.Replication, 0, len(pending))
+	var held []*fsrep.Replication
+	for _, fsr := range pending {
+		if deferred[fsr] && len(r.active) > 0 {
+			held = append(held, fsr)
+		} else {
+			candidates = append(candidates, fsr)
+		}
+	}
+	picked, rest := pickActive(candidates, r.active, r.concurrency)
+	r.active = append(r.active, picked...)
+	// r.active is a prefix of r.queue
+	r.queue = append(append(append(make([]*fsrep.Replication, 0, len(r.queue)), r.active...), rest...), held...)
+	return picked
+}
 
-	if active == nil {
-		return rsfNext
+// finishActive moves fsr from r.active back to the queue, after its step was taken.
+// r.lock must be held.
+func (r *Replication) finishActive(fsr *fsrep.Replication) {
+	active := make([]*fsrep.Replication, 0, len(r.active))
+	for _, a := range r.active {
+		if a != fsr {
+			active = append(active, a)
+		}
 	}
+	rest := r.queue[len(r.active):]
+	r.queue = append(append(append(make([]*fsrep.Replication, 0, len(r.queue)), active...), fsr), rest...)
+	r.active = active
+}
+
+// stateWorking takes the steps of up to r.concurrency filesystems at a time.
+// A filesystem is started as soon as a slot is free, i.e. a slow filesystem does not hold back the others,
+// and the queue is sorted again after each step.
+func stateWorking(ctx context.Context, ka *watchdog.KeepAlive, sender Sender, receiver Receiver, u updater) state {
 
-	activeCtx := fsrep.WithLogger(ctx, getLogger(ctx).WithField("fs", active.FS()))
-	err := active.Retry(activeCtx, ka, sender, receiver)
 	u(func(r *Replication) {
-		r.active = nil
-	}).rsf()
+		r.err = nil
+	})
 
-	if err != nil {
-		if err.ContextErr() && ctx.Err() != nil {
-			getLogger(ctx).WithError(err).
-				Info("filesystem replication was cancelled")
-			u(func(r*Replication) {
-				r.err = GlobalError{Err: err, Temporary: false}
-				r.state = PermanentError
-			})
-		} else if err.LocalToFS() {
-			getLogger(ctx).WithError(err).
-				Error("filesystem replication encountered a filesystem-specific error")
-			// we stay in this state and let the queuing logic above de-prioritize this failing FS
-		} else if err.Temporary() {
-			getLogger(ctx).WithError(err).
-				Error("filesystem encountered a non-filesystem-specific temporary error, enter retry-wait")
-			u(func(r *Replication) {
-				r.err = GlobalError{Err: err, Temporary: true}
-				r.sleepUntil = time.Now().Add(RetryInterval)
-				r.state = WorkingWait
-			}).rsf()
-		} else {
-			getLogger(ctx).WithError(err).
-				Error("encountered a permanent non-filesystem-specific error")
-			u(func(r *Replication) {
-				r.err = GlobalError{Err: err, Temporary: false}
-				r.state = PermanentError
-			}).rsf()
+	type stepResult struct {
+		fsr *fsrep.Replication
+		err fsrep.Error
+	}
+	results := make(chan stepResult)
+	running := 0
+	// filesystems whose step failed are retried once no other filesystem is active, like before this state is re-entered
+	deferred := make(map[*fsrep.Replication]bool)
+
+	for {
+		var start []*fsrep.Replication
+		u(func(r *Replication) {
+			if r.state != Working {
+				return // after a global error, only wait for the active filesystems
+			}
+			if running == 0 {
+				deferred = make(map[*fsrep.Replication]bool)
+			}
+			start = r.startActive(deferred)
+		})
+		for _, fsr := range start {
+			running++
+			go func(fsr *fsrep.Replication) {
+				activeCtx := fsrep.WithLogger(ctx, getLogger(ctx).WithField("fs", fsr.FS()))
+				results <- stepResult{fsr, fsr.Retry(activeCtx, ka, sender, receiver)}
+			}(fsr)
+		}
+		if running == 0 {
+			return u(nil).rsf()
+		}
+
+		res := <-results
+		running--
+		u(func(r *Replication) {
+			r.finishActive(res.fsr)
+		})
+		if res.err != nil {
+			deferred[res.fsr] = true
+			handleStepError(ctx, u, res.err)
 		}
 	}
+}
 
-	return u(nil).rsf()
+// handleStepError changes the state of the replication according to the error of a filesystem's step.
+func handleStepError(ctx context.Context, u updater, err fsrep.Error) {
+	if err.ContextErr() && ctx.Err() != nil {
+		getLogger(ctx).WithError(err).
+			Info("filesystem replication was cancelled")
+		u(func(r *Replication) {
+			r.err = GlobalError{Err: err, Temporary: false}
+			r.state = PermanentError
+		})
+	} else if err.LocalToFS() {
+		getLogger(ctx).WithError(err).
+			Error("filesystem replication encountered a filesystem-specific error")
+		// we stay in this state and let the queuing logic above de-prioritize this failing FS
+	} else if err.Temporary() {
+		getLogger(ctx).WithError(err).
+			Error("filesystem encountered a non-filesystem-specific temporary error, enter retry-wait")
+		u(func(r *Replication) {
+			if r.state == PermanentError {
+				return // takes precedence
+			}
+			r.err = GlobalError{Err: err, Temporary: true}
+			r.sleepUntil = time.Now().Add(RetryInterval)
+			r.state = WorkingWait
+		})
+	} else {
+		getLogger(ctx).WithError(err).
+			Error("encountered a permanent non-filesystem-specific error")
+		u(func(r *Replication) {
+			r.err = GlobalError{Err: err, Temporary: false}
+			r.state = PermanentError
+		})
+	}
 }
 
 func stateWorkingWait(ctx context.Context, ka *watchdog.KeepAlive, sender Sender, receiver Receiver, u updater) state {
@@ -537,12 +929,11 @@ func (r *Replication) Report() *Report {
 	rep.Pending = make([]*fsrep.Report, 0, len(r.queue))
 	rep.Completed = make([]*fsrep.Report, 0, len(r.completed)) // room for active (potentially)
 
-	// since r.active == r.queue[0], do not contain it in pending output
-	pending := r.queue
-	if r.active != nil {
-		rep.Active = r.active.Report()
-		pending = r.queue[1:]
+	// since r.active is a prefix of r.queue, do not contain it in pending output
+	for _, fsr := range r.active {
+		rep.Active = append(rep.Active, fsr.Report())
 	}
+	pending := r.queue[len(r.active):]
 	for _, fsr := range pending {
 		rep.Pending= append(rep.Pending, fsr.Report())
 	}
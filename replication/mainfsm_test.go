@@ -0,0 +1,574 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/replication/fsrep"
+	"github.com/zrepl/zrepl/replication/pdu"
+	"github.com/zrepl/zrepl/util/watchdog"
+)
+
+// fakeEndpoint is an in-memory Sender and Receiver that is safe for concurrent use.
+type fakeEndpoint struct {
+	mtx      sync.Mutex
+	versions map[string][]*pdu.FilesystemVersion
+	listErrs map[string]error
+	// filesystems of the ListFilesystemVersions calls, in order
+	listed []string
+	// if not nil, called by ListFilesystemVersions before it returns, without mtx held
+	onList func(ctx context.Context, fs string) error
+	// if not nil, called by Send before it returns, without mtx held
+	onSend func(ctx context.Context, r *pdu.SendReq) error
+	// the SetStepHolds requests, in order
+	stepHolds []*pdu.SetStepHoldsReq
+	// the resume tokens of interrupted receives that ListFilesystems reports, by filesystem
+	resume map[string]*pdu.Filesystem
+}
+
+var _ Sender = &fakeEndpoint{}
+var _ Receiver = &fakeEndpoint{}
+
+func newFakeEndpoint() *fakeEndpoint {
+	return &fakeEndpoint{
+		versions: make(map[string][]*pdu.FilesystemVersion),
+		listErrs: make(map[string]error),
+	}
+}
+
+func (e *fakeEndpoint) add(fs string, vs ...*pdu.FilesystemVersion) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.versions[fs] = append(e.versions[fs], vs...)
+}
+
+func (e *fakeEndpoint) Listed() []string {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return append([]string(nil), e.listed...)
+}
+
+func (e *fakeEndpoint) ListFilesystems(ctx context.Context) ([]*pdu.Filesystem, error) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	fss := make([]*pdu.Filesystem, 0, len(e.versions))
+	for fs := range e.versions {
+		if r, ok := e.resume[fs]; ok {
+			fss = append(fss, r)
+			continue
+		}
+		fss = append(fss, &pdu.Filesystem{Path: fs})
+	}
+	sort.Slice(fss, func(i, j int) bool { return fss[i].Path < fss[j].Path })
+	return fss, nil
+}
+
+func (e *fakeEndpoint) ListFilesystemVersions(ctx context.Context, fs string) ([]*pdu.FilesystemVersion, error) {
+	e.mtx.Lock()
+	e.listed = append(e.listed, fs)
+	vs, err := e.versions[fs], e.listErrs[fs]
+	onList := e.onList
+	e.mtx.Unlock()
+	if onList != nil {
+		if err := onList(ctx, fs); err != nil {
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append([]*pdu.FilesystemVersion(nil), vs...), nil
+}
+
+func (e *fakeEndpoint) DestroySnapshots(ctx context.Context, req *pdu.DestroySnapshotsReq) (*pdu.DestroySnapshotsRes, error) {
+	return &pdu.DestroySnapshotsRes{}, nil
+}
+
+func (e *fakeEndpoint) Send(ctx context.Context, r *pdu.SendReq) (*pdu.SendRes, io.ReadCloser, error) {
+	e.mtx.Lock()
+	onSend := e.onSend
+	e.mtx.Unlock()
+	if onSend != nil {
+		if err := onSend(ctx, r); err != nil {
+			return nil, nil, err
+		}
+	}
+	if r.DryRun {
+		return &pdu.SendRes{ExpectedSize: 1024}, nil, nil
+	}
+	return &pdu.SendRes{}, ioutil.NopCloser(bytes.NewReader(make([]byte, 1024))), nil
+}
+
+func (e *fakeEndpoint) ReplicationCursor(ctx context.Context, req *pdu.ReplicationCursorReq) (*pdu.ReplicationCursorRes, error) {
+	return &pdu.ReplicationCursorRes{}, nil
+}
+
+func (e *fakeEndpoint) Bookmark(ctx context.Context, req *pdu.BookmarkReq) (*pdu.BookmarkRes, error) {
+	return &pdu.BookmarkRes{}, nil
+}
+
+func (e *fakeEndpoint) SetStepHolds(ctx context.Context, req *pdu.SetStepHoldsReq) (*pdu.SetStepHoldsRes, error) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.stepHolds = append(e.stepHolds, req)
+	return &pdu.SetStepHoldsRes{}, nil
+}
+
+func (e *fakeEndpoint) GetProperties(ctx context.Context, req *pdu.GetPropertiesReq) (*pdu.GetPropertiesRes, error) {
+	return &pdu.GetPropertiesRes{}, nil
+}
+
+func (e *fakeEndpoint) Receive(ctx context.Context, r *pdu.ReceiveReq, sendStream io.ReadCloser) error {
+	defer sendStream.Close()
+	_, err := io.Copy(ioutil.Discard, sendStream)
+	return err
+}
+
+func (e *fakeEndpoint) SetProperties(ctx context.Context, req *pdu.SetPropertiesReq) (*pdu.SetPropertiesRes, error) {
+	return &pdu.SetPropertiesRes{}, nil
+}
+
+func (e *fakeEndpoint) ResolveDivergence(ctx context.Context, req *pdu.ResolveDivergenceReq) (*pdu.ResolveDivergenceRes, error) {
+	return &pdu.ResolveDivergenceRes{}, nil
+}
+
+// batchEndpoint is a fakeEndpoint that implements BatchLister.
+// Filesystems with a list error are omitted from the batch.
+type batchEndpoint struct {
+	*fakeEndpoint
+	batchErr error
+	batches  int
+}
+
+var _ BatchLister = &batchEndpoint{}
+
+func (e *batchEndpoint) ListFilesystemVersionsBatch(ctx context.Context, fss []string) (map[string][]*pdu.FilesystemVersion, error) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.batches++
+	if e.batchErr != nil {
+		return nil, e.batchErr
+	}
+	res := make(map[string][]*pdu.FilesystemVersion, len(fss))
+	for _, fs := range fss {
+		if e.listErrs[fs] == nil {
+			res[fs] = append([]*pdu.FilesystemVersion(nil), e.versions[fs]...)
+		}
+	}
+	return res, nil
+}
+
+func newTestReplication(concurrency, planConcurrency int) *Replication {
+	return newTestReplicationGrace(concurrency, planConcurrency, 0)
+}
+
+func newTestReplicationGrace(concurrency, planConcurrency int, gracePeriod time.Duration) *Replication {
+	return NewReplication(
+		prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "test_secs"}, []string{"state"}),
+		prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_bytes"}, []string{"filesystem"}),
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_expected"}, []string{"filesystem"}),
+		fsrep.Options{}, nil, gracePeriod, concurrency, planConcurrency, ConflictFail, InitialReplicationMostRecent)
+}
+
+// runPlanning runs statePlanning for r and returns the state of r afterwards.
+func runPlanning(ctx context.Context, r *Replication, sender Sender, receiver Receiver) State {
+	u := func(f func(*Replication)) State {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		if f != nil {
+			f(r)
+		}
+		return r.state
+	}
+	var ka watchdog.KeepAlive
+	statePlanning(ctx, &ka, sender, receiver, u)
+	return u(nil)
+}
+
+func queuedFilesystems(r *Replication) []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	fss := make([]string, len(r.queue))
+	for i, q := range r.queue {
+		fss[i] = q.FS()
+	}
+	return fss
+}
+
+func testSnap(name string, txg uint64, creation time.Time) *pdu.FilesystemVersion {
+	return &pdu.FilesystemVersion{
+		Type:      pdu.FilesystemVersion_Snapshot,
+		Name:      name,
+		Guid:      txg,
+		CreateTXG: txg,
+		Creation:  pdu.FilesystemVersionCreation(creation),
+	}
+}
+
+func TestReplicateBefore(t *testing.T) {
+	now := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	fsr := func(fs string, prio int, next time.Time) *fsrep.Replication {
+		return fsrep.BuildReplication(fs, fsrep.Options{}, prometheus.NewCounter(prometheus.CounterOpts{Name: "test"})).
+			AddStep(nil, testSnap("s", 1, next)).
+			Priority(prio).
+			Done()
+	}
+	q := []*fsrep.Replication{
+		fsr("pool/media", -1, now.Add(-3*time.Hour)),
+		fsr("pool/new", 0, now),
+		fsr("pool/old", 0, now.Add(-time.Hour)),
+		fsr("pool/db", 10, now),
+	}
+	sort.SliceStable(q, func(i, j int) bool { return replicateBefore(q[i], q[j]) })
+
+	var order []string
+	for _, r := range q {
+		order = append(order, r.FS())
+	}
+	assert.Equal(t, []string{"pool/db", "pool/old", "pool/new", "pool/media"}, order)
+}
+
+func versionNames(vs []*pdu.FilesystemVersion) []string {
+	names := make([]string, len(vs))
+	for i, v := range vs {
+		names[i] = v.RelName()
+	}
+	return names
+}
+
+func TestTrimFreshSnapshots(t *testing.T) {
+	now := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	notAfter := now.Add(-10 * time.Minute)
+	a := testSnap("a", 1, now.Add(-2*time.Hour))
+	b := testSnap("b", 2, now.Add(-time.Hour))
+	c := testSnap("c", 3, now.Add(-5*time.Minute))
+	d := testSnap("d", 4, now)
+	bm := &pdu.FilesystemVersion{Type: pdu.FilesystemVersion_Bookmark, Name: "bm", Guid: 2, CreateTXG: 2, Creation: b.Creation}
+	senderVersions := []*pdu.FilesystemVersion{d, bm, b, a, c}
+
+	tcs := []struct {
+		name     string
+		path     []*pdu.FilesystemVersion
+		trimmed  []string
+		deferred int
+	}{
+		{"incremental, nothing fresh", []*pdu.FilesystemVersion{a, b}, []string{"@a", "@b"}, 0},
+		{"incremental, fresh tail", []*pdu.FilesystemVersion{a, b, c, d}, []string{"@a", "@b"}, 2},
+		{"incremental, all fresh", []*pdu.FilesystemVersion{b, c, d}, []string{}, 2},
+		{"full send, old enough", []*pdu.FilesystemVersion{b}, []string{"@b"}, 0},
+		{"full send of a fresh snapshot sends an older one", []*pdu.FilesystemVersion{d}, []string{"@b"}, 2},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			path, deferred := trimFreshSnapshots(tc.path, senderVersions, notAfter)
+			assert.Equal(t, tc.trimmed, versionNames(path))
+			assert.Equal(t, tc.deferred, deferred)
+		})
+	}
+
+	path, deferred := trimFreshSnapshots([]*pdu.FilesystemVersion{d}, []*pdu.FilesystemVersion{c, d}, notAfter)
+	assert.Empty(t, path, "no snapshot is old enough for a full send")
+	assert.Equal(t, 2, deferred)
+}
+
+func TestPlanningListsVersionsInBatch(t *testing.T) {
+	now := time.Now()
+	a1, a2 := testSnap("a1", 1, now.Add(-2*time.Hour)), testSnap("a2", 2, now.Add(-time.Hour))
+	sender := &batchEndpoint{fakeEndpoint: newFakeEndpoint()}
+	sender.add("pool/a", a1, a2)
+	sender.add("pool/b", testSnap("b1", 3, now))
+	sender.add("pool/c", testSnap("c1", 4, now))
+	receiver := &batchEndpoint{fakeEndpoint: newFakeEndpoint()}
+	receiver.add("pool/a", a1)
+
+	r := newTestReplication(1, 2)
+	require.Equal(t, Working, runPlanning(context.Background(), r, sender, receiver))
+	assert.Equal(t, []string{"pool/a", "pool/b", "pool/c"}, queuedFilesystems(r))
+	assert.Equal(t, 1, sender.batches)
+	assert.Equal(t, 1, receiver.batches)
+	assert.Empty(t, sender.Listed(), "all versions were listed in batch")
+	assert.Empty(t, receiver.Listed())
+
+	// a filesystem that is missing in the batch is listed separately
+	sender = &batchEndpoint{fakeEndpoint: newFakeEndpoint()}
+	sender.add("pool/a", a1, a2)
+	sender.add("pool/b", testSnap("b1", 3, now))
+	sender.listErrs["pool/b"] = &FilteredError{"pool/b"}
+	r = newTestReplication(1, 2)
+	assert.Equal(t, PermanentError, runPlanning(context.Background(), r, sender, receiver))
+	assert.Equal(t, []string{"pool/b"}, sender.Listed(), "the error is reported by ListFilesystemVersions")
+
+	// the versions of all filesystems are listed separately if the batch fails
+	sender = &batchEndpoint{fakeEndpoint: newFakeEndpoint(), batchErr: errors.New("zfs list failed")}
+	sender.add("pool/a", a1, a2)
+	sender.add("pool/b", testSnap("b1", 3, now))
+	r = newTestReplication(1, 1)
+	require.Equal(t, Working, runPlanning(context.Background(), r, sender, receiver))
+	assert.Equal(t, []string{"pool/a", "pool/b"}, sender.Listed())
+
+	// endpoints that do not implement BatchLister are listed per filesystem
+	plain := newFakeEndpoint()
+	plain.add("pool/a", a1, a2)
+	r = newTestReplication(1, 1)
+	require.Equal(t, Working, runPlanning(context.Background(), r, plain, receiver))
+	assert.Equal(t, []string{"pool/a"}, plain.Listed())
+}
+
+// queuedSteps returns the names of the target snapshots of the steps of fs in the queue of r.
+func queuedSteps(r *Replication, fs string) []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, q := range r.queue {
+		if q.FS() != fs {
+			continue
+		}
+		steps := []string{}
+		for _, s := range q.Report().Pending {
+			steps = append(steps, s.To)
+		}
+		return steps
+	}
+	return nil
+}
+
+func TestWarmStartTrimsFreshSnapshots(t *testing.T) {
+	now := time.Now()
+	a1, a2 := testSnap("a1", 1, now.Add(-2*time.Hour)), testSnap("a2", 2, now.Add(-20*time.Minute))
+	sender, receiver := newFakeEndpoint(), newFakeEndpoint()
+	sender.add("pool/a", a1, a2)
+	receiver.add("pool/a", a1)
+	var dryRuns int32
+	sender.onSend = func(ctx context.Context, r *pdu.SendReq) error {
+		if r.DryRun {
+			atomic.AddInt32(&dryRuns, 1)
+		}
+		return nil
+	}
+
+	r := newTestReplicationGrace(1, 1, 30*time.Minute)
+	require.Equal(t, Working, runPlanning(context.Background(), r, sender, receiver))
+	assert.Empty(t, queuedSteps(r, "pool/a"), "a2 is within the grace period")
+	plan := r.RemainingPlan()
+	require.NotNil(t, plan)
+
+	// the versions are unchanged, but a2 left the grace period since the plan was made
+	r = newTestReplicationGrace(1, 1, 10*time.Minute)
+	r.WarmStart(plan)
+	require.Equal(t, Working, runPlanning(context.Background(), r, sender, receiver))
+	assert.Equal(t, []string{"@a2"}, queuedSteps(r, "pool/a"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dryRuns), "the step was not estimated before")
+
+	// the size estimates are reused if the trimmed plan is unchanged
+	plan = r.RemainingPlan()
+	r = newTestReplicationGrace(1, 1, 10*time.Minute)
+	r.WarmStart(plan)
+	require.Equal(t, Working, runPlanning(context.Background(), r, sender, receiver))
+	assert.Equal(t, []string{"@a2"}, queuedSteps(r, "pool/a"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dryRuns))
+}
+
+func TestWorkingStartsFilesystemWhenSlotFrees(t *testing.T) {
+	now := time.Now()
+	sender, receiver := newFakeEndpoint(), newFakeEndpoint()
+	for i, fs := range []string{"pool/a", "pool/b", "pool/c"} {
+		sender.add(fs, testSnap("s", uint64(i+1), now))
+	}
+	cSent := make(chan struct{})
+	var inflight, maxInflight int32
+	sender.onSend = func(ctx context.Context, r *pdu.SendReq) error {
+		if r.DryRun {
+			return nil
+		}
+		if n := atomic.AddInt32(&inflight, 1); n > atomic.LoadInt32(&maxInflight) {
+			atomic.StoreInt32(&maxInflight, n)
+		}
+		defer atomic.AddInt32(&inflight, -1)
+		switch r.Filesystem {
+		case "pool/a":
+			// pool/c can only be started while pool/a is active if it takes the slot freed by pool/b
+			select {
+			case <-cSent:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case "pool/c":
+			close(cSent)
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	r := newTestReplication(2, 1)
+	r.Drive(ctx, sender, receiver)
+	require.NoError(t, ctx.Err(), "pool/c was not started before pool/a completed")
+	assert.Equal(t, Completed, r.State())
+	assert.Len(t, r.Report().Completed, 3)
+	assert.True(t, atomic.LoadInt32(&maxInflight) <= 2, "more filesystems than concurrency were active")
+}
+
+func TestPickActiveSkipsRelatives(t *testing.T) {
+	fsr := func(fs string) *fsrep.Replication {
+		return fsrep.BuildReplication(fs, fsrep.Options{}, prometheus.NewCounter(prometheus.CounterOpts{Name: "test"})).
+			AddStep(nil, testSnap("s", 1, time.Now())).
+			Done()
+	}
+	active := []*fsrep.Replication{fsr("pool/a")}
+	queue := []*fsrep.Replication{fsr("pool/a/child"), fsr("pool/b"), fsr("pool/b/child"), fsr("pool/c"), fsr("pool/d")}
+	picked, rest := pickActive(queue, active, 3)
+	assert.Equal(t, []*fsrep.Replication{queue[1], queue[3]}, picked)
+	assert.Equal(t, []*fsrep.Replication{queue[0], queue[2], queue[4]}, rest)
+}
+
+func TestPlanningReleasesStepHoldsOfUnmatchedFilesystems(t *testing.T) {
+	sender, receiver := newFakeEndpoint(), newFakeEndpoint()
+	sender.add("pool/a", testSnap("a1", 1, time.Now()))
+
+	r := newTestReplication(1, 1)
+	require.Equal(t, Working, runPlanning(context.Background(), r, sender, receiver))
+	assert.Empty(t, sender.stepHolds, "no step holds without a tag")
+
+	r = newTestReplication(1, 1)
+	r.opts.StepHoldTag = "zrepl_step_prod"
+	require.Equal(t, Working, runPlanning(context.Background(), r, sender, receiver))
+	require.Len(t, sender.stepHolds, 1)
+	assert.Equal(t, &pdu.SetStepHoldsReq{Tag: "zrepl_step_prod"}, sender.stepHolds[0])
+}
+
+func TestResumesFirstStep(t *testing.T) {
+	now := time.Now()
+	a, b, c := testSnap("a", 1, now), testSnap("b", 2, now), testSnap("c", 3, now)
+	token := func(from, to uint64) *pdu.Filesystem {
+		return &pdu.Filesystem{Path: "pool/a", ResumeToken: "1-abc", ResumeTokenFromGUID: from, ResumeTokenToGUID: to}
+	}
+	tcs := []struct {
+		name string
+		rfs  *pdu.Filesystem
+		path []*pdu.FilesystemVersion
+		want bool
+	}{
+		{"incremental", token(1, 2), []*pdu.FilesystemVersion{a, b, c}, true},
+		{"later step", token(2, 3), []*pdu.FilesystemVersion{a, b, c}, false},
+		{"other source", token(3, 2), []*pdu.FilesystemVersion{a, b}, false},
+		{"full", token(0, 1), []*pdu.FilesystemVersion{a}, true},
+		{"full token for incremental step", token(0, 2), []*pdu.FilesystemVersion{a, b}, false},
+		{"undecodable", token(1, 0), []*pdu.FilesystemVersion{a, b}, false},
+		{"no token", &pdu.Filesystem{Path: "pool/a", ResumeTokenFromGUID: 1, ResumeTokenToGUID: 2}, []*pdu.FilesystemVersion{a, b}, false},
+		{"empty path", token(1, 2), nil, false},
+	}
+	for _, tc := range tcs {
+		assert.Equal(t, tc.want, resumesFirstStep(tc.rfs, tc.path), tc.name)
+	}
+}
+
+func TestPlanningResumesInterruptedReceive(t *testing.T) {
+	now := time.Now()
+	a1, a2, a3 := testSnap("a1", 1, now.Add(-3*time.Hour)), testSnap("a2", 2, now.Add(-2*time.Hour)), testSnap("a3", 3, now.Add(-time.Hour))
+	sender, receiver := newFakeEndpoint(), newFakeEndpoint()
+	sender.add("pool/a", a1, a2, a3)
+	receiver.add("pool/a", a1)
+	var mtx sync.Mutex
+	var sends []*pdu.SendReq
+	sender.onSend = func(ctx context.Context, r *pdu.SendReq) error {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if !r.DryRun {
+			sends = append(sends, r)
+		}
+		return nil
+	}
+
+	// the token only continues the first step
+	receiver.resume = map[string]*pdu.Filesystem{
+		"pool/a": {Path: "pool/a", ResumeToken: "1-abc", ResumeTokenFromGUID: 1, ResumeTokenToGUID: 2},
+	}
+	r := newTestReplication(1, 1)
+	r.Drive(context.Background(), sender, receiver)
+	require.Equal(t, Completed, r.State())
+	require.Len(t, sends, 2)
+	assert.Equal(t, "1-abc", sends[0].ResumeToken)
+	assert.Empty(t, sends[1].ResumeToken)
+
+	// a token that does not continue the plan is not used
+	sends = nil
+	receiver.resume["pool/a"].ResumeTokenToGUID = 3
+	r = newTestReplication(1, 1)
+	r.Drive(context.Background(), sender, receiver)
+	require.Equal(t, Completed, r.State())
+	require.Len(t, sends, 2)
+	assert.Empty(t, sends[0].ResumeToken)
+}
+
+func TestPlanningConcurrencyLimit(t *testing.T) {
+	now := time.Now()
+	sender := newFakeEndpoint()
+	for i, fs := range []string{"pool/a", "pool/b", "pool/c", "pool/d", "pool/e", "pool/f"} {
+		sender.add(fs, testSnap("s", uint64(i+1), now))
+	}
+	var running, max int32
+	sender.onList = func(ctx context.Context, fs string) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}
+
+	r := newTestReplication(1, 2)
+	require.Equal(t, Working, runPlanning(context.Background(), r, sender, newFakeEndpoint()))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&max), "filesystems are planned in parallel, but at most planning concurrency at a time")
+	// queued in the order of the sender's list, not in the order in which their planning completed
+	assert.Equal(t, []string{"pool/a", "pool/b", "pool/c", "pool/d", "pool/e", "pool/f"}, queuedFilesystems(r))
+}
+
+func TestPlanningCancelsOnFirstError(t *testing.T) {
+	now := time.Now()
+	newSender := func() *fakeEndpoint {
+		sender := newFakeEndpoint()
+		for i, fs := range []string{"pool/a", "pool/b", "pool/c", "pool/d"} {
+			sender.add(fs, testSnap("s", uint64(i+1), now))
+		}
+		return sender
+	}
+
+	sender := newSender()
+	var cancelled int32
+	sender.onList = func(ctx context.Context, fs string) error {
+		if fs == "pool/c" {
+			return errors.New("cannot list pool/c")
+		}
+		// the other filesystems wait until their planning is cancelled
+		<-ctx.Done()
+		atomic.AddInt32(&cancelled, 1)
+		return ctx.Err()
+	}
+	r := newTestReplication(1, 4)
+	assert.Equal(t, PermanentError, runPlanning(context.Background(), r, sender, newFakeEndpoint()))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&cancelled))
+	assert.Contains(t, r.err.Error(), "cannot list pool/c", "the first error is reported, not the cancellations it caused")
+	assert.Empty(t, queuedFilesystems(r))
+
+	// filesystems whose planning has not started when an error occurs are not planned
+	sender = newSender()
+	sender.listErrs["pool/a"] = errors.New("cannot list pool/a")
+	r = newTestReplication(1, 1)
+	assert.Equal(t, PermanentError, runPlanning(context.Background(), r, sender, newFakeEndpoint()))
+	assert.Equal(t, []string{"pool/a"}, sender.Listed())
+}
@@ -18,6 +18,57 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
+// Errors of all RPCs carry an ErrorCode so that callers can react to them
+// programmatically. Errors that are not classified are Internal.
+type ErrorCode int32
+
+const (
+	ErrorCode_Internal         ErrorCode = 0
+	ErrorCode_InvalidArgument  ErrorCode = 1
+	ErrorCode_NotFound         ErrorCode = 2
+	ErrorCode_PermissionDenied ErrorCode = 3
+	// The operation conflicts with a concurrent one and may be retried later.
+	ErrorCode_Busy ErrorCode = 4
+	// The operation would destroy the snapshot that incremental replication builds upon.
+	ErrorCode_LastCommonSnapshot ErrorCode = 5
+	// The snapshot cannot be destroyed because it has user holds.
+	ErrorCode_Held ErrorCode = 6
+	// The snapshot cannot be destroyed because it has dependent clones.
+	ErrorCode_HasClones ErrorCode = 7
+	// The receiver refuses the operation because it would exceed a limit configured on the receiving side.
+	ErrorCode_QuotaExceeded ErrorCode = 8
+)
+
+var ErrorCode_name = map[int32]string{
+	0: "Internal",
+	1: "InvalidArgument",
+	2: "NotFound",
+	3: "PermissionDenied",
+	4: "Busy",
+	5: "LastCommonSnapshot",
+	6: "Held",
+	7: "HasClones",
+	8: "QuotaExceeded",
+}
+var ErrorCode_value = map[string]int32{
+	"Internal":           0,
+	"InvalidArgument":    1,
+	"NotFound":           2,
+	"PermissionDenied":   3,
+	"Busy":               4,
+	"LastCommonSnapshot": 5,
+	"Held":               6,
+	"HasClones":          7,
+	"QuotaExceeded":      8,
+}
+
+func (x ErrorCode) String() string {
+	return proto.EnumName(ErrorCode_name, int32(x))
+}
+func (ErrorCode) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{0}
+}
+
 type FilesystemVersion_VersionType int32
 
 const (
@@ -38,7 +89,32 @@ func (x FilesystemVersion_VersionType) String() string {
 	return proto.EnumName(FilesystemVersion_VersionType_name, int32(x))
 }
 func (FilesystemVersion_VersionType) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_pdu_fe566e6b212fcf8d, []int{5, 0}
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{5, 0}
+}
+
+type ResolveDivergenceReq_Action int32
+
+const (
+	// zfs rollback -r to CommonAncestor, which destroys Diverged.
+	ResolveDivergenceReq_Rollback ResolveDivergenceReq_Action = 0
+	// Rename the receiving filesystem aside, replication then starts over.
+	ResolveDivergenceReq_Rename ResolveDivergenceReq_Action = 1
+)
+
+var ResolveDivergenceReq_Action_name = map[int32]string{
+	0: "Rollback",
+	1: "Rename",
+}
+var ResolveDivergenceReq_Action_value = map[string]int32{
+	"Rollback": 0,
+	"Rename":   1,
+}
+
+func (x ResolveDivergenceReq_Action) String() string {
+	return proto.EnumName(ResolveDivergenceReq_Action_name, int32(x))
+}
+func (ResolveDivergenceReq_Action) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{23, 0}
 }
 
 type ListFilesystemReq struct {
@@ -51,7 +127,7 @@ func (m *ListFilesystemReq) Reset()         { *m = ListFilesystemReq{} }
 func (m *ListFilesystemReq) String() string { return proto.CompactTextString(m) }
 func (*ListFilesystemReq) ProtoMessage()    {}
 func (*ListFilesystemReq) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pdu_fe566e6b212fcf8d, []int{0}
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{0}
 }
 func (m *ListFilesystemReq) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_ListFilesystemReq.Unmarshal(m, b)
@@ -82,7 +158,7 @@ func (m *ListFilesystemRes) Reset()         { *m = ListFilesystemRes{} }
 func (m *ListFilesystemRes) String() string { return proto.CompactTextString(m) }
 func (*ListFilesystemRes) ProtoMessage()    {}
 func (*ListFilesystemRes) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pdu_fe566e6b212fcf8d, []int{1}
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{1}
 }
 func (m *ListFilesystemRes) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_ListFilesystemRes.Unmarshal(m, b)
@@ -110,8 +186,14 @@ func (m *ListFilesystemRes) GetFilesystems() []*Filesystem {
 }
 
 type Filesystem struct {
-	Path                 string   `protobuf:"bytes,1,opt,name=Path,proto3" json:"Path,omitempty"`
-	ResumeToken          string   `protobuf:"bytes,2,opt,name=ResumeToken,proto3" json:"ResumeToken,omitempty"`
+	Path string `protobuf:"bytes,1,opt,name=Path,proto3" json:"Path,omitempty"`
+	// Set by a receiver that receives resumably (zfs recv -s) if a receive of the filesystem was interrupted.
+	ResumeToken string `protobuf:"bytes,2,opt,name=ResumeToken,proto3" json:"ResumeToken,omitempty"`
+	// The snapshots the interrupted stream was sent from and to, as encoded in ResumeToken.
+	// ResumeTokenFromGUID is 0 for a full stream.
+	// ResumeTokenToGUID is 0 if the receiver cannot decode the token.
+	ResumeTokenFromGUID  uint64   `protobuf:"varint,3,opt,name=ResumeTokenFromGUID,proto3" json:"ResumeTokenFromGUID,omitempty"`
+	ResumeTokenToGUID    uint64   `protobuf:"varint,4,opt,name=ResumeTokenToGUID,proto3" json:"ResumeTokenToGUID,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -121,7 +203,7 @@ func (m *Filesystem) Reset()         { *m = Filesystem{} }
 func (m *Filesystem) String() string { return proto.CompactTextString(m) }
 func (*Filesystem) ProtoMessage()    {}
 func (*Filesystem) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pdu_fe566e6b212fcf8d, []int{2}
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{2}
 }
 func (m *Filesystem) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_Filesystem.Unmarshal(m, b)
@@ -155,6 +237,20 @@ func (m *Filesystem) GetResumeToken() string {
 	return ""
 }
 
+func (m *Filesystem) GetResumeTokenFromGUID() uint64 {
+	if m != nil {
+		return m.ResumeTokenFromGUID
+	}
+	return 0
+}
+
+func (m *Filesystem) GetResumeTokenToGUID() uint64 {
+	if m != nil {
+		return m.ResumeTokenToGUID
+	}
+	return 0
+}
+
 type ListFilesystemVersionsReq struct {
 	Filesystem           string   `protobuf:"bytes,1,opt,name=Filesystem,proto3" json:"Filesystem,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -166,7 +262,7 @@ func (m *ListFilesystemVersionsReq) Reset()         { *m = ListFilesystemVersion
 func (m *ListFilesystemVersionsReq) String() string { return proto.CompactTextString(m) }
 func (*ListFilesystemVersionsReq) ProtoMessage()    {}
 func (*ListFilesystemVersionsReq) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pdu_fe566e6b212fcf8d, []int{3}
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{3}
 }
 func (m *ListFilesystemVersionsReq) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_ListFilesystemVersionsReq.Unmarshal(m, b)
@@ -204,7 +300,7 @@ func (m *ListFilesystemVersionsRes) Reset()         { *m = ListFilesystemVersion
 func (m *ListFilesystemVersionsRes) String() string { return proto.CompactTextString(m) }
 func (*ListFilesystemVersionsRes) ProtoMessage()    {}
 func (*ListFilesystemVersionsRes) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pdu_fe566e6b212fcf8d, []int{4}
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{4}
 }
 func (m *ListFilesystemVersionsRes) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_ListFilesystemVersionsRes.Unmarshal(m, b)
@@ -246,7 +342,7 @@ func (m *FilesystemVersion) Reset()         { *m = FilesystemVersion{} }
 func (m *FilesystemVersion) String() string { return proto.CompactTextString(m) }
 func (*FilesystemVersion) ProtoMessage()    {}
 func (*FilesystemVersion) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pdu_fe566e6b212fcf8d, []int{5}
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{5}
 }
 func (m *FilesystemVersion) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_FilesystemVersion.Unmarshal(m, b)
@@ -313,10 +409,20 @@ type SendReq struct {
 	// If ResumeToken is not empty, the GUIDs of From and To
 	// MUST correspond to those encoded in the ResumeToken.
 	// Otherwise, the Sender MUST return an error.
-	ResumeToken          string   `protobuf:"bytes,4,opt,name=ResumeToken,proto3" json:"ResumeToken,omitempty"`
-	Compress             bool     `protobuf:"varint,5,opt,name=Compress,proto3" json:"Compress,omitempty"`
-	Dedup                bool     `protobuf:"varint,6,opt,name=Dedup,proto3" json:"Dedup,omitempty"`
-	DryRun               bool     `protobuf:"varint,7,opt,name=DryRun,proto3" json:"DryRun,omitempty"`
+	ResumeToken string `protobuf:"bytes,4,opt,name=ResumeToken,proto3" json:"ResumeToken,omitempty"`
+	// zfs send -c: send blocks compressed as they are stored on disk
+	Compress bool `protobuf:"varint,5,opt,name=Compress,proto3" json:"Compress,omitempty"`
+	Dedup    bool `protobuf:"varint,6,opt,name=Dedup,proto3" json:"Dedup,omitempty"`
+	DryRun   bool `protobuf:"varint,7,opt,name=DryRun,proto3" json:"DryRun,omitempty"`
+	// If true, the sender sends encrypted filesystems without decrypting them (zfs send -w).
+	Raw bool `protobuf:"varint,8,opt,name=Raw,proto3" json:"Raw,omitempty"`
+	// If true, the sender MUST refuse to send filesystems that are not encrypted.
+	// Implies Raw.
+	Encrypted bool `protobuf:"varint,9,opt,name=Encrypted,proto3" json:"Encrypted,omitempty"`
+	// zfs send -L: allow blocks larger than 128KiB
+	LargeBlocks bool `protobuf:"varint,10,opt,name=LargeBlocks,proto3" json:"LargeBlocks,omitempty"`
+	// zfs send -e: send WRITE_EMBEDDED records as such
+	EmbeddedData         bool     `protobuf:"varint,11,opt,name=EmbeddedData,proto3" json:"EmbeddedData,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -326,7 +432,7 @@ func (m *SendReq) Reset()         { *m = SendReq{} }
 func (m *SendReq) String() string { return proto.CompactTextString(m) }
 func (*SendReq) ProtoMessage()    {}
 func (*SendReq) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pdu_fe566e6b212fcf8d, []int{6}
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{6}
 }
 func (m *SendReq) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_SendReq.Unmarshal(m, b)
@@ -395,6 +501,34 @@ func (m *SendReq) GetDryRun() bool {
 	return false
 }
 
+func (m *SendReq) GetRaw() bool {
+	if m != nil {
+		return m.Raw
+	}
+	return false
+}
+
+func (m *SendReq) GetEncrypted() bool {
+	if m != nil {
+		return m.Encrypted
+	}
+	return false
+}
+
+func (m *SendReq) GetLargeBlocks() bool {
+	if m != nil {
+		return m.LargeBlocks
+	}
+	return false
+}
+
+func (m *SendReq) GetEmbeddedData() bool {
+	if m != nil {
+		return m.EmbeddedData
+	}
+	return false
+}
+
 type Property struct {
 	Name                 string   `protobuf:"bytes,1,opt,name=Name,proto3" json:"Name,omitempty"`
 	Value                string   `protobuf:"bytes,2,opt,name=Value,proto3" json:"Value,omitempty"`
@@ -407,7 +541,7 @@ func (m *Property) Reset()         { *m = Property{} }
 func (m *Property) String() string { return proto.CompactTextString(m) }
 func (*Property) ProtoMessage()    {}
 func (*Property) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pdu_fe566e6b212fcf8d, []int{7}
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{7}
 }
 func (m *Property) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_Property.Unmarshal(m, b)
@@ -457,7 +591,7 @@ func (m *SendRes) Reset()         { *m = SendRes{} }
 func (m *SendRes) String() string { return proto.CompactTextString(m) }
 func (*SendRes) ProtoMessage()    {}
 func (*SendRes) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pdu_fe566e6b212fcf8d, []int{8}
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{8}
 }
 func (m *SendRes) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_SendRes.Unmarshal(m, b)
@@ -501,7 +635,25 @@ func (m *SendRes) GetProperties() []*Property {
 type ReceiveReq struct {
 	Filesystem string `protobuf:"bytes,1,opt,name=Filesystem,proto3" json:"Filesystem,omitempty"`
 	// If true, the receiver should clear the resume token before perfoming the zfs recv of the stream in the request
-	ClearResumeToken     bool     `protobuf:"varint,2,opt,name=ClearResumeToken,proto3" json:"ClearResumeToken,omitempty"`
+	ClearResumeToken bool `protobuf:"varint,2,opt,name=ClearResumeToken,proto3" json:"ClearResumeToken,omitempty"`
+	// True if the stream was produced by a raw send.
+	// The receiver MUST refuse non-raw streams if the target filesystem is encrypted.
+	Raw bool `protobuf:"varint,3,opt,name=Raw,proto3" json:"Raw,omitempty"`
+	// Features of the stream, see SendReq.
+	// The receiver MUST refuse the stream if the target pool does not support one of them.
+	Compressed   bool `protobuf:"varint,4,opt,name=Compressed,proto3" json:"Compressed,omitempty"`
+	LargeBlocks  bool `protobuf:"varint,5,opt,name=LargeBlocks,proto3" json:"LargeBlocks,omitempty"`
+	EmbeddedData bool `protobuf:"varint,6,opt,name=EmbeddedData,proto3" json:"EmbeddedData,omitempty"`
+	// Properties to set (zfs recv -o) and to inherit (zfs recv -x) on the received filesystem.
+	// Only the receiving side may specify them: they are refused if the request comes in via RPC.
+	OverrideProperties []*Property `protobuf:"bytes,7,rep,name=OverrideProperties,proto3" json:"OverrideProperties,omitempty"`
+	InheritProperties  []string    `protobuf:"bytes,8,rep,name=InheritProperties,proto3" json:"InheritProperties,omitempty"`
+	// The name of the snapshot the stream ends in, without the filesystem and the '@'.
+	// Empty if sent by an older version.
+	ToSnapshot string `protobuf:"bytes,9,opt,name=ToSnapshot,proto3" json:"ToSnapshot,omitempty"`
+	// Size estimate of the stream in bytes, see SendRes.ExpectedSize, 0 if unknown.
+	// The receiver compares it against its quota before receiving the stream.
+	ExpectedSize         int64    `protobuf:"varint,10,opt,name=ExpectedSize,proto3" json:"ExpectedSize,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -511,7 +663,7 @@ func (m *ReceiveReq) Reset()         { *m = ReceiveReq{} }
 func (m *ReceiveReq) String() string { return proto.CompactTextString(m) }
 func (*ReceiveReq) ProtoMessage()    {}
 func (*ReceiveReq) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pdu_fe566e6b212fcf8d, []int{9}
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{9}
 }
 func (m *ReceiveReq) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_ReceiveReq.Unmarshal(m, b)
@@ -545,6 +697,62 @@ func (m *ReceiveReq) GetClearResumeToken() bool {
 	return false
 }
 
+func (m *ReceiveReq) GetRaw() bool {
+	if m != nil {
+		return m.Raw
+	}
+	return false
+}
+
+func (m *ReceiveReq) GetCompressed() bool {
+	if m != nil {
+		return m.Compressed
+	}
+	return false
+}
+
+func (m *ReceiveReq) GetLargeBlocks() bool {
+	if m != nil {
+		return m.LargeBlocks
+	}
+	return false
+}
+
+func (m *ReceiveReq) GetEmbeddedData() bool {
+	if m != nil {
+		return m.EmbeddedData
+	}
+	return false
+}
+
+func (m *ReceiveReq) GetOverrideProperties() []*Property {
+	if m != nil {
+		return m.OverrideProperties
+	}
+	return nil
+}
+
+func (m *ReceiveReq) GetInheritProperties() []string {
+	if m != nil {
+		return m.InheritProperties
+	}
+	return nil
+}
+
+func (m *ReceiveReq) GetToSnapshot() string {
+	if m != nil {
+		return m.ToSnapshot
+	}
+	return ""
+}
+
+func (m *ReceiveReq) GetExpectedSize() int64 {
+	if m != nil {
+		return m.ExpectedSize
+	}
+	return 0
+}
+
 type ReceiveRes struct {
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
@@ -555,7 +763,7 @@ func (m *ReceiveRes) Reset()         { *m = ReceiveRes{} }
 func (m *ReceiveRes) String() string { return proto.CompactTextString(m) }
 func (*ReceiveRes) ProtoMessage()    {}
 func (*ReceiveRes) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pdu_fe566e6b212fcf8d, []int{10}
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{10}
 }
 func (m *ReceiveRes) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_ReceiveRes.Unmarshal(m, b)
@@ -578,17 +786,21 @@ var xxx_messageInfo_ReceiveRes proto.InternalMessageInfo
 type DestroySnapshotsReq struct {
 	Filesystem string `protobuf:"bytes,1,opt,name=Filesystem,proto3" json:"Filesystem,omitempty"`
 	// Path to filesystem, snapshot or bookmark to be destroyed
-	Snapshots            []*FilesystemVersion `protobuf:"bytes,2,rep,name=Snapshots,proto3" json:"Snapshots,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
-	XXX_unrecognized     []byte               `json:"-"`
-	XXX_sizecache        int32                `json:"-"`
+	Snapshots []*FilesystemVersion `protobuf:"bytes,2,rep,name=Snapshots,proto3" json:"Snapshots,omitempty"`
+	// Guid of the snapshot at the sender's replication cursor, which the next incremental replication builds upon.
+	// The receiving side refuses to destroy its snapshot with this guid, 0 if unknown,
+	// and its newest snapshot regardless of CursorGuid.
+	CursorGuid           uint64   `protobuf:"varint,3,opt,name=CursorGuid,proto3" json:"CursorGuid,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *DestroySnapshotsReq) Reset()         { *m = DestroySnapshotsReq{} }
 func (m *DestroySnapshotsReq) String() string { return proto.CompactTextString(m) }
 func (*DestroySnapshotsReq) ProtoMessage()    {}
 func (*DestroySnapshotsReq) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pdu_fe566e6b212fcf8d, []int{11}
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{11}
 }
 func (m *DestroySnapshotsReq) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_DestroySnapshotsReq.Unmarshal(m, b)
@@ -622,19 +834,27 @@ func (m *DestroySnapshotsReq) GetSnapshots() []*FilesystemVersion {
 	return nil
 }
 
+func (m *DestroySnapshotsReq) GetCursorGuid() uint64 {
+	if m != nil {
+		return m.CursorGuid
+	}
+	return 0
+}
+
 type DestroySnapshotRes struct {
-	Snapshot             *FilesystemVersion `protobuf:"bytes,1,opt,name=Snapshot,proto3" json:"Snapshot,omitempty"`
-	Error                string             `protobuf:"bytes,2,opt,name=Error,proto3" json:"Error,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}           `json:"-"`
-	XXX_unrecognized     []byte             `json:"-"`
-	XXX_sizecache        int32              `json:"-"`
+	Snapshot *FilesystemVersion `protobuf:"bytes,1,opt,name=Snapshot,proto3" json:"Snapshot,omitempty"`
+	// Not set if the snapshot was destroyed
+	Error                *Error   `protobuf:"bytes,2,opt,name=Error,proto3" json:"Error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *DestroySnapshotRes) Reset()         { *m = DestroySnapshotRes{} }
 func (m *DestroySnapshotRes) String() string { return proto.CompactTextString(m) }
 func (*DestroySnapshotRes) ProtoMessage()    {}
 func (*DestroySnapshotRes) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pdu_fe566e6b212fcf8d, []int{12}
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{12}
 }
 func (m *DestroySnapshotRes) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_DestroySnapshotRes.Unmarshal(m, b)
@@ -661,11 +881,11 @@ func (m *DestroySnapshotRes) GetSnapshot() *FilesystemVersion {
 	return nil
 }
 
-func (m *DestroySnapshotRes) GetError() string {
+func (m *DestroySnapshotRes) GetError() *Error {
 	if m != nil {
 		return m.Error
 	}
-	return ""
+	return nil
 }
 
 type DestroySnapshotsRes struct {
@@ -679,7 +899,7 @@ func (m *DestroySnapshotsRes) Reset()         { *m = DestroySnapshotsRes{} }
 func (m *DestroySnapshotsRes) String() string { return proto.CompactTextString(m) }
 func (*DestroySnapshotsRes) ProtoMessage()    {}
 func (*DestroySnapshotsRes) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pdu_fe566e6b212fcf8d, []int{13}
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{13}
 }
 func (m *DestroySnapshotsRes) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_DestroySnapshotsRes.Unmarshal(m, b)
@@ -706,6 +926,157 @@ func (m *DestroySnapshotsRes) GetResults() []*DestroySnapshotRes {
 	return nil
 }
 
+// The asynchronous variant of DestroySnapshots takes a DestroySnapshotsReq
+// and returns the ID of the destroy operation, which is then polled until it is Done.
+type DestroySnapshotsSubmitRes struct {
+	ID                   uint64   `protobuf:"varint,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DestroySnapshotsSubmitRes) Reset()         { *m = DestroySnapshotsSubmitRes{} }
+func (m *DestroySnapshotsSubmitRes) String() string { return proto.CompactTextString(m) }
+func (*DestroySnapshotsSubmitRes) ProtoMessage()    {}
+func (*DestroySnapshotsSubmitRes) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{14}
+}
+func (m *DestroySnapshotsSubmitRes) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DestroySnapshotsSubmitRes.Unmarshal(m, b)
+}
+func (m *DestroySnapshotsSubmitRes) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DestroySnapshotsSubmitRes.Marshal(b, m, deterministic)
+}
+func (dst *DestroySnapshotsSubmitRes) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DestroySnapshotsSubmitRes.Merge(dst, src)
+}
+func (m *DestroySnapshotsSubmitRes) XXX_Size() int {
+	return xxx_messageInfo_DestroySnapshotsSubmitRes.Size(m)
+}
+func (m *DestroySnapshotsSubmitRes) XXX_DiscardUnknown() {
+	xxx_messageInfo_DestroySnapshotsSubmitRes.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DestroySnapshotsSubmitRes proto.InternalMessageInfo
+
+func (m *DestroySnapshotsSubmitRes) GetID() uint64 {
+	if m != nil {
+		return m.ID
+	}
+	return 0
+}
+
+type DestroySnapshotsPollReq struct {
+	ID                   uint64   `protobuf:"varint,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DestroySnapshotsPollReq) Reset()         { *m = DestroySnapshotsPollReq{} }
+func (m *DestroySnapshotsPollReq) String() string { return proto.CompactTextString(m) }
+func (*DestroySnapshotsPollReq) ProtoMessage()    {}
+func (*DestroySnapshotsPollReq) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{15}
+}
+func (m *DestroySnapshotsPollReq) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DestroySnapshotsPollReq.Unmarshal(m, b)
+}
+func (m *DestroySnapshotsPollReq) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DestroySnapshotsPollReq.Marshal(b, m, deterministic)
+}
+func (dst *DestroySnapshotsPollReq) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DestroySnapshotsPollReq.Merge(dst, src)
+}
+func (m *DestroySnapshotsPollReq) XXX_Size() int {
+	return xxx_messageInfo_DestroySnapshotsPollReq.Size(m)
+}
+func (m *DestroySnapshotsPollReq) XXX_DiscardUnknown() {
+	xxx_messageInfo_DestroySnapshotsPollReq.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DestroySnapshotsPollReq proto.InternalMessageInfo
+
+func (m *DestroySnapshotsPollReq) GetID() uint64 {
+	if m != nil {
+		return m.ID
+	}
+	return 0
+}
+
+type DestroySnapshotsPollRes struct {
+	Done bool `protobuf:"varint,1,opt,name=Done,proto3" json:"Done,omitempty"`
+	// Number of snapshots processed so far, out of Total
+	Destroyed uint32 `protobuf:"varint,2,opt,name=Destroyed,proto3" json:"Destroyed,omitempty"`
+	Total     uint32 `protobuf:"varint,3,opt,name=Total,proto3" json:"Total,omitempty"`
+	// Set if Done
+	Result *DestroySnapshotsRes `protobuf:"bytes,4,opt,name=Result,proto3" json:"Result,omitempty"`
+	// Set if Done and the operation failed as a whole
+	Error                *Error   `protobuf:"bytes,5,opt,name=Error,proto3" json:"Error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DestroySnapshotsPollRes) Reset()         { *m = DestroySnapshotsPollRes{} }
+func (m *DestroySnapshotsPollRes) String() string { return proto.CompactTextString(m) }
+func (*DestroySnapshotsPollRes) ProtoMessage()    {}
+func (*DestroySnapshotsPollRes) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{16}
+}
+func (m *DestroySnapshotsPollRes) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DestroySnapshotsPollRes.Unmarshal(m, b)
+}
+func (m *DestroySnapshotsPollRes) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DestroySnapshotsPollRes.Marshal(b, m, deterministic)
+}
+func (dst *DestroySnapshotsPollRes) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DestroySnapshotsPollRes.Merge(dst, src)
+}
+func (m *DestroySnapshotsPollRes) XXX_Size() int {
+	return xxx_messageInfo_DestroySnapshotsPollRes.Size(m)
+}
+func (m *DestroySnapshotsPollRes) XXX_DiscardUnknown() {
+	xxx_messageInfo_DestroySnapshotsPollRes.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DestroySnapshotsPollRes proto.InternalMessageInfo
+
+func (m *DestroySnapshotsPollRes) GetDone() bool {
+	if m != nil {
+		return m.Done
+	}
+	return false
+}
+
+func (m *DestroySnapshotsPollRes) GetDestroyed() uint32 {
+	if m != nil {
+		return m.Destroyed
+	}
+	return 0
+}
+
+func (m *DestroySnapshotsPollRes) GetTotal() uint32 {
+	if m != nil {
+		return m.Total
+	}
+	return 0
+}
+
+func (m *DestroySnapshotsPollRes) GetResult() *DestroySnapshotsRes {
+	if m != nil {
+		return m.Result
+	}
+	return nil
+}
+
+func (m *DestroySnapshotsPollRes) GetError() *Error {
+	if m != nil {
+		return m.Error
+	}
+	return nil
+}
+
 type ReplicationCursorReq struct {
 	Filesystem string `protobuf:"bytes,1,opt,name=Filesystem,proto3" json:"Filesystem,omitempty"`
 	// Types that are valid to be assigned to Op:
@@ -721,7 +1092,7 @@ func (m *ReplicationCursorReq) Reset()         { *m = ReplicationCursorReq{} }
 func (m *ReplicationCursorReq) String() string { return proto.CompactTextString(m) }
 func (*ReplicationCursorReq) ProtoMessage()    {}
 func (*ReplicationCursorReq) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pdu_fe566e6b212fcf8d, []int{14}
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{17}
 }
 func (m *ReplicationCursorReq) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_ReplicationCursorReq.Unmarshal(m, b)
@@ -869,7 +1240,7 @@ func (m *ReplicationCursorReq_GetOp) Reset()         { *m = ReplicationCursorReq
 func (m *ReplicationCursorReq_GetOp) String() string { return proto.CompactTextString(m) }
 func (*ReplicationCursorReq_GetOp) ProtoMessage()    {}
 func (*ReplicationCursorReq_GetOp) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pdu_fe566e6b212fcf8d, []int{14, 0}
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{17, 0}
 }
 func (m *ReplicationCursorReq_GetOp) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_ReplicationCursorReq_GetOp.Unmarshal(m, b)
@@ -900,7 +1271,7 @@ func (m *ReplicationCursorReq_SetOp) Reset()         { *m = ReplicationCursorReq
 func (m *ReplicationCursorReq_SetOp) String() string { return proto.CompactTextString(m) }
 func (*ReplicationCursorReq_SetOp) ProtoMessage()    {}
 func (*ReplicationCursorReq_SetOp) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pdu_fe566e6b212fcf8d, []int{14, 1}
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{17, 1}
 }
 func (m *ReplicationCursorReq_SetOp) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_ReplicationCursorReq_SetOp.Unmarshal(m, b)
@@ -941,7 +1312,7 @@ func (m *ReplicationCursorRes) Reset()         { *m = ReplicationCursorRes{} }
 func (m *ReplicationCursorRes) String() string { return proto.CompactTextString(m) }
 func (*ReplicationCursorRes) ProtoMessage()    {}
 func (*ReplicationCursorRes) Descriptor() ([]byte, []int) {
-	return fileDescriptor_pdu_fe566e6b212fcf8d, []int{15}
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{18}
 }
 func (m *ReplicationCursorRes) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_ReplicationCursorRes.Unmarshal(m, b)
@@ -1066,72 +1437,816 @@ func _ReplicationCursorRes_OneofSizer(msg proto.Message) (n int) {
 	return n
 }
 
-func init() {
-	proto.RegisterType((*ListFilesystemReq)(nil), "pdu.ListFilesystemReq")
-	proto.RegisterType((*ListFilesystemRes)(nil), "pdu.ListFilesystemRes")
-	proto.RegisterType((*Filesystem)(nil), "pdu.Filesystem")
-	proto.RegisterType((*ListFilesystemVersionsReq)(nil), "pdu.ListFilesystemVersionsReq")
-	proto.RegisterType((*ListFilesystemVersionsRes)(nil), "pdu.ListFilesystemVersionsRes")
-	proto.RegisterType((*FilesystemVersion)(nil), "pdu.FilesystemVersion")
-	proto.RegisterType((*SendReq)(nil), "pdu.SendReq")
-	proto.RegisterType((*Property)(nil), "pdu.Property")
-	proto.RegisterType((*SendRes)(nil), "pdu.SendRes")
-	proto.RegisterType((*ReceiveReq)(nil), "pdu.ReceiveReq")
-	proto.RegisterType((*ReceiveRes)(nil), "pdu.ReceiveRes")
-	proto.RegisterType((*DestroySnapshotsReq)(nil), "pdu.DestroySnapshotsReq")
-	proto.RegisterType((*DestroySnapshotRes)(nil), "pdu.DestroySnapshotRes")
-	proto.RegisterType((*DestroySnapshotsRes)(nil), "pdu.DestroySnapshotsRes")
-	proto.RegisterType((*ReplicationCursorReq)(nil), "pdu.ReplicationCursorReq")
-	proto.RegisterType((*ReplicationCursorReq_GetOp)(nil), "pdu.ReplicationCursorReq.GetOp")
-	proto.RegisterType((*ReplicationCursorReq_SetOp)(nil), "pdu.ReplicationCursorReq.SetOp")
-	proto.RegisterType((*ReplicationCursorRes)(nil), "pdu.ReplicationCursorRes")
-	proto.RegisterEnum("pdu.FilesystemVersion_VersionType", FilesystemVersion_VersionType_name, FilesystemVersion_VersionType_value)
+type BookmarkReq struct {
+	Filesystem string `protobuf:"bytes,1,opt,name=Filesystem,proto3" json:"Filesystem,omitempty"`
+	// Name of the snapshot to be bookmarked, without @.
+	// The bookmark is created with the same name.
+	Snapshot             string   `protobuf:"bytes,2,opt,name=Snapshot,proto3" json:"Snapshot,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BookmarkReq) Reset()         { *m = BookmarkReq{} }
+func (m *BookmarkReq) String() string { return proto.CompactTextString(m) }
+func (*BookmarkReq) ProtoMessage()    {}
+func (*BookmarkReq) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{19}
+}
+func (m *BookmarkReq) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BookmarkReq.Unmarshal(m, b)
+}
+func (m *BookmarkReq) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BookmarkReq.Marshal(b, m, deterministic)
+}
+func (dst *BookmarkReq) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BookmarkReq.Merge(dst, src)
+}
+func (m *BookmarkReq) XXX_Size() int {
+	return xxx_messageInfo_BookmarkReq.Size(m)
+}
+func (m *BookmarkReq) XXX_DiscardUnknown() {
+	xxx_messageInfo_BookmarkReq.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BookmarkReq proto.InternalMessageInfo
+
+func (m *BookmarkReq) GetFilesystem() string {
+	if m != nil {
+		return m.Filesystem
+	}
+	return ""
+}
+
+func (m *BookmarkReq) GetSnapshot() string {
+	if m != nil {
+		return m.Snapshot
+	}
+	return ""
+}
+
+type BookmarkRes struct {
+	Guid                 uint64   `protobuf:"varint,1,opt,name=Guid,proto3" json:"Guid,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BookmarkRes) Reset()         { *m = BookmarkRes{} }
+func (m *BookmarkRes) String() string { return proto.CompactTextString(m) }
+func (*BookmarkRes) ProtoMessage()    {}
+func (*BookmarkRes) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{20}
+}
+func (m *BookmarkRes) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BookmarkRes.Unmarshal(m, b)
+}
+func (m *BookmarkRes) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BookmarkRes.Marshal(b, m, deterministic)
+}
+func (dst *BookmarkRes) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BookmarkRes.Merge(dst, src)
+}
+func (m *BookmarkRes) XXX_Size() int {
+	return xxx_messageInfo_BookmarkRes.Size(m)
+}
+func (m *BookmarkRes) XXX_DiscardUnknown() {
+	xxx_messageInfo_BookmarkRes.DiscardUnknown(m)
 }
 
-func init() { proto.RegisterFile("pdu.proto", fileDescriptor_pdu_fe566e6b212fcf8d) }
-
-var fileDescriptor_pdu_fe566e6b212fcf8d = []byte{
-	// 659 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x55, 0xdb, 0x6e, 0x13, 0x31,
-	0x10, 0xcd, 0xe6, 0xba, 0x99, 0x94, 0x5e, 0xdc, 0xaa, 0x2c, 0x15, 0x82, 0xc8, 0xbc, 0x04, 0x24,
-	0x22, 0x91, 0x56, 0xbc, 0xf0, 0x96, 0xde, 0xf2, 0x80, 0xda, 0xca, 0x09, 0x55, 0x9f, 0x90, 0x42,
-	0x77, 0x44, 0x57, 0xb9, 0x78, 0x6b, 0x7b, 0x51, 0xc3, 0x07, 0xf0, 0x4f, 0xfc, 0x07, 0x0f, 0x7c,
-	0x0e, 0xf2, 0xec, 0x25, 0xdb, 0x24, 0x54, 0x79, 0x8a, 0xcf, 0xf8, 0x78, 0xe6, 0xcc, 0xf1, 0x8e,
-	0x03, 0xf5, 0xd0, 0x8f, 0xda, 0xa1, 0x92, 0x46, 0xb2, 0x52, 0xe8, 0x47, 0x7c, 0x17, 0x76, 0x3e,
-	0x07, 0xda, 0x9c, 0x05, 0x63, 0xd4, 0x33, 0x6d, 0x70, 0x22, 0xf0, 0x9e, 0x9f, 0x2d, 0x07, 0x35,
-	0xfb, 0x00, 0x8d, 0x79, 0x40, 0x7b, 0x4e, 0xb3, 0xd4, 0x6a, 0x74, 0xb6, 0xda, 0x36, 0x5f, 0x8e,
-	0x98, 0xe7, 0xf0, 0x2e, 0xc0, 0x1c, 0x32, 0x06, 0xe5, 0xab, 0xa1, 0xb9, 0xf3, 0x9c, 0xa6, 0xd3,
-	0xaa, 0x0b, 0x5a, 0xb3, 0x26, 0x34, 0x04, 0xea, 0x68, 0x82, 0x03, 0x39, 0xc2, 0xa9, 0x57, 0xa4,
-	0xad, 0x7c, 0x88, 0x7f, 0x82, 0x17, 0x8f, 0xb5, 0x5c, 0xa3, 0xd2, 0x81, 0x9c, 0x6a, 0x81, 0xf7,
-	0xec, 0x55, 0xbe, 0x40, 0x92, 0x38, 0x17, 0xe1, 0x97, 0xff, 0x3f, 0xac, 0x59, 0x07, 0xdc, 0x14,
-	0x26, 0xdd, 0xec, 0x2f, 0x74, 0x93, 0x6c, 0x8b, 0x8c, 0xc7, 0xff, 0x3a, 0xb0, 0xb3, 0xb4, 0xcf,
-	0x3e, 0x42, 0x79, 0x30, 0x0b, 0x91, 0x04, 0x6c, 0x76, 0xf8, 0xea, 0x2c, 0xed, 0xe4, 0xd7, 0x32,
-	0x05, 0xf1, 0xad, 0x23, 0x17, 0xc3, 0x09, 0x26, 0x6d, 0xd3, 0xda, 0xc6, 0xce, 0xa3, 0xc0, 0xf7,
-	0x4a, 0x4d, 0xa7, 0x55, 0x16, 0xb4, 0x66, 0x2f, 0xa1, 0x7e, 0xac, 0x70, 0x68, 0x70, 0x70, 0x73,
-	0xee, 0x95, 0x69, 0x63, 0x1e, 0x60, 0x07, 0xe0, 0x12, 0x08, 0xe4, 0xd4, 0xab, 0x50, 0xa6, 0x0c,
-	0xf3, 0xb7, 0xd0, 0xc8, 0x95, 0x65, 0x1b, 0xe0, 0xf6, 0xa7, 0xc3, 0x50, 0xdf, 0x49, 0xb3, 0x5d,
-	0xb0, 0xa8, 0x2b, 0xe5, 0x68, 0x32, 0x54, 0xa3, 0x6d, 0x87, 0xff, 0x76, 0xa0, 0xd6, 0xc7, 0xa9,
-	0xbf, 0x86, 0xaf, 0x56, 0xe4, 0x99, 0x92, 0x93, 0x54, 0xb8, 0x5d, 0xb3, 0x4d, 0x28, 0x0e, 0x24,
-	0xc9, 0xae, 0x8b, 0xe2, 0x40, 0x2e, 0x5e, 0x6d, 0x79, 0xe9, 0x6a, 0x49, 0xb8, 0x9c, 0x84, 0x0a,
-	0xb5, 0x26, 0xe1, 0xae, 0xc8, 0x30, 0xdb, 0x83, 0xca, 0x09, 0xfa, 0x51, 0xe8, 0x55, 0x69, 0x23,
-	0x06, 0x6c, 0x1f, 0xaa, 0x27, 0x6a, 0x26, 0xa2, 0xa9, 0x57, 0xa3, 0x70, 0x82, 0xf8, 0x11, 0xb8,
-	0x57, 0x4a, 0x86, 0xa8, 0xcc, 0x2c, 0x33, 0xd5, 0xc9, 0x99, 0xba, 0x07, 0x95, 0xeb, 0xe1, 0x38,
-	0x4a, 0x9d, 0x8e, 0x01, 0xff, 0x95, 0x75, 0xac, 0x59, 0x0b, 0xb6, 0xbe, 0x68, 0xf4, 0xf3, 0x8a,
-	0x1d, 0x2a, 0xb1, 0x18, 0x66, 0x1c, 0x36, 0x4e, 0x1f, 0x42, 0xbc, 0x35, 0xe8, 0xf7, 0x83, 0x9f,
-	0x71, 0xca, 0x92, 0x78, 0x14, 0x63, 0xef, 0x01, 0x12, 0x3d, 0x01, 0x6a, 0xaf, 0x44, 0x1f, 0xd7,
-	0x33, 0xfa, 0x2c, 0x52, 0x99, 0x22, 0x47, 0xe0, 0x37, 0x00, 0x02, 0x6f, 0x31, 0xf8, 0x81, 0xeb,
-	0x98, 0xff, 0x0e, 0xb6, 0x8f, 0xc7, 0x38, 0x54, 0x8b, 0x83, 0xe3, 0x8a, 0xa5, 0x38, 0xdf, 0xc8,
-	0x65, 0xd6, 0x7c, 0x04, 0xbb, 0x27, 0xa8, 0x8d, 0x92, 0xb3, 0xf4, 0x2b, 0x58, 0x67, 0x8a, 0xd8,
-	0x11, 0xd4, 0x33, 0xbe, 0x57, 0x7c, 0x72, 0x52, 0xe6, 0x44, 0xfe, 0x15, 0xd8, 0x42, 0xb1, 0x64,
-	0xe8, 0x52, 0x48, 0x95, 0x9e, 0x18, 0xba, 0x94, 0x67, 0x6f, 0xef, 0x54, 0x29, 0xa9, 0xd2, 0xdb,
-	0x23, 0xc0, 0x7b, 0xab, 0x9a, 0xb1, 0xcf, 0x54, 0xcd, 0x1a, 0x30, 0x36, 0xe9, 0x50, 0x3f, 0xa7,
-	0xfc, 0xcb, 0x52, 0x44, 0xca, 0xe3, 0x7f, 0x1c, 0xd8, 0x13, 0x18, 0x8e, 0x83, 0x5b, 0x1a, 0x9a,
-	0xe3, 0x48, 0x69, 0xa9, 0xd6, 0x31, 0xe6, 0x10, 0x4a, 0xdf, 0xd1, 0x90, 0xac, 0x46, 0xe7, 0x35,
-	0xd5, 0x59, 0x95, 0xa7, 0x7d, 0x8e, 0xe6, 0x32, 0xec, 0x15, 0x84, 0x65, 0xdb, 0x43, 0x1a, 0x0d,
-	0x0d, 0xca, 0x93, 0x87, 0xfa, 0xe9, 0x21, 0x8d, 0xe6, 0xa0, 0x06, 0x15, 0x4a, 0x72, 0xf0, 0x06,
-	0x2a, 0xb4, 0x61, 0x87, 0x27, 0x33, 0x32, 0xf6, 0x25, 0xc3, 0xdd, 0x32, 0x14, 0x65, 0xc8, 0x07,
-	0x2b, 0xbb, 0xb2, 0xa3, 0x15, 0xbf, 0x30, 0xb6, 0x9f, 0x72, 0xaf, 0x90, 0xbd, 0x31, 0xee, 0x85,
-	0x34, 0xf8, 0x10, 0xe8, 0x38, 0x9f, 0xdb, 0x2b, 0x88, 0x2c, 0xd2, 0x75, 0xa1, 0x1a, 0xbb, 0xf5,
-	0xad, 0x4a, 0x7f, 0x1e, 0x87, 0xff, 0x02, 0x00, 0x00, 0xff, 0xff, 0x66, 0x74, 0x36, 0x3a, 0x49,
-	0x06, 0x00, 0x00,
+var xxx_messageInfo_BookmarkRes proto.InternalMessageInfo
+
+func (m *BookmarkRes) GetGuid() uint64 {
+	if m != nil {
+		return m.Guid
+	}
+	return 0
+}
+
+// SetStepHoldsReq makes Snapshots the only snapshots of Filesystem that have the hold Tag,
+// so that they cannot be destroyed while a multi-step replication still needs them.
+// An empty list releases all holds with Tag.
+// An empty Filesystem and list release the step holds with Tag on all filesystems that the sender no longer exposes,
+// Tag must then start with zrepl_step_.
+type SetStepHoldsReq struct {
+	Filesystem string `protobuf:"bytes,1,opt,name=Filesystem,proto3" json:"Filesystem,omitempty"`
+	Tag        string `protobuf:"bytes,2,opt,name=Tag,proto3" json:"Tag,omitempty"`
+	// Names of the snapshots, without @.
+	Snapshots            []string `protobuf:"bytes,3,rep,name=Snapshots,proto3" json:"Snapshots,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SetStepHoldsReq) Reset()         { *m = SetStepHoldsReq{} }
+func (m *SetStepHoldsReq) String() string { return proto.CompactTextString(m) }
+func (*SetStepHoldsReq) ProtoMessage()    {}
+func (*SetStepHoldsReq) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{21}
+}
+func (m *SetStepHoldsReq) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SetStepHoldsReq.Unmarshal(m, b)
+}
+func (m *SetStepHoldsReq) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SetStepHoldsReq.Marshal(b, m, deterministic)
+}
+func (dst *SetStepHoldsReq) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SetStepHoldsReq.Merge(dst, src)
+}
+func (m *SetStepHoldsReq) XXX_Size() int {
+	return xxx_messageInfo_SetStepHoldsReq.Size(m)
+}
+func (m *SetStepHoldsReq) XXX_DiscardUnknown() {
+	xxx_messageInfo_SetStepHoldsReq.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SetStepHoldsReq proto.InternalMessageInfo
+
+func (m *SetStepHoldsReq) GetFilesystem() string {
+	if m != nil {
+		return m.Filesystem
+	}
+	return ""
+}
+
+func (m *SetStepHoldsReq) GetTag() string {
+	if m != nil {
+		return m.Tag
+	}
+	return ""
+}
+
+func (m *SetStepHoldsReq) GetSnapshots() []string {
+	if m != nil {
+		return m.Snapshots
+	}
+	return nil
+}
+
+type SetStepHoldsRes struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SetStepHoldsRes) Reset()         { *m = SetStepHoldsRes{} }
+func (m *SetStepHoldsRes) String() string { return proto.CompactTextString(m) }
+func (*SetStepHoldsRes) ProtoMessage()    {}
+func (*SetStepHoldsRes) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{22}
+}
+func (m *SetStepHoldsRes) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SetStepHoldsRes.Unmarshal(m, b)
+}
+func (m *SetStepHoldsRes) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SetStepHoldsRes.Marshal(b, m, deterministic)
+}
+func (dst *SetStepHoldsRes) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SetStepHoldsRes.Merge(dst, src)
+}
+func (m *SetStepHoldsRes) XXX_Size() int {
+	return xxx_messageInfo_SetStepHoldsRes.Size(m)
+}
+func (m *SetStepHoldsRes) XXX_DiscardUnknown() {
+	xxx_messageInfo_SetStepHoldsRes.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SetStepHoldsRes proto.InternalMessageInfo
+
+type ResolveDivergenceReq struct {
+	Filesystem string                      `protobuf:"bytes,1,opt,name=Filesystem,proto3" json:"Filesystem,omitempty"`
+	Action     ResolveDivergenceReq_Action `protobuf:"varint,2,opt,name=Action,proto3,enum=pdu.ResolveDivergenceReq_Action" json:"Action,omitempty"`
+	// The receiver's most recent snapshot that is also present on the sender.
+	CommonAncestor *FilesystemVersion `protobuf:"bytes,3,opt,name=CommonAncestor,proto3" json:"CommonAncestor,omitempty"`
+	// The receiver's versions after CommonAncestor, as known to the planner.
+	// The receiver MUST refuse to roll back if it has other versions after CommonAncestor.
+	Diverged             []*FilesystemVersion `protobuf:"bytes,4,rep,name=Diverged,proto3" json:"Diverged,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
+	XXX_unrecognized     []byte               `json:"-"`
+	XXX_sizecache        int32                `json:"-"`
+}
+
+func (m *ResolveDivergenceReq) Reset()         { *m = ResolveDivergenceReq{} }
+func (m *ResolveDivergenceReq) String() string { return proto.CompactTextString(m) }
+func (*ResolveDivergenceReq) ProtoMessage()    {}
+func (*ResolveDivergenceReq) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{23}
+}
+func (m *ResolveDivergenceReq) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ResolveDivergenceReq.Unmarshal(m, b)
+}
+func (m *ResolveDivergenceReq) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ResolveDivergenceReq.Marshal(b, m, deterministic)
+}
+func (dst *ResolveDivergenceReq) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ResolveDivergenceReq.Merge(dst, src)
+}
+func (m *ResolveDivergenceReq) XXX_Size() int {
+	return xxx_messageInfo_ResolveDivergenceReq.Size(m)
+}
+func (m *ResolveDivergenceReq) XXX_DiscardUnknown() {
+	xxx_messageInfo_ResolveDivergenceReq.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ResolveDivergenceReq proto.InternalMessageInfo
+
+func (m *ResolveDivergenceReq) GetFilesystem() string {
+	if m != nil {
+		return m.Filesystem
+	}
+	return ""
+}
+
+func (m *ResolveDivergenceReq) GetAction() ResolveDivergenceReq_Action {
+	if m != nil {
+		return m.Action
+	}
+	return ResolveDivergenceReq_Rollback
+}
+
+func (m *ResolveDivergenceReq) GetCommonAncestor() *FilesystemVersion {
+	if m != nil {
+		return m.CommonAncestor
+	}
+	return nil
+}
+
+func (m *ResolveDivergenceReq) GetDiverged() []*FilesystemVersion {
+	if m != nil {
+		return m.Diverged
+	}
+	return nil
+}
+
+type ResolveDivergenceRes struct {
+	// For Action Rename, the receiver's name of the filesystem the diverged one was renamed to.
+	RenamedTo            string   `protobuf:"bytes,1,opt,name=RenamedTo,proto3" json:"RenamedTo,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ResolveDivergenceRes) Reset()         { *m = ResolveDivergenceRes{} }
+func (m *ResolveDivergenceRes) String() string { return proto.CompactTextString(m) }
+func (*ResolveDivergenceRes) ProtoMessage()    {}
+func (*ResolveDivergenceRes) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{24}
+}
+func (m *ResolveDivergenceRes) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ResolveDivergenceRes.Unmarshal(m, b)
+}
+func (m *ResolveDivergenceRes) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ResolveDivergenceRes.Marshal(b, m, deterministic)
+}
+func (dst *ResolveDivergenceRes) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ResolveDivergenceRes.Merge(dst, src)
+}
+func (m *ResolveDivergenceRes) XXX_Size() int {
+	return xxx_messageInfo_ResolveDivergenceRes.Size(m)
+}
+func (m *ResolveDivergenceRes) XXX_DiscardUnknown() {
+	xxx_messageInfo_ResolveDivergenceRes.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ResolveDivergenceRes proto.InternalMessageInfo
+
+func (m *ResolveDivergenceRes) GetRenamedTo() string {
+	if m != nil {
+		return m.RenamedTo
+	}
+	return ""
+}
+
+type GetPropertiesReq struct {
+	Filesystem           string   `protobuf:"bytes,1,opt,name=Filesystem,proto3" json:"Filesystem,omitempty"`
+	Names                []string `protobuf:"bytes,2,rep,name=Names,proto3" json:"Names,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetPropertiesReq) Reset()         { *m = GetPropertiesReq{} }
+func (m *GetPropertiesReq) String() string { return proto.CompactTextString(m) }
+func (*GetPropertiesReq) ProtoMessage()    {}
+func (*GetPropertiesReq) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{25}
+}
+func (m *GetPropertiesReq) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetPropertiesReq.Unmarshal(m, b)
+}
+func (m *GetPropertiesReq) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetPropertiesReq.Marshal(b, m, deterministic)
+}
+func (dst *GetPropertiesReq) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetPropertiesReq.Merge(dst, src)
+}
+func (m *GetPropertiesReq) XXX_Size() int {
+	return xxx_messageInfo_GetPropertiesReq.Size(m)
+}
+func (m *GetPropertiesReq) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetPropertiesReq.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetPropertiesReq proto.InternalMessageInfo
+
+func (m *GetPropertiesReq) GetFilesystem() string {
+	if m != nil {
+		return m.Filesystem
+	}
+	return ""
+}
+
+func (m *GetPropertiesReq) GetNames() []string {
+	if m != nil {
+		return m.Names
+	}
+	return nil
+}
+
+type GetPropertiesRes struct {
+	// Properties of GetPropertiesReq.Names that are set on the filesystem, locally or by zfs recv.
+	Set []*Property `protobuf:"bytes,1,rep,name=Set,proto3" json:"Set,omitempty"`
+	// The remaining properties of GetPropertiesReq.Names, which are inherited or have their default value.
+	Inherited            []string `protobuf:"bytes,2,rep,name=Inherited,proto3" json:"Inherited,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetPropertiesRes) Reset()         { *m = GetPropertiesRes{} }
+func (m *GetPropertiesRes) String() string { return proto.CompactTextString(m) }
+func (*GetPropertiesRes) ProtoMessage()    {}
+func (*GetPropertiesRes) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{26}
+}
+func (m *GetPropertiesRes) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetPropertiesRes.Unmarshal(m, b)
+}
+func (m *GetPropertiesRes) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetPropertiesRes.Marshal(b, m, deterministic)
+}
+func (dst *GetPropertiesRes) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetPropertiesRes.Merge(dst, src)
+}
+func (m *GetPropertiesRes) XXX_Size() int {
+	return xxx_messageInfo_GetPropertiesRes.Size(m)
+}
+func (m *GetPropertiesRes) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetPropertiesRes.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetPropertiesRes proto.InternalMessageInfo
+
+func (m *GetPropertiesRes) GetSet() []*Property {
+	if m != nil {
+		return m.Set
+	}
+	return nil
+}
+
+func (m *GetPropertiesRes) GetInherited() []string {
+	if m != nil {
+		return m.Inherited
+	}
+	return nil
+}
+
+type SetPropertiesReq struct {
+	Filesystem           string      `protobuf:"bytes,1,opt,name=Filesystem,proto3" json:"Filesystem,omitempty"`
+	Set                  []*Property `protobuf:"bytes,2,rep,name=Set,proto3" json:"Set,omitempty"`
+	Inherit              []string    `protobuf:"bytes,3,rep,name=Inherit,proto3" json:"Inherit,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *SetPropertiesReq) Reset()         { *m = SetPropertiesReq{} }
+func (m *SetPropertiesReq) String() string { return proto.CompactTextString(m) }
+func (*SetPropertiesReq) ProtoMessage()    {}
+func (*SetPropertiesReq) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{27}
+}
+func (m *SetPropertiesReq) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SetPropertiesReq.Unmarshal(m, b)
+}
+func (m *SetPropertiesReq) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SetPropertiesReq.Marshal(b, m, deterministic)
+}
+func (dst *SetPropertiesReq) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SetPropertiesReq.Merge(dst, src)
+}
+func (m *SetPropertiesReq) XXX_Size() int {
+	return xxx_messageInfo_SetPropertiesReq.Size(m)
+}
+func (m *SetPropertiesReq) XXX_DiscardUnknown() {
+	xxx_messageInfo_SetPropertiesReq.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SetPropertiesReq proto.InternalMessageInfo
+
+func (m *SetPropertiesReq) GetFilesystem() string {
+	if m != nil {
+		return m.Filesystem
+	}
+	return ""
+}
+
+func (m *SetPropertiesReq) GetSet() []*Property {
+	if m != nil {
+		return m.Set
+	}
+	return nil
+}
+
+func (m *SetPropertiesReq) GetInherit() []string {
+	if m != nil {
+		return m.Inherit
+	}
+	return nil
+}
+
+type SetPropertiesRes struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SetPropertiesRes) Reset()         { *m = SetPropertiesRes{} }
+func (m *SetPropertiesRes) String() string { return proto.CompactTextString(m) }
+func (*SetPropertiesRes) ProtoMessage()    {}
+func (*SetPropertiesRes) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{28}
+}
+func (m *SetPropertiesRes) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SetPropertiesRes.Unmarshal(m, b)
+}
+func (m *SetPropertiesRes) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SetPropertiesRes.Marshal(b, m, deterministic)
+}
+func (dst *SetPropertiesRes) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SetPropertiesRes.Merge(dst, src)
+}
+func (m *SetPropertiesRes) XXX_Size() int {
+	return xxx_messageInfo_SetPropertiesRes.Size(m)
+}
+func (m *SetPropertiesRes) XXX_DiscardUnknown() {
+	xxx_messageInfo_SetPropertiesRes.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SetPropertiesRes proto.InternalMessageInfo
+
+type PingReq struct {
+	Message string `protobuf:"bytes,1,opt,name=Message,proto3" json:"Message,omitempty"`
+	// If non-zero, the response carries a stream of ReplyStreamLength bytes.
+	ReplyStreamLength    uint64   `protobuf:"varint,2,opt,name=ReplyStreamLength,proto3" json:"ReplyStreamLength,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PingReq) Reset()         { *m = PingReq{} }
+func (m *PingReq) String() string { return proto.CompactTextString(m) }
+func (*PingReq) ProtoMessage()    {}
+func (*PingReq) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{29}
+}
+func (m *PingReq) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PingReq.Unmarshal(m, b)
+}
+func (m *PingReq) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PingReq.Marshal(b, m, deterministic)
+}
+func (dst *PingReq) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PingReq.Merge(dst, src)
+}
+func (m *PingReq) XXX_Size() int {
+	return xxx_messageInfo_PingReq.Size(m)
+}
+func (m *PingReq) XXX_DiscardUnknown() {
+	xxx_messageInfo_PingReq.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PingReq proto.InternalMessageInfo
+
+func (m *PingReq) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *PingReq) GetReplyStreamLength() uint64 {
+	if m != nil {
+		return m.ReplyStreamLength
+	}
+	return 0
+}
+
+type PingRes struct {
+	// Echoes PingReq.Message
+	Message string `protobuf:"bytes,1,opt,name=Message,proto3" json:"Message,omitempty"`
+	// Number of bytes read from the request stream
+	ReceivedStreamLength uint64   `protobuf:"varint,2,opt,name=ReceivedStreamLength,proto3" json:"ReceivedStreamLength,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PingRes) Reset()         { *m = PingRes{} }
+func (m *PingRes) String() string { return proto.CompactTextString(m) }
+func (*PingRes) ProtoMessage()    {}
+func (*PingRes) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{30}
+}
+func (m *PingRes) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PingRes.Unmarshal(m, b)
+}
+func (m *PingRes) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PingRes.Marshal(b, m, deterministic)
+}
+func (dst *PingRes) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PingRes.Merge(dst, src)
+}
+func (m *PingRes) XXX_Size() int {
+	return xxx_messageInfo_PingRes.Size(m)
+}
+func (m *PingRes) XXX_DiscardUnknown() {
+	xxx_messageInfo_PingRes.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PingRes proto.InternalMessageInfo
+
+func (m *PingRes) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *PingRes) GetReceivedStreamLength() uint64 {
+	if m != nil {
+		return m.ReceivedStreamLength
+	}
+	return 0
+}
+
+type Error struct {
+	Code                 ErrorCode `protobuf:"varint,1,opt,name=Code,proto3,enum=pdu.ErrorCode" json:"Code,omitempty"`
+	Message              string    `protobuf:"bytes,2,opt,name=Message,proto3" json:"Message,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *Error) Reset()         { *m = Error{} }
+func (m *Error) String() string { return proto.CompactTextString(m) }
+func (*Error) ProtoMessage()    {}
+func (*Error) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{31}
+}
+func (m *Error) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Error.Unmarshal(m, b)
+}
+func (m *Error) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Error.Marshal(b, m, deterministic)
+}
+func (dst *Error) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Error.Merge(dst, src)
+}
+func (m *Error) XXX_Size() int {
+	return xxx_messageInfo_Error.Size(m)
+}
+func (m *Error) XXX_DiscardUnknown() {
+	xxx_messageInfo_Error.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Error proto.InternalMessageInfo
+
+func (m *Error) GetCode() ErrorCode {
+	if m != nil {
+		return m.Code
+	}
+	return ErrorCode_Internal
+}
+
+func (m *Error) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+// Dry-run SendReqs, usually the steps of one filesystem, answered with a single reply
+// instead of one Send request per step.
+type SendEstimatesReq struct {
+	Steps                []*SendReq `protobuf:"bytes,1,rep,name=Steps,proto3" json:"Steps,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *SendEstimatesReq) Reset()         { *m = SendEstimatesReq{} }
+func (m *SendEstimatesReq) String() string { return proto.CompactTextString(m) }
+func (*SendEstimatesReq) ProtoMessage()    {}
+func (*SendEstimatesReq) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{32}
+}
+func (m *SendEstimatesReq) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SendEstimatesReq.Unmarshal(m, b)
+}
+func (m *SendEstimatesReq) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SendEstimatesReq.Marshal(b, m, deterministic)
+}
+func (dst *SendEstimatesReq) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SendEstimatesReq.Merge(dst, src)
+}
+func (m *SendEstimatesReq) XXX_Size() int {
+	return xxx_messageInfo_SendEstimatesReq.Size(m)
+}
+func (m *SendEstimatesReq) XXX_DiscardUnknown() {
+	xxx_messageInfo_SendEstimatesReq.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SendEstimatesReq proto.InternalMessageInfo
+
+func (m *SendEstimatesReq) GetSteps() []*SendReq {
+	if m != nil {
+		return m.Steps
+	}
+	return nil
+}
+
+type SendEstimatesRes struct {
+	// SendRes.ExpectedSize of each step, in the order of SendEstimatesReq.Steps
+	ExpectedSizes        []int64  `protobuf:"varint,1,rep,packed,name=ExpectedSizes,proto3" json:"ExpectedSizes,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SendEstimatesRes) Reset()         { *m = SendEstimatesRes{} }
+func (m *SendEstimatesRes) String() string { return proto.CompactTextString(m) }
+func (*SendEstimatesRes) ProtoMessage()    {}
+func (*SendEstimatesRes) Descriptor() ([]byte, []int) {
+	return fileDescriptor_pdu_a62a7a3947ad8e18, []int{33}
+}
+func (m *SendEstimatesRes) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SendEstimatesRes.Unmarshal(m, b)
+}
+func (m *SendEstimatesRes) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SendEstimatesRes.Marshal(b, m, deterministic)
+}
+func (dst *SendEstimatesRes) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SendEstimatesRes.Merge(dst, src)
+}
+func (m *SendEstimatesRes) XXX_Size() int {
+	return xxx_messageInfo_SendEstimatesRes.Size(m)
+}
+func (m *SendEstimatesRes) XXX_DiscardUnknown() {
+	xxx_messageInfo_SendEstimatesRes.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SendEstimatesRes proto.InternalMessageInfo
+
+func (m *SendEstimatesRes) GetExpectedSizes() []int64 {
+	if m != nil {
+		return m.ExpectedSizes
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*ListFilesystemReq)(nil), "pdu.ListFilesystemReq")
+	proto.RegisterType((*ListFilesystemRes)(nil), "pdu.ListFilesystemRes")
+	proto.RegisterType((*Filesystem)(nil), "pdu.Filesystem")
+	proto.RegisterType((*ListFilesystemVersionsReq)(nil), "pdu.ListFilesystemVersionsReq")
+	proto.RegisterType((*ListFilesystemVersionsRes)(nil), "pdu.ListFilesystemVersionsRes")
+	proto.RegisterType((*FilesystemVersion)(nil), "pdu.FilesystemVersion")
+	proto.RegisterType((*SendReq)(nil), "pdu.SendReq")
+	proto.RegisterType((*Property)(nil), "pdu.Property")
+	proto.RegisterType((*SendRes)(nil), "pdu.SendRes")
+	proto.RegisterType((*ReceiveReq)(nil), "pdu.ReceiveReq")
+	proto.RegisterType((*ReceiveRes)(nil), "pdu.ReceiveRes")
+	proto.RegisterType((*DestroySnapshotsReq)(nil), "pdu.DestroySnapshotsReq")
+	proto.RegisterType((*DestroySnapshotRes)(nil), "pdu.DestroySnapshotRes")
+	proto.RegisterType((*DestroySnapshotsRes)(nil), "pdu.DestroySnapshotsRes")
+	proto.RegisterType((*DestroySnapshotsSubmitRes)(nil), "pdu.DestroySnapshotsSubmitRes")
+	proto.RegisterType((*DestroySnapshotsPollReq)(nil), "pdu.DestroySnapshotsPollReq")
+	proto.RegisterType((*DestroySnapshotsPollRes)(nil), "pdu.DestroySnapshotsPollRes")
+	proto.RegisterType((*ReplicationCursorReq)(nil), "pdu.ReplicationCursorReq")
+	proto.RegisterType((*ReplicationCursorReq_GetOp)(nil), "pdu.ReplicationCursorReq.GetOp")
+	proto.RegisterType((*ReplicationCursorReq_SetOp)(nil), "pdu.ReplicationCursorReq.SetOp")
+	proto.RegisterType((*ReplicationCursorRes)(nil), "pdu.ReplicationCursorRes")
+	proto.RegisterType((*BookmarkReq)(nil), "pdu.BookmarkReq")
+	proto.RegisterType((*BookmarkRes)(nil), "pdu.BookmarkRes")
+	proto.RegisterType((*SetStepHoldsReq)(nil), "pdu.SetStepHoldsReq")
+	proto.RegisterType((*SetStepHoldsRes)(nil), "pdu.SetStepHoldsRes")
+	proto.RegisterType((*ResolveDivergenceReq)(nil), "pdu.ResolveDivergenceReq")
+	proto.RegisterType((*ResolveDivergenceRes)(nil), "pdu.ResolveDivergenceRes")
+	proto.RegisterType((*GetPropertiesReq)(nil), "pdu.GetPropertiesReq")
+	proto.RegisterType((*GetPropertiesRes)(nil), "pdu.GetPropertiesRes")
+	proto.RegisterType((*SetPropertiesReq)(nil), "pdu.SetPropertiesReq")
+	proto.RegisterType((*SetPropertiesRes)(nil), "pdu.SetPropertiesRes")
+	proto.RegisterType((*PingReq)(nil), "pdu.PingReq")
+	proto.RegisterType((*PingRes)(nil), "pdu.PingRes")
+	proto.RegisterType((*Error)(nil), "pdu.Error")
+	proto.RegisterType((*SendEstimatesReq)(nil), "pdu.SendEstimatesReq")
+	proto.RegisterType((*SendEstimatesRes)(nil), "pdu.SendEstimatesRes")
+	proto.RegisterEnum("pdu.ErrorCode", ErrorCode_name, ErrorCode_value)
+	proto.RegisterEnum("pdu.FilesystemVersion_VersionType", FilesystemVersion_VersionType_name, FilesystemVersion_VersionType_value)
+	proto.RegisterEnum("pdu.ResolveDivergenceReq_Action", ResolveDivergenceReq_Action_name, ResolveDivergenceReq_Action_value)
+}
+
+func init() { proto.RegisterFile("pdu.proto", fileDescriptor_pdu_a62a7a3947ad8e18) }
+
+var fileDescriptor_pdu_a62a7a3947ad8e18 = []byte{
+	// 1393 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x03, 0x95, 0x57, 0x4b, 0x73, 0xdc, 0x44,
+	0x10, 0x8e, 0x56, 0xfb, 0xec, 0xf5, 0x43, 0x1e, 0xa7, 0x92, 0x4d, 0x8a, 0x22, 0x66, 0xe0, 0x10,
+	0x02, 0xb8, 0xc0, 0x71, 0xa5, 0x52, 0x45, 0x41, 0x55, 0xec, 0xf5, 0xab, 0xca, 0x24, 0xce, 0xec,
+	0x26, 0x70, 0x95, 0x57, 0x53, 0x6b, 0x61, 0x49, 0x23, 0x34, 0x5a, 0x93, 0xe5, 0xc6, 0x85, 0xe2,
+	0x67, 0x50, 0xfc, 0x01, 0x4e, 0xfc, 0x13, 0x0e, 0xfc, 0x1c, 0x7a, 0x46, 0x4f, 0x4b, 0x6b, 0x67,
+	0x39, 0x69, 0xfa, 0x31, 0x3d, 0x3d, 0xdd, 0x3d, 0x5f, 0xb7, 0xa0, 0x17, 0x3a, 0xb3, 0xed, 0x30,
+	0x12, 0xb1, 0x20, 0x26, 0x2e, 0xe9, 0x26, 0x6c, 0x9c, 0xba, 0x32, 0x3e, 0x74, 0x3d, 0x2e, 0xe7,
+	0x32, 0xe6, 0x3e, 0xe3, 0x3f, 0xd1, 0xc3, 0x3a, 0x53, 0x92, 0xaf, 0xa0, 0x5f, 0x30, 0xe4, 0xc0,
+	0xd8, 0x32, 0x1f, 0xf7, 0x77, 0xd6, 0xb7, 0x95, 0xbd, 0x92, 0x62, 0x59, 0x87, 0xfe, 0x69, 0x00,
+	0x14, 0x34, 0x21, 0xd0, 0x3c, 0xb3, 0xe3, 0x0b, 0xdc, 0x6a, 0x3c, 0xee, 0x31, 0xbd, 0x26, 0x5b,
+	0xd0, 0x47, 0xe3, 0x33, 0x9f, 0x8f, 0xc5, 0x25, 0x0f, 0x06, 0x0d, 0x2d, 0x2a, 0xb3, 0xc8, 0x97,
+	0xb0, 0x59, 0x22, 0x0f, 0x23, 0xe1, 0x1f, 0xbd, 0x39, 0x19, 0x0e, 0x4c, 0xd4, 0x6c, 0xb2, 0x45,
+	0x22, 0xf2, 0x39, 0x6c, 0x94, 0xd8, 0x63, 0xa1, 0xf5, 0x9b, 0x5a, 0xbf, 0x2e, 0xa0, 0x5f, 0xc3,
+	0x83, 0xeb, 0x97, 0x7d, 0xcb, 0x23, 0xe9, 0x8a, 0x40, 0x62, 0x24, 0xc8, 0x87, 0xe5, 0x0b, 0xa4,
+	0x8e, 0x97, 0x38, 0xf4, 0xd5, 0xcd, 0x9b, 0x25, 0xd9, 0x81, 0x6e, 0x46, 0xa6, 0xe1, 0xba, 0x57,
+	0x09, 0x57, 0x2a, 0x66, 0xb9, 0x1e, 0xfd, 0xd7, 0x80, 0x8d, 0x9a, 0x9c, 0x3c, 0x83, 0xe6, 0x78,
+	0x1e, 0x72, 0xed, 0xc0, 0xda, 0x0e, 0x5d, 0x6c, 0x65, 0x3b, 0xfd, 0x2a, 0x4d, 0xa6, 0xf5, 0x55,
+	0xc4, 0x5f, 0xda, 0x3e, 0x4f, 0xc3, 0xaa, 0xd7, 0x8a, 0x77, 0x34, 0x73, 0x9d, 0x34, 0x80, 0x7a,
+	0x4d, 0x3e, 0x80, 0xde, 0x7e, 0xc4, 0xed, 0x98, 0x8f, 0x7f, 0x38, 0x4a, 0x23, 0x55, 0x30, 0xc8,
+	0x43, 0xe8, 0x6a, 0x02, 0x6d, 0x0f, 0x5a, 0xda, 0x52, 0x4e, 0xd3, 0x4f, 0xa1, 0x5f, 0x3a, 0x96,
+	0xac, 0x40, 0x77, 0x14, 0xd8, 0xa1, 0xbc, 0x10, 0xb1, 0x75, 0x47, 0x51, 0x7b, 0x42, 0x5c, 0xfa,
+	0x76, 0x74, 0x69, 0x19, 0xf4, 0xaf, 0x06, 0x74, 0x46, 0x3c, 0x70, 0x96, 0x88, 0xab, 0x72, 0x52,
+	0xa5, 0x33, 0x73, 0x5c, 0xad, 0xc9, 0x1a, 0x34, 0xc6, 0x42, 0xbb, 0xdd, 0x63, 0xb8, 0xaa, 0x96,
+	0x4e, 0xb3, 0x5e, 0x3a, 0xca, 0x71, 0xe1, 0x87, 0x11, 0x97, 0x52, 0x3b, 0xde, 0x65, 0x39, 0x4d,
+	0xee, 0x42, 0x6b, 0xc8, 0x9d, 0x59, 0x38, 0x68, 0x6b, 0x41, 0x42, 0x90, 0x7b, 0xd0, 0x1e, 0x46,
+	0x73, 0x36, 0x0b, 0x06, 0x1d, 0xcd, 0x4e, 0x29, 0x62, 0x81, 0xc9, 0xec, 0x9f, 0x07, 0x5d, 0xcd,
+	0x54, 0x4b, 0x15, 0xb2, 0x83, 0x60, 0x12, 0xcd, 0xc3, 0x98, 0x3b, 0x83, 0x9e, 0xe6, 0x17, 0x0c,
+	0xe5, 0xdb, 0xa9, 0x1d, 0x4d, 0xf9, 0x9e, 0x27, 0x26, 0x97, 0x72, 0x00, 0x5a, 0x5e, 0x66, 0x11,
+	0x0a, 0x2b, 0x07, 0xfe, 0x39, 0x77, 0x1c, 0xee, 0x0c, 0xed, 0xd8, 0x1e, 0xf4, 0xb5, 0xca, 0x35,
+	0x1e, 0xdd, 0x85, 0xee, 0x59, 0x24, 0x42, 0x1e, 0xc5, 0xf3, 0x3c, 0x95, 0x46, 0x29, 0x95, 0x78,
+	0x87, 0xb7, 0xb6, 0x37, 0xcb, 0xf2, 0x9b, 0x10, 0xf4, 0x37, 0x23, 0x8b, 0xb3, 0x24, 0x8f, 0x61,
+	0xfd, 0x8d, 0xe4, 0x4e, 0x39, 0x4e, 0x86, 0x3e, 0xa8, 0xca, 0xd6, 0xfe, 0xbc, 0x0b, 0xf9, 0x04,
+	0xbd, 0x1f, 0xb9, 0xbf, 0x24, 0x26, 0x4d, 0x76, 0x8d, 0x47, 0xbe, 0x00, 0x48, 0xfd, 0x71, 0xb9,
+	0xc4, 0x4c, 0xa8, 0x92, 0x5e, 0xd5, 0xc5, 0x98, 0xb9, 0xc9, 0x4a, 0x0a, 0xf4, 0x57, 0x13, 0x80,
+	0xf1, 0x09, 0x77, 0xaf, 0xf8, 0x32, 0x39, 0x7f, 0x02, 0xd6, 0xbe, 0xc7, 0xed, 0xa8, 0x8a, 0x07,
+	0x5d, 0x56, 0xe3, 0x67, 0xf9, 0x30, 0x8b, 0x7c, 0xa0, 0xf5, 0x2c, 0xb7, 0x98, 0x90, 0xa6, 0x16,
+	0x94, 0x38, 0xd5, 0x8c, 0xb4, 0xde, 0x9f, 0x91, 0x76, 0x3d, 0x23, 0xe4, 0x1b, 0x20, 0xaf, 0xae,
+	0x78, 0x14, 0xb9, 0x0e, 0x2f, 0x45, 0xa2, 0xb3, 0x28, 0x12, 0x0b, 0x14, 0x15, 0x32, 0x9d, 0x04,
+	0x17, 0x3c, 0x72, 0xe3, 0xd2, 0xee, 0x2e, 0xee, 0xee, 0xb1, 0xba, 0x40, 0x5d, 0x69, 0x2c, 0xb2,
+	0xe7, 0xa4, 0x6b, 0x0c, 0x03, 0x56, 0x70, 0x6a, 0x29, 0x83, 0x7a, 0xca, 0xe8, 0x4a, 0x29, 0x05,
+	0x92, 0x5e, 0xc2, 0xe6, 0x90, 0xcb, 0x38, 0x12, 0xf3, 0xcc, 0xc8, 0x32, 0x28, 0x47, 0x76, 0xa1,
+	0x97, 0xeb, 0x63, 0x4a, 0x6e, 0x43, 0xb2, 0x42, 0x91, 0xfe, 0x08, 0xa4, 0x72, 0x58, 0x0a, 0x8a,
+	0xf9, 0x95, 0xd4, 0x49, 0xb7, 0x80, 0x62, 0x7e, 0xd1, 0x2d, 0x68, 0x1d, 0x44, 0x91, 0x88, 0x74,
+	0x39, 0xf4, 0x77, 0x40, 0x6f, 0xd0, 0x1c, 0x96, 0x08, 0xe8, 0xf1, 0xa2, 0x8b, 0xa9, 0x9e, 0xd5,
+	0x51, 0x55, 0xe3, 0xc5, 0x19, 0x00, 0xdf, 0xd7, 0x5b, 0xeb, 0x6e, 0xb1, 0x4c, 0x8f, 0x7e, 0x06,
+	0x0f, 0xaa, 0x96, 0x46, 0xb3, 0x73, 0xdf, 0xd5, 0xce, 0x23, 0x04, 0x61, 0x2b, 0x31, 0x34, 0x40,
+	0xe2, 0x0a, 0xd1, 0xef, 0x7e, 0x55, 0xf9, 0x4c, 0x78, 0x9e, 0x8a, 0x69, 0x55, 0xf5, 0x6f, 0xe3,
+	0x26, 0x5d, 0xa9, 0xde, 0xf6, 0x50, 0x04, 0x3c, 0x7d, 0x9a, 0x7a, 0xad, 0xf0, 0x25, 0x55, 0xc7,
+	0x72, 0x56, 0xf7, 0x5e, 0x65, 0x05, 0x43, 0xbd, 0xfc, 0xb1, 0x88, 0x6d, 0x4f, 0xbf, 0x80, 0x55,
+	0x96, 0x10, 0xd8, 0x2a, 0xdb, 0xc9, 0x35, 0x74, 0xfd, 0xf7, 0x77, 0x06, 0x8b, 0x6e, 0xab, 0x02,
+	0xc3, 0x52, 0xbd, 0x22, 0xb2, 0xad, 0x9b, 0x22, 0xfb, 0x8f, 0x01, 0x77, 0x19, 0x0f, 0x3d, 0x77,
+	0xa2, 0x01, 0x7f, 0x7f, 0x16, 0x49, 0x14, 0x2e, 0x51, 0x34, 0x4f, 0xc1, 0x9c, 0xf2, 0x38, 0x4d,
+	0xd9, 0x23, 0x6d, 0x78, 0x91, 0x9d, 0xed, 0x23, 0x1e, 0xbf, 0x0a, 0x8f, 0xef, 0x30, 0xa5, 0xad,
+	0x36, 0x49, 0xdc, 0x64, 0xbe, 0x6f, 0xd3, 0x28, 0xdb, 0x84, 0xda, 0x0f, 0x3b, 0xd0, 0xd2, 0x46,
+	0x1e, 0x7e, 0x0c, 0x2d, 0x2d, 0x50, 0xc0, 0x9f, 0x17, 0x59, 0x82, 0x8d, 0x39, 0xbd, 0xd7, 0x84,
+	0x86, 0x08, 0xe9, 0x78, 0xe1, 0xad, 0x54, 0x5b, 0x48, 0xba, 0xa3, 0x4e, 0x1c, 0x1e, 0x90, 0xf5,
+	0xc7, 0xee, 0x4b, 0x11, 0xf3, 0x77, 0xd8, 0xea, 0x13, 0x48, 0x42, 0x49, 0xce, 0xd9, 0xeb, 0x66,
+	0x61, 0xa7, 0x27, 0xd0, 0xcf, 0x1a, 0xde, 0x32, 0x21, 0xba, 0xc5, 0x4d, 0xfa, 0x51, 0xd9, 0x94,
+	0xcc, 0xbb, 0xb6, 0x51, 0x74, 0x6d, 0x6a, 0xc3, 0x3a, 0x5e, 0x77, 0x14, 0xf3, 0xf0, 0x58, 0x78,
+	0xce, 0x52, 0x2f, 0x19, 0x71, 0x73, 0x6c, 0x4f, 0xd3, 0xc3, 0xd4, 0x52, 0xd5, 0x59, 0xf1, 0xb6,
+	0x4d, 0x0d, 0x45, 0xa5, 0x37, 0xbc, 0x51, 0x3d, 0x42, 0xd2, 0xdf, 0x1b, 0x2a, 0x74, 0x52, 0x78,
+	0x57, 0x7c, 0x88, 0xb0, 0x82, 0xf8, 0x19, 0x4c, 0x96, 0xc2, 0xf7, 0xe7, 0xd0, 0x7e, 0x31, 0xd1,
+	0x43, 0x44, 0x43, 0x8f, 0x31, 0x5b, 0x69, 0x7a, 0xeb, 0xa6, 0xb6, 0x13, 0x3d, 0x96, 0xea, 0x93,
+	0x6f, 0x61, 0x0d, 0x91, 0xdc, 0x17, 0xc1, 0x0b, 0x94, 0xcb, 0x18, 0xcb, 0xd5, 0xbc, 0x15, 0x39,
+	0x2a, 0xda, 0x0a, 0x73, 0x52, 0xfb, 0xaa, 0x33, 0xdc, 0x3a, 0x88, 0x65, 0x7a, 0x94, 0x66, 0xde,
+	0xaa, 0x29, 0x86, 0xe1, 0x43, 0x3d, 0xb7, 0x27, 0x97, 0x38, 0xd3, 0x80, 0x4a, 0x76, 0x80, 0xdd,
+	0x17, 0x27, 0x9a, 0xdd, 0x85, 0x91, 0x90, 0x2a, 0xa6, 0x89, 0x8e, 0x83, 0x03, 0x4b, 0x12, 0x88,
+	0x82, 0x81, 0x58, 0x65, 0x61, 0xb9, 0x16, 0x38, 0xbf, 0x4c, 0xec, 0xf0, 0xbd, 0xab, 0x8e, 0x9f,
+	0xa0, 0x2f, 0x76, 0x7a, 0x4d, 0xd0, 0xd7, 0x35, 0x4b, 0x92, 0x3c, 0x02, 0x13, 0x33, 0x96, 0xc2,
+	0x5d, 0xa5, 0x25, 0x29, 0x89, 0x72, 0x2e, 0x6d, 0x35, 0x1a, 0x58, 0x74, 0xc2, 0x73, 0x06, 0xf5,
+	0xc1, 0x1a, 0xfd, 0x5f, 0xe7, 0xd2, 0x23, 0x1b, 0x37, 0x1e, 0x39, 0x80, 0x4e, 0x7a, 0x42, 0x5a,
+	0x61, 0x19, 0x49, 0x49, 0xed, 0x38, 0x75, 0xab, 0xce, 0x99, 0x1b, 0x4c, 0xd5, 0xc9, 0xb8, 0xf1,
+	0x3b, 0x6c, 0xdf, 0xf6, 0x34, 0x9b, 0x7b, 0x32, 0x32, 0x99, 0xf1, 0x43, 0x6f, 0x3e, 0x8a, 0x71,
+	0x12, 0xf5, 0x4f, 0x79, 0x30, 0xc5, 0x1f, 0x8b, 0x46, 0x36, 0xe3, 0x57, 0x04, 0xf4, 0xfb, 0xcc,
+	0xa4, 0xbc, 0xc5, 0xe4, 0x8e, 0xca, 0xa6, 0x6e, 0x95, 0xce, 0x02, 0xab, 0x0b, 0x65, 0xf4, 0x20,
+	0xc5, 0x4f, 0xec, 0xc5, 0xcd, 0x7d, 0xe1, 0x64, 0x13, 0xfa, 0x5a, 0x81, 0xa3, 0x8a, 0xcb, 0xb4,
+	0xac, 0x7c, 0x74, 0xe3, 0xda, 0xd1, 0xf4, 0x99, 0x0a, 0x43, 0xe0, 0x1c, 0xc8, 0xd8, 0xf5, 0x71,
+	0xe8, 0xd6, 0x51, 0xa7, 0x08, 0x66, 0xf8, 0xee, 0xb2, 0xce, 0xb5, 0xa2, 0x4d, 0xa6, 0xf3, 0x33,
+	0x4b, 0x44, 0xf4, 0x79, 0x6d, 0x9f, 0x24, 0x9f, 0xc0, 0x6a, 0x79, 0x02, 0x48, 0xf6, 0x9b, 0xec,
+	0x3a, 0xf3, 0xc9, 0x1f, 0x06, 0xce, 0xaf, 0x99, 0x7f, 0xaa, 0xc4, 0x4f, 0x82, 0x98, 0x47, 0x81,
+	0xed, 0x61, 0x89, 0x6f, 0xc2, 0xfa, 0x49, 0x70, 0x65, 0x7b, 0xae, 0xf3, 0x22, 0x9a, 0xe2, 0xc8,
+	0x15, 0xc4, 0x96, 0xa1, 0x54, 0x10, 0xf0, 0x0e, 0xc5, 0x2c, 0x70, 0xac, 0x06, 0xd6, 0xa3, 0x75,
+	0xc6, 0x23, 0xdf, 0x95, 0xea, 0xd5, 0x0c, 0x79, 0xe0, 0x72, 0xc7, 0x32, 0x49, 0x17, 0x9a, 0x7b,
+	0x33, 0x39, 0xb7, 0x9a, 0x38, 0x47, 0x93, 0x53, 0x5b, 0xc6, 0xc9, 0x3b, 0xcc, 0xff, 0x08, 0x5a,
+	0x4a, 0xe3, 0x98, 0x7b, 0x8e, 0xd5, 0x26, 0xab, 0xd0, 0x3b, 0xb6, 0xe5, 0xbe, 0x87, 0xbd, 0x4e,
+	0x5a, 0x1d, 0xb2, 0x01, 0xab, 0xaf, 0x67, 0xd8, 0xc4, 0x0e, 0xde, 0x4d, 0x38, 0xc7, 0x69, 0xcb,
+	0xea, 0x9e, 0xb7, 0xf5, 0x6f, 0xea, 0xd3, 0xff, 0x00, 0xb0, 0xb3, 0xd9, 0xe3, 0xb3, 0x0e, 0x00,
+	0x00,
 }
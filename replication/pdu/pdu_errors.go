@@ -0,0 +1,77 @@
+package pdu
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+)
+
+func NewError(code ErrorCode, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Temporary is true for Busy, i.e. the operation may succeed if retried later.
+func (e *Error) Temporary() bool { return e.Code == ErrorCode_Busy }
+
+func (e *Error) Timeout() bool { return false }
+
+// Code returns the ErrorCode of err, which is Internal if neither err nor its cause is an *Error.
+func Code(err error) ErrorCode {
+	if e, ok := errors.Cause(err).(*Error); ok {
+		return e.Code
+	}
+	return ErrorCode_Internal
+}
+
+// ErrorFrom returns err as an *Error, with code Internal if neither err nor its cause is an *Error.
+// The message is that of err, including the context added by wrapping.
+func ErrorFrom(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: Code(err), Message: err.Error()}
+}
+
+// The RPC transport carries errors as plain strings, the code is encoded into them with this prefix.
+const wireErrorPrefix = "zrepl-error-code "
+
+var wireErrorRegex = regexp.MustCompile(wireErrorPrefix + `(\w+): `)
+
+type wireError struct {
+	msg string
+}
+
+func (e wireError) Error() string { return e.msg }
+
+// WireError encodes the ErrorCode of err into the message, for an RPC handler to return.
+func WireError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return wireError{fmt.Sprintf("%s%s: %s", wireErrorPrefix, Code(err), err.Error())}
+}
+
+// ErrorFromWire decodes an error returned by WireError on the other side of an RPC into an *Error.
+// Other errors, e.g. of the transport itself, are returned unchanged.
+func ErrorFromWire(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	loc := wireErrorRegex.FindStringSubmatchIndex(msg)
+	if loc == nil {
+		return err
+	}
+	code, ok := ErrorCode_value[msg[loc[2]:loc[3]]]
+	if !ok {
+		code = int32(ErrorCode_Internal)
+	}
+	// keep whatever the transport put around the message
+	return &Error{
+		Code:    ErrorCode(code),
+		Message: strings.TrimSpace(msg[:loc[0]] + msg[loc[1]:]),
+	}
+}
@@ -74,3 +74,35 @@ func (v *FilesystemVersion) ZFSFilesystemVersion() (*zfs.FilesystemVersion, erro
 		Creation:  ct,
 	}, nil
 }
+
+// StreamFeatures returns the features of the stream requested by r.
+func (r *SendReq) StreamFeatures() zfs.StreamFeatures {
+	return zfs.StreamFeatures{
+		Raw:          r.GetRaw() || r.GetEncrypted(),
+		Compressed:   r.GetCompress(),
+		LargeBlocks:  r.GetLargeBlocks(),
+		EmbeddedData: r.GetEmbeddedData(),
+	}
+}
+
+// StreamFeatures returns the features of the stream announced by r.
+func (r *ReceiveReq) StreamFeatures() zfs.StreamFeatures {
+	return zfs.StreamFeatures{
+		Raw:          r.GetRaw(),
+		Compressed:   r.GetCompressed(),
+		LargeBlocks:  r.GetLargeBlocks(),
+		EmbeddedData: r.GetEmbeddedData(),
+	}
+}
+
+// RecvProperties returns the receive property overrides requested by r.
+func (r *ReceiveReq) RecvProperties() zfs.RecvProperties {
+	p := zfs.RecvProperties{
+		Override: make(map[string]string, len(r.GetOverrideProperties())),
+		Inherit:  r.GetInheritProperties(),
+	}
+	for _, o := range r.GetOverrideProperties() {
+		p.Override[o.GetName()] = o.GetValue()
+	}
+	return p
+}
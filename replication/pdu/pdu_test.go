@@ -1,7 +1,10 @@
 package pdu
 
 import (
+	"fmt"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/zrepl/zrepl/zfs"
 	"testing"
 	"time"
 )
@@ -66,3 +69,33 @@ func TestFilesystemVersion_ZFSFilesystemVersion(t *testing.T) {
 	assert.Error(t, err)
 
 }
+
+func TestWireError(t *testing.T) {
+	orig := errors.Wrap(NewError(ErrorCode_NotFound, "dataset %q does not exist", "pool/a"), "cannot destroy")
+	assert.Equal(t, ErrorCode_NotFound, Code(orig))
+
+	// transports may add their own context to the message
+	transported := fmt.Errorf("remote error: %s", WireError(orig))
+	decoded := ErrorFromWire(transported)
+	assert.Equal(t, ErrorCode_NotFound, Code(decoded))
+	assert.Equal(t, `remote error: cannot destroy: dataset "pool/a" does not exist`, decoded.Error())
+
+	assert.Equal(t, ErrorCode_Internal, Code(ErrorFromWire(WireError(errors.New("foo")))))
+
+	plain := errors.New("connection reset")
+	assert.Equal(t, plain, ErrorFromWire(plain))
+	assert.True(t, NewError(ErrorCode_Busy, "busy").Temporary())
+	assert.False(t, NewError(ErrorCode_QuotaExceeded, "quota").Temporary())
+}
+
+func TestStreamFeatures(t *testing.T) {
+	sr := &SendReq{Compress: true, LargeBlocks: true, EmbeddedData: true}
+	assert.Equal(t, zfs.StreamFeatures{Compressed: true, LargeBlocks: true, EmbeddedData: true}, sr.StreamFeatures())
+	// encrypted sends are always raw
+	sr = &SendReq{Encrypted: true}
+	assert.Equal(t, zfs.StreamFeatures{Raw: true}, sr.StreamFeatures())
+
+	rr := &ReceiveReq{Raw: true, Compressed: true, LargeBlocks: true, EmbeddedData: true}
+	assert.Equal(t, zfs.StreamFeatures{Raw: true, Compressed: true, LargeBlocks: true, EmbeddedData: true}, rr.StreamFeatures())
+	assert.Equal(t, zfs.StreamFeatures{}, (&ReceiveReq{}).StreamFeatures())
+}
@@ -0,0 +1,95 @@
+package replication
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/zrepl/zrepl/replication/pdu"
+)
+
+// Plan is the outcome of planning a Replication, by filesystem.
+//
+// A Replication that was warm-started with the Plan of a failed previous one (see WarmStart)
+// reuses the steps and size estimates of each filesystem whose sender and receiver versions
+// are unchanged, instead of computing them again.
+type Plan struct {
+	fss map[string]*fsPlan
+}
+
+type fsPlan struct {
+	// digest of sender and receiver versions at planning time
+	digest string
+	path   []*pdu.FilesystemVersion
+	// path without the snapshots within the grace period, see trimFreshSnapshots
+	trimmed []*pdu.FilesystemVersion
+	// size estimate per step of trimmed
+	sizes []int64
+}
+
+// sameVersions returns true if a and b consist of the same versions in the same order.
+func sameVersions(a, b []*pdu.FilesystemVersion) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if (a[i] == nil) != (b[i] == nil) {
+			return false
+		}
+		if a[i] != nil && (a[i].Guid != b[i].Guid || a[i].Type != b[i].Type) {
+			return false
+		}
+	}
+	return true
+}
+
+func versionsDigest(sender, receiver []*pdu.FilesystemVersion) string {
+	h := sha256.New()
+	for _, vs := range [][]*pdu.FilesystemVersion{sender, receiver} {
+		for _, v := range vs {
+			fmt.Fprintf(h, "%s %s %d %d\n", v.Type, v.Name, v.Guid, v.CreateTXG)
+		}
+		fmt.Fprintln(h, "--")
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// resumesFirstStep returns true if the interrupted receive reported by the receiver in rfs
+// continues the first step of path.
+func resumesFirstStep(rfs *pdu.Filesystem, path []*pdu.FilesystemVersion) bool {
+	if rfs.GetResumeToken() == "" || rfs.GetResumeTokenToGUID() == 0 || len(path) == 0 {
+		return false
+	}
+	var from, to *pdu.FilesystemVersion
+	if len(path) == 1 {
+		to = path[0]
+	} else {
+		from, to = path[0], path[1] // from is nil for a full send, see resolveConflict
+	}
+	if to.Guid != rfs.ResumeTokenToGUID {
+		return false
+	}
+	if from == nil {
+		return rfs.ResumeTokenFromGUID == 0
+	}
+	return from.Guid == rfs.ResumeTokenFromGUID
+}
+
+// RemainingPlan returns the most recent Plan of r if r did not complete, nil otherwise.
+// Must only be called after Drive returned.
+func (r *Replication) RemainingPlan() *Plan {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.state == Completed || r.plan == nil {
+		return nil
+	}
+	return &Plan{r.plan}
+}
+
+// WarmStart makes r reuse plan where still applicable. plan may be nil.
+// Must be called before Drive.
+func (r *Replication) WarmStart(plan *Plan) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if plan != nil {
+		r.plan = plan.fss
+	}
+}
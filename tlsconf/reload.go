@@ -0,0 +1,159 @@
+package tlsconf
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CertReloader provides a certificate and key pair loaded from files
+// to TLS handshakes and reloads it when the files change, so that renewed
+// certificates are presented on new connections without restarting the daemon.
+// Established connections are not affected.
+//
+// A reload that fails, e.g. because only one of the files has been replaced yet,
+// keeps the previous certificate and is retried on the next handshake.
+type CertReloader struct {
+	certFile, keyFile string
+
+	mtx       sync.Mutex
+	cert      *tls.Certificate
+	certStamp fileStamp
+	keyStamp  fileStamp
+	lastCheck time.Time
+}
+
+// The files are checked for changes on handshakes, but at most once per reloadCheckInterval.
+const reloadCheckInterval = 5 * time.Second
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stampFile(path string) (fileStamp, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{fi.ModTime(), fi.Size()}, nil
+}
+
+var reloaders struct {
+	mtx      sync.Mutex
+	all      []*CertReloader
+	onReload func(certFile string, err error)
+}
+
+// SetReloadHook sets f to be called after every reload of a CertReloader
+// that was triggered by a change of its files, err is nil if the reload succeeded.
+func SetReloadHook(f func(certFile string, err error)) {
+	reloaders.mtx.Lock()
+	defer reloaders.mtx.Unlock()
+	reloaders.onReload = f
+}
+
+// ReloadAll unconditionally reloads the certificates of all CertReloaders, e.g. on SIGHUP.
+func ReloadAll() error {
+	reloaders.mtx.Lock()
+	all := append([]*CertReloader(nil), reloaders.all...)
+	reloaders.mtx.Unlock()
+	var msgs []string
+	for _, r := range all {
+		if err := r.Reload(); err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("cannot reload certificates: %s", strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// NewCertReloader loads the certificate and key pair from certFile and keyFile.
+// The reloader is registered for ReloadAll.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	reloaders.mtx.Lock()
+	reloaders.all = append(reloaders.all, r)
+	reloaders.mtx.Unlock()
+	return r, nil
+}
+
+// Reload loads the certificate and key pair from the files regardless of whether they changed.
+func (r *CertReloader) Reload() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.load()
+}
+
+// r.mtx must be held
+func (r *CertReloader) load() error {
+	r.lastCheck = time.Now()
+	// stamp before loading: if a file changes while loading, the next check loads it again
+	certStamp, err := stampFile(r.certFile)
+	if err != nil {
+		return err
+	}
+	keyStamp, err := stampFile(r.keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load cert/key pair %s %s: %s", r.certFile, r.keyFile, err)
+	}
+	r.cert = &cert
+	r.certStamp = certStamp
+	r.keyStamp = keyStamp
+	return nil
+}
+
+func (r *CertReloader) changed() bool {
+	certStamp, err := stampFile(r.certFile)
+	if err != nil {
+		return false // e.g. the file is being replaced, check again later
+	}
+	keyStamp, err := stampFile(r.keyFile)
+	if err != nil {
+		return false
+	}
+	return certStamp != r.certStamp || keyStamp != r.keyStamp
+}
+
+// Certificate returns the current certificate, reloading it first if the files changed.
+func (r *CertReloader) Certificate() *tls.Certificate {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if time.Since(r.lastCheck) < reloadCheckInterval {
+		return r.cert
+	}
+	r.lastCheck = time.Now()
+	if !r.changed() {
+		return r.cert
+	}
+	err := r.load()
+	reloaders.mtx.Lock()
+	onReload := reloaders.onReload
+	reloaders.mtx.Unlock()
+	if onReload != nil {
+		onReload(r.certFile, err)
+	}
+	return r.cert
+}
+
+// GetCertificate can be used as tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
+
+// GetClientCertificate can be used as tls.Config.GetClientCertificate.
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
@@ -0,0 +1,127 @@
+package tlsconf
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func genCertKeyPEM(t *testing.T, cn string) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
+
+func certCN(t *testing.T, c *tls.Certificate) string {
+	parsed, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Subject.CommonName
+}
+
+func writeFile(t *testing.T, path string, content []byte, mtime time.Time) {
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// file systems with coarse timestamps would not register the change otherwise
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrepl-tlsconf-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "host.crt"), filepath.Join(dir, "host.key")
+
+	var hookErrs []error
+	SetReloadHook(func(_ string, err error) { hookErrs = append(hookErrs, err) })
+	defer SetReloadHook(nil)
+
+	t0 := time.Now().Add(-time.Hour)
+	certA, keyA := genCertKeyPEM(t, "a")
+	writeFile(t, certFile, certA, t0)
+	writeFile(t, keyFile, keyA, t0)
+
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn := certCN(t, r.Certificate()); cn != "a" {
+		t.Fatalf("unexpected initial certificate %q", cn)
+	}
+
+	// only the certificate has been replaced yet: keep the old pair
+	certB, keyB := genCertKeyPEM(t, "b")
+	writeFile(t, certFile, certB, t0.Add(time.Minute))
+	r.lastCheck = time.Time{}
+	if cn := certCN(t, r.Certificate()); cn != "a" {
+		t.Fatalf("mismatched pair must not be loaded, got %q", cn)
+	}
+	if len(hookErrs) != 1 || hookErrs[0] == nil {
+		t.Fatalf("expected failed reload to be reported, got %v", hookErrs)
+	}
+
+	// the key follows
+	writeFile(t, keyFile, keyB, t0.Add(time.Minute))
+	if cn := certCN(t, r.Certificate()); cn != "a" {
+		t.Fatalf("files must not be checked before reloadCheckInterval passed, got %q", cn)
+	}
+	r.lastCheck = time.Time{}
+	if cn := certCN(t, r.Certificate()); cn != "b" {
+		t.Fatalf("expected reloaded certificate, got %q", cn)
+	}
+	if len(hookErrs) != 2 || hookErrs[1] != nil {
+		t.Fatalf("expected successful reload to be reported, got %v", hookErrs)
+	}
+
+	// unchanged files are not reloaded
+	r.lastCheck = time.Time{}
+	r.Certificate()
+	if len(hookErrs) != 2 {
+		t.Fatalf("unexpected reload of unchanged files: %v", hookErrs)
+	}
+
+	// explicit reload, e.g. on SIGHUP, does not depend on the modification time
+	certC, keyC := genCertKeyPEM(t, "c")
+	writeFile(t, certFile, certC, t0.Add(time.Minute))
+	writeFile(t, keyFile, keyC, t0.Add(time.Minute))
+	if err := ReloadAll(); err != nil {
+		t.Fatal(err)
+	}
+	if cn := certCN(t, r.Certificate()); cn != "c" {
+		t.Fatalf("expected certificate after ReloadAll, got %q", cn)
+	}
+}
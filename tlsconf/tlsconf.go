@@ -27,18 +27,18 @@ type ClientAuthListener struct {
 }
 
 func NewClientAuthListener(
-	l net.Listener, ca *x509.CertPool, serverCert tls.Certificate,
+	l net.Listener, ca *x509.CertPool, serverCert *CertReloader,
 	handshakeTimeout time.Duration) *ClientAuthListener {
 
 	if ca == nil {
 		panic(ca)
 	}
-	if serverCert.Certificate == nil || serverCert.PrivateKey == nil {
+	if serverCert == nil {
 		panic(serverCert)
 	}
 
 	tlsConf := tls.Config{
-		Certificates:             []tls.Certificate{serverCert},
+		GetCertificate:           serverCert.GetCertificate,
 		ClientCAs:                ca,
 		ClientAuth:               tls.RequireAndVerifyClientCert,
 		PreferServerCipherSuites: true,
@@ -91,21 +91,20 @@ func (l *ClientAuthListener) Close() error {
 	return l.l.Close()
 }
 
-func ClientAuthClient(serverName string, rootCA *x509.CertPool, clientCert tls.Certificate) (*tls.Config, error) {
+func ClientAuthClient(serverName string, rootCA *x509.CertPool, clientCert *CertReloader) (*tls.Config, error) {
 	if serverName == "" {
 		panic(serverName)
 	}
 	if rootCA == nil {
 		panic(rootCA)
 	}
-	if clientCert.Certificate == nil || clientCert.PrivateKey == nil {
+	if clientCert == nil {
 		panic(clientCert)
 	}
 	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{clientCert},
-		RootCAs:      rootCA,
-		ServerName:   serverName,
+		GetClientCertificate: clientCert.GetClientCertificate,
+		RootCAs:              rootCA,
+		ServerName:           serverName,
 	}
-	tlsConfig.BuildNameToCertificate()
 	return tlsConfig, nil
 }
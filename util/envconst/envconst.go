@@ -2,6 +2,7 @@ package envconst
 
 import (
 	"os"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -23,3 +24,19 @@ func Duration(varname string, def time.Duration) time.Duration {
 	cache.Store(varname, d)
 	return d
 }
+
+func Int(varname string, def int) int {
+	if v, ok := cache.Load(varname); ok {
+		return v.(int)
+	}
+	e := os.Getenv(varname)
+	if e == "" {
+		return def
+	}
+	d, err := strconv.Atoi(e)
+	if err != nil {
+		panic(err)
+	}
+	cache.Store(varname, d)
+	return d
+}
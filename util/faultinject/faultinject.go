@@ -0,0 +1,165 @@
+// Package faultinject makes operations fail deterministically, which allows operators
+// to rehearse failure handling and verify alerting in staging environments.
+//
+// Faults are configured through the environment variable ZREPL_FAULT_INJECTION,
+// a comma-separated list of rules POINT:SCHEDULE:FAULT, e.g.
+//
+//	ZREPL_FAULT_INJECTION="client.DestroySnapshots:every=3:timeout,server.Receive:nth=2:disconnect"
+//
+// POINT names the injection site, see the users of this package.
+// SCHEDULE is either every=N (every N-th call fails) or nth=N (only the N-th call fails).
+// FAULT is one of timeout, disconnect or error.
+package faultinject
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const EnvVar = "ZREPL_FAULT_INJECTION"
+
+type Fault int
+
+const (
+	// A net.Error whose Timeout() and Temporary() return true.
+	FaultTimeout Fault = 1 + iota
+	// Like FaultTimeout, but injection sites that own a connection close it.
+	FaultDisconnect
+	// A permanent error.
+	FaultError
+)
+
+var faultNames = map[string]Fault{
+	"timeout":    FaultTimeout,
+	"disconnect": FaultDisconnect,
+	"error":      FaultError,
+}
+
+type rule struct {
+	point      string
+	every, nth int
+	fault      Fault
+	calls      int
+}
+
+// Injector decides which calls fail. The zero value and nil never inject faults.
+type Injector struct {
+	mtx   sync.Mutex
+	rules []*rule
+}
+
+// Parse parses a rule list in the format of ZREPL_FAULT_INJECTION.
+func Parse(spec string) (*Injector, error) {
+	i := &Injector{}
+	for _, r := range strings.Split(spec, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		comps := strings.Split(r, ":")
+		if len(comps) != 3 {
+			return nil, fmt.Errorf("rule %q: expected POINT:SCHEDULE:FAULT", r)
+		}
+		rl := &rule{point: comps[0]}
+		sched := strings.SplitN(comps[1], "=", 2)
+		if len(sched) != 2 {
+			return nil, fmt.Errorf("rule %q: schedule must be every=N or nth=N", r)
+		}
+		n, err := strconv.Atoi(sched[1])
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("rule %q: schedule must have a positive count", r)
+		}
+		switch sched[0] {
+		case "every":
+			rl.every = n
+		case "nth":
+			rl.nth = n
+		default:
+			return nil, fmt.Errorf("rule %q: unknown schedule %q", r, sched[0])
+		}
+		var ok bool
+		if rl.fault, ok = faultNames[comps[2]]; !ok {
+			return nil, fmt.Errorf("rule %q: unknown fault %q", r, comps[2])
+		}
+		i.rules = append(i.rules, rl)
+	}
+	return i, nil
+}
+
+var fromEnv struct {
+	once sync.Once
+	i    *Injector
+}
+
+// FromEnv returns the Injector configured by ZREPL_FAULT_INJECTION, or nil if it is not set.
+// It panics if the variable cannot be parsed.
+func FromEnv() *Injector {
+	fromEnv.once.Do(func() {
+		spec := os.Getenv(EnvVar)
+		if spec == "" {
+			return
+		}
+		i, err := Parse(spec)
+		if err != nil {
+			panic(fmt.Sprintf("invalid %s: %s", EnvVar, err))
+		}
+		fromEnv.i = i
+	})
+	return fromEnv.i
+}
+
+// Inject counts a call at point and returns the error of the first rule that fires, or nil.
+func (i *Injector) Inject(point string) error {
+	if i == nil {
+		return nil
+	}
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	var fired *rule
+	for _, r := range i.rules {
+		if r.point != point {
+			continue
+		}
+		r.calls++
+		if fired == nil && ((r.every > 0 && r.calls%r.every == 0) || r.calls == r.nth) {
+			fired = r
+		}
+	}
+	if fired == nil {
+		return nil
+	}
+	return &Error{Point: point, Fault: fired.fault, Call: fired.calls}
+}
+
+// Error is returned by Inject.
+type Error struct {
+	Point string
+	Fault Fault
+	Call  int
+}
+
+func (e *Error) Error() string {
+	var what string
+	switch e.Fault {
+	case FaultTimeout:
+		what = "i/o timeout"
+	case FaultDisconnect:
+		what = "connection closed"
+	default:
+		what = "error"
+	}
+	return fmt.Sprintf("%s (injected at %s, call #%d)", what, e.Point, e.Call)
+}
+
+func (e *Error) Timeout() bool { return e.Fault != FaultError }
+
+func (e *Error) Temporary() bool { return e.Fault != FaultError }
+
+// IsDisconnect returns true if err requests the injection site to close its connection.
+func IsDisconnect(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Fault == FaultDisconnect
+}
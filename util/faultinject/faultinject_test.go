@@ -0,0 +1,50 @@
+package faultinject
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"Receive",
+		"Receive:every=3",
+		"Receive:often=3:timeout",
+		"Receive:every=0:timeout",
+		"Receive:nth=x:timeout",
+		"Receive:nth=1:explode",
+	} {
+		_, err := Parse(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestInject(t *testing.T) {
+	i, err := Parse("client.DestroySnapshots:every=3:timeout, server.Receive:nth=2:disconnect")
+	require.NoError(t, err)
+
+	var failed []int
+	for call := 1; call <= 7; call++ {
+		if err := i.Inject("client.DestroySnapshots"); err != nil {
+			failed = append(failed, call)
+			neterr, ok := err.(net.Error)
+			require.True(t, ok)
+			assert.True(t, neterr.Timeout())
+			assert.False(t, IsDisconnect(err))
+		}
+	}
+	assert.Equal(t, []int{3, 6}, failed)
+
+	assert.NoError(t, i.Inject("server.Receive"))
+	assert.True(t, IsDisconnect(i.Inject("server.Receive")))
+	assert.NoError(t, i.Inject("server.Receive"))
+
+	assert.NoError(t, i.Inject("client.Send"))
+}
+
+func TestNilInjector(t *testing.T) {
+	var i *Injector
+	assert.NoError(t, i.Inject("client.Send"))
+}
@@ -142,3 +142,13 @@ func (b *ByteCounterReader) Read(p []byte) (n int, err error) {
 func (b *ByteCounterReader) Bytes() int64 {
 	return atomic.LoadInt64(&b.bytes)
 }
+
+// ZeroReader is an infinite stream of zero bytes, e.g. for transport throughput tests.
+type ZeroReader struct{}
+
+func (ZeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
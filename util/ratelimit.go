@@ -0,0 +1,80 @@
+package util
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the combined throughput of all readers wrapped by it.
+// A nil *RateLimiter does not limit at all.
+type RateLimiter struct {
+	bytesPerSecond int64
+
+	mtx sync.Mutex
+	// time at which all previously reserved bytes will have been transferred at bytesPerSecond
+	next time.Time
+}
+
+// NewRateLimiter returns nil if bytesPerSecond <= 0.
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &RateLimiter{bytesPerSecond: bytesPerSecond}
+}
+
+// reserve accounts for n transferred bytes and returns the time at which they may be handed out.
+// Readers reserve in the order they call it, which shares the bandwidth fairly between them.
+func (l *RateLimiter) reserve(n int) time.Time {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.bytesPerSecond))
+	return l.next
+}
+
+// Wait blocks until n bytes may be transferred, or until ctx is done.
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	t := time.NewTimer(l.reserve(n).Sub(time.Now()))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+type rateLimitedReader struct {
+	ctx     context.Context
+	reader  io.ReadCloser
+	limiter *RateLimiter
+}
+
+// NewRateLimitedReader returns reader if limiter is nil.
+func NewRateLimitedReader(ctx context.Context, reader io.ReadCloser, limiter *RateLimiter) io.ReadCloser {
+	if limiter == nil {
+		return reader
+	}
+	return &rateLimitedReader{ctx, reader, limiter}
+}
+
+func (r *rateLimitedReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	if waitErr := r.limiter.Wait(r.ctx, n); waitErr != nil && err == nil {
+		err = waitErr
+	}
+	return n, err
+}
+
+func (r *rateLimitedReader) Close() error {
+	return r.reader.Close()
+}
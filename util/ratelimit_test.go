@@ -0,0 +1,46 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterNil(t *testing.T) {
+	r := ioutil.NopCloser(bytes.NewReader(nil))
+	assert.Nil(t, NewRateLimiter(0))
+	assert.Equal(t, r, NewRateLimitedReader(context.Background(), r, nil))
+}
+
+func TestRateLimiterSharedBudget(t *testing.T) {
+	const rate = 1 << 20
+	l := NewRateLimiter(rate)
+
+	// two readers share the budget: 2 x 128KiB at 1MiB/s take at least 250ms
+	begin := time.Now()
+	done := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() {
+			r := NewRateLimitedReader(context.Background(), ioutil.NopCloser(bytes.NewReader(make([]byte, rate/8))), l)
+			_, err := io.Copy(ioutil.Discard, r)
+			done <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		require.NoError(t, <-done)
+	}
+	assert.True(t, time.Since(begin) >= 240*time.Millisecond, "took %s", time.Since(begin))
+}
+
+func TestRateLimiterContextCancel(t *testing.T) {
+	l := NewRateLimiter(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := l.Wait(ctx, 1<<20)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
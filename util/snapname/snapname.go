@@ -0,0 +1,109 @@
+// Package snapname renders and parses the names of snapshots taken by zrepl.
+package snapname
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultLayout is the time layout of snapshot names configured with a plain prefix.
+const DefaultLayout = "20060102_150405_000"
+
+// Format is a snapshot name template like
+//
+//	zrepl_{{ .Time.Format "2006-01-02_15:04:05" }}
+//
+// that contains exactly one .Time.Format action and otherwise only literal text,
+// which makes names rendered by the template parseable.
+type Format struct {
+	template       string
+	tmpl           *template.Template
+	prefix, suffix string
+	layout         string
+	loc            *time.Location
+}
+
+var timeFormatAction = regexp.MustCompile(`\{\{-?\s*\.Time\.Format\s+"([^"]*)"\s*-?\}\}`)
+
+// New parses tmpl. timezone is passed to time.LoadLocation, e.g. "UTC" or "Local".
+func New(tmpl, timezone string) (*Format, error) {
+	actions := timeFormatAction.FindAllStringSubmatchIndex(tmpl, -1)
+	if len(actions) != 1 {
+		return nil, errors.Errorf("name template %q must contain exactly one {{ .Time.Format \"<layout>\" }} action", tmpl)
+	}
+	a := actions[0]
+	f := &Format{
+		template: tmpl,
+		prefix:   tmpl[:a[0]],
+		suffix:   tmpl[a[1]:],
+		layout:   tmpl[a[2]:a[3]],
+	}
+	if strings.Contains(f.prefix, "{{") || strings.Contains(f.suffix, "{{") {
+		return nil, errors.Errorf("name template %q must not contain actions other than {{ .Time.Format }}", tmpl)
+	}
+	if f.layout == "" {
+		return nil, errors.Errorf("name template %q has an empty time layout", tmpl)
+	}
+	if strings.ContainsAny(f.prefix+f.layout+f.suffix, "@#/ ") {
+		return nil, errors.Errorf("name template %q contains characters that are invalid in snapshot names", tmpl)
+	}
+	var err error
+	if f.tmpl, err = template.New("snapname").Parse(tmpl); err != nil {
+		return nil, errors.Wrap(err, "cannot parse name template")
+	}
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	if f.loc, err = time.LoadLocation(timezone); err != nil {
+		return nil, errors.Wrap(err, "invalid timezone")
+	}
+	return f, nil
+}
+
+// FromPrefix returns the Format of snapshot names that consist of prefix followed by DefaultLayout in UTC.
+func FromPrefix(prefix string) (*Format, error) {
+	if prefix == "" {
+		return nil, errors.New("prefix must not be empty")
+	}
+	return New(prefix+`{{ .Time.Format "`+DefaultLayout+`" }}`, "UTC")
+}
+
+func (f *Format) String() string { return f.template }
+
+// Prefix returns the literal text before the time in names of f.
+func (f *Format) Prefix() string { return f.prefix }
+
+// Name renders the snapshot name for t.
+func (f *Format) Name(t time.Time) (string, error) {
+	var buf bytes.Buffer
+	data := struct{ Time time.Time }{t.In(f.loc)}
+	if err := f.tmpl.Execute(&buf, data); err != nil {
+		return "", errors.Wrap(err, "cannot render snapshot name")
+	}
+	return buf.String(), nil
+}
+
+// Parse returns the time encoded in name, or false if name was not rendered by f.
+func (f *Format) Parse(name string) (t time.Time, ok bool) {
+	if !strings.HasPrefix(name, f.prefix) || !strings.HasSuffix(name, f.suffix) {
+		return time.Time{}, false
+	}
+	if len(name) < len(f.prefix)+len(f.suffix) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(f.layout, name[len(f.prefix):len(name)-len(f.suffix)], f.loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// Matches returns true if name was rendered by f.
+func (f *Format) Matches(name string) bool {
+	_, ok := f.Parse(name)
+	return ok
+}
@@ -0,0 +1,54 @@
+package snapname
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestFormatRoundTrip(t *testing.T) {
+	at := time.Date(2018, 10, 1, 13, 37, 12, 0, time.UTC)
+
+	f, err := New(`zrepl_{{ .Time.Format "2006-01-02_15:04:05" }}_auto`, "UTC")
+	require.NoError(t, err)
+	name, err := f.Name(at)
+	require.NoError(t, err)
+	assert.Equal(t, "zrepl_2018-10-01_13:37:12_auto", name)
+	parsed, ok := f.Parse(name)
+	require.True(t, ok)
+	assert.True(t, at.Equal(parsed))
+	assert.Equal(t, "zrepl_", f.Prefix())
+
+	assert.False(t, f.Matches("zrepl_2018-10-01_13:37:12"))
+	assert.False(t, f.Matches("zrepl_garbage_auto"))
+	assert.False(t, f.Matches("manual_2018-10-01_13:37:12_auto"))
+}
+
+func TestFromPrefix(t *testing.T) {
+	f, err := FromPrefix("zrepl_")
+	require.NoError(t, err)
+	name, err := f.Name(time.Date(2018, 10, 1, 13, 37, 12, 0, time.FixedZone("CEST", 2*60*60)))
+	require.NoError(t, err)
+	assert.Equal(t, "zrepl_20181001_113712_000", name, "default naming must be compatible with previous releases")
+	assert.True(t, f.Matches(name))
+
+	_, err = FromPrefix("")
+	assert.Error(t, err)
+}
+
+func TestNewInvalid(t *testing.T) {
+	invalid := []string{
+		"zrepl_",
+		`zrepl_{{ .Time.Format "2006" }}_{{ .Time.Format "01" }}`,
+		`{{ .Foo }}{{ .Time.Format "2006" }}`,
+		`zrepl {{ .Time.Format "2006" }}`,
+		`zrepl_{{ .Time.Format "" }}`,
+	}
+	for _, tmpl := range invalid {
+		_, err := New(tmpl, "UTC")
+		assert.Error(t, err, "template %q", tmpl)
+	}
+	_, err := New(`zrepl_{{ .Time.Format "2006" }}`, "Not/AZone")
+	assert.Error(t, err)
+}
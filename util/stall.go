@@ -0,0 +1,94 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StallError is returned by a StallDetectingReader once its underlying reader stalled.
+type StallError struct {
+	// The time a single Read waited for data before the reader was closed.
+	Timeout time.Duration
+	// Number of bytes read before the stall, 0 means that the stream never produced any data.
+	Bytes int64
+}
+
+func (e *StallError) Error() string {
+	if e.Bytes == 0 {
+		return fmt.Sprintf("send stalled: no data within %s after start", e.Timeout)
+	}
+	return fmt.Sprintf("send stalled: no data for %s after %d bytes", e.Timeout, e.Bytes)
+}
+
+// StallDetectingReader closes the wrapped reader if a single Read blocks for longer than a timeout.
+// Only time spent waiting in the wrapped reader counts, so a slow consumer is not mistaken for a stall.
+type StallDetectingReader struct {
+	reader  io.ReadCloser
+	timeout time.Duration
+
+	mtx   sync.Mutex
+	timer *time.Timer
+
+	// set atomically
+	bytes   int64
+	stalled int32
+}
+
+// NewStallDetectingReader returns a StallDetectingReader that never detects a stall if timeout is 0.
+func NewStallDetectingReader(reader io.ReadCloser, timeout time.Duration) *StallDetectingReader {
+	return &StallDetectingReader{reader: reader, timeout: timeout}
+}
+
+func (r *StallDetectingReader) onTimeout() {
+	atomic.StoreInt32(&r.stalled, 1)
+	r.reader.Close()
+}
+
+func (r *StallDetectingReader) Read(p []byte) (n int, err error) {
+	if err := r.Stalled(); err != nil {
+		return 0, err
+	}
+	if r.timeout > 0 {
+		r.mtx.Lock()
+		if r.timer == nil {
+			r.timer = time.AfterFunc(r.timeout, r.onTimeout)
+		} else {
+			r.timer.Reset(r.timeout)
+		}
+		r.mtx.Unlock()
+	}
+	n, err = r.reader.Read(p)
+	if r.timeout > 0 {
+		r.mtx.Lock()
+		r.timer.Stop()
+		r.mtx.Unlock()
+	}
+	atomic.AddInt64(&r.bytes, int64(n))
+	if err != nil {
+		if stallErr := r.Stalled(); stallErr != nil {
+			err = stallErr
+		}
+	}
+	return n, err
+}
+
+// Stalled returns a *StallError if the wrapped reader was closed because it stalled, and nil otherwise.
+// Consumers that only see the effects of the closed reader (e.g. a failed RPC) use it to report the cause.
+func (r *StallDetectingReader) Stalled() error {
+	if atomic.LoadInt32(&r.stalled) == 0 {
+		return nil
+	}
+	return &StallError{Timeout: r.timeout, Bytes: atomic.LoadInt64(&r.bytes)}
+}
+
+func (r *StallDetectingReader) Close() error {
+	r.mtx.Lock()
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.mtx.Unlock()
+	return r.reader.Close()
+}
@@ -0,0 +1,68 @@
+package util
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// blockingReader returns data once and then blocks in Read until it is closed.
+type blockingReader struct {
+	data   []byte
+	closed chan struct{}
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	if len(r.data) > 0 {
+		n := copy(p, r.data)
+		r.data = r.data[n:]
+		return n, nil
+	}
+	<-r.closed
+	return 0, io.ErrClosedPipe
+}
+
+func (r *blockingReader) Close() error {
+	select {
+	case <-r.closed:
+	default:
+		close(r.closed)
+	}
+	return nil
+}
+
+func TestStallDetectingReaderNoData(t *testing.T) {
+	r := NewStallDetectingReader(&blockingReader{closed: make(chan struct{})}, 20*time.Millisecond)
+	_, err := io.Copy(ioutil.Discard, r)
+	require.Error(t, err)
+	stallErr, ok := err.(*StallError)
+	require.True(t, ok, "%T", err)
+	assert.Equal(t, int64(0), stallErr.Bytes)
+	assert.Contains(t, err.Error(), "no data within")
+	assert.Equal(t, err, r.Stalled())
+}
+
+func TestStallDetectingReaderAfterData(t *testing.T) {
+	r := NewStallDetectingReader(&blockingReader{data: []byte("foo"), closed: make(chan struct{})}, 20*time.Millisecond)
+	_, err := io.Copy(ioutil.Discard, r)
+	require.Error(t, err)
+	assert.Equal(t, &StallError{Timeout: 20 * time.Millisecond, Bytes: 3}, err)
+}
+
+func TestStallDetectingReaderSlowConsumer(t *testing.T) {
+	r := NewStallDetectingReader(ioutil.NopCloser(bytes.NewReader([]byte("foobar"))), 10*time.Millisecond)
+	buf := make([]byte, 3)
+	for {
+		_, err := r.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		time.Sleep(30 * time.Millisecond) // consumer is slower than the timeout
+	}
+	assert.NoError(t, r.Stalled())
+}
@@ -0,0 +1,88 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// TeeTarget receives the copy of a stream made by a TeeReader.
+type TeeTarget interface {
+	io.Writer
+	// Commit is called once the stream was copied completely.
+	Commit() error
+	// Abort is called instead of Commit if the stream was not copied completely.
+	Abort()
+}
+
+// TeeError is the error of a TeeTarget, as returned by a TeeReader.
+type TeeError struct {
+	Err error
+}
+
+func (e *TeeError) Error() string {
+	return fmt.Sprintf("stream tee failed: %s", e.Err)
+}
+
+// TeeReader copies everything read from the wrapped reader to a TeeTarget,
+// which is committed when the wrapped reader returns io.EOF and aborted if the TeeReader is closed before.
+//
+// If the target fails and failOnError is set, Read returns a *TeeError, which aborts the stream.
+// Otherwise the target is aborted, reading continues and the error is available through Failed.
+// A nil target copies nothing.
+type TeeReader struct {
+	reader      io.ReadCloser
+	failOnError bool
+
+	mtx    sync.Mutex
+	target TeeTarget // nil once committed or aborted
+	err    *TeeError
+}
+
+func NewTeeReader(reader io.ReadCloser, target TeeTarget, failOnError bool) *TeeReader {
+	return &TeeReader{reader: reader, target: target, failOnError: failOnError}
+}
+
+func (t *TeeReader) Read(p []byte) (n int, err error) {
+	n, err = t.reader.Read(p)
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.target != nil && n > 0 {
+		if _, werr := t.target.Write(p[:n]); werr != nil {
+			t.err = &TeeError{werr}
+			t.target.Abort()
+			t.target = nil
+		}
+	}
+	if t.target != nil && err == io.EOF {
+		if cerr := t.target.Commit(); cerr != nil {
+			t.err = &TeeError{cerr}
+		}
+		t.target = nil
+	}
+	if t.err != nil && t.failOnError {
+		return n, t.err
+	}
+	return n, err
+}
+
+// Failed returns the *TeeError of the target, or nil if the target did not fail (yet).
+func (t *TeeReader) Failed() error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.err == nil {
+		return nil
+	}
+	return t.err
+}
+
+func (t *TeeReader) Close() error {
+	t.mtx.Lock()
+	if t.target != nil {
+		t.target.Abort()
+		t.target = nil
+	}
+	t.mtx.Unlock()
+	return t.reader.Close()
+}
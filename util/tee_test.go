@@ -0,0 +1,76 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+type testTeeTarget struct {
+	bytes.Buffer
+	failWrite          bool
+	committed, aborted bool
+}
+
+func (t *testTeeTarget) Write(p []byte) (int, error) {
+	if t.failWrite {
+		return 0, errors.New("disk full")
+	}
+	return t.Buffer.Write(p)
+}
+
+func (t *testTeeTarget) Commit() error {
+	t.committed = true
+	return nil
+}
+
+func (t *testTeeTarget) Abort() { t.aborted = true }
+
+func TestTeeReader(t *testing.T) {
+	data := bytes.Repeat([]byte("zfs send stream "), 1000)
+	target := &testTeeTarget{}
+	r := NewTeeReader(ioutil.NopCloser(bytes.NewReader(data)), target, true)
+	out, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, data, out)
+	assert.Equal(t, data, target.Bytes())
+	assert.True(t, target.committed)
+	assert.False(t, target.aborted)
+	assert.NoError(t, r.Close())
+	assert.False(t, target.aborted, "a committed target must not be aborted on close")
+}
+
+func TestTeeReaderClosedBeforeEOF(t *testing.T) {
+	target := &testTeeTarget{}
+	r := NewTeeReader(ioutil.NopCloser(bytes.NewReader([]byte("partial"))), target, true)
+	_, err := r.Read(make([]byte, 3))
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.True(t, target.aborted)
+	assert.False(t, target.committed)
+}
+
+func TestTeeReaderFailOnError(t *testing.T) {
+	target := &testTeeTarget{failWrite: true}
+	r := NewTeeReader(ioutil.NopCloser(bytes.NewReader([]byte("data"))), target, true)
+	_, err := io.Copy(ioutil.Discard, r)
+	require.Error(t, err)
+	assert.IsType(t, &TeeError{}, err)
+	assert.True(t, target.aborted)
+	assert.Equal(t, err, r.Failed())
+}
+
+func TestTeeReaderContinueOnError(t *testing.T) {
+	target := &testTeeTarget{failWrite: true}
+	r := NewTeeReader(ioutil.NopCloser(bytes.NewReader([]byte("data"))), target, false)
+	out, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), out)
+	assert.True(t, target.aborted)
+	assert.False(t, target.committed)
+	assert.IsType(t, &TeeError{}, r.Failed())
+}
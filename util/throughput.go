@@ -0,0 +1,120 @@
+package util
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// ThroughputReader accounts the bytes read from reader over time
+// to compute the average and the recent throughput of a stream.
+// It is safe to call Stats concurrently with Read.
+type ThroughputReader struct {
+	reader io.ReadCloser
+	// called with the duration of every Read that returned data, may be nil
+	observeLatency func(d time.Duration)
+
+	mtx        sync.Mutex
+	start, end time.Time
+	bytes      int64
+	// ring buffer of samples for the recent throughput, at most one per throughputSampleInterval
+	samples    [throughputSamples]throughputSample
+	nextSample int
+}
+
+const (
+	throughputSampleInterval = 1 * time.Second
+	// the recent throughput is averaged over roughly throughputSamples*throughputSampleInterval
+	throughputSamples = 10
+)
+
+type throughputSample struct {
+	at    time.Time
+	bytes int64
+}
+
+type ThroughputStats struct {
+	Bytes int64
+	// from the first Read until the stream ended or now
+	Elapsed               time.Duration
+	AverageBytesPerSecond int64
+	// throughput over the last seconds, 0 once the stream ended
+	RecentBytesPerSecond int64
+	Ended                bool
+}
+
+func NewThroughputReader(reader io.ReadCloser, observeLatency func(d time.Duration)) *ThroughputReader {
+	return &ThroughputReader{reader: reader, observeLatency: observeLatency}
+}
+
+func (r *ThroughputReader) Read(p []byte) (n int, err error) {
+	begin := time.Now()
+	n, err = r.reader.Read(p)
+	now := time.Now()
+	if n > 0 && r.observeLatency != nil {
+		r.observeLatency(now.Sub(begin))
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.start.IsZero() {
+		r.start = begin
+		r.samples[0] = throughputSample{begin, 0}
+		r.nextSample = 1
+	}
+	r.bytes += int64(n)
+	last := r.samples[(r.nextSample+throughputSamples-1)%throughputSamples]
+	if now.Sub(last.at) >= throughputSampleInterval {
+		r.samples[r.nextSample] = throughputSample{now, r.bytes}
+		r.nextSample = (r.nextSample + 1) % throughputSamples
+	}
+	if err != nil && r.end.IsZero() {
+		r.end = now
+	}
+	return n, err
+}
+
+func (r *ThroughputReader) Close() error {
+	r.mtx.Lock()
+	if !r.start.IsZero() && r.end.IsZero() {
+		r.end = time.Now()
+	}
+	r.mtx.Unlock()
+	return r.reader.Close()
+}
+
+func bytesPerSecond(bytes int64, d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(float64(bytes) / d.Seconds())
+}
+
+func (r *ThroughputReader) Stats() ThroughputStats {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.statsAt(time.Now())
+}
+
+// r.mtx must be held
+func (r *ThroughputReader) statsAt(now time.Time) ThroughputStats {
+	s := ThroughputStats{Bytes: r.bytes}
+	if r.start.IsZero() {
+		return s
+	}
+	s.Ended = !r.end.IsZero()
+	if s.Ended {
+		now = r.end
+	}
+	s.Elapsed = now.Sub(r.start)
+	s.AverageBytesPerSecond = bytesPerSecond(r.bytes, s.Elapsed)
+	if !s.Ended {
+		// the oldest sample in the ring buffer, which is the first one until the buffer is full
+		oldest := r.samples[r.nextSample]
+		if oldest.at.IsZero() {
+			oldest = r.samples[0]
+		}
+		s.RecentBytesPerSecond = bytesPerSecond(r.bytes-oldest.bytes, now.Sub(oldest.at))
+	}
+	return s
+}
@@ -0,0 +1,59 @@
+package util
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestThroughputReader(t *testing.T) {
+	var latencies int
+	r := NewThroughputReader(ioutil.NopCloser(bytes.NewReader(make([]byte, 1<<20))), func(time.Duration) { latencies++ })
+	assert.Equal(t, ThroughputStats{}, r.Stats())
+
+	buf := make([]byte, 1<<16)
+	_, err := r.Read(buf)
+	require.NoError(t, err)
+	running := r.Stats()
+	assert.Equal(t, int64(1<<16), running.Bytes)
+	assert.False(t, running.Ended)
+
+	for err == nil {
+		_, err = r.Read(buf)
+	}
+	require.Equal(t, io.EOF, err)
+	ended := r.Stats()
+	assert.True(t, ended.Ended)
+	assert.Equal(t, int64(1<<20), ended.Bytes)
+	assert.Equal(t, int64(0), ended.RecentBytesPerSecond)
+	assert.Equal(t, 16, latencies) // the final Read at EOF returns no data
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, ended.Elapsed, r.Stats().Elapsed, "elapsed time must not grow after the stream ended")
+}
+
+func TestThroughputReader_Recent(t *testing.T) {
+	r := &ThroughputReader{}
+	start := time.Now()
+	r.start = start
+	// 1MiB/s for 5s, then 4MiB/s for 15s: the recent throughput only reflects the latter
+	r.samples[0] = throughputSample{start, 0}
+	r.nextSample = 1
+	r.bytes = 0
+	for i := 1; i <= 20; i++ {
+		if i <= 5 {
+			r.bytes += 1 << 20
+		} else {
+			r.bytes += 4 << 20
+		}
+		r.samples[r.nextSample] = throughputSample{start.Add(time.Duration(i) * time.Second), r.bytes}
+		r.nextSample = (r.nextSample + 1) % throughputSamples
+	}
+	s := r.statsAt(start.Add(20 * time.Second))
+	assert.Equal(t, int64(4<<20), s.RecentBytesPerSecond)
+	assert.Equal(t, int64((5*1<<20+15*4<<20)/20), s.AverageBytesPerSecond)
+	assert.Equal(t, 20*time.Second, s.Elapsed)
+}
@@ -29,3 +29,32 @@ func (k *KeepAlive) CheckTimeout(timeout time.Duration, jitter time.Duration) (d
 	defer k.mtx.Unlock()
 	return k.lastUpd.Add(timeout - jitter).Before(time.Now())
 }
+
+// Watch calls onTimeout once if k makes no progress for timeout, counted from the call to Watch.
+// Watching stops when onTimeout was called or stop is called, whichever happens first.
+func (k *KeepAlive) Watch(timeout time.Duration, onTimeout func()) (stop func()) {
+	k.MadeProgress()
+	done := make(chan struct{})
+	var once sync.Once
+	stop = func() { once.Do(func() { close(done) }) }
+	interval := timeout / 10
+	if interval <= 0 {
+		interval = timeout
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				if k.CheckTimeout(timeout, 0) {
+					onTimeout()
+					return
+				}
+			}
+		}
+	}()
+	return stop
+}
@@ -0,0 +1,35 @@
+package watchdog
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeepAliveWatch(t *testing.T) {
+	var k KeepAlive
+	var fired int32
+	stop := k.Watch(100*time.Millisecond, func() { atomic.AddInt32(&fired, 1) })
+	defer stop()
+
+	// progress keeps the watchdog from firing
+	for i := 0; i < 10; i++ {
+		time.Sleep(20 * time.Millisecond)
+		k.MadeProgress()
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&fired))
+
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fired), "fires exactly once")
+}
+
+func TestKeepAliveWatchStop(t *testing.T) {
+	var k KeepAlive
+	var fired int32
+	stop := k.Watch(50*time.Millisecond, func() { atomic.AddInt32(&fired, 1) })
+	stop()
+	stop() // idempotent
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&fired))
+}
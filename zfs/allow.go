@@ -0,0 +1,272 @@
+package zfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DelegationIdentity is the user whose delegated permissions (zfs allow) are evaluated.
+// Groups and user may be given both by name and by numeric id, as zfs allow prints ids it cannot resolve.
+type DelegationIdentity struct {
+	Users  []string
+	Groups []string
+}
+
+type delegationScope uint
+
+const (
+	delegationLocal delegationScope = 1 << iota
+	delegationDescendent
+)
+
+type delegationEntry struct {
+	dataset string
+	scope   delegationScope
+	whoType string // user, group or everyone
+	who     string
+	perms   []string
+}
+
+// Delegations are the permissions delegated on a dataset and its ancestors, as reported by zfs allow.
+type Delegations struct {
+	dataset string
+	entries []delegationEntry
+	// permission sets by dataset on which they are defined
+	sets map[string]map[string][]string
+}
+
+var zfsAllowHeaderRegex = regexp.MustCompile(`^---- Permissions on (\S+) -+$`)
+
+// ParseZFSAllowOutput parses the output of `zfs allow dataset`.
+func ParseZFSAllowOutput(dataset string, output []byte) (*Delegations, error) {
+	d := &Delegations{dataset: dataset, sets: make(map[string]map[string][]string)}
+	var curDS, section string
+	s := bufio.NewScanner(bytes.NewReader(output))
+	for s.Scan() {
+		line := s.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if m := zfsAllowHeaderRegex.FindStringSubmatch(line); m != nil {
+			curDS, section = m[1], ""
+			continue
+		}
+		if !strings.HasPrefix(line, "\t") && !strings.HasPrefix(line, " ") {
+			section = strings.TrimSuffix(strings.TrimSpace(line), ":")
+			continue
+		}
+		if curDS == "" {
+			return nil, fmt.Errorf("permissions outside of a dataset header: %q", line)
+		}
+		fields := strings.Fields(line)
+		var scope delegationScope
+		switch section {
+		case "Permission sets":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("invalid permission set line %q", line)
+			}
+			if d.sets[curDS] == nil {
+				d.sets[curDS] = make(map[string][]string)
+			}
+			d.sets[curDS][fields[0]] = strings.Split(fields[1], ",")
+			continue
+		case "Create time permissions":
+			continue // only apply to the creator of a dataset, which we cannot know here
+		case "Local permissions":
+			scope = delegationLocal
+		case "Descendent permissions":
+			scope = delegationDescendent
+		case "Local+Descendent permissions":
+			scope = delegationLocal | delegationDescendent
+		default:
+			return nil, fmt.Errorf("unknown section %q", section)
+		}
+		e := delegationEntry{dataset: curDS, scope: scope}
+		switch {
+		case len(fields) == 2 && fields[0] == "everyone":
+			e.whoType, e.perms = fields[0], strings.Split(fields[1], ",")
+		case len(fields) == 3 && (fields[0] == "user" || fields[0] == "group"):
+			e.whoType, e.who, e.perms = fields[0], fields[1], strings.Split(fields[2], ",")
+		default:
+			return nil, fmt.Errorf("invalid permission line %q", line)
+		}
+		d.entries = append(d.entries, e)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ZFSAllow returns the permissions delegated on fs and its ancestors.
+func ZFSAllow(fs *DatasetPath) (*Delegations, error) {
+	cmd := zfsCmd(context.Background(), "allow", fs.ToString())
+	stderr := bytes.NewBuffer(make([]byte, 0, 1024))
+	cmd.Stderr = stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, ZFSError{
+			Stderr:  stderr.Bytes(),
+			WaitErr: err,
+		}
+	}
+	return ParseZFSAllowOutput(fs.ToString(), output)
+}
+
+// ZFSAllowAll returns the permissions delegated on each of fss and its ancestors, by dataset name.
+// zfs allow displays a single dataset per invocation, but that output includes the permissions on all its ancestors,
+// so it is only invoked for those of fss that have no descendant in fss.
+func ZFSAllowAll(fss []*DatasetPath) (map[string]*Delegations, error) {
+	names := make([]string, len(fss))
+	for i, fs := range fss {
+		names[i] = fs.ToString()
+	}
+	queryFor := allowQueries(names)
+	queried := make(map[string]*Delegations)
+	res := make(map[string]*Delegations, len(queryFor))
+	for ds, q := range queryFor {
+		d, ok := queried[q]
+		if !ok {
+			qp, err := NewDatasetPath(q)
+			if err != nil {
+				return nil, err
+			}
+			if d, err = ZFSAllow(qp); err != nil {
+				return nil, err
+			}
+			queried[q] = d
+		}
+		res[ds] = d.forAncestor(ds)
+	}
+	return res, nil
+}
+
+// allowQueries maps each of datasets to the dataset whose zfs allow output covers it:
+// the dataset itself or one of its descendants in datasets.
+func allowQueries(datasets []string) map[string]string {
+	sorted := append([]string{}, datasets...)
+	sort.Strings(sorted)
+	queryFor := make(map[string]string, len(sorted))
+	// descendants sort directly after their ancestors, so walk backwards and remember the last leaf below each dataset
+	for i := len(sorted) - 1; i >= 0; i-- {
+		ds := sorted[i]
+		if _, ok := queryFor[ds]; ok {
+			continue // duplicate
+		}
+		queryFor[ds] = ds
+		for j := i + 1; j < len(sorted); j++ {
+			if strings.HasPrefix(sorted[j], ds+"/") {
+				queryFor[ds] = queryFor[sorted[j]]
+				break
+			}
+		}
+	}
+	return queryFor
+}
+
+// forAncestor returns the delegations on ancestor, which must be d's dataset or one of its ancestors.
+func (d *Delegations) forAncestor(ancestor string) *Delegations {
+	// Allowed ignores the entries on datasets that are not ancestor or above
+	return &Delegations{dataset: ancestor, entries: d.entries, sets: d.sets}
+}
+
+// ZFSNearestExisting returns p or its closest ancestor that exists, or nil if not even the pool exists.
+func ZFSNearestExisting(p *DatasetPath) (*DatasetPath, error) {
+	for n := len(p.comps); n > 0; n-- {
+		cur := &DatasetPath{comps: p.comps[:n]}
+		_, err := zfsGet(cur.ToString(), []string{"name"}, sourceAny)
+		if err == nil {
+			return cur.Copy(), nil
+		}
+		if _, ok := err.(*DatasetDoesNotExist); !ok {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (d *Delegations) appliesTo(e delegationEntry, id DelegationIdentity) bool {
+	var candidates []string
+	switch e.whoType {
+	case "everyone":
+		return true
+	case "user":
+		candidates = id.Users
+	case "group":
+		candidates = id.Groups
+	}
+	for _, c := range candidates {
+		if c == e.who {
+			return true
+		}
+	}
+	return false
+}
+
+// expand resolves permission set names, which are looked up on the dataset they are used on and its ancestors.
+func (d *Delegations) expand(perms []string, on string, depth int, out map[string]bool) {
+	for _, p := range perms {
+		if !strings.HasPrefix(p, "@") {
+			out[p] = true
+			continue
+		}
+		if depth > 8 { // sets may contain sets, but not endlessly
+			continue
+		}
+		for ds := on; ds != ""; ds = parentDataset(ds) {
+			if set, ok := d.sets[ds][p]; ok {
+				d.expand(set, ds, depth+1, out)
+				break
+			}
+		}
+	}
+}
+
+func parentDataset(ds string) string {
+	i := strings.LastIndex(ds, "/")
+	if i == -1 {
+		return ""
+	}
+	return ds[:i]
+}
+
+// Allowed returns the permissions of id on the dataset passed to ZFSAllow,
+// or, if descendants is true, on datasets below it, e.g. those created by zfs recv.
+func (d *Delegations) Allowed(id DelegationIdentity, descendants bool) map[string]bool {
+	allowed := make(map[string]bool)
+	for _, e := range d.entries {
+		if !d.appliesTo(e, id) {
+			continue
+		}
+		onDataset := e.dataset == d.dataset
+		if !onDataset && !strings.HasPrefix(d.dataset, e.dataset+"/") {
+			continue
+		}
+		if onDataset && !descendants && e.scope&delegationLocal == 0 {
+			continue
+		}
+		if (!onDataset || descendants) && e.scope&delegationDescendent == 0 {
+			continue
+		}
+		d.expand(e.perms, e.dataset, 0, allowed)
+	}
+	return allowed
+}
+
+// Missing returns those of perms that id lacks, sorted.
+func (d *Delegations) Missing(id DelegationIdentity, descendants bool, perms []string) []string {
+	allowed := d.Allowed(id, descendants)
+	var missing []string
+	for _, p := range perms {
+		if !allowed[p] {
+			missing = append(missing, p)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
@@ -0,0 +1,90 @@
+package zfs
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const zfsAllowTestOutput = `---- Permissions on pool/backup/host1 --------------------------------
+Permission sets:
+	@recv create,mount,receive
+Local permissions:
+	user zrepl destroy
+---- Permissions on pool/backup --------------------------------------
+Create time permissions:
+	destroy
+Local+Descendent permissions:
+	group backup @recv
+Descendent permissions:
+	user zrepl userprop
+---- Permissions on pool ---------------------------------------------
+Local permissions:
+	everyone snapshot
+Descendent permissions:
+	user other send
+`
+
+func TestParseZFSAllowOutput(t *testing.T) {
+	d, err := ParseZFSAllowOutput("pool/backup/host1", []byte(zfsAllowTestOutput))
+	require.NoError(t, err)
+
+	id := DelegationIdentity{Users: []string{"zrepl", "1001"}, Groups: []string{"backup"}}
+	perms := []string{"create", "destroy", "mount", "receive", "send", "snapshot", "userprop"}
+
+	// @recv is defined on host1, but used on pool/backup, so it does not resolve there
+	assert.Equal(t, []string{"create", "mount", "receive", "send", "snapshot"}, d.Missing(id, false, perms))
+	// the local destroy does not apply to children
+	assert.Equal(t, []string{"create", "destroy", "mount", "receive", "send", "snapshot"}, d.Missing(id, true, perms))
+
+	// on pool/backup, userprop is only delegated to descendants, and host1's permissions do not apply
+	d, err = ParseZFSAllowOutput("pool/backup", []byte(zfsAllowTestOutput))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"create", "destroy", "mount", "receive", "send", "snapshot", "userprop"}, d.Missing(id, false, perms))
+	assert.Equal(t, map[string]bool{"send": true}, d.Allowed(DelegationIdentity{Users: []string{"other"}}, true))
+	assert.Equal(t, map[string]bool{}, d.Allowed(DelegationIdentity{Users: []string{"nobody"}}, true))
+}
+
+func TestParseZFSAllowOutputPermissionSets(t *testing.T) {
+	out := `---- Permissions on pool/backup --------------------------------------
+Permission sets:
+	@base mount
+	@recv @base,create,receive
+Local+Descendent permissions:
+	user zrepl @recv
+`
+	d, err := ParseZFSAllowOutput("pool/backup", []byte(out))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"create": true, "mount": true, "receive": true}, d.Allowed(DelegationIdentity{Users: []string{"zrepl"}}, true))
+}
+
+func TestParseZFSAllowOutputInvalid(t *testing.T) {
+	_, err := ParseZFSAllowOutput("pool", []byte("---- Permissions on pool ----\nFoo permissions:\n\tuser zrepl send\n"))
+	assert.Error(t, err)
+	_, err = ParseZFSAllowOutput("pool", []byte("---- Permissions on pool ----\nLocal permissions:\n\tuser send\n"))
+	assert.Error(t, err)
+}
+
+func TestAllowQueries(t *testing.T) {
+	q := allowQueries([]string{"pool", "pool/a", "pool/a-b", "pool/a/x", "pool/a/x", "pool/c", "other/d"})
+	assert.Equal(t, map[string]string{
+		"pool/a/x": "pool/a/x",
+		"pool/a-b": "pool/a-b",
+		"pool/a":   "pool/a/x",
+		"pool/c":   "pool/c",
+		"pool":     "pool/a/x",
+		"other/d":  "other/d",
+	}, q)
+}
+
+func TestDelegationsForAncestor(t *testing.T) {
+	leaf, err := ParseZFSAllowOutput("pool/backup/host1", []byte(zfsAllowTestOutput))
+	require.NoError(t, err)
+	direct, err := ParseZFSAllowOutput("pool/backup", []byte(zfsAllowTestOutput))
+	require.NoError(t, err)
+
+	id := DelegationIdentity{Users: []string{"zrepl"}, Groups: []string{"backup"}}
+	for _, descendants := range []bool{false, true} {
+		assert.Equal(t, direct.Allowed(id, descendants), leaf.forAncestor("pool/backup").Allowed(id, descendants))
+	}
+}
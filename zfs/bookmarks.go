@@ -0,0 +1,43 @@
+package zfs
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+)
+
+// ZFSBookmarkSnapshot creates the bookmark fs#snapname of the snapshot fs@snapname and returns its guid.
+// If the bookmark already exists and refers to the snapshot, it is left untouched,
+// which makes retries after a failure idempotent.
+func ZFSBookmarkSnapshot(fs *DatasetPath, snapname string) (guid uint64, err error) {
+	snapPath := zfsBuildSnapName(fs, snapname)
+	propsSnap, err := zfsGet(snapPath, []string{"guid"}, sourceAny)
+	if err != nil {
+		return 0, err
+	}
+	snapGuid, err := strconv.ParseUint(propsSnap.Get("guid"), 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "cannot parse snapshot guid")
+	}
+
+	bookmarkPath := zfsBuildBookmarkName(fs, snapname)
+	propsBookmark, err := zfsGet(bookmarkPath, []string{"guid"}, sourceAny)
+	if _, notExist := err.(*DatasetDoesNotExist); err != nil && !notExist {
+		return 0, err
+	}
+	if err == nil {
+		bookmarkGuid, err := strconv.ParseUint(propsBookmark.Get("guid"), 10, 64)
+		if err != nil {
+			return 0, errors.Wrap(err, "cannot parse bookmark guid")
+		}
+		if bookmarkGuid != snapGuid {
+			return 0, fmt.Errorf("bookmark %s exists but does not refer to snapshot %s", bookmarkPath, snapPath)
+		}
+		return bookmarkGuid, nil
+	}
+
+	if err := ZFSBookmark(fs, snapname, snapname); err != nil {
+		return 0, err
+	}
+	return snapGuid, nil
+}
@@ -2,11 +2,11 @@ package zfs
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha512"
 	"encoding/hex"
 	"fmt"
 	"io"
-	"os/exec"
 	"sort"
 )
 
@@ -261,7 +261,7 @@ func ZFSIsPlaceholderFilesystem(p *DatasetPath) (isPlaceholder bool, err error)
 
 func ZFSCreatePlaceholderFilesystem(p *DatasetPath) (err error) {
 	v := PlaceholderPropertyValue(p)
-	cmd := exec.Command(ZFS_BINARY, "create",
+	cmd := zfsCmd(context.Background(), "create",
 		"-o", fmt.Sprintf("%s=%s", ZREPL_PLACEHOLDER_PROPERTY_NAME, v),
 		"-o", "mountpoint=none",
 		p.ToString())
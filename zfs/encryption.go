@@ -0,0 +1,29 @@
+package zfs
+
+import (
+	"bytes"
+	"os/exec"
+)
+
+// ZFSEncryptionEnabled returns true if fs is encrypted, i.e. its encryption property is not "off".
+// On ZFS versions without native encryption, all filesystems are reported as unencrypted.
+func ZFSEncryptionEnabled(fs *DatasetPath) (bool, error) {
+	props, err := zfsGet(fs.ToString(), []string{"encryption"}, sourceAny)
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && bytes.Contains(exitErr.Stderr, []byte("invalid property 'encryption'")) {
+			return false, nil
+		}
+		return false, err
+	}
+	return encryptionEnabled(props.Get("encryption")), nil
+}
+
+// encryptionEnabled interprets the value of the encryption property.
+func encryptionEnabled(value string) bool {
+	switch value {
+	case "off", "-", "":
+		return false
+	default:
+		return true
+	}
+}
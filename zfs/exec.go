@@ -0,0 +1,346 @@
+package zfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/problame/go-rwccmd"
+	"github.com/zrepl/zrepl/logger"
+	"github.com/zrepl/zrepl/util"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExecConfig controls how this package runs zfs and zpool commands, see SetExecConfig.
+type ExecConfig struct {
+	// Increment passed to nice(1), zero runs commands without nice.
+	Nice int
+	// Scheduling class passed to ionice(1), one of idle, best-effort or realtime.
+	// Empty runs commands without ionice.
+	IONiceClass string
+	// Priority within IONiceClass (0-7), ignored for the idle class.
+	IONiceLevel int
+	// Maximum number of commands that run at the same time, zero means unlimited.
+	// zfs send and zfs recv, which last for a whole replication step, do not count against the limit.
+	MaxConcurrent int
+	// Run snapshot, bookmark, hold, release and the destruction of snapshots and bookmarks
+	// in-process through libzfs_core instead of the zfs command.
+	// Requires a binary built with the lzc build tag.
+	LZC bool
+	// Maximum number of snapshots of a filesystem destroyed by a single zfs destroy, see ZFSDestroySnapshots.
+	// Zero or one destroys snapshots one at a time.
+	DestroyBatchSize int
+	// The daemon runs as an unprivileged user with permissions delegated by zfs allow.
+	// Received filesystems are not mounted (zfs recv -u) because mounting requires root on most platforms.
+	Delegated bool
+	// Commands are logged at debug level, nil disables logging.
+	Logger logger.Logger
+}
+
+var ionicePriorityClasses = map[string]string{
+	"realtime":    "1",
+	"best-effort": "2",
+	"idle":        "3",
+}
+
+var execState struct {
+	mtx    sync.RWMutex
+	config ExecConfig
+	sem    chan struct{} // nil if unlimited
+}
+
+// Validate checks c without applying it.
+func (c ExecConfig) Validate() error {
+	if c.IONiceClass != "" {
+		if _, ok := ionicePriorityClasses[c.IONiceClass]; !ok {
+			return fmt.Errorf("invalid ionice class %q, must be one of idle, best-effort, realtime", c.IONiceClass)
+		}
+		if c.IONiceLevel < 0 || c.IONiceLevel > 7 {
+			return fmt.Errorf("invalid ionice level %d, must be in 0-7", c.IONiceLevel)
+		}
+	}
+	if c.MaxConcurrent < 0 {
+		return fmt.Errorf("maximum number of concurrent commands must not be negative")
+	}
+	if c.DestroyBatchSize < 0 {
+		return fmt.Errorf("destroy batch size must not be negative")
+	}
+	if c.LZC && !lzcCompiledIn {
+		return errLZCNotCompiledIn
+	}
+	return nil
+}
+
+// SetExecConfig applies c to all commands started afterwards.
+// Commands that wait for a slot of the previous concurrency limit keep waiting for it.
+func SetExecConfig(c ExecConfig) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if c.LZC {
+		if err := lzcInit(); err != nil {
+			return err
+		}
+	}
+	execState.mtx.Lock()
+	defer execState.mtx.Unlock()
+	execState.config = c
+	execState.sem = nil
+	if c.MaxConcurrent > 0 {
+		execState.sem = make(chan struct{}, c.MaxConcurrent)
+	}
+	return nil
+}
+
+// execArgv returns the command line that runs binary with args under the nice and ionice of c.
+func execArgv(c ExecConfig, binary string, args []string) (string, []string) {
+	var argv []string
+	if c.Nice != 0 {
+		argv = append(argv, "nice", "-n", strconv.Itoa(c.Nice))
+	}
+	if c.IONiceClass != "" {
+		argv = append(argv, "ionice", "-c", ionicePriorityClasses[c.IONiceClass])
+		if c.IONiceClass != "idle" {
+			argv = append(argv, "-n", strconv.Itoa(c.IONiceLevel))
+		}
+	}
+	argv = append(argv, binary)
+	argv = append(argv, args...)
+	return argv[0], argv[1:]
+}
+
+// cmdRecord limits, logs and measures one invocation of a zfs or zpool command.
+type cmdRecord struct {
+	// e.g. "zfs list", the label of the Prometheus metrics
+	command string
+	// as specified by the caller, i.e. without nice and ionice
+	argv  []string
+	log   logger.Logger
+	sem   chan struct{}
+	begin time.Time
+	once  sync.Once
+}
+
+func newCmdRecord(binary string, args []string) (*cmdRecord, string, []string) {
+	execState.mtx.RLock()
+	conf, sem := execState.config, execState.sem
+	execState.mtx.RUnlock()
+
+	command := filepath.Base(binary)
+	if len(args) > 0 {
+		command += " " + args[0]
+	}
+	if len(args) > 0 && (args[0] == "send" || args[0] == "recv") {
+		sem = nil
+	}
+	r := &cmdRecord{
+		command: command,
+		argv:    append([]string{binary}, args...),
+		log:     conf.Logger,
+		sem:     sem,
+	}
+	name, argv := execArgv(conf, binary, args)
+	return r, name, argv
+}
+
+// start must be called before the command is started, and if it returns nil, done must be called once the command exited.
+func (r *cmdRecord) start(ctx context.Context) error {
+	if r.sem != nil {
+		begin := time.Now()
+		select {
+		case r.sem <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		prom.ZFSCommandWaitDuration.WithLabelValues(r.command).Observe(time.Since(begin).Seconds())
+	}
+	r.begin = time.Now()
+	return nil
+}
+
+// done is idempotent.
+func (r *cmdRecord) done(err error) {
+	r.once.Do(func() {
+		if r.sem != nil {
+			<-r.sem
+		}
+		duration := time.Since(r.begin)
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		prom.ZFSCommandCount.WithLabelValues(r.command, result).Inc()
+		prom.ZFSCommandDuration.WithLabelValues(r.command).Observe(duration.Seconds())
+		if r.log == nil {
+			return
+		}
+		log := r.log.
+			WithField("cmd", strings.Join(r.argv, " ")).
+			WithField("duration", duration)
+		if err != nil {
+			log = log.WithError(err)
+		}
+		log.Debug("zfs command exited")
+	})
+}
+
+// execCmd is an exec.Cmd for a zfs or zpool command, see ExecConfig.
+// Only the methods of execCmd, not those of the embedded exec.Cmd, must be used to run it.
+type execCmd struct {
+	*exec.Cmd
+	ctx context.Context
+	rec *cmdRecord
+}
+
+func zfsCmd(ctx context.Context, args ...string) *execCmd {
+	return newCmd(ctx, ZFS_BINARY, args)
+}
+
+func zpoolCmd(ctx context.Context, args ...string) *execCmd {
+	return newCmd(ctx, ZPOOL_BINARY, args)
+}
+
+func newCmd(ctx context.Context, binary string, args []string) *execCmd {
+	rec, name, argv := newCmdRecord(binary, args)
+	return &execCmd{exec.CommandContext(ctx, name, argv...), ctx, rec}
+}
+
+func (c *execCmd) Start() error {
+	if err := c.rec.start(c.ctx); err != nil {
+		return err
+	}
+	if err := c.Cmd.Start(); err != nil {
+		c.rec.done(err)
+		return err
+	}
+	return nil
+}
+
+func (c *execCmd) Wait() error {
+	err := c.Cmd.Wait()
+	c.rec.done(err)
+	return err
+}
+
+func (c *execCmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Output is like exec.Cmd.Output, including the stderr of the returned *exec.ExitError.
+func (c *execCmd) Output() ([]byte, error) {
+	if c.Stdout != nil {
+		return nil, fmt.Errorf("exec: Stdout already set")
+	}
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	captureStderr := c.Stderr == nil
+	if captureStderr {
+		c.Stderr = &stderr
+	}
+	err := c.Run()
+	if ee, ok := err.(*exec.ExitError); ok && captureStderr {
+		ee.Stderr = stderr.Bytes()
+	}
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput is like exec.Cmd.CombinedOutput.
+func (c *execCmd) CombinedOutput() ([]byte, error) {
+	if c.Stdout != nil || c.Stderr != nil {
+		return nil, fmt.Errorf("exec: Stdout or Stderr already set")
+	}
+	var b syncBuffer
+	c.Stdout = &b
+	c.Stderr = &b
+	err := c.Run()
+	return b.Bytes(), err
+}
+
+type syncBuffer struct {
+	mtx sync.Mutex
+	b   []byte
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.b = append(b.b, p...)
+	return len(p), nil
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.b
+}
+
+// rwcCmd is a rwccmd.Cmd for a zfs command, see ExecConfig.
+type rwcCmd struct {
+	*rwccmd.Cmd
+	ctx context.Context
+	rec *cmdRecord
+}
+
+func zfsRWCCmd(ctx context.Context, args ...string) (*rwcCmd, error) {
+	rec, name, argv := newCmdRecord(ZFS_BINARY, args)
+	cmd, err := rwccmd.CommandContext(ctx, name, argv, []string{})
+	if err != nil {
+		return nil, err
+	}
+	return &rwcCmd{cmd, ctx, rec}, nil
+}
+
+func (c *rwcCmd) Start() error {
+	if err := c.rec.start(c.ctx); err != nil {
+		return err
+	}
+	if err := c.Cmd.Start(); err != nil {
+		c.rec.done(err)
+		return err
+	}
+	return nil
+}
+
+func (c *rwcCmd) Close() error {
+	err := c.Cmd.Close()
+	c.rec.done(err)
+	return err
+}
+
+// ioCommandStream is the stdout of a util.IOCommand for a zfs command, see ExecConfig.
+type ioCommandStream struct {
+	*util.IOCommand
+	rec *cmdRecord
+}
+
+var _ io.ReadCloser = &ioCommandStream{}
+
+func runZFSIOCommand(ctx context.Context, args ...string) (*ioCommandStream, error) {
+	rec, name, argv := newCmdRecord(ZFS_BINARY, args)
+	if err := rec.start(ctx); err != nil {
+		return nil, err
+	}
+	c, err := util.RunIOCommand(ctx, name, argv...)
+	if err != nil {
+		rec.done(err)
+		return nil, err
+	}
+	return &ioCommandStream{c, rec}, nil
+}
+
+func (s *ioCommandStream) Close() error {
+	err := s.IOCommand.Close()
+	if err == nil && s.ExitResult != nil {
+		err = s.ExitResult.Error
+	}
+	s.rec.done(err)
+	return err
+}
@@ -0,0 +1,40 @@
+package zfs
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestExecArgv(t *testing.T) {
+	tcs := []struct {
+		conf ExecConfig
+		argv []string
+	}{
+		{ExecConfig{}, []string{"zfs", "list"}},
+		{ExecConfig{Nice: 10}, []string{"nice", "-n", "10", "zfs", "list"}},
+		{ExecConfig{IONiceClass: "idle", IONiceLevel: 4}, []string{"ionice", "-c", "3", "zfs", "list"}},
+		{ExecConfig{Nice: 5, IONiceClass: "best-effort", IONiceLevel: 7}, []string{"nice", "-n", "5", "ionice", "-c", "2", "-n", "7", "zfs", "list"}},
+	}
+	for _, tc := range tcs {
+		name, args := execArgv(tc.conf, "zfs", []string{"list"})
+		assert.Equal(t, tc.argv, append([]string{name}, args...))
+	}
+}
+
+func TestExecConfigValidate(t *testing.T) {
+	assert.NoError(t, ExecConfig{}.Validate())
+	assert.NoError(t, ExecConfig{IONiceClass: "realtime", IONiceLevel: 0, MaxConcurrent: 2}.Validate())
+	assert.Error(t, ExecConfig{IONiceClass: "background"}.Validate())
+	assert.Error(t, ExecConfig{IONiceClass: "best-effort", IONiceLevel: 8}.Validate())
+	assert.Error(t, ExecConfig{MaxConcurrent: -1}.Validate())
+	assert.Error(t, ExecConfig{DestroyBatchSize: -1}.Validate())
+}
+
+func TestExecConfigValidateLZC(t *testing.T) {
+	err := ExecConfig{LZC: true}.Validate()
+	if lzcCompiledIn {
+		assert.NoError(t, err)
+	} else {
+		assert.Equal(t, errLZCNotCompiledIn, err)
+	}
+}
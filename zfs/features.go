@@ -0,0 +1,141 @@
+package zfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ZPOOL_BINARY string = "zpool"
+
+// ZPoolFeatureEnabled returns true if feature (e.g. "large_blocks") is enabled or active on pool.
+// Features unknown to the installed ZFS version are reported as not enabled.
+func ZPoolFeatureEnabled(pool, feature string) (bool, error) {
+	cmd := zpoolCmd(context.Background(), "get", "-H", "-o", "value", "feature@"+feature, pool)
+	stderr := bytes.NewBuffer(make([]byte, 0, 1024))
+	cmd.Stderr = stderr
+	stdout, err := cmd.Output()
+	if err != nil {
+		if bytes.Contains(stderr.Bytes(), []byte("invalid property")) {
+			return false, nil
+		}
+		return false, ZFSError{Stderr: stderr.Bytes(), WaitErr: err}
+	}
+	return parseZPoolFeatureValue(feature, stdout)
+}
+
+func parseZPoolFeatureValue(feature string, out []byte) (bool, error) {
+	switch v := strings.TrimSpace(string(out)); v {
+	case "enabled", "active":
+		return true, nil
+	case "disabled", "-":
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected value %q of pool feature %q", v, feature)
+	}
+}
+
+var zpoolStatusScanRegex = regexp.MustCompile(`(?m)^\s*scan: (scrub|resilver) in progress`)
+
+// ZPoolScanActivity returns "scrub" or "resilver" if one is in progress on pool, and "" otherwise.
+func ZPoolScanActivity(ctx context.Context, pool string) (string, error) {
+	cmd := zpoolCmd(ctx, "status", pool)
+	stderr := bytes.NewBuffer(make([]byte, 0, 1024))
+	cmd.Stderr = stderr
+	stdout, err := cmd.Output()
+	if err != nil {
+		return "", ZFSError{Stderr: stderr.Bytes(), WaitErr: err}
+	}
+	return parseZPoolScanActivity(stdout), nil
+}
+
+func parseZPoolScanActivity(zpoolStatus []byte) string {
+	m := zpoolStatusScanRegex.FindSubmatch(zpoolStatus)
+	if m == nil {
+		return ""
+	}
+	return string(m[1])
+}
+
+// StreamFeatures are the properties of a send stream that the receiving pool must support.
+type StreamFeatures struct {
+	Raw          bool // zfs send -w
+	Compressed   bool // zfs send -c
+	LargeBlocks  bool // zfs send -L
+	EmbeddedData bool // zfs send -e
+}
+
+// SendArgs returns the zfs send flags that produce a stream with features f.
+func (f StreamFeatures) SendArgs() []string {
+	args := make([]string, 0, 4)
+	if f.Raw {
+		args = append(args, "-w")
+	}
+	if f.Compressed {
+		args = append(args, "-c")
+	}
+	if f.LargeBlocks {
+		args = append(args, "-L")
+	}
+	if f.EmbeddedData {
+		args = append(args, "-e")
+	}
+	return args
+}
+
+// CheckPoolSupport returns an error that names the first feature of f that pool does not support.
+func (f StreamFeatures) CheckPoolSupport(pool string) error {
+	required := []struct {
+		used                bool
+		option, poolFeature string
+	}{
+		{f.Raw, "raw", "encryption"},
+		{f.Compressed, "compressed", "lz4_compress"},
+		{f.LargeBlocks, "large_blocks", "large_blocks"},
+		{f.EmbeddedData, "embedded_data", "embedded_data"},
+	}
+	for _, r := range required {
+		if !r.used {
+			continue
+		}
+		enabled, err := ZPoolFeatureEnabled(pool, r.poolFeature)
+		if err != nil {
+			return err
+		}
+		if !enabled {
+			return fmt.Errorf("pool %q does not support %s streams (feature@%s is not enabled)", pool, r.option, r.poolFeature)
+		}
+	}
+	return nil
+}
+
+// ZPoolSpace returns the size of pool and the space allocated in it, in bytes.
+func ZPoolSpace(pool string) (size, allocated int64, err error) {
+	cmd := zpoolCmd(context.Background(), "get", "-Hp", "-o", "value", "size,allocated", pool)
+	stderr := bytes.NewBuffer(make([]byte, 0, 1024))
+	cmd.Stderr = stderr
+	stdout, err := cmd.Output()
+	if err != nil {
+		return 0, 0, ZFSError{Stderr: stderr.Bytes(), WaitErr: err}
+	}
+	return parseZPoolSpace(stdout)
+}
+
+func parseZPoolSpace(out []byte) (size, allocated int64, err error) {
+	lines := strings.Fields(string(out))
+	if len(lines) != 2 {
+		return 0, 0, fmt.Errorf("unexpected pool space %q", out)
+	}
+	size, err = strconv.ParseInt(lines[0], 10, 64)
+	if err != nil || size <= 0 {
+		return 0, 0, fmt.Errorf("unexpected pool size %q", lines[0])
+	}
+	allocated, err = strconv.ParseInt(lines[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected allocated pool space %q", lines[1])
+	}
+	return size, allocated, nil
+}
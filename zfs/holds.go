@@ -0,0 +1,197 @@
+package zfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+func zfsHoldCmd(args ...string) error {
+	cmd := zfsCmd(context.Background(), args...)
+	stderr := bytes.NewBuffer(make([]byte, 0, 1024))
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return ZFSError{
+			Stderr:  stderr.Bytes(),
+			WaitErr: err,
+		}
+	}
+	return nil
+}
+
+// ZFSHold places the hold tag on fs@snapname, which prevents the snapshot from being destroyed.
+// Holding a snapshot that already has the tag is not an error.
+func ZFSHold(fs *DatasetPath, snapname, tag string) error {
+	snap := zfsBuildSnapName(fs, snapname)
+	var err error
+	if lzcEnabled() {
+		err = runLZC("hold", snap, func() error { return lzcHold(snap, tag) })
+	} else {
+		err = zfsHoldCmd("hold", tag, snap)
+	}
+	if zfsErr, ok := err.(ZFSError); ok && bytes.Contains(zfsErr.Stderr, []byte("tag already exists")) {
+		return nil
+	}
+	return err
+}
+
+// ZFSRelease removes the hold tag from fs@snapname.
+// Releasing a tag that the snapshot does not have is not an error.
+func ZFSRelease(fs *DatasetPath, snapname, tag string) error {
+	snap := zfsBuildSnapName(fs, snapname)
+	var err error
+	if lzcEnabled() {
+		err = runLZC("release", snap, func() error { return lzcRelease(snap, tag) })
+	} else {
+		err = zfsHoldCmd("release", tag, snap)
+	}
+	if zfsErr, ok := err.(ZFSError); ok && bytes.Contains(zfsErr.Stderr, []byte("no such tag")) {
+		return nil
+	}
+	return err
+}
+
+// parseZFSHoldsOutput parses the output of `zfs holds -H` and returns the names (without @)
+// of the snapshots of fs that have tag, sorted.
+func parseZFSHoldsOutput(fs string, output []byte, tag string) ([]string, error) {
+	byFS, err := parseZFSHoldsOutputByFS(output, tag)
+	if err != nil {
+		return nil, err
+	}
+	for other := range byFS {
+		if other != fs {
+			return nil, fmt.Errorf("zfs holds output contains snapshots of %q, which does not belong to %q", other, fs)
+		}
+	}
+	return byFS[fs], nil
+}
+
+// parseZFSHoldsOutputByFS parses the output of `zfs holds -H` and returns the names (without @)
+// of the snapshots that have tag, sorted, by filesystem.
+func parseZFSHoldsOutputByFS(output []byte, tag string) (map[string][]string, error) {
+	snaps := make(map[string][]string)
+	s := bufio.NewScanner(bytes.NewReader(output))
+	for s.Scan() {
+		if s.Text() == "" {
+			continue
+		}
+		fields := strings.Split(s.Text(), "\t")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected zfs holds output line %q", s.Text())
+		}
+		if fields[1] != tag {
+			continue
+		}
+		at := strings.Index(fields[0], "@")
+		if at == -1 {
+			return nil, fmt.Errorf("zfs holds output line %q does not name a snapshot", s.Text())
+		}
+		fs := fields[0][:at]
+		snaps[fs] = append(snaps[fs], fields[0][at+1:])
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	for _, l := range snaps {
+		sort.Strings(l)
+	}
+	return snaps, nil
+}
+
+// zfsHolds runs `zfs holds -H` on the snapshots listed by `zfs list -t snapshot` with listArgs that have user references,
+// and returns its output, which is empty if there are none.
+func zfsHolds(listArgs ...string) ([]byte, error) {
+	// only snapshots with user references have holds
+	args := append([]string{"list", "-H", "-p", "-o", "name,userrefs", "-t", "snapshot"}, listArgs...)
+	cmd := zfsCmd(context.Background(), args...)
+	stderr := bytes.NewBuffer(make([]byte, 0, 1024))
+	cmd.Stderr = stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, ZFSError{
+			Stderr:  stderr.Bytes(),
+			WaitErr: err,
+		}
+	}
+	held := []string{"holds", "-H"}
+	s := bufio.NewScanner(bytes.NewReader(output))
+	for s.Scan() {
+		fields := strings.Split(s.Text(), "\t")
+		if len(fields) == 2 && fields[1] != "0" {
+			held = append(held, fields[0])
+		}
+	}
+	if len(held) == 2 {
+		return nil, nil
+	}
+
+	cmd = zfsCmd(context.Background(), held...)
+	stderr.Reset()
+	cmd.Stderr = stderr
+	output, err = cmd.Output()
+	if err != nil {
+		return nil, ZFSError{
+			Stderr:  stderr.Bytes(),
+			WaitErr: err,
+		}
+	}
+	return output, nil
+}
+
+// ZFSListHolds returns the names (without @) of the snapshots of fs that have the hold tag.
+func ZFSListHolds(fs *DatasetPath, tag string) ([]string, error) {
+	output, err := zfsHolds("-d", "1", fs.ToString())
+	if err != nil {
+		return nil, err
+	}
+	return parseZFSHoldsOutput(fs.ToString(), output, tag)
+}
+
+// ZFSListAllHolds returns the names (without @) of the snapshots of all filesystems that have the hold tag, by filesystem.
+func ZFSListAllHolds(tag string) (map[string][]string, error) {
+	output, err := zfsHolds()
+	if err != nil {
+		return nil, err
+	}
+	return parseZFSHoldsOutputByFS(output, tag)
+}
+
+// parseZFSHoldTagsOutput parses the output of `zfs holds -H snap` and returns the hold tags of snap, sorted.
+func parseZFSHoldTagsOutput(snap string, output []byte) ([]string, error) {
+	var tags []string
+	s := bufio.NewScanner(bytes.NewReader(output))
+	for s.Scan() {
+		if s.Text() == "" {
+			continue
+		}
+		fields := strings.Split(s.Text(), "\t")
+		if len(fields) != 3 || fields[0] != snap {
+			return nil, fmt.Errorf("unexpected zfs holds output line %q", s.Text())
+		}
+		tags = append(tags, fields[1])
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// ZFSHoldTags returns the tags of all holds on fs@snapname, including those not placed by zrepl.
+func ZFSHoldTags(fs *DatasetPath, snapname string) ([]string, error) {
+	snap := zfsBuildSnapName(fs, snapname)
+	cmd := zfsCmd(context.Background(), "holds", "-H", snap)
+	stderr := bytes.NewBuffer(make([]byte, 0, 1024))
+	cmd.Stderr = stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, ZFSError{
+			Stderr:  stderr.Bytes(),
+			WaitErr: err,
+		}
+	}
+	return parseZFSHoldTagsOutput(snap, output)
+}
@@ -0,0 +1,54 @@
+package zfs
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseZFSHoldsOutput(t *testing.T) {
+	out := "pool/a@s2\tzrepl_step_prod\tThu Oct 15 10:00 2026\n" +
+		"pool/a@s1\tzrepl_step_prod\tThu Oct 15 10:00 2026\n" +
+		"pool/a@s1\tmanual\tThu Oct 15 09:00 2026\n" +
+		"pool/a@s3\tzrepl_step_other\tThu Oct 15 10:00 2026\n"
+	snaps, err := parseZFSHoldsOutput("pool/a", []byte(out), "zrepl_step_prod")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"s1", "s2"}, snaps)
+
+	snaps, err = parseZFSHoldsOutput("pool/a", nil, "zrepl_step_prod")
+	require.NoError(t, err)
+	assert.Empty(t, snaps)
+
+	_, err = parseZFSHoldsOutput("pool/a", []byte("pool/a@s1 zrepl_step_prod\n"), "zrepl_step_prod")
+	assert.Error(t, err)
+	_, err = parseZFSHoldsOutput("pool/a", []byte("pool/b@s1\tzrepl_step_prod\tnow\n"), "zrepl_step_prod")
+	assert.Error(t, err)
+}
+
+func TestParseZFSHoldTagsOutput(t *testing.T) {
+	out := "pool/a@s1\tzrepl_step_prod\tThu Oct 15 10:00 2026\n" +
+		"pool/a@s1\tbackup_export\tThu Oct 15 09:00 2026\n"
+	tags, err := parseZFSHoldTagsOutput("pool/a@s1", []byte(out))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"backup_export", "zrepl_step_prod"}, tags)
+
+	tags, err = parseZFSHoldTagsOutput("pool/a@s1", nil)
+	require.NoError(t, err)
+	assert.Empty(t, tags)
+
+	_, err = parseZFSHoldTagsOutput("pool/a@s1", []byte("pool/a@s2\tmanual\tnow\n"))
+	assert.Error(t, err)
+}
+
+func TestParseZFSHoldsOutputByFS(t *testing.T) {
+	out := "pool/a@s2\tzrepl_step_prod\tThu Oct 15 10:00 2026\n" +
+		"pool/a@s1\tzrepl_step_prod\tThu Oct 15 10:00 2026\n" +
+		"pool/b/c@s1\tzrepl_step_prod\tThu Oct 15 10:00 2026\n" +
+		"pool/b/c@s2\tbackup_export\tThu Oct 15 09:00 2026\n"
+	byFS, err := parseZFSHoldsOutputByFS([]byte(out), "zrepl_step_prod")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"pool/a": {"s1", "s2"}, "pool/b/c": {"s1"}}, byFS)
+
+	_, err = parseZFSHoldsOutputByFS([]byte("pool/a\tzrepl_step_prod\tnow\n"), "zrepl_step_prod")
+	assert.Error(t, err)
+}
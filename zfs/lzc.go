@@ -0,0 +1,70 @@
+package zfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// The libzfs_core backend (ExecConfig.LZC) runs the operations that jobs issue for many datasets
+// every few minutes in-process instead of forking a zfs command for each of them:
+// snapshot, bookmark, hold, release and the destruction of snapshots and bookmarks.
+// It is only available in binaries built with the lzc build tag (see lzc_cgo.go),
+// everything else is always done by the zfs command.
+
+var errLZCNotCompiledIn = errors.New("zrepl was built without libzfs_core support (build tag lzc)")
+
+func lzcEnabled() bool {
+	execState.mtx.RLock()
+	defer execState.mtx.RUnlock()
+	return execState.config.LZC
+}
+
+// runLZC logs and measures the libzfs_core call f like a zfs command and converts its errno to a ZFSError.
+func runLZC(op, dataset string, f func() error) error {
+	execState.mtx.RLock()
+	log := execState.config.Logger
+	execState.mtx.RUnlock()
+
+	rec := &cmdRecord{
+		command: "lzc " + op,
+		argv:    []string{"lzc_" + op, dataset},
+		log:     log,
+	}
+	if err := rec.start(context.Background()); err != nil {
+		return err
+	}
+	err := f()
+	rec.done(err)
+	if err == nil {
+		return nil
+	}
+	return lzcError(op, dataset, err)
+}
+
+// lzcError returns a ZFSError whose Stderr matches the message of the zfs command for the same error,
+// so that callers that inspect the Stderr of a ZFSError work with both backends.
+func lzcError(op, dataset string, err error) error {
+	msg := err.Error()
+	if errno, ok := err.(syscall.Errno); ok {
+		switch {
+		case errno == syscall.EEXIST && op == "hold":
+			msg = "tag already exists on this dataset"
+		case errno == syscall.ESRCH && op == "release":
+			msg = "no such tag on this dataset"
+		case errno == syscall.ENOENT:
+			msg = "dataset does not exist"
+		case errno == syscall.EEXIST && op == "destroy":
+			msg = "snapshot has dependent clones"
+		case errno == syscall.EBUSY:
+			msg = "dataset is busy"
+		case errno == syscall.EEXIST:
+			msg = "dataset already exists"
+		}
+	}
+	return ZFSError{
+		Stderr:  []byte(fmt.Sprintf("cannot %s '%s': %s\n", op, dataset, msg)),
+		WaitErr: err,
+	}
+}
@@ -0,0 +1,140 @@
+//go:build lzc
+// +build lzc
+
+package zfs
+
+// Build with `go build -tags lzc`, which requires cgo and the headers and libraries of libzfs_core and libnvpair.
+// The include paths below are those of the OpenZFS packages of Linux distributions,
+// use CGO_CFLAGS for other locations.
+
+/*
+#cgo CFLAGS: -I/usr/include/libzfs -I/usr/include/libspl -I/usr/local/include/libzfs -I/usr/local/include/libspl
+#cgo LDFLAGS: -lzfs_core -lnvpair
+#include <stdlib.h>
+#include <libnvpair.h>
+#include <libzfs_core.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const lzcCompiledIn = true
+
+var lzcInitState struct {
+	once sync.Once
+	err  error
+}
+
+// lzcInit opens /dev/zfs for all later libzfs_core calls of the process.
+func lzcInit() error {
+	lzcInitState.once.Do(func() {
+		if rc := C.libzfs_core_init(); rc != 0 {
+			lzcInitState.err = fmt.Errorf("cannot initialize libzfs_core: %s", syscall.Errno(rc))
+		}
+	})
+	return lzcInitState.err
+}
+
+func lzcNvlistBooleans(names []string) *C.nvlist_t {
+	nvl := C.fnvlist_alloc()
+	for _, name := range names {
+		cname := C.CString(name)
+		C.fnvlist_add_boolean(nvl, cname)
+		C.free(unsafe.Pointer(cname))
+	}
+	return nvl
+}
+
+func lzcNvlistStrings(m map[string]string) *C.nvlist_t {
+	nvl := C.fnvlist_alloc()
+	for k, v := range m {
+		ck, cv := C.CString(k), C.CString(v)
+		C.fnvlist_add_string(nvl, ck, cv)
+		C.free(unsafe.Pointer(ck))
+		C.free(unsafe.Pointer(cv))
+	}
+	return nvl
+}
+
+// lzcNvlistPairs returns the pairs of nvl, with the empty string as the value of booleans and nvlists.
+func lzcNvlistPairs(nvl *C.nvlist_t) map[string]string {
+	pairs := make(map[string]string)
+	for p := C.nvlist_next_nvpair(nvl, nil); p != nil; p = C.nvlist_next_nvpair(nvl, p) {
+		name := C.GoString(C.nvpair_name(p))
+		pairs[name] = ""
+		if C.nvpair_type(p) == C.DATA_TYPE_STRING {
+			pairs[name] = C.GoString(C.fnvpair_value_string(p))
+		}
+	}
+	return pairs
+}
+
+func lzcNvlistFree(nvl *C.nvlist_t) {
+	C.nvlist_free(nvl)
+}
+
+// lzcResult frees the errlist of a libzfs_core call and returns its return code as a syscall.Errno.
+func lzcResult(rc C.int, errlist *C.nvlist_t) error {
+	if errlist != nil {
+		C.nvlist_free(errlist)
+	}
+	if rc != 0 {
+		return syscall.Errno(rc)
+	}
+	return nil
+}
+
+// lzcSnapshot atomically creates snapshots, which must all be in the same pool.
+func lzcSnapshot(snapshots []string) error {
+	snaps := lzcNvlistBooleans(snapshots)
+	defer C.nvlist_free(snaps)
+	var errlist *C.nvlist_t
+	return lzcResult(C.lzc_snapshot(snaps, nil, &errlist), errlist)
+}
+
+func lzcDestroySnapshots(snapshots []string) error {
+	snaps := lzcNvlistBooleans(snapshots)
+	defer C.nvlist_free(snaps)
+	var errlist *C.nvlist_t
+	return lzcResult(C.lzc_destroy_snaps(snaps, C.B_FALSE, &errlist), errlist)
+}
+
+func lzcDestroyBookmarks(bookmarks []string) error {
+	bmarks := lzcNvlistBooleans(bookmarks)
+	defer C.nvlist_free(bmarks)
+	var errlist *C.nvlist_t
+	return lzcResult(C.lzc_destroy_bookmarks(bmarks, &errlist), errlist)
+}
+
+// lzcBookmark creates the bookmarks, which are the keys of bookmarks, of the snapshots that are their values.
+func lzcBookmark(bookmarks map[string]string) error {
+	bmarks := lzcNvlistStrings(bookmarks)
+	defer C.nvlist_free(bmarks)
+	var errlist *C.nvlist_t
+	return lzcResult(C.lzc_bookmark(bmarks, &errlist), errlist)
+}
+
+// lzcHold places a user hold like zfs hold, i.e. one that outlives the process.
+func lzcHold(snapshot, tag string) error {
+	holds := lzcNvlistStrings(map[string]string{snapshot: tag})
+	defer C.nvlist_free(holds)
+	var errlist *C.nvlist_t
+	return lzcResult(C.lzc_hold(holds, -1, &errlist), errlist)
+}
+
+func lzcRelease(snapshot, tag string) error {
+	tags := lzcNvlistBooleans([]string{tag})
+	defer C.nvlist_free(tags)
+	holds := C.fnvlist_alloc()
+	defer C.nvlist_free(holds)
+	csnap := C.CString(snapshot)
+	C.fnvlist_add_nvlist(holds, csnap, tags)
+	C.free(unsafe.Pointer(csnap))
+	var errlist *C.nvlist_t
+	return lzcResult(C.lzc_release(holds, &errlist), errlist)
+}
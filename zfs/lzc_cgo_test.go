@@ -0,0 +1,85 @@
+//go:build lzc
+// +build lzc
+
+package zfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLZCNvlists(t *testing.T) {
+	bools := lzcNvlistBooleans([]string{"pool/a@1", "pool/b@1"})
+	defer lzcNvlistFree(bools)
+	assert.Equal(t, map[string]string{"pool/a@1": "", "pool/b@1": ""}, lzcNvlistPairs(bools))
+
+	strs := lzcNvlistStrings(map[string]string{"pool/a#1": "pool/a@1", "pool/b#1": "pool/b@1"})
+	defer lzcNvlistFree(strs)
+	assert.Equal(t, map[string]string{"pool/a#1": "pool/a@1", "pool/b#1": "pool/b@1"}, lzcNvlistPairs(strs))
+}
+
+// lzcTestFilesystem returns the filesystem named by ZREPL_TEST_LZC_FS,
+// in which TestLZCOperations creates and destroys snapshots, or skips t.
+func lzcTestFilesystem(t *testing.T) *DatasetPath {
+	name := os.Getenv("ZREPL_TEST_LZC_FS")
+	if name == "" {
+		t.Skip("set ZREPL_TEST_LZC_FS to a scratch filesystem to test the libzfs_core backend")
+	}
+	if _, err := os.Stat("/dev/zfs"); err != nil {
+		t.Skipf("no ZFS on this host: %s", err)
+	}
+	fs, err := NewDatasetPath(name)
+	require.NoError(t, err)
+	return fs
+}
+
+func TestLZCOperations(t *testing.T) {
+	fs := lzcTestFilesystem(t)
+	require.NoError(t, SetExecConfig(ExecConfig{LZC: true}))
+	defer SetExecConfig(ExecConfig{})
+
+	snap := fmt.Sprintf("zrepl_lzc_test_%d", os.Getpid())
+	snapname := zfsBuildSnapName(fs, snap)
+	require.NoError(t, zfsSnapshot([]string{snapname}))
+	defer ZFSDestroy(snapname)
+
+	// errors look like those of the zfs command
+	err := zfsSnapshot([]string{snapname})
+	require.IsType(t, ZFSError{}, err)
+	assert.Equal(t, syscall.EEXIST, err.(ZFSError).WaitErr)
+	assert.True(t, bytes.Contains(err.(ZFSError).Stderr, []byte("dataset already exists")), "%s", err)
+
+	require.NoError(t, ZFSHold(fs, snap, "zrepl_lzc_test"))
+	require.NoError(t, ZFSHold(fs, snap, "zrepl_lzc_test"), "holding twice is not an error")
+	err = ZFSDestroy(snapname)
+	require.IsType(t, ZFSError{}, err)
+	assert.Equal(t, syscall.EBUSY, err.(ZFSError).WaitErr)
+	require.NoError(t, ZFSRelease(fs, snap, "zrepl_lzc_test"))
+	require.NoError(t, ZFSRelease(fs, snap, "zrepl_lzc_test"), "releasing twice is not an error")
+
+	require.NoError(t, ZFSBookmark(fs, snap, snap))
+	bookmarkname := zfsBuildBookmarkName(fs, snap)
+	versions, err := ZFSListFilesystemVersions(fs, nil)
+	require.NoError(t, err)
+	var names []string
+	for _, v := range versions {
+		names = append(names, v.ToAbsPath(fs))
+	}
+	assert.Contains(t, names, snapname)
+	assert.Contains(t, names, bookmarkname)
+
+	require.NoError(t, ZFSDestroy(bookmarkname))
+	require.NoError(t, ZFSDestroy(snapname))
+	versions, err = ZFSListFilesystemVersions(fs, nil)
+	require.NoError(t, err)
+	for _, v := range versions {
+		assert.NotEqual(t, snapname, v.ToAbsPath(fs))
+		assert.NotEqual(t, bookmarkname, v.ToAbsPath(fs))
+	}
+}
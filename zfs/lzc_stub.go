@@ -0,0 +1,14 @@
+//go:build !lzc
+// +build !lzc
+
+package zfs
+
+const lzcCompiledIn = false
+
+func lzcInit() error                                { return errLZCNotCompiledIn }
+func lzcSnapshot(snapshots []string) error          { return errLZCNotCompiledIn }
+func lzcDestroySnapshots(snapshots []string) error  { return errLZCNotCompiledIn }
+func lzcDestroyBookmarks(bookmarks []string) error  { return errLZCNotCompiledIn }
+func lzcBookmark(bookmarks map[string]string) error { return errLZCNotCompiledIn }
+func lzcHold(snapshot, tag string) error            { return errLZCNotCompiledIn }
+func lzcRelease(snapshot, tag string) error         { return errLZCNotCompiledIn }
@@ -0,0 +1,32 @@
+package zfs
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"syscall"
+	"testing"
+)
+
+func TestLZCErrorMatchesZFSCommandStderr(t *testing.T) {
+	tcs := []struct {
+		op     string
+		errno  syscall.Errno
+		stderr string
+	}{
+		{"hold", syscall.EEXIST, "tag already exists"},
+		{"release", syscall.ESRCH, "no such tag"},
+		{"destroy", syscall.ENOENT, "dataset does not exist"},
+		{"destroy", syscall.EBUSY, "dataset is busy"},
+		{"destroy", syscall.EEXIST, "snapshot has dependent clones"},
+		{"snapshot", syscall.EEXIST, "dataset already exists"},
+	}
+	for _, tc := range tcs {
+		err := lzcError(tc.op, "pool/fs@snap", tc.errno)
+		zfsErr, ok := err.(ZFSError)
+		if !assert.True(t, ok) {
+			continue
+		}
+		assert.True(t, bytes.Contains(zfsErr.Stderr, []byte(tc.stderr)), "%s: %q", tc.op, zfsErr.Stderr)
+		assert.Equal(t, tc.errno, zfsErr.WaitErr)
+	}
+}
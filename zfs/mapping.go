@@ -10,7 +10,12 @@ type DatasetFilter interface {
 }
 
 func ZFSListMapping(filter DatasetFilter) (datasets []*DatasetPath, err error) {
-	res, err := ZFSListMappingProperties(filter, nil)
+	return ZFSListMappingContext(context.Background(), filter)
+}
+
+// ZFSListMappingContext is like ZFSListMapping but returns ctx.Err() if ctx is done before the listing is complete.
+func ZFSListMappingContext(ctx context.Context, filter DatasetFilter) (datasets []*DatasetPath, err error) {
+	res, err := ZFSListMappingPropertiesContext(ctx, filter, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -29,6 +34,10 @@ type ZFSListMappingPropertiesResult struct {
 
 // properties must not contain 'name'
 func ZFSListMappingProperties(filter DatasetFilter, properties []string) (datasets []ZFSListMappingPropertiesResult, err error) {
+	return ZFSListMappingPropertiesContext(context.Background(), filter, properties)
+}
+
+func ZFSListMappingPropertiesContext(ctx context.Context, filter DatasetFilter, properties []string) (datasets []ZFSListMappingPropertiesResult, err error) {
 
 	if filter == nil {
 		panic("filter must not be nil")
@@ -44,7 +53,7 @@ func ZFSListMappingProperties(filter DatasetFilter, properties []string) (datase
 	copy(newProps[1:], properties)
 	properties = newProps
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	rchan := make(chan ZFSListResult)
 
@@ -75,6 +84,10 @@ func ZFSListMappingProperties(filter DatasetFilter, properties []string) (datase
 		}
 
 	}
+	// ZFSListChan closes rchan without an error if ctx is done
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	return
 }
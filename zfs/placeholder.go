@@ -0,0 +1,161 @@
+package zfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ZFSSetPlaceholder marks (isPlaceholder == true) or unmarks the filesystem p as a placeholder.
+// Unmarking converts a placeholder into a regular filesystem, which zrepl then treats like a received one.
+func ZFSSetPlaceholder(p *DatasetPath, isPlaceholder bool) error {
+	if isPlaceholder {
+		props := NewZFSProperties()
+		props.Set(ZREPL_PLACEHOLDER_PROPERTY_NAME, PlaceholderPropertyValue(p))
+		return ZFSSet(p, props)
+	}
+
+	return ZFSInherit(p, ZREPL_PLACEHOLDER_PROPERTY_NAME)
+}
+
+// RemovablePlaceholders returns the placeholder filesystems strictly below root
+// whose children are all placeholders themselves, i.e. those that no longer lead to a received filesystem.
+// The result is ordered children first, so that it can be destroyed non-recursively in order.
+// state is the result of ZFSListFilesystemState.
+func RemovablePlaceholders(root *DatasetPath, state map[string]FilesystemState) ([]*DatasetPath, error) {
+	paths := make([]*DatasetPath, 0, len(state))
+	for name := range state {
+		p, err := NewDatasetPath(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filesystem name %q: %s", name, err)
+		}
+		if p.HasPrefix(root) && !p.Equal(root) {
+			paths = append(paths, p)
+		}
+	}
+	// children first, siblings in lexical order
+	sort.Slice(paths, func(i, j int) bool {
+		if paths[i].Length() != paths[j].Length() {
+			return paths[i].Length() > paths[j].Length()
+		}
+		return paths[i].ToString() < paths[j].ToString()
+	})
+
+	// a filesystem is removable if it is a placeholder and all of its children are removable
+	notRemovable := make(map[string]bool, len(paths))
+	removable := make([]*DatasetPath, 0)
+	for _, p := range paths {
+		name := p.ToString()
+		if state[name].Placeholder && !notRemovable[name] {
+			removable = append(removable, p)
+			continue
+		}
+		parent := strings.Join(p.comps[:len(p.comps)-1], "/")
+		notRemovable[parent] = true
+	}
+	return removable, nil
+}
+
+// ZFSListLocalPlaceholderMarks returns the value of the placeholder property of all filesystems and volumes
+// on which it is set locally, i.e. not inherited, by filesystem name.
+func ZFSListLocalPlaceholderMarks() (map[string]string, error) {
+	cmd := zfsCmd(context.Background(), "get", "-H", "-p", "-o", "name,value", "-s", "local",
+		"-t", "filesystem,volume", ZREPL_PLACEHOLDER_PROPERTY_NAME)
+	stderr := bytes.NewBuffer(make([]byte, 0, 1024))
+	cmd.Stderr = stderr
+	stdout, err := cmd.Output()
+	if err != nil {
+		return nil, ZFSError{
+			Stderr:  stderr.Bytes(),
+			WaitErr: err,
+		}
+	}
+	marks := make(map[string]string)
+	for _, line := range strings.Split(string(stdout), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("zfs get did not return name,value tuples: %q", line)
+		}
+		marks[fields[0]] = fields[1]
+	}
+	return marks, nil
+}
+
+// PlaceholderMarkFix describes how to convert the placeholder mark of Filesystem to the current format.
+type PlaceholderMarkFix struct {
+	Filesystem *DatasetPath
+	Old        string
+	// false if the mark must be removed
+	IsPlaceholder bool
+	Reason        string
+}
+
+var placeholderHashRegexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// OutdatedPlaceholderMarks returns the fixes for the locally set placeholder marks
+// (the result of ZFSListLocalPlaceholderMarks) that this version does not recognize:
+//
+//   - "on" and "off", written by releases that use a boolean mark, are converted to the hash or removed
+//   - the hash of another name, which remains after a placeholder is renamed with zfs rename, is recomputed
+//
+// Other values are reported as an error because their meaning is unknown.
+// Filesystems for which hasSnapshots returns true hold received data and are no placeholders,
+// marking them as such is refused with an error.
+// The result is sorted by filesystem name.
+func OutdatedPlaceholderMarks(marks map[string]string, hasSnapshots func(fs *DatasetPath) (bool, error)) ([]PlaceholderMarkFix, error) {
+	names := make([]string, 0, len(marks))
+	for name := range marks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fixes := make([]PlaceholderMarkFix, 0)
+	var unknown, withData []string
+	for _, name := range names {
+		p, err := NewDatasetPath(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filesystem name %q: %s", name, err)
+		}
+		v := marks[name]
+		fix := PlaceholderMarkFix{Filesystem: p, Old: v, IsPlaceholder: true}
+		switch {
+		case v == PlaceholderPropertyValue(p):
+			continue
+		case v == "on":
+			fix.Reason = "boolean placeholder mark"
+		case v == "off":
+			fix.IsPlaceholder = false
+			fix.Reason = "boolean placeholder mark"
+		case placeholderHashRegexp.MatchString(v):
+			fix.Reason = "placeholder mark of another filesystem name, was the placeholder renamed?"
+		default:
+			unknown = append(unknown, fmt.Sprintf("%s=%q", name, v))
+			continue
+		}
+		if fix.IsPlaceholder {
+			data, err := hasSnapshots(p)
+			if err != nil {
+				return nil, fmt.Errorf("cannot check whether %s has snapshots: %s", name, err)
+			}
+			if data {
+				withData = append(withData, fmt.Sprintf("%s=%q", name, v))
+				continue
+			}
+		}
+		fixes = append(fixes, fix)
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown %s values: %s", ZREPL_PLACEHOLDER_PROPERTY_NAME, strings.Join(unknown, ", "))
+	}
+	if len(withData) > 0 {
+		return nil, fmt.Errorf("refusing to mark filesystems with snapshots as placeholders, check and fix their %s manually: %s",
+			ZREPL_PLACEHOLDER_PROPERTY_NAME, strings.Join(withData, ", "))
+	}
+	return fixes, nil
+}
@@ -0,0 +1,99 @@
+package zfs
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRemovablePlaceholders(t *testing.T) {
+	state := map[string]FilesystemState{
+		"backup":        {Placeholder: false},
+		"backup/a":      {Placeholder: true},
+		"backup/a/b":    {Placeholder: true},
+		"backup/a/b/c":  {Placeholder: false}, // received
+		"backup/a/d":    {Placeholder: true},
+		"backup/e":      {Placeholder: true},
+		"backup/e/f":    {Placeholder: true},
+		"backup/e/f/g":  {Placeholder: true},
+		"backup2/h":     {Placeholder: true},
+		"backupother/i": {Placeholder: true},
+	}
+	root, err := NewDatasetPath("backup")
+	require.NoError(t, err)
+
+	removable, err := RemovablePlaceholders(root, state)
+	require.NoError(t, err)
+	names := make([]string, len(removable))
+	for i := range removable {
+		names[i] = removable[i].ToString()
+	}
+	assert.Equal(t, []string{"backup/e/f/g", "backup/a/d", "backup/e/f", "backup/e"}, names)
+}
+
+func TestOutdatedPlaceholderMarks(t *testing.T) {
+	mustPath := func(name string) *DatasetPath {
+		p, err := NewDatasetPath(name)
+		require.NoError(t, err)
+		return p
+	}
+	renamedFrom := PlaceholderPropertyValue(mustPath("backup/old"))
+	marks := map[string]string{
+		"backup/current": PlaceholderPropertyValue(mustPath("backup/current")),
+		"backup/on":      "on",
+		"backup/off":     "off",
+		"backup/renamed": renamedFrom,
+	}
+
+	noSnapshots := func(*DatasetPath) (bool, error) { return false, nil }
+	fixes, err := OutdatedPlaceholderMarks(marks, noSnapshots)
+	require.NoError(t, err)
+	require.Len(t, fixes, 3)
+	assert.Equal(t, "backup/off", fixes[0].Filesystem.ToString())
+	assert.False(t, fixes[0].IsPlaceholder)
+	assert.Equal(t, "backup/on", fixes[1].Filesystem.ToString())
+	assert.True(t, fixes[1].IsPlaceholder)
+	assert.Equal(t, "backup/renamed", fixes[2].Filesystem.ToString())
+	assert.True(t, fixes[2].IsPlaceholder)
+	assert.Equal(t, renamedFrom, fixes[2].Old)
+
+	marks["backup/unknown"] = "yes"
+	_, err = OutdatedPlaceholderMarks(marks, noSnapshots)
+	assert.Error(t, err)
+}
+
+func TestOutdatedPlaceholderMarksWithData(t *testing.T) {
+	marks := map[string]string{
+		"backup/on":      "on",
+		"backup/off":     "off",
+		"backup/renamed": PlaceholderPropertyValue(&DatasetPath{comps: []string{"backup", "old"}}),
+	}
+	hasSnapshots := func(withData ...string) func(*DatasetPath) (bool, error) {
+		return func(fs *DatasetPath) (bool, error) {
+			for _, d := range withData {
+				if fs.ToString() == d {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+	}
+
+	// a filesystem that received data is no placeholder, whatever its mark says
+	_, err := OutdatedPlaceholderMarks(marks, hasSnapshots("backup/renamed"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "backup/renamed")
+	assert.NotContains(t, err.Error(), "backup/on")
+	_, err = OutdatedPlaceholderMarks(marks, hasSnapshots("backup/on"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "backup/on")
+
+	// removing a mark is always safe
+	fixes, err := OutdatedPlaceholderMarks(marks, hasSnapshots("backup/off"))
+	require.NoError(t, err)
+	assert.Len(t, fixes, 3)
+
+	_, err = OutdatedPlaceholderMarks(marks, func(*DatasetPath) (bool, error) { return false, errors.New("zfs list failed") })
+	assert.Error(t, err)
+}
@@ -0,0 +1,260 @@
+package zfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Platform abstracts the differences between the ZFS implementations of the operating systems zrepl runs on.
+type Platform interface {
+	// e.g. "freebsd"
+	Name() string
+	// ProbeFeatures determines which zfs send and zfs recv flags the installed ZFS supports.
+	ProbeFeatures(ctx context.Context) (*PlatformFeatures, error)
+}
+
+// PlatformFeatures are the results of Platform.ProbeFeatures.
+type PlatformFeatures struct {
+	Platform string
+	// single-letter flags including the dash, e.g. "-w"
+	SendFlags, RecvFlags map[string]bool
+}
+
+// ResumableRecv returns true if interrupted receives can be resumed (zfs recv -s and zfs send -t).
+func (f *PlatformFeatures) ResumableRecv() bool {
+	return f.RecvFlags["-s"] && f.SendFlags["-t"]
+}
+
+func (f *PlatformFeatures) String() string {
+	flags := func(m map[string]bool) string {
+		l := make([]string, 0, len(m))
+		for flag := range m {
+			l = append(l, flag)
+		}
+		sort.Strings(l)
+		return strings.Join(l, " ")
+	}
+	return fmt.Sprintf("platform=%s send=[%s] recv=[%s]", f.Platform, flags(f.SendFlags), flags(f.RecvFlags))
+}
+
+type FlagNotSupportedError struct {
+	Platform, Subcommand, Flag string
+}
+
+func (e *FlagNotSupportedError) Error() string {
+	return fmt.Sprintf("zfs %s %s is not supported by the ZFS installed on this host (platform %s)", e.Subcommand, e.Flag, e.Platform)
+}
+
+// checkFlags returns a *FlagNotSupportedError for the first single-letter flag in args that is not in supported.
+// Option values, e.g. the name=value of -o, are ignored.
+func (f *PlatformFeatures) checkFlags(subcommand string, supported map[string]bool, args []string) error {
+	for _, a := range args {
+		if len(a) != 2 || a[0] != '-' {
+			continue
+		}
+		if !supported[a] {
+			return &FlagNotSupportedError{f.Platform, subcommand, a}
+		}
+	}
+	return nil
+}
+
+func (f *PlatformFeatures) CheckSendArgs(args []string) error {
+	return f.checkFlags("send", f.SendFlags, args)
+}
+
+func (f *PlatformFeatures) CheckRecvArgs(args []string) error {
+	return f.checkFlags("recv", f.RecvFlags, args)
+}
+
+var platforms = map[string]Platform{
+	"linux":   openZFSPlatform{},
+	"freebsd": freeBSDPlatform{},
+	"illumos": illumosPlatform{},
+	"solaris": illumosPlatform{},
+}
+
+// DetectPlatform returns the Platform of the operating system the daemon runs on,
+// falling back to the OpenZFS behavior for operating systems without a dedicated implementation.
+func DetectPlatform() Platform {
+	if p, ok := platforms[runtime.GOOS]; ok {
+		return p
+	}
+	return openZFSPlatform{}
+}
+
+var platformState struct {
+	mtx      sync.RWMutex
+	features *PlatformFeatures // nil if not probed
+}
+
+// ProbePlatform probes the features of p and makes the zfs send and recv wrappers of this package check against them.
+// Until ProbePlatform succeeds, all flags are assumed to be supported.
+func ProbePlatform(ctx context.Context, p Platform) (*PlatformFeatures, error) {
+	f, err := p.ProbeFeatures(ctx)
+	if err != nil {
+		return nil, err
+	}
+	platformState.mtx.Lock()
+	defer platformState.mtx.Unlock()
+	platformState.features = f
+	return f, nil
+}
+
+// probedFeatures returns the result of the last successful ProbePlatform, or nil.
+func probedFeatures() *PlatformFeatures {
+	platformState.mtx.RLock()
+	defer platformState.mtx.RUnlock()
+	return platformState.features
+}
+
+// resumableRecvSupported is true unless probing found that this host cannot resume receives,
+// in which case the receive_resume_token property does not exist.
+func resumableRecvSupported() bool {
+	f := probedFeatures()
+	return f == nil || f.ResumableRecv()
+}
+
+func checkSendArgs(args []string) error {
+	if f := probedFeatures(); f != nil {
+		return f.CheckSendArgs(args)
+	}
+	return nil
+}
+
+func checkRecvArgs(args []string) error {
+	if f := probedFeatures(); f != nil {
+		return f.CheckRecvArgs(args)
+	}
+	return nil
+}
+
+// The usage of a zfs subcommand lists its synopses on lines indented by a tab, e.g.
+//
+//	send [-DnPpRvLecwhb] [-[i|I] snapshot] <snapshot>
+//	send [-nvPe] -t <receive_resume_token>
+var usageFlagRegex = regexp.MustCompile(`(?:^|[\s\[|])-([a-zA-Z]+)`)
+
+// parseUsageFlags returns the single-letter flags in the synopses of subcommand (or one of its aliases) in usage.
+func parseUsageFlags(usage []byte, subcommand ...string) map[string]bool {
+	flags := make(map[string]bool)
+	isSynopsis := false
+	for _, l := range strings.Split(string(usage), "\n") {
+		fields := strings.Fields(l)
+		switch {
+		case len(fields) == 0 || !strings.HasPrefix(l, "\t"):
+			isSynopsis = false
+		case strings.HasPrefix(l, "\t ") || strings.HasPrefix(l, "\t\t"):
+			// continuation of a long synopsis, e.g. "\t    [-d | -e] <filesystem>"
+		default:
+			isSynopsis = false
+			for _, s := range subcommand {
+				isSynopsis = isSynopsis || fields[0] == s
+			}
+		}
+		if !isSynopsis {
+			continue
+		}
+		for _, m := range usageFlagRegex.FindAllStringSubmatch(l, -1) {
+			for _, c := range m[1] {
+				flags["-"+string(c)] = true
+			}
+		}
+	}
+	return flags
+}
+
+// zfsUsage returns the usage message that zfs prints for subcommand if invoked without arguments.
+func zfsUsage(ctx context.Context, subcommand string) ([]byte, error) {
+	cmd := zfsCmd(ctx, subcommand)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		return nil, err
+	}
+	// zfs exits with status 2 after printing the usage
+	if !bytes.Contains(stderr.Bytes(), []byte("usage:")) {
+		return nil, fmt.Errorf("cannot probe zfs %s flags, unexpected output: %q", subcommand, stderr.String())
+	}
+	return stderr.Bytes(), nil
+}
+
+func probeUsageFeatures(ctx context.Context, platform string) (*PlatformFeatures, error) {
+	send, err := zfsUsage(ctx, "send")
+	if err != nil {
+		return nil, err
+	}
+	recv, err := zfsUsage(ctx, "recv")
+	if err != nil {
+		return nil, err
+	}
+	return &PlatformFeatures{
+		Platform:  platform,
+		SendFlags: parseUsageFlags(send, "send"),
+		RecvFlags: parseUsageFlags(recv, "receive", "recv"),
+	}, nil
+}
+
+// openZFSPlatform is ZFS on Linux and OpenZFS, whose usage messages list all supported flags.
+type openZFSPlatform struct{}
+
+func (openZFSPlatform) Name() string { return "openzfs" }
+
+func (p openZFSPlatform) ProbeFeatures(ctx context.Context) (*PlatformFeatures, error) {
+	return probeUsageFeatures(ctx, p.Name())
+}
+
+// freeBSDPlatform is the ZFS of FreeBSD, whose userland may support resumable send and receive
+// while the kernel module does not, which is reported by the ioctl version sysctl.
+type freeBSDPlatform struct{}
+
+func (freeBSDPlatform) Name() string { return "freebsd" }
+
+var SYSCTL_BINARY string = "sysctl"
+
+// the first ZFS ioctl version with resumable send and receive
+const freeBSDResumeIoctlVersion = 7
+
+func (p freeBSDPlatform) ProbeFeatures(ctx context.Context) (*PlatformFeatures, error) {
+	f, err := probeUsageFeatures(ctx, p.Name())
+	if err != nil {
+		return nil, err
+	}
+	out, err := newCmd(ctx, SYSCTL_BINARY, []string{"-n", "vfs.zfs.version.ioctl"}).Output()
+	if err != nil {
+		// newer OpenZFS-based releases do not have the sysctl, their usage is accurate
+		return f, nil
+	}
+	restrictToIoctlVersion(f, out)
+	return f, nil
+}
+
+// restrictToIoctlVersion removes the flags of resumable send and receive from f unless out,
+// the output of the ioctl version sysctl, shows that the kernel module supports them.
+// An unparsable version is treated like one without support instead of failing the probe.
+func restrictToIoctlVersion(f *PlatformFeatures, out []byte) {
+	version, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil || version < freeBSDResumeIoctlVersion {
+		delete(f.RecvFlags, "-s")
+		delete(f.SendFlags, "-t")
+	}
+}
+
+// illumosPlatform is the ZFS of illumos distributions and Solaris.
+// Its zfs recv lacks some of the flags of OpenZFS, e.g. -h, which the usage message reflects.
+type illumosPlatform struct{}
+
+func (illumosPlatform) Name() string { return "illumos" }
+
+func (p illumosPlatform) ProbeFeatures(ctx context.Context) (*PlatformFeatures, error) {
+	return probeUsageFeatures(ctx, p.Name())
+}
@@ -0,0 +1,81 @@
+package zfs
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const openZFSRecvUsage = `usage:
+	receive [-vMnsFhu] [-o <property>=<value>] ... [-x <property>] ...
+	    <filesystem|volume|snapshot>
+	receive [-vMnsFhu] [-o <property>=<value>] ... [-x <property>] ...
+	    [-d | -e] <filesystem>
+	receive -A <filesystem|volume>
+
+For the property list, run: zfs help receive
+`
+
+const illumosSendUsage = `usage:
+	send [-DnPpRv] [-[iI] snapshot] <snapshot>
+	send [-Le] [-i snapshot|bookmark] <filesystem|volume|snapshot>
+	recv [-vnFu] <filesystem|volume|snapshot>
+`
+
+func TestParseUsageFlags(t *testing.T) {
+	recv := parseUsageFlags([]byte(openZFSRecvUsage), "receive", "recv")
+	for _, f := range []string{"-v", "-M", "-n", "-s", "-F", "-h", "-u", "-o", "-x", "-d", "-e", "-A"} {
+		assert.True(t, recv[f], "missing flag %s", f)
+	}
+	assert.Len(t, recv, 12)
+
+	send := parseUsageFlags([]byte(illumosSendUsage), "send")
+	for _, f := range []string{"-D", "-n", "-P", "-p", "-R", "-v", "-L", "-e", "-i"} {
+		assert.True(t, send[f], "missing flag %s", f)
+	}
+	assert.False(t, send["-F"], "flag of another subcommand")
+	assert.False(t, send["-w"])
+	assert.False(t, send["-t"])
+}
+
+func TestPlatformFeaturesCheckArgs(t *testing.T) {
+	f := &PlatformFeatures{
+		Platform:  "illumos",
+		SendFlags: map[string]bool{"-i": true, "-p": true},
+		RecvFlags: map[string]bool{"-s": true, "-o": true},
+	}
+	assert.NoError(t, f.CheckSendArgs([]string{"-p", "-i", "pool/fs@a", "pool/fs@b"}))
+	err := f.CheckSendArgs([]string{"-w", "pool/fs@b"})
+	if assert.IsType(t, &FlagNotSupportedError{}, err) {
+		assert.Equal(t, "-w", err.(*FlagNotSupportedError).Flag)
+	}
+	assert.NoError(t, f.CheckRecvArgs([]string{"-o", "canmount=off"}))
+	assert.Error(t, f.CheckRecvArgs([]string{"-h"}))
+	assert.False(t, f.ResumableRecv())
+}
+
+func TestRestrictToIoctlVersion(t *testing.T) {
+	features := func() *PlatformFeatures {
+		return &PlatformFeatures{
+			Platform:  "freebsd",
+			SendFlags: map[string]bool{"-t": true, "-i": true},
+			RecvFlags: map[string]bool{"-s": true, "-u": true},
+		}
+	}
+	for _, tc := range []struct {
+		out       string
+		resumable bool
+	}{
+		{"7\n", true},
+		{"15\n", true},
+		{"6\n", false},
+		{"", false},
+		{"unknown oid\n", false},
+	} {
+		f := features()
+		restrictToIoctlVersion(f, []byte(tc.out))
+		assert.Equal(t, tc.resumable, f.ResumableRecv(), "%q", tc.out)
+		// other flags are unaffected
+		assert.True(t, f.SendFlags["-i"])
+		assert.True(t, f.RecvFlags["-u"])
+	}
+}
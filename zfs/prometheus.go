@@ -7,6 +7,9 @@ var prom struct {
 	ZFSSnapshotDuration              *prometheus.HistogramVec
 	ZFSBookmarkDuration              *prometheus.HistogramVec
 	ZFSDestroyDuration               *prometheus.HistogramVec
+	ZFSCommandCount                  *prometheus.CounterVec
+	ZFSCommandDuration               *prometheus.HistogramVec
+	ZFSCommandWaitDuration           *prometheus.HistogramVec
 }
 
 func init() {
@@ -34,6 +37,24 @@ func init() {
 		Name:      "destroy_duration",
 		Help:      "Duration it took to destroy a dataset",
 	}, []string{"dataset_type", "filesystem"})
+	prom.ZFSCommandCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "zrepl",
+		Subsystem: "zfs",
+		Name:      "commands_total",
+		Help:      "Number of zfs and zpool commands that exited, by command and result",
+	}, []string{"command", "result"})
+	prom.ZFSCommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "zrepl",
+		Subsystem: "zfs",
+		Name:      "command_duration",
+		Help:      "Seconds a zfs or zpool command ran",
+	}, []string{"command"})
+	prom.ZFSCommandWaitDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "zrepl",
+		Subsystem: "zfs",
+		Name:      "command_wait_duration",
+		Help:      "Seconds a zfs or zpool command waited for the concurrency limit before it started",
+	}, []string{"command"})
 }
 
 func PrometheusRegister(registry prometheus.Registerer) error {
@@ -49,5 +70,14 @@ func PrometheusRegister(registry prometheus.Registerer) error {
 	if err := registry.Register(prom.ZFSDestroyDuration); err != nil {
 		return err
 	}
+	if err := registry.Register(prom.ZFSCommandCount); err != nil {
+		return err
+	}
+	if err := registry.Register(prom.ZFSCommandDuration); err != nil {
+		return err
+	}
+	if err := registry.Register(prom.ZFSCommandWaitDuration); err != nil {
+		return err
+	}
 	return nil
 }
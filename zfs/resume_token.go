@@ -48,9 +48,13 @@ func ParseResumeToken(ctx context.Context, token string) (*ResumeToken, error) {
 	//	toname = pool1/test@b
 	//cannot resume send: 'pool1/test@b' used in the initial send no longer exists
 
+	if !resumableRecvSupported() {
+		return nil, ResumeTokenDecodingNotSupported
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
 	defer cancel()
-	cmd := exec.CommandContext(ctx, ZFS_BINARY, "send", "-nvt", string(token))
+	cmd := zfsCmd(ctx, "send", "-nvt", string(token))
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
@@ -105,7 +109,12 @@ func ParseResumeToken(ctx context.Context, token string) (*ResumeToken, error) {
 
 }
 
+// ZFSGetReceiveResumeToken returns "" if fs has no resumable receive state
+// or the platform does not support resumable receives.
 func ZFSGetReceiveResumeToken(fs *DatasetPath) (string, error) {
+	if !resumableRecvSupported() {
+		return "", nil
+	}
 	const prop_receive_resume_token = "receive_resume_token"
 	props, err := ZFSGet(fs, []string{prop_receive_resume_token})
 	if err != nil {
@@ -119,3 +128,22 @@ func ZFSGetReceiveResumeToken(fs *DatasetPath) (string, error) {
 	}
 
 }
+
+// ZFSListReceiveResumeTokens returns the resume tokens of the filesystems and volumes at or below root
+// that have the state of an interrupted resumable receive (zfs recv -s), by filesystem name.
+func ZFSListReceiveResumeTokens(root *DatasetPath) (map[string]string, error) {
+	if !resumableRecvSupported() {
+		return map[string]string{}, nil
+	}
+	lines, err := ZFSList([]string{"name", "receive_resume_token"}, "-r", "-t", "filesystem,volume", root.ToString())
+	if err != nil {
+		return nil, err
+	}
+	tokens := make(map[string]string)
+	for _, l := range lines {
+		if l[1] != "-" && l[1] != "" {
+			tokens[l[0]] = l[1]
+		}
+	}
+	return tokens, nil
+}
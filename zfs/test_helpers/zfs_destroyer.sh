@@ -0,0 +1,10 @@
+#!/bin/sh
+# fails the destroy of snapshots named held, like zfs does for held snapshots,
+# and appends the destroyed datasets to $ZREPL_TEST_DESTROY_LOG
+echo "$2" >> "$ZREPL_TEST_DESTROY_LOG"
+case "$2" in
+*held*)
+    echo "cannot destroy snapshot $2: dataset is busy" 1>&2
+    exit 1
+    ;;
+esac
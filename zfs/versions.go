@@ -88,15 +88,21 @@ type FilesystemVersionFilter interface {
 }
 
 func ZFSListFilesystemVersions(fs *DatasetPath, filter FilesystemVersionFilter) (res []FilesystemVersion, err error) {
+	return ZFSListFilesystemVersionsContext(context.Background(), fs, filter)
+}
+
+// ZFSListFilesystemVersionsContext is like ZFSListFilesystemVersions but kills `zfs list` and returns ctx.Err()
+// if ctx is done before the listing is complete.
+func ZFSListFilesystemVersionsContext(ctx context.Context, fs *DatasetPath, filter FilesystemVersionFilter) (res []FilesystemVersion, err error) {
 	listResults := make(chan ZFSListResult)
 
 	promTimer := prometheus.NewTimer(prom.ZFSListFilesystemVersionDuration.WithLabelValues(fs.ToString()))
 	defer promTimer.ObserveDuration()
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	go ZFSListChan(ctx, listResults,
-		[]string{"name", "guid", "createtxg", "creation"},
+		filesystemVersionListProperties,
 		"-r", "-d", "1",
 		"-t", "bookmark,snapshot",
 		"-s", "createtxg", fs.ToString())
@@ -111,47 +117,180 @@ func ZFSListFilesystemVersions(fs *DatasetPath, filter FilesystemVersionFilter)
 			return nil, listResult.Err
 		}
 
-		line := listResult.Fields
-
-		var v FilesystemVersion
-
-		_, v.Type, v.Name, err = DecomposeVersionString(line[0])
+		_, v, accept, err := parseFilesystemVersion(listResult.Fields, filter)
 		if err != nil {
 			return nil, err
 		}
+		if accept {
+			res = append(res, v)
+		}
+
+	}
+	// ZFSListChan closes listResults without an error if ctx is done
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+var filesystemVersionListProperties = []string{"name", "guid", "createtxg", "creation"}
+
+// parseFilesystemVersion parses a line of `zfs list -o filesystemVersionListProperties`
+// and applies filter (if not nil) to the result.
+func parseFilesystemVersion(line []string, filter FilesystemVersionFilter) (fs string, v FilesystemVersion, accept bool, err error) {
+
+	fs, v.Type, v.Name, err = DecomposeVersionString(line[0])
+	if err != nil {
+		return
+	}
+
+	if v.Guid, err = strconv.ParseUint(line[1], 10, 64); err != nil {
+		err = errors.New(fmt.Sprintf("cannot parse GUID: %s", err.Error()))
+		return
+	}
+
+	if v.CreateTXG, err = strconv.ParseUint(line[2], 10, 64); err != nil {
+		err = errors.New(fmt.Sprintf("cannot parse CreateTXG: %s", err.Error()))
+		return
+	}
 
-		if v.Guid, err = strconv.ParseUint(line[1], 10, 64); err != nil {
-			err = errors.New(fmt.Sprintf("cannot parse GUID: %s", err.Error()))
+	creationUnix, err := strconv.ParseInt(line[3], 10, 64)
+	if err != nil {
+		err = fmt.Errorf("cannot parse creation date '%s': %s", line[3], err)
+		return
+	}
+	v.Creation = time.Unix(creationUnix, 0)
+
+	accept = true
+	if filter != nil {
+		accept, err = filter.Filter(v.Type, v.Name)
+		if err != nil {
+			err = fmt.Errorf("error executing filter: %s", err)
 			return
 		}
+	}
+	return
+}
 
-		if v.CreateTXG, err = strconv.ParseUint(line[2], 10, 64); err != nil {
-			err = errors.New(fmt.Sprintf("cannot parse CreateTXG: %s", err.Error()))
+type ZFSListFilesystemVersionsResult struct {
+	Filesystem *DatasetPath
+	// Sorted by CreateTXG, empty if the filesystem has no (matching) versions
+	Versions []FilesystemVersion
+	Err      error
+}
+
+// ZFSListFilesystemVersionsChan lists the versions of all filesystems in fss
+// using a single `zfs list -r` invocation per pool instead of one per filesystem,
+// and sends one result per filesystem to the `out` channel as soon as its pool has been listed.
+// Filesystems that do not exist are reported with empty Versions.
+//
+// The `out` channel is always closed by ZFSListFilesystemVersionsChan, with the same semantics
+// as the `out` channel of ZFSListChan.
+func ZFSListFilesystemVersionsChan(ctx context.Context, out chan ZFSListFilesystemVersionsResult, fss []*DatasetPath, filter FilesystemVersionFilter) {
+	defer close(out)
+
+	sendResult := func(res ZFSListFilesystemVersionsResult) (done bool) {
+		select {
+		case <-ctx.Done():
+			return true
+		case out <- res:
+			return false
+		}
+	}
+
+	// group by pool, preserving the order of fss
+	pools := make([]string, 0, 1)
+	byPool := make(map[string][]*DatasetPath)
+	for _, fs := range fss {
+		if fs.Length() == 0 {
+			sendResult(ZFSListFilesystemVersionsResult{Err: errors.New("empty filesystem not allowed")})
 			return
 		}
+		pool := fs.comps[0]
+		if _, ok := byPool[pool]; !ok {
+			pools = append(pools, pool)
+		}
+		byPool[pool] = append(byPool[pool], fs)
+	}
 
-		creationUnix, err := strconv.ParseInt(line[3], 10, 64)
-		if err != nil {
-			err = fmt.Errorf("cannot parse creation date '%s': %s", line[3], err)
-			return nil, err
-		} else {
-			v.Creation = time.Unix(creationUnix, 0)
+	for _, pool := range pools {
+
+		versions := make(map[string][]FilesystemVersion, len(byPool[pool]))
+		for _, fs := range byPool[pool] {
+			versions[fs.ToString()] = make([]FilesystemVersion, 0)
 		}
 
-		accept := true
-		if filter != nil {
-			accept, err = filter.Filter(v.Type, v.Name)
+		promTimer := prometheus.NewTimer(prom.ZFSListFilesystemVersionDuration.WithLabelValues(pool))
+		listCtx, cancel := context.WithCancel(ctx)
+		listResults := make(chan ZFSListResult)
+		go ZFSListChan(listCtx, listResults,
+			filesystemVersionListProperties,
+			"-r",
+			"-t", "bookmark,snapshot",
+			"-s", "createtxg", pool)
+
+		var err error
+		for listResult := range listResults {
+			if listResult.Err != nil {
+				err = listResult.Err
+				break
+			}
+			var fs string
+			var v FilesystemVersion
+			var accept bool
+			fs, v, accept, err = parseFilesystemVersion(listResult.Fields, filter)
 			if err != nil {
-				err = fmt.Errorf("error executing filter: %s", err)
-				return nil, err
+				break
+			}
+			if l, ok := versions[fs]; ok && accept {
+				versions[fs] = append(l, v)
 			}
 		}
-		if accept {
-			res = append(res, v)
+		cancel()
+		promTimer.ObserveDuration()
+		if err == io.ErrUnexpectedEOF {
+			// Like ZFSListFilesystemVersions, treat this like the pool doesn't exist
+			err = nil
+		}
+		if err != nil {
+			sendResult(ZFSListFilesystemVersionsResult{Err: err})
+			return
 		}
 
+		for _, fs := range byPool[pool] {
+			if sendResult(ZFSListFilesystemVersionsResult{Filesystem: fs, Versions: versions[fs.ToString()]}) {
+				return
+			}
+		}
 	}
-	return
+}
+
+// ZFSListFilesystemVersionsBatch collects the results of ZFSListFilesystemVersionsChan,
+// keyed by filesystem name.
+func ZFSListFilesystemVersionsBatch(fss []*DatasetPath, filter FilesystemVersionFilter) (map[string][]FilesystemVersion, error) {
+	return ZFSListFilesystemVersionsBatchContext(context.Background(), fss, filter)
+}
+
+// ZFSListFilesystemVersionsBatchContext is like ZFSListFilesystemVersionsBatch but kills `zfs list`
+// and returns ctx.Err() if ctx is done before the listing is complete.
+func ZFSListFilesystemVersionsBatchContext(ctx context.Context, fss []*DatasetPath, filter FilesystemVersionFilter) (map[string][]FilesystemVersion, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan ZFSListFilesystemVersionsResult)
+	go ZFSListFilesystemVersionsChan(ctx, results, fss, filter)
+
+	res := make(map[string][]FilesystemVersion, len(fss))
+	for r := range results {
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		res[r.Filesystem.ToString()] = r.Versions
+	}
+	// ZFSListFilesystemVersionsChan closes results without an error if ctx is done
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
 func ZFSDestroyFilesystemVersion(filesystem *DatasetPath, version *FilesystemVersion) (err error) {
@@ -0,0 +1,40 @@
+package zfs
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type snapshotsOnly struct{}
+
+func (snapshotsOnly) Filter(t VersionType, name string) (bool, error) {
+	return t == Snapshot, nil
+}
+
+func TestParseFilesystemVersion(t *testing.T) {
+
+	fs, v, accept, err := parseFilesystemVersion([]string{"pool/a/b@zrepl_1", "4711", "23", "1536000000"}, nil)
+	require.NoError(t, err)
+	assert.True(t, accept)
+	assert.Equal(t, "pool/a/b", fs)
+	assert.Equal(t, FilesystemVersion{
+		Type:      Snapshot,
+		Name:      "zrepl_1",
+		Guid:      4711,
+		CreateTXG: 23,
+		Creation:  time.Unix(1536000000, 0),
+	}, v)
+
+	fs, v, accept, err = parseFilesystemVersion([]string{"pool/a#zrepl_1", "4711", "23", "1536000000"}, snapshotsOnly{})
+	require.NoError(t, err)
+	assert.False(t, accept)
+	assert.Equal(t, "pool/a", fs)
+	assert.Equal(t, Bookmark, v.Type)
+
+	_, _, _, err = parseFilesystemVersion([]string{"pool/a@zrepl_1", "notaguid", "23", "1536000000"}, nil)
+	assert.Error(t, err)
+	_, _, _, err = parseFilesystemVersion([]string{"pool/a@zrepl_1", "4711", "23", "-"}, nil)
+	assert.Error(t, err)
+}
@@ -11,11 +11,11 @@ import (
 	"strings"
 
 	"context"
-	"github.com/problame/go-rwccmd"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/zrepl/zrepl/util"
 	"regexp"
+	"sort"
 	"strconv"
+	"time"
 )
 
 type DatasetPath struct {
@@ -155,7 +155,7 @@ func ZFSList(properties []string, zfsArgs ...string) (res [][]string, err error)
 		"-o", strings.Join(properties, ","))
 	args = append(args, zfsArgs...)
 
-	cmd := exec.Command(ZFS_BINARY, args...)
+	cmd := zfsCmd(context.Background(), args...)
 
 	var stdout io.Reader
 	stderr := bytes.NewBuffer(make([]byte, 0, 1024))
@@ -227,7 +227,7 @@ func ZFSListChan(ctx context.Context, out chan ZFSListResult, properties []strin
 		}
 	}
 
-	cmd, err := rwccmd.CommandContext(ctx, ZFS_BINARY, args, []string{})
+	cmd, err := zfsRWCCmd(ctx, args...)
 	if err != nil {
 		sendResult(nil, err)
 		return
@@ -286,13 +286,14 @@ func absVersion(fs, v string) (full string, err error) {
 	return fmt.Sprintf("%s%s", fs, v), nil
 }
 
-func buildCommonSendArgs(fs string, from, to string, token string) ([]string, error) {
-	args := make([]string, 0, 3)
+func buildCommonSendArgs(fs string, from, to string, token string, features StreamFeatures) ([]string, error) {
 	if token != "" {
-		args = append(args, "-t", token)
-		return args, nil
+		// the token encodes all other flags
+		return []string{"-t", token}, nil
 	}
 
+	args := features.SendArgs()
+
 	toV, err := absVersion(fs, to)
 	if err != nil {
 		return nil, err
@@ -315,22 +316,23 @@ func buildCommonSendArgs(fs string, from, to string, token string) ([]string, er
 }
 
 // if token != "", then send -t token is used
-// otherwise send [-i from] to is used
+// otherwise send [flags for features] [-i from] to is used
 // (if from is "" a full ZFS send is done)
-func ZFSSend(ctx context.Context, fs string, from, to string, token string) (stream io.ReadCloser, err error) {
+func ZFSSend(ctx context.Context, fs string, from, to string, token string, features StreamFeatures) (stream io.ReadCloser, err error) {
 
 	args := make([]string, 0)
 	args = append(args, "send")
 
-	sargs, err := buildCommonSendArgs(fs, from, to, token)
+	sargs, err := buildCommonSendArgs(fs, from, to, token, features)
 	if err != nil {
 		return nil, err
 	}
 	args = append(args, sargs...)
+	if err := checkSendArgs(sargs); err != nil {
+		return nil, err
+	}
 
-	stream, err = util.RunIOCommand(ctx, ZFS_BINARY, args...)
-
-	return
+	return runZFSIOCommand(ctx, args...)
 }
 
 
@@ -409,7 +411,7 @@ func (s *DrySendInfo) unmarshalInfoLine(l string) (regexMatched bool, err error)
 
 // from may be "", in which case a full ZFS send is done
 // May return BookmarkSizeEstimationNotSupported as err if from is a bookmark.
-func ZFSSendDry(fs string, from, to string, token string) (_ *DrySendInfo, err error) {
+func ZFSSendDry(fs string, from, to string, token string, features StreamFeatures) (_ *DrySendInfo, err error) {
 
 	if strings.Contains(from, "#") {
 		/* TODO:
@@ -436,13 +438,16 @@ func ZFSSendDry(fs string, from, to string, token string) (_ *DrySendInfo, err e
 
 	args := make([]string, 0)
 	args = append(args, "send", "-n", "-v", "-P")
-	sargs, err := buildCommonSendArgs(fs, from, to, token)
+	sargs, err := buildCommonSendArgs(fs, from, to, token, features)
 	if err != nil {
 		return nil, err
 	}
 	args = append(args, sargs...)
+	if err := checkSendArgs(sargs); err != nil {
+		return nil, err
+	}
 
-	cmd := exec.Command(ZFS_BINARY, args...)
+	cmd := zfsCmd(context.Background(), args...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return nil, err
@@ -455,20 +460,207 @@ func ZFSSendDry(fs string, from, to string, token string) (_ *DrySendInfo, err e
 }
 
 
+// ZFSSendDryIncrementals estimates the sizes of the incremental sends of fs from from to tos[0], from tos[0] to tos[1] and so on
+// with a single zfs send -n -I from tos[len(tos)-1], instead of one ZFSSendDry per send.
+// from and tos must be snapshots.
+// The estimate of a send includes the snapshots between its versions that are not in tos.
+func ZFSSendDryIncrementals(ctx context.Context, fs string, from string, tos []string, features StreamFeatures) ([]int64, error) {
+	if len(tos) == 0 {
+		return nil, nil
+	}
+	for _, v := range append([]string{from}, tos...) {
+		if !strings.HasPrefix(v, "@") {
+			return nil, fmt.Errorf("%q is not a snapshot", v)
+		}
+	}
+	fromV, err := absVersion(fs, from)
+	if err != nil {
+		return nil, err
+	}
+	absTos := make([]string, len(tos))
+	for i, to := range tos {
+		if absTos[i], err = absVersion(fs, to); err != nil {
+			return nil, err
+		}
+	}
+	sargs := append(features.SendArgs(), "-I", fromV, absTos[len(absTos)-1])
+	if err := checkSendArgs(sargs); err != nil {
+		return nil, err
+	}
+	args := append([]string{"send", "-n", "-v", "-P"}, sargs...)
+
+	output, err := zfsCmd(ctx, args...).CombinedOutput()
+	if err != nil {
+		return nil, err
+	}
+	return parseSendDryIncrementals(output, absTos)
+}
+
+// parseSendDryIncrementals returns the size estimates of the sends to tos from the output of zfs send -n -v -P -I,
+// which has one info line per snapshot in the range.
+func parseSendDryIncrementals(output []byte, tos []string) ([]int64, error) {
+	sizes := make([]int64, 0, len(tos))
+	var sum int64
+	for _, l := range strings.Split(string(output), "\n") {
+		var si DrySendInfo
+		matched, err := si.unmarshalInfoLine(l)
+		if err != nil {
+			return nil, fmt.Errorf("line %q: %s", l, err)
+		}
+		if !matched {
+			continue
+		}
+		if si.Type != DrySendTypeIncremental || len(sizes) == len(tos) {
+			return nil, fmt.Errorf("unexpected info line %q", l)
+		}
+		sum += si.SizeEstimate
+		if si.To == tos[len(sizes)] {
+			sizes = append(sizes, sum)
+			sum = 0
+		}
+	}
+	if len(sizes) != len(tos) {
+		return nil, fmt.Errorf("zfs send -n output has estimates for %d of %d snapshots", len(sizes), len(tos))
+	}
+	return sizes, nil
+}
+
+// RecvPassThroughFlags lists the zfs recv flags that users may configure per job.
+// Flags that change the semantics zrepl relies on (e.g. -F, -d, -e, -A) are deliberately not part of it.
+var RecvPassThroughFlags = map[string]string{
+	"-h": "do not receive holds",
+	"-u": "do not mount the received filesystem",
+	"-s": "save the state of an interrupted receive so that it can be resumed",
+}
+
+type RecvFlagNotAllowedError struct {
+	Flag string
+}
+
+func (e *RecvFlagNotAllowedError) Error() string {
+	return fmt.Sprintf("zfs recv flag %q is not allowed for pass-through", e.Flag)
+}
+
+// ValidateRecvPassThroughFlags returns a *RecvFlagNotAllowedError for the first flag
+// in flags that is not in RecvPassThroughFlags, or an error if a flag is specified twice.
+func ValidateRecvPassThroughFlags(flags []string) error {
+	seen := make(map[string]bool, len(flags))
+	for _, f := range flags {
+		if _, ok := RecvPassThroughFlags[f]; !ok {
+			return &RecvFlagNotAllowedError{f}
+		}
+		if seen[f] {
+			return fmt.Errorf("zfs recv flag %q specified more than once", f)
+		}
+		seen[f] = true
+	}
+	return nil
+}
+
+// RecvProperties are property overrides applied by zfs recv.
+type RecvProperties struct {
+	// zfs recv -o name=value
+	Override map[string]string
+	// zfs recv -x name
+	Inherit []string
+}
+
+var recvPropertyNameRegexp = regexp.MustCompile(`^[a-z0-9_.:-]+$`)
+
+// Validate checks that the property names are syntactically valid,
+// do not refer to properties managed by zrepl and are not both overridden and inherited.
+func (p RecvProperties) Validate() error {
+	check := func(name string) error {
+		if !recvPropertyNameRegexp.MatchString(name) {
+			return fmt.Errorf("invalid property name %q", name)
+		}
+		if name == ZREPL_PLACEHOLDER_PROPERTY_NAME {
+			return fmt.Errorf("property %q is managed by zrepl", name)
+		}
+		return nil
+	}
+	for name, value := range p.Override {
+		if err := check(name); err != nil {
+			return err
+		}
+		if strings.ContainsAny(value, "\n\x00") {
+			return fmt.Errorf("value of property %q must not contain newline or NUL characters", name)
+		}
+	}
+	for _, name := range p.Inherit {
+		if err := check(name); err != nil {
+			return err
+		}
+		if _, ok := p.Override[name]; ok {
+			return fmt.Errorf("property %q is both overridden and inherited", name)
+		}
+	}
+	return nil
+}
+
+// Merge returns the union of p and q, where q takes precedence over p for properties specified by both.
+func (p RecvProperties) Merge(q RecvProperties) RecvProperties {
+	inQ := make(map[string]bool, len(q.Override)+len(q.Inherit))
+	r := RecvProperties{Override: make(map[string]string, len(p.Override)+len(q.Override))}
+	for name, value := range q.Override {
+		r.Override[name] = value
+		inQ[name] = true
+	}
+	for _, name := range q.Inherit {
+		r.Inherit = append(r.Inherit, name)
+		inQ[name] = true
+	}
+	for name, value := range p.Override {
+		if !inQ[name] {
+			r.Override[name] = value
+		}
+	}
+	for _, name := range p.Inherit {
+		if !inQ[name] {
+			r.Inherit = append(r.Inherit, name)
+		}
+	}
+	return r
+}
+
+// Args returns the zfs recv arguments for p in a deterministic order.
+func (p RecvProperties) Args() []string {
+	names := make([]string, 0, len(p.Override))
+	for name := range p.Override {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	args := make([]string, 0, 2*(len(p.Override)+len(p.Inherit)))
+	for _, name := range names {
+		args = append(args, "-o", fmt.Sprintf("%s=%s", name, p.Override[name]))
+	}
+	for _, name := range p.Inherit {
+		args = append(args, "-x", name)
+	}
+	return args
+}
+
 func ZFSRecv(ctx context.Context, fs string, stream io.Reader, additionalArgs ...string) (err error) {
 
 	if err := validateZFSFilesystem(fs); err != nil {
 		return err
 	}
 
+	if delegated() {
+		additionalArgs = delegatedRecvArgs(additionalArgs)
+	}
+
 	args := make([]string, 0)
 	args = append(args, "recv")
 	if len(args) > 0 {
 		args = append(args, additionalArgs...)
 	}
 	args = append(args, fs)
+	if err := checkRecvArgs(additionalArgs); err != nil {
+		return err
+	}
 
-	cmd := exec.CommandContext(ctx, ZFS_BINARY, args...)
+	cmd := zfsCmd(ctx, args...)
 
 	stderr := bytes.NewBuffer(make([]byte, 0, 1024))
 	cmd.Stderr = stderr
@@ -512,7 +704,7 @@ func ZFSRecvClearResumeToken(fs string) (err error) {
 		return err
 	}
 
-	cmd := exec.Command(ZFS_BINARY, "recv", "-A", fs)
+	cmd := zfsCmd(context.Background(), "recv", "-A", fs)
 	o, err := cmd.CombinedOutput()
 	if err != nil {
 		if bytes.Contains(o, []byte("does not have any resumable receive state to abort")) {
@@ -539,6 +731,11 @@ func (p *ZFSProperties) Get(key string) string {
 	return p.m[key]
 }
 
+func (p *ZFSProperties) Lookup(key string) (value string, ok bool) {
+	value, ok = p.m[key]
+	return value, ok
+}
+
 func (p *ZFSProperties) appendArgs(args *[]string) (err error) {
 	for prop, val := range p.m {
 		if strings.Contains(prop, "=") {
@@ -562,7 +759,7 @@ func zfsSet(path string, props *ZFSProperties) (err error) {
 	}
 	args = append(args, path)
 
-	cmd := exec.Command(ZFS_BINARY, args...)
+	cmd := zfsCmd(context.Background(), args...)
 
 	stderr := bytes.NewBuffer(make([]byte, 0, 1024))
 	cmd.Stderr = stderr
@@ -585,6 +782,32 @@ func ZFSGet(fs *DatasetPath, props []string) (*ZFSProperties, error) {
 	return zfsGet(fs.ToString(), props, sourceAny)
 }
 
+// ZFSGetSetProperties returns those of props that are set on fs, locally or by zfs recv,
+// i.e. that are neither inherited nor at their default value.
+func ZFSGetSetProperties(fs *DatasetPath, props []string) (*ZFSProperties, error) {
+	return zfsGet(fs.ToString(), props, sourceLocal|sourceReceived)
+}
+
+// ZFSInherit clears the local value of prop on fs.
+// Properties that cannot be inherited, e.g. quota, are set to none instead.
+func ZFSInherit(fs *DatasetPath, prop string) error {
+	cmd := zfsCmd(context.Background(), "inherit", prop, fs.ToString())
+	stderr := bytes.NewBuffer(make([]byte, 0, 1024))
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if bytes.Contains(stderr.Bytes(), []byte("cannot be inherited")) {
+			props := NewZFSProperties()
+			props.Set(prop, "none")
+			return ZFSSet(fs, props)
+		}
+		return ZFSError{
+			Stderr:  stderr.Bytes(),
+			WaitErr: err,
+		}
+	}
+	return nil
+}
+
 var zfsGetDatasetDoesNotExistRegexp = regexp.MustCompile(`^cannot open '(\S+)': (dataset does not exist|no such pool or dataset)`)
 
 type DatasetDoesNotExist struct {
@@ -620,7 +843,7 @@ func (s zfsPropertySource) zfsGetSourceFieldPrefixes() []string {
 
 func zfsGet(path string, props []string, allowedSources zfsPropertySource) (*ZFSProperties, error) {
 	args := []string{"get", "-Hp", "-o", "property,value,source", strings.Join(props, ","), path}
-	cmd := exec.Command(ZFS_BINARY, args...)
+	cmd := zfsCmd(context.Background(), args...)
 	stdout, err := cmd.Output()
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
@@ -663,6 +886,11 @@ func zfsGet(path string, props []string, allowedSources zfsPropertySource) (*ZFS
 }
 
 func ZFSDestroy(dataset string) (err error) {
+	return ZFSDestroyContext(context.Background(), dataset)
+}
+
+// ZFSDestroyContext is like ZFSDestroy but kills `zfs destroy` once ctx is done.
+func ZFSDestroyContext(ctx context.Context, dataset string) (err error) {
 
 	var dstype, filesystem string
 	idx := strings.IndexAny(dataset, "@#")
@@ -679,7 +907,16 @@ func ZFSDestroy(dataset string) (err error) {
 
 	defer prometheus.NewTimer(prom.ZFSDestroyDuration.WithLabelValues(dstype, filesystem))
 
-	cmd := exec.Command(ZFS_BINARY, "destroy", dataset)
+	if lzcEnabled() {
+		switch dstype {
+		case "snapshot":
+			return runLZC("destroy", dataset, func() error { return lzcDestroySnapshots([]string{dataset}) })
+		case "bookmark":
+			return runLZC("destroy", dataset, func() error { return lzcDestroyBookmarks([]string{dataset}) })
+		}
+	}
+
+	cmd := zfsCmd(ctx, "destroy", dataset)
 
 	stderr := bytes.NewBuffer(make([]byte, 0, 1024))
 	cmd.Stderr = stderr
@@ -699,6 +936,107 @@ func ZFSDestroy(dataset string) (err error) {
 
 }
 
+func delegated() bool {
+	execState.mtx.RLock()
+	defer execState.mtx.RUnlock()
+	return execState.config.Delegated
+}
+
+// delegatedRecvArgs adds -u to the zfs recv flags args if it is missing.
+func delegatedRecvArgs(args []string) []string {
+	for _, a := range args {
+		if a == "-u" {
+			return args
+		}
+	}
+	return append(append([]string{}, args...), "-u")
+}
+
+func destroyBatchSize() int {
+	execState.mtx.RLock()
+	defer execState.mtx.RUnlock()
+	return execState.config.DestroyBatchSize
+}
+
+// ZFSDestroySnapshots destroys the snapshots names (without fs@) of fs and returns an error for each of them, nil if it was destroyed.
+//
+// Batches of up to ExecConfig.DestroyBatchSize snapshots are destroyed with a single zfs destroy fs@a,b,c,
+// which destroys either all snapshots of the batch or none.
+// Hence, if a batch fails, e.g. because one of its snapshots is held, its snapshots are destroyed one at a time,
+// so that the failure is attributed to the snapshot that caused it.
+// Snapshots of a successful batch that did not exist are not reported as errors.
+//
+// progress, if not nil, is called with the number of snapshots processed so far after each batch.
+// Once ctx is done, the running zfs destroy is killed and the remaining snapshots fail with ctx.Err().
+func ZFSDestroySnapshots(ctx context.Context, fs *DatasetPath, names []string, progress func(destroyed int)) []error {
+	errs := make([]error, len(names))
+	batchSize := destroyBatchSize()
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	for _, b := range destroyBatches(len(names), batchSize) {
+		batch := names[b.start:b.end]
+		// the error of a failed batch is not attributable to one of its snapshots, the destroys one at a time tell
+		batchDestroyed := len(batch) > 1 && zfsDestroySnapshotBatch(ctx, fs, batch) == nil
+		if !batchDestroyed {
+			for i, name := range batch {
+				if ctx.Err() != nil {
+					errs[b.start+i] = ctx.Err()
+					continue
+				}
+				errs[b.start+i] = ZFSDestroyContext(ctx, zfsBuildSnapName(fs, name))
+			}
+		}
+		if progress != nil {
+			progress(b.end)
+		}
+	}
+	return errs
+}
+
+type destroyBatch struct{ start, end int }
+
+// destroyBatches splits n snapshots into batches of at most size snapshots.
+func destroyBatches(n, size int) []destroyBatch {
+	batches := make([]destroyBatch, 0, (n+size-1)/size)
+	for start := 0; start < n; start += size {
+		end := start + size
+		if end > n {
+			end = n
+		}
+		batches = append(batches, destroyBatch{start, end})
+	}
+	return batches
+}
+
+func zfsDestroySnapshotBatch(ctx context.Context, fs *DatasetPath, names []string) error {
+	defer prometheus.NewTimer(prom.ZFSDestroyDuration.WithLabelValues("snapshot", fs.ToString())).ObserveDuration()
+
+	if lzcEnabled() {
+		snaps := make([]string, len(names))
+		for i, name := range names {
+			snaps[i] = zfsBuildSnapName(fs, name)
+		}
+		return runLZC("destroy", zfsBuildSnapName(fs, strings.Join(names, ",")), func() error {
+			return lzcDestroySnapshots(snaps)
+		})
+	}
+
+	cmd := zfsCmd(ctx, "destroy", zfsBuildSnapName(fs, strings.Join(names, ",")))
+	stderr := bytes.NewBuffer(make([]byte, 0, 1024))
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := cmd.Wait(); err != nil {
+		return ZFSError{
+			Stderr:  stderr.Bytes(),
+			WaitErr: err,
+		}
+	}
+	return nil
+}
+
 func zfsBuildSnapName(fs *DatasetPath, name string) string { // TODO defensive
 	return fmt.Sprintf("%s@%s", fs.ToString(), name)
 }
@@ -712,8 +1050,60 @@ func ZFSSnapshot(fs *DatasetPath, name string, recursive bool) (err error) {
 	promTimer := prometheus.NewTimer(prom.ZFSSnapshotDuration.WithLabelValues(fs.ToString()))
 	defer promTimer.ObserveDuration()
 
-	snapname := zfsBuildSnapName(fs, name)
-	cmd := exec.Command(ZFS_BINARY, "snapshot", snapname)
+	return zfsSnapshot([]string{zfsBuildSnapName(fs, name)})
+}
+
+// ZFSSnapshotAtomic creates the snapshot fs@name of every filesystem in fss with one zfs snapshot per pool,
+// which creates all snapshots of a pool atomically, i.e. all or none of them.
+// ZFS cannot create snapshots in different pools atomically.
+// The returned map has the error of each filesystem (by name) whose snapshot could not be created.
+func ZFSSnapshotAtomic(fss []*DatasetPath, name string) map[string]error {
+	errs := make(map[string]error)
+	for _, pool := range GroupByPool(fss) {
+		begin := time.Now()
+		snapnames := make([]string, len(pool))
+		for i, fs := range pool {
+			snapnames[i] = zfsBuildSnapName(fs, name)
+		}
+		err := zfsSnapshot(snapnames)
+		for _, fs := range pool {
+			prom.ZFSSnapshotDuration.WithLabelValues(fs.ToString()).Observe(time.Since(begin).Seconds())
+			if err != nil {
+				errs[fs.ToString()] = err
+			}
+		}
+	}
+	return errs
+}
+
+// GroupByPool groups fss by their pool, in the order of their first occurrence.
+func GroupByPool(fss []*DatasetPath) [][]*DatasetPath {
+	var pools [][]*DatasetPath
+	idx := make(map[string]int)
+	for _, fs := range fss {
+		if fs.Empty() {
+			continue
+		}
+		pool := fs.comps[0]
+		i, ok := idx[pool]
+		if !ok {
+			i = len(pools)
+			idx[pool] = i
+			pools = append(pools, nil)
+		}
+		pools[i] = append(pools[i], fs)
+	}
+	return pools
+}
+
+// zfsSnapshot atomically creates snapnames, which must all be in the same pool.
+func zfsSnapshot(snapnames []string) (err error) {
+	if lzcEnabled() {
+		return runLZC("snapshot", strings.Join(snapnames, " "), func() error { return lzcSnapshot(snapnames) })
+	}
+
+	args := append([]string{"snapshot"}, snapnames...)
+	cmd := zfsCmd(context.Background(), args...)
 
 	stderr := bytes.NewBuffer(make([]byte, 0, 1024))
 	cmd.Stderr = stderr
@@ -741,7 +1131,36 @@ func ZFSBookmark(fs *DatasetPath, snapshot, bookmark string) (err error) {
 	snapname := zfsBuildSnapName(fs, snapshot)
 	bookmarkname := zfsBuildBookmarkName(fs, bookmark)
 
-	cmd := exec.Command(ZFS_BINARY, "bookmark", snapname, bookmarkname)
+	if lzcEnabled() {
+		return runLZC("bookmark", bookmarkname, func() error {
+			return lzcBookmark(map[string]string{bookmarkname: snapname})
+		})
+	}
+
+	cmd := zfsCmd(context.Background(), "bookmark", snapname, bookmarkname)
+
+	stderr := bytes.NewBuffer(make([]byte, 0, 1024))
+	cmd.Stderr = stderr
+
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	if err = cmd.Wait(); err != nil {
+		err = ZFSError{
+			Stderr:  stderr.Bytes(),
+			WaitErr: err,
+		}
+	}
+
+	return
+
+}
+
+// ZFSRollback rolls fs back to fs@snapshot, destroying all more recent snapshots and bookmarks of fs.
+func ZFSRollback(fs *DatasetPath, snapshot string) (err error) {
+
+	cmd := zfsCmd(context.Background(), "rollback", "-r", zfsBuildSnapName(fs, snapshot))
 
 	stderr := bytes.NewBuffer(make([]byte, 0, 1024))
 	cmd.Stderr = stderr
@@ -758,5 +1177,26 @@ func ZFSBookmark(fs *DatasetPath, snapshot, bookmark string) (err error) {
 	}
 
 	return
+}
+
+// ZFSRename renames the filesystem from to to, along with its snapshots and children.
+func ZFSRename(from, to *DatasetPath) (err error) {
+
+	cmd := zfsCmd(context.Background(), "rename", from.ToString(), to.ToString())
+
+	stderr := bytes.NewBuffer(make([]byte, 0, 1024))
+	cmd.Stderr = stderr
 
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	if err = cmd.Wait(); err != nil {
+		err = ZFSError{
+			Stderr:  stderr.Bytes(),
+			WaitErr: err,
+		}
+	}
+
+	return
 }
@@ -1,7 +1,13 @@
 package zfs
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -70,6 +76,29 @@ func TestZFSPropertySource(t *testing.T) {
 
 }
 
+func TestParseSendDryIncrementals(t *testing.T) {
+	// $ zfs send -nvP -I zroot/test/a@1 zroot/test/a@4
+	output := []byte(`incremental	1	zroot/test/a@2	1000
+incremental	2	zroot/test/a@3	200
+incremental	3	zroot/test/a@4	30
+size	1230
+`)
+	// zroot/test/a@3 is not a step of its own, it is part of the send to @4
+	sizes, err := parseSendDryIncrementals(output, []string{"zroot/test/a@2", "zroot/test/a@4"})
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1000, 230}, sizes)
+
+	_, err = parseSendDryIncrementals(output, []string{"zroot/test/a@2", "zroot/test/a@5"})
+	assert.Error(t, err, "an estimate for every step is required")
+	_, err = parseSendDryIncrementals([]byte("full\tzroot/test/a@1\t5389768\n"), []string{"zroot/test/a@1"})
+	assert.Error(t, err)
+}
+
+func TestZFSSendDryIncrementalsRequiresSnapshots(t *testing.T) {
+	_, err := ZFSSendDryIncrementals(context.Background(), "zroot/test/a", "#1", []string{"@2"}, StreamFeatures{})
+	assert.Error(t, err, "zfs send -I does not accept bookmarks")
+}
+
 func TestDrySendInfo(t *testing.T) {
 
 	// # full send
@@ -206,3 +235,160 @@ size	10518512
 		})
 	}
 }
+
+func TestValidateRecvPassThroughFlags(t *testing.T) {
+	assert.NoError(t, ValidateRecvPassThroughFlags(nil))
+	assert.NoError(t, ValidateRecvPassThroughFlags([]string{"-h", "-u"}))
+
+	err := ValidateRecvPassThroughFlags([]string{"-h", "-F"})
+	assert.IsType(t, &RecvFlagNotAllowedError{}, err)
+	assert.Equal(t, "-F", err.(*RecvFlagNotAllowedError).Flag)
+
+	assert.Error(t, ValidateRecvPassThroughFlags([]string{"-h", "-h"}))
+}
+
+func TestParseZPoolScanActivity(t *testing.T) {
+	status := `  pool: backup
+ state: DEGRADED
+status: One or more devices is currently being resilvered.
+  scan: resilver in progress since Tue Oct 16 10:00:01 2018
+	1.21T scanned out of 3.40T at 301M/s, 2h7m to go
+config:
+`
+	assert.Equal(t, "resilver", parseZPoolScanActivity([]byte(status)))
+	assert.Equal(t, "scrub", parseZPoolScanActivity([]byte("  pool: backup\n  scan: scrub in progress since Tue Oct 16 10:00:01 2018\n")))
+	assert.Equal(t, "", parseZPoolScanActivity([]byte("  pool: backup\n  scan: scrub repaired 0B in 2h with 0 errors on Sun Oct 14 02:00:01 2018\n")))
+	assert.Equal(t, "", parseZPoolScanActivity([]byte("  pool: backup\n  scan: none requested\n")))
+}
+
+func TestParseZPoolSpace(t *testing.T) {
+	size, allocated, err := parseZPoolSpace([]byte("1000204886016\n870178250752\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000204886016), size)
+	assert.Equal(t, int64(870178250752), allocated)
+	_, _, err = parseZPoolSpace([]byte("87%\n"))
+	assert.Error(t, err)
+	_, _, err = parseZPoolSpace([]byte("-\n-\n"))
+	assert.Error(t, err)
+	_, _, err = parseZPoolSpace([]byte("0\n0\n"))
+	assert.Error(t, err)
+}
+
+func TestGroupByPool(t *testing.T) {
+	fss := []*DatasetPath{
+		toDatasetPath("tank/a"),
+		toDatasetPath("backup/x"),
+		toDatasetPath("tank"),
+		toDatasetPath("tank/a/b"),
+		toDatasetPath("backup/y"),
+	}
+	pools := GroupByPool(fss)
+	if assert.Len(t, pools, 2) {
+		assert.Equal(t, []*DatasetPath{fss[0], fss[2], fss[3]}, pools[0])
+		assert.Equal(t, []*DatasetPath{fss[1], fss[4]}, pools[1])
+	}
+}
+
+func TestDestroyBatches(t *testing.T) {
+	assert.Empty(t, destroyBatches(0, 3))
+	assert.Equal(t, []destroyBatch{{0, 2}}, destroyBatches(2, 3))
+	assert.Equal(t, []destroyBatch{{0, 3}, {3, 6}, {6, 7}}, destroyBatches(7, 3))
+	assert.Equal(t, []destroyBatch{{0, 1}, {1, 2}}, destroyBatches(2, 1))
+}
+
+func TestZFSDestroySnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrepl-destroy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	log := filepath.Join(dir, "log")
+	require.NoError(t, os.Setenv("ZREPL_TEST_DESTROY_LOG", log))
+	defer os.Unsetenv("ZREPL_TEST_DESTROY_LOG")
+	prevBinary := ZFS_BINARY
+	defer func() { ZFS_BINARY = prevBinary }()
+	ZFS_BINARY = "./test_helpers/zfs_destroyer.sh"
+	require.NoError(t, SetExecConfig(ExecConfig{DestroyBatchSize: 2}))
+	defer SetExecConfig(ExecConfig{})
+
+	fs := toDatasetPath("pool/fs")
+	var progress []int
+	errs := ZFSDestroySnapshots(context.Background(), fs, []string{"a", "held", "b", "c", "d"}, func(destroyed int) {
+		progress = append(progress, destroyed)
+	})
+	require.Len(t, errs, 5)
+	for _, i := range []int{0, 2, 3, 4} {
+		assert.NoError(t, errs[i], "snapshot %d", i)
+	}
+	// only the snapshot that failed its batch is reported, the other one of the batch was destroyed individually
+	require.Error(t, errs[1])
+	assert.Contains(t, string(errs[1].(ZFSError).Stderr), "pool/fs@held")
+	assert.Equal(t, []int{2, 4, 5}, progress)
+
+	out, err := ioutil.ReadFile(log)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"pool/fs@a,held", "pool/fs@a", "pool/fs@held", "pool/fs@b,c", "pool/fs@d",
+	}, strings.Split(strings.TrimSpace(string(out)), "\n"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	errs = ZFSDestroySnapshots(ctx, fs, []string{"a", "b", "c"}, nil)
+	for _, err := range errs {
+		assert.Equal(t, context.Canceled, err)
+	}
+}
+
+func TestDelegatedRecvArgs(t *testing.T) {
+	assert.Equal(t, []string{"-u"}, delegatedRecvArgs(nil))
+	in := []string{"-s", "-o", "mountpoint=none"}
+	assert.Equal(t, []string{"-s", "-o", "mountpoint=none", "-u"}, delegatedRecvArgs(in))
+	assert.Equal(t, []string{"-s", "-o", "mountpoint=none"}, in, "must not modify its argument")
+	assert.Equal(t, []string{"-u", "-s"}, delegatedRecvArgs([]string{"-u", "-s"}))
+}
+
+func TestEncryptionEnabled(t *testing.T) {
+	assert.False(t, encryptionEnabled("off"))
+	assert.False(t, encryptionEnabled("-"), "value on ZFS versions without native encryption")
+	assert.False(t, encryptionEnabled(""))
+	assert.True(t, encryptionEnabled("on"))
+	assert.True(t, encryptionEnabled("aes-256-gcm"))
+}
+
+func TestBuildCommonSendArgsRaw(t *testing.T) {
+	args, err := buildCommonSendArgs("pool/fs", "@a", "@b", "", StreamFeatures{Raw: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"-w", "-i", "pool/fs@a", "pool/fs@b"}, args)
+
+	args, err = buildCommonSendArgs("pool/fs", "", "@b", "", StreamFeatures{Raw: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"-w", "pool/fs@b"}, args)
+
+	args, err = buildCommonSendArgs("pool/fs", "", "@b", "", StreamFeatures{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pool/fs@b"}, args)
+
+	// the resume token encodes the flags of the interrupted send
+	args, err = buildCommonSendArgs("pool/fs", "@a", "@b", "1-abc", StreamFeatures{Raw: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"-t", "1-abc"}, args)
+}
+
+func TestStreamFeaturesSendArgs(t *testing.T) {
+	assert.Empty(t, StreamFeatures{}.SendArgs())
+	assert.Equal(t, []string{"-c", "-e"}, StreamFeatures{Compressed: true, EmbeddedData: true}.SendArgs())
+	all := StreamFeatures{Raw: true, Compressed: true, LargeBlocks: true, EmbeddedData: true}
+	assert.Equal(t, []string{"-w", "-c", "-L", "-e"}, all.SendArgs())
+
+	args, err := buildCommonSendArgs("pool/fs", "@a", "@b", "", StreamFeatures{LargeBlocks: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"-L", "-i", "pool/fs@a", "pool/fs@b"}, args)
+}
+
+func TestParseZPoolFeatureValue(t *testing.T) {
+	for out, enabled := range map[string]bool{"active\n": true, "enabled\n": true, "disabled\n": false, "-\n": false} {
+		v, err := parseZPoolFeatureValue("large_blocks", []byte(out))
+		assert.NoError(t, err)
+		assert.Equal(t, enabled, v, "%q", out)
+	}
+	_, err := parseZPoolFeatureValue("large_blocks", []byte("bogus\n"))
+	assert.Error(t, err)
+}